@@ -2,16 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/adapters/events"
+	"github.com/stoik/email-security/internal/adapters/hunt"
+	"github.com/stoik/email-security/internal/adapters/kms"
+	"github.com/stoik/email-security/internal/adapters/notifiers"
+	"github.com/stoik/email-security/internal/adapters/oauth"
+	"github.com/stoik/email-security/internal/adapters/onboarding"
 	"github.com/stoik/email-security/internal/adapters/providers"
+	"github.com/stoik/email-security/internal/adapters/receiver"
 	"github.com/stoik/email-security/internal/adapters/storage"
 	"github.com/stoik/email-security/internal/application"
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/calibration"
+	"github.com/stoik/email-security/internal/domain/classifier"
 	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/domain/resilience"
 	"github.com/stoik/email-security/internal/ports"
 )
 
@@ -41,50 +57,328 @@ func main() {
 	log.Println("Database schema initialized")
 
 	// Initialize fraud detector (domain logic)
-	detector := detection.NewDetector(internalDomains, trustedDomains)
+	// The text classifier is optional: it is only enabled when a trained model is provided
+	detectorOpts := make([]detection.Option, 0)
+	if modelPath := os.Getenv("CLASSIFIER_MODEL_PATH"); modelPath != "" {
+		model, err := loadClassifier(modelPath)
+		if err != nil {
+			log.Fatalf("Failed to load classifier model: %v", err)
+		}
+		detectorOpts = append(detectorOpts, detection.WithStrategies(
+			detection.NewClassifierStrategy(model, classifierThreshold),
+		))
+		log.Printf("Loaded text classifier model %s (%d terms)", model.Version, len(model.Terms))
+	}
+	// Calibration maps fitted by cmd/evaluate turn confidences into fraud probabilities
+	// They are fitted on one aggregator's scores and only apply to tenants using it.
+	var calibrationSet *calibration.Set
+	if calibrationPath := os.Getenv("DETECTOR_CALIBRATION_PATH"); calibrationPath != "" {
+		set, err := loadCalibration(calibrationPath)
+		if err != nil {
+			log.Fatalf("Failed to load calibration: %v", err)
+		}
+		calibrationSet = set
+		detectorOpts = append(detectorOpts, detection.WithCalibration(set))
+		log.Printf("Loaded detector calibration %s for aggregator %s (%d detection types)", set.Version, set.Aggregator, len(set.Detections))
+	}
+	// Trace mode persists what every strategy saw, to explain missed detections
+	if getEnv("DETECTION_TRACE", "false") == "true" {
+		detectorOpts = append(detectorOpts, detection.WithTracing())
+		log.Println("Detection trace mode enabled")
+	}
+	detector := detection.NewDetector(internalDomains, trustedDomains, detectorOpts...)
+
+	// Risk aggregators selectable per tenant (tenant.RiskAggregator)
+	// The learned logistic combiner is only available when a fitted model is provided
+	aggregators := detection.StandardAggregators()
+	if combinerPath := os.Getenv("RISK_COMBINER_PATH"); combinerPath != "" {
+		combiner, err := loadCombiner(combinerPath)
+		if err != nil {
+			log.Fatalf("Failed to load risk combiner: %v", err)
+		}
+		aggregators[combiner.Name()] = combiner
+		log.Printf("Loaded logistic risk combiner %s", combiner.Version)
+	}
+	if calibrationSet != nil {
+		if _, ok := aggregators[calibrationSet.Aggregator]; !ok {
+			log.Fatalf("Calibration %s was fitted on unknown aggregator %q", calibrationSet.Version, calibrationSet.Aggregator)
+		}
+		if !calibrationSet.Fits(detector.AggregatorName()) {
+			log.Printf("Calibration %s does not apply to the default aggregator %s", calibrationSet.Version, detector.AggregatorName())
+		}
+	}
+
+	// Tenant secrets are sealed with per-tenant data keys wrapped by a master key:
+	// MASTER_KEY (base64, 32 bytes) or the local key file KMS_KEY_FILE, created on
+	// first run. In production, a cloud KMS implements ports.KeyManager.
+	keyManager, err := loadKeyManager()
+	if err != nil {
+		log.Fatalf("Failed to load master keys: %v", err)
+	}
+	secrets := application.NewSecretService(store, keyManager)
+
+	// Tenant OAuth credentials: access tokens are exchanged, cached and refreshed per
+	// tenant. OAUTH_TOKEN_URL points our provider apps at a token endpoint; by default
+	// a local fake endpoint is served.
+	tokenClient := oauth.NewTokenClient(10 * time.Second)
+	credentials := application.NewCredentialService(store, tokenClient, secrets, application.CredentialConfig{})
+	tokenURL := os.Getenv("OAUTH_TOKEN_URL")
+	if tokenURL == "" {
+		tokenURL = serveFakeTokens()
+	}
 
 	// Initialize provider adapters (driving port implementations)
 	// This map enables dynamic provider selection based on tenant.Provider
 	// without reflection or switch statements
+	// Clients are wrapped with per-tenant rate limits matching the provider quotas,
+	// retries of throttled calls and a circuit breaker
 	providerMap := map[domain.Provider]ports.EmailProvider{
-		domain.ProviderMicrosoft: providers.NewMicrosoftClient(),
-		domain.ProviderGoogle:    providers.NewGoogleClient(),
+		domain.ProviderMicrosoft: providers.NewResilientProvider(providers.NewMicrosoftClient(credentials), resilience.Config{Rate: resilience.GraphRate}),
+		domain.ProviderGoogle:    providers.NewResilientProvider(providers.NewGoogleClient(credentials), resilience.Config{Rate: resilience.GmailRate}),
 	}
 
 	// Initialize application service (dependency injection via constructor)
 	// This is the hexagonal architecture pattern: outer layers (main) wire up
 	// dependencies and inject them into inner layers (application service)
-	service := application.NewFraudDetectionService(store, detector, providerMap)
+	service := application.NewFraudDetectionService(store, detector, providerMap, aggregators)
+
+	// Push ingestion: provider notifications bring new mail in as it arrives. Polling
+	// covers the initial backfill (IngestEmailsForTenant) and lapsed subscriptions.
+	pushIngestion := application.NewPushIngestionService(store, providerMap, map[domain.Provider]ports.MailSubscriber{
+		domain.ProviderMicrosoft: providers.NewMicrosoftSubscriber(getEnv("PUSH_BASE_URL", "https://localhost:8443") + receiver.GraphPath),
+		domain.ProviderGoogle:    providers.NewGoogleSubscriber(getEnv("GMAIL_PUSH_TOPIC", "projects/email-security/topics/gmail-push")),
+	}, application.PushConfig{})
 
-	// Create sample tenants for demonstration
-	// In production, tenants would be managed via admin API
+	// Tenant lifecycle: tenants are created pending consent and activated by the
+	// provider consent callback, which stores their credentials and syncs their
+	// directory. Consent states are signed with CONSENT_STATE_SECRET, which every
+	// process answering callbacks must share.
+	lifecycle := application.NewOnboardingService(store, credentials, tokenClient, service, pushIngestion, application.OnboardingConfig{
+		StateSecret: consentStateSecret(),
+		RedirectURL: getEnv("CONSENT_REDIRECT_URL", "https://localhost:8443"+onboarding.CallbackPath),
+		Apps: map[domain.Provider]domain.OAuthApp{
+			domain.ProviderMicrosoft: {
+				ClientID:     getEnv("MICROSOFT_CLIENT_ID", "email-security-graph-app"),
+				ClientSecret: getEnv("MICROSOFT_CLIENT_SECRET", "graph-client-secret"),
+				TokenURL:     tokenURL,
+			},
+			domain.ProviderGoogle: {
+				ClientID:     getEnv("GOOGLE_CLIENT_ID", "email-security-gmail-app"),
+				ClientSecret: getEnv("GOOGLE_CLIENT_SECRET", "gmail-client-secret"),
+				TokenURL:     tokenURL,
+			},
+		},
+	})
+	// ONBOARDING_LISTEN_ADDR serves the onboarding API for the duration of the run,
+	// authenticated with ONBOARDING_ADMIN_TOKEN
+	if addr := os.Getenv("ONBOARDING_LISTEN_ADDR"); addr != "" {
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           onboarding.New(lifecycle, onboarding.Config{AdminToken: os.Getenv("ONBOARDING_ADMIN_TOKEN")}),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Onboarding API stopped: %v", err)
+			}
+		}()
+		defer server.Close()
+		log.Printf("Onboarding API listening on %s", addr)
+	}
+	// HUNT_LISTEN_ADDR serves the retro-hunt API (GET /hunt) for the duration of the
+	// run, authenticated with HUNT_ADMIN_TOKEN; cmd/hunt runs the same hunts from a shell
+	if addr := os.Getenv("HUNT_LISTEN_ADDR"); addr != "" {
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           hunt.New(application.NewHuntService(store), hunt.Config{AdminToken: os.Getenv("HUNT_ADMIN_TOKEN")}),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Hunt API stopped: %v", err)
+			}
+		}()
+		defer server.Close()
+		log.Printf("Hunt API listening on %s", addr)
+	}
+
+	// Outbox relay: analysis results reach consumers through sinks, never straight from
+	// the detection loop. The in-process bus hosts local consumers; EVENT_SINK_URL adds
+	// an HTTP endpoint. A broker sink (events.NewBrokerSink) plugs in the same way
+	// once a NATS/Kafka client is configured.
+	bus := events.NewBus()
+	bus.Subscribe("threat-log", domain.EventThreatDetected, func(ctx context.Context, event domain.Event) error {
+		log.Printf("📣 %s for email %s (%s)", event.Type, event.EmailID, event.DedupKey)
+		return nil
+	})
+	// Security team alerts: analyses reach Slack, Teams or email through tenant routes
+	channels := []ports.Notifier{
+		notifiers.NewSlackNotifier(10 * time.Second),
+		notifiers.NewTeamsNotifier(10 * time.Second),
+	}
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		channels = append(channels, notifiers.NewSMTPNotifier(notifiers.SMTPConfig{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getEnv("SMTP_FROM", "Email Security <alerts@example.com>"),
+		}))
+	}
+	alerts := application.NewAlertService(store, channels, secrets, application.AlertConfig{})
+	bus.Subscribe("alerts", domain.EventAnalysisCompleted, alerts.HandleEvent)
+
+	// Automated remediation: tenant policies act on messages in recipients' mailboxes
+	remediation := application.NewRemediationService(store, map[domain.Provider]ports.MailboxRemediator{
+		domain.ProviderMicrosoft: providers.NewMicrosoftRemediator(),
+		domain.ProviderGoogle:    providers.NewGoogleRemediator(),
+	})
+	bus.Subscribe("remediation", domain.EventAnalysisCompleted, remediation.HandleEvent)
+
+	// Customer webhooks: matching events become signed deliveries, sent after the relay
+	webhooks := application.NewWebhookService(store, events.NewWebhookSender(10*time.Second), secrets, application.WebhookConfig{})
+	sinks := []ports.EventSink{bus, webhooks}
+	if sinkURL := os.Getenv("EVENT_SINK_URL"); sinkURL != "" {
+		sinks = append(sinks, events.NewHTTPSink(sinkURL, 10*time.Second))
+	}
+	relay := application.NewOutboxRelay(store, sinks, application.RelayConfig{})
+
+	// Onboard sample tenants for demonstration
+	// In production, tenants are onboarded through the onboarding API and their
+	// administrators grant consent in the browser: the consent callback is simulated.
 	ctx := context.Background()
 	tenants := []*domain.Tenant{
 		{
-			ID:          uuid.New(),
-			Name:        "Acme Insurance Co.",
-			Provider:    domain.ProviderMicrosoft,
-			Credentials: "encrypted_oauth_token_here", // In production: use Vault/Secrets Manager
-			Status:      "active",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Name:     "Acme Insurance Co.",
+			Provider: domain.ProviderMicrosoft,
+			// Corroborating signals (typosquatting + reply-to + auth failures) add up
+			RiskAggregator: detection.AggregatorNoisyOR,
 		},
 		{
-			ID:          uuid.New(),
-			Name:        "Beta Corp.",
-			Provider:    domain.ProviderGoogle,
-			Credentials: "encrypted_oauth_token_here",
-			Status:      "active",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Name:     "Beta Corp.",
+			Provider: domain.ProviderGoogle,
 		},
 	}
+	for i, tenant := range tenants {
+		consentURL, err := lifecycle.StartOnboarding(ctx, tenant)
+		if err != nil {
+			log.Fatalf("Failed to onboard tenant %s: %v", tenant.Name, err)
+		}
+		log.Printf("Created tenant: %s (%s), consent at %s", tenant.Name, tenant.Provider, consentURL)
+		activated, err := simulateConsent(ctx, lifecycle, tenant, consentURL)
+		if err != nil {
+			log.Printf("Consent failed for tenant %s: %v", tenant.Name, err)
+			continue
+		}
+		tenants[i] = activated
+	}
 
-	for _, tenant := range tenants {
-		if err := store.CreateTenant(ctx, tenant); err != nil {
-			log.Printf("Tenant creation skipped (may already exist): %v", err)
+	// ROTATE_MASTER_KEY=true rotates the master key and re-wraps every tenant data key
+	if os.Getenv("ROTATE_MASTER_KEY") == "true" {
+		rotateMasterKey(ctx, keyManager, secrets)
+	}
+
+	// Sample custom detection rule, as an analyst would create it at runtime
+	// In production, rules would be managed via admin API
+	invoiceRule := &domain.DetectionRule{
+		TenantID:      tenants[0].ID,
+		Name:          "External invoice to finance",
+		Expression:    `sender.domain not in internal_domains and subject matches "(?i)(facture|invoice)" and recipient.functions contains "finance"`,
+		DetectionType: "CUSTOM_EXTERNAL_INVOICE",
+		Confidence:    0.60,
+		Enabled:       true,
+	}
+	if err := service.CreateDetectionRule(ctx, invoiceRule); err != nil {
+		log.Printf("Detection rule creation skipped (may already exist): %v", err)
+	}
+
+	// Sample role override: the board advisor's title says nothing, tag them as a VIP
+	// In production, overrides would be managed via admin API
+	advisor := &domain.RoleOverride{
+		TenantID: tenants[0].ID,
+		Email:    "board.advisor@example.com",
+		VIP:      true,
+		Reason:   "Board advisor, handles M&A",
+	}
+	if err := service.SetRoleOverride(ctx, advisor); err != nil {
+		log.Printf("Role override creation skipped: %v", err)
+	}
+
+	// Sample VIP: the CEO's name must never appear on external mail, and mail to the
+	// CEO is graded with stricter thresholds
+	// In production, the VIP list would be managed via admin API
+	ceo := &domain.VIP{
+		TenantID: tenants[0].ID,
+		Email:    "john.smith@company.com",
+		Name:     "John Smith",
+		Reason:   "CEO, approves wire transfers",
+	}
+	if err := service.SetVIP(ctx, ceo); err != nil {
+		log.Printf("VIP creation skipped: %v", err)
+	}
+	vipThresholds := &domain.RiskThresholds{Critical: 0.75, High: 0.60, Medium: 0.40, Low: 0.20}
+	if err := service.SetVIPRiskThresholds(ctx, tenants[0].ID, vipThresholds); err != nil {
+		log.Printf("VIP risk thresholds skipped: %v", err)
+	}
+
+	// Sample sender list entry: a known-bad domain is flagged whatever the strategies find
+	// In production, entries would be managed via admin API
+	blocked := &domain.SenderListEntry{
+		TenantID:  tenants[0].ID,
+		Action:    domain.SenderBlock,
+		MatchType: domain.SenderMatchRegistrableDomain,
+		Value:     "external-domain.com",
+		Reason:    "Reported CEO fraud campaign",
+		CreatedBy: "soc@company.com",
+	}
+	if err := service.CreateSenderListEntry(ctx, blocked); err != nil {
+		log.Printf("Sender list entry creation skipped (may already exist): %v", err)
+	}
+
+	// Sample alert route: SLACK_WEBHOOK_URL receives the first tenant's high-risk alerts
+	// right away and a digest of the medium ones
+	// In production, routes would be managed via admin API
+	if slackURL := os.Getenv("SLACK_WEBHOOK_URL"); slackURL != "" {
+		route := &domain.NotificationRoute{
+			TenantID:     tenants[0].ID,
+			Name:         "Security team",
+			Channel:      domain.ChannelSlack,
+			Target:       slackURL,
+			MinRiskLevel: "medium",
+			DigestBelow:  "high",
+			Enabled:      true,
+		}
+		if err := alerts.CreateNotificationRoute(ctx, route); err != nil {
+			log.Printf("Notification route creation skipped (may already exist): %v", err)
+		}
+	}
+
+	// Sample remediation policies: the first tenant's critical emails are bannered and
+	// quarantined, high-risk ones bannered and labeled
+	// In production, policies would be managed via admin API
+	policies := []*domain.RemediationPolicy{
+		{TenantID: tenants[0].ID, RiskLevel: "critical", Actions: []string{domain.RemediationBanner, domain.RemediationQuarantine}, Enabled: true},
+		{TenantID: tenants[0].ID, RiskLevel: "high", Actions: []string{domain.RemediationBanner, domain.RemediationLabel}, Label: "Suspicious", Enabled: true},
+	}
+	for _, policy := range policies {
+		if err := remediation.SetRemediationPolicy(ctx, policy); err != nil {
+			log.Printf("Remediation policy creation failed: %v", err)
+		}
+	}
+
+	// Sample webhook subscription: WEBHOOK_URL receives the first tenant's threats
+	// In production, subscriptions would be managed via admin API
+	if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+		sub := &domain.WebhookSubscription{
+			TenantID:     tenants[0].ID,
+			URL:          webhookURL,
+			EventTypes:   []string{domain.EventThreatDetected},
+			MinRiskLevel: "high",
+			Enabled:      true,
+		}
+		if err := webhooks.CreateWebhookSubscription(ctx, sub); err != nil {
+			log.Printf("Webhook subscription skipped: %v", err)
 		} else {
-			log.Printf("Created tenant: %s (%s)", tenant.Name, tenant.Provider)
+			log.Printf("Created webhook subscription %s", sub.ID)
 		}
 	}
 
@@ -94,16 +388,105 @@ func main() {
 	log.Println("Starting email ingestion and detection loop...")
 
 	// Phase 1: Ingestion
+	// A throttled tenant or one with an open circuit is deferred to the next run;
+	// other tenants are not held back.
 	for _, tenant := range tenants {
 		if err := service.IngestEmailsForTenant(ctx, tenant); err != nil {
-			log.Fatalf("Ingestion failed for tenant %s: %v", tenant.Name, err)
+			log.Printf("Ingestion failed for tenant %s, deferring: %v", tenant.Name, err)
 		}
 	}
 
-	// Phase 2: Detection
+	// Phase 1b: Push subscriptions, and polling of the mailboxes they do not cover
+	// Subscriptions are created when a tenant is activated; in production,
+	// reconciliation runs continuously (pushIngestion.Run) next to the notification
+	// receiver, and lapsed mailboxes are polled on a schedule.
+	pushStats, err := pushIngestion.Reconcile(ctx)
+	if err != nil {
+		log.Printf("Mail subscription reconciliation failed: %v", err)
+	}
+	log.Printf("Mail subscriptions: %d renewed, %d recreated, %d lapsed",
+		pushStats.Renewed, pushStats.Recreated, pushStats.Lapsed)
 	for _, tenant := range tenants {
-		if err := service.ProcessUnprocessedEmails(ctx, tenant.ID); err != nil {
-			log.Fatalf("Processing failed for tenant %s: %v", tenant.Name, err)
+		if _, err := pushIngestion.PollLapsed(ctx, tenant.ID); err != nil {
+			log.Printf("Polling lapsed mailboxes failed for tenant %s: %v", tenant.Name, err)
+		}
+	}
+	// PUSH_LISTEN_ADDR serves the notification receiver and sends it a simulated Graph notification
+	if addr := os.Getenv("PUSH_LISTEN_ADDR"); addr != "" {
+		simulatePush(ctx, addr, pushIngestion, tenants[0])
+	}
+
+	// Phase 2: Detection (tenants round-robin, batched and analyzed in parallel)
+	// SHADOW_AGGREGATOR runs a candidate scoring with another aggregator in shadow mode:
+	// its analyses are stored under its own version but never alert
+	var shadow *detection.Detector
+	if name := os.Getenv("SHADOW_AGGREGATOR"); name != "" {
+		aggregator, ok := aggregators[name]
+		if !ok {
+			log.Fatalf("Unknown SHADOW_AGGREGATOR %q", name)
+		}
+		shadow = detector.Using(aggregator).Versioned(detector.Version() + "+" + name)
+		log.Printf("Shadow detector %s enabled", shadow.Version())
+	}
+	logBacklog(ctx, service)
+	stats, err := service.ProcessConcurrently(ctx, application.PoolConfig{
+		Workers:   getEnvInt("DETECTION_WORKERS", 0),
+		BatchSize: getEnvInt("DETECTION_BATCH_SIZE", 0),
+		Owner:     os.Getenv("DETECTION_WORKER_ID"),
+		Shadow:    shadow,
+	})
+	if err != nil {
+		log.Fatalf("Processing failed after %d emails: %v", stats.Emails, err)
+	}
+	log.Printf("Detection throughput: %.0f emails/s (%d emails, %d batches, %s)",
+		stats.Throughput(), stats.Emails, stats.Batches, stats.Elapsed)
+	logBacklog(ctx, service)
+	if shadow != nil {
+		for _, tenant := range tenants {
+			compareShadow(ctx, service, tenant, shadow.Version())
+		}
+	}
+
+	// Phase 2b: Publish ingestion and analysis events
+	// In production, the relay runs continuously (relay.Run) in its own process.
+	relayStats, err := relay.Drain(ctx)
+	if err != nil {
+		log.Printf("Event relay failed (events stay in the outbox): %v", err)
+	}
+	log.Printf("Events: %d published, %d retrying, %d dead-lettered",
+		relayStats.Published, relayStats.Retried, relayStats.DeadLettered)
+
+	// In production, the dispatcher runs continuously (webhooks.Run) next to the relay.
+	webhookStats, err := webhooks.DispatchDue(ctx)
+	if err != nil {
+		log.Printf("Webhook dispatch failed (deliveries stay pending): %v", err)
+	}
+	log.Printf("Webhooks: %d delivered, %d retrying, %d dead-lettered",
+		webhookStats.Delivered, webhookStats.Retried, webhookStats.DeadLettered)
+
+	// In production, digests are flushed on a schedule (e.g. hourly).
+	digests, err := alerts.FlushDigests(ctx)
+	if err != nil {
+		log.Printf("Some alert digests failed to send (kept for the next flush): %v", err)
+	}
+	log.Printf("Alert digests sent: %d", digests)
+
+	// Phase 2f: Reprocessing (REPROCESS_SINCE, e.g. "168h", re-runs the detector over
+	// each tenant's recent mail; REPROCESS_PROMOTE=true makes the new analyses current)
+	// In production, a reprocessing job runs in its own process next to the workers.
+	if since := os.Getenv("REPROCESS_SINCE"); since != "" {
+		window, err := time.ParseDuration(since)
+		if err != nil {
+			log.Fatalf("Invalid REPROCESS_SINCE: %v", err)
+		}
+		for _, tenant := range tenants {
+			reprocess(ctx, service, application.ReprocessRequest{
+				TenantID: tenant.ID,
+				From:     time.Now().Add(-window),
+				To:       time.Now(),
+				Promote:  os.Getenv("REPROCESS_PROMOTE") == "true",
+				Rate:     float64(getEnvInt("REPROCESS_RATE", 0)),
+			})
 		}
 	}
 
@@ -128,9 +511,235 @@ func main() {
 	log.Println("Email security service completed successfully")
 }
 
+// simulatePush serves the notification receiver on addr and posts it a Graph
+// notification for a tenant's first live mailbox subscription, as Graph would when
+// new mail arrives
+// In production, the receiver runs in its own process behind the public PUSH_BASE_URL.
+func simulatePush(ctx context.Context, addr string, pushIngestion *application.PushIngestionService, tenant *domain.Tenant) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("Push receiver not started: %v", err)
+		return
+	}
+	server := &http.Server{
+		Handler:           receiver.New(pushIngestion, receiver.Config{GmailToken: os.Getenv("PUSH_GMAIL_TOKEN")}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	log.Printf("Push receiver listening on %s", listener.Addr())
+
+	subs, err := pushIngestion.ListMailSubscriptions(ctx, tenant.ID)
+	if err != nil {
+		log.Printf("Push simulation skipped: %v", err)
+		return
+	}
+	graph := receiver.NewGraphSender("http://" + listener.Addr().String() + receiver.GraphPath)
+	for _, sub := range subs {
+		if sub.Provider != domain.ProviderMicrosoft || !sub.Live(time.Now()) {
+			continue
+		}
+		if err := graph.Validate(ctx); err != nil {
+			log.Printf("Push receiver validation failed: %v", err)
+			return
+		}
+		status, err := graph.Notify(ctx, domain.MailChange{
+			SubscriptionID: sub.ExternalID,
+			ClientState:    sub.ClientState,
+			MessageID:      "AAMkAD-" + uuid.NewString()[:8],
+		})
+		log.Printf("Simulated Graph notification for %s: status %d, err %v", sub.Mailbox, status, err)
+		return
+	}
+}
+
+// simulateConsent answers a tenant's consent callback as its provider would after
+// the administrator granted consent at consentURL
+func simulateConsent(ctx context.Context, lifecycle *application.OnboardingService, tenant *domain.Tenant, consentURL string) (*domain.Tenant, error) {
+	parsed, err := url.Parse(consentURL)
+	if err != nil {
+		return nil, err
+	}
+	callback := domain.ConsentCallback{State: parsed.Query().Get("state")}
+	switch tenant.Provider {
+	case domain.ProviderMicrosoft:
+		callback.DirectoryID = uuid.NewString()
+		callback.AdminConsent = true
+	case domain.ProviderGoogle:
+		callback.Code = "demo-authorization-code-" + tenant.ID.String()
+	}
+	return lifecycle.CompleteConsent(ctx, callback)
+}
+
+// consentStateSecret returns CONSENT_STATE_SECRET, or a random secret valid for this
+// process only
+func consentStateSecret() []byte {
+	if secret := os.Getenv("CONSENT_STATE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate consent state secret: %v", err)
+	}
+	log.Println("CONSENT_STATE_SECRET not set: consent URLs are only valid for this process")
+	return secret
+}
+
+// logBacklog logs each tenant's pending emails and detection lag
+// In production, these would be exported as per-tenant gauges and alerted on.
+func logBacklog(ctx context.Context, service *application.FraudDetectionService) {
+	backlog, err := service.GetBacklog(ctx)
+	if err != nil {
+		log.Printf("Failed to fetch backlog: %v", err)
+		return
+	}
+	if len(backlog) == 0 {
+		log.Println("Detection backlog: empty")
+		return
+	}
+	for _, tenant := range backlog {
+		log.Printf("Detection backlog: %s: %d pending (%d leased), lag %s",
+			tenant.TenantName, tenant.Pending, tenant.Leased, tenant.Lag.Round(time.Second))
+	}
+}
+
+// compareShadow logs how the shadow detector's verdicts on a tenant's last week of
+// mail differ from the live detector's
+func compareShadow(ctx context.Context, service *application.FraudDetectionService, tenant *domain.Tenant, version string) {
+	comparison, err := service.CompareVersions(ctx, application.CompareRequest{
+		TenantID:  tenant.ID,
+		From:      time.Now().Add(-7 * 24 * time.Hour),
+		To:        time.Now(),
+		Candidate: version,
+	})
+	if err != nil {
+		log.Printf("Failed to compare shadow detector for tenant %s: %v", tenant.Name, err)
+		return
+	}
+	log.Printf("Shadow %s vs %s for %s: %d emails, %.1f%% agreement, %d newly flagged, %d no longer flagged",
+		comparison.Candidate, comparison.Baseline, tenant.Name, comparison.Compared,
+		comparison.AgreementRate()*100, len(comparison.NewlyFlagged), len(comparison.NoLongerFlagged))
+	for _, change := range comparison.NewlyFlagged {
+		log.Printf("  + %s -> %s: %q from %s", change.Before, change.After, change.Subject, change.SenderEmail)
+	}
+	for _, change := range comparison.NoLongerFlagged {
+		log.Printf("  - %s -> %s: %q from %s", change.Before, change.After, change.Subject, change.SenderEmail)
+	}
+}
+
+// reprocess runs a reprocessing job and logs the emails whose risk level changed
+func reprocess(ctx context.Context, service *application.FraudDetectionService, req application.ReprocessRequest) {
+	report, err := service.Reprocess(ctx, req)
+	if err != nil {
+		log.Printf("Reprocessing of tenant %s stopped after %d emails: %v", req.TenantID, report.Emails, err)
+		return
+	}
+	log.Printf("Reprocessed %d emails with detector %s in %s: %d escalated, %d de-escalated, %d pauses for live traffic",
+		report.Emails, report.DetectorVersion, report.Elapsed.Round(time.Millisecond),
+		report.Escalated, report.Deescalated, report.Pauses)
+	for _, change := range report.Changed {
+		log.Printf("  %s -> %s (%.2f -> %.2f): %q from %s", change.Before, change.After,
+			change.BeforeScore, change.AfterScore, change.Subject, change.SenderEmail)
+	}
+}
+
+// classifierThreshold is the calibrated probability above which the text classifier flags an email
+const classifierThreshold = 0.80
+
+func loadClassifier(path string) (*classifier.Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return classifier.Load(f)
+}
+
+func loadCombiner(path string) (*detection.LogisticAggregator, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	combiner := &detection.LogisticAggregator{}
+	if err := json.Unmarshal(raw, combiner); err != nil {
+		return nil, err
+	}
+	return combiner, nil
+}
+
+func loadCalibration(path string) (*calibration.Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return calibration.LoadSet(f)
+}
+
+// serveFakeTokens serves a local fake OAuth token endpoint and returns its URL
+func serveFakeTokens() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Failed to start fake token endpoint: %v", err)
+	}
+	server := &http.Server{
+		Handler:           oauth.NewFakeTokenServer(time.Hour),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go server.Serve(listener)
+	log.Printf("Fake OAuth token endpoint listening on %s", listener.Addr())
+	return "http://" + listener.Addr().String() + "/token"
+}
+
+// rotateMasterKey makes a new master key active and re-wraps the data keys
+// The previous master key is kept: retire it (LocalKMS.Retire) once every process
+// runs with the new key file. A MASTER_KEY from the environment is not rotated: the
+// new key would only live in this process.
+func rotateMasterKey(ctx context.Context, keyManager *kms.LocalKMS, secrets *application.SecretService) {
+	if os.Getenv("MASTER_KEY") != "" {
+		log.Printf("Master key rotation skipped: ROTATE_MASTER_KEY needs the key file KMS_KEY_FILE, not MASTER_KEY")
+		return
+	}
+	masterKeyID, err := keyManager.Rotate()
+	if err != nil {
+		log.Printf("Master key rotation failed: %v", err)
+		return
+	}
+	total := 0
+	for {
+		stats, err := secrets.RotateMasterKey(ctx, 100)
+		if err != nil {
+			log.Printf("Data key re-wrap failed: %v", err)
+			return
+		}
+		total += stats.Rewrapped
+		if stats.Rewrapped == 0 {
+			break
+		}
+	}
+	log.Printf("Rotated master key to %s, re-wrapped %d data keys", masterKeyID, total)
+}
+
+// loadKeyManager returns the local key manager holding the master keys
+func loadKeyManager() (*kms.LocalKMS, error) {
+	if masterKey := os.Getenv("MASTER_KEY"); masterKey != "" {
+		return kms.NewLocalKMS(masterKey)
+	}
+	return kms.LoadLocalKMS(getEnv("KMS_KEY_FILE", "kms-keys.json"))
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// getEnvInt reads an integer setting; unset or invalid values use the default
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
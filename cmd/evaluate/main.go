@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/stoik/email-security/internal/adapters/corpus"
+	"github.com/stoik/email-security/internal/domain/calibration"
+	"github.com/stoik/email-security/internal/domain/classifier"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/domain/evaluation"
+)
+
+// evaluate measures the Detector against a labeled corpus and fits calibration maps
+//
+// Usage:
+//
+//	go run ./cmd/evaluate -corpus reviewed/ -curves out/
+//	go run ./cmd/evaluate -corpus reviewed/ -calibrate isotonic -calibration-output calibration.json
+//
+// The corpus is a JSONL file, an .eml file, or a directory of them (see corpus.Load).
+// Per-strategy and per-risk-level precision/recall/F1 and confusion matrices are printed
+// to stdout; ROC and PR curves are written as CSV. Calibration is fitted on a train
+// split of the corpus and its metrics are reported on the held-out rest. A fitted
+// calibration file is loaded by the detection service through DETECTOR_CALIBRATION_PATH
+// and only applies to tenants scored with the aggregator it was fitted on.
+func main() {
+	corpusPath := flag.String("corpus", "", "labeled emails: JSONL/.eml file or directory")
+	internalDomains := flag.String("internal-domains", "company.com,example.com", "comma-separated internal domains")
+	trustedDomains := flag.String("trusted-domains", "microsoft.com,google.com,paypal.com", "comma-separated trusted domains")
+	aggregatorName := flag.String("aggregator", detection.AggregatorWeightedMax, "risk aggregator to evaluate")
+	combinerPath := flag.String("combiner", "", "logistic combiner file (required by -aggregator logistic)")
+	modelPath := flag.String("classifier", "", "optional text classifier model to evaluate alongside the rules")
+	calibrationPath := flag.String("calibration", "", "existing calibration file to evaluate with")
+	curvesDir := flag.String("curves", "", "directory where roc.csv and pr.csv are written")
+	method := flag.String("calibrate", "", "fit calibration maps: isotonic or platt")
+	calibrationOutput := flag.String("calibration-output", "calibration.json", "path of the fitted calibration file")
+	holdout := flag.Float64("holdout", 0.3, "fraction of the corpus kept out of calibration fitting to report its metrics")
+	seed := flag.Int64("seed", 1, "random seed for the train/holdout split")
+	combinerOutput := flag.String("combiner-output", "", "fit a logistic combiner on the corpus and write it here")
+	version := flag.String("version", "", "version label of fitted files (defaults to the fit timestamp)")
+	flag.Parse()
+
+	if *corpusPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	labeled, err := corpus.Load(*corpusPath)
+	if err != nil {
+		log.Fatalf("Failed to load corpus: %v", err)
+	}
+	examples := make([]evaluation.Example, len(labeled))
+	for i, l := range labeled {
+		examples[i] = evaluation.Example{Email: l.Email, Recipient: l.Recipient, Fraud: l.Fraud}
+	}
+
+	detector, err := buildDetector(splitList(*internalDomains), splitList(*trustedDomains), *aggregatorName, *combinerPath, *modelPath, *calibrationPath)
+	if err != nil {
+		log.Fatalf("Failed to build detector: %v", err)
+	}
+
+	report := evaluation.Run(detector, examples)
+	printReport(report, *aggregatorName)
+
+	if *curvesDir != "" {
+		if err := writeCurves(*curvesDir, report.Curve); err != nil {
+			log.Fatalf("Failed to write curves: %v", err)
+		}
+		log.Printf("Wrote ROC and PR curves to %s", *curvesDir)
+	}
+
+	if *method != "" {
+		train, test := evaluation.Split(examples, *holdout, *seed)
+		set, err := evaluation.FitCalibration(detector, train, *method, *version)
+		if err != nil {
+			log.Fatalf("Calibration failed: %v", err)
+		}
+		if err := writeFile(*calibrationOutput, func(f *os.File) error { return set.Save(f) }); err != nil {
+			log.Fatalf("Failed to write calibration: %v", err)
+		}
+		log.Printf("Calibration %s for aggregator %s: fitted on %d emails, %d detection types, risk score calibrated: %t",
+			set.Version, set.Aggregator, len(train), len(set.Detections), set.RiskScore != nil)
+
+		// Metrics on the emails the maps were fitted on would overstate them
+		if len(test) == 0 {
+			log.Printf("No holdout split (-holdout %.2f, %d emails): calibrated metrics not reported", *holdout, len(examples))
+		} else {
+			fmt.Printf("\nHoldout (%d emails) without calibration:\n", len(test))
+			printLevels(evaluation.Run(detector.Calibrated(nil), test))
+			fmt.Printf("\nHoldout (%d emails) with fitted %s calibration:\n", len(test), *method)
+			printLevels(evaluation.Run(detector.Calibrated(set), test))
+		}
+		log.Printf("Wrote %s", *calibrationOutput)
+	}
+
+	if *combinerOutput != "" {
+		combiner := detection.FitLogisticAggregator(evaluation.LogisticExamples(detector, examples), combinerL2, combinerIterations, *version)
+		if err := writeFile(*combinerOutput, func(f *os.File) error {
+			encoder := json.NewEncoder(f)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(combiner)
+		}); err != nil {
+			log.Fatalf("Failed to write combiner: %v", err)
+		}
+		log.Printf("Wrote logistic combiner to %s", *combinerOutput)
+	}
+}
+
+// Logistic combiner fitting parameters
+const (
+	combinerL2         = 0.01
+	combinerIterations = 2000
+)
+
+func buildDetector(internalDomains, trustedDomains []string, aggregatorName, combinerPath, modelPath, calibrationPath string) (*detection.Detector, error) {
+	aggregators := detection.StandardAggregators()
+	if combinerPath != "" {
+		raw, err := os.ReadFile(combinerPath)
+		if err != nil {
+			return nil, err
+		}
+		combiner := &detection.LogisticAggregator{}
+		if err := json.Unmarshal(raw, combiner); err != nil {
+			return nil, fmt.Errorf("failed to decode combiner: %w", err)
+		}
+		aggregators[combiner.Name()] = combiner
+	}
+	aggregator, ok := aggregators[aggregatorName]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregator %q", aggregatorName)
+	}
+
+	opts := []detection.Option{detection.WithAggregator(aggregator)}
+	if modelPath != "" {
+		f, err := os.Open(modelPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		model, err := classifier.Load(f)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, detection.WithStrategies(detection.NewClassifierStrategy(model, classifierThreshold)))
+	}
+	if calibrationPath != "" {
+		f, err := os.Open(calibrationPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		set, err := calibration.LoadSet(f)
+		if err != nil {
+			return nil, err
+		}
+		if !set.Fits(aggregator.Name()) {
+			return nil, fmt.Errorf("calibration %s was fitted on aggregator %s, not %s", set.Version, set.Aggregator, aggregator.Name())
+		}
+		opts = append(opts, detection.WithCalibration(set))
+	}
+
+	return detection.NewDetector(internalDomains, trustedDomains, opts...), nil
+}
+
+// classifierThreshold matches the threshold used by the detection service
+const classifierThreshold = 0.80
+
+func printReport(report evaluation.Report, aggregator string) {
+	fmt.Printf("Corpus: %d emails (%d fraud, %d legit), aggregator %s\n", report.Examples, report.Fraud, report.Examples-report.Fraud, aggregator)
+	fmt.Printf("ROC AUC %.3f, average precision %.3f\n\n", report.ROCAUC, report.AveragePrecision)
+
+	fmt.Println("Per strategy (strategy fired = flagged):")
+	printResults(report.Strategies)
+	fmt.Println()
+	printLevels(report)
+}
+
+func printLevels(report evaluation.Report) {
+	fmt.Println("Per risk level (level or above = flagged):")
+	printResults(report.Levels)
+}
+
+func printResults(results []evaluation.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPRECISION\tRECALL\tF1\tTP\tFP\tTN\tFN")
+	for _, r := range results {
+		c := r.Confusion
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%.3f\t%d\t%d\t%d\t%d\n", r.Name, c.Precision(), c.Recall(), c.F1(), c.TP, c.FP, c.TN, c.FN)
+	}
+	w.Flush()
+}
+
+func writeCurves(dir string, points []evaluation.CurvePoint) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, "roc.csv"), func(f *os.File) error {
+		fmt.Fprintln(f, "threshold,fpr,tpr")
+		for _, p := range points {
+			fmt.Fprintf(f, "%.6f,%.6f,%.6f\n", p.Threshold, p.FPR, p.TPR)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "pr.csv"), func(f *os.File) error {
+		fmt.Fprintln(f, "threshold,recall,precision")
+		for _, p := range points {
+			fmt.Fprintf(f, "%.6f,%.6f,%.6f\n", p.Threshold, p.TPR, p.Precision)
+		}
+		return nil
+	})
+}
+
+func writeFile(path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
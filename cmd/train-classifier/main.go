@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/stoik/email-security/internal/adapters/corpus"
+	"github.com/stoik/email-security/internal/domain/classifier"
+)
+
+// train-classifier fits the naive Bayes text classifier from reviewed emails
+//
+// Usage:
+//
+//	go run ./cmd/train-classifier -input reviewed/ -output models/classifier.json
+//
+// The input is a JSONL file (or a directory of them) where each line is an email
+// with a "verdict" field ("fraud" or "legit"). The resulting model file is loaded
+// by the detection service through CLASSIFIER_MODEL_PATH.
+func main() {
+	input := flag.String("input", "", "labeled emails: JSONL file or directory of JSONL files")
+	output := flag.String("output", "classifier.json", "path of the model file to write")
+	maxNGram := flag.Int("ngram", 2, "largest n-gram size")
+	minCount := flag.Int("min-count", 2, "drop terms seen fewer times than this")
+	holdout := flag.Float64("holdout", 0.2, "fraction of examples kept out to fit calibration (0 trains on every example, uncalibrated)")
+	seed := flag.Int64("seed", 1, "random seed for the train/holdout split")
+	version := flag.String("version", "", "model version label (defaults to the training timestamp)")
+	flag.Parse()
+
+	if *input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	labeled, err := corpus.Load(*input)
+	if err != nil {
+		log.Fatalf("Failed to load corpus: %v", err)
+	}
+
+	examples := make([]classifier.Example, 0, len(labeled))
+	fraudCount := 0
+	for _, l := range labeled {
+		examples = append(examples, classifier.Example{Text: classifier.Text(l.Email), Fraud: l.Fraud})
+		if l.Fraud {
+			fraudCount++
+		}
+	}
+	log.Printf("Loaded %d labeled emails (%d fraud, %d legit)", len(examples), fraudCount, len(examples)-fraudCount)
+
+	holdoutFraction := *holdout
+	if holdoutFraction <= 0 {
+		holdoutFraction = classifier.NoHoldout // Zero would select the default fraction
+	}
+	model, err := classifier.Train(examples, classifier.TrainOptions{
+		MaxNGram:        *maxNGram,
+		MinCount:        *minCount,
+		HoldoutFraction: holdoutFraction,
+		Seed:            *seed,
+		Version:         *version,
+	})
+	if err != nil {
+		log.Fatalf("Training failed: %v", err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Failed to create model file: %v", err)
+	}
+	if err := model.Save(f); err != nil {
+		log.Fatalf("Failed to write model: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write model: %v", err)
+	}
+
+	log.Printf("Model %s: %d terms, calibration a=%.3f b=%.3f", model.Version, len(model.Terms), model.Calibration.A, model.Calibration.B)
+	if model.HoldoutSize > 0 {
+		log.Printf("Holdout (%d emails): accuracy %.3f, log loss %.3f", model.HoldoutSize, model.HoldoutAccuracy, model.HoldoutLogLoss)
+	}
+	log.Printf("Wrote %s", *output)
+}
@@ -0,0 +1,148 @@
+package corpus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// LabeledEmail is an email with a human-reviewed verdict
+type LabeledEmail struct {
+	Email domain.Email
+	Fraud bool
+
+	// Recipient is the directory entry of the recipient, when known
+	// Needed to evaluate role-based strategies (BEC targeting).
+	Recipient *domain.User
+
+	// Source identifies where the example came from (file:line) for error reporting
+	Source string
+}
+
+// record is the JSONL line format: the domain.Email fields plus a "verdict"
+// and an optional "recipient" user
+//
+//	{"subject": "...", "sender_email": "...", "body_preview": "...", "verdict": "fraud", "recipient": {"role": "CFO"}}
+type record struct {
+	domain.Email
+	Verdict   string       `json:"verdict"`
+	Recipient *domain.User `json:"recipient,omitempty"`
+}
+
+// ParseVerdict maps a reviewer verdict to a fraud label
+// Accepts the labels used by common review tools ("fraud"/"legit", "malicious"/"benign", "spam"/"ham").
+func ParseVerdict(verdict string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(verdict)) {
+	case "fraud", "malicious", "phishing", "bec", "spam":
+		return true, nil
+	case "legit", "legitimate", "benign", "clean", "ham":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown verdict %q", verdict)
+	}
+}
+
+// ReadJSONL reads labeled emails, one JSON object per line
+// Blank lines are skipped; a malformed line aborts with its line number.
+func ReadJSONL(r io.Reader, name string) ([]LabeledEmail, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // Allow long bodies
+
+	emails := make([]LabeledEmail, 0)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		isFraud, err := ParseVerdict(rec.Verdict)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+
+		emails = append(emails, LabeledEmail{
+			Email:     rec.Email,
+			Fraud:     isFraud,
+			Recipient: rec.Recipient,
+			Source:    fmt.Sprintf("%s:%d", name, line),
+		})
+	}
+
+	return emails, scanner.Err()
+}
+
+// Load reads labeled emails from a file or a directory
+// Directories are walked recursively for *.jsonl and *.eml files, in lexical order.
+// An .eml file takes its verdict from its X-Verdict header, or else from the name
+// of its parent directory (e.g. corpus/fraud/123.eml).
+func Load(path string) ([]LabeledEmail, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat corpus: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = files[:0]
+		err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isCorpusFile(p) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk corpus directory: %w", err)
+		}
+		sort.Strings(files)
+	}
+
+	emails := make([]LabeledEmail, 0)
+	for _, file := range files {
+		if strings.EqualFold(filepath.Ext(file), ".eml") {
+			loaded, err := loadEMLFile(file)
+			if err != nil {
+				return nil, err
+			}
+			emails = append(emails, loaded)
+			continue
+		}
+
+		loaded, err := loadJSONLFile(file)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, loaded...)
+	}
+
+	return emails, nil
+}
+
+func isCorpusFile(path string) bool {
+	ext := filepath.Ext(path)
+	return strings.EqualFold(ext, ".jsonl") || strings.EqualFold(ext, ".eml")
+}
+
+func loadJSONLFile(path string) ([]LabeledEmail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	return ReadJSONL(f, path)
+}
@@ -0,0 +1,266 @@
+package corpus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// VerdictHeader carries the reviewer verdict inside an .eml file
+// Files without it take their verdict from the parent directory name (e.g. fraud/, legit/).
+const VerdictHeader = "X-Verdict"
+
+// bodyPreviewLength matches the preview stored by provider adapters
+const bodyPreviewLength = 500
+
+// headerAliases restores the spelling detection strategies expect for headers
+// that net/mail canonicalizes differently ("Received-Spf")
+var headerAliases = map[string]string{
+	"Received-Spf":     "Received-SPF",
+	"X-Originating-Ip": "X-Originating-IP",
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// ReadEML parses a raw RFC 5322 message into a labeled email
+// fallbackVerdict is used when the message has no X-Verdict header.
+func ReadEML(r io.Reader, name, fallbackVerdict string) (LabeledEmail, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return LabeledEmail{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	verdict := msg.Header.Get(VerdictHeader)
+	if verdict == "" {
+		verdict = fallbackVerdict
+	}
+	isFraud, err := ParseVerdict(verdict)
+	if err != nil {
+		return LabeledEmail{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	email := domain.Email{
+		ID:                uuid.New(),
+		ProviderMessageID: strings.Trim(msg.Header.Get("Message-Id"), "<>"),
+		Subject:           subject,
+		Headers:           make(map[string]string),
+		IngestedAt:        time.Now(),
+	}
+
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		email.SenderEmail = from.Address
+		email.SenderName = from.Name
+	} else {
+		email.SenderEmail = msg.Header.Get("From")
+	}
+	if to, err := mail.ParseAddressList(msg.Header.Get("To")); err == nil && len(to) > 0 {
+		email.RecipientEmail = to[0].Address
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		email.ReceivedAt = date
+	}
+
+	for key, values := range msg.Header {
+		if key == VerdictHeader || len(values) == 0 {
+			continue
+		}
+		if alias, ok := headerAliases[key]; ok {
+			key = alias
+		}
+		email.Headers[key] = values[0]
+	}
+	// Strategies compare the bare Reply-To address
+	if replyTo, err := mail.ParseAddress(msg.Header.Get("Reply-To")); err == nil {
+		email.Headers["Reply-To"] = replyTo.Address
+	}
+
+	body, attachments, err := readParts(headerPart(msg.Header), msg.Body)
+	if err != nil {
+		return LabeledEmail{}, fmt.Errorf("%s: %w", name, err)
+	}
+	email.BodyPreview = preview(body)
+	email.AttachmentNames = make([]string, len(attachments))
+	email.AttachmentHashes = make([]string, len(attachments))
+	for i, a := range attachments {
+		email.AttachmentNames[i] = a.name
+		email.AttachmentHashes[i] = a.sha256
+	}
+	email.HasAttachments = len(attachments) > 0
+
+	return LabeledEmail{Email: email, Fraud: isFraud, Source: name}, nil
+}
+
+func loadEMLFile(path string) (LabeledEmail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return LabeledEmail{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	return ReadEML(f, path, filepath.Base(filepath.Dir(path)))
+}
+
+// part is the subset of MIME headers needed to walk a message
+type part struct {
+	contentType string
+	disposition string
+	encoding    string
+}
+
+func headerPart(h mail.Header) part {
+	return part{
+		contentType: h.Get("Content-Type"),
+		disposition: h.Get("Content-Disposition"),
+		encoding:    h.Get("Content-Transfer-Encoding"),
+	}
+}
+
+// attachment is an attachment's file name and the hex SHA-256 of its decoded content
+type attachment struct {
+	name   string
+	sha256 string
+}
+
+// readParts returns the best text body and the attachments of a MIME entity
+// text/plain is preferred over text/html; HTML is reduced to its text content.
+func readParts(p part, body io.Reader) (string, []attachment, error) {
+	mediaType, params, err := mime.ParseMediaType(p.contentType)
+	if err != nil {
+		mediaType = "text/plain" // RFC 2045 default
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var plain, html string
+		attachments := make([]attachment, 0)
+		for {
+			child, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to read MIME part: %w", err)
+			}
+
+			childPart := part{
+				contentType: child.Header.Get("Content-Type"),
+				disposition: child.Header.Get("Content-Disposition"),
+				encoding:    child.Header.Get("Content-Transfer-Encoding"),
+			}
+			if name := attachmentName(childPart); name != "" {
+				hash := sha256.New()
+				if _, err := io.Copy(hash, decodeTransfer(childPart.encoding, child)); err != nil {
+					return "", nil, fmt.Errorf("failed to decode attachment %s: %w", name, err)
+				}
+				attachments = append(attachments, attachment{name: name, sha256: hex.EncodeToString(hash.Sum(nil))})
+				continue
+			}
+
+			text, nested, err := readParts(childPart, child)
+			if err != nil {
+				return "", nil, err
+			}
+			attachments = append(attachments, nested...)
+
+			childType, _, _ := mime.ParseMediaType(childPart.contentType)
+			switch {
+			case childType == "text/html" && html == "":
+				html = text
+			case plain == "":
+				plain = text
+			}
+		}
+		if plain != "" {
+			return plain, attachments, nil
+		}
+		return html, attachments, nil
+	}
+
+	raw, err := io.ReadAll(decodeTransfer(p.encoding, body))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode body: %w", err)
+	}
+	text := string(raw)
+	if mediaType == "text/html" {
+		text = htmlTag.ReplaceAllString(text, " ")
+	}
+	return text, nil, nil
+}
+
+func attachmentName(p part) string {
+	disposition, params, err := mime.ParseMediaType(p.disposition)
+	if err != nil {
+		return ""
+	}
+	if params["filename"] != "" {
+		return params["filename"]
+	}
+	if disposition == "attachment" {
+		if _, typeParams, err := mime.ParseMediaType(p.contentType); err == nil && typeParams["name"] != "" {
+			return typeParams["name"]
+		}
+		return "unnamed"
+	}
+	return ""
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// newlineStripper removes line breaks from base64 bodies, which the decoder rejects
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		kept := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, p[:count])
+		copy(p, kept)
+		if len(kept) > 0 || err != nil {
+			return len(kept), err
+		}
+	}
+}
+
+func preview(body string) string {
+	text := strings.Join(strings.Fields(body), " ")
+	runes := []rune(text)
+	if len(runes) > bodyPreviewLength {
+		return string(runes[:bodyPreviewLength])
+	}
+	return text
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// BrokerSink publishes events to a message broker (NATS, Kafka, ...)
+//
+// Each event type is its own subject (prefix + type, e.g.
+// "email-security.threat.detected"), and the tenant ID is the message key so a
+// partitioned broker keeps each tenant's events in order.
+type BrokerSink struct {
+	publisher ports.MessagePublisher
+	prefix    string
+}
+
+// NewBrokerSink creates a sink publishing through a broker client
+func NewBrokerSink(publisher ports.MessagePublisher, subjectPrefix string) *BrokerSink {
+	return &BrokerSink{publisher: publisher, prefix: subjectPrefix}
+}
+
+// Name returns the sink name
+func (s *BrokerSink) Name() string {
+	return "broker"
+}
+
+// Publish sends the event envelope as JSON
+func (s *BrokerSink) Publish(ctx context.Context, event domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	headers := map[string]string{
+		"Event-Id":   event.ID.String(),
+		"Event-Type": event.Type,
+		"Dedup-Key":  event.DedupKey,
+		// JetStream drops messages with an ID already seen in its duplicate window
+		"Nats-Msg-Id": event.DedupKey,
+	}
+	return s.publisher.Publish(ctx, s.prefix+event.Type, []byte(event.TenantID.String()), body, headers)
+}
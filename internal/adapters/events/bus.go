@@ -0,0 +1,120 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// AllEvents subscribes a handler to every event type
+const AllEvents = "*"
+
+// defaultDedupWindow is the number of recent dedup keys a subscription remembers
+const defaultDedupWindow = 10000
+
+// Handler reacts to an event delivered by the bus
+type Handler func(ctx context.Context, event domain.Event) error
+
+// Bus is an in-process event sink dispatching events to subscribed handlers
+//
+// Handlers run synchronously, in subscription order. When one fails, Publish
+// returns an error and the relay redelivers the event later; handlers that already
+// succeeded skip the redelivery, since each subscription remembers the dedup keys
+// of the last events it handled.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions []*subscription
+}
+
+type subscription struct {
+	name      string
+	eventType string
+	handler   Handler
+	seen      *dedupWindow
+}
+
+// NewBus creates an empty in-process bus
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Name returns the sink name
+func (b *Bus) Name() string {
+	return "bus"
+}
+
+// Subscribe registers a handler for an event type (or AllEvents)
+// name identifies the handler in delivery errors.
+func (b *Bus) Subscribe(name, eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, &subscription{
+		name:      name,
+		eventType: eventType,
+		handler:   handler,
+		seen:      newDedupWindow(defaultDedupWindow),
+	})
+}
+
+// Publish dispatches an event to the handlers subscribed to its type
+func (b *Bus) Publish(ctx context.Context, event domain.Event) error {
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+
+	var failures []string
+	for _, sub := range subscriptions {
+		if sub.eventType != AllEvents && sub.eventType != event.Type {
+			continue
+		}
+		if sub.seen.contains(event.DedupKey) {
+			continue // Redelivery of an event this handler already processed
+		}
+		if err := sub.handler(ctx, event); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", sub.name, err))
+			continue
+		}
+		sub.seen.add(event.DedupKey)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d handler(s) failed: %s", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// dedupWindow remembers the last N keys added, forgetting the oldest first
+type dedupWindow struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+	ring []string
+	next int
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{keys: make(map[string]struct{}, size), ring: make([]string, size)}
+}
+
+func (w *dedupWindow) contains(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.keys[key]
+	return ok
+}
+
+func (w *dedupWindow) add(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.keys[key]; ok {
+		return
+	}
+	if evicted := w.ring[w.next]; evicted != "" {
+		delete(w.keys, evicted)
+	}
+	w.ring[w.next] = key
+	w.keys[key] = struct{}{}
+	w.next = (w.next + 1) % len(w.ring)
+}
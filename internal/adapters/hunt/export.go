@@ -0,0 +1,115 @@
+package hunt
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Export formats
+const (
+	FormatNDJSON = "ndjson" // One JSON match per line, with the full email
+	FormatCSV    = "csv"    // One row per match, for spreadsheets and ticket attachments
+)
+
+// csvColumns is the header row of CSV exports
+var csvColumns = []string{
+	"received_at", "tenant_id", "tenant_name", "email_id", "provider_message_id",
+	"recipient_email", "sender_name", "sender_email", "subject",
+	"attachment_names", "attachment_hashes", "risk_level", "risk_score",
+}
+
+// Exporter writes hunt matches in an export format
+type Exporter interface {
+	Write(match domain.HuntMatch) error
+	// Flush writes buffered matches to the underlying writer
+	Flush() error
+	// ContentType is the MIME type of the export
+	ContentType() string
+}
+
+// NewExporter creates an exporter writing to w in a format (FormatNDJSON or FormatCSV)
+func NewExporter(format string, w io.Writer) (Exporter, error) {
+	switch format {
+	case FormatNDJSON, "":
+		buffered := bufio.NewWriter(w)
+		return &ndjsonExporter{w: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case FormatCSV:
+		exporter := &csvExporter{w: csv.NewWriter(w)}
+		if err := exporter.w.Write(csvColumns); err != nil {
+			return nil, fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		return exporter, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q (want %s or %s)", format, FormatNDJSON, FormatCSV)
+	}
+}
+
+type ndjsonExporter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExporter) Write(match domain.HuntMatch) error {
+	return e.encoder.Encode(match)
+}
+
+func (e *ndjsonExporter) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *ndjsonExporter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(match domain.HuntMatch) error {
+	email := match.Email
+	row := []string{
+		email.ReceivedAt.UTC().Format(time.RFC3339),
+		email.TenantID.String(),
+		match.TenantName,
+		email.ID.String(),
+		email.ProviderMessageID,
+		email.RecipientEmail,
+		email.SenderName,
+		email.SenderEmail,
+		email.Subject,
+		strings.Join(email.AttachmentNames, "; "),
+		strings.Join(email.AttachmentHashes, "; "),
+		match.RiskLevel,
+		strconv.FormatFloat(match.RiskScore, 'f', 2, 64),
+	}
+	for i := range row {
+		row[i] = safeCell(row[i])
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) ContentType() string {
+	return "text/csv"
+}
+
+// safeCell defuses spreadsheet formulas: subjects and names are attacker-controlled,
+// and a cell starting with =, +, - or @ would run when an analyst opens the export
+func safeCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
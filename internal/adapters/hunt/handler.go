@@ -0,0 +1,235 @@
+// Package hunt is the HTTP API and export formats of retro-hunts over stored emails
+//
+// Routes:
+//
+//	GET /hunt     Stream the emails matching a query, newest first
+//
+// Query parameters (all optional, at least one email criterion required):
+//
+//	tenant_id        Search one tenant (default: all tenants)
+//	sender           Exact sender address
+//	sender_domain    Sender domain or any of its subdomains
+//	lookalike_of     Sender domains resembling this domain, excluding it
+//	text             Substring of the subject or body preview (its first 500 characters)
+//	attachment_name  Substring of an attachment file name
+//	attachment_hash  SHA-256 of an attachment's content
+//	header           "Name: value", exact value; repeatable
+//	from, to         Reception window, RFC 3339
+//	limit            Maximum number of matches
+//	format           ndjson (default) or csv
+//
+// Matches are streamed as they are found. The hunt's summary is sent in trailers
+// (X-Hunt-Matches, X-Hunt-Tenants, X-Hunt-Mailboxes, X-Hunt-Truncated), and
+// X-Hunt-Error reports a hunt that failed after streaming began. The route requires
+// the admin token as a bearer token: a hunt reads every tenant's mail.
+package hunt
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// HuntPath is the route served by the handler
+const HuntPath = "/hunt"
+
+// flushEvery is the number of matches streamed between flushes to the client
+const flushEvery = 100
+
+// Config configures the handler
+type Config struct {
+	// AdminToken authenticates requests; when empty they are refused
+	AdminToken string
+
+	// Timeout bounds one hunt (default: 5 minutes, cross-tenant exports are long)
+	Timeout time.Duration
+}
+
+// Handler serves the hunt API
+type Handler struct {
+	service ports.EmailHunter
+	cfg     Config
+	mux     *http.ServeMux
+}
+
+// New creates a handler hunting through service
+func New(service ports.EmailHunter, cfg Config) *Handler {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	h := &Handler{service: service, cfg: cfg, mux: http.NewServeMux()}
+	h.mux.HandleFunc(HuntPath, h.admin(h.handleHunt))
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+// handleHunt streams the matches of a query in the requested format
+func (h *Handler) handleHunt(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := ParseQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exporter, err := NewExporter(req.URL.Query().Get("format"), w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Headers are sent with the first match: until then, an invalid query can still
+	// be answered with an error status
+	started := false
+	start := func() {
+		started = true
+		w.Header().Set("Content-Type", exporter.ContentType())
+		if exporter.ContentType() == "text/csv" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="hunt-%s.csv"`, time.Now().UTC().Format("20060102T150405Z")))
+		}
+		w.Header().Set("Trailer", "X-Hunt-Matches, X-Hunt-Tenants, X-Hunt-Mailboxes, X-Hunt-Truncated, X-Hunt-Error")
+		w.WriteHeader(http.StatusOK)
+	}
+	flusher, _ := w.(http.Flusher)
+	flush := func() error {
+		if err := exporter.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	streamed := 0
+	report, err := h.service.Hunt(ctx, query, func(match domain.HuntMatch) error {
+		if !started {
+			start()
+		}
+		if err := exporter.Write(match); err != nil {
+			return err
+		}
+		streamed++
+		if streamed%flushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		writeError(w, err)
+		return
+	}
+	if !started {
+		start()
+	}
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		log.Printf("Hunt failed after streaming %d matches: %v", streamed, err)
+		w.Header().Set("X-Hunt-Error", "hunt interrupted, results are incomplete")
+	}
+	w.Header().Set("X-Hunt-Matches", strconv.Itoa(report.Matches))
+	w.Header().Set("X-Hunt-Tenants", strconv.Itoa(report.Tenants))
+	w.Header().Set("X-Hunt-Mailboxes", strconv.Itoa(report.Mailboxes))
+	w.Header().Set("X-Hunt-Truncated", strconv.FormatBool(report.Truncated))
+}
+
+// ParseQuery reads a hunt query from URL parameters (see the package documentation)
+// The hunt CLI maps its flags onto the same parameters.
+func ParseQuery(values map[string][]string) (domain.HuntQuery, error) {
+	get := func(name string) string {
+		if v := values[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	query := domain.HuntQuery{
+		Sender:         get("sender"),
+		SenderDomain:   get("sender_domain"),
+		LookalikeOf:    get("lookalike_of"),
+		Text:           get("text"),
+		AttachmentName: get("attachment_name"),
+		AttachmentHash: get("attachment_hash"),
+	}
+
+	var err error
+	if v := get("tenant_id"); v != "" {
+		if query.TenantID, err = uuid.Parse(v); err != nil {
+			return query, fmt.Errorf("invalid tenant_id %q", v)
+		}
+	}
+	for _, header := range values["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return query, fmt.Errorf("invalid header %q, want \"Name: value\"", header)
+		}
+		if query.Headers == nil {
+			query.Headers = make(map[string]string)
+		}
+		query.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if query.From, err = parseTime(get("from")); err != nil {
+		return query, fmt.Errorf("invalid from: %w", err)
+	}
+	if query.To, err = parseTime(get("to")); err != nil {
+		return query, fmt.Errorf("invalid to: %w", err)
+	}
+	if v := get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			return query, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return query, nil
+}
+
+// parseTime parses an RFC 3339 time or a date; empty is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// admin authenticates a request with the admin bearer token
+func (h *Handler) admin(next func(ctx context.Context, w http.ResponseWriter, req *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || h.cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), h.cfg.Timeout)
+		defer cancel()
+		next(ctx, w, req)
+	}
+}
+
+// writeError answers with the status matching a service error
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, domain.ErrInvalidHunt) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Hunt request failed: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
@@ -0,0 +1,212 @@
+// Package kms implements ports.KeyManager with master keys held locally
+//
+// LocalKMS stands in for a cloud KMS (AWS KMS, Google Cloud KMS, Vault transit) in
+// development and tests: master keys are read from a file or the environment
+// instead of staying inside an HSM.
+package kms
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain/envelope"
+)
+
+// ErrNotPersisted is returned when changing the master keys of a LocalKMS without a
+// key file: a new key held only in memory would be lost on restart, along with every
+// data key wrapped with it
+var ErrNotPersisted = errors.New("master keys are not persisted: use a key file to rotate them")
+
+// keyFile is the on-disk form of a LocalKMS
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"` // Base64 AES-256 keys by ID; retired keys still unwrap
+}
+
+// LocalKMS wraps data keys with AES-256-GCM master keys
+type LocalKMS struct {
+	mu     sync.RWMutex
+	path   string // Empty for keys that are not persisted
+	active string
+	keys   map[string][]byte
+}
+
+// LoadLocalKMS reads master keys from a JSON file, creating it with a new key if
+// it does not exist
+func LoadLocalKMS(path string) (*LocalKMS, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		k, err := GenerateLocalKMS()
+		if err != nil {
+			return nil, err
+		}
+		k.path = path
+		return k, k.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	k := &LocalKMS{path: path, active: file.Active, keys: make(map[string][]byte, len(file.Keys))}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != envelope.KeySize {
+			return nil, fmt.Errorf("invalid master key %s", id)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[k.active]; !ok {
+		return nil, fmt.Errorf("active master key %q not in key file", file.Active)
+	}
+	return k, nil
+}
+
+// NewLocalKMS uses a single base64-encoded master key, as passed in the environment
+// The key ID is derived from the key, so data keys wrapped with it are found again.
+func NewLocalKMS(encodedKey string) (*LocalKMS, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != envelope.KeySize {
+		return nil, fmt.Errorf("master key must be %d base64-encoded bytes", envelope.KeySize)
+	}
+	id := keyID(key)
+	return &LocalKMS{active: id, keys: map[string][]byte{id: key}}, nil
+}
+
+// GenerateLocalKMS creates an in-memory key manager with a new master key
+func GenerateLocalKMS() (*LocalKMS, error) {
+	key, err := envelope.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	id := keyID(key)
+	return &LocalKMS{active: id, keys: map[string][]byte{id: key}}, nil
+}
+
+// ActiveKeyID returns the ID of the master key WrapKey uses
+func (k *LocalKMS) ActiveKeyID(ctx context.Context) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active, nil
+}
+
+// WrapKey encrypts a data key with the active master key
+func (k *LocalKMS) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	wrapped, err := envelope.Seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return k.active, wrapped, nil
+}
+
+// UnwrapKey decrypts a data key wrapped with any master key still held
+func (k *LocalKMS) UnwrapKey(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", masterKeyID)
+	}
+	dataKey, err := envelope.Open(key, wrapped, []byte(masterKeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Rotate makes a new master key active and returns its ID
+// Previous keys are kept to unwrap data keys until they are re-wrapped. Keys not
+// loaded from a key file cannot be rotated (ErrNotPersisted).
+func (k *LocalKMS) Rotate() (string, error) {
+	if !k.persisted() {
+		return "", ErrNotPersisted
+	}
+	key, err := envelope.NewKey()
+	if err != nil {
+		return "", err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := keyID(key)
+	k.keys[id] = key
+	previous := k.active
+	k.active = id
+	if err := k.save(); err != nil {
+		delete(k.keys, id)
+		k.active = previous
+		return "", err
+	}
+	return id, nil
+}
+
+// Retire forgets a master key once no data key is wrapped with it
+func (k *LocalKMS) Retire(masterKeyID string) error {
+	if !k.persisted() {
+		return ErrNotPersisted
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if masterKeyID == k.active {
+		return errors.New("cannot retire the active master key")
+	}
+	key, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil
+	}
+	delete(k.keys, masterKeyID)
+	if err := k.save(); err != nil {
+		k.keys[masterKeyID] = key
+		return err
+	}
+	return nil
+}
+
+// persisted reports whether key changes are written to a key file
+func (k *LocalKMS) persisted() bool {
+	return k.path != ""
+}
+
+// save writes the keys to the key file, readable by the owner only; callers hold the
+// write lock (or own k exclusively)
+func (k *LocalKMS) save() error {
+	if k.path == "" {
+		return nil
+	}
+	file := keyFile{Active: k.active, Keys: make(map[string]string, len(k.keys))}
+	for id, key := range k.keys {
+		file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key file: %w", err)
+	}
+	// Written aside then renamed, so a crash never leaves a truncated key file
+	tmp := fmt.Sprintf("%s.%d.tmp", k.path, time.Now().UnixNano())
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
+
+// keyID derives a master key ID from its fingerprint
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "local-" + hex.EncodeToString(sum[:6])
+}
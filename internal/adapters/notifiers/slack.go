@@ -0,0 +1,89 @@
+// Package notifiers implements ports.Notifier for chat and email channels
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// SlackNotifier posts notifications to Slack incoming webhooks
+type SlackNotifier struct {
+	client *http.Client
+}
+
+// NewSlackNotifier creates a Slack notifier giving up on a request after timeout
+func NewSlackNotifier(timeout time.Duration) *SlackNotifier {
+	return &SlackNotifier{client: &http.Client{Timeout: timeout}}
+}
+
+// Channel returns the channel name
+func (n *SlackNotifier) Channel() string {
+	return domain.ChannelSlack
+}
+
+// Send posts the notification as a colored attachment to the incoming webhook URL
+func (n *SlackNotifier) Send(ctx context.Context, webhookURL string, notification domain.Notification) error {
+	message := map[string]interface{}{
+		"text": escapeSlack(notification.Title), // Shown in push notifications
+		"attachments": []map[string]interface{}{{
+			"color":     severityColor(notification.Severity),
+			"title":     escapeSlack(notification.Title),
+			"text":      escapeSlack(notification.Text),
+			"mrkdwn_in": []string{"text"},
+		}},
+	}
+	return postJSON(ctx, n.client, webhookURL, message)
+}
+
+// escapeSlack escapes the characters Slack treats as markup
+func escapeSlack(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// severityColor returns a hex color for a risk level
+func severityColor(severity string) string {
+	switch severity {
+	case "critical":
+		return "#B00020"
+	case "high":
+		return "#E65100"
+	case "medium":
+		return "#F9A825"
+	default:
+		return "#757575"
+	}
+}
+
+// postJSON posts a JSON document and fails on non-2xx responses
+func postJSON(ctx context.Context, client *http.Client, url string, document interface{}) error {
+	body, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post message: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) // Lets the connection be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
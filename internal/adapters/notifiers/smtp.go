@@ -0,0 +1,97 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// SMTPConfig configures the SMTP relay used for email notifications
+type SMTPConfig struct {
+	Addr     string // host:port, e.g. "smtp.example.com:587"
+	Username string // Empty disables authentication
+	Password string
+	From     string // e.g. "Email Security <alerts@example.com>"
+}
+
+// SMTPNotifier emails notifications through an SMTP relay
+// net/smtp upgrades to TLS with STARTTLS when the server offers it; PLAIN
+// authentication is refused by net/smtp over unencrypted connections to remote hosts.
+type SMTPNotifier struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPNotifier creates an email notifier
+func NewSMTPNotifier(cfg SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{cfg: cfg}
+}
+
+// Channel returns the channel name
+func (n *SMTPNotifier) Channel() string {
+	return domain.ChannelEmail
+}
+
+// Send emails the notification as plain text to the recipient address
+// In production, a context-aware client (or a transactional email API) would let
+// ctx cancel a slow relay; net/smtp blocks until the exchange completes.
+func (n *SMTPNotifier) Send(ctx context.Context, recipient string, notification domain.Notification) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(n.cfg.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %w", n.cfg.Addr, err)
+		}
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+
+	from, err := envelopeAddress(n.cfg.From)
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(n.cfg.Addr, auth, from, []string{recipient}, n.message(recipient, notification)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// message builds the RFC 5322 message
+func (n *SMTPNotifier) message(recipient string, notification domain.Notification) []byte {
+	priority := "3"
+	if domain.RiskLevelAtLeast(notification.Severity, "high") {
+		priority = "1"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notification.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "X-Priority: %s\r\n", priority)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	// Lines starting with a dot are escaped by the SMTP client's data writer
+	b.WriteString(strings.ReplaceAll(notification.Text, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// envelopeAddress extracts the bare address of a From header for the SMTP envelope
+func envelopeAddress(from string) (string, error) {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+	return address.Address, nil
+}
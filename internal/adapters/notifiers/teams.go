@@ -0,0 +1,40 @@
+package notifiers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// TeamsNotifier posts notifications to Microsoft Teams incoming webhook connectors
+type TeamsNotifier struct {
+	client *http.Client
+}
+
+// NewTeamsNotifier creates a Teams notifier giving up on a request after timeout
+func NewTeamsNotifier(timeout time.Duration) *TeamsNotifier {
+	return &TeamsNotifier{client: &http.Client{Timeout: timeout}}
+}
+
+// Channel returns the channel name
+func (n *TeamsNotifier) Channel() string {
+	return domain.ChannelTeams
+}
+
+// Send posts the notification as a MessageCard to the connector URL
+// Teams renders card text as markdown, where single line breaks are ignored, so
+// lines are joined with explicit breaks.
+func (n *TeamsNotifier) Send(ctx context.Context, connectorURL string, notification domain.Notification) error {
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    notification.Title,
+		"themeColor": strings.TrimPrefix(severityColor(notification.Severity), "#"),
+		"title":      notification.Title,
+		"text":       strings.ReplaceAll(notification.Text, "\n", "  \n"),
+	}
+	return postJSON(ctx, n.client, connectorURL, card)
+}
@@ -0,0 +1,258 @@
+// Package oauth implements ports.TokenExchanger for the Microsoft identity platform
+// and Google OAuth, and a fake token endpoint for tests and local runs
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/consent"
+	"github.com/stoik/email-security/internal/domain/resilience"
+)
+
+// Provider token endpoints
+const (
+	MicrosoftTokenURL = "https://login.microsoftonline.com/%s/oauth2/v2.0/token" // %s: directory ID
+	GoogleTokenURL    = "https://oauth2.googleapis.com/token"
+	GoogleRevokeURL   = "https://oauth2.googleapis.com/revoke"
+)
+
+// jwtBearerGrant is the grant type of service account assertions (RFC 7523)
+const jwtBearerGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// authErrors are the token endpoint error codes meaning the tenant must reauthorize
+var authErrors = map[string]bool{
+	"invalid_grant":        true, // Refresh token or delegation revoked
+	"invalid_client":       true, // Client secret expired or deleted
+	"unauthorized_client":  true, // Application removed from the directory
+	"consent_required":     true,
+	"interaction_required": true,
+}
+
+// TokenClient exchanges tenant credentials for access tokens
+type TokenClient struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewTokenClient creates a token client giving up on a request after timeout
+func NewTokenClient(timeout time.Duration) *TokenClient {
+	return &TokenClient{client: &http.Client{Timeout: timeout}, now: time.Now}
+}
+
+// tokenResponse is the token endpoint response (RFC 6749 section 5)
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange obtains an access token with the credentials' grant
+// Graph uses client credentials, Google a service account assertion impersonating
+// creds.Subject (domain-wide delegation); both accept refresh tokens.
+func (c *TokenClient) Exchange(ctx context.Context, provider domain.Provider, creds domain.TenantCredentials) (domain.AccessToken, error) {
+	tokenURL := c.tokenURL(provider, creds)
+	scopes := creds.Scopes
+	if len(scopes) == 0 {
+		scopes = consent.DefaultScopes(provider)
+	}
+
+	form := url.Values{}
+	switch creds.Grant {
+	case domain.GrantClientCredentials:
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("scope", strings.Join(scopes, " "))
+	case domain.GrantJWTBearer:
+		assertion, err := c.assertion(creds, scopes, tokenURL)
+		if err != nil {
+			return domain.AccessToken{}, err
+		}
+		form.Set("grant_type", jwtBearerGrant)
+		form.Set("assertion", assertion)
+	case domain.GrantRefreshToken:
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		if creds.ClientSecret != "" {
+			form.Set("client_secret", creds.ClientSecret)
+		}
+		form.Set("refresh_token", creds.RefreshToken)
+		form.Set("scope", strings.Join(scopes, " "))
+	default:
+		return domain.AccessToken{}, fmt.Errorf("unknown grant %q", creds.Grant)
+	}
+
+	return c.post(ctx, tokenURL, form)
+}
+
+// ExchangeCode redeems the authorization code of a consent callback
+// The returned token carries the refresh token granted with offline access.
+func (c *TokenClient) ExchangeCode(ctx context.Context, provider domain.Provider, app domain.OAuthApp, code, redirectURL string) (domain.AccessToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", app.ClientID)
+	form.Set("client_secret", app.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+
+	tokenURL := c.tokenURL(provider, domain.TenantCredentials{TokenURL: app.TokenURL})
+	token, err := c.post(ctx, tokenURL, form)
+	if err != nil {
+		return token, err
+	}
+	if token.RefreshToken == "" {
+		return token, errors.New("authorization granted no refresh token")
+	}
+	return token, nil
+}
+
+// Revoke withdraws the grant of a tenant's credentials
+// Google refresh tokens are revoked at the revocation endpoint. Application
+// permissions (Graph client credentials, Google domain-wide delegation) cannot be
+// revoked by the application: they end when the administrator removes it.
+func (c *TokenClient) Revoke(ctx context.Context, provider domain.Provider, creds domain.TenantCredentials) error {
+	if provider != domain.ProviderGoogle || creds.Grant != domain.GrantRefreshToken {
+		return nil
+	}
+	revokeURL := GoogleRevokeURL
+	if creds.TokenURL != "" {
+		revokeURL = strings.TrimSuffix(creds.TokenURL, "/token") + "/revoke"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(url.Values{"token": {creds.RefreshToken}}.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build revocation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: revocation request failed: %v", domain.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// An already revoked or expired token is answered with invalid_token: nothing to revoke
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil
+	}
+	return resilience.FromHTTP(resp.StatusCode, resp.Header.Get("Retry-After"), "", c.now())
+}
+
+// tokenURL returns the endpoint for the credentials
+func (c *TokenClient) tokenURL(provider domain.Provider, creds domain.TenantCredentials) string {
+	if creds.TokenURL != "" {
+		return creds.TokenURL
+	}
+	if provider == domain.ProviderMicrosoft {
+		directory := creds.DirectoryID
+		if directory == "" {
+			directory = "common"
+		}
+		return fmt.Sprintf(MicrosoftTokenURL, url.PathEscape(directory))
+	}
+	return GoogleTokenURL
+}
+
+// post sends a token request and classifies its failure
+func (c *TokenClient) post(ctx context.Context, tokenURL string, form url.Values) (domain.AccessToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return domain.AccessToken{}, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	requested := c.now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return domain.AccessToken{}, fmt.Errorf("%w: token request failed: %v", domain.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var body tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil && resp.StatusCode < 400 {
+		return domain.AccessToken{}, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		if authErrors[body.Error] {
+			return domain.AccessToken{}, fmt.Errorf("%w: %s: %s", domain.ErrAuthExpired, body.Error, body.ErrorDescription)
+		}
+		if err := resilience.FromHTTP(resp.StatusCode, resp.Header.Get("Retry-After"), body.Error, c.now()); err != nil {
+			return domain.AccessToken{}, fmt.Errorf("token request failed: %w", err)
+		}
+	}
+	if body.AccessToken == "" {
+		return domain.AccessToken{}, errors.New("token response has no access token")
+	}
+
+	return domain.AccessToken{
+		Value:        body.AccessToken,
+		ExpiresAt:    requested.Add(time.Duration(body.ExpiresIn) * time.Second),
+		RefreshToken: body.RefreshToken,
+	}, nil
+}
+
+// assertion returns a service account JWT signed with RS256, valid for an hour
+func (c *TokenClient) assertion(creds domain.TenantCredentials, scopes []string, audience string) (string, error) {
+	key, err := parsePrivateKey(creds.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	issued := c.now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   creds.ServiceAccount,
+		"sub":   creds.Subject,
+		"scope": strings.Join(scopes, " "),
+		"aud":   audience,
+		"iat":   issued.Unix(),
+		"exp":   issued.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode assertion: %w", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign assertion: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey parses a PEM-encoded PKCS#8 (service account key files) or PKCS#1 RSA key
+func parsePrivateKey(encoded string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("private key is not PEM-encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
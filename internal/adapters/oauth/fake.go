@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeTokenServer is a local OAuth token endpoint for tests and local runs
+//
+// It issues opaque tokens for any well-formed request (assertion signatures are not
+// verified) and rotates refresh tokens like the Microsoft identity platform: once a
+// client refreshed, only the latest refresh token is accepted. Revoke simulates an
+// administrator withdrawing consent. Each authorization code starts a grant of its
+// own, so tenants consenting to the same client ID are revoked separately: requests
+// to a path ending in /revoke revoke the grant of the posted refresh token, like
+// Google's revocation endpoint.
+type FakeTokenServer struct {
+	mu          sync.Mutex
+	lifetime    time.Duration
+	issued      int
+	revoked     map[string]bool   // Client IDs, service accounts and grants
+	refresh     map[string]string // Latest refresh token per client ID or grant
+	grants      map[string]string // Grant of every refresh token issued
+	unavailable int               // Requests still answered with 503
+}
+
+// NewFakeTokenServer creates a fake token endpoint issuing tokens valid for lifetime
+func NewFakeTokenServer(lifetime time.Duration) *FakeTokenServer {
+	return &FakeTokenServer{
+		lifetime: lifetime,
+		revoked:  make(map[string]bool),
+		refresh:  make(map[string]string),
+		grants:   make(map[string]string),
+	}
+}
+
+// Revoke makes requests of a client ID or service account fail with invalid_grant
+func (s *FakeTokenServer) Revoke(principal string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[principal] = true
+}
+
+// Unavailable answers the next n requests with 503
+func (s *FakeTokenServer) Unavailable(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = n
+}
+
+// Issued returns the number of access tokens issued
+func (s *FakeTokenServer) Issued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// ServeHTTP answers token requests
+func (s *FakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unavailable > 0 {
+		s.unavailable--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/revoke") {
+		s.revokeToken(w, r.PostForm.Get("token"))
+		return
+	}
+
+	principal, err := requestPrincipal(r)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	grantType := r.PostForm.Get("grant_type")
+	grant := principal
+	switch grantType {
+	case "authorization_code":
+		grant = principal + "/" + r.PostForm.Get("code")
+	case "refresh_token":
+		if issuedTo, ok := s.grants[r.PostForm.Get("refresh_token")]; ok {
+			grant = issuedTo
+		}
+	}
+	if s.revoked[principal] || s.revoked[grant] {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "AADSTS65001: the user or administrator has not consented to use the application")
+		return
+	}
+
+	refreshing := grantType == "refresh_token"
+	if latest, ok := s.refresh[grant]; refreshing && ok && latest != r.PostForm.Get("refresh_token") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "refresh token was already redeemed")
+		return
+	}
+
+	s.issued++
+	response := tokenResponse{
+		AccessToken: fmt.Sprintf("fake-access-token-%d", s.issued),
+		ExpiresIn:   int(s.lifetime / time.Second),
+	}
+	if refreshing || grantType == "authorization_code" {
+		response.RefreshToken = fmt.Sprintf("fake-refresh-token-%d", s.issued)
+		s.refresh[grant] = response.RefreshToken
+		s.grants[response.RefreshToken] = grant
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// revokeToken revokes the grant a refresh token was issued for; callers hold s.mu
+func (s *FakeTokenServer) revokeToken(w http.ResponseWriter, token string) {
+	grant, ok := s.grants[token]
+	if !ok {
+		writeTokenError(w, http.StatusBadRequest, "invalid_token", "unknown token")
+		return
+	}
+	s.revoked[grant] = true
+	w.WriteHeader(http.StatusOK)
+}
+
+// requestPrincipal returns the client ID or service account a request is for
+func requestPrincipal(r *http.Request) (string, error) {
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials", "refresh_token", "authorization_code":
+		if clientID := r.PostForm.Get("client_id"); clientID != "" {
+			return clientID, nil
+		}
+		return "", fmt.Errorf("missing client_id")
+	case jwtBearerGrant:
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			return "", fmt.Errorf("malformed assertion")
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", fmt.Errorf("malformed assertion: %w", err)
+		}
+		var claims struct {
+			Issuer string `json:"iss"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil || claims.Issuer == "" {
+			return "", fmt.Errorf("assertion has no issuer")
+		}
+		return claims.Issuer, nil
+	default:
+		return "", fmt.Errorf("unsupported grant_type %q", r.PostForm.Get("grant_type"))
+	}
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(tokenResponse{Error: code, ErrorDescription: description})
+}
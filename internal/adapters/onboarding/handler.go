@@ -0,0 +1,224 @@
+// Package onboarding is the HTTP API of the tenant lifecycle
+//
+// Routes:
+//
+//	POST /tenants                   Create a tenant ({"name", "provider"}), returns its consent URL
+//	POST /tenants/{id}/consent      New consent URL, for a pending or reauthorizing tenant
+//	POST /tenants/{id}/suspend      Stop provider calls
+//	POST /tenants/{id}/resume       Resume a suspended tenant
+//	POST /tenants/{id}/offboard     Revoke the tenant's grant and purge its data
+//	GET  /consent/callback          Provider consent redirect
+//
+// Tenant routes require the admin token as a bearer token. The consent callback is
+// public: it is authenticated by its signed state.
+package onboarding
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// Routes served by the handler
+const (
+	TenantsPath  = "/tenants"
+	CallbackPath = "/consent/callback"
+)
+
+// maxBody bounds request bodies
+const maxBody = 1 << 16
+
+// Config configures the handler
+type Config struct {
+	// AdminToken authenticates tenant routes; when empty they are refused
+	AdminToken string
+
+	// Timeout bounds the handling of one request (default: 30s, consent exchanges
+	// tokens and syncs the directory)
+	Timeout time.Duration
+}
+
+// Handler serves the onboarding API
+type Handler struct {
+	service ports.TenantOnboarding
+	cfg     Config
+	mux     *http.ServeMux
+}
+
+// New creates a handler managing tenants through service
+func New(service ports.TenantOnboarding, cfg Config) *Handler {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	h := &Handler{service: service, cfg: cfg, mux: http.NewServeMux()}
+	h.mux.HandleFunc(TenantsPath, h.admin(h.handleCreate))
+	h.mux.HandleFunc(TenantsPath+"/", h.admin(h.handleTenant))
+	h.mux.HandleFunc(CallbackPath, h.handleCallback)
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+// tenantResponse is a tenant with, while it awaits consent, its consent URL
+type tenantResponse struct {
+	*domain.Tenant
+	ConsentURL string `json:"consent_url,omitempty"`
+}
+
+// handleCreate creates a tenant pending consent
+func (h *Handler) handleCreate(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Name     string          `json:"name"`
+		Provider domain.Provider `json:"provider"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBody)).Decode(&body); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Name == "" || (body.Provider != domain.ProviderMicrosoft && body.Provider != domain.ProviderGoogle) {
+		http.Error(w, "name and provider (microsoft or google) are required", http.StatusBadRequest)
+		return
+	}
+
+	tenant := &domain.Tenant{Name: body.Name, Provider: body.Provider}
+	consentURL, err := h.service.StartOnboarding(ctx, tenant)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tenantResponse{Tenant: tenant, ConsentURL: consentURL})
+}
+
+// handleTenant dispatches /tenants/{id}/{action}
+func (h *Handler) handleTenant(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, action, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, TenantsPath+"/"), "/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	tenantID, err := uuid.Parse(id)
+	if err != nil {
+		http.Error(w, "invalid tenant ID", http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "consent":
+		consentURL, err := h.service.ConsentURL(ctx, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"consent_url": consentURL})
+	case "suspend", "resume":
+		update := h.service.Suspend
+		if action == "resume" {
+			update = h.service.Resume
+		}
+		tenant, err := update(ctx, tenantID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tenantResponse{Tenant: tenant})
+	case "offboard":
+		if err := h.service.Offboard(ctx, tenantID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// handleCallback completes a consent
+// Microsoft admin consent redirects with tenant (the directory ID) and
+// admin_consent=True; Google with code. Both send error and error_description on
+// refusal.
+func (h *Handler) handleCallback(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	callback := domain.ConsentCallback{
+		State:            query.Get("state"),
+		Code:             query.Get("code"),
+		DirectoryID:      query.Get("tenant"),
+		AdminConsent:     strings.EqualFold(query.Get("admin_consent"), "True"),
+		Error:            query.Get("error"),
+		ErrorDescription: query.Get("error_description"),
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), h.cfg.Timeout)
+	defer cancel()
+	tenant, err := h.service.CompleteConsent(ctx, callback)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tenantResponse{Tenant: tenant})
+}
+
+// admin authenticates a tenant route with the admin bearer token
+func (h *Handler) admin(next func(ctx context.Context, w http.ResponseWriter, req *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || h.cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), h.cfg.Timeout)
+		defer cancel()
+		next(ctx, w, req)
+	}
+}
+
+// writeError answers with the status matching a service error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, domain.ErrTenantNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, domain.ErrInvalidTransition):
+		status = http.StatusConflict
+	case errors.Is(err, domain.ErrInvalidConsentState), errors.Is(err, domain.ErrConsentDenied), errors.Is(err, domain.ErrDirectoryMismatch):
+		status = http.StatusBadRequest
+	case errors.Is(err, domain.ErrAuthExpired):
+		status = http.StatusUnauthorized // The provider refused the granted credentials
+	}
+	if status == http.StatusInternalServerError {
+		log.Printf("Onboarding request failed: %v", err)
+		http.Error(w, "internal error", status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
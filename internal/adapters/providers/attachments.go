@@ -0,0 +1,30 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// attachment is a file attached to a message, with its decoded content
+// Graph returns it in the contentBytes of GET /messages/{id}/attachments, Gmail in
+// users.messages.attachments.get (base64url).
+type attachment struct {
+	name    string
+	content []byte
+}
+
+// withAttachments records a message's attachments on its email: their names, and
+// the SHA-256 of their content that retro-hunts match. The content itself is not
+// kept.
+func withAttachments(email *domain.Email, attachments ...attachment) {
+	email.HasAttachments = len(attachments) > 0
+	email.AttachmentNames = make([]string, len(attachments))
+	email.AttachmentHashes = make([]string, len(attachments))
+	for i, a := range attachments {
+		sum := sha256.Sum256(a.content)
+		email.AttachmentNames[i] = a.name
+		email.AttachmentHashes[i] = hex.EncodeToString(sum[:])
+	}
+}
@@ -2,25 +2,38 @@ package providers
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/mail"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
 )
 
 // GoogleClient implements ports.EmailProvider for Gmail API
 // For this prototype: returns mock data to demonstrate the pipeline
-type GoogleClient struct{}
+// Requests are authorized with the tenant's access token from tokens.
+type GoogleClient struct {
+	tokens ports.TokenSource
+}
 
 // NewGoogleClient creates a new client
-func NewGoogleClient() *GoogleClient {
-	return &GoogleClient{}
+func NewGoogleClient(tokens ports.TokenSource) *GoogleClient {
+	return &GoogleClient{tokens: tokens}
 }
 
 // GetUsers fetches all users for a tenant from Google Workspace Directory API
+// In production, this pages through GET /admin/directory/v1/users?customer=my_customer&projection=full
+// (organizations[].title and department, relations[type=manager], suspended) and reads
+// each user's groups with GET /admin/directory/v1/groups?userKey={id}. The manager is
+// reported by address.
 func (c *GoogleClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	if err := c.authorize(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
 	// Mock implementation - returns sample users
 
 	users := []domain.User{
@@ -31,6 +44,20 @@ func (c *GoogleClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]doma
 			Email:          "alice@example.com",
 			DisplayName:    "Alice Johnson",
 			Role:           "CEO",
+			Department:     "Executive",
+			Groups:         []string{"Executives"},
+			CreatedAt:      time.Now(),
+		},
+		{
+			ID:             uuid.New(),
+			TenantID:       tenantID,
+			ProviderUserID: "google-user-2",
+			Email:          "bob.martin@example.com",
+			DisplayName:    "Bob Martin",
+			Role:           "Finance Director",
+			Department:     "Finance",
+			Groups:         []string{"Finance"},
+			ManagerRef:     "alice@example.com",
 			CreatedAt:      time.Now(),
 		},
 	}
@@ -40,12 +67,16 @@ func (c *GoogleClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]doma
 
 // GetEmails fetches emails for a specific user from Gmail API
 // In production, we'd batch message.get requests and use goroutines for concurrency.
-func (c *GoogleClient) GetEmails(ctx context.Context, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+func (c *GoogleClient) GetEmails(ctx context.Context, tenantID, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+	if err := c.authorize(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
 	// Mock implementation - returns sample email with typosquatting + reply-to mismatch
 
 	email := domain.Email{
 		ID:                uuid.New(),
-		TenantID:          uuid.UUID{}, // Will be set by application layer
+		TenantID:          tenantID,
 		UserID:            userID,
 		ProviderMessageID: "gmail-msg-001",
 		Subject:           "Invoice #4821 - Payment Required",
@@ -53,13 +84,14 @@ func (c *GoogleClient) GetEmails(ctx context.Context, userID uuid.UUID, received
 		SenderName:        "accounts@companny.com",
 		RecipientEmail:    "alice@example.com",
 		ReceivedAt:        time.Now().Add(-2 * time.Hour),
-		HasAttachments:    false,
 		BodyPreview:       "Please find attached invoice for immediate payment. Wire transfer to the new account urgently.",
 		Headers: map[string]string{
 			"Reply-To": "urgent-payments@gmail.com", // Reply-to mismatch indicator
 		},
 		IngestedAt: time.Now(),
 	}
+	// Attachment parts are fetched by attachment ID and hashed, never stored
+	withAttachments(&email, attachment{name: "Invoice_4821.pdf", content: []byte("%PDF-1.4 invoice 4821")})
 
 	return []domain.Email{email}, nil
 }
@@ -74,3 +106,12 @@ func extractEmail(s string) string {
 	}
 	return addr.Address
 }
+
+// authorize obtains the tenant's access token
+// In production, it is sent as "Authorization: Bearer <token>" on every request.
+func (c *GoogleClient) authorize(ctx context.Context, tenantID uuid.UUID) error {
+	if _, err := c.tokens.Token(ctx, tenantID); err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	return nil
+}
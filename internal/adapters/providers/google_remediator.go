@@ -0,0 +1,81 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// gmailQuarantineLabel is the label quarantined messages get instead of INBOX
+// In production, it would be created on onboarding in each mailbox (users.labels.create).
+const gmailQuarantineLabel = "Email Security/Quarantine"
+
+// GoogleRemediator implements ports.MailboxRemediator for Gmail API
+// For this prototype: logs the Gmail requests it would send and returns their restore state
+type GoogleRemediator struct{}
+
+// NewGoogleRemediator creates a new Gmail remediator
+// In production, it needs the gmail.modify scope through domain-wide delegation.
+func NewGoogleRemediator() *GoogleRemediator {
+	return &GoogleRemediator{}
+}
+
+// Apply performs an action on a message through the Gmail API
+// Gmail has no folders: moves swap labels, and delete uses the trash (untrash restores).
+func (r *GoogleRemediator) Apply(ctx context.Context, message domain.MessageRef, action, label string) (string, domain.MessageRef, error) {
+	path := fmt.Sprintf("/gmail/v1/users/%s/messages/%s", message.Mailbox, message.MessageID)
+
+	switch action {
+	case domain.RemediationQuarantine:
+		log.Printf("[gmail] POST %s/modify {\"addLabelIds\": [%q], \"removeLabelIds\": [\"INBOX\"]}", path, gmailQuarantineLabel)
+		return "INBOX", message, nil
+	case domain.RemediationJunk:
+		log.Printf("[gmail] POST %s/modify {\"addLabelIds\": [\"SPAM\"], \"removeLabelIds\": [\"INBOX\"]}", path)
+		return "INBOX", message, nil
+	case domain.RemediationDelete:
+		log.Printf("[gmail] POST %s/trash", path)
+		return "", message, nil
+	case domain.RemediationLabel:
+		log.Printf("[gmail] POST %s/modify {\"addLabelIds\": [%q]}", path, label)
+		return "", message, nil
+	case domain.RemediationBanner:
+		// Gmail messages are immutable: a copy with the banner is inserted
+		// (messages.insert, same threadId and labels) and the original trashed.
+		// Both IDs are the restore state; later actions target the copy.
+		log.Printf("[gmail] POST /gmail/v1/users/%s/messages (copy with banner), POST %s/trash", message.Mailbox, path)
+		copyID := uuid.NewString() // Mock: the ID messages.insert returns
+		bannered := domain.MessageRef{Mailbox: message.Mailbox, MessageID: copyID}
+		return bannerState{Original: message.MessageID, Copy: copyID}.encode(), bannered, nil
+	default:
+		return "", message, fmt.Errorf("unsupported remediation action %q", action)
+	}
+}
+
+// Restore undoes an action through the Gmail API
+func (r *GoogleRemediator) Restore(ctx context.Context, message domain.MessageRef, action, label, restoreState string) error {
+	path := fmt.Sprintf("/gmail/v1/users/%s/messages/%s", message.Mailbox, message.MessageID)
+
+	switch action {
+	case domain.RemediationQuarantine:
+		log.Printf("[gmail] POST %s/modify {\"addLabelIds\": [%q], \"removeLabelIds\": [%q]}", path, restoreState, gmailQuarantineLabel)
+	case domain.RemediationJunk:
+		log.Printf("[gmail] POST %s/modify {\"addLabelIds\": [%q], \"removeLabelIds\": [\"SPAM\"]}", path, restoreState)
+	case domain.RemediationDelete:
+		log.Printf("[gmail] POST %s/untrash", path)
+	case domain.RemediationLabel:
+		log.Printf("[gmail] POST %s/modify {\"removeLabelIds\": [%q]}", path, label)
+	case domain.RemediationBanner:
+		state, err := decodeBannerState(restoreState)
+		if err != nil {
+			return err
+		}
+		log.Printf("[gmail] POST /gmail/v1/users/%s/messages/%s/untrash, DELETE /gmail/v1/users/%s/messages/%s",
+			message.Mailbox, state.Original, message.Mailbox, state.Copy)
+	default:
+		return fmt.Errorf("unsupported remediation action %q", action)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/push"
+)
+
+// gmailWatchLifetime is how long a Gmail watch lasts (Google recommends renewing daily)
+const gmailWatchLifetime = 7 * 24 * time.Hour
+
+// GoogleSubscriber implements ports.MailSubscriber for Gmail push notifications
+// For this prototype: logs the Gmail requests it would send and returns mock data
+type GoogleSubscriber struct {
+	topic string
+}
+
+// NewGoogleSubscriber creates a subscriber publishing mailbox changes to a Pub/Sub topic
+// In production, the topic's push subscription targets the receiver's Gmail endpoint,
+// and gmail-api-push@system.gserviceaccount.com may publish to the topic.
+func NewGoogleSubscriber(topic string) *GoogleSubscriber {
+	return &GoogleSubscriber{topic: topic}
+}
+
+// Subscribe starts a Gmail watch on the mailbox's inbox
+// A mailbox has a single watch, so its address is the subscription ID; the history
+// ID returned by watch is where change fetching starts.
+func (s *GoogleSubscriber) Subscribe(ctx context.Context, mailbox, clientState string) (domain.MailSubscription, error) {
+	log.Printf("[gmail] POST /gmail/v1/users/%s/watch {\"topicName\": %q, \"labelIds\": [\"INBOX\"]}", mailbox, s.topic)
+	return domain.MailSubscription{
+		Provider:   domain.ProviderGoogle,
+		Mailbox:    mailbox,
+		ExternalID: mailbox,
+		Cursor:     strconv.FormatInt(time.Now().Unix(), 10),
+		ExpiresAt:  time.Now().Add(gmailWatchLifetime),
+	}, nil
+}
+
+// Renew calls watch again, which extends the existing watch
+func (s *GoogleSubscriber) Renew(ctx context.Context, sub domain.MailSubscription) (time.Time, error) {
+	log.Printf("[gmail] POST /gmail/v1/users/%s/watch {\"topicName\": %q, \"labelIds\": [\"INBOX\"]}", sub.Mailbox, s.topic)
+	return time.Now().Add(gmailWatchLifetime), nil
+}
+
+// Unsubscribe stops the mailbox's watch
+func (s *GoogleSubscriber) Unsubscribe(ctx context.Context, sub domain.MailSubscription) error {
+	log.Printf("[gmail] POST /gmail/v1/users/%s/stop", sub.Mailbox)
+	return nil
+}
+
+// FetchChanges lists the messages added since the subscription's cursor
+// Notifications can arrive out of order: one older than the cursor has nothing new.
+func (s *GoogleSubscriber) FetchChanges(ctx context.Context, sub domain.MailSubscription, change domain.MailChange) ([]domain.Email, string, error) {
+	if change.HistoryID == "" {
+		return nil, "", fmt.Errorf("gmail notification has no history ID")
+	}
+	if !push.HistoryAfter(change.HistoryID, sub.Cursor) {
+		return nil, sub.Cursor, nil
+	}
+	log.Printf("[gmail] GET /gmail/v1/users/%s/history?startHistoryId=%s&historyTypes=messageAdded&labelId=INBOX", sub.Mailbox, sub.Cursor)
+
+	// Mock implementation - one message added, fetched with messages.get (format=metadata)
+	email := domain.Email{
+		ID:                uuid.New(),
+		ProviderMessageID: "gmail-" + change.HistoryID,
+		Subject:           "Quick favor - are you available?",
+		SenderEmail:       "ceo.office@gmail.com",
+		SenderName:        "Alice Johnson",
+		RecipientEmail:    sub.Mailbox,
+		ReceivedAt:        time.Now(),
+		BodyPreview:       "I need you to handle a confidential task for me today, reply when you see this...",
+		Headers:           make(map[string]string),
+		IngestedAt:        time.Now(),
+	}
+	return []domain.Email{email}, change.HistoryID, nil
+}
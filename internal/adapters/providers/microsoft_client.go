@@ -2,26 +2,50 @@ package providers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
 )
 
 // MicrosoftClient implements ports.EmailProvider for Microsoft Graph API
 // For this prototype: returns mock data to demonstrate the pipeline
-type MicrosoftClient struct{}
+// Requests are authorized with the tenant's access token from tokens.
+type MicrosoftClient struct {
+	tokens ports.TokenSource
+}
 
 // NewMicrosoftClient creates a new Microsoft Graph API client
-func NewMicrosoftClient() *MicrosoftClient {
-	return &MicrosoftClient{}
+func NewMicrosoftClient(tokens ports.TokenSource) *MicrosoftClient {
+	return &MicrosoftClient{tokens: tokens}
 }
 
 // GetUsers fetches all users for a tenant from Microsoft Graph API
+// In production, this pages through
+// GET /users?$select=id,mail,displayName,jobTitle,department,accountEnabled&$expand=manager($select=id)
+// and reads each user's groups with GET /users/{id}/transitiveMemberOf/microsoft.graph.group?$select=displayName.
+// The manager is reported by Graph user ID.
 func (c *MicrosoftClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	if err := c.authorize(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
 	// Mock implementation - returns sample users
 
 	users := []domain.User{
+		{
+			ID:             uuid.New(),
+			TenantID:       tenantID,
+			ProviderUserID: "user-3",
+			Email:          "john.smith@company.com",
+			DisplayName:    "John Smith",
+			Role:           "CEO",
+			Department:     "Executive",
+			Groups:         []string{"Executives"},
+			CreatedAt:      time.Now(),
+		},
 		{
 			ID:             uuid.New(),
 			TenantID:       tenantID,
@@ -29,6 +53,9 @@ func (c *MicrosoftClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]d
 			Email:          "john.doe@company.com",
 			DisplayName:    "John Doe",
 			Role:           "CFO",
+			Department:     "Finance",
+			Groups:         []string{"Finance", "Executives"},
+			ManagerRef:     "user-3",
 			CreatedAt:      time.Now(),
 		},
 		{
@@ -38,6 +65,22 @@ func (c *MicrosoftClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]d
 			Email:          "jane.smith@company.com",
 			DisplayName:    "Jane Smith",
 			Role:           "HR Director",
+			Department:     "Human Resources",
+			Groups:         []string{"HR"},
+			ManagerRef:     "user-3",
+			CreatedAt:      time.Now(),
+		},
+		{
+			ID:             uuid.New(),
+			TenantID:       tenantID,
+			ProviderUserID: "user-4",
+			Email:          "tom.wilson@company.com",
+			DisplayName:    "Tom Wilson",
+			Role:           "Accountant",
+			Department:     "Finance",
+			Groups:         []string{"Finance"},
+			ManagerRef:     "user-1",
+			Disabled:       true, // accountEnabled: false
 			CreatedAt:      time.Now(),
 		},
 	}
@@ -46,12 +89,16 @@ func (c *MicrosoftClient) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]d
 }
 
 // GetEmails fetches emails for a specific user from Microsoft Graph API
-func (c *MicrosoftClient) GetEmails(ctx context.Context, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+func (c *MicrosoftClient) GetEmails(ctx context.Context, tenantID, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+	if err := c.authorize(ctx, tenantID); err != nil {
+		return nil, err
+	}
+
 	// Mock implementation - returns sample email with BEC indicators
 
 	email := domain.Email{
 		ID:                uuid.New(),
-		TenantID:          uuid.UUID{}, // Will be set by application layer
+		TenantID:          tenantID,
 		UserID:            userID,
 		ProviderMessageID: "msg-001",
 		Subject:           "Urgent: Wire Transfer Needed",
@@ -67,3 +114,12 @@ func (c *MicrosoftClient) GetEmails(ctx context.Context, userID uuid.UUID, recei
 
 	return []domain.Email{email}, nil
 }
+
+// authorize obtains the tenant's access token
+// In production, it is sent as "Authorization: Bearer <token>" on every request.
+func (c *MicrosoftClient) authorize(ctx context.Context, tenantID uuid.UUID) error {
+	if _, err := c.tokens.Token(ctx, tenantID); err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	return nil
+}
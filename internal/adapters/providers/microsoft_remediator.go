@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// graphQuarantineFolder is the mail folder quarantined messages are moved to
+// In production, it would be created on onboarding in each mailbox (hidden from rules).
+const graphQuarantineFolder = "EmailSecurityQuarantine"
+
+// graphBanner is the warning prepended to suspicious messages
+const graphBanner = `<div style="border:2px solid #B00020;padding:8px;margin-bottom:12px">` +
+	`<b>Warning:</b> this email shows signs of fraud. Do not reply, click links or make payments before checking with the sender by phone.</div>`
+
+// MicrosoftRemediator implements ports.MailboxRemediator for Microsoft Graph API
+// For this prototype: logs the Graph requests it would send and returns their restore state
+type MicrosoftRemediator struct{}
+
+// NewMicrosoftRemediator creates a new Microsoft Graph remediator
+// In production, it needs the Mail.ReadWrite application permission.
+func NewMicrosoftRemediator() *MicrosoftRemediator {
+	return &MicrosoftRemediator{}
+}
+
+// Apply performs an action on a message through Microsoft Graph
+func (r *MicrosoftRemediator) Apply(ctx context.Context, message domain.MessageRef, action, label string) (string, domain.MessageRef, error) {
+	path := fmt.Sprintf("/users/%s/messages/%s", message.Mailbox, message.MessageID)
+
+	switch action {
+	case domain.RemediationQuarantine, domain.RemediationJunk, domain.RemediationDelete:
+		// GET {path}?$select=parentFolderId first: the original folder is the restore state
+		destination := map[string]string{
+			domain.RemediationQuarantine: graphQuarantineFolder,
+			domain.RemediationJunk:       "junkemail",
+			domain.RemediationDelete:     "deleteditems",
+		}[action]
+		log.Printf("[graph] POST %s/move {\"destinationId\": %q}", path, destination)
+		return "inbox", message, nil
+
+	case domain.RemediationLabel:
+		// Categories are replaced as a whole: the previous list is the restore state
+		log.Printf("[graph] GET %s?$select=categories", path)
+		previous := []string{} // Mock: the message had no categories
+		categories, _ := json.Marshal(append(previous, label))
+		log.Printf("[graph] PATCH %s {\"categories\": %s}", path, categories)
+		state, _ := json.Marshal(previous)
+		return string(state), message, nil
+
+	case domain.RemediationBanner:
+		// Graph only edits the body of drafts: the message is copied with the banner
+		// prepended (POST /messages, then move) and the original moved to a hidden
+		// folder. Both IDs are the restore state; later actions target the copy.
+		log.Printf("[graph] POST /users/%s/messages (copy with %d-byte banner), POST %s/move to the hidden folder", message.Mailbox, len(graphBanner), path)
+		copyID := uuid.NewString() // Mock: the ID Graph returns for the copy
+		bannered := domain.MessageRef{Mailbox: message.Mailbox, MessageID: copyID}
+		return bannerState{Original: message.MessageID, Copy: copyID}.encode(), bannered, nil
+
+	default:
+		return "", message, fmt.Errorf("unsupported remediation action %q", action)
+	}
+}
+
+// Restore undoes an action through Microsoft Graph
+func (r *MicrosoftRemediator) Restore(ctx context.Context, message domain.MessageRef, action, label, restoreState string) error {
+	path := fmt.Sprintf("/users/%s/messages/%s", message.Mailbox, message.MessageID)
+
+	switch action {
+	case domain.RemediationQuarantine, domain.RemediationJunk, domain.RemediationDelete:
+		log.Printf("[graph] POST %s/move {\"destinationId\": %q}", path, restoreState)
+	case domain.RemediationLabel:
+		// Actions recorded before categories were saved restore to none
+		previous := restoreState
+		if previous == "" {
+			previous = "[]"
+		}
+		log.Printf("[graph] PATCH %s {\"categories\": %s}", path, previous)
+	case domain.RemediationBanner:
+		state, err := decodeBannerState(restoreState)
+		if err != nil {
+			return err
+		}
+		log.Printf("[graph] POST /users/%s/messages/%s/move {\"destinationId\": \"inbox\"}, DELETE /users/%s/messages/%s",
+			message.Mailbox, state.Original, message.Mailbox, state.Copy)
+	default:
+		return fmt.Errorf("unsupported remediation action %q", action)
+	}
+	return nil
+}
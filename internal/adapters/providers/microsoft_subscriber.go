@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// graphSubscriptionLifetime is how long Graph keeps a subscription on messages
+// (the maximum is 4230 minutes, just under 3 days)
+const graphSubscriptionLifetime = 4230 * time.Minute
+
+// MicrosoftSubscriber implements ports.MailSubscriber for Microsoft Graph change notifications
+// For this prototype: logs the Graph requests it would send and returns mock data
+type MicrosoftSubscriber struct {
+	notificationURL string
+}
+
+// NewMicrosoftSubscriber creates a subscriber whose notifications go to notificationURL
+// Graph validates the URL when a subscription is created: the receiver must echo its
+// validationToken. Lifecycle notifications go to the same URL.
+func NewMicrosoftSubscriber(notificationURL string) *MicrosoftSubscriber {
+	return &MicrosoftSubscriber{notificationURL: notificationURL}
+}
+
+// Subscribe creates a Graph subscription on the mailbox's new messages
+func (s *MicrosoftSubscriber) Subscribe(ctx context.Context, mailbox, clientState string) (domain.MailSubscription, error) {
+	expiresAt := time.Now().Add(graphSubscriptionLifetime)
+	log.Printf("[graph] POST /subscriptions {\"changeType\": \"created\", \"resource\": \"users/%s/messages\", \"notificationUrl\": %q, \"lifecycleNotificationUrl\": %q}",
+		mailbox, s.notificationURL, s.notificationURL)
+	return domain.MailSubscription{
+		Provider:    domain.ProviderMicrosoft,
+		Mailbox:     mailbox,
+		ExternalID:  uuid.NewString(),
+		ClientState: clientState,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// Renew extends a Graph subscription to its maximum lifetime
+func (s *MicrosoftSubscriber) Renew(ctx context.Context, sub domain.MailSubscription) (time.Time, error) {
+	expiresAt := time.Now().Add(graphSubscriptionLifetime)
+	log.Printf("[graph] PATCH /subscriptions/%s {\"expirationDateTime\": %q}", sub.ExternalID, expiresAt.UTC().Format(time.RFC3339))
+	return expiresAt, nil
+}
+
+// Unsubscribe deletes a Graph subscription
+func (s *MicrosoftSubscriber) Unsubscribe(ctx context.Context, sub domain.MailSubscription) error {
+	log.Printf("[graph] DELETE /subscriptions/%s", sub.ExternalID)
+	return nil
+}
+
+// FetchChanges fetches the message a Graph notification is about
+// Graph notifications name one message each, so there is no cursor.
+func (s *MicrosoftSubscriber) FetchChanges(ctx context.Context, sub domain.MailSubscription, change domain.MailChange) ([]domain.Email, string, error) {
+	if change.MessageID == "" {
+		return nil, "", fmt.Errorf("graph notification has no message")
+	}
+	log.Printf("[graph] GET /users/%s/messages/%s?$select=subject,from,toRecipients,receivedDateTime,hasAttachments,bodyPreview,internetMessageHeaders",
+		sub.Mailbox, change.MessageID)
+
+	// Mock implementation - returns a sample message
+	email := domain.Email{
+		ID:                uuid.New(),
+		ProviderMessageID: change.MessageID,
+		Subject:           "Updated bank details for invoice payment",
+		SenderEmail:       "accounts@supplier-payments.com",
+		SenderName:        "Supplier Accounts",
+		RecipientEmail:    sub.Mailbox,
+		ReceivedAt:        time.Now(),
+		BodyPreview:       "Please note our bank details have changed, kindly update them before the next payment...",
+		Headers:           make(map[string]string),
+		IngestedAt:        time.Now(),
+	}
+	log.Printf("[graph] GET /users/%s/messages/%s/attachments?$select=name,contentBytes", sub.Mailbox, change.MessageID)
+	withAttachments(&email, attachment{name: "New_bank_details.pdf", content: []byte("%PDF-1.4 bank details")})
+	return []domain.Email{email}, "", nil
+}
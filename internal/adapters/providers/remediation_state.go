@@ -0,0 +1,29 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// bannerState is the restore state of a banner: both providers replace the message
+// with a bannered copy, which restoring deletes once the original is back
+type bannerState struct {
+	Original string `json:"original"` // Provider ID of the original message, set aside
+	Copy     string `json:"copy"`     // Provider ID of the bannered copy shown instead
+}
+
+func (s bannerState) encode() string {
+	raw, _ := json.Marshal(s)
+	return string(raw)
+}
+
+// decodeBannerState parses a banner's restore state
+// States recorded without the copy's ID cannot be restored safely: the copy would
+// have to be found, and deleting by the original's ID would lose the message.
+func decodeBannerState(state string) (bannerState, error) {
+	var s bannerState
+	if err := json.Unmarshal([]byte(state), &s); err != nil || s.Original == "" || s.Copy == "" {
+		return s, fmt.Errorf("banner restore state %q does not identify the original and its copy", state)
+	}
+	return s, nil
+}
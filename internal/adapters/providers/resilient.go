@@ -0,0 +1,49 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/resilience"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// ResilientProvider decorates a ports.EmailProvider with per-tenant rate limiting,
+// retries of throttled and unavailable calls, and a per-tenant circuit breaker
+// The wrapped client reports failures as domain provider errors; in production the
+// Graph and Gmail clients map their HTTP responses with resilience.FromHTTP.
+type ResilientProvider struct {
+	inner    ports.EmailProvider
+	executor *resilience.Executor
+}
+
+// NewResilientProvider wraps a provider client
+// cfg.Rate should match the provider's per-tenant quota (resilience.GraphRate,
+// resilience.GmailRate).
+func NewResilientProvider(inner ports.EmailProvider, cfg resilience.Config) *ResilientProvider {
+	return &ResilientProvider{inner: inner, executor: resilience.NewExecutor(cfg)}
+}
+
+// GetUsers fetches a tenant's users within the tenant's limits
+func (p *ResilientProvider) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	var users []domain.User
+	err := p.executor.Do(ctx, tenantID.String(), func(ctx context.Context) error {
+		var err error
+		users, err = p.inner.GetUsers(ctx, tenantID)
+		return err
+	})
+	return users, err
+}
+
+// GetEmails fetches a user's emails within the tenant's limits
+func (p *ResilientProvider) GetEmails(ctx context.Context, tenantID, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+	var emails []domain.Email
+	err := p.executor.Do(ctx, tenantID.String(), func(ctx context.Context) error {
+		var err error
+		emails, err = p.inner.GetEmails(ctx, tenantID, userID, receivedAfter)
+		return err
+	})
+	return emails, err
+}
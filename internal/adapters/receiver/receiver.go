@@ -0,0 +1,162 @@
+// Package receiver is the HTTP endpoint providers push mailbox notifications to
+//
+// Routes:
+//
+//	POST /notifications/microsoft   Graph change and lifecycle notifications
+//	POST /notifications/google      Gmail changes pushed by Pub/Sub
+package receiver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/push"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// Routes served by the receiver
+const (
+	GraphPath = "/notifications/microsoft"
+	GmailPath = "/notifications/google"
+)
+
+// maxBody bounds notification bodies (Graph batches are well under this)
+const maxBody = 1 << 20
+
+// Config configures the receiver
+type Config struct {
+	// GmailToken authenticates Pub/Sub pushes, sent as the token query parameter of
+	// the push endpoint. In production, verify the push's OIDC token instead.
+	GmailToken string
+
+	// Timeout bounds the handling of one request: Graph expects an answer within
+	// 3 seconds and Pub/Sub within its ack deadline (default: 3s)
+	Timeout time.Duration
+}
+
+// Receiver handles provider push notifications
+type Receiver struct {
+	handler ports.MailChangeHandler
+	cfg     Config
+	mux     *http.ServeMux
+}
+
+// New creates a receiver passing notifications to handler
+func New(handler ports.MailChangeHandler, cfg Config) *Receiver {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+	r := &Receiver{handler: handler, cfg: cfg, mux: http.NewServeMux()}
+	r.mux.HandleFunc(GraphPath, r.handleGraph)
+	r.mux.HandleFunc(GmailPath, r.handleGmail)
+	return r
+}
+
+// ServeHTTP implements http.Handler
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// handleGraph answers the validation handshake and handles notification batches
+// Graph retries batches answered with a 5xx, for up to 4 hours.
+func (r *Receiver) handleGraph(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Graph checks the endpoint before creating a subscription: the token must be
+	// echoed as plain text
+	if token := req.URL.Query().Get("validationToken"); token != "" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, token)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBody))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	changes, err := push.ParseGraph(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), r.cfg.Timeout)
+	defer cancel()
+	failed := false
+	for _, change := range changes {
+		if err := r.handle(ctx, change); err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		http.Error(w, "notification handling failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleGmail authenticates and handles a Pub/Sub push
+// Pub/Sub redelivers pushes that are not acknowledged with a 2xx.
+func (r *Receiver) handleGmail(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := req.URL.Query().Get("token")
+	if r.cfg.GmailToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(r.cfg.GmailToken)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBody))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	change, err := push.ParseGmail(body)
+	if errors.Is(err, push.ErrNoChange) {
+		w.WriteHeader(http.StatusNoContent) // Nothing to do, and nothing to redeliver
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), r.cfg.Timeout)
+	defer cancel()
+	if err := r.handle(ctx, change); err != nil {
+		http.Error(w, "notification handling failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handle passes a change to the handler; it returns an error only when the
+// notification should be sent again
+// Notifications for unknown subscriptions or with a wrong client state are
+// acknowledged and dropped: retrying cannot make them valid.
+func (r *Receiver) handle(ctx context.Context, change domain.MailChange) error {
+	err := r.handler.HandleMailChange(ctx, change)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, domain.ErrUnknownSubscription), errors.Is(err, domain.ErrClientStateMismatch):
+		log.Printf("Dropped %s notification for subscription %s: %v", change.Provider, change.SubscriptionID, err)
+		return nil
+	default:
+		log.Printf("Failed to handle %s notification for subscription %s: %v", change.Provider, change.SubscriptionID, err)
+		return err
+	}
+}
@@ -0,0 +1,93 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/push"
+)
+
+// GraphSender stands in for Microsoft Graph in tests and local runs: it sends
+// validation handshakes and notification batches in Graph's format
+type GraphSender struct {
+	url    string
+	client *http.Client
+}
+
+// NewGraphSender creates a fake Graph posting to a receiver's Graph endpoint
+func NewGraphSender(endpoint string) *GraphSender {
+	return &GraphSender{url: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Validate performs the validation handshake Graph runs before creating a subscription
+func (s *GraphSender) Validate(ctx context.Context) error {
+	token := fmt.Sprintf("Validation: Testing client application reachability for subscription Request-Id: %d", time.Now().UnixNano())
+	status, body, err := post(ctx, s.client, s.url+"?validationToken="+url.QueryEscape(token), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || body != token {
+		return fmt.Errorf("validation failed: status %d, token echoed: %t", status, body == token)
+	}
+	return nil
+}
+
+// Notify posts changes as one notification batch and returns the response status
+func (s *GraphSender) Notify(ctx context.Context, changes ...domain.MailChange) (int, error) {
+	body, err := push.EncodeGraph(changes...)
+	if err != nil {
+		return 0, err
+	}
+	status, _, err := post(ctx, s.client, s.url, "application/json", body)
+	return status, err
+}
+
+// GmailSender stands in for Pub/Sub pushing Gmail notifications in tests and local runs
+type GmailSender struct {
+	url    string
+	client *http.Client
+}
+
+// NewGmailSender creates a fake Pub/Sub push subscription posting to a receiver's
+// Gmail endpoint with the configured token
+func NewGmailSender(endpoint, token string) *GmailSender {
+	return &GmailSender{
+		url:    endpoint + "?token=" + url.QueryEscape(token),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify pushes a mailbox change and returns the response status
+func (s *GmailSender) Notify(ctx context.Context, mailbox, historyID string) (int, error) {
+	body, err := push.EncodeGmail(domain.MailChange{Provider: domain.ProviderGoogle, SubscriptionID: mailbox, HistoryID: historyID})
+	if err != nil {
+		return 0, err
+	}
+	status, _, err := post(ctx, s.client, s.url, "application/json", body)
+	return status, err
+}
+
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return resp.StatusCode, "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return resp.StatusCode, string(respBody), nil
+}
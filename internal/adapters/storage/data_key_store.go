@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/envelope"
+)
+
+// dataKeysSchema creates the tenant data key table
+const dataKeysSchema = `
+	-- ============================================================================
+	-- TENANT_DATA_KEYS TABLE
+	-- ============================================================================
+	-- Envelope encryption: one AES-256 data key per tenant encrypts its secrets
+	-- (tenants.credentials). Data keys are stored wrapped by a master key that never
+	-- leaves the key manager; rotating the master key re-wraps the rows in place.
+	CREATE TABLE IF NOT EXISTS tenant_data_keys (
+		tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+		master_key_id VARCHAR(64) NOT NULL,
+		wrapped_key BYTEA NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		rotated_at TIMESTAMP
+	);
+
+	-- Rotation scan
+	CREATE INDEX IF NOT EXISTS idx_tenant_data_keys_master ON tenant_data_keys(master_key_id);
+`
+
+// GetTenantDataKey returns a tenant's wrapped data key (nil if none yet)
+func (s *PostgresStore) GetTenantDataKey(ctx context.Context, tenantID uuid.UUID) (*domain.DataKey, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+dataKeyColumns+` FROM tenant_data_keys WHERE tenant_id = $1`,
+		tenantID,
+	)
+	key, err := scanDataKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateTenantDataKey stores a tenant's data key unless it already has one
+// Callers read the key back: a concurrent writer may have stored another one first.
+func (s *PostgresStore) CreateTenantDataKey(ctx context.Context, key *domain.DataKey) error {
+	query := `
+		INSERT INTO tenant_data_keys (tenant_id, master_key_id, wrapped_key, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, query, key.TenantID, key.MasterKeyID, key.WrappedKey, key.CreatedAt)
+	return err
+}
+
+// ListDataKeysToRewrap returns up to limit data keys not wrapped with a master key
+func (s *PostgresStore) ListDataKeysToRewrap(ctx context.Context, masterKeyID string, limit int) ([]domain.DataKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+dataKeyColumns+` FROM tenant_data_keys WHERE master_key_id <> $1 ORDER BY created_at LIMIT $2`,
+		masterKeyID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]domain.DataKey, 0)
+	for rows.Next() {
+		key, err := scanDataKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RewrapTenantDataKey replaces a data key's wrapping if it is still wrapped with
+// fromMasterKeyID, so concurrent rotations do not overwrite each other
+func (s *PostgresStore) RewrapTenantDataKey(ctx context.Context, tenantID uuid.UUID, fromMasterKeyID string, key *domain.DataKey) error {
+	query := `
+		UPDATE tenant_data_keys
+		SET master_key_id = $3, wrapped_key = $4, rotated_at = $5
+		WHERE tenant_id = $1 AND master_key_id = $2
+	`
+	result, err := s.db.ExecContext(ctx, query, tenantID, fromMasterKeyID, key.MasterKeyID, key.WrappedKey, key.RotatedAt)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "data key of tenant", tenantID)
+}
+
+// dataKeyColumns are scanned by scanDataKey, in order
+const dataKeyColumns = `tenant_id, master_key_id, wrapped_key, created_at, rotated_at`
+
+func scanDataKey(row interface{ Scan(...interface{}) error }) (domain.DataKey, error) {
+	var key domain.DataKey
+	err := row.Scan(&key.TenantID, &key.MasterKeyID, &key.WrappedKey, &key.CreatedAt, &key.RotatedAt)
+	return key, err
+}
+
+// checkSealed refuses to store a tenant secret in the clear
+// Secrets are sealed by the application's SecretStore; empty means none yet.
+func checkSealed(tenantID uuid.UUID, secret string) error {
+	if secret != "" && !envelope.IsSealed(secret) {
+		return fmt.Errorf("refusing to store a secret of tenant %s in the clear: %w", tenantID, envelope.ErrNotSealed)
+	}
+	return nil
+}
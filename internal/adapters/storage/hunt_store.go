@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/hunt"
+	"github.com/stoik/email-security/internal/domain/senderlist"
+)
+
+// senderSkeletonSQL computes the sender_skeleton column, versioned by
+// senderSkeletonVersion
+var (
+	senderSkeletonSQL     = skeletonSQL("lower(split_part(sender_email, '@', 2))")
+	senderSkeletonVersion = expressionVersion(senderSkeletonSQL)
+)
+
+// huntSchema adds the columns and indexes retro-hunts search emails with
+// Executed by InitSchema after versionsSchema.
+//
+// In production, these indexes would be built with CREATE INDEX CONCURRENTLY in a
+// migration: adding sender_domain rewrites the emails table, and trigram indexes on
+// a large table take a while to build.
+var huntSchema = `
+	-- Trigram indexes back substring (LIKE/ILIKE) and similarity searches
+	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+	-- Lowercase hex SHA-256 of each attachment's content, in attachment_names order
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS attachment_hashes TEXT[];
+	-- Lowercase sender domain, so domain hunts don't parse every address
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS sender_domain TEXT
+		GENERATED ALWAYS AS (lower(split_part(sender_email, '@', 2))) STORED;
+	-- Its skeleton (hunt.Skeleton), so homoglyph lookalikes ("раураl.com") are found
+	-- The column comment is a hash of its expression: the column is rebuilt when
+	-- hunt.Homoglyphs changes, so stored skeletons match the ones hunts compute.
+	DO $$
+	BEGIN
+		IF (SELECT col_description('emails'::regclass, attnum) FROM pg_attribute
+		    WHERE attrelid = 'emails'::regclass AND attname = 'sender_skeleton' AND NOT attisdropped)
+		   IS DISTINCT FROM '` + senderSkeletonVersion + `' THEN
+			ALTER TABLE emails DROP COLUMN IF EXISTS sender_skeleton;
+			ALTER TABLE emails ADD COLUMN sender_skeleton TEXT
+				GENERATED ALWAYS AS (` + senderSkeletonSQL + `) STORED;
+			COMMENT ON COLUMN emails.sender_skeleton IS '` + senderSkeletonVersion + `';
+		END IF;
+	END $$;
+
+	-- Hunts across tenants walk a time window newest first
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_received ON emails(received_at DESC, id DESC);
+	-- Exact sender address, whatever its case
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_sender ON emails(lower(sender_email));
+	-- Exact domain, subdomains (LIKE '%.domain') and lookalikes (word similarity)
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_sender_domain ON emails(sender_domain);
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_sender_domain_trgm ON emails USING GIN (sender_domain gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_sender_skeleton_trgm ON emails USING GIN (sender_skeleton gin_trgm_ops);
+	-- Text in subject or body
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_subject_trgm ON emails USING GIN (subject gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_body_trgm ON emails USING GIN (body_preview gin_trgm_ops);
+	-- Attachment names, searched in their JSON text
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_attachment_names_trgm ON emails USING GIN ((attachment_names::text) gin_trgm_ops);
+	-- Attachment hashes (array containment) and header values (JSONB containment)
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_attachment_hashes ON emails USING GIN (attachment_hashes);
+	CREATE INDEX IF NOT EXISTS idx_emails_hunt_headers ON emails USING GIN (headers jsonb_path_ops);
+`
+
+// HuntEmails retrieves up to limit emails a hunt query may match, received before a
+// cursor (a zero cursor starts from the newest), newest first, with their tenant's
+// name and current risk
+// Every criterion is applied in SQL. Lookalike domains are prefiltered by trigram word
+// similarity or by containing the domain's name, compared as written and as skeletons;
+// hunt.Matches confirms them.
+func (s *PostgresStore) HuntEmails(ctx context.Context, q domain.HuntQuery, before domain.EmailCursor, limit int) ([]domain.HuntMatch, error) {
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.TenantID != uuid.Nil {
+		conditions = append(conditions, "tenant_id = "+arg(q.TenantID))
+	}
+	if !before.ReceivedAt.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("(received_at, id) < (%s, %s)", arg(before.ReceivedAt), arg(before.ID)))
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, "received_at >= "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "received_at < "+arg(q.To))
+	}
+	if q.Sender != "" {
+		conditions = append(conditions, "lower(sender_email) = "+arg(q.Sender))
+	}
+	if q.SenderDomain != "" {
+		conditions = append(conditions, fmt.Sprintf("(sender_domain = %s OR sender_domain LIKE %s)",
+			arg(q.SenderDomain), arg("%."+escapeLike(q.SenderDomain))))
+	}
+	if q.LookalikeOf != "" {
+		registrable := senderlist.RegistrableDomain(q.LookalikeOf)
+		name := strings.SplitN(registrable, ".", 2)[0]
+		conditions = append(conditions, fmt.Sprintf("(%s::text <%% sender_domain OR sender_domain LIKE %s OR %s::text <%% sender_skeleton OR sender_skeleton LIKE %s) AND sender_domain <> %s AND sender_domain NOT LIKE %s",
+			arg(registrable), arg("%"+escapeLike(name)+"%"),
+			arg(hunt.Skeleton(registrable)), arg("%"+escapeLike(hunt.Skeleton(name))+"%"),
+			arg(registrable), arg("%."+escapeLike(registrable))))
+	}
+	if q.Text != "" {
+		pattern := arg("%" + escapeLike(q.Text) + "%")
+		conditions = append(conditions, fmt.Sprintf("(subject ILIKE %s OR body_preview ILIKE %s)", pattern, pattern))
+	}
+	if q.AttachmentName != "" {
+		conditions = append(conditions, "attachment_names::text ILIKE "+arg("%"+escapeLike(q.AttachmentName)+"%"))
+	}
+	if q.AttachmentHash != "" {
+		conditions = append(conditions, "attachment_hashes @> "+arg(pq.Array([]string{q.AttachmentHash}))+"::text[]")
+	}
+	if len(q.Headers) > 0 {
+		headersJSON, err := json.Marshal(q.Headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal headers: %w", err)
+		}
+		conditions = append(conditions, "headers @> "+arg(string(headersJSON))+"::jsonb")
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, "\n\t\t  AND ")
+	}
+	query := `
+		SELECT ` + emailColumns + `,
+		       COALESCE((SELECT name FROM tenants WHERE tenants.id = emails.tenant_id), ''),
+		       COALESCE(fa.risk_level, ''), COALESCE(fa.risk_score, 0)
+		FROM emails
+		LEFT JOIN LATERAL (
+			SELECT risk_level, risk_score FROM fraud_analyses
+			WHERE fraud_analyses.email_id = emails.id AND is_current
+			LIMIT 1
+		) fa ON TRUE
+		` + where + `
+		ORDER BY received_at DESC, id DESC
+		LIMIT ` + arg(limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := make([]domain.HuntMatch, 0)
+	for rows.Next() {
+		var match domain.HuntMatch
+		email, err := scanEmail(rows, &match.TenantName, &match.RiskLevel, &match.RiskScore)
+		if err != nil {
+			return nil, err
+		}
+		match.Email = email
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// skeletonSQL returns the SQL computing hunt.Skeleton of a lowercase expression
+func skeletonSQL(expr string) string {
+	sequences, from, to := skeletonSteps()
+	for _, sequence := range sequences {
+		expr = fmt.Sprintf("replace(%s, '%s', '%s')", expr, sequence[0], sequence[1])
+	}
+	return fmt.Sprintf("translate(%s, '%s', '%s')", expr, from, to)
+}
+
+// skeletonSteps splits hunt.Homoglyphs into the sequences replaced first and the
+// characters then translated one for one
+func skeletonSteps() (sequences [][2]string, from, to string) {
+	for _, h := range hunt.Homoglyphs {
+		if utf8.RuneCountInString(h[0]) > 1 {
+			sequences = append(sequences, h)
+			continue
+		}
+		from += h[0]
+		to += h[1]
+	}
+	return sequences, from, to
+}
+
+// expressionVersion returns a short hash of a column expression
+func expressionVersion(expr string) string {
+	sum := sha256.Sum256([]byte(expr))
+	return hex.EncodeToString(sum[:6])
+}
+
+// escapeLike escapes LIKE wildcards, so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/hunt"
+)
+
+// skeletonSamples embeds every homoglyph in a domain name, plus names mixing them
+func skeletonSamples() []string {
+	samples := []string{"paypal.com", "раураl.com", "c1oudflare.com", "сlient.io", "g00gle-1nvoice.net"}
+	for _, h := range hunt.Homoglyphs {
+		samples = append(samples, "pay"+h[0]+"al.com")
+	}
+	return samples
+}
+
+// evalSkeletonSQL evaluates the output of skeletonSQL, nested replace calls inside a
+// translate call, on a value
+func evalSkeletonSQL(t *testing.T, sql, value string) string {
+	calls := regexp.MustCompile(`, '([^']*)', '([^']*)'\)`).FindAllStringSubmatch(sql, -1)
+	require.Equal(t, strings.Count(sql, "replace(")+1, len(calls), "replace calls and a translate call")
+	for _, call := range calls[:len(calls)-1] {
+		value = strings.ReplaceAll(value, call[1], call[2])
+	}
+	from, to := []rune(calls[len(calls)-1][1]), []rune(calls[len(calls)-1][2])
+	require.Len(t, to, len(from))
+	return strings.Map(func(r rune) rune {
+		for i, f := range from {
+			if r == f {
+				return to[i]
+			}
+		}
+		return r
+	}, value)
+}
+
+func TestSkeletonSQL(t *testing.T) {
+	sql := skeletonSQL("sender_domain")
+	assert.True(t, strings.HasPrefix(sql, "translate("), sql)
+	for _, name := range skeletonSamples() {
+		assert.Equal(t, hunt.Skeleton(name), evalSkeletonSQL(t, sql, name), name)
+	}
+
+	// The column is rebuilt whenever its expression changes
+	assert.NotEqual(t, senderSkeletonVersion, expressionVersion(skeletonSQL("sender_email")))
+	assert.Contains(t, huntSchema, "IS '"+senderSkeletonVersion+"'")
+}
+
+// testStore connects to the PostgreSQL database in TEST_DATABASE_URL, skipping the
+// test when it is not set
+func testStore(t *testing.T) *PostgresStore {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	store, err := NewPostgresStore(connStr)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	require.NoError(t, store.InitSchema())
+	return store
+}
+
+// TestSkeletonSQL_Postgres runs skeletonSQL in PostgreSQL (TEST_DATABASE_URL)
+func TestSkeletonSQL_Postgres(t *testing.T) {
+	store := testStore(t)
+	for _, name := range skeletonSamples() {
+		var skeleton string
+		require.NoError(t, store.db.QueryRow(`SELECT `+skeletonSQL("$1::text"), name).Scan(&skeleton))
+		assert.Equal(t, hunt.Skeleton(name), skeleton, name)
+	}
+}
+
+// TestHuntEmails_LookalikeHomoglyph needs a PostgreSQL database: set TEST_DATABASE_URL
+func TestHuntEmails_LookalikeHomoglyph(t *testing.T) {
+	store := testStore(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	tenant := &domain.Tenant{ID: uuid.New(), Name: "hunt-" + uuid.NewString(), Provider: domain.ProviderMicrosoft, Status: domain.TenantActive, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, store.CreateTenant(ctx, tenant))
+	t.Cleanup(func() { store.DeleteTenant(context.Background(), tenant.ID) })
+	user := &domain.User{ID: uuid.New(), TenantID: tenant.ID, ProviderUserID: "cfo", Email: "cfo@company.com", SyncedAt: now, CreatedAt: now}
+	require.NoError(t, store.CreateUser(ctx, user))
+
+	senders := []string{"billing@раураl.com", "billing@paypal.com", "news@example.org"}
+	for i, sender := range senders {
+		require.NoError(t, store.CreateEmail(ctx, &domain.Email{
+			ID:                uuid.New(),
+			TenantID:          tenant.ID,
+			UserID:            user.ID,
+			ProviderMessageID: uuid.NewString(),
+			Subject:           "Invoice",
+			SenderEmail:       sender,
+			RecipientEmail:    user.Email,
+			ReceivedAt:        now.Add(-time.Duration(i) * time.Minute),
+			IngestedAt:        now,
+		}))
+	}
+
+	// "раураl.com" is written in Cyrillic: it contains no "paypal" and scores low
+	// against it, but its skeleton is "paypal.com"
+	matches, err := store.HuntEmails(ctx, domain.HuntQuery{TenantID: tenant.ID, LookalikeOf: "paypal.com"}, domain.EmailCursor{}, 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "billing@раураl.com", matches[0].Email.SenderEmail)
+}
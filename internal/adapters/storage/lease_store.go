@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// emailLeasesSchema adds processing leases to emails
+const emailLeasesSchema = `
+	-- ============================================================================
+	-- EMAIL PROCESSING LEASES
+	-- ============================================================================
+	-- A detection worker claims unprocessed emails by taking a lease: lease_owner
+	-- identifies the worker, lease_expires_at bounds how long the emails are reserved.
+	-- Claims use FOR UPDATE SKIP LOCKED so concurrent workers get disjoint batches
+	-- without waiting on each other. A worker that crashes simply lets its leases
+	-- expire; the next claim picks the emails up again (no reaper job needed).
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(128);
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+	-- Backs ClaimUnprocessedEmails and GetBacklog: the backlog only, per tenant, oldest first
+	CREATE INDEX IF NOT EXISTS idx_emails_unprocessed ON emails(tenant_id, received_at) WHERE processed_at IS NULL;
+`
+
+// ClaimUnprocessedEmails leases up to limit of a tenant's unprocessed emails to owner, oldest first
+// Emails leased to another worker are skipped unless their lease has expired.
+func (s *PostgresStore) ClaimUnprocessedEmails(ctx context.Context, tenantID uuid.UUID, owner string, limit int, lease time.Duration) ([]domain.Email, error) {
+	query := `
+		UPDATE emails
+		SET lease_owner = $1, lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM emails
+			WHERE tenant_id = $4 AND processed_at IS NULL
+			  AND (lease_expires_at IS NULL OR lease_expires_at < NOW())
+			ORDER BY received_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + emailColumns
+
+	rows, err := s.db.QueryContext(ctx, query, owner, limit, lease.Seconds(), tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim emails: %w", err)
+	}
+	return scanEmails(rows)
+}
+
+// ReleaseEmails gives up owner's leases so the emails can be claimed again immediately
+// Leases that expired and were claimed by another worker are left alone.
+func (s *PostgresStore) ReleaseEmails(ctx context.Context, owner string, emailIDs []uuid.UUID) error {
+	query := `
+		UPDATE emails SET lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ANY($1::uuid[]) AND lease_owner = $2 AND processed_at IS NULL
+	`
+	_, err := s.db.ExecContext(ctx, query, pq.Array(uuidStrings(emailIDs)), owner)
+	return err
+}
+
+// CompleteEmails stores the analyses of emails leased to owner and marks them processed
+//
+// Everything happens in one transaction, including writing the analysis events to the
+// outbox. Analyses of emails whose lease owner has lost (it expired and another worker
+// claimed the email) are dropped, since the new owner will store its own. Returns the
+// number of analyses stored.
+func (s *PostgresStore) CompleteEmails(ctx context.Context, owner string, analyses []domain.FraudAnalysis) (int, error) {
+	if len(analyses) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	emailIDs := make([]uuid.UUID, len(analyses))
+	for i := range analyses {
+		emailIDs[i] = analyses[i].EmailID
+	}
+
+	// The row locks taken here keep the leases from being re-claimed until commit
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE emails SET processed_at = NOW(), lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ANY($1::uuid[]) AND lease_owner = $2 AND processed_at IS NULL
+		RETURNING id, tenant_id, subject, sender_email, sender_name, recipient_email
+	`, pq.Array(uuidStrings(emailIDs)), owner)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark emails processed: %w", err)
+	}
+	held := make(map[uuid.UUID]domain.Email, len(analyses))
+	for rows.Next() {
+		var email domain.Email
+		err := rows.Scan(&email.ID, &email.TenantID, &email.Subject, &email.SenderEmail, &email.SenderName, &email.RecipientEmail)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		held[email.ID] = email
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	kept := make([]domain.FraudAnalysis, 0, len(held))
+	for _, analysis := range analyses {
+		if _, ok := held[analysis.EmailID]; ok {
+			kept = append(kept, analysis)
+		}
+	}
+	if len(kept) > 0 {
+		if err := insertCurrentAnalyses(ctx, tx, kept, held); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit analyses: %w", err)
+	}
+	return len(kept), nil
+}
+
+// GetBacklog reports the pending emails of every tenant that has some, longest lag first
+func (s *PostgresStore) GetBacklog(ctx context.Context) ([]domain.TenantBacklog, error) {
+	query := `
+		SELECT e.tenant_id, t.name,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE e.lease_expires_at >= NOW()),
+		       EXTRACT(EPOCH FROM NOW() - MIN(e.ingested_at))
+		FROM emails e
+		JOIN tenants t ON t.id = e.tenant_id
+		WHERE e.processed_at IS NULL
+		GROUP BY e.tenant_id, t.name
+		ORDER BY MIN(e.ingested_at) ASC
+	`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backlog := make([]domain.TenantBacklog, 0)
+	for rows.Next() {
+		var tenant domain.TenantBacklog
+		var lagSeconds float64
+		if err := rows.Scan(&tenant.TenantID, &tenant.TenantName, &tenant.Pending, &tenant.Leased, &lagSeconds); err != nil {
+			return nil, err
+		}
+		tenant.Lag = time.Duration(lagSeconds * float64(time.Second))
+		backlog = append(backlog, tenant)
+	}
+	return backlog, rows.Err()
+}
+
+// uuidStrings formats IDs for a uuid[] parameter
+func uuidStrings(ids []uuid.UUID) []string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+	return values
+}
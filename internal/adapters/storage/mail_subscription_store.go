@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// mailSubscriptionsSchema creates the provider push subscription table
+const mailSubscriptionsSchema = `
+	-- ============================================================================
+	-- MAIL_SUBSCRIPTIONS TABLE
+	-- ============================================================================
+	-- One push subscription per user mailbox (Graph subscription or Gmail watch).
+	-- Lapsed subscriptions are polled from synced_at until recreated.
+	--
+	-- Production: encrypt client_state at rest, it authenticates Graph notifications.
+	CREATE TABLE IF NOT EXISTS mail_subscriptions (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		provider VARCHAR(10) NOT NULL CHECK (provider IN ('microsoft', 'google')),
+		mailbox VARCHAR(255) NOT NULL,
+		external_id VARCHAR(255),
+		client_state VARCHAR(255) NOT NULL,
+		sync_cursor VARCHAR(64),
+		status VARCHAR(20) NOT NULL CHECK (status IN ('active', 'lapsed')),
+		expires_at TIMESTAMP NOT NULL,
+		synced_at TIMESTAMP NOT NULL,
+		last_notification_at TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE(user_id)
+	);
+
+	-- Notification lookup; lapsed subscriptions may have no provider ID yet
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_mail_subscriptions_external
+		ON mail_subscriptions(provider, external_id) WHERE external_id IS NOT NULL;
+
+	-- Renewal scan
+	CREATE INDEX IF NOT EXISTS idx_mail_subscriptions_expiry ON mail_subscriptions(status, expires_at);
+`
+
+// CreateMailSubscription inserts a mail subscription
+func (s *PostgresStore) CreateMailSubscription(ctx context.Context, sub *domain.MailSubscription) error {
+	query := `
+		INSERT INTO mail_subscriptions (
+			id, tenant_id, user_id, provider, mailbox, external_id, client_state, sync_cursor,
+			status, expires_at, synced_at, last_notification_at, last_error, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), $9, $10, $11, $12, NULLIF($13, ''), $14, $15)
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		sub.ID, sub.TenantID, sub.UserID, sub.Provider, sub.Mailbox, sub.ExternalID, sub.ClientState, sub.Cursor,
+		sub.Status, sub.ExpiresAt, sub.SyncedAt, sub.LastNotificationAt, sub.LastError, sub.CreatedAt, sub.UpdatedAt,
+	)
+	return err
+}
+
+// UpdateMailSubscription replaces a subscription's provider state
+func (s *PostgresStore) UpdateMailSubscription(ctx context.Context, sub *domain.MailSubscription) error {
+	query := `
+		UPDATE mail_subscriptions
+		SET external_id = NULLIF($2, ''), client_state = $3, sync_cursor = NULLIF($4, ''), status = $5,
+		    expires_at = $6, synced_at = $7, last_notification_at = $8, last_error = NULLIF($9, ''), updated_at = $10
+		WHERE id = $1
+	`
+	result, err := s.db.ExecContext(ctx, query,
+		sub.ID, sub.ExternalID, sub.ClientState, sub.Cursor, sub.Status,
+		sub.ExpiresAt, sub.SyncedAt, sub.LastNotificationAt, sub.LastError, sub.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "mail subscription", sub.ID)
+}
+
+// mailSubscriptionColumns are scanned by scanMailSubscription, in order
+const mailSubscriptionColumns = `
+	id, tenant_id, user_id, provider, mailbox, COALESCE(external_id, ''), client_state, COALESCE(sync_cursor, ''),
+	status, expires_at, synced_at, last_notification_at, COALESCE(last_error, ''), created_at, updated_at
+`
+
+func scanMailSubscription(row interface{ Scan(...interface{}) error }) (domain.MailSubscription, error) {
+	var sub domain.MailSubscription
+	err := row.Scan(
+		&sub.ID, &sub.TenantID, &sub.UserID, &sub.Provider, &sub.Mailbox, &sub.ExternalID, &sub.ClientState, &sub.Cursor,
+		&sub.Status, &sub.ExpiresAt, &sub.SyncedAt, &sub.LastNotificationAt, &sub.LastError, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	return sub, err
+}
+
+// GetMailSubscription retrieves a subscription by provider and provider ID (nil if not found)
+func (s *PostgresStore) GetMailSubscription(ctx context.Context, provider domain.Provider, externalID string) (*domain.MailSubscription, error) {
+	query := `SELECT ` + mailSubscriptionColumns + ` FROM mail_subscriptions WHERE provider = $1 AND external_id = $2`
+	sub, err := scanMailSubscription(s.db.QueryRowContext(ctx, query, provider, externalID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// ListMailSubscriptions retrieves all subscriptions of a tenant by mailbox
+func (s *PostgresStore) ListMailSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.MailSubscription, error) {
+	query := `SELECT ` + mailSubscriptionColumns + ` FROM mail_subscriptions WHERE tenant_id = $1 ORDER BY mailbox`
+	return s.queryMailSubscriptions(ctx, query, tenantID)
+}
+
+// ListMailSubscriptionsDue retrieves lapsed subscriptions and active ones expiring
+// before a time, soonest first
+// In production, concurrent renewers would claim them with leases, like emails.
+func (s *PostgresStore) ListMailSubscriptionsDue(ctx context.Context, expiringBefore time.Time, limit int) ([]domain.MailSubscription, error) {
+	query := `
+		SELECT ` + mailSubscriptionColumns + `
+		FROM mail_subscriptions
+		WHERE status = 'lapsed' OR expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2
+	`
+	return s.queryMailSubscriptions(ctx, query, expiringBefore, limit)
+}
+
+func (s *PostgresStore) queryMailSubscriptions(ctx context.Context, query string, args ...interface{}) ([]domain.MailSubscription, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]domain.MailSubscription, 0)
+	for rows.Next() {
+		sub, err := scanMailSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// notificationRoutesSchema creates the per-tenant alert routing table
+const notificationRoutesSchema = `
+	-- ============================================================================
+	-- NOTIFICATION_ROUTES TABLE
+	-- ============================================================================
+	-- Where a tenant's alerts go (Slack, Teams, email) and which ones: minimum risk
+	-- level, detection types, and the level below which alerts are batched in digests.
+	--
+	-- Targets are sealed with the tenant's data key: Slack and Teams URLs are credentials.
+	CREATE TABLE IF NOT EXISTS notification_routes (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		channel VARCHAR(20) NOT NULL CHECK (channel IN ('slack', 'teams', 'email')),
+		target TEXT NOT NULL,
+		min_risk_level VARCHAR(20) NOT NULL,
+		detection_types TEXT[] NOT NULL DEFAULT '{}',
+		digest_below VARCHAR(20),
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE(tenant_id, name)
+	);
+`
+
+// CreateNotificationRoute inserts a notification route
+func (s *PostgresStore) CreateNotificationRoute(ctx context.Context, route *domain.NotificationRoute) error {
+	if err := checkSealed(route.TenantID, route.Target); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO notification_routes (id, tenant_id, name, channel, target, min_risk_level, detection_types, digest_below, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11)
+	`
+	detectionTypes := route.DetectionTypes
+	if detectionTypes == nil {
+		detectionTypes = []string{}
+	}
+	_, err := s.db.ExecContext(ctx, query,
+		route.ID, route.TenantID, route.Name, route.Channel, route.Target, route.MinRiskLevel,
+		pq.Array(detectionTypes), route.DigestBelow, route.Enabled, route.CreatedAt, route.UpdatedAt,
+	)
+	return err
+}
+
+// ListNotificationRoutes retrieves all routes of a tenant, enabled or not, by name
+func (s *PostgresStore) ListNotificationRoutes(ctx context.Context, tenantID uuid.UUID) ([]domain.NotificationRoute, error) {
+	query := `
+		SELECT id, tenant_id, name, channel, target, min_risk_level, detection_types,
+		       COALESCE(digest_below, ''), enabled, created_at, updated_at
+		FROM notification_routes
+		WHERE tenant_id = $1
+		ORDER BY name
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := make([]domain.NotificationRoute, 0)
+	for rows.Next() {
+		var route domain.NotificationRoute
+		err := rows.Scan(
+			&route.ID, &route.TenantID, &route.Name, &route.Channel, &route.Target, &route.MinRiskLevel,
+			pq.Array(&route.DetectionTypes), &route.DigestBelow, &route.Enabled, &route.CreatedAt, &route.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, rows.Err()
+}
+
+// UpdateNotificationRouteTarget replaces a route's stored target
+func (s *PostgresStore) UpdateNotificationRouteTarget(ctx context.Context, tenantID, routeID uuid.UUID, target string) error {
+	if err := checkSealed(tenantID, target); err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE notification_routes SET target = $3, updated_at = NOW() WHERE tenant_id = $1 AND id = $2`,
+		tenantID, routeID, target,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "notification route", routeID)
+}
+
+// DeleteNotificationRoute removes a tenant's route
+func (s *PostgresStore) DeleteNotificationRoute(ctx context.Context, tenantID, routeID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notification_routes WHERE tenant_id = $1 AND id = $2`, tenantID, routeID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "notification route", routeID)
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// outboxSchema creates the transactional outbox
+const outboxSchema = `
+	-- ============================================================================
+	-- OUTBOX TABLE
+	-- ============================================================================
+	-- Events (email.ingested, analysis.completed, threat.detected) are inserted in the
+	-- same transaction as the change they describe, so an event exists if and only if
+	-- the change was committed. The relay publishes them to sinks afterwards and
+	-- records delivery state here.
+	--
+	-- dedup_key identifies the fact (e.g. "analysis.completed:<analysis id>"): the
+	-- unique constraint makes writing an event idempotent, and consumers deduplicate
+	-- redeliveries on it.
+	--
+	-- Production: partition by created_at and drop published partitions, or delete
+	-- published rows after a retention period.
+	CREATE TABLE IF NOT EXISTS outbox (
+		id UUID PRIMARY KEY,
+		event_type VARCHAR(64) NOT NULL,
+		tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+		email_id UUID,
+		dedup_key VARCHAR(255) NOT NULL UNIQUE,
+		payload JSONB NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_error TEXT,
+		lease_owner VARCHAR(128),
+		lease_expires_at TIMESTAMP,
+		published_at TIMESTAMP,
+		dead_at TIMESTAMP
+	);
+
+	-- Backs ClaimOutboxEvents: undelivered events only, in due order
+	CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(next_attempt_at)
+		WHERE published_at IS NULL AND dead_at IS NULL;
+`
+
+// insertOutboxEvents writes events in the caller's transaction
+// An event whose dedup key already exists is skipped.
+func insertOutboxEvents(ctx context.Context, tx *sql.Tx, events []domain.Event) error {
+	rows := make([][]interface{}, len(events))
+	for i, event := range events {
+		rows[i] = []interface{}{
+			event.ID, event.Type, event.TenantID, event.EmailID,
+			event.DedupKey, []byte(event.Payload), event.CreatedAt,
+		}
+	}
+	err := insertRows(ctx, tx,
+		`INSERT INTO outbox (id, event_type, tenant_id, email_id, dedup_key, payload, created_at)`,
+		rows, `ON CONFLICT (dedup_key) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox events: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutboxEvents leases up to limit due, undelivered events to owner, oldest first
+// Claiming counts as an attempt, so events of a relay that crashes mid-delivery still
+// reach their retry limit.
+func (s *PostgresStore) ClaimOutboxEvents(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.Event, error) {
+	query := `
+		UPDATE outbox
+		SET lease_owner = $1, lease_expires_at = NOW() + $3 * INTERVAL '1 second', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND dead_at IS NULL AND next_attempt_at <= NOW()
+			  AND (lease_expires_at IS NULL OR lease_expires_at < NOW())
+			ORDER BY created_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_type, tenant_id, email_id, dedup_key, payload, created_at, attempts
+	`
+	rows, err := s.db.QueryContext(ctx, query, owner, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]domain.Event, 0)
+	for rows.Next() {
+		var event domain.Event
+		var tenantID, emailID uuid.NullUUID
+		var payload []byte
+		err := rows.Scan(
+			&event.ID, &event.Type, &tenantID, &emailID,
+			&event.DedupKey, &payload, &event.CreatedAt, &event.Attempts,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.TenantID, event.EmailID, event.Payload = tenantID.UUID, emailID.UUID, payload
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the subquery order
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+	return events, nil
+}
+
+// MarkOutboxEventsPublished records the delivery of events leased to owner
+func (s *PostgresStore) MarkOutboxEventsPublished(ctx context.Context, owner string, eventIDs []uuid.UUID) error {
+	query := `
+		UPDATE outbox
+		SET published_at = NOW(), last_error = NULL, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ANY($1::uuid[]) AND lease_owner = $2
+	`
+	_, err := s.db.ExecContext(ctx, query, pq.Array(uuidStrings(eventIDs)), owner)
+	return err
+}
+
+// RetryOutboxEvent releases a failed event of owner, to be claimed again after delay
+func (s *PostgresStore) RetryOutboxEvent(ctx context.Context, owner string, eventID uuid.UUID, delay time.Duration, lastErr string) error {
+	query := `
+		UPDATE outbox
+		SET next_attempt_at = NOW() + $3 * INTERVAL '1 second', last_error = $4,
+		    lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = $1 AND lease_owner = $2
+	`
+	_, err := s.db.ExecContext(ctx, query, eventID, owner, delay.Seconds(), lastErr)
+	return err
+}
+
+// DeadLetterOutboxEvent gives up on an event of owner; it stays in the outbox for
+// investigation and manual replay (reset dead_at and next_attempt_at)
+func (s *PostgresStore) DeadLetterOutboxEvent(ctx context.Context, owner string, eventID uuid.UUID, lastErr string) error {
+	query := `
+		UPDATE outbox
+		SET dead_at = NOW(), last_error = $3, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = $1 AND lease_owner = $2
+	`
+	_, err := s.db.ExecContext(ctx, query, eventID, owner, lastErr)
+	return err
+}
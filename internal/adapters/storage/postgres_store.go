@@ -5,11 +5,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/directory"
 )
 
 // PostgresStore implements ports.Storage for PostgreSQL
@@ -44,6 +46,9 @@ func (s *PostgresStore) Close() error {
 }
 
 // InitSchema creates database tables if they don't exist
+// The core tables are created first, then the tables of each store file, whose
+// schemas (emailLeasesSchema, outboxSchema...) are executed in the order listed at
+// the end.
 // In production, use proper migration tools
 func (s *PostgresStore) InitSchema() error {
 	schema := `
@@ -51,9 +56,12 @@ func (s *PostgresStore) InitSchema() error {
 	-- TENANTS TABLE
 	-- ============================================================================
 	-- Multi-tenant architecture: each tenant = one organization using our service.
-	-- Credentials stored as encrypted OAuth tokens (TEXT is sufficient for prototype).
-	--
-	-- Production: use a secrets manager (AWS Secrets Manager, Vault) instead of DB storage.
+	-- Credentials are JSON-encoded OAuth credentials (client secret, service account key
+	-- or refresh token), sealed with the tenant's data key (see tenant_data_keys): they
+	-- are never stored in the clear. Status follows the onboarding lifecycle
+	-- (domain.ValidateTenantTransition): 'pending_consent' until an administrator grants
+	-- consent, 'active', 'suspended', 'auth_error' when consent is revoked, and
+	-- 'offboarding' until the tenant row, and by cascade all of its data, is deleted.
 	CREATE TABLE IF NOT EXISTS tenants (
 		id UUID PRIMARY KEY,
 		name VARCHAR(100) NOT NULL,
@@ -64,18 +72,20 @@ func (s *PostgresStore) InitSchema() error {
 		updated_at TIMESTAMP DEFAULT NOW()
 	);
 
+	-- Per-tenant risk score aggregator ("weighted_max", "noisy_or", "log_odds", "logistic")
+	-- NULL/empty falls back to the detector default
+	ALTER TABLE tenants ADD COLUMN IF NOT EXISTS risk_aggregator VARCHAR(32);
+
 	-- ============================================================================
 	-- USERS TABLE
 	-- ============================================================================
 	-- Represents email users within a tenant's organization (synced from provider APIs).
 	--
 	-- Production considerations:
-	-- - Add role/title for executive impersonation detection
-	-- - Add manager_id for org chart modeling (detect requests bypassing approval chains)
 	-- - Add last_active_at for dormant account detection
-	-- - Extract those into a dedicated table for proper BEC (Business Email COmpromise) detection
 	--
-	-- Here we'll simplify to a single "role" column.
+	-- "role" is the directory job title. The org chart (manager_id), department,
+	-- group memberships and account state are added below.
 
 	CREATE TABLE IF NOT EXISTS users (
 		id UUID PRIMARY KEY,
@@ -91,6 +101,20 @@ func (s *PostgresStore) InitSchema() error {
 	-- Backs GetUserByEmail
 	CREATE INDEX IF NOT EXISTS idx_users_tenant_email ON users(tenant_id, email);
 
+	-- Directory sync: org chart, groups and deprovisioning
+	-- manager_id links each user to its manager (NULL at the top of the chart or when
+	-- the manager is unknown). Users missing from a sync are soft-deleted: deleted_at
+	-- is set, and cleared if they come back. Their emails and analyses are kept.
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS department VARCHAR(100) NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS groups TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS manager_id UUID REFERENCES users(id) ON DELETE SET NULL;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS synced_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+	-- Backs manager chain lookups
+	CREATE INDEX IF NOT EXISTS idx_users_manager ON users(manager_id) WHERE manager_id IS NOT NULL;
+
 	-- ============================================================================
 	-- EMAILS TABLE
 	-- ============================================================================
@@ -118,6 +142,9 @@ func (s *PostgresStore) InitSchema() error {
 	--    Why: Flexible storage for authentication headers (SPF, DKIM, DMARC) and routing info
 	--    Production: Dedicated headers table for efficient querying (e.g., "all emails with DMARC=fail")
 	--
+	-- Retro-hunt columns and indexes (attachment_hashes, sender_domain, sender_skeleton,
+	-- trigram and GIN indexes) are added by huntSchema.
+	--
 	-- Scaling considerations for production (not implemented in prototype):
 	-- - PARTITION BY RANGE(ingested_at): enables efficient time-based queries and cheap partition drops
 	-- - Retention policy: DROP old partitions monthly (e.g., "DROP TABLE emails_2025_01") instead of
@@ -167,8 +194,10 @@ func (s *PostgresStore) InitSchema() error {
 	--    Production: A human review loop is ESSENTIAL for tuning detection precision
 	--                Security teams must confirm or dismiss detections; feedback feeds back into model tuning and ML models.
 	--
-	-- 3. No versioning or A/B testing columns (model_version, experiment_id)
-	--    Production: Track which detection model version produced each analysis for debugging and rollback
+	-- 3. Versioning: detector_version and policy_version (see reprocessingSchema and
+	--    versionsSchema) record which detector and tenant configuration produced each
+	--    analysis. Reprocessing jobs and shadow detectors store their analyses as
+	--    history next to the current one, and versions are compared per email.
 
 	CREATE TABLE IF NOT EXISTS fraud_analyses (
 		id UUID PRIMARY KEY,
@@ -183,21 +212,45 @@ func (s *PostgresStore) InitSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_fraud_risk ON fraud_analyses(risk_level, analyzed_at DESC);
 	-- FK lookup: makes the JOIN emails ON fa.email_id = e.id efficient (avoids seq scan)
 	CREATE INDEX IF NOT EXISTS idx_fraud_email ON fraud_analyses(email_id);
+
+	-- Aggregator that combined detections into risk_score; per-detection contributions
+	-- are stored inside detected_threats
+	ALTER TABLE fraud_analyses ADD COLUMN IF NOT EXISTS aggregator VARCHAR(32);
+
+	-- Per-strategy trace (values, thresholds, timings); NULL unless the detector runs in trace mode
+	-- Only read back for a single email (GetFraudAnalysisByEmail), so it is not indexed
+	ALTER TABLE fraud_analyses ADD COLUMN IF NOT EXISTS trace JSONB;
+
+	-- At most one current analysis per email. Re-analysis demotes the previous one,
+	-- which is kept as history. Duplicates written before this constraint existed
+	-- (concurrent workers) are demoted first, keeping the latest.
+	ALTER TABLE fraud_analyses ADD COLUMN IF NOT EXISTS is_current BOOLEAN NOT NULL DEFAULT TRUE;
+	UPDATE fraud_analyses fa SET is_current = FALSE
+	WHERE fa.is_current AND EXISTS (
+		SELECT 1 FROM fraud_analyses newer
+		WHERE newer.email_id = fa.email_id AND newer.is_current
+		  AND (newer.analyzed_at, newer.id) > (fa.analyzed_at, fa.id)
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_fraud_current ON fraud_analyses(email_id) WHERE is_current;
 	`
 
-	_, err := s.db.Exec(schema)
+	_, err := s.db.Exec(schema + emailLeasesSchema + outboxSchema + detectionRulesSchema + webhookSchema + notificationRoutesSchema + remediationSchema + mailSubscriptionsSchema + dataKeysSchema + roleOverridesSchema + vipsSchema + senderListSchema + reprocessingSchema + versionsSchema + huntSchema)
 	return err
 }
 
 // CreateTenant inserts a new tenant
+// Credentials must be empty or sealed: they are never stored in the clear.
 func (s *PostgresStore) CreateTenant(ctx context.Context, tenant *domain.Tenant) error {
+	if err := checkSealed(tenant.ID, tenant.Credentials); err != nil {
+		return err
+	}
 	query := `
-		INSERT INTO tenants (id, name, provider, credentials, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO tenants (id, name, provider, credentials, status, created_at, updated_at, risk_aggregator)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
 	`
 	_, err := s.db.ExecContext(ctx, query,
 		tenant.ID, tenant.Name, tenant.Provider, tenant.Credentials,
-		tenant.Status, tenant.CreatedAt, tenant.UpdatedAt,
+		tenant.Status, tenant.CreatedAt, tenant.UpdatedAt, tenant.RiskAggregator,
 	)
 	return err
 }
@@ -205,57 +258,297 @@ func (s *PostgresStore) CreateTenant(ctx context.Context, tenant *domain.Tenant)
 // GetTenant retrieves a tenant by ID
 func (s *PostgresStore) GetTenant(ctx context.Context, id uuid.UUID) (*domain.Tenant, error) {
 	query := `
-		SELECT id, name, provider, credentials, status, created_at, updated_at
+		SELECT id, name, provider, credentials, status, created_at, updated_at,
+		       COALESCE(risk_aggregator, ''), vip_risk_thresholds
 		FROM tenants
 		WHERE id = $1
 	`
 	tenant := &domain.Tenant{}
+	var vipThresholds []byte
 	err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&tenant.ID, &tenant.Name, &tenant.Provider, &tenant.Credentials,
-		&tenant.Status, &tenant.CreatedAt, &tenant.UpdatedAt,
+		&tenant.Status, &tenant.CreatedAt, &tenant.UpdatedAt, &tenant.RiskAggregator, &vipThresholds,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return tenant, err
+	if err != nil {
+		return nil, err
+	}
+	if vipThresholds != nil {
+		tenant.VIPRiskThresholds = &domain.RiskThresholds{}
+		if err := json.Unmarshal(vipThresholds, tenant.VIPRiskThresholds); err != nil {
+			return nil, fmt.Errorf("failed to decode VIP risk thresholds of tenant %s: %w", id, err)
+		}
+	}
+	return tenant, nil
+}
+
+// UpdateTenantCredentials replaces a tenant's stored credentials and moves it from
+// status from to status to
+// Like UpdateTenantStatus, the update is conditional: credentials written after a
+// concurrent transition (offboarding, revoked access) must not undo it.
+func (s *PostgresStore) UpdateTenantCredentials(ctx context.Context, tenantID uuid.UUID, credentials, from, to string) error {
+	if err := checkSealed(tenantID, credentials); err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE tenants SET credentials = $2, status = $4, updated_at = NOW() WHERE id = $1 AND status = $3`,
+		tenantID, credentials, from, to,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: tenant %s is no longer %s", domain.ErrInvalidTransition, tenantID, from)
+	}
+	return nil
+}
+
+// UpdateTenantStatus moves a tenant from status from to status to
+// The update is conditional so concurrent transitions cannot overwrite each other.
+func (s *PostgresStore) UpdateTenantStatus(ctx context.Context, tenantID uuid.UUID, from, to string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE tenants SET status = $3, updated_at = NOW() WHERE id = $1 AND status = $2`,
+		tenantID, from, to,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: tenant %s is no longer %s", domain.ErrInvalidTransition, tenantID, from)
+	}
+	return nil
+}
+
+// DeleteTenant deletes a tenant; its users, emails, analyses, settings and data key
+// are deleted by cascade
+func (s *PostgresStore) DeleteTenant(ctx context.Context, tenantID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM tenants WHERE id = $1`, tenantID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "tenant", tenantID)
 }
 
-// CreateUser inserts a new user
+// userColumns are the columns scanned by scanUser
+const userColumns = `id, tenant_id, provider_user_id, email, COALESCE(display_name, ''), COALESCE(role, ''),
+	department, groups, disabled, manager_id, COALESCE(synced_at, created_at), deleted_at, created_at`
+
+// scanUser reads a user selected with userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*domain.User, error) {
+	user := &domain.User{}
+	var managerID uuid.NullUUID
+	var deletedAt sql.NullTime
+	err := row.Scan(
+		&user.ID, &user.TenantID, &user.ProviderUserID, &user.Email, &user.DisplayName, &user.Role,
+		&user.Department, pq.Array(&user.Groups), &user.Disabled, &managerID, &user.SyncedAt, &deletedAt, &user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if managerID.Valid {
+		user.ManagerID = &managerID.UUID
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	return user, nil
+}
+
+// CreateUser inserts or updates a user by provider user ID
+// user.ID is set to the stored user's ID. A departed user synced again is restored.
+// The manager is set separately (UpdateUserManagers): it may not be stored yet.
 func (s *PostgresStore) CreateUser(ctx context.Context, user *domain.User) error {
 	query := `
-		INSERT INTO users (id, tenant_id, provider_user_id, email, display_name, role, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO users (id, tenant_id, provider_user_id, email, display_name, role,
+			department, groups, disabled, synced_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		ON CONFLICT (tenant_id, provider_user_id) DO UPDATE
 		SET email = EXCLUDED.email,
 		    display_name = EXCLUDED.display_name,
-		    role = EXCLUDED.role
+		    role = EXCLUDED.role,
+		    department = EXCLUDED.department,
+		    groups = EXCLUDED.groups,
+		    disabled = EXCLUDED.disabled,
+		    synced_at = EXCLUDED.synced_at,
+		    deleted_at = NULL
+		RETURNING id
 	`
-	_, err := s.db.ExecContext(ctx, query,
-		user.ID, user.TenantID, user.ProviderUserID, user.Email,
-		user.DisplayName, user.Role, user.CreatedAt,
-	)
+	groups := user.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return s.db.QueryRowContext(ctx, query,
+		user.ID, user.TenantID, user.ProviderUserID, user.Email, user.DisplayName, user.Role,
+		user.Department, pq.Array(groups), user.Disabled, user.SyncedAt, user.CreatedAt,
+	).Scan(&user.ID)
+}
+
+// UpdateUserManagers sets the managers of a tenant's users, keyed by user ID (nil
+// clears the manager), in one statement
+func (s *PostgresStore) UpdateUserManagers(ctx context.Context, tenantID uuid.UUID, managers map[uuid.UUID]*uuid.UUID) error {
+	if len(managers) == 0 {
+		return nil
+	}
+	userIDs := make([]string, 0, len(managers))
+	managerIDs := make([]string, 0, len(managers))
+	for userID, managerID := range managers {
+		userIDs = append(userIDs, userID.String())
+		if managerID == nil {
+			managerIDs = append(managerIDs, "")
+		} else {
+			managerIDs = append(managerIDs, managerID.String())
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users u
+		SET manager_id = NULLIF(m.manager_id, '')::uuid
+		FROM unnest($2::uuid[], $3::text[]) AS m(id, manager_id)
+		WHERE u.tenant_id = $1 AND u.id = m.id
+	`, tenantID, pq.Array(userIDs), pq.Array(managerIDs))
 	return err
 }
 
-// GetUserByEmail retrieves a user by email and tenant
+// MarkUsersDeparted soft-deletes a tenant's users not synced since syncedBefore and
+// returns the number deleted
+func (s *PostgresStore) MarkUsersDeparted(ctx context.Context, tenantID uuid.UUID, syncedBefore time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET deleted_at = NOW()
+		WHERE tenant_id = $1 AND deleted_at IS NULL
+		  AND (synced_at IS NULL OR synced_at < $2)
+	`, tenantID, syncedBefore)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// GetUserByEmail retrieves a user by email and tenant, with its managers
 func (s *PostgresStore) GetUserByEmail(ctx context.Context, tenantID uuid.UUID, email string) (*domain.User, error) {
-	query := `
-		SELECT id, tenant_id, provider_user_id, email, display_name, role, created_at
-		FROM users
-		WHERE tenant_id = $1 AND email = $2
-	`
-	user := &domain.User{}
-	err := s.db.QueryRowContext(ctx, query, tenantID, email).Scan(
-		&user.ID, &user.TenantID, &user.ProviderUserID, &user.Email,
-		&user.DisplayName, &user.Role, &user.CreatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE tenant_id = $1 AND email = $2`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, tenantID, email))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return user, err
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadManagers(ctx, map[uuid.UUID]*domain.User{user.ID: user}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUsersByEmails retrieves the users of a tenant matching any of the addresses,
+// with their managers, in two queries, keyed by address; addresses without a user
+// are absent
+func (s *PostgresStore) GetUsersByEmails(ctx context.Context, tenantID uuid.UUID, emails []string) (map[string]*domain.User, error) {
+	users := make(map[string]*domain.User, len(emails))
+	if len(emails) == 0 {
+		return users, nil
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE tenant_id = $1 AND email = ANY($2)`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[uuid.UUID]*domain.User, len(emails))
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users[user.Email] = user
+		byID[user.ID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadManagers(ctx, byID); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// loadManagers sets the manager chains of users, keyed by ID, with a recursive query
+// Chains are bounded by directory.MaxDepth.
+func (s *PostgresStore) loadManagers(ctx context.Context, users map[uuid.UUID]*domain.User) error {
+	ids := make([]string, 0, len(users))
+	for id, user := range users {
+		if user.ManagerID != nil {
+			ids = append(ids, id.String())
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE chain AS (
+			SELECT u.id AS user_id, m.id, m.email, COALESCE(m.display_name, '') AS display_name, m.manager_id, 1 AS depth
+			FROM users u JOIN users m ON m.id = u.manager_id
+			WHERE u.id = ANY($1::uuid[])
+			UNION ALL
+			SELECT c.user_id, m.id, m.email, COALESCE(m.display_name, ''), m.manager_id, c.depth + 1
+			FROM chain c JOIN users m ON m.id = c.manager_id
+			WHERE c.depth < $2
+		)
+		SELECT user_id, id, email, display_name FROM chain ORDER BY user_id, depth
+	`, pq.Array(ids), directory.MaxDepth)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uuid.UUID
+		var manager domain.UserRef
+		if err := rows.Scan(&userID, &manager.ID, &manager.Email, &manager.DisplayName); err != nil {
+			return err
+		}
+		users[userID].Managers = append(users[userID].Managers, manager)
+	}
+	return rows.Err()
+}
+
+// ListUsers retrieves the users of a tenant still in its directory, by email
+func (s *PostgresStore) ListUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY email`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]domain.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
 }
 
 // CreateEmail inserts a new email
+// A newly inserted email writes its email.ingested event to the outbox in the same
+// transaction; an email that was already stored is a no-op and emits nothing.
 func (s *PostgresStore) CreateEmail(ctx context.Context, email *domain.Email) error {
 	attachmentJSON, err := json.Marshal(email.AttachmentNames)
 	if err != nil {
@@ -267,98 +560,127 @@ func (s *PostgresStore) CreateEmail(ctx context.Context, email *domain.Email) er
 		return fmt.Errorf("failed to marshal headers: %w", err)
 	}
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
 	query := `
 		INSERT INTO emails (
 			id, tenant_id, user_id, provider_message_id, subject,
 			sender_email, sender_name, recipient_email, received_at,
 			has_attachments, attachment_names, body_preview, headers,
-			ingested_at, processed_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			ingested_at, processed_at, attachment_hashes
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 		ON CONFLICT (tenant_id, provider_message_id) DO NOTHING
 	`
-	_, err = s.db.ExecContext(ctx, query,
+	result, err := tx.ExecContext(ctx, query,
 		email.ID, email.TenantID, email.UserID, email.ProviderMessageID,
 		email.Subject, email.SenderEmail, email.SenderName, email.RecipientEmail,
 		email.ReceivedAt, email.HasAttachments, attachmentJSON, email.BodyPreview,
-		headersJSON, email.IngestedAt, email.ProcessedAt,
+		headersJSON, email.IngestedAt, email.ProcessedAt, pq.Array(email.AttachmentHashes),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return nil // Already stored
+	}
+
+	event, err := domain.NewEmailIngestedEvent(*email)
+	if err != nil {
+		return err
+	}
+	if err := insertOutboxEvents(ctx, tx, []domain.Event{event}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetEmail retrieves an email by ID
 func (s *PostgresStore) GetEmail(ctx context.Context, id uuid.UUID) (*domain.Email, error) {
 	query := `
-		SELECT id, tenant_id, user_id, provider_message_id, subject,
-		       sender_email, sender_name, recipient_email, received_at,
-		       has_attachments, attachment_names, body_preview, headers,
-		       ingested_at, processed_at
+		SELECT ` + emailColumns + `
 		FROM emails
 		WHERE id = $1
 	`
-	email := &domain.Email{}
-	var attachmentJSON, headersJSON []byte
-
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&email.ID, &email.TenantID, &email.UserID, &email.ProviderMessageID,
-		&email.Subject, &email.SenderEmail, &email.SenderName, &email.RecipientEmail,
-		&email.ReceivedAt, &email.HasAttachments, &attachmentJSON, &email.BodyPreview,
-		&headersJSON, &email.IngestedAt, &email.ProcessedAt,
-	)
+	email, err := scanEmail(s.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-
-	json.Unmarshal(attachmentJSON, &email.AttachmentNames)
-	json.Unmarshal(headersJSON, &email.Headers)
-
-	return email, nil
+	return &email, nil
 }
 
-// GetUnprocessedEmails retrieves emails that haven't been analyzed yet
-func (s *PostgresStore) GetUnprocessedEmails(ctx context.Context, limit int) ([]domain.Email, error) {
+// emailColumns lists the columns scanEmails expects, in order
+const emailColumns = `
+	id, tenant_id, user_id, provider_message_id, subject,
+	sender_email, sender_name, recipient_email, received_at,
+	has_attachments, attachment_names, body_preview, headers,
+	ingested_at, processed_at, attachment_hashes`
+
+// GetUnprocessedEmails retrieves a tenant's emails that haven't been analyzed yet
+// It does not claim them: concurrent callers get the same emails. Workers use
+// ClaimUnprocessedEmails instead.
+func (s *PostgresStore) GetUnprocessedEmails(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.Email, error) {
 	query := `
-		SELECT id, tenant_id, user_id, provider_message_id, subject,
-		       sender_email, sender_name, recipient_email, received_at,
-		       has_attachments, attachment_names, body_preview, headers,
-		       ingested_at, processed_at
+		SELECT ` + emailColumns + `
 		FROM emails
-		WHERE processed_at IS NULL
+		WHERE tenant_id = $1 AND processed_at IS NULL
 		ORDER BY received_at ASC
-		LIMIT $1
+		LIMIT $2
 	`
-	rows, err := s.db.QueryContext(ctx, query, limit)
+	rows, err := s.db.QueryContext(ctx, query, tenantID, limit)
 	if err != nil {
 		return nil, err
 	}
+	return scanEmails(rows)
+}
+
+// scanEmails reads rows selected with emailColumns and closes them
+func scanEmails(rows *sql.Rows) ([]domain.Email, error) {
 	defer rows.Close()
 
 	emails := make([]domain.Email, 0)
 	for rows.Next() {
-		var email domain.Email
-		var attachmentJSON, headersJSON []byte
-
-		err := rows.Scan(
-			&email.ID, &email.TenantID, &email.UserID, &email.ProviderMessageID,
-			&email.Subject, &email.SenderEmail, &email.SenderName, &email.RecipientEmail,
-			&email.ReceivedAt, &email.HasAttachments, &attachmentJSON, &email.BodyPreview,
-			&headersJSON, &email.IngestedAt, &email.ProcessedAt,
-		)
+		email, err := scanEmail(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		json.Unmarshal(attachmentJSON, &email.AttachmentNames)
-		json.Unmarshal(headersJSON, &email.Headers)
-
 		emails = append(emails, email)
 	}
 
 	return emails, rows.Err()
 }
 
+// scanEmail reads a row selected with emailColumns, followed by extra columns
+func scanEmail(row interface{ Scan(dest ...any) error }, extra ...any) (domain.Email, error) {
+	var email domain.Email
+	var attachmentJSON, headersJSON []byte
+
+	dest := append([]any{
+		&email.ID, &email.TenantID, &email.UserID, &email.ProviderMessageID,
+		&email.Subject, &email.SenderEmail, &email.SenderName, &email.RecipientEmail,
+		&email.ReceivedAt, &email.HasAttachments, &attachmentJSON, &email.BodyPreview,
+		&headersJSON, &email.IngestedAt, &email.ProcessedAt, pq.Array(&email.AttachmentHashes),
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return email, err
+	}
+
+	json.Unmarshal(attachmentJSON, &email.AttachmentNames)
+	json.Unmarshal(headersJSON, &email.Headers)
+
+	return email, nil
+}
+
 // MarkEmailProcessed updates email's processed_at timestamp
 func (s *PostgresStore) MarkEmailProcessed(ctx context.Context, emailID uuid.UUID) error {
 	query := `UPDATE emails SET processed_at = NOW() WHERE id = $1`
@@ -366,32 +688,208 @@ func (s *PostgresStore) MarkEmailProcessed(ctx context.Context, emailID uuid.UUI
 	return err
 }
 
-// CreateFraudAnalysis inserts a fraud analysis result
+// CreateFraudAnalysis inserts a fraud analysis result as the email's current analysis
+// Its analysis.completed (and threat.detected) events are written to the outbox in the
+// same transaction. The generated ID is set on analysis.
 func (s *PostgresStore) CreateFraudAnalysis(ctx context.Context, analysis *domain.FraudAnalysis) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	email := domain.Email{ID: analysis.EmailID}
+	err = tx.QueryRowContext(ctx, `
+		SELECT tenant_id, subject, sender_email, sender_name, recipient_email
+		FROM emails WHERE id = $1
+	`, analysis.EmailID).Scan(&email.TenantID, &email.Subject, &email.SenderEmail, &email.SenderName, &email.RecipientEmail)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("email %s not found", analysis.EmailID)
+	}
+	if err != nil {
+		return err
+	}
+
+	analyses := []domain.FraudAnalysis{*analysis}
+	if err := insertCurrentAnalyses(ctx, tx, analyses, map[uuid.UUID]domain.Email{email.ID: email}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*analysis = analyses[0]
+	return nil
+}
+
+// analysisArgs returns the insert values of an analysis, in fraud_analyses column order
+func analysisArgs(analysis *domain.FraudAnalysis) ([]interface{}, error) {
 	threatsJSON, err := json.Marshal(analysis.DetectedThreats)
 	if err != nil {
-		return fmt.Errorf("failed to marshal threats: %w", err)
+		return nil, fmt.Errorf("failed to marshal threats: %w", err)
 	}
 
+	// Stored as NULL when tracing is off
+	var traceJSON []byte
+	if analysis.Trace != nil {
+		traceJSON, err = json.Marshal(analysis.Trace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal trace: %w", err)
+		}
+	}
+
+	// Stored as NULL when no sender list entry applied
+	var overrideJSON []byte
+	if analysis.Override != nil {
+		overrideJSON, err = json.Marshal(analysis.Override)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal override: %w", err)
+		}
+	}
+
+	return []interface{}{
+		analysis.ID, analysis.EmailID, analysis.RiskScore, analysis.RiskLevel,
+		analysis.Aggregator, threatsJSON, traceJSON, overrideJSON, analysis.DetectorVersion, analysis.PolicyVersion, analysis.AnalyzedAt,
+	}, nil
+}
+
+// analysisColumns are the fraud_analyses columns of analysisArgs, in order
+const analysisColumns = `id, email_id, risk_score, risk_level, aggregator, detected_threats, trace, override, detector_version, policy_version, analyzed_at`
+
+// analysisInsert inserts rows built by analysisArgs
+const analysisInsert = `INSERT INTO fraud_analyses (` + analysisColumns + `)`
+
+// insertCurrentAnalyses demotes the current analyses of the emails, inserts the new
+// ones as current and writes their events to the outbox
+//
+// IDs and timestamps are assigned in place. emails provides the tenant and alert
+// fields of each analysis' email, for the events.
+func insertCurrentAnalyses(ctx context.Context, tx *sql.Tx, analyses []domain.FraudAnalysis, emails map[uuid.UUID]domain.Email) error {
+	emailIDs := make([]uuid.UUID, len(analyses))
+	rows := make([][]interface{}, len(analyses))
+	events := make([]domain.Event, 0, len(analyses))
+	now := time.Now()
+	for i := range analyses {
+		analysis := &analyses[i]
+		analysis.ID = uuid.New()
+		analysis.AnalyzedAt = now
+		emailIDs[i] = analysis.EmailID
+
+		row, err := analysisArgs(analysis)
+		if err != nil {
+			return err
+		}
+		rows[i] = row
+
+		analysisEvents, err := domain.NewAnalysisEvents(emails[analysis.EmailID], *analysis)
+		if err != nil {
+			return err
+		}
+		events = append(events, analysisEvents...)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE fraud_analyses SET is_current = FALSE WHERE email_id = ANY($1::uuid[]) AND is_current`, pq.Array(uuidStrings(emailIDs)),
+	); err != nil {
+		return fmt.Errorf("failed to demote previous analyses: %w", err)
+	}
+
+	err := insertRows(ctx, tx, analysisInsert, rows, "")
+	if err != nil {
+		return fmt.Errorf("failed to insert analyses: %w", err)
+	}
+	return insertOutboxEvents(ctx, tx, events)
+}
+
+// maxInsertParams is the number of parameters PostgreSQL accepts in one statement
+const maxInsertParams = 65535
+
+// insertRows runs a multi-row INSERT, one statement per chunk of rows that fits the
+// parameter limit, instead of a round trip per row
+// All rows must have the same number of values; suffix follows VALUES (e.g. ON CONFLICT).
+func insertRows(ctx context.Context, tx *sql.Tx, insert string, rows [][]interface{}, suffix string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	chunkSize := maxInsertParams / len(rows[0])
+
+	for start := 0; start < len(rows); start += chunkSize {
+		chunk := rows[start:min(start+chunkSize, len(rows))]
+
+		var query strings.Builder
+		query.WriteString(insert)
+		query.WriteString(" VALUES ")
+		args := make([]interface{}, 0, len(chunk)*len(rows[0]))
+		for i, row := range chunk {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for col := range row {
+				if col > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+col+1)
+			}
+			query.WriteString(")")
+			args = append(args, row...)
+		}
+		query.WriteString(" ")
+		query.WriteString(suffix)
+
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFraudAnalysisByEmail retrieves the current analysis of an email, including its trace
+func (s *PostgresStore) GetFraudAnalysisByEmail(ctx context.Context, emailID uuid.UUID) (*domain.FraudAnalysis, error) {
 	query := `
-		INSERT INTO fraud_analyses (id, email_id, risk_score, risk_level, detected_threats, analyzed_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		SELECT id, email_id, risk_score, risk_level, COALESCE(aggregator, ''),
+		       detected_threats, trace, override, COALESCE(detector_version, ''), COALESCE(policy_version, ''), analyzed_at
+		FROM fraud_analyses
+		WHERE email_id = $1 AND is_current
 	`
-	_, err = s.db.ExecContext(ctx, query,
-		uuid.New(), analysis.EmailID, analysis.RiskScore, analysis.RiskLevel,
-		threatsJSON, time.Now(),
+	analysis := &domain.FraudAnalysis{}
+	var threatsJSON, traceJSON, overrideJSON []byte
+	err := s.db.QueryRowContext(ctx, query, emailID).Scan(
+		&analysis.ID, &analysis.EmailID, &analysis.RiskScore, &analysis.RiskLevel,
+		&analysis.Aggregator, &threatsJSON, &traceJSON, &overrideJSON, &analysis.DetectorVersion, &analysis.PolicyVersion, &analysis.AnalyzedAt,
 	)
-	return err
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(threatsJSON, &analysis.DetectedThreats); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal threats: %w", err)
+	}
+	if traceJSON != nil {
+		analysis.Trace = &domain.AnalysisTrace{}
+		if err := json.Unmarshal(traceJSON, analysis.Trace); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal trace: %w", err)
+		}
+	}
+	if overrideJSON != nil {
+		analysis.Override = &domain.AnalysisOverride{}
+		if err := json.Unmarshal(overrideJSON, analysis.Override); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal override: %w", err)
+		}
+	}
+	return analysis, nil
 }
 
 // GetHighRiskEmails retrieves emails with high/critical risk levels
 func (s *PostgresStore) GetHighRiskEmails(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.FraudAnalysis, error) {
 	query := `
 		SELECT fa.id, fa.email_id, fa.risk_score, fa.risk_level,
-		       fa.detected_threats, fa.analyzed_at
+		       COALESCE(fa.aggregator, ''), fa.detected_threats, fa.analyzed_at
 		FROM fraud_analyses fa
 		JOIN emails e ON fa.email_id = e.id
-		WHERE e.tenant_id = $1 AND fa.risk_level IN ('high', 'critical')
+		WHERE e.tenant_id = $1 AND fa.is_current AND fa.risk_level IN ('high', 'critical')
 		ORDER BY fa.risk_score DESC, fa.analyzed_at DESC
 		LIMIT $2
 	`
@@ -408,7 +906,7 @@ func (s *PostgresStore) GetHighRiskEmails(ctx context.Context, tenantID uuid.UUI
 
 		err := rows.Scan(
 			&analysis.ID, &analysis.EmailID, &analysis.RiskScore, &analysis.RiskLevel,
-			&threatsJSON, &analysis.AnalyzedAt,
+			&analysis.Aggregator, &threatsJSON, &analysis.AnalyzedAt,
 		)
 		if err != nil {
 			return nil, err
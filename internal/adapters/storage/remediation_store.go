@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// remediationSchema creates the remediation policy and audit tables
+const remediationSchema = `
+	-- ============================================================================
+	-- REMEDIATION_POLICIES TABLE
+	-- ============================================================================
+	-- Actions applied automatically to a tenant's emails, per risk level
+	-- (e.g. critical: banner + quarantine, high: banner + label).
+	CREATE TABLE IF NOT EXISTS remediation_policies (
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		risk_level VARCHAR(20) NOT NULL,
+		actions TEXT[] NOT NULL,
+		label VARCHAR(100),
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		updated_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (tenant_id, risk_level)
+	);
+
+	-- ============================================================================
+	-- REMEDIATION_ACTIONS TABLE
+	-- ============================================================================
+	-- Audit log of every action taken on a mailbox message, by policy or analyst,
+	-- successful or not. restore_state is what the provider needs to undo it.
+	-- message_id is the provider message acted on: after a banner, the bannered copy
+	-- (replaced_by) rather than the original.
+	--
+	-- Append-only except for the restore columns.
+	CREATE TABLE IF NOT EXISTS remediation_actions (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		email_id UUID NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+		analysis_id UUID,
+		action VARCHAR(20) NOT NULL,
+		label VARCHAR(100),
+		status VARCHAR(20) NOT NULL CHECK (status IN ('applied', 'failed', 'restored')),
+		actor VARCHAR(255) NOT NULL,
+		error TEXT,
+		restore_state TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		restored_at TIMESTAMP,
+		restored_by VARCHAR(255)
+	);
+
+	ALTER TABLE remediation_actions ADD COLUMN IF NOT EXISTS message_id VARCHAR(255);
+	ALTER TABLE remediation_actions ADD COLUMN IF NOT EXISTS replaced_by VARCHAR(255);
+
+	CREATE INDEX IF NOT EXISTS idx_remediation_actions_email ON remediation_actions(email_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_remediation_actions_tenant ON remediation_actions(tenant_id, created_at DESC);
+`
+
+// UpsertRemediationPolicy creates or replaces a tenant's policy for a risk level
+func (s *PostgresStore) UpsertRemediationPolicy(ctx context.Context, policy *domain.RemediationPolicy) error {
+	query := `
+		INSERT INTO remediation_policies (tenant_id, risk_level, actions, label, enabled, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		ON CONFLICT (tenant_id, risk_level) DO UPDATE SET
+			actions = EXCLUDED.actions, label = EXCLUDED.label,
+			enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		policy.TenantID, policy.RiskLevel, pq.Array(policy.Actions), policy.Label, policy.Enabled, policy.UpdatedAt,
+	)
+	return err
+}
+
+// ListRemediationPolicies retrieves all policies of a tenant, enabled or not
+func (s *PostgresStore) ListRemediationPolicies(ctx context.Context, tenantID uuid.UUID) ([]domain.RemediationPolicy, error) {
+	query := `
+		SELECT tenant_id, risk_level, actions, COALESCE(label, ''), enabled, updated_at
+		FROM remediation_policies
+		WHERE tenant_id = $1
+		ORDER BY risk_level
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := make([]domain.RemediationPolicy, 0)
+	for rows.Next() {
+		var policy domain.RemediationPolicy
+		err := rows.Scan(
+			&policy.TenantID, &policy.RiskLevel, pq.Array(&policy.Actions), &policy.Label, &policy.Enabled, &policy.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+// CreateRemediationAction inserts an audit record
+func (s *PostgresStore) CreateRemediationAction(ctx context.Context, action *domain.RemediationAction) error {
+	query := `
+		INSERT INTO remediation_actions (id, tenant_id, email_id, analysis_id, action, label, status, actor, error, restore_state, created_at, message_id, replaced_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, NULLIF($12, ''), NULLIF($13, ''))
+	`
+	var analysisID interface{}
+	if action.AnalysisID != uuid.Nil {
+		analysisID = action.AnalysisID
+	}
+	_, err := s.db.ExecContext(ctx, query,
+		action.ID, action.TenantID, action.EmailID, analysisID, action.Action, action.Label,
+		action.Status, action.Actor, action.Error, action.RestoreState, action.CreatedAt,
+		action.MessageID, action.ReplacedBy,
+	)
+	return err
+}
+
+// remediationActionColumns are scanned by scanRemediationAction, in order
+const remediationActionColumns = `
+	id, tenant_id, email_id, analysis_id, action, COALESCE(label, ''), status, actor,
+	COALESCE(error, ''), COALESCE(restore_state, ''), created_at, restored_at, COALESCE(restored_by, ''),
+	COALESCE(message_id, ''), COALESCE(replaced_by, '')
+`
+
+func scanRemediationAction(row interface{ Scan(...interface{}) error }) (domain.RemediationAction, error) {
+	var action domain.RemediationAction
+	var analysisID uuid.NullUUID
+	err := row.Scan(
+		&action.ID, &action.TenantID, &action.EmailID, &analysisID, &action.Action, &action.Label,
+		&action.Status, &action.Actor, &action.Error, &action.RestoreState, &action.CreatedAt,
+		&action.RestoredAt, &action.RestoredBy, &action.MessageID, &action.ReplacedBy,
+	)
+	action.AnalysisID = analysisID.UUID
+	return action, err
+}
+
+// GetRemediationAction retrieves one of a tenant's audit records (nil if not found)
+func (s *PostgresStore) GetRemediationAction(ctx context.Context, tenantID, actionID uuid.UUID) (*domain.RemediationAction, error) {
+	query := `SELECT ` + remediationActionColumns + ` FROM remediation_actions WHERE tenant_id = $1 AND id = $2`
+	action, err := scanRemediationAction(s.db.QueryRowContext(ctx, query, tenantID, actionID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// ListRemediationActions retrieves the audit records of an email, oldest first
+func (s *PostgresStore) ListRemediationActions(ctx context.Context, tenantID, emailID uuid.UUID) ([]domain.RemediationAction, error) {
+	query := `
+		SELECT ` + remediationActionColumns + `
+		FROM remediation_actions
+		WHERE tenant_id = $1 AND email_id = $2
+		ORDER BY created_at, id
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, emailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := make([]domain.RemediationAction, 0)
+	for rows.Next() {
+		action, err := scanRemediationAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, rows.Err()
+}
+
+// MarkRemediationRestored records that an applied action was undone
+// Only applied actions can be restored, so concurrent restores cannot both succeed.
+func (s *PostgresStore) MarkRemediationRestored(ctx context.Context, tenantID, actionID uuid.UUID, by string, at time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE remediation_actions
+		SET status = 'restored', restored_at = $3, restored_by = $4
+		WHERE tenant_id = $1 AND id = $2 AND status = 'applied'
+	`, tenantID, actionID, at, by)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "applied remediation action", actionID)
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// reprocessingSchema versions analyses by detector and backs reprocessing jobs
+const reprocessingSchema = `
+	-- Version of the detector that produced each analysis (detection.Version). A
+	-- reprocessing job stores its analyses next to the email's existing ones, as
+	-- history (is_current = FALSE) unless it promotes them; NULL predates versioning.
+	ALTER TABLE fraud_analyses ADD COLUMN IF NOT EXISTS detector_version VARCHAR(32);
+
+	-- Backs ListProcessedEmails: keyset pagination over a tenant's time range
+	CREATE INDEX IF NOT EXISTS idx_emails_tenant_received_id ON emails(tenant_id, received_at, id)
+		WHERE processed_at IS NOT NULL;
+`
+
+// ListProcessedEmails retrieves up to limit of a tenant's analyzed emails received
+// after a cursor and before to, oldest first
+// Callers page through a time range by passing the last email of a page as the next
+// cursor; unlike offsets, pages stay consistent while new emails arrive.
+func (s *PostgresStore) ListProcessedEmails(ctx context.Context, tenantID uuid.UUID, after domain.EmailCursor, to time.Time, limit int) ([]domain.Email, error) {
+	query := `
+		SELECT ` + emailColumns + `
+		FROM emails
+		WHERE tenant_id = $1 AND processed_at IS NOT NULL
+		  AND (received_at, id) > ($2, $3) AND received_at < $4
+		ORDER BY received_at ASC, id ASC
+		LIMIT $5
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, after.ReceivedAt, after.ID, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmails(rows)
+}
+
+// GetCurrentAnalyses retrieves the current analyses of emails, without their traces,
+// keyed by email ID; emails never analyzed are absent
+func (s *PostgresStore) GetCurrentAnalyses(ctx context.Context, emailIDs []uuid.UUID) (map[uuid.UUID]domain.FraudAnalysis, error) {
+	analyses := make(map[uuid.UUID]domain.FraudAnalysis, len(emailIDs))
+	if len(emailIDs) == 0 {
+		return analyses, nil
+	}
+
+	query := `
+		SELECT id, email_id, risk_score, risk_level, COALESCE(aggregator, ''),
+		       detected_threats, override, COALESCE(detector_version, ''), COALESCE(policy_version, ''), analyzed_at
+		FROM fraud_analyses
+		WHERE email_id = ANY($1::uuid[]) AND is_current
+	`
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(uuidStrings(emailIDs)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var analysis domain.FraudAnalysis
+		var threatsJSON, overrideJSON []byte
+		err := rows.Scan(
+			&analysis.ID, &analysis.EmailID, &analysis.RiskScore, &analysis.RiskLevel,
+			&analysis.Aggregator, &threatsJSON, &overrideJSON, &analysis.DetectorVersion, &analysis.PolicyVersion, &analysis.AnalyzedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(threatsJSON, &analysis.DetectedThreats); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal threats: %w", err)
+		}
+		if overrideJSON != nil {
+			analysis.Override = &domain.AnalysisOverride{}
+			if err := json.Unmarshal(overrideJSON, analysis.Override); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal override: %w", err)
+			}
+		}
+		analyses[analysis.EmailID] = analysis
+	}
+	return analyses, rows.Err()
+}
+
+// StoreVersionedAnalyses stores analyses produced outside live detection, by a
+// reprocessing job or a shadow detector, next to the emails' existing ones
+//
+// Unless promote, they are kept as history and the current analyses are untouched.
+// With promote, they replace the current analyses, in one transaction. No events are
+// written: neither old mail nor a candidate detector may alert. IDs and timestamps are
+// assigned in place.
+func (s *PostgresStore) StoreVersionedAnalyses(ctx context.Context, analyses []domain.FraudAnalysis, promote bool) error {
+	if len(analyses) == 0 {
+		return nil
+	}
+
+	emailIDs := make([]uuid.UUID, len(analyses))
+	rows := make([][]interface{}, len(analyses))
+	now := time.Now()
+	for i := range analyses {
+		analysis := &analyses[i]
+		analysis.ID = uuid.New()
+		analysis.AnalyzedAt = now
+		emailIDs[i] = analysis.EmailID
+
+		row, err := analysisArgs(analysis)
+		if err != nil {
+			return err
+		}
+		rows[i] = append(row, promote)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	if promote {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE fraud_analyses SET is_current = FALSE WHERE email_id = ANY($1::uuid[]) AND is_current`, pq.Array(uuidStrings(emailIDs)),
+		); err != nil {
+			return fmt.Errorf("failed to demote previous analyses: %w", err)
+		}
+	}
+
+	err = insertRows(ctx, tx, `INSERT INTO fraud_analyses (`+analysisColumns+`, is_current)`, rows, "")
+	if err != nil {
+		return fmt.Errorf("failed to insert reprocessed analyses: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// roleOverridesSchema creates the per-tenant role override table
+const roleOverridesSchema = `
+	-- ============================================================================
+	-- ROLE_OVERRIDES TABLE
+	-- ============================================================================
+	-- Tenant corrections of the role taxonomy classification (internal/domain/roles):
+	-- either a job title (stored normalized) or a user address (stored lowercase),
+	-- never both. Loaded with the tenant's detector for every processing batch.
+	CREATE TABLE IF NOT EXISTS role_overrides (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		title VARCHAR(200) NOT NULL DEFAULT '',
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		functions TEXT[] NOT NULL DEFAULT '{}',
+		seniority VARCHAR(20) NOT NULL DEFAULT '',
+		vip BOOLEAN NOT NULL DEFAULT FALSE,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW(),
+		CHECK ((title = '') <> (email = '')),
+		UNIQUE(tenant_id, title, email)
+	);
+`
+
+// UpsertRoleOverride inserts an override, or replaces the tenant's override of the
+// same title or user (override.ID and CreatedAt are then set to the stored ones)
+func (s *PostgresStore) UpsertRoleOverride(ctx context.Context, override *domain.RoleOverride) error {
+	query := `
+		INSERT INTO role_overrides (id, tenant_id, title, email, functions, seniority, vip, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (tenant_id, title, email) DO UPDATE
+		SET functions = EXCLUDED.functions,
+		    seniority = EXCLUDED.seniority,
+		    vip = EXCLUDED.vip,
+		    reason = EXCLUDED.reason
+		RETURNING id, created_at
+	`
+	functions := override.Functions
+	if functions == nil {
+		functions = []string{}
+	}
+	return s.db.QueryRowContext(ctx, query,
+		override.ID, override.TenantID, override.Title, override.Email, pq.Array(functions),
+		override.Seniority, override.VIP, override.Reason, override.CreatedAt,
+	).Scan(&override.ID, &override.CreatedAt)
+}
+
+// DeleteRoleOverride removes a tenant's override
+func (s *PostgresStore) DeleteRoleOverride(ctx context.Context, tenantID, overrideID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM role_overrides WHERE tenant_id = $1 AND id = $2`, tenantID, overrideID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "role override", overrideID)
+}
+
+// ListRoleOverrides retrieves the overrides of a tenant, titles first
+func (s *PostgresStore) ListRoleOverrides(ctx context.Context, tenantID uuid.UUID) ([]domain.RoleOverride, error) {
+	query := `
+		SELECT id, tenant_id, title, email, functions, seniority, vip, reason, created_at
+		FROM role_overrides
+		WHERE tenant_id = $1
+		ORDER BY email, title
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make([]domain.RoleOverride, 0)
+	for rows.Next() {
+		var override domain.RoleOverride
+		err := rows.Scan(
+			&override.ID, &override.TenantID, &override.Title, &override.Email, pq.Array(&override.Functions),
+			&override.Seniority, &override.VIP, &override.Reason, &override.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
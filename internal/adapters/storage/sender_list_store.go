@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// senderListSchema creates the per-tenant sender allow/block entries and their audit
+// trail, and the analyses' override column
+const senderListSchema = `
+	-- ============================================================================
+	-- SENDER_LIST_ENTRIES TABLE
+	-- ============================================================================
+	-- Tenant allow and block entries for senders (package senderlist). Values are
+	-- stored normalized, so one value has one entry per tenant and match type.
+	-- Expired entries are kept: they no longer match, and the audit trail refers to them.
+	CREATE TABLE IF NOT EXISTS sender_list_entries (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		action VARCHAR(10) NOT NULL CHECK (action IN ('allow', 'block')),
+		match_type VARCHAR(20) NOT NULL CHECK (match_type IN ('address', 'domain', 'registrable_domain', 'ip_cidr')),
+		value VARCHAR(255) NOT NULL,
+		reason TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		created_by VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE(tenant_id, match_type, value)
+	);
+
+	-- Append-only audit trail of sender list changes, with a copy of the entry
+	-- No FK to the entry: records outlive deleted entries
+	CREATE TABLE IF NOT EXISTS sender_list_audit (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		entry_id UUID NOT NULL,
+		operation VARCHAR(10) NOT NULL,
+		entry JSONB NOT NULL,
+		actor VARCHAR(255) NOT NULL,
+		at TIMESTAMP NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_sender_list_audit_tenant ON sender_list_audit(tenant_id, at DESC);
+
+	-- Sender list entry that decided the analysis (NULL if none)
+	ALTER TABLE fraud_analyses ADD COLUMN IF NOT EXISTS override JSONB;
+`
+
+// CreateSenderListEntry stores an entry and its audit record in one transaction
+func (s *PostgresStore) CreateSenderListEntry(ctx context.Context, entry *domain.SenderListEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sender_list_entries (id, tenant_id, action, match_type, value, reason, expires_at, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, entry.ID, entry.TenantID, entry.Action, entry.MatchType, entry.Value, entry.Reason,
+		entry.ExpiresAt, entry.CreatedBy, entry.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if err := insertSenderListChange(ctx, tx, domain.SenderListCreated, *entry, entry.CreatedBy, entry.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteSenderListEntry removes an entry and records who removed it, in one transaction
+func (s *PostgresStore) DeleteSenderListEntry(ctx context.Context, tenantID, entryID uuid.UUID, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx,
+		`DELETE FROM sender_list_entries WHERE tenant_id = $1 AND id = $2 RETURNING `+senderListColumns,
+		tenantID, entryID,
+	)
+	entry, err := scanSenderListEntry(row)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("sender list entry %s not found", entryID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertSenderListChange(ctx, tx, domain.SenderListDeleted, *entry, actor, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListSenderListEntries retrieves the entries of a tenant, expired ones included
+func (s *PostgresStore) ListSenderListEntries(ctx context.Context, tenantID uuid.UUID) ([]domain.SenderListEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+senderListColumns+`
+		FROM sender_list_entries
+		WHERE tenant_id = $1
+		ORDER BY match_type, value
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]domain.SenderListEntry, 0)
+	for rows.Next() {
+		entry, err := scanSenderListEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ListSenderListChanges retrieves the latest sender list changes of a tenant, newest first
+func (s *PostgresStore) ListSenderListChanges(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.SenderListChange, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tenant_id, entry_id, operation, entry, actor, at
+		FROM sender_list_audit
+		WHERE tenant_id = $1
+		ORDER BY at DESC
+		LIMIT $2
+	`, tenantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]domain.SenderListChange, 0)
+	for rows.Next() {
+		var change domain.SenderListChange
+		var entryJSON []byte
+		err := rows.Scan(&change.ID, &change.TenantID, &change.EntryID, &change.Operation, &entryJSON, &change.Actor, &change.At)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(entryJSON, &change.Entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audited sender list entry: %w", err)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// senderListColumns are the columns scanned by scanSenderListEntry
+const senderListColumns = `id, tenant_id, action, match_type, value, reason, expires_at, created_by, created_at`
+
+// scanSenderListEntry reads an entry selected with senderListColumns
+func scanSenderListEntry(row interface{ Scan(...interface{}) error }) (*domain.SenderListEntry, error) {
+	entry := &domain.SenderListEntry{}
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&entry.ID, &entry.TenantID, &entry.Action, &entry.MatchType, &entry.Value, &entry.Reason,
+		&expiresAt, &entry.CreatedBy, &entry.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		entry.ExpiresAt = &expiresAt.Time
+	}
+	return entry, nil
+}
+
+// insertSenderListChange appends an audit record of a sender list change
+func insertSenderListChange(ctx context.Context, tx *sql.Tx, operation string, entry domain.SenderListEntry, actor string, at time.Time) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sender list entry: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sender_list_audit (id, tenant_id, entry_id, operation, entry, actor, at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, uuid.New(), entry.TenantID, entry.ID, operation, entryJSON, actor, at)
+	return err
+}
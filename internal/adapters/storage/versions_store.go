@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// versionsSchema records the tenant policy behind each analysis and backs version comparisons
+// Executed by InitSchema after reprocessingSchema.
+const versionsSchema = `
+	-- Fingerprint of the tenant configuration (rules, role overrides, sender list, VIPs,
+	-- aggregator) the detector applied; NULL predates versioning
+	ALTER TABLE fraud_analyses ADD COLUMN IF NOT EXISTS policy_version VARCHAR(32);
+
+	-- Backs ListAnalysesByVersion: a shadow or reprocessed version's analyses of an email
+	CREATE INDEX IF NOT EXISTS idx_fraud_version ON fraud_analyses(detector_version, email_id, analyzed_at DESC);
+`
+
+// ListAnalysesByVersion retrieves, for each of a tenant's emails received in [from, to),
+// its latest analysis by a detector version, current or not, keyed by email ID
+// Traces are not read: comparisons only need verdicts.
+func (s *PostgresStore) ListAnalysesByVersion(ctx context.Context, tenantID uuid.UUID, detectorVersion string, from, to time.Time) (map[uuid.UUID]domain.FraudAnalysis, error) {
+	query := `
+		SELECT DISTINCT ON (fa.email_id)
+		       fa.id, fa.email_id, fa.risk_score, fa.risk_level, COALESCE(fa.aggregator, ''),
+		       fa.detected_threats, COALESCE(fa.policy_version, ''), fa.analyzed_at
+		FROM fraud_analyses fa
+		JOIN emails e ON e.id = fa.email_id
+		WHERE e.tenant_id = $1 AND e.received_at >= $2 AND e.received_at < $3
+		  AND fa.detector_version = $4
+		ORDER BY fa.email_id, fa.analyzed_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, from, to, detectorVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	analyses := make(map[uuid.UUID]domain.FraudAnalysis)
+	for rows.Next() {
+		analysis := domain.FraudAnalysis{DetectorVersion: detectorVersion}
+		var threatsJSON []byte
+		err := rows.Scan(
+			&analysis.ID, &analysis.EmailID, &analysis.RiskScore, &analysis.RiskLevel,
+			&analysis.Aggregator, &threatsJSON, &analysis.PolicyVersion, &analysis.AnalyzedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(threatsJSON, &analysis.DetectedThreats); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal threats: %w", err)
+		}
+		analyses[analysis.EmailID] = analysis
+	}
+	return analyses, rows.Err()
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// vipsSchema creates the per-tenant VIP list and the tenants' VIP risk thresholds
+const vipsSchema = `
+	-- ============================================================================
+	-- VIPS TABLE
+	-- ============================================================================
+	-- Tenant-designated high-value users (one row per mailbox, stored lowercase).
+	-- Their names and aliases must not appear on external mail; mail to them is
+	-- graded with the tenant's VIP risk thresholds.
+	CREATE TABLE IF NOT EXISTS vips (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		email VARCHAR(255) NOT NULL,
+		name VARCHAR(200) NOT NULL,
+		aliases TEXT[] NOT NULL DEFAULT '{}',
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE(tenant_id, email)
+	);
+
+	-- Risk thresholds for mail to VIPs ({"critical": 0.7, ...}); NULL uses the defaults
+	ALTER TABLE tenants ADD COLUMN IF NOT EXISTS vip_risk_thresholds JSONB;
+`
+
+// UpsertVIP inserts a VIP, or replaces the tenant's VIP with the same address
+// (vip.ID and CreatedAt are then set to the stored ones)
+func (s *PostgresStore) UpsertVIP(ctx context.Context, vip *domain.VIP) error {
+	query := `
+		INSERT INTO vips (id, tenant_id, email, name, aliases, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (tenant_id, email) DO UPDATE
+		SET name = EXCLUDED.name,
+		    aliases = EXCLUDED.aliases,
+		    reason = EXCLUDED.reason
+		RETURNING id, created_at
+	`
+	aliases := vip.Aliases
+	if aliases == nil {
+		aliases = []string{}
+	}
+	return s.db.QueryRowContext(ctx, query,
+		vip.ID, vip.TenantID, vip.Email, vip.Name, pq.Array(aliases), vip.Reason, vip.CreatedAt,
+	).Scan(&vip.ID, &vip.CreatedAt)
+}
+
+// DeleteVIP removes a VIP from a tenant's list
+func (s *PostgresStore) DeleteVIP(ctx context.Context, tenantID, vipID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM vips WHERE tenant_id = $1 AND id = $2`, tenantID, vipID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "VIP", vipID)
+}
+
+// ListVIPs retrieves the VIPs of a tenant, by address
+func (s *PostgresStore) ListVIPs(ctx context.Context, tenantID uuid.UUID) ([]domain.VIP, error) {
+	query := `
+		SELECT id, tenant_id, email, name, aliases, reason, created_at
+		FROM vips
+		WHERE tenant_id = $1
+		ORDER BY email
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vips := make([]domain.VIP, 0)
+	for rows.Next() {
+		var vip domain.VIP
+		err := rows.Scan(
+			&vip.ID, &vip.TenantID, &vip.Email, &vip.Name, pq.Array(&vip.Aliases), &vip.Reason, &vip.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		vips = append(vips, vip)
+	}
+
+	return vips, rows.Err()
+}
+
+// UpdateTenantVIPRiskThresholds sets the risk thresholds of mail to a tenant's VIPs
+// (nil restores the defaults)
+func (s *PostgresStore) UpdateTenantVIPRiskThresholds(ctx context.Context, tenantID uuid.UUID, thresholds *domain.RiskThresholds) error {
+	var raw []byte
+	if thresholds != nil {
+		var err error
+		if raw, err = json.Marshal(thresholds); err != nil {
+			return err
+		}
+	}
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE tenants SET vip_risk_thresholds = $2, updated_at = NOW() WHERE id = $1`,
+		tenantID, raw,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "tenant", tenantID)
+}
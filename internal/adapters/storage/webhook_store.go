@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// webhookSchema creates webhook subscriptions and their delivery log
+const webhookSchema = `
+	-- ============================================================================
+	-- WEBHOOK TABLES
+	-- ============================================================================
+	-- Customers subscribe an endpoint of theirs to event types, optionally above a
+	-- minimum risk level. Matching outbox events become one delivery per subscription;
+	-- deliveries are retried with backoff and every HTTP request is logged.
+	--
+	-- Secrets are sealed with the tenant's data key (see tenant_data_keys).
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id UUID PRIMARY KEY,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		event_types TEXT[] NOT NULL,
+		min_risk_level VARCHAR(20),
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_tenant ON webhook_subscriptions(tenant_id);
+
+	-- One row per (subscription, event): the unique constraint makes enqueueing an
+	-- outbox redelivery a no-op
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id UUID PRIMARY KEY,
+		subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+		tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+		event_id UUID NOT NULL,
+		event_type VARCHAR(64) NOT NULL,
+		dedup_key VARCHAR(255) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_status_code INT,
+		last_error TEXT,
+		lease_owner VARCHAR(128),
+		lease_expires_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		delivered_at TIMESTAMP,
+		UNIQUE(subscription_id, dedup_key)
+	);
+
+	-- Backs ClaimWebhookDeliveries
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at)
+		WHERE status = 'pending';
+	-- Backs the per-subscription delivery log
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id, created_at DESC);
+
+	CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+		delivery_id UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		attempt INT NOT NULL,
+		status_code INT,
+		error TEXT,
+		duration_ms INT NOT NULL,
+		attempted_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (delivery_id, attempt)
+	);
+`
+
+// webhookDeliveryColumns lists the columns scanned by scanWebhookDeliveries, in order
+const webhookDeliveryColumns = `id, subscription_id, tenant_id, event_id, event_type, dedup_key, payload, status,
+	attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+// CreateWebhookSubscription inserts a webhook subscription
+func (s *PostgresStore) CreateWebhookSubscription(ctx context.Context, sub *domain.WebhookSubscription) error {
+	if err := checkSealed(sub.TenantID, sub.Secret); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO webhook_subscriptions (id, tenant_id, url, secret, event_types, min_risk_level, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9)
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		sub.ID, sub.TenantID, sub.URL, sub.Secret, pq.Array(sub.EventTypes),
+		sub.MinRiskLevel, sub.Enabled, sub.CreatedAt, sub.UpdatedAt,
+	)
+	return err
+}
+
+// ListWebhookSubscriptions retrieves all subscriptions of a tenant, enabled or not, with their secrets
+func (s *PostgresStore) ListWebhookSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.WebhookSubscription, error) {
+	query := `
+		SELECT id, tenant_id, url, secret, event_types, COALESCE(min_risk_level, ''), enabled, created_at, updated_at
+		FROM webhook_subscriptions
+		WHERE tenant_id = $1
+		ORDER BY created_at
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]domain.WebhookSubscription, 0)
+	for rows.Next() {
+		var sub domain.WebhookSubscription
+		err := rows.Scan(
+			&sub.ID, &sub.TenantID, &sub.URL, &sub.Secret, pq.Array(&sub.EventTypes),
+			&sub.MinRiskLevel, &sub.Enabled, &sub.CreatedAt, &sub.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// UpdateWebhookSubscriptionSecret replaces a subscription's stored secret
+func (s *PostgresStore) UpdateWebhookSubscriptionSecret(ctx context.Context, tenantID, subscriptionID uuid.UUID, secret string) error {
+	if err := checkSealed(tenantID, secret); err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_subscriptions SET secret = $3, updated_at = NOW() WHERE tenant_id = $1 AND id = $2`,
+		tenantID, subscriptionID, secret,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "webhook subscription", subscriptionID)
+}
+
+// DeleteWebhookSubscription removes a tenant's subscription with its deliveries
+func (s *PostgresStore) DeleteWebhookSubscription(ctx context.Context, tenantID, subscriptionID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE tenant_id = $1 AND id = $2`, tenantID, subscriptionID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "webhook subscription", subscriptionID)
+}
+
+// EnqueueWebhookDeliveries inserts pending deliveries
+// A delivery of an event already enqueued for the same subscription is skipped.
+func (s *PostgresStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows := make([][]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		rows[i] = []interface{}{
+			d.ID, d.SubscriptionID, d.TenantID, d.EventID, d.EventType,
+			d.DedupKey, []byte(d.Payload), d.CreatedAt,
+		}
+	}
+	err = insertRows(ctx, tx,
+		`INSERT INTO webhook_deliveries (id, subscription_id, tenant_id, event_id, event_type, dedup_key, payload, created_at)`,
+		rows, `ON CONFLICT (subscription_id, dedup_key) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ClaimWebhookDeliveries leases up to limit due, pending deliveries to owner, oldest first
+// Claiming counts as an attempt, so the attempt number is known before the request is sent.
+func (s *PostgresStore) ClaimWebhookDeliveries(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET lease_owner = $1, lease_expires_at = NOW() + $3 * INTERVAL '1 second', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			  AND (lease_expires_at IS NULL OR lease_expires_at < NOW())
+			ORDER BY created_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+	rows, err := s.db.QueryContext(ctx, query, owner, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	deliveries, err := scanWebhookDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the subquery order
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt) })
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt logs an attempt of a delivery leased to owner and moves the delivery
+// to status, releasing its lease; a pending delivery is due again after retryIn
+// Nothing is recorded once the lease was lost.
+func (s *PostgresStore) RecordWebhookAttempt(ctx context.Context, owner string, attempt domain.WebhookAttempt, status string, retryIn time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	update := `
+		UPDATE webhook_deliveries
+		SET status = $3, next_attempt_at = NOW() + $4 * INTERVAL '1 second',
+		    last_status_code = NULLIF($5, 0), last_error = NULLIF($6, ''),
+		    delivered_at = CASE WHEN $7 THEN NOW() END,
+		    lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = $1 AND lease_owner = $2
+	`
+	result, err := tx.ExecContext(ctx, update,
+		attempt.DeliveryID, owner, status, retryIn.Seconds(), attempt.StatusCode, attempt.Error,
+		status == domain.WebhookDelivered)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err // Lease lost: another dispatcher owns the delivery and logs its own attempt
+	}
+
+	insert := `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms, attempted_at)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5, $6)
+		ON CONFLICT (delivery_id, attempt) DO NOTHING
+	`
+	_, err = tx.ExecContext(ctx, insert,
+		attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error,
+		attempt.Duration.Milliseconds(), attempt.AttemptedAt)
+	if err != nil {
+		return fmt.Errorf("failed to log webhook attempt: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ListWebhookDeliveries retrieves the latest deliveries of a tenant's subscription, newest first
+func (s *PostgresStore) ListWebhookDeliveries(ctx context.Context, tenantID, subscriptionID uuid.UUID, limit int) ([]domain.WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE tenant_id = $1 AND subscription_id = $2
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// ListWebhookAttempts retrieves the attempts of a tenant's delivery, in order
+func (s *PostgresStore) ListWebhookAttempts(ctx context.Context, tenantID, deliveryID uuid.UUID) ([]domain.WebhookAttempt, error) {
+	query := `
+		SELECT a.delivery_id, a.attempt, COALESCE(a.status_code, 0), COALESCE(a.error, ''), a.duration_ms, a.attempted_at
+		FROM webhook_delivery_attempts a
+		JOIN webhook_deliveries d ON d.id = a.delivery_id
+		WHERE d.tenant_id = $1 AND a.delivery_id = $2
+		ORDER BY a.attempt
+	`
+	rows, err := s.db.QueryContext(ctx, query, tenantID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := make([]domain.WebhookAttempt, 0)
+	for rows.Next() {
+		var attempt domain.WebhookAttempt
+		var durationMs int64
+		err := rows.Scan(
+			&attempt.DeliveryID, &attempt.Attempt, &attempt.StatusCode, &attempt.Error,
+			&durationMs, &attempt.AttemptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempt.Duration = time.Duration(durationMs) * time.Millisecond
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// scanWebhookDeliveries reads rows selected with webhookDeliveryColumns and closes them
+func scanWebhookDeliveries(rows *sql.Rows) ([]domain.WebhookDelivery, error) {
+	defer rows.Close()
+
+	deliveries := make([]domain.WebhookDelivery, 0)
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		var payload []byte
+		var statusCode sql.NullInt64
+		var lastError sql.NullString
+		err := rows.Scan(
+			&d.ID, &d.SubscriptionID, &d.TenantID, &d.EventID, &d.EventType, &d.DedupKey, &payload, &d.Status,
+			&d.Attempts, &d.NextAttemptAt, &statusCode, &lastError, &d.CreatedAt, &d.DeliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload, d.LastStatusCode, d.LastError = payload, int(statusCode.Int64), lastError.String
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,297 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/alerting"
+	"github.com/stoik/email-security/internal/domain/ratelimit"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// AlertConfig configures alert notifications
+type AlertConfig struct {
+	// RateLimit is the number of immediate alerts a route sends per minute, after an
+	// initial burst of the same size; further alerts go to the route's digest (default: 10)
+	RateLimit int
+
+	// Templates renders alerts and digests (default: alerting.DefaultTemplates)
+	Templates *alerting.Templates
+}
+
+// withDefaults fills unset fields
+func (c AlertConfig) withDefaults() AlertConfig {
+	if c.RateLimit <= 0 {
+		c.RateLimit = 10
+	}
+	if c.Templates == nil {
+		c.Templates = alerting.DefaultTemplates()
+	}
+	return c
+}
+
+// AlertService notifies security teams of fraud analyses through their routes
+//
+// It handles analysis.completed events (subscribed on the event bus). Each tenant
+// route matching the analysis gets an immediate alert, or a digest entry when the
+// analysis is below the route's DigestBelow level or the route exceeded its rate
+// limit, so an attack campaign produces a few alerts and a digest instead of a storm.
+//
+// Delivery is at-least-once: when a route fails, the event is redelivered and routes
+// that already succeeded may alert twice. Pending digests are held in memory until
+// FlushDigests; in production they would be stored so a restart does not drop them.
+// Route targets (Slack and Teams URLs are credentials) are sealed by secrets before
+// they are stored, and only opened to send.
+type AlertService struct {
+	storage   ports.Storage
+	notifiers map[string]ports.Notifier
+	secrets   ports.SecretStore
+	cfg       AlertConfig
+	now       func() time.Time
+
+	mu       sync.Mutex
+	limiters map[uuid.UUID]*ratelimit.TokenBucket
+	digests  map[uuid.UUID]*alerting.Digest
+}
+
+// NewAlertService creates an alert service sending through notifiers, one per channel,
+// and sealing route targets with secrets
+func NewAlertService(storage ports.Storage, notifiers []ports.Notifier, secrets ports.SecretStore, cfg AlertConfig) *AlertService {
+	byChannel := make(map[string]ports.Notifier, len(notifiers))
+	for _, notifier := range notifiers {
+		byChannel[notifier.Channel()] = notifier
+	}
+	return &AlertService{
+		storage:   storage,
+		notifiers: byChannel,
+		secrets:   secrets,
+		cfg:       cfg.withDefaults(),
+		now:       time.Now,
+		limiters:  make(map[uuid.UUID]*ratelimit.TokenBucket),
+		digests:   make(map[uuid.UUID]*alerting.Digest),
+	}
+}
+
+// CreateNotificationRoute validates and stores a tenant's route
+func (s *AlertService) CreateNotificationRoute(ctx context.Context, route *domain.NotificationRoute) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+	if _, ok := s.notifiers[route.Channel]; !ok {
+		return fmt.Errorf("notification channel %q is not configured", route.Channel)
+	}
+
+	sealed, err := s.secrets.Seal(ctx, route.TenantID, route.Target)
+	if err != nil {
+		return fmt.Errorf("failed to seal notification route target: %w", err)
+	}
+	now := time.Now()
+	route.ID = uuid.New()
+	route.CreatedAt = now
+	route.UpdatedAt = now
+	stored := *route
+	stored.Target = sealed
+	if err := s.storage.CreateNotificationRoute(ctx, &stored); err != nil {
+		return fmt.Errorf("failed to store notification route: %w", err)
+	}
+	return nil
+}
+
+// ListNotificationRoutes returns a tenant's routes; targets stay sealed
+func (s *AlertService) ListNotificationRoutes(ctx context.Context, tenantID uuid.UUID) ([]domain.NotificationRoute, error) {
+	return s.storage.ListNotificationRoutes(ctx, tenantID)
+}
+
+// DeleteNotificationRoute removes a tenant's route
+// Its pending digest is dropped.
+func (s *AlertService) DeleteNotificationRoute(ctx context.Context, tenantID, routeID uuid.UUID) error {
+	if err := s.storage.DeleteNotificationRoute(ctx, tenantID, routeID); err != nil {
+		return fmt.Errorf("failed to delete notification route: %w", err)
+	}
+	s.mu.Lock()
+	delete(s.digests, routeID)
+	delete(s.limiters, routeID)
+	s.mu.Unlock()
+	return nil
+}
+
+// HandleEvent alerts the routes matching an analysis.completed event; other events are ignored
+func (s *AlertService) HandleEvent(ctx context.Context, event domain.Event) error {
+	if event.Type != domain.EventAnalysisCompleted {
+		return nil
+	}
+	var payload domain.AnalysisCompletedPayload
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid %s payload: %w", event.Type, err)
+	}
+
+	routes, err := s.storage.ListNotificationRoutes(ctx, event.TenantID)
+	if err != nil {
+		return fmt.Errorf("failed to load notification routes: %w", err)
+	}
+	matched := make([]domain.NotificationRoute, 0, len(routes))
+	for _, route := range routes {
+		if route.Matches(payload.RiskLevel, payload.ThreatTypes) {
+			matched = append(matched, route)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+
+	// The event only carries a summary; templates render the full detections
+	alert, err := s.loadAlert(ctx, event.EmailID)
+	if err != nil || alert == nil {
+		return err
+	}
+
+	var failures []error
+	for _, route := range matched {
+		if err := s.route(ctx, route, *alert); err != nil {
+			failures = append(failures, fmt.Errorf("route %s: %w", route.Name, err))
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// loadAlert reads an email and its current analysis (nil if either is gone)
+func (s *AlertService) loadAlert(ctx context.Context, emailID uuid.UUID) (*alerting.Alert, error) {
+	email, err := s.storage.GetEmail(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch email %s: %w", emailID, err)
+	}
+	analysis, err := s.storage.GetFraudAnalysisByEmail(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch analysis of email %s: %w", emailID, err)
+	}
+	if email == nil || analysis == nil {
+		return nil, nil
+	}
+	return &alerting.Alert{Email: *email, Analysis: *analysis}, nil
+}
+
+// route sends an alert right away or adds it to the route's digest
+func (s *AlertService) route(ctx context.Context, route domain.NotificationRoute, alert alerting.Alert) error {
+	if route.Digested(alert.Analysis.RiskLevel) {
+		s.addToDigest(route, alert, false)
+		return nil
+	}
+	if !s.limiter(route.ID).Allow(s.now()) {
+		log.Printf("Alert rate limit reached for route %s, holding alert for the digest", route.Name)
+		s.addToDigest(route, alert, true)
+		return nil
+	}
+
+	notification, err := s.cfg.Templates.RenderAlert(alert)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, route, notification)
+}
+
+// limiter returns the rate limiter of a route
+func (s *AlertService) limiter(routeID uuid.UUID) *ratelimit.TokenBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limiter, ok := s.limiters[routeID]
+	if !ok {
+		limiter = ratelimit.NewTokenBucket(ratelimit.PerMinute(s.cfg.RateLimit), s.cfg.RateLimit)
+		s.limiters[routeID] = limiter
+	}
+	return limiter
+}
+
+// addToDigest queues an alert for the route's next digest
+func (s *AlertService) addToDigest(route domain.NotificationRoute, alert alerting.Alert, rateLimited bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest, ok := s.digests[route.ID]
+	if !ok {
+		digest = &alerting.Digest{Route: route}
+		s.digests[route.ID] = digest
+	}
+	digest.Route = route // Latest settings
+	if len(digest.Alerts) < alerting.MaxDigestAlerts {
+		digest.Alerts = append(digest.Alerts, alert)
+	} else {
+		digest.Dropped++
+	}
+	if rateLimited {
+		digest.RateLimited++
+	}
+}
+
+// FlushDigests sends every pending digest and returns the number sent
+// Digests that fail to send are kept for the next flush. In production, this runs
+// on a schedule (e.g. hourly).
+func (s *AlertService) FlushDigests(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	pending := s.digests
+	s.digests = make(map[uuid.UUID]*alerting.Digest)
+	s.mu.Unlock()
+
+	sent := 0
+	var failures []error
+	for routeID, digest := range pending {
+		err := s.sendDigest(ctx, *digest)
+		if err == nil {
+			sent++
+			continue
+		}
+		failures = append(failures, fmt.Errorf("route %s: %w", digest.Route.Name, err))
+		s.restoreDigest(routeID, digest)
+	}
+	return sent, errors.Join(failures...)
+}
+
+func (s *AlertService) sendDigest(ctx context.Context, digest alerting.Digest) error {
+	notification, err := s.cfg.Templates.RenderDigest(digest)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, digest.Route, notification)
+}
+
+// restoreDigest puts back a digest that failed to send, ahead of alerts queued since
+func (s *AlertService) restoreDigest(routeID uuid.UUID, failed *alerting.Digest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if newer, ok := s.digests[routeID]; ok {
+		for _, alert := range newer.Alerts {
+			if len(failed.Alerts) < alerting.MaxDigestAlerts {
+				failed.Alerts = append(failed.Alerts, alert)
+			} else {
+				failed.Dropped++
+			}
+		}
+		failed.Route = newer.Route
+		failed.Dropped += newer.Dropped
+		failed.RateLimited += newer.RateLimited
+	}
+	s.digests[routeID] = failed
+}
+
+// send delivers a notification through the route's channel
+func (s *AlertService) send(ctx context.Context, route domain.NotificationRoute, notification domain.Notification) error {
+	notifier, ok := s.notifiers[route.Channel]
+	if !ok {
+		return fmt.Errorf("notification channel %q is not configured", route.Channel)
+	}
+	target, err := openSecret(ctx, s.secrets, route.TenantID, route.Target, func(sealed string) error {
+		return s.storage.UpdateNotificationRouteTarget(ctx, route.TenantID, route.ID, sealed)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open %s route target: %w", route.Channel, err)
+	}
+	if err := notifier.Send(ctx, target, notification); err != nil {
+		return fmt.Errorf("failed to send %s notification: %w", route.Channel, err)
+	}
+	return nil
+}
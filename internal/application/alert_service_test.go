@@ -0,0 +1,247 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/envelope"
+	"github.com/stoik/email-security/internal/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingNotifier records sent notifications and fails the first failures calls
+type recordingNotifier struct {
+	channel  string
+	mu       sync.Mutex
+	failures int
+	sent     []sentNotification
+}
+
+type sentNotification struct {
+	target       string
+	notification domain.Notification
+}
+
+func (n *recordingNotifier) Channel() string { return n.channel }
+
+func (n *recordingNotifier) Send(ctx context.Context, target string, notification domain.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.failures > 0 {
+		n.failures--
+		return errors.New("unavailable")
+	}
+	n.sent = append(n.sent, sentNotification{target, notification})
+	return nil
+}
+
+// alertFixture is an alert service for one tenant, with Slack and email notifiers
+type alertFixture struct {
+	store    *fakeStorage
+	tenantID uuid.UUID
+	slack    *recordingNotifier
+	email    *recordingNotifier
+	service  *AlertService
+}
+
+func newAlertFixture(t *testing.T, cfg AlertConfig) *alertFixture {
+	f := &alertFixture{
+		store:    newFakeStorage(),
+		tenantID: uuid.New(),
+		slack:    &recordingNotifier{channel: domain.ChannelSlack},
+		email:    &recordingNotifier{channel: domain.ChannelEmail},
+	}
+	f.service = NewAlertService(f.store, []ports.Notifier{f.slack, f.email}, newTestSecrets(t, f.store), cfg)
+	return f
+}
+
+func (f *alertFixture) addRoute(t *testing.T, route domain.NotificationRoute) {
+	route.TenantID, route.Enabled = f.tenantID, true
+	require.NoError(t, f.service.CreateNotificationRoute(context.Background(), &route))
+}
+
+// analyzed stores an analyzed email and returns its analysis.completed event
+func (f *alertFixture) analyzed(t *testing.T, subject, level string, detectionTypes ...string) domain.Event {
+	return f.store.analyzed(t, domain.Email{
+		TenantID:       f.tenantID,
+		Subject:        subject,
+		SenderEmail:    "ceo@examp1e.com",
+		RecipientEmail: "cfo@company.com",
+	}, level, detectionTypes...)
+}
+
+// handle hands events to the alert service as the bus would
+func (f *alertFixture) handle(t *testing.T, events ...domain.Event) {
+	for _, event := range events {
+		require.NoError(t, f.service.HandleEvent(context.Background(), event))
+	}
+}
+
+func TestAlertService_Routing(t *testing.T) {
+	f := newAlertFixture(t, AlertConfig{})
+	f.addRoute(t, domain.NotificationRoute{
+		Name: "SOC", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/services/T/B/X",
+		MinRiskLevel: "high",
+	})
+	f.addRoute(t, domain.NotificationRoute{
+		Name: "Typosquatting", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/services/T/B/Y",
+		MinRiskLevel: "low", DetectionTypes: []string{"DOMAIN_TYPOSQUATTING"},
+	})
+	f.addRoute(t, domain.NotificationRoute{
+		Name: "Security mailbox", Channel: domain.ChannelEmail, Target: "security@company.com",
+		MinRiskLevel: "low", DigestBelow: "high",
+	})
+
+	f.handle(t,
+		f.analyzed(t, "Wire transfer", "critical", "BEC_CSUITE_TARGETING"),
+		f.analyzed(t, "Invoice", "medium", "DOMAIN_TYPOSQUATTING"),
+		f.analyzed(t, "Newsletter", "none"),
+	)
+
+	// Immediate alerts: SOC for the critical analysis, Typosquatting for the medium one
+	require.Len(t, f.slack.sent, 2)
+	assert.Equal(t, "https://hooks.slack.com/services/T/B/X", f.slack.sent[0].target)
+	assert.Equal(t, "[CRITICAL] Suspicious email to cfo@company.com", f.slack.sent[0].notification.Title)
+	assert.Contains(t, f.slack.sent[0].notification.Text, "BEC_CSUITE_TARGETING (90%)")
+	assert.Equal(t, "https://hooks.slack.com/services/T/B/Y", f.slack.sent[1].target)
+	assert.Equal(t, "medium", f.slack.sent[1].notification.Severity)
+
+	// The mailbox gets the critical alert now and the medium one in its digest
+	require.Len(t, f.email.sent, 1)
+	assert.Equal(t, "critical", f.email.sent[0].notification.Severity)
+
+	sent, err := f.service.FlushDigests(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, f.email.sent, 2)
+	digest := f.email.sent[1].notification
+	assert.Equal(t, "1 suspicious email for Security mailbox", digest.Title)
+	assert.Contains(t, digest.Text, "[medium] Invoice")
+
+	// Nothing left to flush
+	sent, err = f.service.FlushDigests(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+}
+
+func TestAlertService_RateLimit(t *testing.T) {
+	f := newAlertFixture(t, AlertConfig{RateLimit: 2})
+	now := time.Unix(1700000000, 0)
+	f.service.now = func() time.Time { return now }
+	f.addRoute(t, domain.NotificationRoute{
+		Name: "SOC", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/services/T/B/X",
+		MinRiskLevel: "high",
+	})
+
+	for i := 0; i < 5; i++ {
+		f.handle(t, f.analyzed(t, "Wire transfer", "critical", "BEC_CSUITE_TARGETING"))
+	}
+
+	// The burst goes out, the rest of the storm is held for the digest
+	assert.Len(t, f.slack.sent, 2)
+	sent, err := f.service.FlushDigests(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, f.slack.sent, 3)
+	digest := f.slack.sent[2].notification
+	assert.Equal(t, "3 suspicious emails for SOC", digest.Title)
+	assert.Equal(t, "critical", digest.Severity)
+	assert.Contains(t, digest.Text, "3 of these exceeded the route's alert rate")
+
+	// The limit refills over time
+	now = now.Add(time.Minute)
+	f.handle(t, f.analyzed(t, "Wire transfer", "critical", "BEC_CSUITE_TARGETING"))
+	assert.Len(t, f.slack.sent, 4)
+}
+
+func TestAlertService_FailedDelivery(t *testing.T) {
+	f := newAlertFixture(t, AlertConfig{})
+	f.addRoute(t, domain.NotificationRoute{
+		Name: "SOC", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/services/T/B/X",
+		MinRiskLevel: "high",
+	})
+	f.addRoute(t, domain.NotificationRoute{
+		Name: "Security mailbox", Channel: domain.ChannelEmail, Target: "security@company.com",
+		MinRiskLevel: "low", DigestBelow: "critical",
+	})
+
+	// An immediate alert failing fails the event, so the relay redelivers it
+	f.slack.failures = 1
+	threat := f.analyzed(t, "Wire transfer", "critical", "BEC_CSUITE_TARGETING")
+	err := f.service.HandleEvent(context.Background(), threat)
+	assert.ErrorContains(t, err, "route SOC: failed to send slack notification: unavailable")
+	f.handle(t, threat)
+	assert.Len(t, f.slack.sent, 1)
+	assert.Len(t, f.email.sent, 2, "at-least-once: the mailbox succeeded both times")
+
+	// A digest failing to send is kept, with alerts queued since
+	f.handle(t, f.analyzed(t, "Invoice", "low", "DOMAIN_TYPOSQUATTING"))
+	f.email.failures = 1
+	sent, err := f.service.FlushDigests(context.Background())
+	assert.ErrorContains(t, err, "route Security mailbox")
+	assert.Zero(t, sent)
+
+	f.handle(t, f.analyzed(t, "Invoice", "high", "DOMAIN_TYPOSQUATTING"))
+	sent, err = f.service.FlushDigests(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, f.email.sent, 3)
+	assert.Equal(t, "2 suspicious emails for Security mailbox", f.email.sent[2].notification.Title)
+	assert.Equal(t, "high", f.email.sent[2].notification.Severity)
+}
+
+func TestAlertService_LegacyPlaintextTarget(t *testing.T) {
+	f := newAlertFixture(t, AlertConfig{})
+	// Stored in the clear before targets were sealed
+	f.store.notificationRoutes = append(f.store.notificationRoutes, domain.NotificationRoute{
+		ID: uuid.New(), TenantID: f.tenantID, Name: "SOC", Channel: domain.ChannelSlack,
+		Target: "https://hooks.slack.com/services/T/B/X", MinRiskLevel: "high", Enabled: true,
+	})
+
+	f.handle(t, f.analyzed(t, "Wire transfer", "critical", "BEC_CSUITE_TARGETING"))
+
+	require.Len(t, f.slack.sent, 1)
+	assert.Equal(t, "https://hooks.slack.com/services/T/B/X", f.slack.sent[0].target)
+	assert.True(t, envelope.IsSealed(f.store.notificationRoutes[0].Target), "sealed in place")
+}
+
+func TestAlertService_CreateNotificationRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   domain.NotificationRoute
+		wantErr string
+	}{
+		{"slack", domain.NotificationRoute{Name: "SOC", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/services/T/B/X", MinRiskLevel: "high"}, ""},
+		{"email digest", domain.NotificationRoute{Name: "Mailbox", Channel: domain.ChannelEmail, Target: "Security <security@company.com>", MinRiskLevel: "low", DigestBelow: "high"}, ""},
+		{"no name", domain.NotificationRoute{Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/x", MinRiskLevel: "high"}, "needs a name"},
+		{"unconfigured channel", domain.NotificationRoute{Name: "SOC", Channel: domain.ChannelTeams, Target: "https://example.webhook.office.com/x", MinRiskLevel: "high"}, "not configured"},
+		{"unknown channel", domain.NotificationRoute{Name: "SOC", Channel: "pager", Target: "x", MinRiskLevel: "high"}, "unknown notification channel"},
+		{"http target", domain.NotificationRoute{Name: "SOC", Channel: domain.ChannelSlack, Target: "http://hooks.slack.com/x", MinRiskLevel: "high"}, "https URL"},
+		{"bad address", domain.NotificationRoute{Name: "SOC", Channel: domain.ChannelEmail, Target: "security", MinRiskLevel: "high"}, "invalid email address"},
+		{"no minimum", domain.NotificationRoute{Name: "SOC", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/x"}, "unknown risk level"},
+		{"bad digest level", domain.NotificationRoute{Name: "SOC", Channel: domain.ChannelSlack, Target: "https://hooks.slack.com/x", MinRiskLevel: "low", DigestBelow: "severe"}, "unknown risk level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAlertFixture(t, AlertConfig{})
+			route := tt.route
+			err := f.service.CreateNotificationRoute(context.Background(), &route)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Empty(t, f.store.notificationRoutes)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEqual(t, uuid.Nil, route.ID)
+			require.Len(t, f.store.notificationRoutes, 1)
+			assert.True(t, envelope.IsSealed(f.store.notificationRoutes[0].Target), "stored sealed")
+		})
+	}
+}
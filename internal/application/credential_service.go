@@ -0,0 +1,321 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/envelope"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// CredentialConfig configures tenant access tokens
+type CredentialConfig struct {
+	// RefreshBefore is how long before expiry access tokens are refreshed (default: 5 minutes)
+	RefreshBefore time.Duration
+}
+
+// withDefaults fills unset fields
+func (c CredentialConfig) withDefaults() CredentialConfig {
+	if c.RefreshBefore <= 0 {
+		c.RefreshBefore = 5 * time.Minute
+	}
+	return c
+}
+
+// CredentialStats reports a RefreshDue call
+type CredentialStats struct {
+	Refreshed int // Tokens exchanged ahead of expiry
+	Failed    int // Refreshes that failed (revoked tenants included)
+}
+
+// CredentialService manages tenant OAuth credentials and implements ports.TokenSource
+//
+// Access tokens are cached per tenant until RefreshBefore their expiry, and Run
+// refreshes cached tokens ahead of time so provider calls rarely wait on a token
+// exchange. A refresh failing because consent was revoked moves the tenant to
+// domain.TenantAuthError: no exchange is attempted until SetCredentials stores
+// working credentials. Rotated refresh tokens are stored as they are issued.
+//
+// Credentials are sealed by secrets before they are stored, and only opened to
+// exchange them: they are never stored nor logged in the clear.
+type CredentialService struct {
+	storage   ports.Storage
+	exchanger ports.TokenExchanger
+	secrets   ports.SecretStore
+	cfg       CredentialConfig
+	now       func() time.Time
+
+	mu     sync.Mutex
+	tokens map[uuid.UUID]*cachedToken
+}
+
+// cachedToken is a tenant's access token; its lock serializes the tenant's exchanges
+type cachedToken struct {
+	mu    sync.Mutex
+	token domain.AccessToken
+}
+
+// NewCredentialService creates a credential service exchanging tokens through
+// exchanger and sealing stored credentials with secrets
+func NewCredentialService(storage ports.Storage, exchanger ports.TokenExchanger, secrets ports.SecretStore, cfg CredentialConfig) *CredentialService {
+	return &CredentialService{
+		storage:   storage,
+		exchanger: exchanger,
+		secrets:   secrets,
+		cfg:       cfg.withDefaults(),
+		now:       time.Now,
+		tokens:    make(map[uuid.UUID]*cachedToken),
+	}
+}
+
+// Token returns a valid access token for a tenant, exchanging its credentials when
+// the cached token is missing or about to expire
+// If a refresh fails transiently, the cached token is returned while it lasts.
+func (s *CredentialService) Token(ctx context.Context, tenantID uuid.UUID) (string, error) {
+	cached := s.cached(tenantID)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if cached.token.ValidFor(s.now(), s.cfg.RefreshBefore) {
+		return cached.token.Value, nil
+	}
+
+	if err := s.refresh(ctx, tenantID, cached); err != nil {
+		revoked := errors.Is(err, domain.ErrAuthExpired) || errors.Is(err, domain.ErrTenantInactive)
+		if !revoked && cached.token.ValidFor(s.now(), 0) {
+			log.Printf("Token refresh failed for tenant %s, using cached token: %v", tenantID, err)
+			return cached.token.Value, nil
+		}
+		return "", err
+	}
+	return cached.token.Value, nil
+}
+
+// SetCredentials checks and stores a tenant's credentials, activating a tenant
+// pending consent or in domain.TenantAuthError
+// The credentials are stored only if a token exchange succeeds with them.
+func (s *CredentialService) SetCredentials(ctx context.Context, tenantID uuid.UUID, creds domain.TenantCredentials) error {
+	tenant, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tenant: %w", err)
+	}
+	if tenant == nil {
+		return fmt.Errorf("%w: %s", domain.ErrTenantNotFound, tenantID)
+	}
+	if !tenant.Active() {
+		if err := domain.ValidateTenantTransition(tenant.Status, domain.TenantActive); err != nil {
+			return err
+		}
+	}
+	if err := creds.Validate(); err != nil {
+		return err
+	}
+
+	cached := s.cached(tenantID)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	token, err := s.exchanger.Exchange(ctx, tenant.Provider, creds)
+	if err != nil {
+		return fmt.Errorf("failed to exchange credentials: %w", err)
+	}
+	if token.RefreshToken != "" {
+		creds.RefreshToken = token.RefreshToken
+	}
+	if err := s.store(ctx, tenantID, creds, tenant.Status, domain.TenantActive); err != nil {
+		return err
+	}
+	token.RefreshToken = ""
+	cached.token = token
+	log.Printf("Stored %s credentials for tenant %s", creds.Grant, tenant.Name)
+	return nil
+}
+
+// Revoke withdraws a tenant's grant at the provider where possible and drops its
+// cached token
+func (s *CredentialService) Revoke(ctx context.Context, tenantID uuid.UUID) error {
+	cached := s.cached(tenantID)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	defer s.Forget(tenantID)
+
+	tenant, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tenant: %w", err)
+	}
+	if tenant == nil {
+		return fmt.Errorf("%w: %s", domain.ErrTenantNotFound, tenantID)
+	}
+	if tenant.Credentials == "" {
+		return nil // Never consented
+	}
+	creds, err := s.open(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if err := s.exchanger.Revoke(ctx, tenant.Provider, creds); err != nil {
+		return fmt.Errorf("failed to revoke grant of tenant %s: %w", tenant.Name, err)
+	}
+	log.Printf("Revoked %s grant of tenant %s", creds.Grant, tenant.Name)
+	return nil
+}
+
+// Forget drops a tenant's cached token, so the next Token call checks its status
+func (s *CredentialService) Forget(tenantID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, tenantID)
+}
+
+// Run refreshes cached tokens ahead of expiry every interval until ctx is cancelled
+// interval should be shorter than RefreshBefore.
+func (s *CredentialService) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if stats := s.RefreshDue(ctx); stats.Failed > 0 && ctx.Err() == nil {
+			log.Printf("%d token refreshes failed, retrying in %s", stats.Failed, interval)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshDue refreshes the cached tokens expiring within RefreshBefore
+// Tokens are cached on first use: tenants never used are not refreshed.
+func (s *CredentialService) RefreshDue(ctx context.Context) CredentialStats {
+	s.mu.Lock()
+	tenants := make(map[uuid.UUID]*cachedToken, len(s.tokens))
+	for tenantID, cached := range s.tokens {
+		tenants[tenantID] = cached
+	}
+	s.mu.Unlock()
+
+	var stats CredentialStats
+	for tenantID, cached := range tenants {
+		cached.mu.Lock()
+		due := cached.token.Value != "" && !cached.token.ValidFor(s.now(), s.cfg.RefreshBefore)
+		if due {
+			if err := s.refresh(ctx, tenantID, cached); err != nil {
+				log.Printf("Token refresh failed for tenant %s: %v", tenantID, err)
+				stats.Failed++
+			} else {
+				stats.Refreshed++
+			}
+		}
+		cached.mu.Unlock()
+	}
+	return stats
+}
+
+// refresh exchanges a tenant's stored credentials for a new token; callers hold cached.mu
+// Revoked consent moves the tenant to domain.TenantAuthError and drops its token.
+func (s *CredentialService) refresh(ctx context.Context, tenantID uuid.UUID, cached *cachedToken) error {
+	tenant, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tenant: %w", err)
+	}
+	if tenant == nil {
+		return fmt.Errorf("%w: %s", domain.ErrTenantNotFound, tenantID)
+	}
+	if tenant.Status == domain.TenantAuthError {
+		cached.token = domain.AccessToken{}
+		return fmt.Errorf("tenant %s must reauthorize: %w", tenant.Name, domain.ErrAuthExpired)
+	}
+	if !tenant.Active() {
+		cached.token = domain.AccessToken{}
+		return fmt.Errorf("tenant %s is %s: %w", tenant.Name, tenant.Status, domain.ErrTenantInactive)
+	}
+	creds, err := s.open(ctx, tenant)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.exchanger.Exchange(ctx, tenant.Provider, creds)
+	if errors.Is(err, domain.ErrAuthExpired) {
+		cached.token = domain.AccessToken{}
+		if statusErr := s.storage.UpdateTenantStatus(ctx, tenantID, tenant.Status, domain.TenantAuthError); statusErr != nil {
+			log.Printf("Failed to mark tenant %s as %s: %v", tenant.Name, domain.TenantAuthError, statusErr)
+		}
+		log.Printf("Tenant %s revoked access, marked %s", tenant.Name, domain.TenantAuthError)
+		return fmt.Errorf("failed to refresh token of tenant %s: %w", tenant.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to refresh token of tenant %s: %w", tenant.Name, err)
+	}
+
+	// A rotated refresh token replaces the stored one: the previous one may stop working
+	if token.RefreshToken != "" && token.RefreshToken != creds.RefreshToken {
+		creds.RefreshToken = token.RefreshToken
+		if err := s.store(ctx, tenantID, creds, tenant.Status, tenant.Status); err != nil {
+			return err
+		}
+	}
+	token.RefreshToken = ""
+	cached.token = token
+	return nil
+}
+
+// open opens and parses a tenant's stored credentials
+// Credentials stored in the clear before they were sealed are accepted once and
+// sealed in place, so no tenant has to consent again.
+func (s *CredentialService) open(ctx context.Context, tenant *domain.Tenant) (domain.TenantCredentials, error) {
+	plaintext, err := s.secrets.Open(ctx, tenant.ID, tenant.Credentials)
+	legacy := errors.Is(err, envelope.ErrNotSealed)
+	if legacy {
+		plaintext, err = tenant.Credentials, nil
+	}
+	if err != nil {
+		return domain.TenantCredentials{}, fmt.Errorf("failed to open credentials of tenant %s: %w", tenant.Name, err)
+	}
+	creds, err := domain.ParseCredentials(plaintext)
+	if err != nil {
+		return domain.TenantCredentials{}, fmt.Errorf("failed to read credentials of tenant %s: %w", tenant.Name, err)
+	}
+	if legacy {
+		if err := s.store(ctx, tenant.ID, creds, tenant.Status, tenant.Status); err != nil {
+			return domain.TenantCredentials{}, err
+		}
+		log.Printf("Sealed legacy credentials of tenant %s", tenant.Name)
+	}
+	return creds, nil
+}
+
+// store encodes, seals and saves a tenant's credentials, moving it from status from
+// to status to
+// It fails if the tenant left status from since it was read, rather than undo the
+// change.
+func (s *CredentialService) store(ctx context.Context, tenantID uuid.UUID, creds domain.TenantCredentials, from, to string) error {
+	encoded, err := creds.Encode()
+	if err != nil {
+		return err
+	}
+	sealed, err := s.secrets.Seal(ctx, tenantID, encoded)
+	if err != nil {
+		return fmt.Errorf("failed to seal credentials: %w", err)
+	}
+	if err := s.storage.UpdateTenantCredentials(ctx, tenantID, sealed, from, to); err != nil {
+		return fmt.Errorf("failed to store credentials: %w", err)
+	}
+	return nil
+}
+
+// cached returns the token entry of a tenant
+func (s *CredentialService) cached(tenantID uuid.UUID) *cachedToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached, ok := s.tokens[tenantID]
+	if !ok {
+		cached = &cachedToken{}
+		s.tokens[tenantID] = cached
+	}
+	return cached
+}
@@ -0,0 +1,311 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/adapters/kms"
+	"github.com/stoik/email-security/internal/adapters/oauth"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/envelope"
+)
+
+// credentialFixture is a credential service exchanging tokens with a fake token endpoint
+type credentialFixture struct {
+	store   *fakeStorage
+	tokens  *oauth.FakeTokenServer
+	secrets *SecretService
+	url     string
+	service *CredentialService
+	now     time.Time
+}
+
+func newCredentialFixture(t *testing.T) *credentialFixture {
+	f := &credentialFixture{
+		store:  newFakeStorage(),
+		tokens: oauth.NewFakeTokenServer(time.Hour),
+		now:    time.Now(),
+	}
+	server := httptest.NewServer(f.tokens)
+	t.Cleanup(server.Close)
+	f.url = server.URL + "/token"
+	keys, err := kms.GenerateLocalKMS()
+	require.NoError(t, err)
+	f.secrets = NewSecretService(f.store, keys)
+	f.service = NewCredentialService(f.store, oauth.NewTokenClient(5*time.Second), f.secrets, CredentialConfig{RefreshBefore: 5 * time.Minute})
+	f.service.now = func() time.Time { return f.now }
+	return f
+}
+
+// addTenant stores a tenant with sealed credentials pointing at the fake endpoint
+func (f *credentialFixture) addTenant(t *testing.T, provider domain.Provider, creds domain.TenantCredentials) *domain.Tenant {
+	creds.TokenURL = f.url
+	encoded, err := creds.Encode()
+	require.NoError(t, err)
+	tenant := &domain.Tenant{ID: uuid.New(), Name: creds.ClientID + creds.ServiceAccount, Provider: provider, Status: domain.TenantActive}
+	tenant.Credentials, err = f.secrets.Seal(context.Background(), tenant.ID, encoded)
+	require.NoError(t, err)
+	f.store.tenants[tenant.ID] = tenant
+	return tenant
+}
+
+// storedCredentials opens the credentials stored for a tenant
+func (f *credentialFixture) storedCredentials(t *testing.T, tenantID uuid.UUID) domain.TenantCredentials {
+	plaintext, err := f.secrets.Open(context.Background(), tenantID, f.store.tenants[tenantID].Credentials)
+	require.NoError(t, err)
+	creds, err := domain.ParseCredentials(plaintext)
+	require.NoError(t, err)
+	return creds
+}
+
+func graphCredentials(clientID string) domain.TenantCredentials {
+	return domain.TenantCredentials{
+		Grant:        domain.GrantClientCredentials,
+		DirectoryID:  uuid.NewString(),
+		ClientID:     clientID,
+		ClientSecret: "secret",
+	}
+}
+
+func TestCredentialService_Token(t *testing.T) {
+	f := newCredentialFixture(t)
+	tenant := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("acme"))
+	ctx := context.Background()
+
+	first, err := f.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+	again, err := f.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Equal(t, first, again, "cached until about to expire")
+	assert.Equal(t, 1, f.tokens.Issued())
+
+	// Refreshed within RefreshBefore of expiry
+	f.now = f.now.Add(56 * time.Minute)
+	refreshed, err := f.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, refreshed)
+	assert.Equal(t, 2, f.tokens.Issued())
+}
+
+func TestCredentialService_Token_Concurrent(t *testing.T) {
+	f := newCredentialFixture(t)
+	tenant := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("acme"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := f.service.Token(context.Background(), tenant.ID)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, f.tokens.Issued(), "one exchange per tenant")
+}
+
+func TestCredentialService_Token_Revoked(t *testing.T) {
+	f := newCredentialFixture(t)
+	tenant := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("acme"))
+	other := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("beta"))
+	ctx := context.Background()
+
+	_, err := f.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+
+	f.tokens.Revoke("acme")
+	f.now = f.now.Add(time.Hour)
+	_, err = f.service.Token(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrAuthExpired)
+	assert.Equal(t, domain.TenantAuthError, f.store.tenants[tenant.ID].Status)
+
+	// No exchange is attempted until the tenant reauthorizes
+	issued := f.tokens.Issued()
+	_, err = f.service.Token(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrAuthExpired)
+	assert.Equal(t, issued, f.tokens.Issued())
+
+	// Other tenants are unaffected
+	_, err = f.service.Token(ctx, other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TenantActive, f.store.tenants[other.ID].Status)
+
+	// New credentials reactivate the tenant
+	creds := graphCredentials("acme-reconsented")
+	creds.TokenURL = f.url
+	require.NoError(t, f.service.SetCredentials(ctx, tenant.ID, creds))
+	assert.Equal(t, domain.TenantActive, f.store.tenants[tenant.ID].Status)
+	assert.Equal(t, "acme-reconsented", f.storedCredentials(t, tenant.ID).ClientID)
+	_, err = f.service.Token(ctx, tenant.ID)
+	assert.NoError(t, err)
+}
+
+func TestCredentialService_SetCredentials_Invalid(t *testing.T) {
+	f := newCredentialFixture(t)
+	tenant := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("acme"))
+	stored := f.store.tenants[tenant.ID].Credentials
+	f.tokens.Revoke("revoked")
+
+	tests := []struct {
+		name  string
+		creds domain.TenantCredentials
+	}{
+		{"missing secret", domain.TenantCredentials{Grant: domain.GrantClientCredentials, DirectoryID: "d", ClientID: "acme"}},
+		{"unknown grant", domain.TenantCredentials{Grant: "password"}},
+		{"rejected by the provider", graphCredentials("revoked")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.creds.TokenURL = f.url
+			assert.Error(t, f.service.SetCredentials(context.Background(), tenant.ID, tt.creds))
+			assert.Equal(t, stored, f.store.tenants[tenant.ID].Credentials, "credentials unchanged")
+		})
+	}
+}
+
+func TestCredentialService_Token_TransientFailure(t *testing.T) {
+	f := newCredentialFixture(t)
+	tenant := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("acme"))
+	ctx := context.Background()
+
+	cached, err := f.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+
+	// A failed refresh falls back to the cached token while it is valid
+	f.tokens.Unavailable(1)
+	f.now = f.now.Add(57 * time.Minute)
+	token, err := f.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Equal(t, cached, token)
+	assert.Equal(t, domain.TenantActive, f.store.tenants[tenant.ID].Status)
+
+	// Not once it expired
+	f.tokens.Unavailable(1)
+	f.now = f.now.Add(time.Hour)
+	_, err = f.service.Token(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrUnavailable)
+	assert.Equal(t, domain.TenantActive, f.store.tenants[tenant.ID].Status)
+}
+
+func TestCredentialService_Token_RotatesRefreshToken(t *testing.T) {
+	f := newCredentialFixture(t)
+	tenant := f.addTenant(t, domain.ProviderGoogle, domain.TenantCredentials{
+		Grant:        domain.GrantRefreshToken,
+		ClientID:     "beta",
+		RefreshToken: "initial",
+	})
+	ctx := context.Background()
+
+	// The fake endpoint only accepts the latest refresh token: every refresh
+	// succeeds only if the rotated token was stored
+	for i := 0; i < 3; i++ {
+		_, err := f.service.Token(ctx, tenant.ID)
+		require.NoError(t, err, "refresh %d", i)
+		f.now = f.now.Add(time.Hour)
+	}
+
+	assert.Equal(t, "fake-refresh-token-3", f.storedCredentials(t, tenant.ID).RefreshToken)
+	assert.NotContains(t, f.store.tenants[tenant.ID].Credentials, "fake-refresh-token", "sealed at rest")
+}
+
+func TestCredentialService_Token_OffboardedDuringRefresh(t *testing.T) {
+	f := newCredentialFixture(t)
+	var tenantID uuid.UUID
+	// The tenant is offboarded while its refresh token is exchanged
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.store.mu.Lock()
+		f.store.tenants[tenantID].Status = domain.TenantOffboarding
+		f.store.mu.Unlock()
+		f.tokens.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	f.url = server.URL + "/token"
+	tenant := f.addTenant(t, domain.ProviderGoogle, domain.TenantCredentials{
+		Grant:        domain.GrantRefreshToken,
+		ClientID:     "beta",
+		RefreshToken: "initial",
+	})
+	tenantID = tenant.ID
+
+	_, err := f.service.Token(context.Background(), tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrInvalidTransition)
+	assert.Equal(t, domain.TenantOffboarding, f.store.tenants[tenant.ID].Status, "storing the rotated token does not reactivate the tenant")
+	assert.Equal(t, "initial", f.storedCredentials(t, tenant.ID).RefreshToken)
+}
+
+func TestCredentialService_Token_LegacyPlaintext(t *testing.T) {
+	f := newCredentialFixture(t)
+	creds := graphCredentials("acme")
+	creds.TokenURL = f.url
+	encoded, err := creds.Encode()
+	require.NoError(t, err)
+	// Stored in the clear before credentials were sealed
+	tenant := &domain.Tenant{ID: uuid.New(), Name: "acme", Provider: domain.ProviderMicrosoft, Status: domain.TenantActive, Credentials: encoded}
+	f.store.tenants[tenant.ID] = tenant
+
+	token, err := f.service.Token(context.Background(), tenant.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.True(t, envelope.IsSealed(f.store.tenants[tenant.ID].Credentials), "sealed on first read")
+	assert.Equal(t, "secret", f.storedCredentials(t, tenant.ID).ClientSecret)
+	assert.Equal(t, domain.TenantActive, f.store.tenants[tenant.ID].Status)
+}
+
+func TestCredentialService_Token_ServiceAccount(t *testing.T) {
+	f := newCredentialFixture(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	tenant := f.addTenant(t, domain.ProviderGoogle, domain.TenantCredentials{
+		Grant:          domain.GrantJWTBearer,
+		ServiceAccount: "ingest@beta.iam.gserviceaccount.com",
+		PrivateKey:     string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		Subject:        "admin@beta.com",
+	})
+
+	token, err := f.service.Token(context.Background(), tenant.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	f.tokens.Revoke("ingest@beta.iam.gserviceaccount.com")
+	f.now = f.now.Add(time.Hour)
+	_, err = f.service.Token(context.Background(), tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrAuthExpired)
+}
+
+func TestCredentialService_RefreshDue(t *testing.T) {
+	f := newCredentialFixture(t)
+	used := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("acme"))
+	revoked := f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("beta"))
+	f.addTenant(t, domain.ProviderMicrosoft, graphCredentials("never-used"))
+	ctx := context.Background()
+
+	for _, tenant := range []*domain.Tenant{used, revoked} {
+		_, err := f.service.Token(ctx, tenant.ID)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, CredentialStats{}, f.service.RefreshDue(ctx), "nothing due yet")
+
+	f.tokens.Revoke("beta")
+	f.now = f.now.Add(56 * time.Minute)
+	assert.Equal(t, CredentialStats{Refreshed: 1, Failed: 1}, f.service.RefreshDue(ctx))
+	assert.Equal(t, 3, f.tokens.Issued())
+	assert.Equal(t, domain.TenantAuthError, f.store.tenants[revoked.ID].Status)
+
+}
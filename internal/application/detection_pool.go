@@ -0,0 +1,272 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+)
+
+const (
+	// defaultBatchSize is the number of emails claimed per batch when not configured
+	defaultBatchSize = 500
+
+	// defaultLease is how long claimed emails stay reserved for a worker when not configured.
+	// It must cover analyzing and storing one batch; a crashed worker's emails are
+	// picked up by others once it expires.
+	defaultLease = 5 * time.Minute
+)
+
+// PoolConfig configures concurrent detection
+type PoolConfig struct {
+	// Workers is the number of emails analyzed in parallel (default: number of CPUs)
+	Workers int
+
+	// BatchSize is the number of emails claimed, looked up and written per batch
+	BatchSize int
+
+	// Owner identifies this worker on its email leases (default: hostname, PID and a random suffix)
+	Owner string
+
+	// Lease is how long a claimed batch is reserved for this worker
+	Lease time.Duration
+
+	// Shadow is a candidate detector run on every batch next to the live one (optional)
+	// Its analyses are stored as history under its own version and never alert; compare
+	// them with the live ones with CompareVersions.
+	Shadow *detection.Detector
+}
+
+// withDefaults fills unset fields
+func (c PoolConfig) withDefaults() PoolConfig {
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.Owner == "" {
+		c.Owner = newLeaseOwner()
+	}
+	if c.Lease <= 0 {
+		c.Lease = defaultLease
+	}
+	return c
+}
+
+// newLeaseOwner returns a worker identity that is unique across processes and hosts
+func newLeaseOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.New().String()[:8])
+}
+
+// ProcessingStats reports the throughput of a detection run
+type ProcessingStats struct {
+	Emails       int // Emails analyzed and stored
+	Failed       int // Emails analyzed but not stored; they are retried when their lease expires
+	LeasesLost   int // Emails whose lease expired before their analysis was stored; another worker owns them
+	Batches      int
+	Rounds       int               // Scheduler rounds: one batch per tenant with claimable emails
+	Shadowed     int               // Emails analyzed and stored by the shadow detector
+	ShadowFailed int               // Shadow analyses that failed to store; live processing is unaffected
+	PerTenant    map[uuid.UUID]int // Emails stored per tenant
+	Elapsed      time.Duration
+}
+
+// Throughput returns the number of emails stored per second
+func (s ProcessingStats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Emails) / s.Elapsed.Seconds()
+}
+
+// ProcessConcurrently runs fraud detection on all unprocessed emails with a worker pool
+//
+// Tenants are scheduled round-robin: each round processes at most one batch per
+// tenant with claimable emails, so a tenant with a huge backlog cannot starve the
+// others; their lag stays bounded by one round. The tenant list is refreshed from the
+// backlog every round, which picks up tenants whose emails arrive mid-run.
+//
+// Each batch costs a fixed number of storage round trips instead of three per email:
+// one claim, one recipient lookup and one transactional write of all analyses.
+// Analysis itself is pure and CPU-bound, so it runs on cfg.Workers goroutines.
+//
+// Several processes can run it at once: batches are claimed with leases, so each
+// email is analyzed by one worker at a time. Processing is at-least-once: a batch is
+// recorded atomically, a batch that fails to store is retried (by any worker) once
+// its lease expires, and a batch interrupted by ctx cancellation is released at once.
+func (s *FraudDetectionService) ProcessConcurrently(ctx context.Context, cfg PoolConfig) (stats ProcessingStats, err error) {
+	cfg = cfg.withDefaults()
+	stats.PerTenant = make(map[uuid.UUID]int)
+	start := time.Now()
+	defer func() { stats.Elapsed = time.Since(start) }()
+
+	for {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+
+		backlog, err := s.storage.GetBacklog(ctx)
+		if err != nil {
+			return stats, fmt.Errorf("failed to fetch backlog: %w", err)
+		}
+		round := make([]uuid.UUID, 0, len(backlog))
+		for _, tenant := range backlog {
+			if tenant.Claimable() > 0 {
+				round = append(round, tenant.TenantID)
+			}
+		}
+		if len(round) == 0 {
+			break // Backlog drained, or the rest is leased to other workers
+		}
+		stats.Rounds++
+
+		for _, tenantID := range round {
+			if err := s.processTenantBatch(ctx, tenantID, cfg, &stats); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	log.Printf("Processed %d emails for %d tenants in %d batches (%d failed, %d leases lost)",
+		stats.Emails, len(stats.PerTenant), stats.Batches, stats.Failed, stats.LeasesLost)
+	return stats, nil
+}
+
+// processTenantBatch claims, analyzes and stores one batch of a tenant's emails
+// Only claim failures and cancellation are returned; a failed write is counted and
+// its batch is left leased so it is retried after expiry, not re-claimed right away.
+func (s *FraudDetectionService) processTenantBatch(ctx context.Context, tenantID uuid.UUID, cfg PoolConfig, stats *ProcessingStats) error {
+	emails, err := s.storage.ClaimUnprocessedEmails(ctx, tenantID, cfg.Owner, cfg.BatchSize, cfg.Lease)
+	if err != nil {
+		return fmt.Errorf("failed to claim unprocessed emails for tenant %s: %w", tenantID, err)
+	}
+	if len(emails) == 0 {
+		return nil // Claimed by another worker since the backlog was read
+	}
+	stats.Batches++
+
+	// The tenant policy and recipients are resolved once per batch, not once per email
+	policy := s.tenantPolicy(ctx, tenantID)
+	detector := policy.applyLive(s.detector)
+	recipients := s.lookupRecipients(ctx, tenantID, emails)
+
+	analyses, err := analyzeBatch(ctx, emails, recipients, detector, cfg.Workers)
+	if err != nil {
+		s.releaseLeases(cfg.Owner, emails)
+		return err
+	}
+
+	stored, err := s.storage.CompleteEmails(ctx, cfg.Owner, analyses)
+	if err != nil {
+		stats.Failed += len(analyses)
+		log.Printf("Failed to store batch of %d analyses for tenant %s: %v", len(analyses), tenantID, err)
+		return nil
+	}
+	stats.Emails += stored
+	stats.PerTenant[tenantID] += stored
+	stats.LeasesLost += len(analyses) - stored
+	for i, analysis := range analyses {
+		logHighRisk(emails[i], analysis)
+	}
+
+	// The shadow detector runs once live analyses are stored, so it never delays them
+	if cfg.Shadow != nil {
+		return s.shadowBatch(ctx, policy.apply(cfg.Shadow), emails, recipients, cfg.Workers, stats)
+	}
+	return nil
+}
+
+// GetBacklog reports pending emails and detection lag per tenant, longest lag first
+func (s *FraudDetectionService) GetBacklog(ctx context.Context) ([]domain.TenantBacklog, error) {
+	return s.storage.GetBacklog(ctx)
+}
+
+// releaseLeases hands a batch back so other workers can claim it without waiting for expiry
+// It runs on its own context since the caller's is usually cancelled by then.
+func (s *FraudDetectionService) releaseLeases(owner string, emails []domain.Email) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ids := make([]uuid.UUID, len(emails))
+	for i, email := range emails {
+		ids[i] = email.ID
+	}
+	if err := s.storage.ReleaseEmails(ctx, owner, ids); err != nil {
+		log.Printf("Failed to release %d email leases (they expire on their own): %v", len(ids), err)
+	}
+}
+
+// lookupRecipients fetches the recipients of a tenant's batch with one query
+// A failed lookup is logged and detection continues without role-based signals.
+func (s *FraudDetectionService) lookupRecipients(ctx context.Context, tenantID uuid.UUID, emails []domain.Email) map[string]*domain.User {
+	addresses := make([]string, 0, len(emails))
+	seen := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		if email.RecipientEmail == "" || seen[email.RecipientEmail] {
+			continue
+		}
+		seen[email.RecipientEmail] = true
+		addresses = append(addresses, email.RecipientEmail)
+	}
+
+	users, err := s.storage.GetUsersByEmails(ctx, tenantID, addresses)
+	if err != nil {
+		log.Printf("Failed to fetch %d recipient users for tenant %s: %v", len(addresses), tenantID, err)
+		return nil
+	}
+	return users
+}
+
+// analyzeBatch runs the detector on every email of a batch on a bounded number of goroutines
+// Results keep the order of emails. On cancellation it stops feeding workers and returns ctx.Err().
+func analyzeBatch(
+	ctx context.Context,
+	emails []domain.Email,
+	recipients map[string]*domain.User,
+	detector *detection.Detector,
+	workers int,
+) ([]domain.FraudAnalysis, error) {
+	analyses := make([]domain.FraudAnalysis, len(emails))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(emails)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				analyses[i] = detector.AnalyzeEmail(emails[i], recipients[emails[i].RecipientEmail])
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := range emails {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	return analyses, nil
+}
@@ -0,0 +1,237 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedEmails adds n unprocessed emails spread over two tenants, each sent to a known user
+func seedEmails(store *fakeStorage, n int) []uuid.UUID {
+	tenants := []uuid.UUID{uuid.New(), uuid.New()}
+	seedTenant(store, tenants[0], n-n/2)
+	seedTenant(store, tenants[1], n/2)
+	return tenants
+}
+
+// seedTenant adds n unprocessed emails for a tenant, sent to its CFO
+func seedTenant(store *fakeStorage, tenantID uuid.UUID, n int) {
+	store.addUser(domain.User{TenantID: tenantID, Email: "cfo@company.com", Role: "CFO"})
+	for i := 0; i < n; i++ {
+		store.addEmail(domain.Email{
+			TenantID:       tenantID,
+			Subject:        fmt.Sprintf("Urgent Payment Needed #%d", i),
+			SenderEmail:    "fake@external.com",
+			RecipientEmail: "cfo@company.com",
+			BodyPreview:    "Wire transfer must be completed asap",
+		})
+	}
+}
+
+func newTestService(store *fakeStorage) *FraudDetectionService {
+	detector := detection.NewDetector([]string{"company.com"}, []string{})
+	return NewFraudDetectionService(store, detector, nil, nil)
+}
+
+func TestProcessConcurrently(t *testing.T) {
+	store := newFakeStorage()
+	seedEmails(store, 25)
+	service := newTestService(store)
+
+	stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{Workers: 4, BatchSize: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, 25, stats.Emails)
+	assert.Equal(t, 4, stats.Batches, "two batches per tenant")
+	assert.Equal(t, 2, stats.Rounds)
+	assert.Zero(t, stats.Failed)
+	assert.Positive(t, stats.Elapsed)
+
+	// Every email is stored exactly once
+	require.Len(t, store.processed, 25)
+	for id, count := range store.processed {
+		assert.Equal(t, 1, count, "email %s", id)
+	}
+
+	// Lookups and writes are batched: one recipient query and one write per batch
+	assert.Equal(t, 4, store.callCount("GetUsersByEmails"))
+	assert.Equal(t, 4, store.callCount("CompleteEmails"))
+	assert.Zero(t, store.callCount("GetUserByEmail"))
+	assert.Zero(t, store.callCount("CreateFraudAnalysis"))
+	assert.Empty(t, store.leases, "completed emails hold no lease")
+
+	// Batched recipients still feed role-based detection
+	for _, analysis := range store.analyses {
+		types := make([]string, 0, len(analysis.DetectedThreats))
+		for _, threat := range analysis.DetectedThreats {
+			types = append(types, threat.Type)
+		}
+		assert.Contains(t, types, "BEC_CSUITE_TARGETING")
+	}
+}
+
+func TestProcessConcurrently_FairScheduling(t *testing.T) {
+	store := newFakeStorage()
+	large, small := uuid.New(), uuid.New()
+	seedTenant(store, large, 100) // Oldest backlog first: the large tenant is scheduled first
+	seedTenant(store, small, 15)
+	service := newTestService(store)
+
+	stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, 100, stats.PerTenant[large])
+	assert.Equal(t, 15, stats.PerTenant[small])
+	assert.Equal(t, 10, stats.Rounds)
+
+	// Tenants alternate until the small one is drained instead of waiting behind 100 emails
+	require.Len(t, store.completions, 12)
+	assert.Equal(t, []uuid.UUID{large, small, large, small, large}, store.completions[:5])
+}
+
+func TestProcessUnprocessedEmails_TenantScoped(t *testing.T) {
+	store := newFakeStorage()
+	tenants := seedEmails(store, 20)
+	service := newTestService(store)
+
+	require.NoError(t, service.ProcessUnprocessedEmails(context.Background(), tenants[0]))
+
+	backlog, err := service.GetBacklog(context.Background())
+	require.NoError(t, err)
+	require.Len(t, backlog, 1, "only the requested tenant is processed")
+	assert.Equal(t, tenants[1], backlog[0].TenantID)
+	assert.Equal(t, 10, backlog[0].Pending)
+	assert.Equal(t, 10, backlog[0].Claimable())
+	assert.Positive(t, backlog[0].Lag)
+}
+
+func TestProcessConcurrently_ConcurrentWorkers(t *testing.T) {
+	store := newFakeStorage()
+	store.latency = time.Millisecond
+	seedEmails(store, 200)
+	service := newTestService(store)
+
+	const workers = 4
+	results := make(chan ProcessingStats, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 10})
+			assert.NoError(t, err)
+			results <- stats
+		}()
+	}
+
+	total := 0
+	for i := 0; i < workers; i++ {
+		total += (<-results).Emails
+	}
+	assert.Equal(t, 200, total)
+	require.Len(t, store.processed, 200)
+	for id, count := range store.processed {
+		assert.Equal(t, 1, count, "email %s analyzed by several workers", id)
+	}
+}
+
+func TestProcessConcurrently_ExpiredLease(t *testing.T) {
+	store := newFakeStorage()
+	tenantID := uuid.New()
+	seedTenant(store, tenantID, 10)
+	service := newTestService(store)
+
+	// A worker claims part of the backlog and crashes; another holds a live lease
+	_, err := store.ClaimUnprocessedEmails(context.Background(), tenantID, "crashed-worker", 4, -time.Second)
+	require.NoError(t, err)
+	_, err = store.ClaimUnprocessedEmails(context.Background(), tenantID, "busy-worker", 3, time.Hour)
+	require.NoError(t, err)
+
+	stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 5})
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, stats.Emails, "expired leases are reclaimed, live ones are skipped")
+	assert.Len(t, store.leases, 3)
+}
+
+func TestProcessConcurrently_Cancelled(t *testing.T) {
+	store := newFakeStorage()
+	seedEmails(store, 10)
+	service := newTestService(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stats, err := service.ProcessConcurrently(ctx, PoolConfig{Workers: 2, BatchSize: 5})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, stats.Emails)
+	assert.Empty(t, store.analyses, "nothing is stored, emails stay unprocessed")
+	assert.Empty(t, store.leases, "no email is left reserved")
+}
+
+func TestProcessConcurrently_WriteFailure(t *testing.T) {
+	store := newFakeStorage()
+	seedEmails(store, 10)
+	store.writeErr = errors.New("connection reset")
+	service := newTestService(store)
+
+	stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 5})
+	require.NoError(t, err)
+
+	// Failed batches keep their leases, so the run moves on instead of re-claiming them;
+	// they are retried once the leases expire
+	assert.Equal(t, 10, stats.Failed)
+	assert.Zero(t, stats.Emails)
+	assert.Equal(t, 2, store.callCount("ClaimUnprocessedEmails"))
+	assert.Empty(t, store.processed)
+	assert.Len(t, store.leases, 10)
+}
+
+// benchmarkEmails and benchmarkLatency model a backlog against a database a
+// millisecond away, where round trips rather than analysis dominate
+const (
+	benchmarkEmails  = 200
+	benchmarkLatency = time.Millisecond
+)
+
+func BenchmarkProcessUnprocessedEmails_Sequential(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store := newFakeStorage()
+		store.latency = benchmarkLatency
+		tenants := seedEmails(store, benchmarkEmails)
+		service := newTestService(store)
+		b.StartTimer()
+
+		// The sequential loop claims 100 emails per call; run it until the backlog is drained
+		for processed := 0; processed < benchmarkEmails; processed = len(store.processed) {
+			for _, tenantID := range tenants {
+				if err := service.ProcessUnprocessedEmails(context.Background(), tenantID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkProcessConcurrently(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store := newFakeStorage()
+		store.latency = benchmarkLatency
+		seedEmails(store, benchmarkEmails)
+		service := newTestService(store)
+		b.StartTimer()
+
+		stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{BatchSize: 100})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(stats.Throughput(), "emails/s")
+	}
+}
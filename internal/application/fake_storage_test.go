@@ -0,0 +1,1201 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/directory"
+	"github.com/stoik/email-security/internal/ports"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStorage is an in-memory ports.Storage for service tests
+// Methods a test does not need are left to the embedded nil interface (and panic).
+// latency simulates a database round trip on every call.
+type fakeStorage struct {
+	ports.Storage
+
+	mu        sync.Mutex
+	latency   time.Duration
+	emails    map[uuid.UUID]*domain.Email
+	leases    map[uuid.UUID]fakeLease
+	users     map[string]*domain.User // keyed by tenant ID + email
+	analyses  []domain.FraudAnalysis
+	writeErr  error
+	calls     map[string]int
+	processed map[uuid.UUID]int
+
+	// completions lists the tenant of every CompleteEmails batch, in order
+	completions []uuid.UUID
+
+	outbox []*fakeOutboxEntry
+
+	webhookSubs       []domain.WebhookSubscription
+	webhookDeliveries []*fakeWebhookDelivery
+	webhookAttempts   []domain.WebhookAttempt
+
+	notificationRoutes []domain.NotificationRoute
+
+	tenants             map[uuid.UUID]*domain.Tenant
+	remediationPolicies []domain.RemediationPolicy
+	remediationActions  []domain.RemediationAction
+
+	mailSubscriptions []domain.MailSubscription
+
+	dataKeys map[uuid.UUID]*domain.DataKey
+
+	roleOverrides []domain.RoleOverride
+	vips          []domain.VIP
+
+	senderList        []domain.SenderListEntry
+	senderListChanges []domain.SenderListChange
+
+	// history holds reprocessed analyses stored without being promoted to current
+	history []domain.FraudAnalysis
+}
+
+// fakeWebhookDelivery is a webhook delivery with its lease
+type fakeWebhookDelivery struct {
+	delivery domain.WebhookDelivery
+	lease    fakeLease
+}
+
+// fakeOutboxEntry is an outbox event with its delivery state
+type fakeOutboxEntry struct {
+	event       domain.Event
+	lease       fakeLease
+	nextAttempt time.Time
+	lastErr     string
+	published   bool
+	dead        bool
+}
+
+type fakeLease struct {
+	owner   string
+	expires time.Time
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		emails:    make(map[uuid.UUID]*domain.Email),
+		leases:    make(map[uuid.UUID]fakeLease),
+		users:     make(map[string]*domain.User),
+		calls:     make(map[string]int),
+		processed: make(map[uuid.UUID]int),
+		tenants:   make(map[uuid.UUID]*domain.Tenant),
+		dataKeys:  make(map[uuid.UUID]*domain.DataKey),
+	}
+}
+
+func (f *fakeStorage) roundTrip(method string) {
+	f.mu.Lock()
+	f.calls[method]++
+	f.mu.Unlock()
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+}
+
+func (f *fakeStorage) callCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *fakeStorage) addEmail(email domain.Email) {
+	if email.ID == uuid.Nil {
+		email.ID = uuid.New()
+	}
+	if email.ReceivedAt.IsZero() {
+		email.ReceivedAt = time.Now().Add(-time.Hour).Add(time.Duration(len(f.emails)) * time.Millisecond)
+	}
+	if email.IngestedAt.IsZero() {
+		email.IngestedAt = email.ReceivedAt
+	}
+	f.emails[email.ID] = &email
+}
+
+// analyzed stores an email with a current analysis of a level and detection types,
+// and returns its analysis.completed event
+func (f *fakeStorage) analyzed(t *testing.T, email domain.Email, level string, detectionTypes ...string) domain.Event {
+	if email.ID == uuid.Nil {
+		email.ID = uuid.New()
+	}
+	analysis := domain.FraudAnalysis{ID: uuid.New(), EmailID: email.ID, RiskLevel: level}
+	for _, detectionType := range detectionTypes {
+		analysis.DetectedThreats = append(analysis.DetectedThreats, domain.Detection{Type: detectionType, Confidence: 0.9})
+	}
+	f.addEmail(email)
+	f.analyses = append(f.analyses, analysis)
+
+	events, err := domain.NewAnalysisEvents(email, analysis)
+	require.NoError(t, err)
+	return events[0]
+}
+
+func (f *fakeStorage) addUser(user domain.User) {
+	f.users[user.TenantID.String()+user.Email] = &user
+}
+
+func (f *fakeStorage) CreateTenant(ctx context.Context, tenant *domain.Tenant) error {
+	f.roundTrip("CreateTenant")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *tenant
+	f.tenants[tenant.ID] = &stored
+	return nil
+}
+
+func (f *fakeStorage) GetTenant(ctx context.Context, id uuid.UUID) (*domain.Tenant, error) {
+	f.roundTrip("GetTenant")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tenant, ok := f.tenants[id]; ok {
+		copied := *tenant
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeStorage) ListDetectionRules(ctx context.Context, tenantID uuid.UUID) ([]domain.DetectionRule, error) {
+	f.roundTrip("ListDetectionRules")
+	return nil, nil
+}
+
+func (f *fakeStorage) UpsertRoleOverride(ctx context.Context, override *domain.RoleOverride) error {
+	f.roundTrip("UpsertRoleOverride")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, existing := range f.roleOverrides {
+		if existing.TenantID == override.TenantID && existing.Title == override.Title && existing.Email == override.Email {
+			override.ID, override.CreatedAt = existing.ID, existing.CreatedAt
+			f.roleOverrides[i] = *override
+			return nil
+		}
+	}
+	f.roleOverrides = append(f.roleOverrides, *override)
+	return nil
+}
+
+func (f *fakeStorage) DeleteRoleOverride(ctx context.Context, tenantID, overrideID uuid.UUID) error {
+	f.roundTrip("DeleteRoleOverride")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, existing := range f.roleOverrides {
+		if existing.TenantID == tenantID && existing.ID == overrideID {
+			f.roleOverrides = append(f.roleOverrides[:i], f.roleOverrides[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("role override %s not found", overrideID)
+}
+
+func (f *fakeStorage) ListRoleOverrides(ctx context.Context, tenantID uuid.UUID) ([]domain.RoleOverride, error) {
+	f.roundTrip("ListRoleOverrides")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	overrides := make([]domain.RoleOverride, 0)
+	for _, override := range f.roleOverrides {
+		if override.TenantID == tenantID {
+			overrides = append(overrides, override)
+		}
+	}
+	return overrides, nil
+}
+
+func (f *fakeStorage) UpsertVIP(ctx context.Context, vip *domain.VIP) error {
+	f.roundTrip("UpsertVIP")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, existing := range f.vips {
+		if existing.TenantID == vip.TenantID && existing.Email == vip.Email {
+			vip.ID, vip.CreatedAt = existing.ID, existing.CreatedAt
+			f.vips[i] = *vip
+			return nil
+		}
+	}
+	f.vips = append(f.vips, *vip)
+	return nil
+}
+
+func (f *fakeStorage) DeleteVIP(ctx context.Context, tenantID, vipID uuid.UUID) error {
+	f.roundTrip("DeleteVIP")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, existing := range f.vips {
+		if existing.TenantID == tenantID && existing.ID == vipID {
+			f.vips = append(f.vips[:i], f.vips[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("VIP %s not found", vipID)
+}
+
+func (f *fakeStorage) ListVIPs(ctx context.Context, tenantID uuid.UUID) ([]domain.VIP, error) {
+	f.roundTrip("ListVIPs")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vips := make([]domain.VIP, 0)
+	for _, vip := range f.vips {
+		if vip.TenantID == tenantID {
+			vips = append(vips, vip)
+		}
+	}
+	return vips, nil
+}
+
+func (f *fakeStorage) CreateSenderListEntry(ctx context.Context, entry *domain.SenderListEntry) error {
+	f.roundTrip("CreateSenderListEntry")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, existing := range f.senderList {
+		if existing.TenantID == entry.TenantID && existing.MatchType == entry.MatchType && existing.Value == entry.Value {
+			return fmt.Errorf("duplicate sender list entry %s %s", entry.MatchType, entry.Value)
+		}
+	}
+	f.senderList = append(f.senderList, *entry)
+	f.senderListChanges = append(f.senderListChanges, domain.SenderListChange{
+		ID: uuid.New(), TenantID: entry.TenantID, EntryID: entry.ID, Operation: domain.SenderListCreated,
+		Entry: *entry, Actor: entry.CreatedBy, At: entry.CreatedAt,
+	})
+	return nil
+}
+
+func (f *fakeStorage) DeleteSenderListEntry(ctx context.Context, tenantID, entryID uuid.UUID, actor string) error {
+	f.roundTrip("DeleteSenderListEntry")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, existing := range f.senderList {
+		if existing.TenantID == tenantID && existing.ID == entryID {
+			f.senderList = append(f.senderList[:i], f.senderList[i+1:]...)
+			f.senderListChanges = append(f.senderListChanges, domain.SenderListChange{
+				ID: uuid.New(), TenantID: tenantID, EntryID: entryID, Operation: domain.SenderListDeleted,
+				Entry: existing, Actor: actor, At: time.Now(),
+			})
+			return nil
+		}
+	}
+	return fmt.Errorf("sender list entry %s not found", entryID)
+}
+
+func (f *fakeStorage) ListSenderListEntries(ctx context.Context, tenantID uuid.UUID) ([]domain.SenderListEntry, error) {
+	f.roundTrip("ListSenderListEntries")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := make([]domain.SenderListEntry, 0)
+	for _, entry := range f.senderList {
+		if entry.TenantID == tenantID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (f *fakeStorage) ListSenderListChanges(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.SenderListChange, error) {
+	f.roundTrip("ListSenderListChanges")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changes := make([]domain.SenderListChange, 0)
+	for i := len(f.senderListChanges) - 1; i >= 0 && len(changes) < limit; i-- {
+		if f.senderListChanges[i].TenantID == tenantID {
+			changes = append(changes, f.senderListChanges[i])
+		}
+	}
+	return changes, nil
+}
+
+func (f *fakeStorage) GetEmail(ctx context.Context, id uuid.UUID) (*domain.Email, error) {
+	f.roundTrip("GetEmail")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	email, ok := f.emails[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *email
+	return &copied, nil
+}
+
+func (f *fakeStorage) GetUnprocessedEmails(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.Email, error) {
+	f.roundTrip("GetUnprocessedEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	emails := make([]domain.Email, 0, limit)
+	for _, email := range f.emails {
+		if email.TenantID == tenantID && email.ProcessedAt == nil {
+			emails = append(emails, *email)
+		}
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].ReceivedAt.Before(emails[j].ReceivedAt) })
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+	return emails, nil
+}
+
+func (f *fakeStorage) GetUserByEmail(ctx context.Context, tenantID uuid.UUID, email string) (*domain.User, error) {
+	f.roundTrip("GetUserByEmail")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[tenantID.String()+email]
+	if !ok {
+		return nil, nil
+	}
+	return f.withManagers(user), nil
+}
+
+func (f *fakeStorage) GetUsersByEmails(ctx context.Context, tenantID uuid.UUID, emails []string) (map[string]*domain.User, error) {
+	f.roundTrip("GetUsersByEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make(map[string]*domain.User)
+	for _, email := range emails {
+		if user, ok := f.users[tenantID.String()+email]; ok {
+			users[email] = f.withManagers(user)
+		}
+	}
+	return users, nil
+}
+
+// withManagers copies a user with its manager chain, as the store loads it
+func (f *fakeStorage) withManagers(user *domain.User) *domain.User {
+	byID := make(map[uuid.UUID]*domain.User)
+	for _, u := range f.users {
+		if u.TenantID == user.TenantID {
+			byID[u.ID] = u
+		}
+	}
+	copied := *user
+	copied.Managers = directory.Chain(byID, user.ID)
+	return &copied
+}
+
+func (f *fakeStorage) CreateFraudAnalysis(ctx context.Context, analysis *domain.FraudAnalysis) error {
+	f.roundTrip("CreateFraudAnalysis")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	f.analyses = append(f.analyses, *analysis)
+	return nil
+}
+
+// GetFraudAnalysisByEmail returns the latest stored analysis of an email
+func (f *fakeStorage) GetFraudAnalysisByEmail(ctx context.Context, emailID uuid.UUID) (*domain.FraudAnalysis, error) {
+	f.roundTrip("GetFraudAnalysisByEmail")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.analyses) - 1; i >= 0; i-- {
+		if f.analyses[i].EmailID == emailID {
+			analysis := f.analyses[i]
+			return &analysis, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListProcessedEmails pages through processed emails by (ReceivedAt, ID)
+func (f *fakeStorage) ListProcessedEmails(ctx context.Context, tenantID uuid.UUID, after domain.EmailCursor, to time.Time, limit int) ([]domain.Email, error) {
+	f.roundTrip("ListProcessedEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	emails := make([]domain.Email, 0, limit)
+	for _, email := range f.emails {
+		if email.TenantID != tenantID || email.ProcessedAt == nil || !email.ReceivedAt.Before(to) {
+			continue
+		}
+		if email.ReceivedAt.Before(after.ReceivedAt) ||
+			email.ReceivedAt.Equal(after.ReceivedAt) && email.ID.String() <= after.ID.String() {
+			continue
+		}
+		emails = append(emails, *email)
+	}
+	sort.Slice(emails, func(i, j int) bool {
+		if !emails[i].ReceivedAt.Equal(emails[j].ReceivedAt) {
+			return emails[i].ReceivedAt.Before(emails[j].ReceivedAt)
+		}
+		return emails[i].ID.String() < emails[j].ID.String()
+	})
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+	return emails, nil
+}
+
+// HuntEmails returns emails in the query's tenant and window, newest first: it leaves
+// every other criterion to the service's confirmation
+func (f *fakeStorage) HuntEmails(ctx context.Context, q domain.HuntQuery, before domain.EmailCursor, limit int) ([]domain.HuntMatch, error) {
+	f.roundTrip("HuntEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	emails := make([]domain.Email, 0)
+	for _, email := range f.emails {
+		if q.TenantID != uuid.Nil && email.TenantID != q.TenantID {
+			continue
+		}
+		if !q.From.IsZero() && email.ReceivedAt.Before(q.From) || !q.To.IsZero() && !email.ReceivedAt.Before(q.To) {
+			continue
+		}
+		if !before.ReceivedAt.IsZero() && (email.ReceivedAt.After(before.ReceivedAt) ||
+			email.ReceivedAt.Equal(before.ReceivedAt) && email.ID.String() >= before.ID.String()) {
+			continue
+		}
+		emails = append(emails, *email)
+	}
+	sort.Slice(emails, func(i, j int) bool {
+		if !emails[i].ReceivedAt.Equal(emails[j].ReceivedAt) {
+			return emails[i].ReceivedAt.After(emails[j].ReceivedAt)
+		}
+		return emails[i].ID.String() > emails[j].ID.String()
+	})
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+
+	matches := make([]domain.HuntMatch, len(emails))
+	for i, email := range emails {
+		matches[i] = domain.HuntMatch{Email: email}
+		if tenant, ok := f.tenants[email.TenantID]; ok {
+			matches[i].TenantName = tenant.Name
+		}
+		for _, analysis := range f.analyses {
+			if analysis.EmailID == email.ID {
+				matches[i].RiskLevel = analysis.RiskLevel
+				matches[i].RiskScore = analysis.RiskScore
+			}
+		}
+	}
+	return matches, nil
+}
+
+// GetCurrentAnalyses returns the latest stored analysis of each email
+func (f *fakeStorage) GetCurrentAnalyses(ctx context.Context, emailIDs []uuid.UUID) (map[uuid.UUID]domain.FraudAnalysis, error) {
+	f.roundTrip("GetCurrentAnalyses")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	wanted := make(map[uuid.UUID]bool, len(emailIDs))
+	for _, id := range emailIDs {
+		wanted[id] = true
+	}
+	current := make(map[uuid.UUID]domain.FraudAnalysis)
+	for _, analysis := range f.analyses {
+		if wanted[analysis.EmailID] {
+			current[analysis.EmailID] = analysis
+		}
+	}
+	return current, nil
+}
+
+func (f *fakeStorage) StoreVersionedAnalyses(ctx context.Context, analyses []domain.FraudAnalysis, promote bool) error {
+	f.roundTrip("StoreVersionedAnalyses")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	now := time.Now()
+	for i := range analyses {
+		analyses[i].ID = uuid.New()
+		analyses[i].AnalyzedAt = now
+	}
+	if promote {
+		f.analyses = append(f.analyses, analyses...)
+	} else {
+		f.history = append(f.history, analyses...)
+	}
+	return nil
+}
+
+// ListAnalysesByVersion returns the last stored analysis of each email by a version,
+// current analyses first, then history
+func (f *fakeStorage) ListAnalysesByVersion(ctx context.Context, tenantID uuid.UUID, detectorVersion string, from, to time.Time) (map[uuid.UUID]domain.FraudAnalysis, error) {
+	f.roundTrip("ListAnalysesByVersion")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	analyses := make(map[uuid.UUID]domain.FraudAnalysis)
+	for _, analysis := range append(append([]domain.FraudAnalysis{}, f.analyses...), f.history...) {
+		email := f.emails[analysis.EmailID]
+		if analysis.DetectorVersion != detectorVersion || email == nil || email.TenantID != tenantID ||
+			email.ReceivedAt.Before(from) || !email.ReceivedAt.Before(to) {
+			continue
+		}
+		analyses[analysis.EmailID] = analysis
+	}
+	return analyses, nil
+}
+
+func (f *fakeStorage) ClaimUnprocessedEmails(ctx context.Context, tenantID uuid.UUID, owner string, limit int, lease time.Duration) ([]domain.Email, error) {
+	f.roundTrip("ClaimUnprocessedEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	emails := make([]domain.Email, 0, limit)
+	for id, email := range f.emails {
+		if email.TenantID == tenantID && email.ProcessedAt == nil && !f.leases[id].expires.After(now) {
+			emails = append(emails, *email)
+		}
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].ReceivedAt.Before(emails[j].ReceivedAt) })
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+	for _, email := range emails {
+		f.leases[email.ID] = fakeLease{owner: owner, expires: now.Add(lease)}
+	}
+	return emails, nil
+}
+
+func (f *fakeStorage) ReleaseEmails(ctx context.Context, owner string, emailIDs []uuid.UUID) error {
+	f.roundTrip("ReleaseEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range emailIDs {
+		if f.leases[id].owner == owner {
+			delete(f.leases, id)
+		}
+	}
+	return nil
+}
+
+func (f *fakeStorage) CompleteEmails(ctx context.Context, owner string, analyses []domain.FraudAnalysis) (int, error) {
+	f.roundTrip("CompleteEmails")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+
+	stored := 0
+	now := time.Now()
+	for _, analysis := range analyses {
+		email := f.emails[analysis.EmailID]
+		if f.leases[email.ID].owner != owner || email.ProcessedAt != nil {
+			continue // Lease lost
+		}
+		delete(f.leases, email.ID)
+		analysis.ID = uuid.New()
+		f.analyses = append(f.analyses, analysis)
+		events, err := domain.NewAnalysisEvents(*email, analysis)
+		if err != nil {
+			return stored, err
+		}
+		f.addEvents(events...)
+		email.ProcessedAt = &now
+		f.processed[email.ID]++
+		stored++
+	}
+	if len(analyses) > 0 {
+		f.completions = append(f.completions, f.emails[analyses[0].EmailID].TenantID)
+	}
+	return stored, nil
+}
+
+func (f *fakeStorage) GetBacklog(ctx context.Context) ([]domain.TenantBacklog, error) {
+	f.roundTrip("GetBacklog")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	byTenant := make(map[uuid.UUID]*domain.TenantBacklog)
+	for id, email := range f.emails {
+		if email.ProcessedAt != nil {
+			continue
+		}
+		tenant, ok := byTenant[email.TenantID]
+		if !ok {
+			tenant = &domain.TenantBacklog{TenantID: email.TenantID}
+			byTenant[email.TenantID] = tenant
+		}
+		tenant.Pending++
+		if f.leases[id].expires.After(now) {
+			tenant.Leased++
+		}
+		tenant.Lag = max(tenant.Lag, now.Sub(email.IngestedAt))
+	}
+
+	backlog := make([]domain.TenantBacklog, 0, len(byTenant))
+	for _, tenant := range byTenant {
+		backlog = append(backlog, *tenant)
+	}
+	sort.Slice(backlog, func(i, j int) bool { return backlog[i].Lag > backlog[j].Lag })
+	return backlog, nil
+}
+
+func (f *fakeStorage) MarkEmailProcessed(ctx context.Context, emailID uuid.UUID) error {
+	f.roundTrip("MarkEmailProcessed")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	f.emails[emailID].ProcessedAt = &now
+	f.processed[emailID]++
+	return nil
+}
+
+// addEvents writes events to the outbox, skipping known dedup keys; callers hold f.mu
+func (f *fakeStorage) addEvents(events ...domain.Event) {
+	for _, event := range events {
+		duplicate := false
+		for _, entry := range f.outbox {
+			duplicate = duplicate || entry.event.DedupKey == event.DedupKey
+		}
+		if !duplicate {
+			f.outbox = append(f.outbox, &fakeOutboxEntry{event: event})
+		}
+	}
+}
+
+func (f *fakeStorage) outboxEntry(id uuid.UUID, owner string) *fakeOutboxEntry {
+	for _, entry := range f.outbox {
+		if entry.event.ID == id && entry.lease.owner == owner {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (f *fakeStorage) ClaimOutboxEvents(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.Event, error) {
+	f.roundTrip("ClaimOutboxEvents")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	events := make([]domain.Event, 0, limit)
+	for _, entry := range f.outbox {
+		if len(events) == limit {
+			break
+		}
+		if entry.published || entry.dead || entry.nextAttempt.After(now) || entry.lease.expires.After(now) {
+			continue
+		}
+		entry.lease = fakeLease{owner: owner, expires: now.Add(lease)}
+		entry.event.Attempts++
+		events = append(events, entry.event)
+	}
+	return events, nil
+}
+
+func (f *fakeStorage) MarkOutboxEventsPublished(ctx context.Context, owner string, eventIDs []uuid.UUID) error {
+	f.roundTrip("MarkOutboxEventsPublished")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range eventIDs {
+		if entry := f.outboxEntry(id, owner); entry != nil {
+			entry.published, entry.lease = true, fakeLease{}
+		}
+	}
+	return nil
+}
+
+func (f *fakeStorage) RetryOutboxEvent(ctx context.Context, owner string, eventID uuid.UUID, delay time.Duration, lastErr string) error {
+	f.roundTrip("RetryOutboxEvent")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if entry := f.outboxEntry(eventID, owner); entry != nil {
+		entry.nextAttempt, entry.lastErr, entry.lease = time.Now().Add(delay), lastErr, fakeLease{}
+	}
+	return nil
+}
+
+func (f *fakeStorage) DeadLetterOutboxEvent(ctx context.Context, owner string, eventID uuid.UUID, lastErr string) error {
+	f.roundTrip("DeadLetterOutboxEvent")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if entry := f.outboxEntry(eventID, owner); entry != nil {
+		entry.dead, entry.lastErr, entry.lease = true, lastErr, fakeLease{}
+	}
+	return nil
+}
+
+func (f *fakeStorage) CreateWebhookSubscription(ctx context.Context, sub *domain.WebhookSubscription) error {
+	f.roundTrip("CreateWebhookSubscription")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.webhookSubs = append(f.webhookSubs, *sub)
+	return nil
+}
+
+func (f *fakeStorage) ListWebhookSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.WebhookSubscription, error) {
+	f.roundTrip("ListWebhookSubscriptions")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subs := make([]domain.WebhookSubscription, 0)
+	for _, sub := range f.webhookSubs {
+		if sub.TenantID == tenantID {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (f *fakeStorage) UpdateWebhookSubscriptionSecret(ctx context.Context, tenantID, subscriptionID uuid.UUID, secret string) error {
+	f.roundTrip("UpdateWebhookSubscriptionSecret")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.webhookSubs {
+		if f.webhookSubs[i].TenantID == tenantID && f.webhookSubs[i].ID == subscriptionID {
+			f.webhookSubs[i].Secret = secret
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook subscription %s not found", subscriptionID)
+}
+
+func (f *fakeStorage) EnqueueWebhookDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	f.roundTrip("EnqueueWebhookDeliveries")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, delivery := range deliveries {
+		duplicate := false
+		for _, existing := range f.webhookDeliveries {
+			duplicate = duplicate || (existing.delivery.SubscriptionID == delivery.SubscriptionID &&
+				existing.delivery.DedupKey == delivery.DedupKey)
+		}
+		if !duplicate {
+			f.webhookDeliveries = append(f.webhookDeliveries, &fakeWebhookDelivery{delivery: delivery})
+		}
+	}
+	return nil
+}
+
+func (f *fakeStorage) ClaimWebhookDeliveries(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	f.roundTrip("ClaimWebhookDeliveries")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	deliveries := make([]domain.WebhookDelivery, 0, limit)
+	for _, entry := range f.webhookDeliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		d := &entry.delivery
+		if d.Status != domain.WebhookPending || d.NextAttemptAt.After(now) || entry.lease.expires.After(now) {
+			continue
+		}
+		entry.lease = fakeLease{owner: owner, expires: now.Add(lease)}
+		d.Attempts++
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, nil
+}
+
+func (f *fakeStorage) RecordWebhookAttempt(ctx context.Context, owner string, attempt domain.WebhookAttempt, status string, retryIn time.Duration) error {
+	f.roundTrip("RecordWebhookAttempt")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range f.webhookDeliveries {
+		if entry.delivery.ID != attempt.DeliveryID || entry.lease.owner != owner {
+			continue
+		}
+		d := &entry.delivery
+		d.Status, d.NextAttemptAt = status, time.Now().Add(retryIn)
+		d.LastStatusCode, d.LastError = attempt.StatusCode, attempt.Error
+		if status == domain.WebhookDelivered {
+			d.DeliveredAt = &attempt.AttemptedAt
+		}
+		entry.lease = fakeLease{}
+		f.webhookAttempts = append(f.webhookAttempts, attempt)
+	}
+	return nil
+}
+
+func (f *fakeStorage) ListWebhookDeliveries(ctx context.Context, tenantID, subscriptionID uuid.UUID, limit int) ([]domain.WebhookDelivery, error) {
+	f.roundTrip("ListWebhookDeliveries")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deliveries := make([]domain.WebhookDelivery, 0)
+	for i := len(f.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := f.webhookDeliveries[i].delivery
+		if d.TenantID == tenantID && d.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeStorage) ListWebhookAttempts(ctx context.Context, tenantID, deliveryID uuid.UUID) ([]domain.WebhookAttempt, error) {
+	f.roundTrip("ListWebhookAttempts")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attempts := make([]domain.WebhookAttempt, 0)
+	for _, attempt := range f.webhookAttempts {
+		if attempt.DeliveryID == deliveryID {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (f *fakeStorage) CreateNotificationRoute(ctx context.Context, route *domain.NotificationRoute) error {
+	f.roundTrip("CreateNotificationRoute")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notificationRoutes = append(f.notificationRoutes, *route)
+	return nil
+}
+
+func (f *fakeStorage) ListNotificationRoutes(ctx context.Context, tenantID uuid.UUID) ([]domain.NotificationRoute, error) {
+	f.roundTrip("ListNotificationRoutes")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes := make([]domain.NotificationRoute, 0)
+	for _, route := range f.notificationRoutes {
+		if route.TenantID == tenantID {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+func (f *fakeStorage) UpdateNotificationRouteTarget(ctx context.Context, tenantID, routeID uuid.UUID, target string) error {
+	f.roundTrip("UpdateNotificationRouteTarget")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.notificationRoutes {
+		if f.notificationRoutes[i].TenantID == tenantID && f.notificationRoutes[i].ID == routeID {
+			f.notificationRoutes[i].Target = target
+			return nil
+		}
+	}
+	return fmt.Errorf("notification route %s not found", routeID)
+}
+
+func (f *fakeStorage) UpsertRemediationPolicy(ctx context.Context, policy *domain.RemediationPolicy) error {
+	f.roundTrip("UpsertRemediationPolicy")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, existing := range f.remediationPolicies {
+		if existing.TenantID == policy.TenantID && existing.RiskLevel == policy.RiskLevel {
+			f.remediationPolicies[i] = *policy
+			return nil
+		}
+	}
+	f.remediationPolicies = append(f.remediationPolicies, *policy)
+	return nil
+}
+
+func (f *fakeStorage) ListRemediationPolicies(ctx context.Context, tenantID uuid.UUID) ([]domain.RemediationPolicy, error) {
+	f.roundTrip("ListRemediationPolicies")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	policies := make([]domain.RemediationPolicy, 0)
+	for _, policy := range f.remediationPolicies {
+		if policy.TenantID == tenantID {
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+func (f *fakeStorage) CreateRemediationAction(ctx context.Context, action *domain.RemediationAction) error {
+	f.roundTrip("CreateRemediationAction")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	f.remediationActions = append(f.remediationActions, *action)
+	return nil
+}
+
+func (f *fakeStorage) GetRemediationAction(ctx context.Context, tenantID, actionID uuid.UUID) (*domain.RemediationAction, error) {
+	f.roundTrip("GetRemediationAction")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, action := range f.remediationActions {
+		if action.TenantID == tenantID && action.ID == actionID {
+			return &action, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeStorage) ListRemediationActions(ctx context.Context, tenantID, emailID uuid.UUID) ([]domain.RemediationAction, error) {
+	f.roundTrip("ListRemediationActions")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	actions := make([]domain.RemediationAction, 0)
+	for _, action := range f.remediationActions {
+		if action.TenantID == tenantID && action.EmailID == emailID {
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+func (f *fakeStorage) MarkRemediationRestored(ctx context.Context, tenantID, actionID uuid.UUID, by string, at time.Time) error {
+	f.roundTrip("MarkRemediationRestored")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.remediationActions {
+		action := &f.remediationActions[i]
+		if action.TenantID == tenantID && action.ID == actionID && action.Status == domain.RemediationApplied {
+			action.Status = domain.RemediationRestored
+			action.RestoredAt = &at
+			action.RestoredBy = by
+			return nil
+		}
+	}
+	return fmt.Errorf("applied remediation action %s not found", actionID)
+}
+
+func (f *fakeStorage) ListUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	f.roundTrip("ListUsers")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]domain.User, 0)
+	for _, user := range f.users {
+		if user.TenantID == tenantID && user.DeletedAt == nil {
+			users = append(users, *user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users, nil
+}
+
+// CreateEmail stores an email unless the tenant already has its provider message
+func (f *fakeStorage) CreateEmail(ctx context.Context, email *domain.Email) error {
+	f.roundTrip("CreateEmail")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	for _, existing := range f.emails {
+		if existing.TenantID == email.TenantID && existing.ProviderMessageID == email.ProviderMessageID {
+			return nil
+		}
+	}
+	stored := *email
+	f.emails[email.ID] = &stored
+	return nil
+}
+
+func (f *fakeStorage) CreateMailSubscription(ctx context.Context, sub *domain.MailSubscription) error {
+	f.roundTrip("CreateMailSubscription")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mailSubscriptions = append(f.mailSubscriptions, *sub)
+	return nil
+}
+
+func (f *fakeStorage) UpdateMailSubscription(ctx context.Context, sub *domain.MailSubscription) error {
+	f.roundTrip("UpdateMailSubscription")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.mailSubscriptions {
+		if f.mailSubscriptions[i].ID == sub.ID {
+			f.mailSubscriptions[i] = *sub
+			return nil
+		}
+	}
+	return fmt.Errorf("mail subscription %s not found", sub.ID)
+}
+
+func (f *fakeStorage) GetMailSubscription(ctx context.Context, provider domain.Provider, externalID string) (*domain.MailSubscription, error) {
+	f.roundTrip("GetMailSubscription")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, sub := range f.mailSubscriptions {
+		if sub.Provider == provider && sub.ExternalID != "" && sub.ExternalID == externalID {
+			return &sub, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeStorage) ListMailSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.MailSubscription, error) {
+	f.roundTrip("ListMailSubscriptions")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subs := make([]domain.MailSubscription, 0)
+	for _, sub := range f.mailSubscriptions {
+		if sub.TenantID == tenantID {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (f *fakeStorage) ListMailSubscriptionsDue(ctx context.Context, expiringBefore time.Time, limit int) ([]domain.MailSubscription, error) {
+	f.roundTrip("ListMailSubscriptionsDue")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subs := make([]domain.MailSubscription, 0)
+	for _, sub := range f.mailSubscriptions {
+		if (sub.Status == domain.SubscriptionLapsed || sub.ExpiresAt.Before(expiringBefore)) && len(subs) < limit {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (f *fakeStorage) CreateUser(ctx context.Context, user *domain.User) error {
+	f.roundTrip("CreateUser")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *user
+	stored.ManagerID, stored.DeletedAt = nil, nil
+	for key, existing := range f.users {
+		if existing.TenantID == user.TenantID && existing.ProviderUserID != "" && existing.ProviderUserID == user.ProviderUserID {
+			stored.ID, stored.ManagerID = existing.ID, existing.ManagerID
+			delete(f.users, key)
+		}
+	}
+	user.ID = stored.ID
+	f.users[user.TenantID.String()+user.Email] = &stored
+	return nil
+}
+
+func (f *fakeStorage) UpdateUserManagers(ctx context.Context, tenantID uuid.UUID, managers map[uuid.UUID]*uuid.UUID) error {
+	f.roundTrip("UpdateUserManagers")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if managerID, ok := managers[user.ID]; ok && user.TenantID == tenantID {
+			user.ManagerID = managerID
+		}
+	}
+	return nil
+}
+
+func (f *fakeStorage) MarkUsersDeparted(ctx context.Context, tenantID uuid.UUID, syncedBefore time.Time) (int, error) {
+	f.roundTrip("MarkUsersDeparted")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	departed := 0
+	for _, user := range f.users {
+		if user.TenantID == tenantID && user.DeletedAt == nil && user.SyncedAt.Before(syncedBefore) {
+			deletedAt := syncedBefore
+			user.DeletedAt = &deletedAt
+			departed++
+		}
+	}
+	return departed, nil
+}
+
+func (f *fakeStorage) UpdateTenantCredentials(ctx context.Context, tenantID uuid.UUID, credentials, from, to string) error {
+	f.roundTrip("UpdateTenantCredentials")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tenant, ok := f.tenants[tenantID]
+	if !ok || tenant.Status != from {
+		return fmt.Errorf("%w: tenant %s is no longer %s", domain.ErrInvalidTransition, tenantID, from)
+	}
+	tenant.Credentials, tenant.Status = credentials, to
+	return nil
+}
+
+func (f *fakeStorage) UpdateTenantStatus(ctx context.Context, tenantID uuid.UUID, from, to string) error {
+	f.roundTrip("UpdateTenantStatus")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tenant, ok := f.tenants[tenantID]
+	if !ok || tenant.Status != from {
+		return fmt.Errorf("%w: tenant %s is no longer %s", domain.ErrInvalidTransition, tenantID, from)
+	}
+	tenant.Status = to
+	return nil
+}
+
+func (f *fakeStorage) UpdateTenantVIPRiskThresholds(ctx context.Context, tenantID uuid.UUID, thresholds *domain.RiskThresholds) error {
+	f.roundTrip("UpdateTenantVIPRiskThresholds")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tenant, ok := f.tenants[tenantID]
+	if !ok {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+	tenant.VIPRiskThresholds = thresholds
+	return nil
+}
+
+func (f *fakeStorage) DeleteTenant(ctx context.Context, tenantID uuid.UUID) error {
+	f.roundTrip("DeleteTenant")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tenants[tenantID]; !ok {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+	delete(f.tenants, tenantID)
+	delete(f.dataKeys, tenantID)
+	for key, user := range f.users {
+		if user.TenantID == tenantID {
+			delete(f.users, key)
+		}
+	}
+	for id, email := range f.emails {
+		if email.TenantID == tenantID {
+			delete(f.emails, id)
+		}
+	}
+	subs := f.mailSubscriptions[:0]
+	for _, sub := range f.mailSubscriptions {
+		if sub.TenantID != tenantID {
+			subs = append(subs, sub)
+		}
+	}
+	f.mailSubscriptions = subs
+	return nil
+}
+
+func (f *fakeStorage) GetTenantDataKey(ctx context.Context, tenantID uuid.UUID) (*domain.DataKey, error) {
+	f.roundTrip("GetTenantDataKey")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key, ok := f.dataKeys[tenantID]; ok {
+		copied := *key
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeStorage) CreateTenantDataKey(ctx context.Context, key *domain.DataKey) error {
+	f.roundTrip("CreateTenantDataKey")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.dataKeys[key.TenantID]; !ok {
+		stored := *key
+		f.dataKeys[key.TenantID] = &stored
+	}
+	return nil
+}
+
+func (f *fakeStorage) ListDataKeysToRewrap(ctx context.Context, masterKeyID string, limit int) ([]domain.DataKey, error) {
+	f.roundTrip("ListDataKeysToRewrap")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]domain.DataKey, 0)
+	for _, key := range f.dataKeys {
+		if key.MasterKeyID != masterKeyID && len(keys) < limit {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (f *fakeStorage) RewrapTenantDataKey(ctx context.Context, tenantID uuid.UUID, fromMasterKeyID string, key *domain.DataKey) error {
+	f.roundTrip("RewrapTenantDataKey")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.dataKeys[tenantID]
+	if !ok || stored.MasterKeyID != fromMasterKeyID {
+		return fmt.Errorf("data key of tenant %s not found", tenantID)
+	}
+	rewrapped := *key
+	f.dataKeys[tenantID] = &rewrapped
+	return nil
+}
@@ -2,13 +2,19 @@ package application
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stoik/email-security/internal/domain"
 	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/domain/directory"
+	"github.com/stoik/email-security/internal/domain/roles"
+	"github.com/stoik/email-security/internal/domain/rules"
+	"github.com/stoik/email-security/internal/domain/senderlist"
 	"github.com/stoik/email-security/internal/ports"
 )
 
@@ -21,6 +27,10 @@ type FraudDetectionService struct {
 	// This allows supporting multiple providers (Microsoft, Google) dynamically
 	// based on tenant configuration
 	providers map[domain.Provider]ports.EmailProvider
+
+	// Aggregator registry maps tenant.RiskAggregator to a risk score aggregator
+	// Tenants without a (known) aggregator use the detector's default
+	aggregators map[string]detection.Aggregator
 }
 
 // NewFraudDetectionService creates a new fraud detection service with dependency injection
@@ -28,11 +38,13 @@ func NewFraudDetectionService(
 	storage ports.Storage,
 	detector *detection.Detector,
 	providers map[domain.Provider]ports.EmailProvider,
+	aggregators map[string]detection.Aggregator,
 ) *FraudDetectionService {
 	return &FraudDetectionService{
-		storage:   storage,
-		detector:  detector,
-		providers: providers,
+		storage:     storage,
+		detector:    detector,
+		providers:   providers,
+		aggregators: aggregators,
 	}
 }
 
@@ -40,8 +52,18 @@ func NewFraudDetectionService(
 // Error handling strategy:
 //   - Individual user/email failures are logged but don't halt the pipeline
 //   - This ensures partial success: if 1 of 100 users fails, we still ingest 99
+//   - A user whose mailbox is gone (domain.ErrNotFound) is skipped
+//   - Throttling and open circuits (domain.ErrThrottled, domain.ErrCircuitOpen) stop the
+//     run: the remaining users would fail too, so the caller should retry the tenant later
+//   - Expired credentials (domain.ErrAuthExpired) stop the run until the tenant reauthorizes
 //   - Critical failures return error to caller
+//
+// Tenants that are not active (pending consent, suspended, ...) are skipped.
 func (s *FraudDetectionService) IngestEmailsForTenant(ctx context.Context, tenant *domain.Tenant) error {
+	if !tenant.Active() {
+		log.Printf("Skipping ingestion for tenant %s: %s", tenant.Name, tenant.Status)
+		return nil
+	}
 	log.Printf("Ingesting emails for tenant: %s (%s)", tenant.Name, tenant.Provider)
 
 	provider, ok := s.providers[tenant.Provider]
@@ -49,21 +71,9 @@ func (s *FraudDetectionService) IngestEmailsForTenant(ctx context.Context, tenan
 		return fmt.Errorf("unsupported provider: %s", tenant.Provider)
 	}
 
-	// Fetch users from provider API
-	users, err := provider.GetUsers(ctx, tenant.ID)
+	users, err := s.SyncUsers(ctx, tenant)
 	if err != nil {
-		return fmt.Errorf("failed to fetch users: %w", err)
-	}
-
-	// Store users (upsert pattern: update if exists, insert if new)
-	// This handles scenarios where user metadata changes (role updates, name changes)
-	for i := range users {
-		users[i].TenantID = tenant.ID // Ensure tenant linkage
-		if err := s.storage.CreateUser(ctx, &users[i]); err != nil {
-			log.Printf("Failed to create user %s: %v", users[i].Email, err)
-			continue // Don't fail entire ingestion if one user fails
-		}
-		log.Printf("Stored user: %s", users[i].Email)
+		return err
 	}
 
 	// Fetch emails for each user
@@ -72,8 +82,19 @@ func (s *FraudDetectionService) IngestEmailsForTenant(ctx context.Context, tenan
 	emailCount := 0
 
 	for _, user := range users {
-		emails, err := provider.GetEmails(ctx, user.ID, receivedAfter)
-		if err != nil {
+		if user.Disabled {
+			continue // Disabled accounts are not monitored
+		}
+		emails, err := provider.GetEmails(ctx, tenant.ID, user.ID, receivedAfter)
+		switch {
+		case err == nil:
+		case errors.Is(err, domain.ErrNotFound):
+			log.Printf("Mailbox of user %s not found, skipping", user.Email)
+			continue
+		case errors.Is(err, domain.ErrThrottled), errors.Is(err, domain.ErrCircuitOpen), errors.Is(err, domain.ErrAuthExpired):
+			log.Printf("Ingested %d emails before stopping", emailCount)
+			return fmt.Errorf("failed to fetch emails for user %s: %w", user.Email, err)
+		default:
 			log.Printf("Failed to fetch emails for user %s: %v", user.Email, err)
 			continue // Don't fail entire ingestion if one user's emails fail
 		}
@@ -93,20 +114,28 @@ func (s *FraudDetectionService) IngestEmailsForTenant(ctx context.Context, tenan
 	return nil
 }
 
-// ProcessUnprocessedEmails runs fraud detection on all unprocessed emails
+// ProcessUnprocessedEmails runs fraud detection on a tenant's unprocessed emails
 // Processing guarantees:
-//   - Emails are processed at-least-once (if analysis fails, email stays unprocessed)
+//   - Emails are claimed with a lease, so concurrent workers never analyze the same email
+//   - Emails are processed at-least-once (if analysis fails, email is retried when its lease expires)
 //   - Individual failures don't block batch (logged and skipped)
 //   - High-risk emails trigger console alerts for demo purposes
 func (s *FraudDetectionService) ProcessUnprocessedEmails(ctx context.Context, tenantID uuid.UUID) error {
-	log.Println("Processing unprocessed emails...")
+	log.Printf("Processing unprocessed emails for tenant %s...", tenantID)
 
-	emails, err := s.storage.GetUnprocessedEmails(ctx, 100)
+	owner := newLeaseOwner()
+	emails, err := s.storage.ClaimUnprocessedEmails(ctx, tenantID, owner, 100, defaultLease)
 	if err != nil {
-		return fmt.Errorf("failed to fetch unprocessed emails: %w", err)
+		return fmt.Errorf("failed to claim unprocessed emails: %w", err)
 	}
 
 	log.Printf("Found %d unprocessed emails", len(emails))
+	if len(emails) == 0 {
+		return nil
+	}
+
+	// The tenant detector is resolved once per batch, not once per email
+	detector := s.detectorForTenant(ctx, tenantID)
 
 	for _, email := range emails {
 		// Get recipient user for BEC role-based detection
@@ -118,43 +147,336 @@ func (s *FraudDetectionService) ProcessUnprocessedEmails(ctx context.Context, te
 		}
 
 		// Run fraud detection (pure domain logic, no I/O
-		analysis := s.detector.AnalyzeEmail(email, recipient)
+		analysis := detector.AnalyzeEmail(email, recipient)
 
-		// Store analysis result
-		if err := s.storage.CreateFraudAnalysis(ctx, &analysis); err != nil {
+		// Store analysis result and mark email as processed, atomically
+		stored, err := s.storage.CompleteEmails(ctx, owner, []domain.FraudAnalysis{analysis})
+		if err != nil {
 			log.Printf("Failed to store fraud analysis for email %s: %v", email.ID, err)
-			continue // Don't mark as processed if analysis storage fails
+			continue // Stays unprocessed, retried when the lease expires
 		}
+		if stored == 0 {
+			log.Printf("Lease on email %s expired before its analysis was stored, skipping", email.ID)
+			continue
+		}
+
+		logHighRisk(email, analysis)
+	}
+
+	return nil
+}
+
+// logHighRisk logs high-risk detections (for demo purposes)
+// Customer webhooks (WebhookService), security team alerts (AlertService) and
+// mailbox remediation (RemediationService) are driven by outbox events. In
+// production, this would also:
+//   - Send sms or whatever alerting system to the user
+func logHighRisk(email domain.Email, analysis domain.FraudAnalysis) {
+	if analysis.RiskLevel != "high" && analysis.RiskLevel != "critical" {
+		return
+	}
+	log.Printf("🚨 HIGH RISK EMAIL DETECTED:")
+	log.Printf("  Subject: %s", email.Subject)
+	log.Printf("  From: %s <%s>", email.SenderName, email.SenderEmail)
+	log.Printf("  Risk Score: %.2f (%s, %s)", analysis.RiskScore, analysis.RiskLevel, analysis.Aggregator)
+	if analysis.Override != nil {
+		log.Printf("  Override: %s %s %s (%s), strategies scored %.2f", analysis.Override.Action,
+			analysis.Override.MatchType, analysis.Override.Value, analysis.Override.Reason, analysis.Override.StrategyScore)
+	}
+	log.Printf("  Threats Detected: %d", len(analysis.DetectedThreats))
+	for _, threat := range analysis.DetectedThreats {
+		log.Printf("    - %s (%.0f%% confidence, +%.2f to score): %s",
+			threat.Type, threat.Confidence*100, threat.Contribution, threat.Evidence)
+	}
+	log.Println()
+}
+
+// maxDepartedFraction is the largest share of a tenant's directory one sync may
+// soft-delete; beyond it the provider's answer is more likely partial than a layoff
+const maxDepartedFraction = 0.5
+
+// SyncUsers fetches a tenant's directory from its provider, stores the users with
+// their org chart and soft-deletes the users who left, then returns the stored users
+// Departures are only applied after a complete sync: if a user fails to store, or if
+// too many users would leave at once (directory.ErrTooManyDepartures), nobody is
+// deprovisioned and the next sync tries again.
+func (s *FraudDetectionService) SyncUsers(ctx context.Context, tenant *domain.Tenant) ([]domain.User, error) {
+	provider, ok := s.providers[tenant.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", tenant.Provider)
+	}
+
+	syncedAt := time.Now().UTC()
 
-		// Mark email as processed (only after successful analysis storage)
-		if err := s.storage.MarkEmailProcessed(ctx, email.ID); err != nil {
-			log.Printf("Failed to mark email as processed %s: %v", email.ID, err)
+	// Fetch users from provider API
+	users, err := provider.GetUsers(ctx, tenant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+	existing, err := s.storage.ListUsers(ctx, tenant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stored users: %w", err)
+	}
+
+	// Store users (upsert pattern: update if exists, insert if new)
+	// This handles scenarios where user metadata changes (title, department, groups)
+	stored := make([]domain.User, 0, len(users))
+	for i := range users {
+		users[i].TenantID = tenant.ID // Ensure tenant linkage
+		users[i].SyncedAt = syncedAt
+		directory.Normalize(&users[i])
+		if err := s.storage.CreateUser(ctx, &users[i]); err != nil {
+			log.Printf("Failed to create user %s: %v", users[i].Email, err)
+			continue // Don't fail entire ingestion if one user fails
 		}
+		stored = append(stored, users[i])
+	}
+	log.Printf("Stored %d of %d users for tenant %s", len(stored), len(users), tenant.Name)
 
-		// Log high-risk detections (for demo purposes)
-		// In production, this would:
-		//   - Send webhook to security
-		//   - Send Slack alert to security team
-		// 	 - Send email/sms or whatever alerting system to the user
-		//   - Quarantine email via provider API
-		if analysis.RiskLevel == "high" || analysis.RiskLevel == "critical" {
-			log.Printf("🚨 HIGH RISK EMAIL DETECTED:")
-			log.Printf("  Subject: %s", email.Subject)
-			log.Printf("  From: %s <%s>", email.SenderName, email.SenderEmail)
-			log.Printf("  Risk Score: %.2f (%s)", analysis.RiskScore, analysis.RiskLevel)
-			log.Printf("  Threats Detected: %d", len(analysis.DetectedThreats))
-			for _, threat := range analysis.DetectedThreats {
-				log.Printf("    - %s (%.0f%% confidence): %s",
-					threat.Type, threat.Confidence*100, threat.Evidence)
-			}
-			log.Println()
+	// Managers are linked once every user is stored: a manager may be listed after its reports
+	if dropped := directory.Link(stored); dropped > 0 {
+		log.Printf("Dropped %d unresolved or looping manager links for tenant %s", dropped, tenant.Name)
+	}
+	managers := make(map[uuid.UUID]*uuid.UUID, len(stored))
+	for _, user := range stored {
+		managers[user.ID] = user.ManagerID
+	}
+	if err := s.storage.UpdateUserManagers(ctx, tenant.ID, managers); err != nil {
+		log.Printf("Failed to update org chart for tenant %s: %v", tenant.Name, err)
+	}
+
+	if len(stored) < len(users) {
+		log.Printf("Skipping deprovisioning for tenant %s: incomplete sync", tenant.Name)
+		return stored, nil
+	}
+	departed := directory.Departed(existing, stored)
+	if err := directory.CheckDepartures(len(existing), len(departed), maxDepartedFraction); err != nil {
+		log.Printf("Skipping deprovisioning for tenant %s: %v", tenant.Name, err)
+		return stored, nil
+	}
+	if len(departed) > 0 {
+		count, err := s.storage.MarkUsersDeparted(ctx, tenant.ID, syncedAt)
+		if err != nil {
+			log.Printf("Failed to deprovision departed users of tenant %s: %v", tenant.Name, err)
+		} else {
+			log.Printf("Deprovisioned %d departed users of tenant %s", count, tenant.Name)
+		}
+	}
+	return stored, nil
+}
+
+// detectorForTenant returns the detector configured with the tenant's policy: custom
+// rules, role overrides, VIPs, sender list and risk aggregator (see tenantPolicy)
+func (s *FraudDetectionService) detectorForTenant(ctx context.Context, tenantID uuid.UUID) *detection.Detector {
+	return s.tenantPolicy(ctx, tenantID).applyLive(s.detector)
+}
+
+// SetRoleOverride validates and stores a tenant's override of the role classification
+// of a title or user, replacing any previous override of the same target
+func (s *FraudDetectionService) SetRoleOverride(ctx context.Context, override *domain.RoleOverride) error {
+	if err := roles.ValidateOverride(*override); err != nil {
+		return err
+	}
+
+	// Stored in the form the classifier matches, so equivalent spellings share an override
+	override.Title = roles.Normalize(override.Title)
+	override.Email = strings.ToLower(strings.TrimSpace(override.Email))
+	override.ID = uuid.New()
+	override.CreatedAt = time.Now()
+	if err := s.storage.UpsertRoleOverride(ctx, override); err != nil {
+		return fmt.Errorf("failed to store role override: %w", err)
+	}
+	return nil
+}
+
+// DeleteRoleOverride removes a tenant's role override
+func (s *FraudDetectionService) DeleteRoleOverride(ctx context.Context, tenantID, overrideID uuid.UUID) error {
+	if err := s.storage.DeleteRoleOverride(ctx, tenantID, overrideID); err != nil {
+		return fmt.Errorf("failed to delete role override: %w", err)
+	}
+	return nil
+}
+
+// ListRoleOverrides returns a tenant's role overrides
+func (s *FraudDetectionService) ListRoleOverrides(ctx context.Context, tenantID uuid.UUID) ([]domain.RoleOverride, error) {
+	return s.storage.ListRoleOverrides(ctx, tenantID)
+}
+
+// SetVIP validates and stores a VIP, replacing any previous entry for the same mailbox
+func (s *FraudDetectionService) SetVIP(ctx context.Context, vip *domain.VIP) error {
+	vip.Email = strings.ToLower(strings.TrimSpace(vip.Email))
+	vip.Name = strings.TrimSpace(vip.Name)
+	if !detection.ValidateEmail(vip.Email) {
+		return fmt.Errorf("invalid VIP email %q", vip.Email)
+	}
+	// Names are matched whole: a single word would flag every namesake
+	for _, name := range vip.Names() {
+		if len(strings.Fields(name)) < 2 {
+			return fmt.Errorf("VIP name %q must have at least two words", name)
 		}
 	}
 
+	vip.ID = uuid.New()
+	vip.CreatedAt = time.Now()
+	if err := s.storage.UpsertVIP(ctx, vip); err != nil {
+		return fmt.Errorf("failed to store VIP: %w", err)
+	}
+	return nil
+}
+
+// DeleteVIP removes a VIP from a tenant's list
+func (s *FraudDetectionService) DeleteVIP(ctx context.Context, tenantID, vipID uuid.UUID) error {
+	if err := s.storage.DeleteVIP(ctx, tenantID, vipID); err != nil {
+		return fmt.Errorf("failed to delete VIP: %w", err)
+	}
 	return nil
 }
 
+// ListVIPs returns a tenant's VIPs
+func (s *FraudDetectionService) ListVIPs(ctx context.Context, tenantID uuid.UUID) ([]domain.VIP, error) {
+	return s.storage.ListVIPs(ctx, tenantID)
+}
+
+// SetVIPRiskThresholds sets the risk thresholds of mail to a tenant's VIPs (nil restores
+// the defaults); they may only be stricter than the defaults
+func (s *FraudDetectionService) SetVIPRiskThresholds(ctx context.Context, tenantID uuid.UUID, thresholds *domain.RiskThresholds) error {
+	if thresholds != nil {
+		if err := thresholds.Validate(); err != nil {
+			return err
+		}
+		if !thresholds.StricterThan(domain.DefaultRiskThresholds) {
+			return fmt.Errorf("VIP risk thresholds must not be above the defaults %+v", domain.DefaultRiskThresholds)
+		}
+	}
+	if err := s.storage.UpdateTenantVIPRiskThresholds(ctx, tenantID, thresholds); err != nil {
+		return fmt.Errorf("failed to update VIP risk thresholds: %w", err)
+	}
+	return nil
+}
+
+// CreateSenderListEntry validates and stores a tenant's allow or block entry
+// entry.CreatedBy is recorded in the audit trail as the actor.
+func (s *FraudDetectionService) CreateSenderListEntry(ctx context.Context, entry *domain.SenderListEntry) error {
+	senderlist.Normalize(entry)
+	if err := senderlist.Validate(*entry); err != nil {
+		return err
+	}
+	if entry.CreatedBy == "" {
+		return fmt.Errorf("sender list entry needs an actor (created_by) for the audit trail")
+	}
+	now := time.Now()
+	if !entry.Active(now) {
+		return fmt.Errorf("sender list entry expires in the past (%s)", entry.ExpiresAt.Format(time.RFC3339))
+	}
+
+	entry.ID = uuid.New()
+	entry.CreatedAt = now
+	if err := s.storage.CreateSenderListEntry(ctx, entry); err != nil {
+		return fmt.Errorf("failed to store sender list entry: %w", err)
+	}
+	log.Printf("Sender list: %s %s %s for tenant %s by %s (%s)", entry.Action, entry.MatchType, entry.Value, entry.TenantID, entry.CreatedBy, entry.Reason)
+	return nil
+}
+
+// DeleteSenderListEntry removes a tenant's allow or block entry, recording the actor
+func (s *FraudDetectionService) DeleteSenderListEntry(ctx context.Context, tenantID, entryID uuid.UUID, actor string) error {
+	if actor == "" {
+		return fmt.Errorf("sender list changes need an actor for the audit trail")
+	}
+	if err := s.storage.DeleteSenderListEntry(ctx, tenantID, entryID, actor); err != nil {
+		return fmt.Errorf("failed to delete sender list entry: %w", err)
+	}
+	return nil
+}
+
+// ListSenderListEntries returns a tenant's allow and block entries, expired ones included
+func (s *FraudDetectionService) ListSenderListEntries(ctx context.Context, tenantID uuid.UUID) ([]domain.SenderListEntry, error) {
+	return s.storage.ListSenderListEntries(ctx, tenantID)
+}
+
+// ListSenderListChanges returns the audit trail of a tenant's sender list, newest first
+func (s *FraudDetectionService) ListSenderListChanges(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.SenderListChange, error) {
+	return s.storage.ListSenderListChanges(ctx, tenantID, limit)
+}
+
 // GetHighRiskSummary retrieves high-risk emails for a tenant
 func (s *FraudDetectionService) GetHighRiskSummary(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.FraudAnalysis, error) {
 	return s.storage.GetHighRiskEmails(ctx, tenantID, limit)
 }
+
+// ExplainEmail returns the analysis of an email with a per-strategy trace
+//
+// The stored trace is returned when the email was analyzed in trace mode. Otherwise
+// the email is re-analyzed with tracing on (nothing is persisted), which answers
+// "why wasn't this flagged?" with the current detector configuration; that analysis
+// is marked Recomputed.
+func (s *FraudDetectionService) ExplainEmail(ctx context.Context, emailID uuid.UUID) (*domain.FraudAnalysis, error) {
+	analysis, err := s.storage.GetFraudAnalysisByEmail(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch analysis: %w", err)
+	}
+	if analysis != nil && analysis.Trace != nil {
+		return analysis, nil
+	}
+
+	email, err := s.storage.GetEmail(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch email: %w", err)
+	}
+	if email == nil {
+		return nil, fmt.Errorf("email %s not found", emailID)
+	}
+
+	recipient, err := s.storage.GetUserByEmail(ctx, email.TenantID, email.RecipientEmail)
+	if err != nil {
+		log.Printf("Failed to fetch recipient user for email %s: %v", email.ID, err)
+	}
+
+	traced := s.detectorForTenant(ctx, email.TenantID).Tracing(true).AnalyzeEmail(*email, recipient)
+	traced.Recomputed = true
+	return &traced, nil
+}
+
+// CreateDetectionRule validates and stores a custom detection rule for a tenant
+// Compile errors are returned with the line and column of the problem.
+func (s *FraudDetectionService) CreateDetectionRule(ctx context.Context, rule *domain.DetectionRule) error {
+	if _, err := rules.CompileRule(*rule); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rule.ID = uuid.New()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if err := s.storage.CreateDetectionRule(ctx, rule); err != nil {
+		return fmt.Errorf("failed to store detection rule: %w", err)
+	}
+	return nil
+}
+
+// UpdateDetectionRule validates and replaces a tenant's custom detection rule
+// Changes apply from the next processing batch.
+func (s *FraudDetectionService) UpdateDetectionRule(ctx context.Context, rule *domain.DetectionRule) error {
+	if _, err := rules.CompileRule(*rule); err != nil {
+		return err
+	}
+
+	rule.UpdatedAt = time.Now()
+	if err := s.storage.UpdateDetectionRule(ctx, rule); err != nil {
+		return fmt.Errorf("failed to update detection rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteDetectionRule removes a tenant's custom detection rule
+func (s *FraudDetectionService) DeleteDetectionRule(ctx context.Context, tenantID, ruleID uuid.UUID) error {
+	if err := s.storage.DeleteDetectionRule(ctx, tenantID, ruleID); err != nil {
+		return fmt.Errorf("failed to delete detection rule: %w", err)
+	}
+	return nil
+}
+
+// ListDetectionRules returns a tenant's custom detection rules
+func (s *FraudDetectionService) ListDetectionRules(ctx context.Context, tenantID uuid.UUID) ([]domain.DetectionRule, error) {
+	return s.storage.ListDetectionRules(ctx, tenantID)
+}
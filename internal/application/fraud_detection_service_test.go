@@ -0,0 +1,378 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// scriptedProvider is a ports.EmailProvider failing the users of errs with their error
+type scriptedProvider struct {
+	users   []domain.User
+	errs    map[string]error // keyed by user email
+	fetched []string
+}
+
+func (p *scriptedProvider) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	return append([]domain.User(nil), p.users...), nil
+}
+
+func (p *scriptedProvider) GetEmails(ctx context.Context, tenantID, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+	for _, user := range p.users {
+		if user.ID != userID {
+			continue
+		}
+		p.fetched = append(p.fetched, user.Email)
+		if err := p.errs[user.Email]; err != nil {
+			return nil, err
+		}
+		return []domain.Email{{ID: uuid.New(), ProviderMessageID: "msg-" + user.Email, RecipientEmail: user.Email}}, nil
+	}
+	return nil, domain.ErrNotFound
+}
+
+func TestFraudDetectionService_IngestEmailsForTenant(t *testing.T) {
+	emails := []string{"ceo@company.com", "cfo@company.com", "hr@company.com"}
+	tests := []struct {
+		name        string
+		errs        map[string]error
+		wantErr     error
+		wantFetched []string
+		wantStored  int
+	}{
+		{
+			name:        "all users",
+			wantFetched: emails,
+			wantStored:  3,
+		},
+		{
+			name:        "missing mailbox is skipped",
+			errs:        map[string]error{"cfo@company.com": domain.ErrNotFound},
+			wantFetched: emails,
+			wantStored:  2,
+		},
+		{
+			name:        "other failures are skipped",
+			errs:        map[string]error{"cfo@company.com": errors.New("bad request")},
+			wantFetched: emails,
+			wantStored:  2,
+		},
+		{
+			name:        "throttling stops the run",
+			errs:        map[string]error{"cfo@company.com": &domain.ThrottledError{RetryAfter: time.Hour}},
+			wantErr:     domain.ErrThrottled,
+			wantFetched: emails[:2],
+			wantStored:  1,
+		},
+		{
+			name:        "open circuit stops the run",
+			errs:        map[string]error{"ceo@company.com": domain.ErrCircuitOpen},
+			wantErr:     domain.ErrCircuitOpen,
+			wantFetched: emails[:1],
+		},
+		{
+			name:        "expired credentials stop the run",
+			errs:        map[string]error{"ceo@company.com": domain.ErrAuthExpired},
+			wantErr:     domain.ErrAuthExpired,
+			wantFetched: emails[:1],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStorage()
+			tenant := &domain.Tenant{ID: uuid.New(), Name: "Acme", Provider: domain.ProviderMicrosoft, Status: domain.TenantActive}
+			provider := &scriptedProvider{errs: tt.errs}
+			for _, email := range emails {
+				provider.users = append(provider.users, domain.User{ID: uuid.New(), Email: email})
+			}
+			service := NewFraudDetectionService(store, nil, map[domain.Provider]ports.EmailProvider{
+				domain.ProviderMicrosoft: provider,
+			}, nil)
+
+			err := service.IngestEmailsForTenant(context.Background(), tenant)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantFetched, provider.fetched)
+			assert.Len(t, store.emails, tt.wantStored)
+			for _, email := range store.emails {
+				assert.Equal(t, tenant.ID, email.TenantID)
+			}
+		})
+	}
+}
+
+func TestFraudDetectionService_SyncUsers(t *testing.T) {
+	// directory is the provider's answer: users get new IDs on every fetch, as with
+	// the real providers, and are matched by provider user ID
+	all := []domain.User{
+		{ProviderUserID: "ceo", Email: "ceo@company.com", Groups: []string{"Executives"}},
+		{ProviderUserID: "cfo", Email: "CFO@company.com", Groups: []string{"finance", "Executives", "Finance"}, ManagerRef: "ceo"},
+		{ProviderUserID: "accountant", Email: "accountant@company.com", Department: " Finance ", ManagerRef: "cfo@company.com"},
+		{ProviderUserID: "hr", Email: "hr@company.com", ManagerRef: "ceo"},
+	}
+	directory := func(ids ...string) []domain.User {
+		users := make([]domain.User, 0, len(ids))
+		for _, user := range all {
+			for _, id := range ids {
+				if user.ProviderUserID == id {
+					user.ID = uuid.New()
+					users = append(users, user)
+				}
+			}
+		}
+		return users
+	}
+
+	ctx := context.Background()
+	store := newFakeStorage()
+	tenant := &domain.Tenant{ID: uuid.New(), Name: "Acme", Provider: domain.ProviderMicrosoft, Status: domain.TenantActive}
+	provider := &scriptedProvider{users: directory("ceo", "cfo", "accountant", "hr")}
+	service := NewFraudDetectionService(store, nil, map[domain.Provider]ports.EmailProvider{
+		domain.ProviderMicrosoft: provider,
+	}, nil)
+
+	users, err := service.SyncUsers(ctx, tenant)
+	require.NoError(t, err)
+	assert.Len(t, users, 4)
+
+	accountant, err := store.GetUserByEmail(ctx, tenant.ID, "accountant@company.com")
+	require.NoError(t, err)
+	require.NotNil(t, accountant)
+	assert.Equal(t, "Finance", accountant.Department)
+	require.Len(t, accountant.Managers, 2)
+	assert.Equal(t, "cfo@company.com", accountant.Managers[0].Email)
+	assert.Equal(t, "ceo@company.com", accountant.Managers[1].Email)
+	cfo, err := store.GetUserByEmail(ctx, tenant.ID, "cfo@company.com")
+	require.NoError(t, err)
+	require.NotNil(t, cfo)
+	assert.Equal(t, []string{"Executives", "finance"}, cfo.Groups)
+	assert.True(t, cfo.InGroup("Finance"))
+
+	// hr left: soft-deleted, IDs of the others are kept
+	provider.users = directory("ceo", "cfo", "accountant")
+	_, err = service.SyncUsers(ctx, tenant)
+	require.NoError(t, err)
+	listed, err := store.ListUsers(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+	hr, err := store.GetUserByEmail(ctx, tenant.ID, "hr@company.com")
+	require.NoError(t, err)
+	require.NotNil(t, hr)
+	assert.True(t, hr.Departed())
+	again, err := store.GetUserByEmail(ctx, tenant.ID, "accountant@company.com")
+	require.NoError(t, err)
+	assert.Equal(t, accountant.ID, again.ID)
+	assert.Len(t, again.Managers, 2)
+
+	// A directory missing most users is more likely a partial read: nobody leaves
+	provider.users = directory("ceo")
+	_, err = service.SyncUsers(ctx, tenant)
+	require.NoError(t, err)
+	listed, err = store.ListUsers(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+
+	// hr came back
+	provider.users = directory("ceo", "cfo", "accountant", "hr")
+	_, err = service.SyncUsers(ctx, tenant)
+	require.NoError(t, err)
+	listed, err = store.ListUsers(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Len(t, listed, 4)
+}
+
+func TestFraudDetectionService_SetRoleOverride(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	tenantID := uuid.New()
+	service := newTestService(store)
+
+	recipient := &domain.User{TenantID: tenantID, Email: "ops@company.com", Role: "Head of Cash Ops"}
+	email := domain.Email{
+		TenantID:       tenantID,
+		SenderEmail:    "billing@vendor-payments.com",
+		RecipientEmail: recipient.Email,
+		Subject:        "Updated bank details for wire transfer",
+		BodyPreview:    "Please process the payment to our new account.",
+	}
+	hasDetection := func(analysis domain.FraudAnalysis, detectionType string) bool {
+		for _, detection := range analysis.DetectedThreats {
+			if detection.Type == detectionType {
+				return true
+			}
+		}
+		return false
+	}
+
+	// "Head of Cash Ops" names no taxonomy function until the tenant says so
+	assert.False(t, hasDetection(service.detectorForTenant(ctx, tenantID).AnalyzeEmail(email, recipient), "BEC_FINANCE_TARGETING"))
+
+	override := &domain.RoleOverride{TenantID: tenantID, Title: "Head of Cash-Ops", Functions: []string{"finance"}}
+	require.NoError(t, service.SetRoleOverride(ctx, override))
+	assert.Equal(t, "head of cash ops", override.Title)
+	assert.True(t, hasDetection(service.detectorForTenant(ctx, tenantID).AnalyzeEmail(email, recipient), "BEC_FINANCE_TARGETING"))
+
+	// Overriding the same title again replaces the override
+	require.NoError(t, service.SetRoleOverride(ctx, &domain.RoleOverride{TenantID: tenantID, Title: "head of cash ops", Seniority: "director"}))
+	overrides, err := service.ListRoleOverrides(ctx, tenantID)
+	require.NoError(t, err)
+	require.Len(t, overrides, 1)
+	assert.Equal(t, "director", overrides[0].Seniority)
+	assert.Empty(t, overrides[0].Functions)
+
+	assert.ErrorContains(t, service.SetRoleOverride(ctx, &domain.RoleOverride{TenantID: tenantID, Email: "ceo@company.com"}), "must set")
+	assert.ErrorContains(t, service.SetRoleOverride(ctx, &domain.RoleOverride{TenantID: tenantID, Title: "Sales", Functions: []string{"sales"}}), "unknown function")
+
+	require.NoError(t, service.DeleteRoleOverride(ctx, tenantID, overrides[0].ID))
+	overrides, err = service.ListRoleOverrides(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+	assert.Error(t, service.DeleteRoleOverride(ctx, tenantID, uuid.New()))
+}
+
+func TestFraudDetectionService_SetVIP(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	tenant := &domain.Tenant{ID: uuid.New(), Name: "Acme", Provider: domain.ProviderMicrosoft, Status: domain.TenantActive}
+	require.NoError(t, store.CreateTenant(ctx, tenant))
+	service := newTestService(store)
+
+	assert.ErrorContains(t, service.SetVIP(ctx, &domain.VIP{TenantID: tenant.ID, Email: "not-an-address", Name: "John Smith"}), "invalid VIP email")
+	assert.ErrorContains(t, service.SetVIP(ctx, &domain.VIP{TenantID: tenant.ID, Email: "john@company.com", Name: "John"}), "two words")
+
+	vip := &domain.VIP{TenantID: tenant.ID, Email: " John.Smith@Company.com", Name: "John Smith", Reason: "Signs wire transfers"}
+	require.NoError(t, service.SetVIP(ctx, vip))
+	assert.Equal(t, "john.smith@company.com", vip.Email)
+	require.NoError(t, service.SetVIP(ctx, &domain.VIP{TenantID: tenant.ID, Email: "john.smith@company.com", Name: "John Smith", Aliases: []string{"Johnny Smith"}}))
+	vips, err := service.ListVIPs(ctx, tenant.ID)
+	require.NoError(t, err)
+	require.Len(t, vips, 1)
+	assert.Equal(t, vip.ID, vips[0].ID)
+	assert.Equal(t, []string{"Johnny Smith"}, vips[0].Aliases)
+
+	assert.ErrorContains(t, service.SetVIPRiskThresholds(ctx, tenant.ID, &domain.RiskThresholds{Critical: 0.95, High: 0.80, Medium: 0.60, Low: 0.40}), "above the defaults")
+	assert.ErrorContains(t, service.SetVIPRiskThresholds(ctx, tenant.ID, &domain.RiskThresholds{Critical: 0.50, High: 0.60, Medium: 0.30, Low: 0.10}), "low < medium")
+	thresholds := &domain.RiskThresholds{Critical: 0.70, High: 0.50, Medium: 0.30, Low: 0.10}
+	require.NoError(t, service.SetVIPRiskThresholds(ctx, tenant.ID, thresholds))
+
+	// External mail using the VIP's alias, to the VIP's assistant, and an urgent
+	// payment request to the VIP graded with the stricter thresholds
+	detector := service.detectorForTenant(ctx, tenant.ID)
+	impersonation := detector.AnalyzeEmail(domain.Email{
+		SenderName:  "Johnny Smith",
+		SenderEmail: "johnny.smith@gmail.com",
+		Subject:     "Are you at your desk?",
+	}, &domain.User{Email: "assistant@company.com"})
+	require.NotEmpty(t, impersonation.DetectedThreats)
+	assert.Equal(t, "VIP_IMPERSONATION", impersonation.DetectedThreats[0].Type)
+
+	payment := domain.Email{SenderEmail: "partner@vendor.com", Subject: "Urgent payment", BodyPreview: "Please process the payment today"}
+	toVIP := detector.AnalyzeEmail(payment, &domain.User{Email: "john.smith@company.com"})
+	assert.True(t, toVIP.VIPRecipient)
+	assert.Equal(t, "critical", toVIP.RiskLevel)
+	assert.Equal(t, "high", detector.AnalyzeEmail(payment, &domain.User{Email: "dev@company.com"}).RiskLevel)
+
+	require.NoError(t, service.DeleteVIP(ctx, tenant.ID, vip.ID))
+	vips, err = service.ListVIPs(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Empty(t, vips)
+	assert.False(t, service.detectorForTenant(ctx, tenant.ID).AnalyzeEmail(payment, &domain.User{Email: "john.smith@company.com"}).VIPRecipient)
+}
+
+func TestFraudDetectionService_SenderList(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	tenantID := uuid.New()
+	service := newTestService(store)
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	invalid := []struct {
+		entry   domain.SenderListEntry
+		wantErr string
+	}{
+		{domain.SenderListEntry{TenantID: tenantID, Action: domain.SenderBlock, MatchType: domain.SenderMatchDomain, Value: "evil.com", Reason: "Phishing"}, "actor"},
+		{domain.SenderListEntry{TenantID: tenantID, Action: domain.SenderBlock, MatchType: domain.SenderMatchDomain, Value: "evil.com", CreatedBy: "soc@company.com"}, "reason"},
+		{domain.SenderListEntry{TenantID: tenantID, Action: domain.SenderBlock, MatchType: domain.SenderMatchIPCIDR, Value: "10.0.0.0/33", Reason: "Botnet", CreatedBy: "soc@company.com"}, "invalid IP network"},
+		{domain.SenderListEntry{TenantID: tenantID, Action: domain.SenderBlock, MatchType: domain.SenderMatchDomain, Value: "evil.com", Reason: "Phishing", CreatedBy: "soc@company.com", ExpiresAt: &yesterday}, "expires in the past"},
+	}
+	for _, tt := range invalid {
+		entry := tt.entry
+		assert.ErrorContains(t, service.CreateSenderListEntry(ctx, &entry), tt.wantErr)
+	}
+
+	block := &domain.SenderListEntry{TenantID: tenantID, Action: domain.SenderBlock, MatchType: domain.SenderMatchDomain, Value: "@Evil.com", Reason: "Phishing campaign", CreatedBy: "soc@company.com"}
+	require.NoError(t, service.CreateSenderListEntry(ctx, block))
+	assert.Equal(t, "evil.com", block.Value)
+	allow := &domain.SenderListEntry{TenantID: tenantID, Action: domain.SenderAllow, MatchType: domain.SenderMatchRegistrableDomain, Value: "mail.partner.co.uk", Reason: "Replies to a shared mailbox", CreatedBy: "soc@company.com"}
+	require.NoError(t, service.CreateSenderListEntry(ctx, allow))
+	assert.Equal(t, "partner.co.uk", allow.Value)
+
+	detector := service.detectorForTenant(ctx, tenantID)
+	blocked := detector.AnalyzeEmail(domain.Email{SenderEmail: "ceo@evil.com", Subject: "Hello"}, nil)
+	require.NotNil(t, blocked.Override)
+	assert.Equal(t, block.ID, blocked.Override.EntryID)
+	assert.Equal(t, "critical", blocked.RiskLevel)
+	allowed := detector.AnalyzeEmail(domain.Email{
+		SenderEmail: "billing@invoices.partner.co.uk",
+		Headers: map[string]string{
+			"Reply-To":               "partner.billing@gmail.com",
+			"Authentication-Results": "mx.company.com; dmarc=pass header.from=invoices.partner.co.uk",
+		},
+	}, nil)
+	require.NotNil(t, allowed.Override)
+	assert.Equal(t, "none", allowed.RiskLevel)
+
+	require.NoError(t, service.DeleteSenderListEntry(ctx, tenantID, block.ID, "admin@company.com"))
+	assert.Error(t, service.DeleteSenderListEntry(ctx, tenantID, block.ID, "admin@company.com"))
+	assert.ErrorContains(t, service.DeleteSenderListEntry(ctx, tenantID, allow.ID, ""), "actor")
+	entries, err := service.ListSenderListEntries(ctx, tenantID)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, allow.ID, entries[0].ID)
+	assert.Nil(t, service.detectorForTenant(ctx, tenantID).AnalyzeEmail(domain.Email{SenderEmail: "ceo@evil.com"}, nil).Override)
+
+	// The audit trail keeps the deleted entry and who deleted it
+	changes, err := service.ListSenderListChanges(ctx, tenantID, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, domain.SenderListDeleted, changes[0].Operation)
+	assert.Equal(t, "admin@company.com", changes[0].Actor)
+	assert.Equal(t, "evil.com", changes[0].Entry.Value)
+	assert.Equal(t, domain.SenderListCreated, changes[2].Operation)
+	assert.Equal(t, "soc@company.com", changes[2].Actor)
+}
+
+func TestFraudDetectionService_ExplainEmail(t *testing.T) {
+	ctx := context.Background()
+	for _, traced := range []bool{false, true} {
+		store := newFakeStorage()
+		seedTenant(store, uuid.New(), 1)
+		detector := detection.NewDetector([]string{"company.com"}, []string{}).Tracing(traced)
+		service := NewFraudDetectionService(store, detector, nil, nil)
+		_, err := service.ProcessConcurrently(ctx, PoolConfig{Workers: 1, BatchSize: 10})
+		require.NoError(t, err)
+
+		var emailID uuid.UUID
+		for id := range store.emails {
+			emailID = id
+		}
+		explained, err := service.ExplainEmail(ctx, emailID)
+		require.NoError(t, err)
+		assert.NotNil(t, explained.Trace)
+		assert.Equal(t, !traced, explained.Recomputed, "only an analysis stored without a trace is recomputed")
+	}
+}
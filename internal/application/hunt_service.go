@@ -0,0 +1,126 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/hunt"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// defaultHuntPageSize is the number of candidate emails read from storage at a time
+const defaultHuntPageSize = 500
+
+// HuntService searches stored emails for a reported campaign, in one tenant or all
+//
+// Matches are streamed page by page, newest first, so exporting thousands of emails
+// never holds them in memory. Storage selects each page's candidates with its
+// indexes; every candidate is confirmed with hunt.Matches before it is streamed.
+type HuntService struct {
+	storage  ports.Storage
+	pageSize int
+}
+
+// NewHuntService creates a hunt service
+func NewHuntService(storage ports.Storage) *HuntService {
+	return &HuntService{storage: storage, pageSize: defaultHuntPageSize}
+}
+
+// Hunt calls fn with each email matching the query, newest first, up to the query's
+// limit, and reports how far the campaign spread
+// An error from fn (e.g. a client gone mid-export) stops the hunt and is returned.
+func (s *HuntService) Hunt(ctx context.Context, q domain.HuntQuery, fn func(domain.HuntMatch) error) (report domain.HuntReport, err error) {
+	hunt.Normalize(&q)
+	if err := hunt.Validate(q); err != nil {
+		return report, err
+	}
+
+	start := time.Now()
+	defer func() { report.Elapsed = time.Since(start) }()
+
+	scope := "all tenants"
+	if q.TenantID != uuid.Nil {
+		scope = "tenant " + q.TenantID.String()
+	}
+	log.Printf("Hunting emails in %s: %s", scope, describeHunt(q))
+
+	tenants := make(map[uuid.UUID]bool)
+	mailboxes := make(map[string]bool) // Keyed by tenant ID and address: tenants may share addresses
+	var cursor domain.EmailCursor
+	for {
+		// One more match than the limit tells whether the results are truncated
+		pageSize := min(s.pageSize, q.Limit-report.Matches+1)
+		candidates, err := s.storage.HuntEmails(ctx, q, cursor, pageSize)
+		if err != nil {
+			return report, fmt.Errorf("failed to search emails: %w", err)
+		}
+
+		for _, match := range candidates {
+			if !hunt.Matches(q, match.Email) {
+				continue
+			}
+			if report.Matches == q.Limit {
+				report.Truncated = true
+				return report, nil
+			}
+			if err := fn(match); err != nil {
+				return report, err
+			}
+			report.Matches++
+			tenants[match.Email.TenantID] = true
+			mailboxes[match.Email.TenantID.String()+"/"+strings.ToLower(match.Email.RecipientEmail)] = true
+			report.Tenants = len(tenants)
+			report.Mailboxes = len(mailboxes)
+			if domain.RiskLevelAtLeast(match.RiskLevel, domain.ThreatEventMinLevel) {
+				report.Flagged++
+			}
+		}
+
+		if len(candidates) < pageSize {
+			break
+		}
+		last := candidates[len(candidates)-1].Email
+		cursor = domain.EmailCursor{ReceivedAt: last.ReceivedAt, ID: last.ID}
+	}
+
+	log.Printf("Hunt matched %d emails in %d mailboxes of %d tenants (%d flagged)",
+		report.Matches, report.Mailboxes, report.Tenants, report.Flagged)
+	return report, nil
+}
+
+// describeHunt lists a query's criteria for the audit log
+func describeHunt(q domain.HuntQuery) string {
+	var criteria []string
+	add := func(name, value string) {
+		if value != "" {
+			criteria = append(criteria, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+	add("sender", q.Sender)
+	add("sender_domain", q.SenderDomain)
+	add("lookalike_of", q.LookalikeOf)
+	add("text", q.Text)
+	add("attachment_name", q.AttachmentName)
+	add("attachment_hash", q.AttachmentHash)
+	names := make([]string, 0, len(q.Headers))
+	for name := range q.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add("header "+name, q.Headers[name])
+	}
+	if !q.From.IsZero() {
+		add("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		add("to", q.To.Format(time.RFC3339))
+	}
+	return strings.Join(criteria, " ")
+}
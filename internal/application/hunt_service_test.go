@@ -0,0 +1,147 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+const campaignHash = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+// seedCampaign adds a tenant with n emails of a reported campaign among its regular mail
+func seedCampaign(store *fakeStorage, name string, n int) uuid.UUID {
+	tenantID := uuid.New()
+	store.tenants[tenantID] = &domain.Tenant{ID: tenantID, Name: name}
+	seedTenant(store, tenantID, 3)
+	for i := 0; i < n; i++ {
+		store.addEmail(domain.Email{
+			TenantID:         tenantID,
+			Subject:          "Your account is suspended",
+			SenderEmail:      "security@paypa1-support.com",
+			RecipientEmail:   []string{"alice@company.com", "bob@company.com"}[i%2],
+			AttachmentNames:  []string{"Restore_Access.html"},
+			AttachmentHashes: []string{campaignHash},
+			Headers:          map[string]string{"X-Mailer": "PHPMailer 6.1"},
+		})
+	}
+	return tenantID
+}
+
+func collect(matches *[]domain.HuntMatch) func(domain.HuntMatch) error {
+	return func(match domain.HuntMatch) error {
+		*matches = append(*matches, match)
+		return nil
+	}
+}
+
+func TestHuntService_Hunt(t *testing.T) {
+	store := newFakeStorage()
+	acme := seedCampaign(store, "Acme", 4)
+	seedCampaign(store, "Globex", 3)
+	seedCampaign(store, "Initech", 0)
+	service := NewHuntService(store)
+	service.pageSize = 4
+
+	tests := []struct {
+		name      string
+		query     domain.HuntQuery
+		matches   int
+		tenants   int
+		mailboxes int
+	}{
+		{"lookalike across tenants", domain.HuntQuery{LookalikeOf: "PayPal.com"}, 7, 2, 4},
+		{"attachment hash", domain.HuntQuery{AttachmentHash: campaignHash}, 7, 2, 4},
+		{"attachment name", domain.HuntQuery{AttachmentName: "restore_access"}, 7, 2, 4},
+		{"header value", domain.HuntQuery{Headers: map[string]string{"X-Mailer": "PHPMailer 6.1"}}, 7, 2, 4},
+		{"one tenant", domain.HuntQuery{TenantID: acme, Text: "account is suspended"}, 4, 1, 2},
+		{"sender domain", domain.HuntQuery{SenderDomain: "external.com", Text: "urgent payment"}, 9, 3, 3},
+		{"window", domain.HuntQuery{Sender: "security@paypa1-support.com", From: time.Now()}, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matches []domain.HuntMatch
+			report, err := service.Hunt(context.Background(), tt.query, collect(&matches))
+			require.NoError(t, err)
+
+			assert.Len(t, matches, tt.matches)
+			assert.Equal(t, tt.matches, report.Matches)
+			assert.Equal(t, tt.tenants, report.Tenants)
+			assert.Equal(t, tt.mailboxes, report.Mailboxes)
+			assert.False(t, report.Truncated)
+			for i := 1; i < len(matches); i++ {
+				assert.False(t, matches[i].Email.ReceivedAt.After(matches[i-1].Email.ReceivedAt), "newest first")
+			}
+		})
+	}
+}
+
+func TestHuntService_Hunt_MatchDetails(t *testing.T) {
+	store := newFakeStorage()
+	tenantID := seedCampaign(store, "Acme", 0)
+	service := newTestService(store)
+	_, err := service.ProcessConcurrently(context.Background(), PoolConfig{})
+	require.NoError(t, err)
+
+	var matches []domain.HuntMatch
+	report, err := NewHuntService(store).Hunt(context.Background(), domain.HuntQuery{Text: "wire transfer"}, collect(&matches))
+	require.NoError(t, err)
+
+	require.Len(t, matches, 3)
+	assert.Equal(t, tenantID, matches[0].Email.TenantID)
+	assert.Equal(t, "Acme", matches[0].TenantName)
+	assert.NotEmpty(t, matches[0].RiskLevel, "the current analysis is reported")
+	assert.Equal(t, 3, report.Flagged)
+}
+
+func TestHuntService_Hunt_Limit(t *testing.T) {
+	store := newFakeStorage()
+	seedCampaign(store, "Acme", 5)
+	service := NewHuntService(store)
+	service.pageSize = 2
+
+	var matches []domain.HuntMatch
+	report, err := service.Hunt(context.Background(), domain.HuntQuery{AttachmentHash: campaignHash, Limit: 3}, collect(&matches))
+	require.NoError(t, err)
+	assert.Len(t, matches, 3)
+	assert.True(t, report.Truncated)
+
+	// A limit equal to the number of matches is not truncated
+	matches = nil
+	report, err = service.Hunt(context.Background(), domain.HuntQuery{AttachmentHash: campaignHash, Limit: 5}, collect(&matches))
+	require.NoError(t, err)
+	assert.Len(t, matches, 5)
+	assert.False(t, report.Truncated)
+}
+
+func TestHuntService_Hunt_Errors(t *testing.T) {
+	store := newFakeStorage()
+	seedCampaign(store, "Acme", 3)
+	service := NewHuntService(store)
+
+	_, err := service.Hunt(context.Background(), domain.HuntQuery{From: time.Now().Add(-time.Hour)}, collect(new([]domain.HuntMatch)))
+	assert.ErrorIs(t, err, domain.ErrInvalidHunt)
+	_, err = service.Hunt(context.Background(), domain.HuntQuery{Text: "ok"}, collect(new([]domain.HuntMatch)))
+	assert.ErrorIs(t, err, domain.ErrInvalidHunt)
+	assert.Zero(t, store.callCount("HuntEmails"), "invalid queries don't reach storage")
+
+	// The consumer going away stops the hunt
+	gone := errors.New("client disconnected")
+	calls := 0
+	report, err := service.Hunt(context.Background(), domain.HuntQuery{AttachmentHash: campaignHash}, func(domain.HuntMatch) error {
+		calls++
+		if calls == 2 {
+			return gone
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, gone)
+	assert.Equal(t, 1, report.Matches)
+}
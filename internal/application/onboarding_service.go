@@ -0,0 +1,340 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/consent"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// OnboardingConfig configures tenant onboarding
+type OnboardingConfig struct {
+	// StateSecret signs consent states (required). Every process answering consent
+	// callbacks must share it.
+	StateSecret []byte
+
+	// StateTTL is how long a consent URL can be used (default: 1 hour)
+	StateTTL time.Duration
+
+	// RedirectURL is the consent callback URL, as registered with the providers' apps
+	RedirectURL string
+
+	// Apps are our applications registered with each provider
+	Apps map[domain.Provider]domain.OAuthApp
+}
+
+// withDefaults fills unset fields
+func (c OnboardingConfig) withDefaults() OnboardingConfig {
+	if c.StateTTL <= 0 {
+		c.StateTTL = time.Hour
+	}
+	return c
+}
+
+// OnboardingService manages the tenant lifecycle and implements ports.TenantOnboarding
+//
+// A tenant is created pending consent with the provider consent URL for its
+// administrator. The consent callback stores the tenant's credentials (Microsoft
+// client credentials for the consenting directory, a Google refresh token) and
+// activates it; the first activation syncs its directory and subscribes to its
+// mailboxes. A tenant whose consent was revoked (domain.TenantAuthError) goes
+// through the same consent to reactivate.
+//
+// Offboarding stops the tenant's notifications, revokes its grant and deletes the
+// tenant with all of its data. It is retried until it completes: a tenant found in
+// domain.TenantOffboarding is offboarded again.
+type OnboardingService struct {
+	storage     ports.Storage
+	credentials *CredentialService
+	exchanger   ports.TokenExchanger
+	ingestion   *FraudDetectionService
+	push        *PushIngestionService
+	cfg         OnboardingConfig
+	now         func() time.Time
+}
+
+// NewOnboardingService creates an onboarding service storing credentials through
+// credentials and syncing new tenants through ingestion and push (push may be nil)
+func NewOnboardingService(
+	storage ports.Storage,
+	credentials *CredentialService,
+	exchanger ports.TokenExchanger,
+	ingestion *FraudDetectionService,
+	push *PushIngestionService,
+	cfg OnboardingConfig,
+) *OnboardingService {
+	return &OnboardingService{
+		storage:     storage,
+		credentials: credentials,
+		exchanger:   exchanger,
+		ingestion:   ingestion,
+		push:        push,
+		cfg:         cfg.withDefaults(),
+		now:         time.Now,
+	}
+}
+
+// StartOnboarding creates a tenant pending consent and returns its consent URL
+// The tenant's ID, status and timestamps are set.
+func (s *OnboardingService) StartOnboarding(ctx context.Context, tenant *domain.Tenant) (string, error) {
+	if tenant.Name == "" {
+		return "", errors.New("tenant name is required")
+	}
+	if _, ok := s.cfg.Apps[tenant.Provider]; !ok {
+		return "", fmt.Errorf("unsupported provider: %s", tenant.Provider)
+	}
+
+	now := s.now()
+	tenant.ID = uuid.New()
+	tenant.Status = domain.TenantPendingConsent
+	tenant.Credentials = ""
+	tenant.CreatedAt = now
+	tenant.UpdatedAt = now
+	if err := s.storage.CreateTenant(ctx, tenant); err != nil {
+		return "", fmt.Errorf("failed to create tenant: %w", err)
+	}
+	log.Printf("Tenant %s (%s) created, pending consent", tenant.Name, tenant.Provider)
+	return s.consentURL(tenant)
+}
+
+// ConsentURL returns a new consent URL for a tenant pending consent or reauthorization
+func (s *OnboardingService) ConsentURL(ctx context.Context, tenantID uuid.UUID) (string, error) {
+	tenant, err := s.tenant(ctx, tenantID)
+	if err != nil {
+		return "", err
+	}
+	if err := awaitingConsent(tenant); err != nil {
+		return "", err
+	}
+	return s.consentURL(tenant)
+}
+
+// CompleteConsent handles a consent callback: it stores the tenant's credentials and
+// activates it
+// Invalid or expired states wrap domain.ErrInvalidConsentState, refusals
+// domain.ErrConsentDenied. Directory sync failures are logged: ingestion syncs again.
+func (s *OnboardingService) CompleteConsent(ctx context.Context, callback domain.ConsentCallback) (*domain.Tenant, error) {
+	tenantID, err := consent.VerifyState(s.cfg.StateSecret, callback.State, s.now())
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := s.tenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := awaitingConsent(tenant); err != nil {
+		return nil, err
+	}
+	if callback.Error != "" {
+		log.Printf("Consent denied for tenant %s: %s", tenant.Name, callback.Error)
+		return nil, fmt.Errorf("%w: %s: %s", domain.ErrConsentDenied, callback.Error, callback.ErrorDescription)
+	}
+
+	creds, err := s.grantedCredentials(ctx, tenant, callback)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.credentials.SetCredentials(ctx, tenant.ID, creds); err != nil {
+		return nil, err
+	}
+
+	firstActivation := tenant.Status == domain.TenantPendingConsent
+	tenant.Status = domain.TenantActive
+	log.Printf("Tenant %s consented, now %s", tenant.Name, tenant.Status)
+	if firstActivation {
+		s.initialSync(ctx, tenant)
+	}
+	return tenant, nil
+}
+
+// Suspend stops provider calls for a tenant until Resume
+func (s *OnboardingService) Suspend(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error) {
+	tenant, err := s.transition(ctx, tenantID, domain.TenantSuspended)
+	if err != nil {
+		return nil, err
+	}
+	s.credentials.Forget(tenantID)
+	return tenant, nil
+}
+
+// Resume reactivates a suspended tenant
+func (s *OnboardingService) Resume(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error) {
+	tenant, err := s.tenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.Status != domain.TenantSuspended {
+		return nil, fmt.Errorf("%w: tenant %s is %s, not %s", domain.ErrInvalidTransition, tenant.Name, tenant.Status, domain.TenantSuspended)
+	}
+	return s.transition(ctx, tenantID, domain.TenantActive)
+}
+
+// Offboard stops a tenant's notifications, revokes its grant and deletes it with all
+// of its data
+// Notification and revocation failures are logged: subscriptions expire and the
+// administrator can remove the application. Other failures leave the tenant
+// offboarding, and Offboard can be called again.
+func (s *OnboardingService) Offboard(ctx context.Context, tenantID uuid.UUID) error {
+	tenant, err := s.tenant(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.Status != domain.TenantOffboarding {
+		if err := domain.ValidateTenantTransition(tenant.Status, domain.TenantOffboarding); err != nil {
+			return err
+		}
+		// Removing subscriptions needs an access token: do it while the tenant is active
+		if tenant.Active() && s.push != nil {
+			if removed, err := s.push.UnsubscribeTenant(ctx, tenant); err != nil {
+				log.Printf("Failed to remove mail subscriptions of tenant %s: %v", tenant.Name, err)
+			} else {
+				log.Printf("Removed %d mail subscriptions of tenant %s", removed, tenant.Name)
+			}
+		}
+		if err := s.storage.UpdateTenantStatus(ctx, tenantID, tenant.Status, domain.TenantOffboarding); err != nil {
+			return fmt.Errorf("failed to mark tenant %s offboarding: %w", tenant.Name, err)
+		}
+		log.Printf("Tenant %s: %s -> %s", tenant.Name, tenant.Status, domain.TenantOffboarding)
+	}
+
+	if err := s.credentials.Revoke(ctx, tenantID); err != nil {
+		log.Printf("Failed to revoke grant of tenant %s (the administrator can remove the application): %v", tenant.Name, err)
+	}
+	if err := s.storage.DeleteTenant(ctx, tenantID); err != nil {
+		return fmt.Errorf("failed to purge tenant %s: %w", tenant.Name, err)
+	}
+	log.Printf("Tenant %s offboarded, data purged", tenant.Name)
+	return nil
+}
+
+// grantedCredentials returns the credentials a consent callback grants
+func (s *OnboardingService) grantedCredentials(ctx context.Context, tenant *domain.Tenant, callback domain.ConsentCallback) (domain.TenantCredentials, error) {
+	provider := tenant.Provider
+	app := s.cfg.Apps[provider]
+	switch provider {
+	case domain.ProviderMicrosoft:
+		// Admin consent grants our application permissions in the directory: its
+		// tokens are obtained with our client credentials
+		if !callback.AdminConsent {
+			return domain.TenantCredentials{}, fmt.Errorf("%w: admin consent was not granted", domain.ErrConsentDenied)
+		}
+		if callback.DirectoryID == "" {
+			return domain.TenantCredentials{}, errors.New("consent callback has no directory ID")
+		}
+		if err := s.sameDirectory(ctx, tenant, callback.DirectoryID); err != nil {
+			return domain.TenantCredentials{}, err
+		}
+		return domain.TenantCredentials{
+			Grant:        domain.GrantClientCredentials,
+			DirectoryID:  callback.DirectoryID,
+			ClientID:     app.ClientID,
+			ClientSecret: app.ClientSecret,
+			TokenURL:     app.TokenURL,
+		}, nil
+	case domain.ProviderGoogle:
+		if callback.Code == "" {
+			return domain.TenantCredentials{}, errors.New("consent callback has no authorization code")
+		}
+		token, err := s.exchanger.ExchangeCode(ctx, provider, app, callback.Code, s.cfg.RedirectURL)
+		if err != nil {
+			return domain.TenantCredentials{}, fmt.Errorf("failed to exchange authorization code: %w", err)
+		}
+		return domain.TenantCredentials{
+			Grant:        domain.GrantRefreshToken,
+			ClientID:     app.ClientID,
+			ClientSecret: app.ClientSecret,
+			RefreshToken: token.RefreshToken,
+			TokenURL:     app.TokenURL,
+		}, nil
+	default:
+		return domain.TenantCredentials{}, fmt.Errorf("unsupported provider: %s", provider)
+	}
+}
+
+// sameDirectory checks a reauthorization grants the directory the tenant was
+// onboarded with: the tenant parameter of the callback is not signed, so a consent
+// from any other directory must not replace it
+func (s *OnboardingService) sameDirectory(ctx context.Context, tenant *domain.Tenant, directoryID string) error {
+	if tenant.Credentials == "" {
+		return nil // First consent
+	}
+	current, err := s.credentials.open(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if current.DirectoryID != "" && !strings.EqualFold(current.DirectoryID, directoryID) {
+		log.Printf("Consent for tenant %s names directory %s, onboarded with %s", tenant.Name, directoryID, current.DirectoryID)
+		return fmt.Errorf("%w: %s", domain.ErrDirectoryMismatch, directoryID)
+	}
+	return nil
+}
+
+// initialSync stores a newly active tenant's users and subscribes to their mailboxes
+func (s *OnboardingService) initialSync(ctx context.Context, tenant *domain.Tenant) {
+	users, err := s.ingestion.SyncUsers(ctx, tenant)
+	if err != nil {
+		log.Printf("Initial directory sync failed for tenant %s, left to ingestion: %v", tenant.Name, err)
+		return
+	}
+	log.Printf("Initial directory sync for tenant %s: %d users", tenant.Name, len(users))
+
+	if s.push == nil {
+		return
+	}
+	if created, err := s.push.SubscribeTenant(ctx, tenant); err != nil {
+		log.Printf("Failed to subscribe to mailboxes of tenant %s: %v", tenant.Name, err)
+	} else {
+		log.Printf("Subscribed to %d mailboxes of tenant %s", created, tenant.Name)
+	}
+}
+
+// transition moves a tenant to status to if its lifecycle allows it
+func (s *OnboardingService) transition(ctx context.Context, tenantID uuid.UUID, to string) (*domain.Tenant, error) {
+	tenant, err := s.tenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := domain.ValidateTenantTransition(tenant.Status, to); err != nil {
+		return nil, err
+	}
+	if err := s.storage.UpdateTenantStatus(ctx, tenantID, tenant.Status, to); err != nil {
+		return nil, fmt.Errorf("failed to update tenant %s: %w", tenant.Name, err)
+	}
+	log.Printf("Tenant %s: %s -> %s", tenant.Name, tenant.Status, to)
+	tenant.Status = to
+	return tenant, nil
+}
+
+// tenant fetches a tenant, failing with domain.ErrTenantNotFound if it does not exist
+func (s *OnboardingService) tenant(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error) {
+	tenant, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tenant: %w", err)
+	}
+	if tenant == nil {
+		return nil, fmt.Errorf("%w: %s", domain.ErrTenantNotFound, tenantID)
+	}
+	return tenant, nil
+}
+
+// consentURL signs a state for the tenant and builds its provider consent URL
+func (s *OnboardingService) consentURL(tenant *domain.Tenant) (string, error) {
+	state := consent.SignState(s.cfg.StateSecret, tenant.ID, s.now().Add(s.cfg.StateTTL))
+	return consent.AuthorizationURL(tenant.Provider, s.cfg.Apps[tenant.Provider], s.cfg.RedirectURL, state)
+}
+
+// awaitingConsent returns domain.ErrInvalidTransition unless a tenant can be
+// activated by consent: pending its first consent or reauthorization
+func awaitingConsent(tenant *domain.Tenant) error {
+	if tenant.Status != domain.TenantPendingConsent && tenant.Status != domain.TenantAuthError {
+		return fmt.Errorf("%w: tenant %s is %s, not awaiting consent", domain.ErrInvalidTransition, tenant.Name, tenant.Status)
+	}
+	return nil
+}
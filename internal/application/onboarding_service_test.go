@@ -0,0 +1,364 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/adapters/oauth"
+	"github.com/stoik/email-security/internal/adapters/onboarding"
+	"github.com/stoik/email-security/internal/adapters/providers"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// onboardingFixture is an onboarding service whose provider apps exchange tokens with
+// the credential fixture's fake token endpoint
+type onboardingFixture struct {
+	*credentialFixture
+	graph   *fakeSubscriber
+	gmail   *fakeSubscriber
+	service *OnboardingService
+}
+
+func newOnboardingFixture(t *testing.T) *onboardingFixture {
+	f := &onboardingFixture{
+		credentialFixture: newCredentialFixture(t),
+		graph:             &fakeSubscriber{provider: domain.ProviderMicrosoft, lifetime: time.Hour, echoClientState: true},
+		gmail:             &fakeSubscriber{provider: domain.ProviderGoogle, lifetime: time.Hour, echoClientState: true},
+	}
+	providerMap := map[domain.Provider]ports.EmailProvider{
+		domain.ProviderMicrosoft: providers.NewMicrosoftClient(f.credentialFixture.service),
+		domain.ProviderGoogle:    providers.NewGoogleClient(f.credentialFixture.service),
+	}
+	ingestion := NewFraudDetectionService(f.store, nil, providerMap, nil)
+	push := NewPushIngestionService(f.store, providerMap, map[domain.Provider]ports.MailSubscriber{
+		domain.ProviderMicrosoft: f.graph,
+		domain.ProviderGoogle:    f.gmail,
+	}, PushConfig{})
+
+	f.service = NewOnboardingService(f.store, f.credentialFixture.service, oauth.NewTokenClient(5*time.Second), ingestion, push, OnboardingConfig{
+		StateSecret: []byte("test-state-secret"),
+		RedirectURL: "https://app.example.com/consent/callback",
+		Apps: map[domain.Provider]domain.OAuthApp{
+			domain.ProviderMicrosoft: {ClientID: "graph-app", ClientSecret: "graph-secret", TokenURL: f.url},
+			domain.ProviderGoogle:    {ClientID: "gmail-app", ClientSecret: "gmail-secret", TokenURL: f.url},
+		},
+	})
+	f.service.now = func() time.Time { return f.now }
+	return f
+}
+
+// start creates a tenant and returns it with the state of its consent URL
+func (f *onboardingFixture) start(t *testing.T, name string, provider domain.Provider) (*domain.Tenant, string) {
+	tenant := &domain.Tenant{Name: name, Provider: provider}
+	consentURL, err := f.service.StartOnboarding(context.Background(), tenant)
+	require.NoError(t, err)
+	parsed, err := url.Parse(consentURL)
+	require.NoError(t, err)
+	return tenant, parsed.Query().Get("state")
+}
+
+// consent answers a tenant's consent callback as its provider would after a grant
+func (f *onboardingFixture) consent(t *testing.T, tenant *domain.Tenant, state string) *domain.Tenant {
+	callback := domain.ConsentCallback{State: state, DirectoryID: "directory-" + tenant.Name, AdminConsent: true}
+	if tenant.Provider == domain.ProviderGoogle {
+		callback = domain.ConsentCallback{State: state, Code: "code-" + tenant.Name}
+	}
+	activated, err := f.service.CompleteConsent(context.Background(), callback)
+	require.NoError(t, err)
+	return activated
+}
+
+func TestOnboardingService_CompleteConsent(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   domain.Provider
+		grant      string
+		authorize  string
+		users      int
+		subscribed int
+		subscriber func(f *onboardingFixture) *fakeSubscriber
+	}{
+		{
+			name:       "microsoft admin consent",
+			provider:   domain.ProviderMicrosoft,
+			grant:      domain.GrantClientCredentials,
+			users:      4,
+			subscribed: 3, // The disabled account is not monitored
+			authorize:  "https://login.microsoftonline.com/organizations/v2.0/adminconsent?",
+			subscriber: func(f *onboardingFixture) *fakeSubscriber { return f.graph },
+		},
+		{
+			name:       "google authorization code",
+			provider:   domain.ProviderGoogle,
+			grant:      domain.GrantRefreshToken,
+			users:      2,
+			subscribed: 2,
+			authorize:  "https://accounts.google.com/o/oauth2/v2/auth?",
+			subscriber: func(f *onboardingFixture) *fakeSubscriber { return f.gmail },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOnboardingFixture(t)
+			ctx := context.Background()
+
+			tenant := &domain.Tenant{Name: "Acme", Provider: tt.provider}
+			consentURL, err := f.service.StartOnboarding(ctx, tenant)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(consentURL, tt.authorize), consentURL)
+			assert.Equal(t, domain.TenantPendingConsent, f.store.tenants[tenant.ID].Status)
+
+			// Pending tenants are not ingested and get no token
+			require.NoError(t, NewFraudDetectionService(f.store, nil, nil, nil).IngestEmailsForTenant(ctx, tenant))
+			_, err = f.credentialFixture.service.Token(ctx, tenant.ID)
+			assert.ErrorIs(t, err, domain.ErrTenantInactive)
+
+			parsed, err := url.Parse(consentURL)
+			require.NoError(t, err)
+			activated := f.consent(t, tenant, parsed.Query().Get("state"))
+			assert.Equal(t, domain.TenantActive, activated.Status)
+			assert.Equal(t, domain.TenantActive, f.store.tenants[tenant.ID].Status)
+			assert.Equal(t, tt.grant, f.storedCredentials(t, tenant.ID).Grant)
+
+			// Initial directory sync and mailbox subscriptions
+			users, err := f.store.ListUsers(ctx, tenant.ID)
+			require.NoError(t, err)
+			assert.Len(t, users, tt.users)
+			assert.Len(t, tt.subscriber(f).subscribed, tt.subscribed)
+
+			_, err = f.credentialFixture.service.Token(ctx, tenant.ID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestOnboardingService_CompleteConsent_Rejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		callback func(state string) domain.ConsentCallback
+		advance  time.Duration
+		wantErr  error
+	}{
+		{
+			name: "forged state",
+			callback: func(state string) domain.ConsentCallback {
+				forged := uuid.NewString() + state[strings.Index(state, "."):]
+				return domain.ConsentCallback{State: forged, DirectoryID: uuid.NewString(), AdminConsent: true}
+			},
+			wantErr: domain.ErrInvalidConsentState,
+		},
+		{
+			name: "expired state",
+			callback: func(state string) domain.ConsentCallback {
+				return domain.ConsentCallback{State: state, DirectoryID: uuid.NewString(), AdminConsent: true}
+			},
+			advance: 2 * time.Hour,
+			wantErr: domain.ErrInvalidConsentState,
+		},
+		{
+			name: "directory without admin consent",
+			callback: func(state string) domain.ConsentCallback {
+				return domain.ConsentCallback{State: state, DirectoryID: uuid.NewString()}
+			},
+			wantErr: domain.ErrConsentDenied,
+		},
+		{
+			name: "declined by the administrator",
+			callback: func(state string) domain.ConsentCallback {
+				return domain.ConsentCallback{State: state, Error: "access_denied", ErrorDescription: "AADSTS65004: User declined to consent"}
+			},
+			wantErr: domain.ErrConsentDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOnboardingFixture(t)
+			tenant, state := f.start(t, "Acme", domain.ProviderMicrosoft)
+			f.now = f.now.Add(tt.advance)
+
+			_, err := f.service.CompleteConsent(context.Background(), tt.callback(state))
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, domain.TenantPendingConsent, f.store.tenants[tenant.ID].Status)
+			assert.Empty(t, f.store.tenants[tenant.ID].Credentials)
+		})
+	}
+}
+
+func TestOnboardingService_Reauthorize(t *testing.T) {
+	f := newOnboardingFixture(t)
+	ctx := context.Background()
+	tenant, state := f.start(t, "Acme", domain.ProviderMicrosoft)
+	f.consent(t, tenant, state)
+
+	// A consumed consent cannot activate the tenant again
+	_, err := f.service.CompleteConsent(ctx, domain.ConsentCallback{State: state, DirectoryID: uuid.NewString(), AdminConsent: true})
+	assert.ErrorIs(t, err, domain.ErrInvalidTransition)
+	_, err = f.service.ConsentURL(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrInvalidTransition)
+
+	// Revoked consent: a new consent reactivates the tenant without another initial sync
+	f.tokens.Revoke("graph-app")
+	f.now = f.now.Add(2 * time.Hour)
+	_, err = f.credentialFixture.service.Token(ctx, tenant.ID)
+	require.ErrorIs(t, err, domain.ErrAuthExpired)
+	assert.Equal(t, domain.TenantAuthError, f.store.tenants[tenant.ID].Status)
+
+	consentURL, err := f.service.ConsentURL(ctx, tenant.ID)
+	require.NoError(t, err)
+	parsed, err := url.Parse(consentURL)
+	require.NoError(t, err)
+	f.tokens = oauth.NewFakeTokenServer(time.Hour) // Consent granted again
+	server := httptest.NewServer(f.tokens)
+	defer server.Close()
+	f.service.cfg.Apps[domain.ProviderMicrosoft] = domain.OAuthApp{ClientID: "graph-app", ClientSecret: "graph-secret", TokenURL: server.URL + "/token"}
+
+	// A consent from another directory does not replace the tenant's
+	_, err = f.service.CompleteConsent(ctx, domain.ConsentCallback{State: parsed.Query().Get("state"), DirectoryID: uuid.NewString(), AdminConsent: true})
+	assert.ErrorIs(t, err, domain.ErrDirectoryMismatch)
+	assert.Equal(t, "directory-Acme", f.storedCredentials(t, tenant.ID).DirectoryID)
+	assert.Equal(t, domain.TenantAuthError, f.store.tenants[tenant.ID].Status)
+
+	reactivated := f.consent(t, tenant, parsed.Query().Get("state"))
+	assert.Equal(t, domain.TenantActive, reactivated.Status)
+	assert.Len(t, f.graph.subscribed, 3, "subscribed on first activation only")
+}
+
+func TestOnboardingService_SuspendResume(t *testing.T) {
+	f := newOnboardingFixture(t)
+	ctx := context.Background()
+	tenant, state := f.start(t, "Acme", domain.ProviderGoogle)
+
+	_, err := f.service.Suspend(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrInvalidTransition, "pending tenants cannot be suspended")
+
+	f.consent(t, tenant, state)
+	_, err = f.credentialFixture.service.Token(ctx, tenant.ID)
+	require.NoError(t, err)
+
+	suspended, err := f.service.Suspend(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.TenantSuspended, suspended.Status)
+	_, err = f.credentialFixture.service.Token(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrTenantInactive, "cached token dropped")
+
+	resumed, err := f.service.Resume(ctx, tenant.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.TenantActive, resumed.Status)
+	_, err = f.credentialFixture.service.Token(ctx, tenant.ID)
+	assert.NoError(t, err)
+
+	_, err = f.service.Resume(ctx, tenant.ID)
+	assert.ErrorIs(t, err, domain.ErrInvalidTransition)
+	_, err = f.service.Suspend(ctx, uuid.New())
+	assert.ErrorIs(t, err, domain.ErrTenantNotFound)
+}
+
+func TestOnboardingService_Offboard(t *testing.T) {
+	f := newOnboardingFixture(t)
+	ctx := context.Background()
+	leaving, state := f.start(t, "Leaving", domain.ProviderGoogle)
+	f.consent(t, leaving, state)
+	staying, state := f.start(t, "Staying", domain.ProviderGoogle)
+	f.consent(t, staying, state)
+	f.store.addEmail(domain.Email{TenantID: leaving.ID, Subject: "Invoice"})
+	granted := f.storedCredentials(t, leaving.ID)
+
+	require.NoError(t, f.service.Offboard(ctx, leaving.ID))
+
+	// Notifications stopped, grant revoked, data purged
+	assert.Len(t, f.gmail.unsubscribed, 2)
+	_, err := oauth.NewTokenClient(5*time.Second).Exchange(ctx, domain.ProviderGoogle, granted)
+	assert.ErrorIs(t, err, domain.ErrAuthExpired)
+	assert.NotContains(t, f.store.tenants, leaving.ID)
+	assert.NotContains(t, f.store.dataKeys, leaving.ID)
+	users, err := f.store.ListUsers(ctx, leaving.ID)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+	subs, err := f.store.ListMailSubscriptions(ctx, leaving.ID)
+	require.NoError(t, err)
+	assert.Empty(t, subs)
+	for _, email := range f.store.emails {
+		assert.NotEqual(t, leaving.ID, email.TenantID)
+	}
+
+	// Other tenants of the same application keep their grant
+	f.now = f.now.Add(2 * time.Hour)
+	_, err = f.credentialFixture.service.Token(ctx, staying.ID)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, f.service.Offboard(ctx, leaving.ID), domain.ErrTenantNotFound)
+}
+
+func TestOnboardingService_Offboard_Retried(t *testing.T) {
+	f := newOnboardingFixture(t)
+	ctx := context.Background()
+	tenant, _ := f.start(t, "Acme", domain.ProviderMicrosoft)
+	f.store.tenants[tenant.ID].Status = domain.TenantOffboarding // A previous attempt failed to purge
+
+	require.NoError(t, f.service.Offboard(ctx, tenant.ID))
+	assert.NotContains(t, f.store.tenants, tenant.ID)
+}
+
+func TestOnboardingService_HTTP(t *testing.T) {
+	f := newOnboardingFixture(t)
+	server := httptest.NewServer(onboarding.New(f.service, onboarding.Config{AdminToken: "admin-token"}))
+	defer server.Close()
+
+	request := func(method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/tenants", "", `{"name":"Acme","provider":"microsoft"}`).StatusCode)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/tenants", "wrong", `{"name":"Acme","provider":"microsoft"}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/tenants", "admin-token", `{"name":"Acme","provider":"yahoo"}`).StatusCode)
+
+	resp := request(http.MethodPost, "/tenants", "admin-token", `{"name":"Acme","provider":"microsoft"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created struct {
+		ID         uuid.UUID `json:"id"`
+		Status     string    `json:"status"`
+		ConsentURL string    `json:"consent_url"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, domain.TenantPendingConsent, created.Status)
+
+	// The provider redirects the administrator to the callback, which needs no admin token
+	consentURL, err := url.Parse(created.ConsentURL)
+	require.NoError(t, err)
+	callback := url.Values{
+		"state":         {consentURL.Query().Get("state")},
+		"tenant":        {uuid.NewString()},
+		"admin_consent": {"True"},
+	}
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodGet, "/consent/callback?state=forged", "", "").StatusCode)
+	resp = request(http.MethodGet, "/consent/callback?"+callback.Encode(), "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, domain.TenantActive, f.store.tenants[created.ID].Status)
+
+	tenantPath := "/tenants/" + created.ID.String()
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, tenantPath+"/consent", "admin-token", "").StatusCode)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, tenantPath+"/suspend", "admin-token", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPost, tenantPath+"/offboard", "admin-token", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, tenantPath+"/resume", "admin-token", "").StatusCode)
+}
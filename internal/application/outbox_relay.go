@@ -0,0 +1,181 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/resilience"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// RelayConfig configures the outbox relay
+type RelayConfig struct {
+	// BatchSize is the number of events claimed per round trip (default: 100)
+	BatchSize int
+
+	// Lease is how long claimed events are reserved for this relay (default: 1 minute)
+	Lease time.Duration
+
+	// MaxAttempts is the number of deliveries tried before an event is dead-lettered (default: 10)
+	MaxAttempts int
+
+	// BaseBackoff is the delay before the first retry; it doubles with every attempt
+	// up to MaxBackoff (defaults: 1 second, 10 minutes)
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Owner identifies this relay on its event leases (default: hostname, PID and a random suffix)
+	Owner string
+}
+
+// withDefaults fills unset fields
+func (c RelayConfig) withDefaults() RelayConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.Lease <= 0 {
+		c.Lease = time.Minute
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Minute
+	}
+	if c.Owner == "" {
+		c.Owner = newLeaseOwner()
+	}
+	return c
+}
+
+// backoff returns the delay before retrying an event that failed its nth attempt
+func (c RelayConfig) backoff(attempts int) time.Duration {
+	return resilience.Backoff(c.BaseBackoff, c.MaxBackoff, attempts)
+}
+
+// RelayStats reports the outcome of relaying outbox events
+type RelayStats struct {
+	Published    int // Delivered to every sink
+	Retried      int // Failed and rescheduled with backoff
+	DeadLettered int // Failed their last attempt
+}
+
+// OutboxRelay publishes outbox events to sinks
+//
+// Events are claimed with leases, so several relays can run at once. Delivery is
+// at-least-once: an event is marked published only once every sink accepted it, and
+// an event failing on one sink is redelivered to all of them, which deduplicate on
+// Event.DedupKey.
+type OutboxRelay struct {
+	storage ports.Storage
+	sinks   []ports.EventSink
+	cfg     RelayConfig
+}
+
+// NewOutboxRelay creates a relay delivering to sinks
+func NewOutboxRelay(storage ports.Storage, sinks []ports.EventSink, cfg RelayConfig) *OutboxRelay {
+	return &OutboxRelay{storage: storage, sinks: sinks, cfg: cfg.withDefaults()}
+}
+
+// Run relays events until ctx is cancelled, polling every interval once the outbox is drained
+// In production, LISTEN/NOTIFY on outbox inserts would cut the polling latency.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Drain(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Outbox relay failed, retrying in %s: %v", interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain relays due events until none are left
+// Events rescheduled for a later retry are left for a later call.
+func (r *OutboxRelay) Drain(ctx context.Context) (RelayStats, error) {
+	var total RelayStats
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+
+		events, err := r.storage.ClaimOutboxEvents(ctx, r.cfg.Owner, r.cfg.BatchSize, r.cfg.Lease)
+		if err != nil {
+			return total, fmt.Errorf("failed to claim outbox events: %w", err)
+		}
+		if len(events) == 0 {
+			return total, nil
+		}
+
+		stats, err := r.relay(ctx, events)
+		total.Published += stats.Published
+		total.Retried += stats.Retried
+		total.DeadLettered += stats.DeadLettered
+		if err != nil {
+			return total, err
+		}
+		if len(events) < r.cfg.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+// relay delivers a claimed batch and records the outcome of each event
+func (r *OutboxRelay) relay(ctx context.Context, events []domain.Event) (RelayStats, error) {
+	var stats RelayStats
+	published := make([]uuid.UUID, 0, len(events))
+
+	for _, event := range events {
+		err := r.deliver(ctx, event)
+		if err == nil {
+			published = append(published, event.ID)
+			continue
+		}
+
+		if event.Attempts >= r.cfg.MaxAttempts {
+			log.Printf("Dead-lettering %s event %s after %d attempts: %v", event.Type, event.DedupKey, event.Attempts, err)
+			if err := r.storage.DeadLetterOutboxEvent(ctx, r.cfg.Owner, event.ID, err.Error()); err != nil {
+				return stats, fmt.Errorf("failed to dead-letter event %s: %w", event.ID, err)
+			}
+			stats.DeadLettered++
+			continue
+		}
+
+		delay := r.cfg.backoff(event.Attempts)
+		if err := r.storage.RetryOutboxEvent(ctx, r.cfg.Owner, event.ID, delay, err.Error()); err != nil {
+			return stats, fmt.Errorf("failed to reschedule event %s: %w", event.ID, err)
+		}
+		stats.Retried++
+	}
+
+	if len(published) > 0 {
+		if err := r.storage.MarkOutboxEventsPublished(ctx, r.cfg.Owner, published); err != nil {
+			// The leases expire and the events are redelivered: duplicates, not losses
+			return stats, fmt.Errorf("failed to mark %d events published: %w", len(published), err)
+		}
+		stats.Published += len(published)
+	}
+	return stats, nil
+}
+
+// deliver publishes an event to every sink, stopping at the first failure
+func (r *OutboxRelay) deliver(ctx context.Context, event domain.Event) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink records delivered events and fails the first failures calls
+type recordingSink struct {
+	mu       sync.Mutex
+	failures int
+	events   []domain.Event
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Publish(ctx context.Context, event domain.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) types() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := make(map[string]int)
+	for _, event := range s.events {
+		types[event.Type]++
+	}
+	return types
+}
+
+func TestOutboxRelay_Drain(t *testing.T) {
+	store := newFakeStorage()
+	seedEmails(store, 6)
+	_, err := newTestService(store).ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 10})
+	require.NoError(t, err)
+
+	first, second := &recordingSink{}, &recordingSink{}
+	relay := NewOutboxRelay(store, []ports.EventSink{first, second}, RelayConfig{BatchSize: 4})
+
+	stats, err := relay.Drain(context.Background())
+	require.NoError(t, err)
+
+	// Each analysis completes, and each wire transfer request to the CFO is a threat
+	assert.Equal(t, RelayStats{Published: 12}, stats)
+	assert.Equal(t, map[string]int{domain.EventAnalysisCompleted: 6, domain.EventThreatDetected: 6}, first.types())
+	assert.Equal(t, first.types(), second.types())
+
+	// Published events are not delivered again
+	stats, err = relay.Drain(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, stats.Published)
+}
+
+func TestOutboxRelay_Retry(t *testing.T) {
+	store := newFakeStorage()
+	event, err := domain.NewEmailIngestedEvent(domain.Email{Subject: "hello"})
+	require.NoError(t, err)
+	store.addEvents(event)
+
+	healthy, flaky := &recordingSink{}, &recordingSink{failures: 2}
+	relay := NewOutboxRelay(store, []ports.EventSink{healthy, flaky}, RelayConfig{BaseBackoff: time.Millisecond, MaxAttempts: 5})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		stats, err := relay.Drain(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, RelayStats{Retried: 1}, stats, "attempt %d", attempt)
+
+		// Not due again before its backoff
+		stats, err = relay.Drain(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, stats.Retried)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stats, err := relay.Drain(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, RelayStats{Published: 1}, stats)
+
+	// At-least-once: the healthy sink saw the event on every attempt, with the same dedup key
+	require.Len(t, healthy.events, 3)
+	assert.Equal(t, healthy.events[0].DedupKey, healthy.events[2].DedupKey)
+	require.Len(t, flaky.events, 1)
+	assert.Equal(t, 3, flaky.events[0].Attempts)
+}
+
+func TestOutboxRelay_DeadLetter(t *testing.T) {
+	store := newFakeStorage()
+	event, err := domain.NewEmailIngestedEvent(domain.Email{Subject: "hello"})
+	require.NoError(t, err)
+	store.addEvents(event)
+
+	sink := &recordingSink{failures: 100}
+	relay := NewOutboxRelay(store, []ports.EventSink{sink}, RelayConfig{BaseBackoff: time.Nanosecond, MaxAttempts: 3})
+
+	var total RelayStats
+	for i := 0; i < 5; i++ {
+		stats, err := relay.Drain(context.Background())
+		require.NoError(t, err)
+		total.Retried += stats.Retried
+		total.DeadLettered += stats.DeadLettered
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, RelayStats{Retried: 2, DeadLettered: 1}, total)
+	require.Len(t, store.outbox, 1)
+	assert.True(t, store.outbox[0].dead)
+	assert.Contains(t, store.outbox[0].lastErr, "recording: unavailable")
+}
+
+func TestRelayConfig_Backoff(t *testing.T) {
+	cfg := RelayConfig{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, cfg.backoff(1))
+	assert.Equal(t, 2*time.Second, cfg.backoff(2))
+	assert.Equal(t, 8*time.Second, cfg.backoff(4))
+	assert.Equal(t, 10*time.Second, cfg.backoff(5))
+	assert.Equal(t, 10*time.Second, cfg.backoff(1000))
+}
@@ -0,0 +1,429 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// PushConfig configures push ingestion
+type PushConfig struct {
+	// RenewBefore is how long before expiry subscriptions are renewed (default: 24h)
+	RenewBefore time.Duration
+
+	// Backfill is how far back a mailbox never synced is polled (default: 7 days,
+	// like the initial ingestion)
+	Backfill time.Duration
+
+	// BatchSize bounds the subscriptions handled per Reconcile call (default: 100)
+	BatchSize int
+}
+
+// withDefaults fills unset fields
+func (c PushConfig) withDefaults() PushConfig {
+	if c.RenewBefore <= 0 {
+		c.RenewBefore = 24 * time.Hour
+	}
+	if c.Backfill <= 0 {
+		c.Backfill = 7 * 24 * time.Hour
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	return c
+}
+
+// PushStats reports a Reconcile call
+type PushStats struct {
+	Renewed   int // Subscriptions extended
+	Recreated int // Subscriptions created again after a failed renewal or a lapse
+	Lapsed    int // Subscriptions that could not be renewed nor recreated (polled meanwhile)
+}
+
+// PushIngestionService ingests new mail from provider push notifications
+//
+// Each user mailbox gets a subscription (Graph subscription or Gmail watch) whose
+// notifications reach HandleMailChange through the HTTP receiver: the new messages
+// are fetched and stored unprocessed, queued for detection like polled ones.
+// Reconcile renews subscriptions before they expire and recreates the ones that
+// failed; while a subscription is lapsed, PollLapsed polls its mailbox instead.
+//
+// Storing an email is idempotent per provider message ID, so overlapping polls and
+// repeated notifications never duplicate mail.
+type PushIngestionService struct {
+	storage     ports.Storage
+	providers   map[domain.Provider]ports.EmailProvider
+	subscribers map[domain.Provider]ports.MailSubscriber
+	cfg         PushConfig
+	now         func() time.Time
+}
+
+// NewPushIngestionService creates a push ingestion service polling through providers
+// when subscriptions lapse
+func NewPushIngestionService(
+	storage ports.Storage,
+	providers map[domain.Provider]ports.EmailProvider,
+	subscribers map[domain.Provider]ports.MailSubscriber,
+	cfg PushConfig,
+) *PushIngestionService {
+	return &PushIngestionService{
+		storage:     storage,
+		providers:   providers,
+		subscribers: subscribers,
+		cfg:         cfg.withDefaults(),
+		now:         time.Now,
+	}
+}
+
+// SubscribeTenant creates subscriptions for the tenant's users that have none, and
+// returns the number created
+// Mail received before is expected to be ingested already (IngestEmailsForTenant).
+// A subscription that cannot be created is stored lapsed, to be polled and retried.
+func (s *PushIngestionService) SubscribeTenant(ctx context.Context, tenant *domain.Tenant) (int, error) {
+	subscriber, ok := s.subscribers[tenant.Provider]
+	if !ok {
+		return 0, fmt.Errorf("unsupported provider: %s", tenant.Provider)
+	}
+
+	users, err := s.storage.ListUsers(ctx, tenant.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch users: %w", err)
+	}
+	existing, err := s.storage.ListMailSubscriptions(ctx, tenant.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch mail subscriptions: %w", err)
+	}
+	subscribed := make(map[uuid.UUID]bool, len(existing))
+	for _, sub := range existing {
+		subscribed[sub.UserID] = true
+	}
+
+	created := 0
+	for _, user := range users {
+		if subscribed[user.ID] || user.Disabled {
+			continue
+		}
+		now := s.now()
+		sub := domain.MailSubscription{
+			ID:        uuid.New(),
+			TenantID:  tenant.ID,
+			UserID:    user.ID,
+			Provider:  tenant.Provider,
+			Mailbox:   user.Email,
+			SyncedAt:  now,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := s.subscribe(ctx, subscriber, &sub); err != nil {
+			log.Printf("Failed to subscribe to mailbox %s, it will be polled: %v", user.Email, err)
+		} else {
+			created++
+		}
+		if err := s.storage.CreateMailSubscription(ctx, &sub); err != nil {
+			return created, fmt.Errorf("failed to store mail subscription of %s: %w", user.Email, err)
+		}
+	}
+	return created, nil
+}
+
+// ListMailSubscriptions returns a tenant's subscriptions
+func (s *PushIngestionService) ListMailSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.MailSubscription, error) {
+	return s.storage.ListMailSubscriptions(ctx, tenantID)
+}
+
+// UnsubscribeTenant stops the notifications of a tenant's subscriptions, and returns
+// the number removed
+// Subscriptions that cannot be removed are logged: they expire on their own. The
+// stored subscriptions are left to the caller (deleted with the tenant).
+func (s *PushIngestionService) UnsubscribeTenant(ctx context.Context, tenant *domain.Tenant) (int, error) {
+	subscriber, ok := s.subscribers[tenant.Provider]
+	if !ok {
+		return 0, fmt.Errorf("unsupported provider: %s", tenant.Provider)
+	}
+	subs, err := s.storage.ListMailSubscriptions(ctx, tenant.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch mail subscriptions: %w", err)
+	}
+
+	removed := 0
+	for _, sub := range subs {
+		if sub.ExternalID == "" {
+			continue
+		}
+		if err := subscriber.Unsubscribe(ctx, sub); err != nil {
+			log.Printf("Failed to remove mail subscription %s (it expires on its own): %v", sub.ExternalID, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Run reconciles subscriptions every interval until ctx is cancelled
+func (s *PushIngestionService) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Mail subscription reconciliation failed, retrying in %s: %v", interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile renews subscriptions expiring within RenewBefore and recreates lapsed ones
+// A subscription that fails to renew is recreated; a recreated subscription first
+// catches up by polling the mail it may have missed, and so is a Graph subscription
+// stored without a client state. Lapsed subscriptions stay due, so
+// one call handles at most BatchSize subscriptions.
+func (s *PushIngestionService) Reconcile(ctx context.Context) (PushStats, error) {
+	var stats PushStats
+	due, err := s.storage.ListMailSubscriptionsDue(ctx, s.now().Add(s.cfg.RenewBefore), s.cfg.BatchSize)
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch due mail subscriptions: %w", err)
+	}
+
+	var failures []error
+	for i := range due {
+		sub := &due[i]
+		subscriber, ok := s.subscribers[sub.Provider]
+		if !ok {
+			continue
+		}
+
+		if sub.NeedsClientState() && sub.ClientState == "" {
+			log.Printf("Mail subscription of %s has no client state, recreating it", sub.Mailbox)
+		} else if sub.Status == domain.SubscriptionActive {
+			expiresAt, err := subscriber.Renew(ctx, *sub)
+			if err == nil {
+				sub.ExpiresAt = expiresAt
+				stats.Renewed++
+				failures = append(failures, s.update(ctx, sub))
+				continue
+			}
+			log.Printf("Failed to renew mail subscription of %s, recreating it: %v", sub.Mailbox, err)
+		}
+
+		if err := s.recreate(ctx, subscriber, sub); err != nil {
+			log.Printf("Failed to recreate mail subscription of %s, it will be polled: %v", sub.Mailbox, err)
+			stats.Lapsed++
+		} else {
+			stats.Recreated++
+		}
+		failures = append(failures, s.update(ctx, sub))
+	}
+	return stats, errors.Join(failures...)
+}
+
+// recreate replaces a subscription and polls the mail received since it was last synced
+func (s *PushIngestionService) recreate(ctx context.Context, subscriber ports.MailSubscriber, sub *domain.MailSubscription) error {
+	if sub.ExternalID != "" {
+		if err := subscriber.Unsubscribe(ctx, *sub); err != nil {
+			log.Printf("Failed to remove mail subscription %s (it expires on its own): %v", sub.ExternalID, err)
+		}
+	}
+	if err := s.subscribe(ctx, subscriber, sub); err != nil {
+		return err
+	}
+	if _, err := s.poll(ctx, sub); err != nil {
+		log.Printf("Catch-up poll of %s failed, left to the next poll: %v", sub.Mailbox, err)
+	}
+	return nil
+}
+
+// subscribe creates a provider subscription for sub's mailbox and updates sub,
+// which is left lapsed on failure
+func (s *PushIngestionService) subscribe(ctx context.Context, subscriber ports.MailSubscriber, sub *domain.MailSubscription) error {
+	clientState, err := newClientState()
+	if err == nil {
+		var created domain.MailSubscription
+		created, err = subscriber.Subscribe(ctx, sub.Mailbox, clientState)
+		if err == nil {
+			sub.ExternalID = created.ExternalID
+			sub.ClientState = created.ClientState
+			if sub.NeedsClientState() {
+				sub.ClientState = clientState // Ours, whatever the subscriber reports
+			}
+			sub.Cursor = created.Cursor
+			sub.ExpiresAt = created.ExpiresAt
+			sub.Status = domain.SubscriptionActive
+			sub.LastError = ""
+			return nil
+		}
+	}
+	sub.Status = domain.SubscriptionLapsed
+	sub.LastError = err.Error()
+	if sub.ExpiresAt.IsZero() {
+		sub.ExpiresAt = s.now()
+	}
+	return err
+}
+
+// PollLapsed polls the mailboxes of a tenant without a live subscription and returns
+// the number of emails stored
+func (s *PushIngestionService) PollLapsed(ctx context.Context, tenantID uuid.UUID) (int, error) {
+	subs, err := s.storage.ListMailSubscriptions(ctx, tenantID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch mail subscriptions: %w", err)
+	}
+
+	stored := 0
+	var failures []error
+	for i := range subs {
+		sub := &subs[i]
+		if sub.Live(s.now()) {
+			continue
+		}
+		n, err := s.poll(ctx, sub)
+		stored += n
+		if err != nil {
+			failures = append(failures, fmt.Errorf("mailbox %s: %w", sub.Mailbox, err))
+			continue
+		}
+		failures = append(failures, s.update(ctx, sub))
+	}
+	return stored, errors.Join(failures...)
+}
+
+// poll fetches and stores a mailbox's mail since it was last synced
+func (s *PushIngestionService) poll(ctx context.Context, sub *domain.MailSubscription) (int, error) {
+	provider, ok := s.providers[sub.Provider]
+	if !ok {
+		return 0, fmt.Errorf("unsupported provider: %s", sub.Provider)
+	}
+	since := sub.SyncedAt
+	if since.IsZero() {
+		since = s.now().Add(-s.cfg.Backfill)
+	}
+
+	started := s.now()
+	emails, err := provider.GetEmails(ctx, sub.TenantID, sub.UserID, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch emails: %w", err)
+	}
+	stored, err := s.storeEmails(ctx, sub, emails)
+	if err != nil {
+		return stored, err
+	}
+	sub.SyncedAt = started
+	return stored, nil
+}
+
+// HandleMailChange ingests the messages a push notification is about
+// Lifecycle notifications update the subscription: removed ones lapse (recreated by
+// the next Reconcile), missed notifications trigger a catch-up poll, and
+// reauthorization renews the subscription right away.
+func (s *PushIngestionService) HandleMailChange(ctx context.Context, change domain.MailChange) error {
+	sub, err := s.storage.GetMailSubscription(ctx, change.Provider, change.SubscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch mail subscription: %w", err)
+	}
+	if sub == nil {
+		return fmt.Errorf("%w: %s %s", domain.ErrUnknownSubscription, change.Provider, change.SubscriptionID)
+	}
+	// A Graph subscription without a client state cannot authenticate its
+	// notifications: it is refused until Reconcile recreates it
+	if sub.NeedsClientState() && (sub.ClientState == "" || subtle.ConstantTimeCompare([]byte(change.ClientState), []byte(sub.ClientState)) != 1) {
+		return fmt.Errorf("%w: subscription %s", domain.ErrClientStateMismatch, change.SubscriptionID)
+	}
+	subscriber, ok := s.subscribers[sub.Provider]
+	if !ok {
+		return fmt.Errorf("unsupported provider: %s", sub.Provider)
+	}
+
+	switch change.Lifecycle {
+	case "":
+		// New mail, handled below
+	case domain.LifecycleRemoved:
+		log.Printf("Mail subscription of %s removed by %s, polling until recreated", sub.Mailbox, sub.Provider)
+		sub.Status = domain.SubscriptionLapsed
+		sub.LastError = "subscription removed by provider"
+		return s.update(ctx, sub)
+	case domain.LifecycleMissed:
+		if _, err := s.poll(ctx, sub); err != nil {
+			return fmt.Errorf("failed to catch up on missed notifications of %s: %w", sub.Mailbox, err)
+		}
+		return s.update(ctx, sub)
+	case domain.LifecycleReauthorize:
+		expiresAt, err := subscriber.Renew(ctx, *sub)
+		if err != nil {
+			return fmt.Errorf("failed to renew mail subscription of %s: %w", sub.Mailbox, err)
+		}
+		sub.ExpiresAt = expiresAt
+		return s.update(ctx, sub)
+	default:
+		log.Printf("Ignoring %s lifecycle notification %q for %s", sub.Provider, change.Lifecycle, sub.Mailbox)
+		return nil
+	}
+
+	now := s.now()
+	emails, cursor, err := subscriber.FetchChanges(ctx, *sub, change)
+	if err != nil {
+		return fmt.Errorf("failed to fetch changes of %s: %w", sub.Mailbox, err)
+	}
+	if _, err := s.storeEmails(ctx, sub, emails); err != nil {
+		return err
+	}
+
+	// Providers notify changes in order (Graph reports missed ones), so the mailbox
+	// is synced up to now. Concurrent notifications may move the cursor back: the
+	// next fetch then repeats changes, which storing ignores.
+	if cursor != "" {
+		sub.Cursor = cursor
+	}
+	sub.LastNotificationAt = &now
+	sub.SyncedAt = now
+	return s.update(ctx, sub)
+}
+
+// storeEmails stores fetched emails as the subscription's user's, unprocessed
+func (s *PushIngestionService) storeEmails(ctx context.Context, sub *domain.MailSubscription, emails []domain.Email) (int, error) {
+	stored := 0
+	for i := range emails {
+		emails[i].TenantID = sub.TenantID
+		emails[i].UserID = sub.UserID
+		if emails[i].IngestedAt.IsZero() {
+			emails[i].IngestedAt = s.now()
+		}
+		if err := s.storage.CreateEmail(ctx, &emails[i]); err != nil {
+			return stored, fmt.Errorf("failed to store email %s: %w", emails[i].ProviderMessageID, err)
+		}
+		stored++
+	}
+	if stored > 0 {
+		log.Printf("Ingested %d emails for %s", stored, sub.Mailbox)
+	}
+	return stored, nil
+}
+
+// update stores a subscription's new state
+func (s *PushIngestionService) update(ctx context.Context, sub *domain.MailSubscription) error {
+	sub.UpdatedAt = s.now()
+	if err := s.storage.UpdateMailSubscription(ctx, sub); err != nil {
+		return fmt.Errorf("failed to update mail subscription of %s: %w", sub.Mailbox, err)
+	}
+	return nil
+}
+
+// newClientState returns a random secret for Graph to echo in notifications
+func newClientState() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate client state: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,363 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/adapters/receiver"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSubscriber is a ports.MailSubscriber behaving like Graph (client state echoed)
+// or Gmail (subscription ID is the mailbox), with switchable failures
+type fakeSubscriber struct {
+	provider        domain.Provider
+	echoClientState bool
+	lifetime        time.Duration
+	failSubscribe   bool
+	failRenew       bool
+	failFetch       bool
+	mu              sync.Mutex
+	subscribed      []string
+	renewed         []string
+	unsubscribed    []string
+}
+
+func (s *fakeSubscriber) Subscribe(ctx context.Context, mailbox, clientState string) (domain.MailSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failSubscribe {
+		return domain.MailSubscription{}, errors.New("subscription quota exceeded")
+	}
+	s.subscribed = append(s.subscribed, mailbox)
+	sub := domain.MailSubscription{ExternalID: uuid.NewString(), ExpiresAt: time.Now().Add(s.lifetime), Cursor: "100"}
+	if s.provider == domain.ProviderGoogle {
+		sub.ExternalID = mailbox // One watch per mailbox
+	}
+	if s.echoClientState {
+		sub.ClientState = clientState
+	}
+	return sub, nil
+}
+
+func (s *fakeSubscriber) Renew(ctx context.Context, sub domain.MailSubscription) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failRenew {
+		return time.Time{}, errors.New("subscription not found")
+	}
+	s.renewed = append(s.renewed, sub.Mailbox)
+	return time.Now().Add(s.lifetime), nil
+}
+
+func (s *fakeSubscriber) Unsubscribe(ctx context.Context, sub domain.MailSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unsubscribed = append(s.unsubscribed, sub.ExternalID)
+	return nil
+}
+
+func (s *fakeSubscriber) FetchChanges(ctx context.Context, sub domain.MailSubscription, change domain.MailChange) ([]domain.Email, string, error) {
+	if s.failFetch {
+		return nil, "", errors.New("throttled")
+	}
+	messageID, cursor := change.MessageID, ""
+	if change.HistoryID != "" {
+		messageID, cursor = "gmail-"+change.HistoryID, change.HistoryID
+	}
+	email := domain.Email{ID: uuid.New(), ProviderMessageID: messageID, Subject: "Invoice", RecipientEmail: sub.Mailbox}
+	return []domain.Email{email}, cursor, nil
+}
+
+// pollingProvider is a ports.EmailProvider recording the polls of each user
+type pollingProvider struct {
+	mu    sync.Mutex
+	polls map[uuid.UUID][]time.Time
+}
+
+func (p *pollingProvider) GetUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error) {
+	return nil, nil
+}
+
+func (p *pollingProvider) GetEmails(ctx context.Context, tenantID, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.polls[userID] = append(p.polls[userID], receivedAfter)
+	return []domain.Email{{ID: uuid.New(), ProviderMessageID: "polled-" + uuid.NewString()[:8], Subject: "Polled"}}, nil
+}
+
+// pushFixture is a push ingestion service for one Microsoft and one Google tenant
+type pushFixture struct {
+	store    *fakeStorage
+	graph    *fakeSubscriber
+	gmail    *fakeSubscriber
+	polling  *pollingProvider
+	service  *PushIngestionService
+	now      time.Time
+	outlook  *domain.Tenant
+	gsuite   *domain.Tenant
+	cfo, ceo domain.User
+}
+
+func newPushFixture(t *testing.T) *pushFixture {
+	f := &pushFixture{
+		store:   newFakeStorage(),
+		graph:   &fakeSubscriber{provider: domain.ProviderMicrosoft, echoClientState: true, lifetime: 70 * time.Hour},
+		gmail:   &fakeSubscriber{provider: domain.ProviderGoogle, lifetime: 7 * 24 * time.Hour},
+		polling: &pollingProvider{polls: make(map[uuid.UUID][]time.Time)},
+		now:     time.Now(),
+		outlook: &domain.Tenant{ID: uuid.New(), Name: "Acme", Provider: domain.ProviderMicrosoft},
+		gsuite:  &domain.Tenant{ID: uuid.New(), Name: "Beta", Provider: domain.ProviderGoogle},
+	}
+	f.cfo = domain.User{ID: uuid.New(), TenantID: f.outlook.ID, Email: "cfo@acme.com", Role: "CFO"}
+	f.ceo = domain.User{ID: uuid.New(), TenantID: f.gsuite.ID, Email: "ceo@beta.com", Role: "CEO"}
+	f.store.addUser(f.cfo)
+	f.store.addUser(f.ceo)
+
+	f.service = NewPushIngestionService(f.store,
+		map[domain.Provider]ports.EmailProvider{domain.ProviderMicrosoft: f.polling, domain.ProviderGoogle: f.polling},
+		map[domain.Provider]ports.MailSubscriber{domain.ProviderMicrosoft: f.graph, domain.ProviderGoogle: f.gmail},
+		PushConfig{},
+	)
+	f.service.now = func() time.Time { return f.now }
+
+	for _, tenant := range []*domain.Tenant{f.outlook, f.gsuite} {
+		created, err := f.service.SubscribeTenant(context.Background(), tenant)
+		require.NoError(t, err)
+		require.Equal(t, 1, created)
+	}
+	return f
+}
+
+// subscription returns the stored subscription of a user
+func (f *pushFixture) subscription(t *testing.T, user domain.User) domain.MailSubscription {
+	subs, err := f.service.ListMailSubscriptions(context.Background(), user.TenantID)
+	require.NoError(t, err)
+	for _, sub := range subs {
+		if sub.UserID == user.ID {
+			return sub
+		}
+	}
+	t.Fatalf("no subscription for %s", user.Email)
+	return domain.MailSubscription{}
+}
+
+// emailCount returns the number of stored emails of a tenant
+func (f *pushFixture) emailCount(tenantID uuid.UUID) int {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+	count := 0
+	for _, email := range f.store.emails {
+		if email.TenantID == tenantID {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPushIngestionService_Receiver(t *testing.T) {
+	f := newPushFixture(t)
+	server := httptest.NewServer(receiver.New(f.service, receiver.Config{GmailToken: "pubsub-token"}))
+	defer server.Close()
+	ctx := context.Background()
+
+	cfoSub := f.subscription(t, f.cfo)
+	graph := receiver.NewGraphSender(server.URL + receiver.GraphPath)
+	require.NoError(t, graph.Validate(ctx))
+
+	tests := []struct {
+		name       string
+		send       func() (int, error)
+		wantStatus int
+		wantEmails int // Stored for both tenants after the notification
+	}{
+		{"graph new message", func() (int, error) {
+			return graph.Notify(ctx, domain.MailChange{SubscriptionID: cfoSub.ExternalID, ClientState: cfoSub.ClientState, MessageID: "AAMkAD1"})
+		}, http.StatusAccepted, 1},
+		{"graph repeated notification", func() (int, error) {
+			return graph.Notify(ctx, domain.MailChange{SubscriptionID: cfoSub.ExternalID, ClientState: cfoSub.ClientState, MessageID: "AAMkAD1"})
+		}, http.StatusAccepted, 1},
+		{"graph forged client state", func() (int, error) {
+			return graph.Notify(ctx, domain.MailChange{SubscriptionID: cfoSub.ExternalID, ClientState: "guess", MessageID: "AAMkAD2"})
+		}, http.StatusAccepted, 1},
+		{"graph unknown subscription", func() (int, error) {
+			return graph.Notify(ctx, domain.MailChange{SubscriptionID: uuid.NewString(), ClientState: cfoSub.ClientState, MessageID: "AAMkAD3"})
+		}, http.StatusAccepted, 1},
+		{"gmail mailbox change", func() (int, error) {
+			return receiver.NewGmailSender(server.URL+receiver.GmailPath, "pubsub-token").Notify(ctx, f.ceo.Email, "101")
+		}, http.StatusNoContent, 2},
+		{"gmail wrong token", func() (int, error) {
+			return receiver.NewGmailSender(server.URL+receiver.GmailPath, "guess").Notify(ctx, f.ceo.Email, "102")
+		}, http.StatusForbidden, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := tt.send()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantEmails, f.emailCount(f.outlook.ID)+f.emailCount(f.gsuite.ID))
+		})
+	}
+
+	// Notifications move the sync point and the Gmail cursor
+	ceoSub := f.subscription(t, f.ceo)
+	assert.Equal(t, "101", ceoSub.Cursor)
+	assert.NotNil(t, ceoSub.LastNotificationAt)
+
+	// A fetch failing asks the provider to send the notification again
+	f.graph.failFetch = true
+	status, err := graph.Notify(ctx, domain.MailChange{SubscriptionID: cfoSub.ExternalID, ClientState: cfoSub.ClientState, MessageID: "AAMkAD4"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestPushIngestionService_Reconcile(t *testing.T) {
+	f := newPushFixture(t)
+	ctx := context.Background()
+
+	// Nothing expires within a day yet
+	stats, err := f.service.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PushStats{}, stats)
+
+	// Two days later, the Graph subscription is due and renewed
+	f.now = f.now.Add(48 * time.Hour)
+	stats, err = f.service.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PushStats{Renewed: 1}, stats)
+	assert.Equal(t, []string{f.cfo.Email}, f.graph.renewed)
+
+	// Renewal fails and so does recreation: the subscription lapses
+	f.now = f.now.Add(72 * time.Hour)
+	previous := f.subscription(t, f.cfo)
+	f.graph.failRenew, f.graph.failSubscribe = true, true
+	stats, err = f.service.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PushStats{Lapsed: 1}, stats)
+	lapsed := f.subscription(t, f.cfo)
+	assert.Equal(t, domain.SubscriptionLapsed, lapsed.Status)
+	assert.Equal(t, "subscription quota exceeded", lapsed.LastError)
+	assert.Equal(t, []string{previous.ExternalID}, f.graph.unsubscribed)
+
+	// Lapsed mailboxes are polled from their sync point; live ones are not
+	stored, err := f.service.PollLapsed(ctx, f.outlook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored)
+	assert.Equal(t, []time.Time{previous.SyncedAt}, f.polling.polls[f.cfo.ID])
+	stored, err = f.service.PollLapsed(ctx, f.gsuite.ID)
+	require.NoError(t, err)
+	assert.Zero(t, stored)
+
+	// Once the provider recovers, the subscription is recreated and catches up
+	f.now = f.now.Add(time.Hour)
+	f.graph.failSubscribe = false
+	stats, err = f.service.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PushStats{Recreated: 1}, stats)
+	recreated := f.subscription(t, f.cfo)
+	assert.Equal(t, domain.SubscriptionActive, recreated.Status)
+	assert.Empty(t, recreated.LastError)
+	assert.NotEqual(t, previous.ExternalID, recreated.ExternalID)
+	assert.NotEqual(t, previous.ClientState, recreated.ClientState)
+	require.Len(t, f.polling.polls[f.cfo.ID], 2)
+	assert.Equal(t, f.now.Add(-time.Hour), f.polling.polls[f.cfo.ID][1], "catch-up from the last poll")
+	assert.Equal(t, 2, f.emailCount(f.outlook.ID))
+}
+
+func TestPushIngestionService_Lifecycle(t *testing.T) {
+	f := newPushFixture(t)
+	ctx := context.Background()
+	sub := f.subscription(t, f.cfo)
+	lifecycle := func(event string) error {
+		return f.service.HandleMailChange(ctx, domain.MailChange{
+			Provider: domain.ProviderMicrosoft, SubscriptionID: sub.ExternalID, ClientState: sub.ClientState, Lifecycle: event,
+		})
+	}
+
+	require.NoError(t, lifecycle(domain.LifecycleReauthorize))
+	assert.Equal(t, []string{f.cfo.Email}, f.graph.renewed)
+
+	require.NoError(t, lifecycle(domain.LifecycleMissed))
+	assert.Len(t, f.polling.polls[f.cfo.ID], 1)
+	assert.Equal(t, 1, f.emailCount(f.outlook.ID))
+
+	require.NoError(t, lifecycle(domain.LifecycleRemoved))
+	assert.Equal(t, domain.SubscriptionLapsed, f.subscription(t, f.cfo).Status)
+
+	f.graph.failRenew = true
+	assert.ErrorContains(t, lifecycle(domain.LifecycleReauthorize), "subscription not found")
+}
+
+func TestPushIngestionService_SubscribeTenant(t *testing.T) {
+	f := newPushFixture(t)
+	ctx := context.Background()
+
+	// Existing subscriptions are kept, new users are subscribed
+	cto := domain.User{ID: uuid.New(), TenantID: f.outlook.ID, Email: "cto@acme.com"}
+	f.store.addUser(cto)
+	created, err := f.service.SubscribeTenant(ctx, f.outlook)
+	require.NoError(t, err)
+	assert.Equal(t, 1, created)
+	assert.Equal(t, []string{f.cfo.Email, cto.Email}, f.graph.subscribed)
+
+	// A failed subscription is stored lapsed, to be polled and retried
+	cso := domain.User{ID: uuid.New(), TenantID: f.outlook.ID, Email: "cso@acme.com"}
+	f.store.addUser(cso)
+	f.graph.failSubscribe = true
+	created, err = f.service.SubscribeTenant(ctx, f.outlook)
+	require.NoError(t, err)
+	assert.Zero(t, created)
+	assert.Equal(t, domain.SubscriptionLapsed, f.subscription(t, cso).Status)
+
+	_, err = f.service.SubscribeTenant(ctx, &domain.Tenant{ID: uuid.New(), Provider: "yahoo"})
+	assert.ErrorContains(t, err, "unsupported provider")
+}
+
+func TestPushIngestionService_ClientStateRequired(t *testing.T) {
+	f := newPushFixture(t)
+	ctx := context.Background()
+	notify := func(sub domain.MailSubscription, clientState string) error {
+		return f.service.HandleMailChange(ctx, domain.MailChange{
+			Provider: domain.ProviderMicrosoft, SubscriptionID: sub.ExternalID, ClientState: clientState, MessageID: "AAMkAD1",
+		})
+	}
+
+	// The client state is ours even when the subscriber does not report it back
+	previous := f.subscription(t, f.cfo)
+	f.graph.echoClientState, f.graph.failRenew = false, true
+	f.now = f.now.Add(72 * time.Hour)
+	stats, err := f.service.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PushStats{Recreated: 1}, stats)
+	sub := f.subscription(t, f.cfo)
+	assert.NotEqual(t, previous.ExternalID, sub.ExternalID)
+	assert.NotEmpty(t, sub.ClientState)
+
+	// A subscription stored without one refuses every notification
+	sub.ClientState = ""
+	require.NoError(t, f.store.UpdateMailSubscription(ctx, &sub))
+	stored := f.emailCount(f.outlook.ID)
+	assert.ErrorIs(t, notify(sub, ""), domain.ErrClientStateMismatch)
+	assert.Equal(t, stored, f.emailCount(f.outlook.ID))
+
+	// and is recreated rather than renewed once due
+	f.graph.failRenew = false
+	f.now = f.now.Add(48 * time.Hour)
+	stats, err = f.service.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, PushStats{Recreated: 1}, stats)
+	recreated := f.subscription(t, f.cfo)
+	assert.NotEmpty(t, recreated.ClientState)
+	assert.Empty(t, f.graph.renewed)
+	assert.NoError(t, notify(recreated, recreated.ClientState))
+}
@@ -0,0 +1,274 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// RemediationService acts on suspicious messages in recipients' mailboxes
+//
+// It handles analysis.completed events (subscribed on the event bus): the tenant's
+// enabled policy for the analysis risk level, if any, is applied through the
+// remediator of the tenant's provider. Analysts can also remediate an email and
+// restore any applied action from the API. Every action, successful or not, is
+// recorded in the audit log.
+//
+// A policy action already applied or restored for an email is not applied again, so
+// redelivered events are harmless and an analyst's restore is not overridden.
+type RemediationService struct {
+	storage     ports.Storage
+	remediators map[domain.Provider]ports.MailboxRemediator
+	now         func() time.Time
+}
+
+// NewRemediationService creates a remediation service with one remediator per provider
+func NewRemediationService(storage ports.Storage, remediators map[domain.Provider]ports.MailboxRemediator) *RemediationService {
+	return &RemediationService{
+		storage:     storage,
+		remediators: remediators,
+		now:         time.Now,
+	}
+}
+
+// SetRemediationPolicy validates and stores a tenant's policy, replacing the one for its risk level
+func (s *RemediationService) SetRemediationPolicy(ctx context.Context, policy *domain.RemediationPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	policy.UpdatedAt = s.now()
+	if err := s.storage.UpsertRemediationPolicy(ctx, policy); err != nil {
+		return fmt.Errorf("failed to store remediation policy: %w", err)
+	}
+	return nil
+}
+
+// ListRemediationPolicies returns a tenant's policies
+func (s *RemediationService) ListRemediationPolicies(ctx context.Context, tenantID uuid.UUID) ([]domain.RemediationPolicy, error) {
+	return s.storage.ListRemediationPolicies(ctx, tenantID)
+}
+
+// ListRemediationActions returns the audit log of an email
+func (s *RemediationService) ListRemediationActions(ctx context.Context, tenantID, emailID uuid.UUID) ([]domain.RemediationAction, error) {
+	return s.storage.ListRemediationActions(ctx, tenantID, emailID)
+}
+
+// HandleEvent applies the policy matching an analysis.completed event; other events are ignored
+func (s *RemediationService) HandleEvent(ctx context.Context, event domain.Event) error {
+	if event.Type != domain.EventAnalysisCompleted {
+		return nil
+	}
+	var payload domain.AnalysisCompletedPayload
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid %s payload: %w", event.Type, err)
+	}
+
+	policies, err := s.storage.ListRemediationPolicies(ctx, event.TenantID)
+	if err != nil {
+		return fmt.Errorf("failed to load remediation policies: %w", err)
+	}
+	var policy *domain.RemediationPolicy
+	for i := range policies {
+		if policies[i].Enabled && policies[i].RiskLevel == payload.RiskLevel {
+			policy = &policies[i]
+			break
+		}
+	}
+	if policy == nil {
+		return nil
+	}
+
+	email, err := s.storage.GetEmail(ctx, event.EmailID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch email %s: %w", event.EmailID, err)
+	}
+	if email == nil {
+		return nil
+	}
+
+	done, err := s.storage.ListRemediationActions(ctx, email.TenantID, email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load remediation actions: %w", err)
+	}
+	taken := make(map[string]bool, len(done))
+	for _, action := range done {
+		if action.Status != domain.RemediationFailed {
+			taken[action.Action] = true
+		}
+	}
+	pending := make([]string, 0, len(policy.Actions))
+	for _, action := range policy.Actions {
+		if !taken[action] {
+			pending = append(pending, action)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	log.Printf("Applying %s remediation policy to email %s: %v", policy.RiskLevel, email.ID, pending)
+	_, err = s.apply(ctx, *email, currentMessage(*email, done), payload.AnalysisID, pending, policy.Label, domain.RemediationActorPolicy)
+	return err
+}
+
+// Remediate applies actions to one of a tenant's emails on behalf of an analyst
+// The audit records are returned even when some actions failed.
+func (s *RemediationService) Remediate(ctx context.Context, tenantID, emailID uuid.UUID, actions []string, label, actor string) ([]domain.RemediationAction, error) {
+	if err := domain.ValidateRemediationActions(actions, label); err != nil {
+		return nil, err
+	}
+	if actor == "" {
+		return nil, fmt.Errorf("remediation needs an actor")
+	}
+	email, err := s.tenantEmail(ctx, tenantID, emailID)
+	if err != nil {
+		return nil, err
+	}
+	done, err := s.storage.ListRemediationActions(ctx, tenantID, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load remediation actions: %w", err)
+	}
+	return s.apply(ctx, *email, currentMessage(*email, done), uuid.Nil, actions, label, actor)
+}
+
+// Restore undoes one of a tenant's applied actions on behalf of an analyst
+func (s *RemediationService) Restore(ctx context.Context, tenantID, actionID uuid.UUID, actor string) (*domain.RemediationAction, error) {
+	if actor == "" {
+		return nil, fmt.Errorf("restore needs an actor")
+	}
+	action, err := s.storage.GetRemediationAction(ctx, tenantID, actionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remediation action %s: %w", actionID, err)
+	}
+	if action == nil {
+		return nil, fmt.Errorf("remediation action %s not found", actionID)
+	}
+	if action.Status != domain.RemediationApplied {
+		return nil, fmt.Errorf("remediation action %s is %s, only applied actions can be restored", actionID, action.Status)
+	}
+
+	email, err := s.tenantEmail(ctx, tenantID, action.EmailID)
+	if err != nil {
+		return nil, err
+	}
+	remediator, err := s.remediator(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	message := messageRef(*email)
+	if action.MessageID != "" {
+		message.MessageID = action.MessageID
+	}
+	if err := remediator.Restore(ctx, message, action.Action, action.Label, action.RestoreState); err != nil {
+		return nil, fmt.Errorf("failed to restore %s of email %s: %w", action.Action, email.ID, err)
+	}
+
+	now := s.now()
+	if err := s.storage.MarkRemediationRestored(ctx, tenantID, actionID, actor, now); err != nil {
+		return nil, fmt.Errorf("failed to record restore: %w", err)
+	}
+	action.Status = domain.RemediationRestored
+	action.RestoredAt = &now
+	action.RestoredBy = actor
+	log.Printf("Restored %s of email %s (by %s)", action.Action, email.ID, actor)
+	return action, nil
+}
+
+// apply performs actions in order on message and records each of them
+// A failed action does not stop the next ones: a banner is still worth adding
+// when the move failed. Actions after a banner target the bannered copy.
+func (s *RemediationService) apply(ctx context.Context, email domain.Email, message domain.MessageRef, analysisID uuid.UUID, actions []string, label, actor string) ([]domain.RemediationAction, error) {
+	remediator, err := s.remediator(ctx, email.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]domain.RemediationAction, 0, len(actions))
+	var failures []error
+	for _, name := range actions {
+		action := domain.RemediationAction{
+			ID:         uuid.New(),
+			TenantID:   email.TenantID,
+			EmailID:    email.ID,
+			AnalysisID: analysisID,
+			Action:     name,
+			MessageID:  message.MessageID,
+			Status:     domain.RemediationApplied,
+			Actor:      actor,
+		}
+		if name == domain.RemediationLabel {
+			action.Label = label
+		}
+
+		restoreState, current, err := remediator.Apply(ctx, message, name, action.Label)
+		if err != nil {
+			action.Status = domain.RemediationFailed
+			action.Error = err.Error()
+			failures = append(failures, fmt.Errorf("%s: %w", name, err))
+		} else if current.MessageID != message.MessageID {
+			action.ReplacedBy = current.MessageID
+			message = current
+		}
+		action.RestoreState = restoreState
+		action.CreatedAt = s.now()
+
+		if err := s.storage.CreateRemediationAction(ctx, &action); err != nil {
+			// The action was applied but cannot be restored from the API
+			failures = append(failures, fmt.Errorf("failed to record %s of email %s: %w", name, email.ID, err))
+		}
+		records = append(records, action)
+	}
+	return records, errors.Join(failures...)
+}
+
+// remediator returns the remediator of a tenant's provider
+func (s *RemediationService) remediator(ctx context.Context, tenantID uuid.UUID) (ports.MailboxRemediator, error) {
+	tenant, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tenant %s: %w", tenantID, err)
+	}
+	if tenant == nil {
+		return nil, fmt.Errorf("tenant %s not found", tenantID)
+	}
+	remediator, ok := s.remediators[tenant.Provider]
+	if !ok {
+		return nil, fmt.Errorf("no remediator for provider %s", tenant.Provider)
+	}
+	return remediator, nil
+}
+
+// tenantEmail fetches an email, checking it belongs to the tenant
+func (s *RemediationService) tenantEmail(ctx context.Context, tenantID, emailID uuid.UUID) (*domain.Email, error) {
+	email, err := s.storage.GetEmail(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch email %s: %w", emailID, err)
+	}
+	if email == nil || email.TenantID != tenantID {
+		return nil, fmt.Errorf("email %s not found", emailID)
+	}
+	return email, nil
+}
+
+// currentMessage locates the message an email's next actions target: the bannered
+// copy while a banner applied to it is not restored, the original message otherwise
+func currentMessage(email domain.Email, done []domain.RemediationAction) domain.MessageRef {
+	message := messageRef(email)
+	for _, action := range done {
+		if action.Status == domain.RemediationApplied && action.ReplacedBy != "" {
+			message.MessageID = action.ReplacedBy
+		}
+	}
+	return message
+}
+
+// messageRef locates an email in its recipient's mailbox
+func messageRef(email domain.Email) domain.MessageRef {
+	return domain.MessageRef{Mailbox: email.RecipientEmail, MessageID: email.ProviderMessageID}
+}
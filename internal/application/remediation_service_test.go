@@ -0,0 +1,251 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingRemediator records remediator calls and fails the actions in failing
+// A banner replaces the message with a copy whose ID is the original's prefixed with "copy:".
+type recordingRemediator struct {
+	mu       sync.Mutex
+	failing  map[string]bool
+	applied  []string
+	targets  []string // "action message ID" of every applied action
+	restored []string
+}
+
+func (r *recordingRemediator) Apply(ctx context.Context, message domain.MessageRef, action, label string) (string, domain.MessageRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing[action] {
+		return "", message, errors.New("mailbox not found")
+	}
+	r.applied = append(r.applied, action)
+	r.targets = append(r.targets, action+" "+message.MessageID)
+	state := "from:" + message.MessageID
+	if action == domain.RemediationBanner {
+		message.MessageID = "copy:" + message.MessageID
+	}
+	return state, message, nil
+}
+
+func (r *recordingRemediator) Restore(ctx context.Context, message domain.MessageRef, action, label, restoreState string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restored = append(r.restored, action+" "+message.MessageID+" "+restoreState)
+	return nil
+}
+
+// remediationFixture is a remediation service for one Microsoft tenant
+type remediationFixture struct {
+	store      *fakeStorage
+	tenantID   uuid.UUID
+	remediator *recordingRemediator
+	service    *RemediationService
+}
+
+func newRemediationFixture() *remediationFixture {
+	f := &remediationFixture{
+		store:      newFakeStorage(),
+		tenantID:   uuid.New(),
+		remediator: &recordingRemediator{failing: make(map[string]bool)},
+	}
+	f.store.tenants[f.tenantID] = &domain.Tenant{ID: f.tenantID, Name: "Acme", Provider: domain.ProviderMicrosoft}
+	f.service = NewRemediationService(f.store, map[domain.Provider]ports.MailboxRemediator{
+		domain.ProviderMicrosoft: f.remediator,
+	})
+	return f
+}
+
+func (f *remediationFixture) setPolicy(t *testing.T, level string, label string, actions ...string) {
+	policy := domain.RemediationPolicy{TenantID: f.tenantID, RiskLevel: level, Actions: actions, Label: label, Enabled: true}
+	require.NoError(t, f.service.SetRemediationPolicy(context.Background(), &policy))
+}
+
+// analyzed stores an email and returns the analysis.completed event of a level
+func (f *remediationFixture) analyzed(t *testing.T, level string) (domain.Email, domain.Event) {
+	email := domain.Email{
+		ID:                uuid.New(),
+		TenantID:          f.tenantID,
+		ProviderMessageID: "AAMkAD" + uuid.NewString()[:8],
+		Subject:           "Wire transfer",
+		RecipientEmail:    "cfo@company.com",
+	}
+	return email, f.store.analyzed(t, email, level)
+}
+
+func TestRemediationService_HandleEvent(t *testing.T) {
+	f := newRemediationFixture()
+	f.setPolicy(t, "critical", "", domain.RemediationBanner, domain.RemediationQuarantine)
+	f.setPolicy(t, "high", "Suspicious", domain.RemediationBanner, domain.RemediationLabel)
+
+	critical, criticalEvent := f.analyzed(t, "critical")
+	_, mediumEvent := f.analyzed(t, "medium")
+	for _, event := range []domain.Event{criticalEvent, mediumEvent, criticalEvent} {
+		require.NoError(t, f.service.HandleEvent(context.Background(), event))
+	}
+
+	// The redelivered event does not apply the policy twice, medium has no policy
+	assert.Equal(t, []string{domain.RemediationBanner, domain.RemediationQuarantine}, f.remediator.applied)
+
+	actions, err := f.service.ListRemediationActions(context.Background(), f.tenantID, critical.ID)
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	for _, action := range actions {
+		assert.Equal(t, domain.RemediationApplied, action.Status)
+		assert.Equal(t, domain.RemediationActorPolicy, action.Actor)
+		assert.NotEqual(t, uuid.Nil, action.AnalysisID)
+	}
+
+	// A restored action stays restored when the event is redelivered
+	_, err = f.service.Restore(context.Background(), f.tenantID, actions[1].ID, "analyst@company.com")
+	require.NoError(t, err)
+	require.NoError(t, f.service.HandleEvent(context.Background(), criticalEvent))
+	assert.Len(t, f.remediator.applied, 2)
+}
+
+func TestRemediationService_BannerCopyTargeted(t *testing.T) {
+	f := newRemediationFixture()
+	f.setPolicy(t, "critical", "", domain.RemediationBanner, domain.RemediationQuarantine)
+	ctx := context.Background()
+
+	email, event := f.analyzed(t, "critical")
+	original, bannered := email.ProviderMessageID, "copy:"+email.ProviderMessageID
+	require.NoError(t, f.service.HandleEvent(ctx, event))
+
+	// The bannered copy replaced the original in the inbox: it is the one quarantined
+	assert.Equal(t, []string{"banner " + original, "quarantine " + bannered}, f.remediator.targets)
+	actions, err := f.service.ListRemediationActions(ctx, f.tenantID, email.ID)
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	assert.Equal(t, original, actions[0].MessageID)
+	assert.Equal(t, bannered, actions[0].ReplacedBy)
+	assert.Equal(t, bannered, actions[1].MessageID)
+	assert.Empty(t, actions[1].ReplacedBy)
+
+	// Later actions, in another run, still target the copy; restores target the
+	// message the action was applied to
+	_, err = f.service.Remediate(ctx, f.tenantID, email.ID, []string{domain.RemediationLabel}, "Reported", "analyst@company.com")
+	require.NoError(t, err)
+	assert.Equal(t, "label "+bannered, f.remediator.targets[2])
+	_, err = f.service.Restore(ctx, f.tenantID, actions[1].ID, "analyst@company.com")
+	require.NoError(t, err)
+	_, err = f.service.Restore(ctx, f.tenantID, actions[0].ID, "analyst@company.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"quarantine " + bannered + " from:" + bannered,
+		"banner " + original + " from:" + original,
+	}, f.remediator.restored)
+
+	// Once the banner is restored, the original is back in the inbox
+	_, err = f.service.Remediate(ctx, f.tenantID, email.ID, []string{domain.RemediationJunk}, "", "analyst@company.com")
+	require.NoError(t, err)
+	assert.Equal(t, "junk "+original, f.remediator.targets[3])
+}
+
+func TestRemediationService_FailedAction(t *testing.T) {
+	f := newRemediationFixture()
+	f.setPolicy(t, "critical", "", domain.RemediationBanner, domain.RemediationQuarantine)
+	f.remediator.failing[domain.RemediationQuarantine] = true
+
+	email, event := f.analyzed(t, "critical")
+	err := f.service.HandleEvent(context.Background(), event)
+	assert.ErrorContains(t, err, "quarantine: mailbox not found")
+
+	// The failure is audited and the banner still applied
+	actions, err := f.service.ListRemediationActions(context.Background(), f.tenantID, email.ID)
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	assert.Equal(t, domain.RemediationApplied, actions[0].Status)
+	assert.Equal(t, domain.RemediationFailed, actions[1].Status)
+	assert.Equal(t, "mailbox not found", actions[1].Error)
+
+	// The redelivered event retries the failed action only
+	f.remediator.failing[domain.RemediationQuarantine] = false
+	require.NoError(t, f.service.HandleEvent(context.Background(), event))
+	assert.Equal(t, []string{domain.RemediationBanner, domain.RemediationQuarantine}, f.remediator.applied)
+}
+
+func TestRemediationService_RemediateAndRestore(t *testing.T) {
+	f := newRemediationFixture()
+	email, _ := f.analyzed(t, "medium")
+
+	actions, err := f.service.Remediate(context.Background(), f.tenantID, email.ID,
+		[]string{domain.RemediationJunk, domain.RemediationLabel}, "Reported", "analyst@company.com")
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	assert.Equal(t, "analyst@company.com", actions[0].Actor)
+	assert.Equal(t, uuid.Nil, actions[0].AnalysisID)
+	assert.Equal(t, "Reported", actions[1].Label)
+
+	restored, err := f.service.Restore(context.Background(), f.tenantID, actions[0].ID, "lead@company.com")
+	require.NoError(t, err)
+	assert.Equal(t, domain.RemediationRestored, restored.Status)
+	assert.Equal(t, "lead@company.com", restored.RestoredBy)
+	assert.NotNil(t, restored.RestoredAt)
+	assert.Equal(t, []string{"junk " + email.ProviderMessageID + " from:" + email.ProviderMessageID}, f.remediator.restored)
+
+	// Restoring twice, or another tenant's action, fails
+	_, err = f.service.Restore(context.Background(), f.tenantID, actions[0].ID, "lead@company.com")
+	assert.ErrorContains(t, err, "is restored")
+	_, err = f.service.Restore(context.Background(), uuid.New(), actions[1].ID, "lead@company.com")
+	assert.ErrorContains(t, err, "not found")
+	assert.Len(t, f.remediator.restored, 1)
+}
+
+func TestRemediationService_Remediate(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []string
+		label   string
+		actor   string
+		wantErr string
+	}{
+		{"quarantine with banner", []string{domain.RemediationBanner, domain.RemediationQuarantine}, "", "analyst@company.com", ""},
+		{"no action", nil, "", "analyst@company.com", "no remediation action"},
+		{"unknown action", []string{"bounce"}, "", "analyst@company.com", "unknown remediation action"},
+		{"two moves", []string{domain.RemediationJunk, domain.RemediationDelete}, "", "analyst@company.com", "at most one"},
+		{"label without name", []string{domain.RemediationLabel}, "", "analyst@company.com", "needs a label"},
+		{"no actor", []string{domain.RemediationJunk}, "", "", "needs an actor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRemediationFixture()
+			email, _ := f.analyzed(t, "high")
+			_, err := f.service.Remediate(context.Background(), f.tenantID, email.ID, tt.actions, tt.label, tt.actor)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Empty(t, f.store.remediationActions)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.actions, f.remediator.applied)
+		})
+	}
+}
+
+func TestRemediationService_SetRemediationPolicy(t *testing.T) {
+	f := newRemediationFixture()
+	err := f.service.SetRemediationPolicy(context.Background(), &domain.RemediationPolicy{
+		TenantID: f.tenantID, RiskLevel: "none", Actions: []string{domain.RemediationBanner},
+	})
+	assert.ErrorContains(t, err, "unknown risk level")
+
+	f.setPolicy(t, "high", "", domain.RemediationBanner)
+	f.setPolicy(t, "high", "", domain.RemediationJunk)
+	policies, err := f.service.ListRemediationPolicies(context.Background(), f.tenantID)
+	require.NoError(t, err)
+	require.Len(t, policies, 1)
+	assert.Equal(t, []string{domain.RemediationJunk}, policies[0].Actions)
+	assert.False(t, policies[0].UpdatedAt.IsZero())
+}
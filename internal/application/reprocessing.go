@@ -0,0 +1,274 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/domain/ratelimit"
+	"github.com/stoik/email-security/internal/domain/resilience"
+)
+
+const (
+	// defaultReprocessRate is the number of emails reprocessed per second when not configured
+	defaultReprocessRate = 50
+
+	// defaultMaxLiveBacklog is the number of claimable live emails above which
+	// reprocessing pauses when not configured
+	defaultMaxLiveBacklog = defaultBatchSize
+
+	// defaultBacklogPause is how long reprocessing waits before checking the live backlog again
+	defaultBacklogPause = 10 * time.Second
+)
+
+// ReprocessRequest configures a reprocessing job: a detector re-run over the emails
+// a tenant received in a time range
+type ReprocessRequest struct {
+	TenantID uuid.UUID
+	From, To time.Time // Received at or after From and before To
+
+	// Detector is the version to run (default: the service's detector). The tenant's
+	// current rules, role overrides, VIPs and sender list apply on top; the tenant's
+	// aggregator only replaces the service's detector's, not a candidate's.
+	Detector *detection.Detector
+
+	// PolicyVersion is the tenant policy version to run with (default: the current
+	// one). Policies are not kept once edited, so a job asking for another version than
+	// the current one is rejected rather than run with a different configuration.
+	PolicyVersion string
+
+	// Promote makes the new analyses current; otherwise they are stored as history
+	// next to the current ones, for comparison. Neither alerts.
+	Promote bool
+
+	// BatchSize is the number of emails read, analyzed and written per page
+	BatchSize int
+
+	// Workers is the number of emails analyzed in parallel (default: number of CPUs)
+	Workers int
+
+	// Rate caps the emails reprocessed per second, leaving database and CPU to live processing
+	Rate float64
+
+	// MaxLiveBacklog pauses the job while live processing has more claimable emails
+	MaxLiveBacklog int
+
+	// BacklogPause is how long to wait before checking the live backlog again
+	BacklogPause time.Duration
+}
+
+// withDefaults fills unset fields
+func (r ReprocessRequest) withDefaults(detector *detection.Detector) ReprocessRequest {
+	if r.Detector == nil {
+		r.Detector = detector
+	}
+	if r.BatchSize <= 0 {
+		r.BatchSize = defaultBatchSize
+	}
+	if r.Workers <= 0 {
+		r.Workers = PoolConfig{}.withDefaults().Workers
+	}
+	if r.Rate <= 0 {
+		r.Rate = defaultReprocessRate
+	}
+	if r.MaxLiveBacklog <= 0 {
+		r.MaxLiveBacklog = defaultMaxLiveBacklog
+	}
+	if r.BacklogPause <= 0 {
+		r.BacklogPause = defaultBacklogPause
+	}
+	return r
+}
+
+// RiskChange is an email whose risk level differs between two analyses: its current
+// and reprocessed ones, or a baseline and a candidate version's (see CompareVersions)
+type RiskChange struct {
+	EmailID        uuid.UUID `json:"email_id"`
+	Subject        string    `json:"subject"`
+	SenderEmail    string    `json:"sender_email"`
+	RecipientEmail string    `json:"recipient_email"`
+	Before         string    `json:"before"` // Risk level of the current (baseline) analysis
+	After          string    `json:"after"`  // Risk level of the reprocessed (candidate) analysis
+	BeforeScore    float64   `json:"before_score"`
+	AfterScore     float64   `json:"after_score"`
+}
+
+// Escalated reports whether the later analysis is more severe
+func (c RiskChange) Escalated() bool {
+	return !domain.RiskLevelAtLeast(c.Before, c.After)
+}
+
+// ReprocessReport summarizes a reprocessing job
+type ReprocessReport struct {
+	TenantID        uuid.UUID     `json:"tenant_id"`
+	DetectorVersion string        `json:"detector_version"`
+	PolicyVersion   string        `json:"policy_version"`
+	From            time.Time     `json:"from"`
+	To              time.Time     `json:"to"`
+	Promoted        bool          `json:"promoted"`
+	Emails          int           `json:"emails"`    // Emails reprocessed and stored
+	Unchanged       int           `json:"unchanged"` // Emails whose risk level did not change
+	Changed         []RiskChange  `json:"changed"`
+	Escalated       int           `json:"escalated"`
+	Deescalated     int           `json:"deescalated"`
+	Pauses          int           `json:"pauses"` // Times the job waited for live processing
+	Elapsed         time.Duration `json:"elapsed"`
+}
+
+// Reprocess re-runs a detector over the emails a tenant received in a time range and
+// reports the emails whose risk level changed
+//
+// Emails are read in pages in reception order with a keyset cursor, so emails
+// arriving meanwhile don't shift pages. Each page is analyzed, compared with the
+// emails' current analyses and stored with the detector's version next to them (see
+// ReprocessRequest.Promote). Reprocessing never writes events: old mail is not
+// alerted on or remediated again.
+//
+// The job yields to live processing: it is rate limited, and pauses while the live
+// backlog is above req.MaxLiveBacklog. A cancelled job returns its partial report;
+// pages already stored stay stored.
+func (s *FraudDetectionService) Reprocess(ctx context.Context, req ReprocessRequest) (report ReprocessReport, err error) {
+	req = req.withDefaults(s.detector)
+	if !req.From.Before(req.To) {
+		return report, fmt.Errorf("invalid reprocessing range: %s is not before %s", req.From, req.To)
+	}
+
+	report = ReprocessReport{
+		TenantID:        req.TenantID,
+		DetectorVersion: req.Detector.Version(),
+		From:            req.From,
+		To:              req.To,
+		Promoted:        req.Promote,
+		Changed:         make([]RiskChange, 0),
+	}
+	start := time.Now()
+	defer func() { report.Elapsed = time.Since(start) }()
+
+	log.Printf("Reprocessing emails of tenant %s received %s to %s with detector %s",
+		req.TenantID, req.From.Format(time.RFC3339), req.To.Format(time.RFC3339), report.DetectorVersion)
+
+	// The tenant's configuration is resolved once per job, so every page is analyzed the same way
+	policy := s.tenantPolicy(ctx, req.TenantID)
+	if req.PolicyVersion != "" && req.PolicyVersion != policy.version {
+		return report, fmt.Errorf("cannot reprocess with policy version %s: tenant %s policy is now at version %s",
+			req.PolicyVersion, req.TenantID, policy.version)
+	}
+	report.PolicyVersion = policy.version
+	detector := policy.apply(req.Detector)
+	if req.Detector == s.detector {
+		detector = policy.applyLive(req.Detector)
+	}
+	limiter := ratelimit.NewTokenBucket(req.Rate, req.BatchSize)
+	cursor := domain.EmailCursor{ReceivedAt: req.From}
+
+	for {
+		if err := s.waitForLiveBacklog(ctx, req, &report); err != nil {
+			return report, err
+		}
+
+		emails, err := s.storage.ListProcessedEmails(ctx, req.TenantID, cursor, req.To, req.BatchSize)
+		if err != nil {
+			return report, fmt.Errorf("failed to list emails to reprocess: %w", err)
+		}
+		if len(emails) == 0 {
+			break
+		}
+		last := emails[len(emails)-1]
+		cursor = domain.EmailCursor{ReceivedAt: last.ReceivedAt, ID: last.ID}
+
+		var wait time.Duration
+		now := time.Now()
+		for range emails {
+			wait = limiter.Reserve(now)
+		}
+		if err := resilience.Sleep(ctx, wait); err != nil {
+			return report, err
+		}
+
+		if err := s.reprocessPage(ctx, req, detector, emails, &report); err != nil {
+			return report, err
+		}
+	}
+
+	log.Printf("Reprocessed %d emails of tenant %s: %d changed risk level (%d escalated, %d de-escalated)",
+		report.Emails, req.TenantID, len(report.Changed), report.Escalated, report.Deescalated)
+	return report, nil
+}
+
+// reprocessPage analyzes one page of emails, compares and stores the analyses
+func (s *FraudDetectionService) reprocessPage(ctx context.Context, req ReprocessRequest, detector *detection.Detector, emails []domain.Email, report *ReprocessReport) error {
+	recipients := s.lookupRecipients(ctx, req.TenantID, emails)
+	analyses, err := analyzeBatch(ctx, emails, recipients, detector, req.Workers)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uuid.UUID, len(emails))
+	for i, email := range emails {
+		ids[i] = email.ID
+	}
+	current, err := s.storage.GetCurrentAnalyses(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to fetch current analyses: %w", err)
+	}
+
+	if err := s.storage.StoreVersionedAnalyses(ctx, analyses, req.Promote); err != nil {
+		return fmt.Errorf("failed to store reprocessed analyses: %w", err)
+	}
+	report.Emails += len(analyses)
+
+	for i, analysis := range analyses {
+		before, ok := current[analysis.EmailID]
+		if !ok || before.RiskLevel == analysis.RiskLevel {
+			report.Unchanged++
+			continue
+		}
+		change := RiskChange{
+			EmailID:        analysis.EmailID,
+			Subject:        emails[i].Subject,
+			SenderEmail:    emails[i].SenderEmail,
+			RecipientEmail: emails[i].RecipientEmail,
+			Before:         before.RiskLevel,
+			After:          analysis.RiskLevel,
+			BeforeScore:    before.RiskScore,
+			AfterScore:     analysis.RiskScore,
+		}
+		if change.Escalated() {
+			report.Escalated++
+		} else {
+			report.Deescalated++
+		}
+		report.Changed = append(report.Changed, change)
+	}
+	return nil
+}
+
+// waitForLiveBacklog blocks while live processing has more than req.MaxLiveBacklog
+// claimable emails across tenants, so reprocessing never delays fresh mail
+// A failed backlog read is logged and does not block the job.
+func (s *FraudDetectionService) waitForLiveBacklog(ctx context.Context, req ReprocessRequest, report *ReprocessReport) error {
+	for {
+		backlog, err := s.storage.GetBacklog(ctx)
+		if err != nil {
+			log.Printf("Failed to fetch live backlog, reprocessing anyway: %v", err)
+			return nil
+		}
+		claimable := 0
+		for _, tenant := range backlog {
+			claimable += tenant.Claimable()
+		}
+		if claimable <= req.MaxLiveBacklog {
+			return nil
+		}
+
+		report.Pauses++
+		log.Printf("Pausing reprocessing: %d live emails waiting for detection", claimable)
+		if err := resilience.Sleep(ctx, req.BacklogPause); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedProcessed adds n emails for a tenant and analyzes them with the test service
+func seedProcessed(t *testing.T, store *fakeStorage, service *FraudDetectionService, tenantID uuid.UUID, n int) {
+	seedTenant(store, tenantID, n)
+	_, err := service.ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 10})
+	require.NoError(t, err)
+}
+
+func TestFraudDetectionService_Reprocess(t *testing.T) {
+	store := newFakeStorage()
+	service := newTestService(store)
+	tenantID := uuid.New()
+	seedProcessed(t, store, service, tenantID, 12)
+
+	// An email outside the range, and four emails whose current analysis underrated them
+	store.addEmail(domain.Email{TenantID: tenantID, SenderEmail: "old@external.com", ReceivedAt: time.Now().Add(-72 * time.Hour)})
+	for _, email := range store.emails {
+		if email.ProcessedAt == nil {
+			now := time.Now()
+			email.ProcessedAt = &now
+		}
+	}
+	for i := 0; i < 4; i++ {
+		store.analyses[i].RiskLevel = "low"
+	}
+	currentLevel := store.analyses[4].RiskLevel
+
+	candidate := detection.NewDetector([]string{"company.com"}, []string{}, detection.WithVersion("1.1.0-rc1"))
+	req := ReprocessRequest{
+		TenantID:  tenantID,
+		From:      time.Now().Add(-24 * time.Hour),
+		To:        time.Now(),
+		Detector:  candidate,
+		BatchSize: 5,
+		Rate:      1000,
+	}
+	events := len(store.outbox)
+	report, err := service.Reprocess(context.Background(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, "1.1.0-rc1", report.DetectorVersion)
+	assert.Equal(t, 12, report.Emails, "the email outside the range is skipped")
+	assert.Equal(t, 8, report.Unchanged)
+	require.Len(t, report.Changed, 4)
+	assert.Equal(t, 4, report.Escalated)
+	assert.Zero(t, report.Deescalated)
+	assert.Equal(t, "low", report.Changed[0].Before)
+	assert.Equal(t, currentLevel, report.Changed[0].After)
+	assert.Equal(t, 3, store.callCount("StoreVersionedAnalyses"), "pages of 5, 5 and 2")
+
+	// Without promotion, the new analyses are history and the current ones are untouched
+	require.Len(t, store.history, 12)
+	assert.Equal(t, "1.1.0-rc1", store.history[0].DetectorVersion)
+	assert.Len(t, store.analyses, 12)
+	assert.Equal(t, "low", store.analyses[0].RiskLevel)
+	assert.Len(t, store.outbox, events, "reprocessing writes no events")
+
+	// Promoted analyses become current
+	req.Promote = true
+	report, err = service.Reprocess(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, report.Changed, 4)
+	current, err := store.GetFraudAnalysisByEmail(context.Background(), store.analyses[0].EmailID)
+	require.NoError(t, err)
+	assert.Equal(t, currentLevel, current.RiskLevel)
+	assert.Equal(t, "1.1.0-rc1", current.DetectorVersion)
+	assert.Len(t, store.outbox, events)
+
+	_, err = service.Reprocess(context.Background(), ReprocessRequest{TenantID: tenantID, From: req.To, To: req.From})
+	assert.ErrorContains(t, err, "invalid reprocessing range")
+}
+
+func TestFraudDetectionService_Reprocess_PolicyVersion(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	service := newTestService(store)
+	tenantID := uuid.New()
+	seedProcessed(t, store, service, tenantID, 3)
+	current := store.analyses[0].PolicyVersion
+	req := ReprocessRequest{TenantID: tenantID, From: time.Now().Add(-24 * time.Hour), To: time.Now(), PolicyVersion: current}
+
+	report, err := service.Reprocess(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, current, report.PolicyVersion)
+	assert.Equal(t, 3, report.Emails)
+
+	// Once the policy changed, the previous version cannot be replayed
+	vip := &domain.VIP{TenantID: tenantID, Email: "ceo@company.com", Name: "Jane Doe"}
+	require.NoError(t, store.UpsertVIP(ctx, vip))
+	calls := store.callCount("StoreVersionedAnalyses")
+	_, err = service.Reprocess(ctx, req)
+	assert.ErrorContains(t, err, "cannot reprocess with policy version "+current)
+	assert.Equal(t, calls, store.callCount("StoreVersionedAnalyses"), "nothing reprocessed")
+}
+
+func TestFraudDetectionService_Reprocess_YieldsToLiveBacklog(t *testing.T) {
+	store := newFakeStorage()
+	service := newTestService(store)
+	tenantID := uuid.New()
+	seedProcessed(t, store, service, tenantID, 5)
+	seedTenant(store, uuid.New(), 3) // Live emails awaiting detection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report, err := service.Reprocess(ctx, ReprocessRequest{
+		TenantID:       tenantID,
+		From:           time.Now().Add(-24 * time.Hour),
+		To:             time.Now(),
+		MaxLiveBacklog: 2,
+		BacklogPause:   10 * time.Millisecond,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, report.Emails, "nothing is reprocessed while live emails wait")
+	assert.Greater(t, report.Pauses, 1)
+
+	// Once live processing catches up, the job runs
+	_, err = service.ProcessConcurrently(context.Background(), PoolConfig{})
+	require.NoError(t, err)
+	report, err = service.Reprocess(context.Background(), ReprocessRequest{
+		TenantID:       tenantID,
+		From:           time.Now().Add(-24 * time.Hour),
+		To:             time.Now(),
+		MaxLiveBacklog: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Emails)
+	assert.Zero(t, report.Pauses)
+}
+
+func TestFraudDetectionService_Reprocess_Throttled(t *testing.T) {
+	store := newFakeStorage()
+	service := newTestService(store)
+	tenantID := uuid.New()
+	seedProcessed(t, store, service, tenantID, 6)
+
+	// A burst of one page, then 2 pages at 100 emails/s: about 40ms
+	start := time.Now()
+	report, err := service.Reprocess(context.Background(), ReprocessRequest{
+		TenantID:  tenantID,
+		From:      time.Now().Add(-24 * time.Hour),
+		To:        time.Now(),
+		BatchSize: 2,
+		Rate:      100,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Emails)
+	assert.GreaterOrEqual(t, time.Since(start), 35*time.Millisecond)
+}
@@ -0,0 +1,200 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/envelope"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// RotationStats reports a RotateMasterKey call
+type RotationStats struct {
+	Rewrapped int // Data keys wrapped again with the active master key
+	Failed    int // Data keys left wrapped with a previous master key
+}
+
+// SecretService encrypts tenant secrets at rest and implements ports.SecretStore
+//
+// Envelope encryption: each tenant gets an AES-256 data key, created on first use
+// and stored wrapped by the key manager's active master key. Secrets are sealed with
+// the data key, bound to the tenant ID. Unwrapped data keys are cached in memory, so
+// the key manager is called once per tenant and process.
+type SecretService struct {
+	storage ports.Storage
+	keys    ports.KeyManager
+	now     func() time.Time
+
+	mu       sync.Mutex
+	dataKeys map[uuid.UUID][]byte
+}
+
+// NewSecretService creates a secret service wrapping data keys with keys
+func NewSecretService(storage ports.Storage, keys ports.KeyManager) *SecretService {
+	return &SecretService{
+		storage:  storage,
+		keys:     keys,
+		now:      time.Now,
+		dataKeys: make(map[uuid.UUID][]byte),
+	}
+}
+
+// Seal encrypts a tenant secret with the tenant's data key
+func (s *SecretService) Seal(ctx context.Context, tenantID uuid.UUID, plaintext string) (string, error) {
+	key, err := s.dataKey(ctx, tenantID, true)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := envelope.Seal(key, []byte(plaintext), tenantID[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to seal secret: %w", err)
+	}
+	return envelope.Encode(sealed), nil
+}
+
+// Open decrypts a tenant secret sealed by Seal
+// Values stored in the clear fail with envelope.ErrNotSealed.
+func (s *SecretService) Open(ctx context.Context, tenantID uuid.UUID, stored string) (string, error) {
+	sealed, err := envelope.Decode(stored)
+	if err != nil {
+		return "", err
+	}
+	key, err := s.dataKey(ctx, tenantID, false)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := envelope.Open(key, sealed, tenantID[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to open secret of tenant %s: %w", tenantID, err)
+	}
+	return string(plaintext), nil
+}
+
+// openSecret opens a tenant secret read from storage
+// A secret stored in the clear before it was sealed is returned as is, and its sealed
+// form handed to reseal so it is stored sealed from then on.
+func openSecret(ctx context.Context, secrets ports.SecretStore, tenantID uuid.UUID, stored string, reseal func(sealed string) error) (string, error) {
+	plaintext, err := secrets.Open(ctx, tenantID, stored)
+	if !errors.Is(err, envelope.ErrNotSealed) {
+		return plaintext, err
+	}
+	sealed, err := secrets.Seal(ctx, tenantID, stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to seal legacy secret: %w", err)
+	}
+	if err := reseal(sealed); err != nil {
+		return "", fmt.Errorf("failed to store sealed legacy secret: %w", err)
+	}
+	return stored, nil
+}
+
+// RotateMasterKey re-wraps, with the active master key, up to limit data keys
+// wrapped with previous ones
+// Secrets are not re-encrypted: their data keys do not change. Rotate the master key
+// in the key manager first; retire the previous one once nothing is left to re-wrap.
+func (s *SecretService) RotateMasterKey(ctx context.Context, limit int) (RotationStats, error) {
+	var stats RotationStats
+	active, err := s.keys.ActiveKeyID(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch active master key: %w", err)
+	}
+	due, err := s.storage.ListDataKeysToRewrap(ctx, active, limit)
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch data keys: %w", err)
+	}
+
+	for _, old := range due {
+		if err := s.rewrap(ctx, old); err != nil {
+			log.Printf("Failed to re-wrap data key of tenant %s: %v", old.TenantID, err)
+			stats.Failed++
+			continue
+		}
+		stats.Rewrapped++
+	}
+	return stats, nil
+}
+
+// rewrap wraps a data key again with the active master key
+func (s *SecretService) rewrap(ctx context.Context, old domain.DataKey) error {
+	key, err := s.keys.UnwrapKey(ctx, old.MasterKeyID, old.WrappedKey)
+	if err != nil {
+		return err
+	}
+	masterKeyID, wrapped, err := s.keys.WrapKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	rewrapped := domain.DataKey{
+		TenantID:    old.TenantID,
+		MasterKeyID: masterKeyID,
+		WrappedKey:  wrapped,
+		CreatedAt:   old.CreatedAt,
+		RotatedAt:   &now,
+	}
+	return s.storage.RewrapTenantDataKey(ctx, old.TenantID, old.MasterKeyID, &rewrapped)
+}
+
+// dataKey returns a tenant's unwrapped data key, creating it if create is set
+func (s *SecretService) dataKey(ctx context.Context, tenantID uuid.UUID, create bool) ([]byte, error) {
+	s.mu.Lock()
+	key, ok := s.dataKeys[tenantID]
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	stored, err := s.storage.GetTenantDataKey(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data key: %w", err)
+	}
+	if stored == nil {
+		if !create {
+			return nil, fmt.Errorf("tenant %s has no data key", tenantID)
+		}
+		if stored, err = s.createDataKey(ctx, tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err = s.keys.UnwrapKey(ctx, stored.MasterKeyID, stored.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of tenant %s: %w", tenantID, err)
+	}
+	s.mu.Lock()
+	s.dataKeys[tenantID] = key
+	s.mu.Unlock()
+	return key, nil
+}
+
+// createDataKey stores a new data key for a tenant and returns the tenant's key, which
+// is another one if a concurrent call stored it first
+func (s *SecretService) createDataKey(ctx context.Context, tenantID uuid.UUID) (*domain.DataKey, error) {
+	key, err := envelope.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	masterKeyID, wrapped, err := s.keys.WrapKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	created := &domain.DataKey{TenantID: tenantID, MasterKeyID: masterKeyID, WrappedKey: wrapped, CreatedAt: s.now()}
+	if err := s.storage.CreateTenantDataKey(ctx, created); err != nil {
+		return nil, fmt.Errorf("failed to store data key: %w", err)
+	}
+
+	stored, err := s.storage.GetTenantDataKey(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data key: %w", err)
+	}
+	if stored == nil {
+		return nil, fmt.Errorf("data key of tenant %s not stored", tenantID)
+	}
+	return stored, nil
+}
@@ -0,0 +1,166 @@
+package application
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/adapters/kms"
+	"github.com/stoik/email-security/internal/domain/envelope"
+)
+
+// newTestSecrets is a secret service with a fresh local master key
+func newTestSecrets(t *testing.T, store *fakeStorage) *SecretService {
+	keys, err := kms.GenerateLocalKMS()
+	require.NoError(t, err)
+	return NewSecretService(store, keys)
+}
+
+func TestSecretService_SealOpen(t *testing.T) {
+	store := newFakeStorage()
+	keys, err := kms.GenerateLocalKMS()
+	require.NoError(t, err)
+	service := NewSecretService(store, keys)
+	ctx := context.Background()
+	tenant, other := uuid.New(), uuid.New()
+
+	sealed, err := service.Seal(ctx, tenant, "client secret")
+	require.NoError(t, err)
+	assert.True(t, envelope.IsSealed(sealed))
+	assert.NotContains(t, sealed, "client secret")
+
+	plaintext, err := service.Open(ctx, tenant, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "client secret", plaintext)
+
+	// One data key per tenant, unwrapped once
+	_, err = service.Seal(ctx, tenant, "another secret")
+	require.NoError(t, err)
+	assert.Len(t, store.dataKeys, 1)
+	assert.Equal(t, 1, store.callCount("CreateTenantDataKey"))
+
+	// Secrets are bound to their tenant
+	_, err = service.Seal(ctx, other, "other secret")
+	require.NoError(t, err)
+	_, err = service.Open(ctx, other, sealed)
+	assert.ErrorIs(t, err, envelope.ErrDecrypt)
+
+	// Values stored in the clear are not accepted
+	_, err = service.Open(ctx, tenant, `{"grant":"client_credentials"}`)
+	assert.ErrorIs(t, err, envelope.ErrNotSealed)
+
+	// Another process opens secrets with the stored data key
+	restarted := NewSecretService(store, keys)
+	plaintext, err = restarted.Open(ctx, tenant, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "client secret", plaintext)
+}
+
+func TestSecretService_Open_NoDataKey(t *testing.T) {
+	keys, err := kms.GenerateLocalKMS()
+	require.NoError(t, err)
+	service := NewSecretService(newFakeStorage(), keys)
+	other := NewSecretService(newFakeStorage(), keys)
+	tenant := uuid.New()
+
+	sealed, err := other.Seal(context.Background(), tenant, "secret")
+	require.NoError(t, err)
+	_, err = service.Open(context.Background(), tenant, sealed)
+	assert.Error(t, err)
+}
+
+func TestSecretService_RotateMasterKey(t *testing.T) {
+	store := newFakeStorage()
+	path := t.TempDir() + "/keys.json"
+	keys, err := kms.LoadLocalKMS(path)
+	require.NoError(t, err)
+	service := NewSecretService(store, keys)
+	ctx := context.Background()
+
+	tenants := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	sealed := make(map[uuid.UUID]string)
+	for _, tenant := range tenants {
+		sealed[tenant], err = service.Seal(ctx, tenant, "secret of "+tenant.String())
+		require.NoError(t, err)
+	}
+	previous, err := keys.ActiveKeyID(ctx)
+	require.NoError(t, err)
+
+	active, err := keys.Rotate()
+	require.NoError(t, err)
+	assert.NotEqual(t, previous, active)
+
+	stats, err := service.RotateMasterKey(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, RotationStats{Rewrapped: 2}, stats)
+	stats, err = service.RotateMasterKey(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, RotationStats{Rewrapped: 1}, stats)
+	stats, err = service.RotateMasterKey(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, RotationStats{}, stats, "nothing left to re-wrap")
+
+	for _, key := range store.dataKeys {
+		assert.Equal(t, active, key.MasterKeyID)
+		assert.NotNil(t, key.RotatedAt)
+	}
+
+	// Once the previous master key is retired, secrets still open: only data keys
+	// were re-wrapped
+	require.NoError(t, keys.Retire(previous))
+	reloaded, err := kms.LoadLocalKMS(path)
+	require.NoError(t, err)
+	reloadedActive, err := reloaded.ActiveKeyID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, active, reloadedActive)
+
+	restarted := NewSecretService(store, reloaded)
+	for _, tenant := range tenants {
+		plaintext, err := restarted.Open(ctx, tenant, sealed[tenant])
+		require.NoError(t, err)
+		assert.Equal(t, "secret of "+tenant.String(), plaintext)
+	}
+}
+
+func TestSecretService_RotateMasterKey_EnvKey(t *testing.T) {
+	store := newFakeStorage()
+	masterKey, err := envelope.NewKey()
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(masterKey)
+	keys, err := kms.NewLocalKMS(encoded)
+	require.NoError(t, err)
+	service := NewSecretService(store, keys)
+	ctx := context.Background()
+
+	tenant := uuid.New()
+	sealed, err := service.Seal(ctx, tenant, "secret")
+	require.NoError(t, err)
+	previous, err := keys.ActiveKeyID(ctx)
+	require.NoError(t, err)
+
+	// A key passed in the environment cannot be rotated: the new one would be lost on
+	// restart
+	_, err = keys.Rotate()
+	assert.ErrorIs(t, err, kms.ErrNotPersisted)
+	assert.ErrorIs(t, keys.Retire(previous), kms.ErrNotPersisted)
+
+	stats, err := service.RotateMasterKey(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, RotationStats{}, stats)
+	assert.Equal(t, 0, store.callCount("RewrapTenantDataKey"))
+	for _, key := range store.dataKeys {
+		assert.Equal(t, previous, key.MasterKeyID)
+		assert.Nil(t, key.RotatedAt)
+	}
+
+	// A restarted process with the same environment still opens secrets
+	reloaded, err := kms.NewLocalKMS(encoded)
+	require.NoError(t, err)
+	plaintext, err := NewSecretService(store, reloaded).Open(ctx, tenant, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", plaintext)
+}
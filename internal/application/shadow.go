@@ -0,0 +1,177 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+)
+
+// shadowBatch runs a candidate detector on a batch and stores its analyses as history
+// Store failures are counted, not returned: the shadow must never break live processing.
+func (s *FraudDetectionService) shadowBatch(
+	ctx context.Context,
+	detector *detection.Detector,
+	emails []domain.Email,
+	recipients map[string]*domain.User,
+	workers int,
+	stats *ProcessingStats,
+) error {
+	analyses, err := analyzeBatch(ctx, emails, recipients, detector, workers)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.StoreVersionedAnalyses(ctx, analyses, false); err != nil {
+		stats.ShadowFailed += len(analyses)
+		log.Printf("Failed to store %d shadow analyses of detector %s: %v", len(analyses), detector.Version(), err)
+		return nil
+	}
+	stats.Shadowed += len(analyses)
+	return nil
+}
+
+// CompareRequest selects the analyses compared by CompareVersions
+type CompareRequest struct {
+	TenantID uuid.UUID
+	From, To time.Time // Emails received at or after From and before To
+
+	// Baseline is the reference detector version (default: the live detector's)
+	Baseline string
+	// Candidate is the detector version evaluated against it, e.g. a shadow detector's
+	Candidate string
+}
+
+// VersionComparison reports how two detector versions judged the same emails
+// An email is flagged when its risk level raises a threat alert (domain.ThreatEventMinLevel).
+type VersionComparison struct {
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Baseline  string    `json:"baseline"`
+	Candidate string    `json:"candidate"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+
+	Compared  int `json:"compared"`   // Emails analyzed by both versions
+	Agreed    int `json:"agreed"`     // Compared emails both versions flag, or both don't
+	SameLevel int `json:"same_level"` // Compared emails given the same risk level
+
+	// NewlyFlagged are flagged by the candidate only, NoLongerFlagged by the baseline
+	// only; Before is the baseline's verdict and After the candidate's
+	NewlyFlagged    []RiskChange `json:"newly_flagged"`
+	NoLongerFlagged []RiskChange `json:"no_longer_flagged"`
+
+	BaselineOnly  int `json:"baseline_only"`  // Emails only the baseline analyzed
+	CandidateOnly int `json:"candidate_only"` // Emails only the candidate analyzed
+}
+
+// AgreementRate returns the share of compared emails both versions agree to flag or not
+func (c VersionComparison) AgreementRate() float64 {
+	if c.Compared == 0 {
+		return 0
+	}
+	return float64(c.Agreed) / float64(c.Compared)
+}
+
+// CompareVersions compares the analyses two detector versions made of a tenant's
+// emails received in a time range, e.g. the live detector and a shadow candidate
+// Each version's latest analysis of an email is used, current or not. Flagged
+// differences are listed with the email's subject, sender and recipient, highest
+// candidate score first.
+func (s *FraudDetectionService) CompareVersions(ctx context.Context, req CompareRequest) (VersionComparison, error) {
+	if req.Baseline == "" {
+		req.Baseline = s.detector.Version()
+	}
+	comparison := VersionComparison{
+		TenantID:        req.TenantID,
+		Baseline:        req.Baseline,
+		Candidate:       req.Candidate,
+		From:            req.From,
+		To:              req.To,
+		NewlyFlagged:    make([]RiskChange, 0),
+		NoLongerFlagged: make([]RiskChange, 0),
+	}
+	if req.Candidate == "" || req.Candidate == req.Baseline {
+		return comparison, fmt.Errorf("candidate version must be set and differ from baseline %q", req.Baseline)
+	}
+
+	baseline, err := s.storage.ListAnalysesByVersion(ctx, req.TenantID, req.Baseline, req.From, req.To)
+	if err != nil {
+		return comparison, fmt.Errorf("failed to fetch analyses of version %s: %w", req.Baseline, err)
+	}
+	candidate, err := s.storage.ListAnalysesByVersion(ctx, req.TenantID, req.Candidate, req.From, req.To)
+	if err != nil {
+		return comparison, fmt.Errorf("failed to fetch analyses of version %s: %w", req.Candidate, err)
+	}
+
+	for emailID, before := range baseline {
+		after, ok := candidate[emailID]
+		if !ok {
+			comparison.BaselineOnly++
+			continue
+		}
+		comparison.Compared++
+		if before.RiskLevel == after.RiskLevel {
+			comparison.SameLevel++
+		}
+
+		wasFlagged := domain.RiskLevelAtLeast(before.RiskLevel, domain.ThreatEventMinLevel)
+		isFlagged := domain.RiskLevelAtLeast(after.RiskLevel, domain.ThreatEventMinLevel)
+		if wasFlagged == isFlagged {
+			comparison.Agreed++
+			continue
+		}
+		change, err := s.riskChange(ctx, before, after)
+		if err != nil {
+			return comparison, err
+		}
+		if isFlagged {
+			comparison.NewlyFlagged = append(comparison.NewlyFlagged, change)
+		} else {
+			comparison.NoLongerFlagged = append(comparison.NoLongerFlagged, change)
+		}
+	}
+	for emailID := range candidate {
+		if _, ok := baseline[emailID]; !ok {
+			comparison.CandidateOnly++
+		}
+	}
+
+	sortChanges(comparison.NewlyFlagged)
+	sortChanges(comparison.NoLongerFlagged)
+	return comparison, nil
+}
+
+// riskChange describes two analyses of an email, with the email's alert fields
+func (s *FraudDetectionService) riskChange(ctx context.Context, before, after domain.FraudAnalysis) (RiskChange, error) {
+	change := RiskChange{
+		EmailID:     before.EmailID,
+		Before:      before.RiskLevel,
+		After:       after.RiskLevel,
+		BeforeScore: before.RiskScore,
+		AfterScore:  after.RiskScore,
+	}
+	email, err := s.storage.GetEmail(ctx, before.EmailID)
+	if err != nil {
+		return change, fmt.Errorf("failed to fetch email %s: %w", before.EmailID, err)
+	}
+	if email != nil {
+		change.Subject = email.Subject
+		change.SenderEmail = email.SenderEmail
+		change.RecipientEmail = email.RecipientEmail
+	}
+	return change, nil
+}
+
+// sortChanges orders changes by candidate score, highest first
+func sortChanges(changes []RiskChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].AfterScore != changes[j].AfterScore {
+			return changes[i].AfterScore > changes[j].AfterScore
+		}
+		return changes[i].EmailID.String() < changes[j].EmailID.String()
+	})
+}
@@ -0,0 +1,146 @@
+package application
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lunchStrategy is a candidate strategy flagging every lunch invitation
+type lunchStrategy struct{}
+
+func (lunchStrategy) Name() string { return "Lunch" }
+
+func (lunchStrategy) Detect(email domain.Email, recipient *domain.User, context *detection.DetectionContext) *domain.Detection {
+	if !strings.Contains(strings.ToLower(email.Subject), "lunch") {
+		return nil
+	}
+	return &domain.Detection{Type: "LUNCH_INVITATION", Confidence: 0.95, Evidence: "Lunch in subject"}
+}
+
+func TestProcessConcurrently_Shadow(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	service := newTestService(store)
+	tenantID := uuid.New()
+	seedTenant(store, tenantID, 4)
+	for i := 0; i < 3; i++ {
+		store.addEmail(domain.Email{
+			TenantID:       tenantID,
+			Subject:        "Team lunch",
+			SenderEmail:    "colleague@company.com",
+			RecipientEmail: "cfo@company.com",
+			BodyPreview:    "See you at noon",
+		})
+	}
+
+	candidate := detection.NewDetector([]string{"company.com"}, []string{},
+		detection.WithStrategies(lunchStrategy{}), detection.WithVersion("1.1.0-rc1"))
+	stats, err := service.ProcessConcurrently(ctx, PoolConfig{Workers: 2, BatchSize: 10, Shadow: candidate})
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, stats.Emails)
+	assert.Equal(t, 7, stats.Shadowed)
+	require.Len(t, store.history, 7, "shadow analyses are stored as history")
+	assert.Equal(t, "1.1.0-rc1", store.history[0].DetectorVersion)
+	assert.Equal(t, store.analyses[0].PolicyVersion, store.history[0].PolicyVersion, "the shadow applies the same tenant policy")
+
+	flagged := 0
+	for _, analysis := range store.analyses {
+		assert.Equal(t, detection.Version, analysis.DetectorVersion)
+		if domain.RiskLevelAtLeast(analysis.RiskLevel, domain.ThreatEventMinLevel) {
+			flagged++
+		}
+	}
+	assert.Equal(t, 4, flagged)
+	assert.Len(t, store.outbox, 7+flagged, "only live analyses write events")
+
+	// The candidate also flags the lunch invitations
+	window := CompareRequest{TenantID: tenantID, From: time.Now().Add(-24 * time.Hour), To: time.Now(), Candidate: "1.1.0-rc1"}
+	comparison, err := service.CompareVersions(ctx, window)
+	require.NoError(t, err)
+	assert.Equal(t, detection.Version, comparison.Baseline)
+	assert.Equal(t, 7, comparison.Compared)
+	assert.Equal(t, 4, comparison.Agreed)
+	assert.InDelta(t, 4.0/7, comparison.AgreementRate(), 1e-9)
+	require.Len(t, comparison.NewlyFlagged, 3)
+	assert.Equal(t, "Team lunch", comparison.NewlyFlagged[0].Subject)
+	assert.True(t, comparison.NewlyFlagged[0].Escalated())
+	assert.Empty(t, comparison.NoLongerFlagged)
+	assert.Zero(t, comparison.BaselineOnly+comparison.CandidateOnly)
+
+	// Compared the other way round, they are no longer flagged
+	reversed := window
+	reversed.Baseline, reversed.Candidate = "1.1.0-rc1", detection.Version
+	comparison, err = service.CompareVersions(ctx, reversed)
+	require.NoError(t, err)
+	assert.Len(t, comparison.NoLongerFlagged, 3)
+	assert.Empty(t, comparison.NewlyFlagged)
+
+	window.Candidate = detection.Version
+	_, err = service.CompareVersions(ctx, window)
+	assert.ErrorContains(t, err, "differ from baseline")
+}
+
+func TestProcessConcurrently_ShadowFailureSparesLiveProcessing(t *testing.T) {
+	store := &failingShadowStorage{fakeStorage: newFakeStorage()}
+	service := NewFraudDetectionService(store, detection.NewDetector([]string{"company.com"}, []string{}), nil, nil)
+	seedTenant(store.fakeStorage, uuid.New(), 5)
+
+	stats, err := service.ProcessConcurrently(context.Background(), PoolConfig{
+		Shadow: detection.NewDetector([]string{"company.com"}, []string{}, detection.WithVersion("broken")),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, stats.Emails)
+	assert.Equal(t, 5, stats.ShadowFailed)
+	assert.Zero(t, stats.Shadowed)
+}
+
+// failingShadowStorage fails every versioned write
+type failingShadowStorage struct {
+	*fakeStorage
+}
+
+func (f *failingShadowStorage) StoreVersionedAnalyses(ctx context.Context, analyses []domain.FraudAnalysis, promote bool) error {
+	return assert.AnError
+}
+
+func TestProcessConcurrently_ShadowKeepsCandidateAggregator(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	aggregators := detection.StandardAggregators()
+	detector := detection.NewDetector([]string{"company.com"}, []string{})
+	service := NewFraudDetectionService(store, detector, nil, aggregators)
+	tenantID := uuid.New()
+	require.NoError(t, store.CreateTenant(ctx, &domain.Tenant{ID: tenantID, Name: "Acme", RiskAggregator: detection.AggregatorNoisyOR}))
+	seedTenant(store, tenantID, 3)
+
+	version := detection.Version + "+" + detection.AggregatorLogOdds
+	candidate := detector.Using(aggregators[detection.AggregatorLogOdds]).Versioned(version)
+	stats, err := service.ProcessConcurrently(ctx, PoolConfig{BatchSize: 10, Shadow: candidate})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Shadowed)
+
+	require.Len(t, store.analyses, 3)
+	require.Len(t, store.history, 3)
+	live := store.analyses[0]
+	assert.Equal(t, detection.AggregatorNoisyOR, live.Aggregator, "live scores with the tenant's aggregator")
+
+	scored := service.tenantPolicy(ctx, tenantID).apply(candidate)
+	recipients, err := store.GetUsersByEmails(ctx, tenantID, []string{"cfo@company.com"})
+	require.NoError(t, err)
+	for _, shadow := range store.history {
+		expected := scored.AnalyzeEmail(*store.emails[shadow.EmailID], recipients["cfo@company.com"])
+		assert.Equal(t, version, shadow.DetectorVersion)
+		assert.Equal(t, detection.AggregatorLogOdds, shadow.Aggregator, "the shadow keeps its own aggregator")
+		assert.InDelta(t, expected.RiskScore, shadow.RiskScore, 1e-9)
+		assert.NotEqual(t, live.RiskScore, shadow.RiskScore)
+	}
+}
@@ -0,0 +1,163 @@
+package application
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/domain/roles"
+	"github.com/stoik/email-security/internal/domain/rules"
+	"github.com/stoik/email-security/internal/domain/senderlist"
+)
+
+// tenantPolicy is a tenant's detection configuration, loaded once and applied to any
+// detector version: the live one, a shadow candidate or one being reprocessed
+type tenantPolicy struct {
+	rules      *detection.RuleStrategy
+	roles      *roles.Classifier
+	senders    *senderlist.List
+	vips       *detection.VIPList
+	thresholds *domain.RiskThresholds
+	aggregator detection.Aggregator
+
+	// version fingerprints the stored configuration, stamped on analyses as PolicyVersion
+	version string
+}
+
+// policyFingerprint is the configuration a policy version is computed from
+type policyFingerprint struct {
+	Rules         []domain.DetectionRule   `json:"rules"`
+	RoleOverrides []domain.RoleOverride    `json:"role_overrides"`
+	SenderList    []domain.SenderListEntry `json:"sender_list"`
+	VIPs          []domain.VIP             `json:"vips"`
+	Thresholds    *domain.RiskThresholds   `json:"vip_risk_thresholds"`
+	Aggregator    string                   `json:"aggregator"`
+}
+
+// tenantPolicy loads a tenant's custom rules, role overrides, sender list, VIPs and
+// risk aggregator
+// Lookup failures fall back to the defaults: scoring with the default aggregator is
+// better than leaving the email unprocessed. The policy version reflects what was
+// actually loaded.
+func (s *FraudDetectionService) tenantPolicy(ctx context.Context, tenantID uuid.UUID) *tenantPolicy {
+	policy := &tenantPolicy{}
+	var fingerprint policyFingerprint
+
+	if stored, err := s.storage.ListDetectionRules(ctx, tenantID); err != nil {
+		log.Printf("Failed to fetch detection rules for tenant %s: %v", tenantID, err)
+	} else {
+		fingerprint.Rules, policy.rules = compileRules(tenantID, stored)
+	}
+	if overrides, err := s.storage.ListRoleOverrides(ctx, tenantID); err != nil {
+		log.Printf("Failed to fetch role overrides for tenant %s, using default classification: %v", tenantID, err)
+	} else if len(overrides) > 0 {
+		fingerprint.RoleOverrides = overrides
+		policy.roles = roles.Default().WithOverrides(overrides)
+	}
+	if entries, err := s.storage.ListSenderListEntries(ctx, tenantID); err != nil {
+		log.Printf("Failed to fetch sender list for tenant %s: %v", tenantID, err)
+	} else if len(entries) > 0 {
+		fingerprint.SenderList = entries
+		policy.senders = senderlist.New(entries)
+	}
+	vips, err := s.storage.ListVIPs(ctx, tenantID)
+	if err != nil {
+		log.Printf("Failed to fetch VIPs for tenant %s: %v", tenantID, err)
+	}
+
+	tenant, err := s.storage.GetTenant(ctx, tenantID)
+	if err != nil {
+		log.Printf("Failed to fetch tenant %s, using default aggregator: %v", tenantID, err)
+		tenant = nil
+	}
+	if len(vips) > 0 {
+		fingerprint.VIPs = vips
+		policy.vips = detection.NewVIPList(vips)
+		if tenant != nil {
+			fingerprint.Thresholds = tenant.VIPRiskThresholds
+			policy.thresholds = tenant.VIPRiskThresholds
+		}
+	}
+	if tenant != nil && tenant.RiskAggregator != "" {
+		if aggregator, ok := s.aggregators[tenant.RiskAggregator]; ok {
+			fingerprint.Aggregator = tenant.RiskAggregator
+			policy.aggregator = aggregator
+		} else {
+			log.Printf("Unknown risk aggregator %q for tenant %s, using default", tenant.RiskAggregator, tenant.Name)
+		}
+	}
+
+	policy.version = policyVersion(fingerprint)
+	return policy
+}
+
+// compileRules compiles a tenant's enabled custom rules, returning the rules in use
+// and their strategy (nil if none)
+// Rules are validated when saved; one that no longer compiles is skipped, not fatal.
+func compileRules(tenantID uuid.UUID, stored []domain.DetectionRule) ([]domain.DetectionRule, *detection.RuleStrategy) {
+	used := make([]domain.DetectionRule, 0, len(stored))
+	compiled := make([]*rules.CompiledRule, 0, len(stored))
+	for _, rule := range stored {
+		if !rule.Enabled {
+			continue
+		}
+		c, err := rules.CompileRule(rule)
+		if err != nil {
+			log.Printf("Skipping invalid detection rule %s for tenant %s: %v", rule.ID, tenantID, err)
+			continue
+		}
+		used = append(used, rule)
+		compiled = append(compiled, c)
+	}
+	if len(compiled) == 0 {
+		return nil, nil
+	}
+	return used, detection.NewRuleStrategy(compiled)
+}
+
+// policyVersion returns a short hash of a policy's configuration: analyses with the
+// same policy version were produced with the same tenant configuration
+func policyVersion(fingerprint policyFingerprint) string {
+	raw, err := json.Marshal(fingerprint)
+	if err != nil {
+		return "" // Not reachable: the fingerprint only holds marshalable values
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:6])
+}
+
+// applyLive returns the live detector configured with the policy, scoring with the
+// tenant's aggregator; base is not modified
+func (p *tenantPolicy) applyLive(base *detection.Detector) *detection.Detector {
+	detector := p.apply(base)
+	if p.aggregator != nil {
+		detector = detector.Using(p.aggregator)
+	}
+	return detector
+}
+
+// apply returns base configured with the policy, keeping its own aggregator; base is
+// not modified
+// Candidate detectors (shadow, reprocessing) go through apply so that one evaluating
+// another aggregator still scores with it.
+func (p *tenantPolicy) apply(base *detection.Detector) *detection.Detector {
+	detector := base.Governed(p.version)
+	if p.rules != nil {
+		detector = detector.Extended(p.rules)
+	}
+	if p.roles != nil {
+		detector = detector.Classifying(p.roles)
+	}
+	if p.senders != nil {
+		detector = detector.Listing(p.senders)
+	}
+	if p.vips != nil {
+		detector = detector.Protecting(p.vips, p.thresholds)
+	}
+	return detector
+}
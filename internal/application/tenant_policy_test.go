@@ -0,0 +1,33 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFraudDetectionService_PolicyVersion(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	tenant := &domain.Tenant{ID: uuid.New(), Name: "Acme", Provider: domain.ProviderMicrosoft, Status: domain.TenantActive}
+	require.NoError(t, store.CreateTenant(ctx, tenant))
+	service := newTestService(store)
+	email := domain.Email{ID: uuid.New(), TenantID: tenant.ID, SenderEmail: "partner@external.com", Subject: "Hello"}
+
+	analysis := service.detectorForTenant(ctx, tenant.ID).AnalyzeEmail(email, nil)
+	assert.Equal(t, detection.Version, analysis.DetectorVersion)
+	require.Len(t, analysis.PolicyVersion, 12)
+	assert.Equal(t, analysis.PolicyVersion, service.detectorForTenant(ctx, tenant.ID).AnalyzeEmail(email, nil).PolicyVersion,
+		"the same configuration is the same policy version")
+	other := service.detectorForTenant(ctx, uuid.New()).AnalyzeEmail(email, nil).PolicyVersion
+	assert.Equal(t, analysis.PolicyVersion, other, "tenants without customization share the default policy")
+
+	require.NoError(t, service.SetVIP(ctx, &domain.VIP{TenantID: tenant.ID, Email: "john.smith@company.com", Name: "John Smith"}))
+	changed := service.detectorForTenant(ctx, tenant.ID).AnalyzeEmail(email, nil).PolicyVersion
+	assert.NotEqual(t, analysis.PolicyVersion, changed, "configuration changes make a new policy version")
+}
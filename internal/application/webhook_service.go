@@ -0,0 +1,319 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/resilience"
+	"github.com/stoik/email-security/internal/domain/webhook"
+	"github.com/stoik/email-security/internal/ports"
+)
+
+// WebhookConfig configures webhook dispatching
+type WebhookConfig struct {
+	// BatchSize is the number of deliveries claimed per round trip (default: 50)
+	BatchSize int
+
+	// Lease is how long claimed deliveries are reserved for this dispatcher (default: 2 minutes)
+	// It must exceed the sender's request timeout.
+	Lease time.Duration
+
+	// MaxAttempts is the number of requests tried before a delivery is dead-lettered (default: 8)
+	MaxAttempts int
+
+	// BaseBackoff is the delay before the first retry; it doubles with every attempt
+	// up to MaxBackoff (defaults: 10 seconds, 1 hour)
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Owner identifies this dispatcher on its delivery leases (default: hostname, PID and a random suffix)
+	Owner string
+}
+
+// withDefaults fills unset fields
+func (c WebhookConfig) withDefaults() WebhookConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 50
+	}
+	if c.Lease <= 0 {
+		c.Lease = 2 * time.Minute
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 10 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Hour
+	}
+	if c.Owner == "" {
+		c.Owner = newLeaseOwner()
+	}
+	return c
+}
+
+// WebhookStats reports the outcome of dispatching webhook deliveries
+type WebhookStats struct {
+	Delivered    int // Receiver answered 2xx
+	Retried      int // Failed and rescheduled with backoff
+	DeadLettered int // Failed their last attempt
+}
+
+// WebhookService manages customer webhook subscriptions and delivers events to them
+//
+// It is an outbox sink: Publish turns each event into one delivery per matching
+// subscription, stored before the event is acknowledged, so an event reaches every
+// subscriber even if the process stops before sending. DispatchDue then posts due
+// deliveries, signed with the subscription secret (see package webhook), retrying
+// failures with exponential backoff until MaxAttempts. Every request is logged.
+// Signing secrets are sealed by secrets before they are stored.
+type WebhookService struct {
+	storage ports.Storage
+	sender  ports.WebhookSender
+	secrets ports.SecretStore
+	cfg     WebhookConfig
+}
+
+// NewWebhookService creates a webhook service posting through sender and sealing
+// signing secrets with secrets
+func NewWebhookService(storage ports.Storage, sender ports.WebhookSender, secrets ports.SecretStore, cfg WebhookConfig) *WebhookService {
+	return &WebhookService{storage: storage, sender: sender, secrets: secrets, cfg: cfg.withDefaults()}
+}
+
+// CreateWebhookSubscription validates and stores a tenant's subscription
+// A signing secret is generated and set on sub; it is only returned here, so callers
+// show it to the customer once and never log it. Storage only gets it sealed.
+func (s *WebhookService) CreateWebhookSubscription(ctx context.Context, sub *domain.WebhookSubscription) error {
+	if err := sub.Validate(); err != nil {
+		return err
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	sealed, err := s.secrets.Seal(ctx, sub.TenantID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to seal webhook secret: %w", err)
+	}
+	now := time.Now()
+	sub.ID = uuid.New()
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+	stored := *sub
+	stored.Secret = sealed
+	if err := s.storage.CreateWebhookSubscription(ctx, &stored); err != nil {
+		return fmt.Errorf("failed to store webhook subscription: %w", err)
+	}
+	sub.Secret = secret
+	return nil
+}
+
+// ListWebhookSubscriptions returns a tenant's subscriptions; secrets stay sealed
+func (s *WebhookService) ListWebhookSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.WebhookSubscription, error) {
+	return s.storage.ListWebhookSubscriptions(ctx, tenantID)
+}
+
+// DeleteWebhookSubscription removes a tenant's subscription and its delivery log
+func (s *WebhookService) DeleteWebhookSubscription(ctx context.Context, tenantID, subscriptionID uuid.UUID) error {
+	if err := s.storage.DeleteWebhookSubscription(ctx, tenantID, subscriptionID); err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns the delivery log of a subscription, newest first
+func (s *WebhookService) ListWebhookDeliveries(ctx context.Context, tenantID, subscriptionID uuid.UUID, limit int) ([]domain.WebhookDelivery, error) {
+	return s.storage.ListWebhookDeliveries(ctx, tenantID, subscriptionID, limit)
+}
+
+// ListWebhookAttempts returns every request made for a delivery, in order
+func (s *WebhookService) ListWebhookAttempts(ctx context.Context, tenantID, deliveryID uuid.UUID) ([]domain.WebhookAttempt, error) {
+	return s.storage.ListWebhookAttempts(ctx, tenantID, deliveryID)
+}
+
+// Name returns the sink name
+func (s *WebhookService) Name() string {
+	return "webhooks"
+}
+
+// Publish enqueues a delivery of the event for each matching subscription of its tenant
+// Redeliveries of an event are ignored by the storage, so Publish is idempotent.
+// In production, subscriptions would be cached per tenant instead of read per event.
+func (s *WebhookService) Publish(ctx context.Context, event domain.Event) error {
+	if event.TenantID == uuid.Nil {
+		return nil
+	}
+	subs, err := s.storage.ListWebhookSubscriptions(ctx, event.TenantID)
+	if err != nil {
+		return fmt.Errorf("failed to load webhook subscriptions: %w", err)
+	}
+
+	deliveries := make([]domain.WebhookDelivery, 0, len(subs))
+	for _, sub := range subs {
+		if !sub.Matches(event) {
+			continue
+		}
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			ID:             uuid.New(),
+			SubscriptionID: sub.ID,
+			TenantID:       event.TenantID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			DedupKey:       event.DedupKey,
+			Payload:        event.Payload,
+			Status:         domain.WebhookPending,
+			CreatedAt:      time.Now(),
+		})
+	}
+	if err := s.storage.EnqueueWebhookDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// Run dispatches deliveries until ctx is cancelled, polling every interval once none are due
+func (s *WebhookService) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DispatchDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Webhook dispatch failed, retrying in %s: %v", interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue sends due deliveries until none are left
+// Deliveries rescheduled for a later retry are left for a later call.
+func (s *WebhookService) DispatchDue(ctx context.Context) (WebhookStats, error) {
+	var total WebhookStats
+	subs := make(map[uuid.UUID]*domain.WebhookSubscription) // Loaded per tenant, for this call
+	loaded := make(map[uuid.UUID]bool)
+
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+
+		deliveries, err := s.storage.ClaimWebhookDeliveries(ctx, s.cfg.Owner, s.cfg.BatchSize, s.cfg.Lease)
+		if err != nil {
+			return total, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+		}
+		if len(deliveries) == 0 {
+			return total, nil
+		}
+
+		for _, delivery := range deliveries {
+			if !loaded[delivery.TenantID] {
+				tenantSubs, err := s.storage.ListWebhookSubscriptions(ctx, delivery.TenantID)
+				if err != nil {
+					return total, fmt.Errorf("failed to load webhook subscriptions: %w", err)
+				}
+				for i := range tenantSubs {
+					subs[tenantSubs[i].ID] = &tenantSubs[i]
+				}
+				loaded[delivery.TenantID] = true
+			}
+
+			status, err := s.dispatch(ctx, delivery, subs[delivery.SubscriptionID])
+			if err != nil {
+				return total, err
+			}
+			switch status {
+			case domain.WebhookDelivered:
+				total.Delivered++
+			case domain.WebhookDead:
+				total.DeadLettered++
+			default:
+				total.Retried++
+			}
+		}
+		if len(deliveries) < s.cfg.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+// dispatch sends one delivery and records the attempt, returning the delivery's new status
+func (s *WebhookService) dispatch(ctx context.Context, delivery domain.WebhookDelivery, sub *domain.WebhookSubscription) (string, error) {
+	attempt := domain.WebhookAttempt{
+		DeliveryID:  delivery.ID,
+		Attempt:     delivery.Attempts,
+		AttemptedAt: time.Now(),
+	}
+
+	var err error
+	if sub == nil || !sub.Enabled {
+		err = fmt.Errorf("subscription disabled")
+	} else {
+		var secret string
+		if secret, err = s.secret(ctx, sub); err == nil {
+			attempt.StatusCode, err = s.send(ctx, delivery, sub.URL, secret)
+		}
+	}
+	attempt.Duration = time.Since(attempt.AttemptedAt)
+
+	status, retryIn := domain.WebhookDelivered, time.Duration(0)
+	if err != nil {
+		attempt.Error = err.Error()
+		status, retryIn = domain.WebhookPending, resilience.Backoff(s.cfg.BaseBackoff, s.cfg.MaxBackoff, delivery.Attempts)
+		if sub == nil || !sub.Enabled || delivery.Attempts >= s.cfg.MaxAttempts {
+			log.Printf("Dead-lettering webhook delivery %s (%s) after %d attempts: %v",
+				delivery.ID, delivery.DedupKey, delivery.Attempts, err)
+			status, retryIn = domain.WebhookDead, 0
+		}
+	}
+
+	if err := s.storage.RecordWebhookAttempt(ctx, s.cfg.Owner, attempt, status, retryIn); err != nil {
+		// The lease expires and the delivery is sent again: a duplicate, not a loss
+		return status, fmt.Errorf("failed to record webhook attempt %s: %w", delivery.ID, err)
+	}
+	return status, nil
+}
+
+// secret opens a subscription's signing secret, sealing it if it was stored in the clear
+func (s *WebhookService) secret(ctx context.Context, sub *domain.WebhookSubscription) (string, error) {
+	return openSecret(ctx, s.secrets, sub.TenantID, sub.Secret, func(sealed string) error {
+		if err := s.storage.UpdateWebhookSubscriptionSecret(ctx, sub.TenantID, sub.ID, sealed); err != nil {
+			return err
+		}
+		sub.Secret = sealed
+		return nil
+	})
+}
+
+// send posts the signed delivery body to url
+func (s *WebhookService) send(ctx context.Context, delivery domain.WebhookDelivery, url, secret string) (int, error) {
+	body, err := delivery.Body()
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal webhook body: %w", err)
+	}
+	headers := map[string]string{
+		webhook.SignatureHeader: webhook.Sign(secret, time.Now(), body),
+		webhook.IDHeader:        delivery.ID.String(),
+		webhook.EventHeader:     delivery.EventType,
+	}
+	return s.sender.Send(ctx, url, body, headers)
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
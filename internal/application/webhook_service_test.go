@@ -0,0 +1,339 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/adapters/events"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/envelope"
+	"github.com/stoik/email-security/internal/domain/webhook"
+	"github.com/stoik/email-security/internal/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver is a customer endpoint verifying signatures, failing the first failures requests
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	secret   string
+	failures int
+	bodies   []map[string]interface{}
+	invalid  int
+}
+
+func newWebhookReceiver(t *testing.T, failures int) *webhookReceiver {
+	r := &webhookReceiver{failures: failures}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if err := webhook.Verify(r.secret, req.Header.Get(webhook.SignatureHeader), body, time.Now(), webhook.DefaultTolerance); err != nil {
+			r.invalid++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		decoded["header_id"] = req.Header.Get(webhook.IDHeader)
+		r.bodies = append(r.bodies, decoded)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// subscribe creates a subscription to the receiver and lets it verify the generated secret
+func (r *webhookReceiver) subscribe(t *testing.T, service *WebhookService, sub domain.WebhookSubscription) domain.WebhookSubscription {
+	sub.URL = r.URL
+	require.NoError(t, service.CreateWebhookSubscription(context.Background(), &sub))
+	r.mu.Lock()
+	r.secret = sub.Secret
+	r.mu.Unlock()
+	return sub
+}
+
+func newTestWebhookService(t *testing.T, store *fakeStorage, cfg WebhookConfig) *WebhookService {
+	return NewWebhookService(store, events.NewWebhookSender(time.Second), newTestSecrets(t, store), cfg)
+}
+
+func TestWebhookService_CreateWebhookSubscription(t *testing.T) {
+	tests := []struct {
+		name    string
+		sub     domain.WebhookSubscription
+		wantErr string
+	}{
+		{"valid", domain.WebhookSubscription{URL: "https://siem.example.com/hook", EventTypes: []string{domain.EventThreatDetected}, MinRiskLevel: "high"}, ""},
+		{"local http", domain.WebhookSubscription{URL: "http://localhost:8080/hook", EventTypes: []string{domain.EventAnalysisCompleted}}, ""},
+		{"plain http", domain.WebhookSubscription{URL: "http://siem.example.com/hook", EventTypes: []string{domain.EventThreatDetected}}, "must use https"},
+		{"no host", domain.WebhookSubscription{URL: "https://", EventTypes: []string{domain.EventThreatDetected}}, "invalid webhook URL"},
+		{"no event types", domain.WebhookSubscription{URL: "https://siem.example.com/hook"}, "at least one event type"},
+		{"unknown event type", domain.WebhookSubscription{URL: "https://siem.example.com/hook", EventTypes: []string{"email.deleted"}}, "unknown event type"},
+		{"unknown risk level", domain.WebhookSubscription{URL: "https://siem.example.com/hook", EventTypes: []string{domain.EventThreatDetected}, MinRiskLevel: "severe"}, "unknown risk level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStorage()
+			service := newTestWebhookService(t, store, WebhookConfig{})
+			sub := tt.sub
+
+			err := service.CreateWebhookSubscription(context.Background(), &sub)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Empty(t, store.webhookSubs)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEqual(t, uuid.Nil, sub.ID)
+			assert.Regexp(t, `^whsec_[0-9a-f]{64}$`, sub.Secret)
+			require.Len(t, store.webhookSubs, 1)
+			assert.True(t, envelope.IsSealed(store.webhookSubs[0].Secret), "stored sealed")
+		})
+	}
+}
+
+func TestWebhookService_Dispatch_LegacyPlaintextSecret(t *testing.T) {
+	store := newFakeStorage()
+	tenants := seedEmails(store, 4)
+	service := newTestWebhookService(t, store, WebhookConfig{})
+	receiver := newWebhookReceiver(t, 0)
+	// Stored in the clear before secrets were sealed
+	receiver.secret = "whsec_legacy"
+	store.webhookSubs = append(store.webhookSubs, domain.WebhookSubscription{
+		ID: uuid.New(), TenantID: tenants[0], URL: receiver.URL, Secret: "whsec_legacy",
+		EventTypes: []string{domain.EventThreatDetected}, Enabled: true,
+	})
+
+	_, err := newTestService(store).ProcessConcurrently(context.Background(), PoolConfig{})
+	require.NoError(t, err)
+	_, err = NewOutboxRelay(store, []ports.EventSink{service}, RelayConfig{}).Drain(context.Background())
+	require.NoError(t, err)
+
+	stats, err := service.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, WebhookStats{Delivered: 2}, stats, "one threat per email of the first tenant")
+	assert.Zero(t, receiver.invalid, "signed with the legacy secret")
+	assert.True(t, envelope.IsSealed(store.webhookSubs[0].Secret), "sealed in place")
+	assert.Equal(t, 1, store.callCount("UpdateWebhookSubscriptionSecret"))
+}
+
+func TestWebhookService_Dispatch(t *testing.T) {
+	store := newFakeStorage()
+	tenants := seedEmails(store, 6)
+	service := newTestWebhookService(t, store, WebhookConfig{})
+	receiver := newWebhookReceiver(t, 0)
+
+	threats := receiver.subscribe(t, service, domain.WebhookSubscription{
+		TenantID: tenants[0], EventTypes: []string{domain.EventThreatDetected}, MinRiskLevel: "high", Enabled: true,
+	})
+	// Not delivered: disabled, or another tenant's
+	disabled := newWebhookReceiver(t, 0).subscribe(t, service, domain.WebhookSubscription{
+		TenantID: tenants[0], EventTypes: []string{domain.EventThreatDetected},
+	})
+	newWebhookReceiver(t, 0).subscribe(t, service, domain.WebhookSubscription{
+		TenantID: uuid.New(), EventTypes: []string{domain.EventThreatDetected}, Enabled: true,
+	})
+
+	_, err := newTestService(store).ProcessConcurrently(context.Background(), PoolConfig{Workers: 2, BatchSize: 10})
+	require.NoError(t, err)
+	relay := NewOutboxRelay(store, []ports.EventSink{service}, RelayConfig{})
+	_, err = relay.Drain(context.Background())
+	require.NoError(t, err)
+
+	stats, err := service.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, WebhookStats{Delivered: 3}, stats, "one threat per email of the first tenant")
+	assert.Zero(t, receiver.invalid)
+
+	require.Len(t, receiver.bodies, 3)
+	body := receiver.bodies[0]
+	assert.Equal(t, domain.EventThreatDetected, body["type"])
+	assert.Equal(t, tenants[0].String(), body["tenant_id"])
+	assert.Equal(t, body["id"], body["header_id"])
+	data := body["data"].(map[string]interface{})
+	assert.Equal(t, "fake@external.com", data["sender_email"])
+
+	// The delivery log shows each delivery with its attempt
+	log, err := service.ListWebhookDeliveries(context.Background(), tenants[0], threats.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 3)
+	for _, delivery := range log {
+		assert.Equal(t, domain.WebhookDelivered, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.NotNil(t, delivery.DeliveredAt)
+	}
+	attempts, err := service.ListWebhookAttempts(context.Background(), tenants[0], log[0].ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	assert.Equal(t, http.StatusOK, attempts[0].StatusCode)
+
+	empty, err := service.ListWebhookDeliveries(context.Background(), tenants[0], disabled.ID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	// Redelivered outbox events are not sent twice
+	event, err := domain.NewEmailIngestedEvent(domain.Email{TenantID: tenants[0]})
+	require.NoError(t, err)
+	for _, delivery := range log {
+		event.Type, event.DedupKey, event.Payload = delivery.EventType, delivery.DedupKey, delivery.Payload
+		require.NoError(t, service.Publish(context.Background(), event))
+	}
+	stats, err = service.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, stats.Delivered)
+}
+
+func TestWebhookService_Publish(t *testing.T) {
+	tenantID := uuid.New()
+	email := domain.Email{ID: uuid.New(), TenantID: tenantID}
+	ingested, err := domain.NewEmailIngestedEvent(email)
+	require.NoError(t, err)
+	medium, err := domain.NewAnalysisEvents(email, domain.FraudAnalysis{ID: uuid.New(), EmailID: email.ID, RiskLevel: "medium"})
+	require.NoError(t, err)
+	critical, err := domain.NewAnalysisEvents(email, domain.FraudAnalysis{ID: uuid.New(), EmailID: email.ID, RiskLevel: "critical"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		sub   domain.WebhookSubscription
+		event domain.Event
+		want  bool
+	}{
+		{"subscribed type", domain.WebhookSubscription{Enabled: true, EventTypes: []string{domain.EventEmailIngested}}, ingested, true},
+		{"other type", domain.WebhookSubscription{Enabled: true, EventTypes: []string{domain.EventThreatDetected}}, ingested, false},
+		{"no risk level with minimum", domain.WebhookSubscription{Enabled: true, EventTypes: []string{domain.EventEmailIngested}, MinRiskLevel: "low"}, ingested, false},
+		{"below minimum", domain.WebhookSubscription{Enabled: true, EventTypes: []string{domain.EventAnalysisCompleted}, MinRiskLevel: "high"}, medium[0], false},
+		{"at minimum", domain.WebhookSubscription{Enabled: true, EventTypes: []string{domain.EventAnalysisCompleted}, MinRiskLevel: "medium"}, medium[0], true},
+		{"above minimum", domain.WebhookSubscription{Enabled: true, EventTypes: []string{domain.EventThreatDetected}, MinRiskLevel: "high"}, critical[1], true},
+		{"disabled", domain.WebhookSubscription{EventTypes: []string{domain.EventEmailIngested}}, ingested, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStorage()
+			service := newTestWebhookService(t, store, WebhookConfig{})
+			sub := tt.sub
+			sub.TenantID, sub.URL = tenantID, "https://siem.example.com/hook"
+			require.NoError(t, service.CreateWebhookSubscription(context.Background(), &sub))
+
+			require.NoError(t, service.Publish(context.Background(), tt.event))
+			assert.Equal(t, tt.want, len(store.webhookDeliveries) == 1)
+		})
+	}
+}
+
+func TestWebhookService_Retry(t *testing.T) {
+	store := newFakeStorage()
+	tenantID := uuid.New()
+	service := newTestWebhookService(t, store, WebhookConfig{BaseBackoff: time.Millisecond, MaxAttempts: 5})
+	receiver := newWebhookReceiver(t, 2)
+	sub := receiver.subscribe(t, service, domain.WebhookSubscription{
+		TenantID: tenantID, EventTypes: []string{domain.EventEmailIngested}, Enabled: true,
+	})
+
+	event, err := domain.NewEmailIngestedEvent(domain.Email{TenantID: tenantID, Subject: "hello"})
+	require.NoError(t, err)
+	require.NoError(t, service.Publish(context.Background(), event))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		stats, err := service.DispatchDue(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, WebhookStats{Retried: 1}, stats, "attempt %d", attempt)
+
+		// Not due again before its backoff
+		stats, err = service.DispatchDue(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, stats.Retried)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stats, err := service.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, WebhookStats{Delivered: 1}, stats)
+
+	// Retries keep the delivery ID, so receivers can drop duplicates
+	log, err := service.ListWebhookDeliveries(context.Background(), tenantID, sub.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, 3, log[0].Attempts)
+	require.Len(t, receiver.bodies, 1)
+	assert.Equal(t, log[0].ID.String(), receiver.bodies[0]["id"])
+
+	attempts, err := service.ListWebhookAttempts(context.Background(), tenantID, log[0].ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 3)
+	assert.Equal(t, []int{500, 500, 200}, []int{attempts[0].StatusCode, attempts[1].StatusCode, attempts[2].StatusCode})
+	assert.Contains(t, attempts[0].Error, "500")
+	assert.Empty(t, attempts[2].Error)
+}
+
+func TestWebhookService_DeadLetter(t *testing.T) {
+	store := newFakeStorage()
+	tenantID := uuid.New()
+	service := newTestWebhookService(t, store, WebhookConfig{BaseBackoff: time.Nanosecond, MaxAttempts: 3})
+	receiver := newWebhookReceiver(t, 100)
+	sub := receiver.subscribe(t, service, domain.WebhookSubscription{
+		TenantID: tenantID, EventTypes: []string{domain.EventEmailIngested}, Enabled: true,
+	})
+
+	event, err := domain.NewEmailIngestedEvent(domain.Email{TenantID: tenantID, Subject: "hello"})
+	require.NoError(t, err)
+	require.NoError(t, service.Publish(context.Background(), event))
+
+	var total WebhookStats
+	for i := 0; i < 5; i++ {
+		stats, err := service.DispatchDue(context.Background())
+		require.NoError(t, err)
+		total.Retried += stats.Retried
+		total.DeadLettered += stats.DeadLettered
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, WebhookStats{Retried: 2, DeadLettered: 1}, total)
+
+	log, err := service.ListWebhookDeliveries(context.Background(), tenantID, sub.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, domain.WebhookDead, log[0].Status)
+	assert.Equal(t, http.StatusInternalServerError, log[0].LastStatusCode)
+	assert.Nil(t, log[0].DeliveredAt)
+}
+
+func TestWebhookService_SignatureRejected(t *testing.T) {
+	store := newFakeStorage()
+	tenantID := uuid.New()
+	service := newTestWebhookService(t, store, WebhookConfig{MaxAttempts: 1})
+	receiver := newWebhookReceiver(t, 0)
+	receiver.subscribe(t, service, domain.WebhookSubscription{
+		TenantID: tenantID, EventTypes: []string{domain.EventEmailIngested}, Enabled: true,
+	})
+	receiver.secret = "whsec_rotated_by_the_customer"
+
+	event, err := domain.NewEmailIngestedEvent(domain.Email{TenantID: tenantID})
+	require.NoError(t, err)
+	require.NoError(t, service.Publish(context.Background(), event))
+
+	stats, err := service.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, WebhookStats{DeadLettered: 1}, stats)
+	assert.Equal(t, 1, receiver.invalid)
+	assert.Empty(t, receiver.bodies)
+}
@@ -0,0 +1,136 @@
+// Package alerting renders fraud analyses into notifications
+//
+// Messages come from text/template templates: one for an immediate alert and one
+// for a digest batching several. The defaults list every detection with its
+// confidence and evidence; Parse lets a deployment replace them.
+package alerting
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Alert is an analysis to notify about, with its email
+type Alert struct {
+	Email    domain.Email
+	Analysis domain.FraudAnalysis
+}
+
+// Digest batches the alerts a route accumulated since its last digest
+type Digest struct {
+	Route       domain.NotificationRoute
+	Alerts      []Alert // Oldest first, at most MaxDigestAlerts
+	Dropped     int     // Alerts beyond MaxDigestAlerts, counted but not listed
+	RateLimited int     // Alerts diverted from immediate delivery by the rate limit
+}
+
+// MaxDigestAlerts is the number of alerts a digest lists
+const MaxDigestAlerts = 50
+
+// Total returns the number of alerts the digest reports
+func (d Digest) Total() int {
+	return len(d.Alerts) + d.Dropped
+}
+
+// Templates renders alerts and digests
+type Templates struct {
+	alertTitle, alertBody   *template.Template
+	digestTitle, digestBody *template.Template
+}
+
+// Default templates
+// Alert templates execute on an Alert, digest templates on a Digest.
+const (
+	DefaultAlertTitle = `[{{upper .Analysis.RiskLevel}}] Suspicious email to {{.Email.RecipientEmail}}`
+
+	DefaultAlertBody = `From: {{with .Email.SenderName}}{{.}} {{end}}<{{.Email.SenderEmail}}>
+To: {{.Email.RecipientEmail}}
+Subject: {{.Email.Subject}}
+Risk: {{printf "%.2f" .Analysis.RiskScore}} ({{.Analysis.RiskLevel}})
+Detections:
+{{range .Analysis.DetectedThreats}}- {{.Type}} ({{percent .Confidence}}): {{.Evidence}}
+{{end}}`
+
+	DefaultDigestTitle = `{{.Total}} suspicious email{{if ne .Total 1}}s{{end}} for {{.Route.Name}}`
+
+	DefaultDigestBody = `{{range .Alerts}}- [{{.Analysis.RiskLevel}}] {{.Email.Subject}} from <{{.Email.SenderEmail}}> to {{.Email.RecipientEmail}} ({{types .Analysis.DetectedThreats}})
+{{end}}{{with .Dropped}}...and {{.}} more
+{{end}}{{with .RateLimited}}{{.}} of these exceeded the route's alert rate and were held for this digest.
+{{end}}`
+)
+
+var funcs = template.FuncMap{
+	"upper":   strings.ToUpper,
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"types": func(detections []domain.Detection) string {
+		types := make([]string, len(detections))
+		for i, detection := range detections {
+			types[i] = detection.Type
+		}
+		return strings.Join(types, ", ")
+	},
+}
+
+// DefaultTemplates returns the built-in templates
+func DefaultTemplates() *Templates {
+	templates, err := Parse(DefaultAlertTitle, DefaultAlertBody, DefaultDigestTitle, DefaultDigestBody)
+	if err != nil {
+		panic(err) // The defaults are constants covered by tests
+	}
+	return templates
+}
+
+// Parse compiles custom templates; they can use the upper, percent and types functions
+func Parse(alertTitle, alertBody, digestTitle, digestBody string) (*Templates, error) {
+	var t Templates
+	var err error
+	for _, def := range []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{"alert title", alertTitle, &t.alertTitle},
+		{"alert body", alertBody, &t.alertBody},
+		{"digest title", digestTitle, &t.digestTitle},
+		{"digest body", digestBody, &t.digestBody},
+	} {
+		if *def.dst, err = template.New(def.name).Funcs(funcs).Option("missingkey=error").Parse(def.text); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", def.name, err)
+		}
+	}
+	return &t, nil
+}
+
+// RenderAlert renders an immediate alert
+func (t *Templates) RenderAlert(alert Alert) (domain.Notification, error) {
+	return render(t.alertTitle, t.alertBody, alert, alert.Analysis.RiskLevel)
+}
+
+// RenderDigest renders a digest; its severity is the highest risk level it lists
+func (t *Templates) RenderDigest(digest Digest) (domain.Notification, error) {
+	severity := "none"
+	for _, alert := range digest.Alerts {
+		if !domain.RiskLevelAtLeast(severity, alert.Analysis.RiskLevel) {
+			severity = alert.Analysis.RiskLevel
+		}
+	}
+	return render(t.digestTitle, t.digestBody, digest, severity)
+}
+
+func render(title, body *template.Template, data interface{}, severity string) (domain.Notification, error) {
+	var titleText, bodyText strings.Builder
+	if err := title.Execute(&titleText, data); err != nil {
+		return domain.Notification{}, fmt.Errorf("failed to render title: %w", err)
+	}
+	if err := body.Execute(&bodyText, data); err != nil {
+		return domain.Notification{}, fmt.Errorf("failed to render body: %w", err)
+	}
+	return domain.Notification{
+		Title:    strings.TrimSpace(titleText.String()),
+		Text:     strings.TrimSpace(bodyText.String()),
+		Severity: severity,
+	}, nil
+}
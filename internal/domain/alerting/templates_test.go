@@ -0,0 +1,103 @@
+package alerting
+
+import (
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAlert(level, subject string) Alert {
+	return Alert{
+		Email: domain.Email{
+			Subject:        subject,
+			SenderEmail:    "ceo@examp1e.com",
+			SenderName:     "Jane Doe, CEO",
+			RecipientEmail: "cfo@example.com",
+		},
+		Analysis: domain.FraudAnalysis{
+			RiskScore: 0.91,
+			RiskLevel: level,
+			DetectedThreats: []domain.Detection{
+				{Type: "DOMAIN_TYPOSQUATTING", Confidence: 0.9, Evidence: "examp1e.com looks like example.com"},
+				{Type: "DISPLAY_NAME_MISMATCH", Confidence: 0.85, Evidence: "Executive title from external domain"},
+			},
+		},
+	}
+}
+
+func TestTemplates_RenderAlert(t *testing.T) {
+	notification, err := DefaultTemplates().RenderAlert(testAlert("critical", "Wire transfer"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "[CRITICAL] Suspicious email to cfo@example.com", notification.Title)
+	assert.Equal(t, "critical", notification.Severity)
+	assert.Equal(t, `From: Jane Doe, CEO <ceo@examp1e.com>
+To: cfo@example.com
+Subject: Wire transfer
+Risk: 0.91 (critical)
+Detections:
+- DOMAIN_TYPOSQUATTING (90%): examp1e.com looks like example.com
+- DISPLAY_NAME_MISMATCH (85%): Executive title from external domain`, notification.Text)
+}
+
+func TestTemplates_RenderDigest(t *testing.T) {
+	tests := []struct {
+		name         string
+		digest       Digest
+		wantTitle    string
+		wantText     string
+		wantSeverity string
+	}{
+		{
+			name:         "single",
+			digest:       Digest{Route: domain.NotificationRoute{Name: "SOC"}, Alerts: []Alert{testAlert("low", "Invoice")}},
+			wantTitle:    "1 suspicious email for SOC",
+			wantText:     "- [low] Invoice from <ceo@examp1e.com> to cfo@example.com (DOMAIN_TYPOSQUATTING, DISPLAY_NAME_MISMATCH)",
+			wantSeverity: "low",
+		},
+		{
+			name: "dropped and rate limited",
+			digest: Digest{
+				Route:       domain.NotificationRoute{Name: "SOC"},
+				Alerts:      []Alert{testAlert("medium", "Invoice"), testAlert("high", "Wire")},
+				Dropped:     3,
+				RateLimited: 1,
+			},
+			wantTitle: "5 suspicious emails for SOC",
+			wantText: `- [medium] Invoice from <ceo@examp1e.com> to cfo@example.com (DOMAIN_TYPOSQUATTING, DISPLAY_NAME_MISMATCH)
+- [high] Wire from <ceo@examp1e.com> to cfo@example.com (DOMAIN_TYPOSQUATTING, DISPLAY_NAME_MISMATCH)
+...and 3 more
+1 of these exceeded the route's alert rate and were held for this digest.`,
+			wantSeverity: "high",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notification, err := DefaultTemplates().RenderDigest(tt.digest)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTitle, notification.Title)
+			assert.Equal(t, tt.wantText, notification.Text)
+			assert.Equal(t, tt.wantSeverity, notification.Severity)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	templates, err := Parse(`{{.Email.Subject}}`, `{{types .Analysis.DetectedThreats}}`, DefaultDigestTitle, DefaultDigestBody)
+	require.NoError(t, err)
+	notification, err := templates.RenderAlert(testAlert("high", "Hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", notification.Title)
+	assert.Equal(t, "DOMAIN_TYPOSQUATTING, DISPLAY_NAME_MISMATCH", notification.Text)
+
+	_, err = Parse(`{{.Email.Subject`, DefaultAlertBody, DefaultDigestTitle, DefaultDigestBody)
+	assert.ErrorContains(t, err, "invalid alert title template")
+
+	templates, err = Parse(`{{.Email.Missing}}`, DefaultAlertBody, DefaultDigestTitle, DefaultDigestBody)
+	require.NoError(t, err)
+	_, err = templates.RenderAlert(testAlert("high", "Hello"))
+	assert.ErrorContains(t, err, "failed to render title")
+}
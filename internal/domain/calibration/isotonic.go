@@ -0,0 +1,113 @@
+package calibration
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Isotonic is a monotonic, piecewise-linear map from score to probability
+//
+// Fitted with the pool-adjacent-violators algorithm: it makes no assumption about
+// the shape of the curve, only that a higher score never means a lower probability.
+// This suits rule-based confidences that take a handful of discrete values
+// (0.70, 0.85, 0.90...) where a sigmoid would be a poor fit.
+type Isotonic struct {
+	X []float64 `json:"x"` // Block scores, ascending
+	Y []float64 `json:"y"` // Calibrated probability at each X, non-decreasing
+}
+
+// Apply maps a score to a probability, interpolating linearly between blocks
+// and clamping outside the fitted range
+func (iso Isotonic) Apply(score float64) float64 {
+	n := len(iso.X)
+	if n == 0 {
+		return score
+	}
+	if score <= iso.X[0] {
+		return iso.Y[0]
+	}
+	if score >= iso.X[n-1] {
+		return iso.Y[n-1]
+	}
+
+	i := sort.SearchFloat64s(iso.X, score) // First X >= score
+	if iso.X[i] == score {
+		return iso.Y[i]
+	}
+	x0, x1 := iso.X[i-1], iso.X[i]
+	y0, y1 := iso.Y[i-1], iso.Y[i]
+	return y0 + (y1-y0)*(score-x0)/(x1-x0)
+}
+
+// validate checks that blocks pair each X with a Y and that X is strictly ascending,
+// as Apply's search and interpolation assume
+func (iso Isotonic) validate() error {
+	if len(iso.X) == 0 || len(iso.X) != len(iso.Y) {
+		return fmt.Errorf("isotonic map needs as many y as x values, at least one (got %d x, %d y)", len(iso.X), len(iso.Y))
+	}
+	for i := 1; i < len(iso.X); i++ {
+		if !(iso.X[i] > iso.X[i-1]) {
+			return fmt.Errorf("isotonic x values must be strictly ascending (x[%d] = %v after %v)", i, iso.X[i], iso.X[i-1])
+		}
+	}
+	return nil
+}
+
+// FitIsotonic fits an isotonic regression of labels on scores
+func FitIsotonic(scores []float64, labels []bool) Isotonic {
+	if len(scores) == 0 || len(scores) != len(labels) {
+		return Isotonic{}
+	}
+
+	type point struct {
+		score float64
+		label float64
+	}
+	points := make([]point, len(scores))
+	for i, score := range scores {
+		points[i] = point{score: score}
+		if labels[i] {
+			points[i].label = 1
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].score < points[j].score })
+
+	// A block pools consecutive points; its value is the mean label
+	type block struct {
+		sumScore, sumLabel, weight float64
+	}
+	blocks := make([]block, 0, len(points))
+	for i := 0; i < len(points); {
+		// Identical scores must share a value, so they start in the same block
+		b := block{}
+		j := i
+		for ; j < len(points) && points[j].score == points[i].score; j++ {
+			b.sumScore += points[j].score
+			b.sumLabel += points[j].label
+			b.weight++
+		}
+		i = j
+
+		blocks = append(blocks, b)
+		// Pool adjacent violators: merge backwards while the mean decreases
+		for len(blocks) > 1 {
+			last, prev := blocks[len(blocks)-1], blocks[len(blocks)-2]
+			if prev.sumLabel/prev.weight <= last.sumLabel/last.weight {
+				break
+			}
+			blocks = blocks[:len(blocks)-2]
+			blocks = append(blocks, block{
+				sumScore: prev.sumScore + last.sumScore,
+				sumLabel: prev.sumLabel + last.sumLabel,
+				weight:   prev.weight + last.weight,
+			})
+		}
+	}
+
+	iso := Isotonic{X: make([]float64, len(blocks)), Y: make([]float64, len(blocks))}
+	for i, b := range blocks {
+		iso.X[i] = b.sumScore / b.weight
+		iso.Y[i] = b.sumLabel / b.weight
+	}
+	return iso
+}
@@ -0,0 +1,98 @@
+package calibration
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitIsotonic_DiscreteConfidences(t *testing.T) {
+	// A rule that always reports 0.90 but is right only half the time,
+	// and one that reports 0.70 and is right 3 times out of 4
+	scores := []float64{0.9, 0.9, 0.9, 0.9, 0.7, 0.7, 0.7, 0.7}
+	labels := []bool{true, false, true, false, true, true, true, false}
+
+	iso := FitIsotonic(scores, labels)
+
+	// 0.70 (75%) > 0.90 (50%) violates monotonicity, so both pool to 5/8
+	assert.InDelta(t, 0.625, iso.Apply(0.9), 1e-9)
+	assert.InDelta(t, 0.625, iso.Apply(0.7), 1e-9)
+}
+
+func TestFitIsotonic_MonotonicInterpolation(t *testing.T) {
+	scores := []float64{0.1, 0.2, 0.5, 0.6, 0.9, 0.95}
+	labels := []bool{false, false, false, true, true, true}
+
+	iso := FitIsotonic(scores, labels)
+
+	assert.Equal(t, 0.0, iso.Apply(0.0), "clamped below range")
+	assert.Equal(t, 1.0, iso.Apply(1.0), "clamped above range")
+	previous := 0.0
+	for score := 0.0; score <= 1.0; score += 0.05 {
+		value := iso.Apply(score)
+		assert.GreaterOrEqual(t, value, previous)
+		previous = value
+	}
+}
+
+func TestSet_SaveLoadRoundTrip(t *testing.T) {
+	typosquat, err := Fit(MethodIsotonic, []float64{0.9, 0.9, 0.9}, []bool{true, true, false})
+	require.NoError(t, err)
+	risk, err := Fit(MethodPlatt, []float64{0.2, 0.4, 0.9, 1.0}, []bool{false, false, true, true})
+	require.NoError(t, err)
+
+	set := &Set{Version: "test", Aggregator: "weighted_max", Detections: map[string]Map{"DOMAIN_TYPOSQUATTING": typosquat}, RiskScore: &risk}
+
+	var buf bytes.Buffer
+	require.NoError(t, set.Save(&buf))
+	loaded, err := LoadSet(&buf)
+	require.NoError(t, err)
+
+	assert.InDelta(t, 2.0/3.0, loaded.Confidence("DOMAIN_TYPOSQUATTING", 0.9), 1e-9)
+	assert.Equal(t, 0.75, loaded.Confidence("REPLY_TO_MISMATCH", 0.75), "uncalibrated types pass through")
+	assert.InDelta(t, set.Score(0.9), loaded.Score(0.9), 1e-9)
+	assert.True(t, loaded.Fits("weighted_max"))
+	assert.False(t, loaded.Fits("noisy_or"))
+
+	// Sets saved before calibration was keyed by aggregator must be refitted
+	_, err = LoadSet(strings.NewReader(`{"version": "legacy", "detections": {}}`))
+	assert.Error(t, err)
+}
+
+func TestLoadSet_InvalidMaps(t *testing.T) {
+	tests := []struct {
+		name       string
+		detections string
+		riskScore  string
+		wantErr    string
+	}{
+		{"unknown method", `{"X": {"method": "magic"}}`, "", "unknown calibration method"},
+		{"platt without parameters", `{"X": {"method": "platt"}}`, "", "without parameters"},
+		{"isotonic without blocks", `{"X": {"method": "isotonic"}}`, "", "without blocks"},
+		{"isotonic lengths differ", `{"X": {"method": "isotonic", "isotonic": {"x": [0.5, 0.9], "y": [0.2]}}}`, "", "as many y as x"},
+		{"isotonic empty", `{"X": {"method": "isotonic", "isotonic": {"x": [], "y": []}}}`, "", "at least one"},
+		{"isotonic x descending", `{"X": {"method": "isotonic", "isotonic": {"x": [0.9, 0.5], "y": [0.2, 0.8]}}}`, "", "strictly ascending"},
+		{"isotonic x repeated", `{"X": {"method": "isotonic", "isotonic": {"x": [0.5, 0.5], "y": [0.2, 0.8]}}}`, "", "strictly ascending"},
+		{"invalid risk score", `{}`, `{"method": "isotonic", "isotonic": {"x": [0.1, 0.5], "y": [0.2]}}`, "risk score"},
+		{"risk score without parameters", `{}`, `{"method": "platt"}`, "risk score: platt map without parameters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := `{"version": "test", "aggregator": "weighted_max", "detections": ` + tt.detections
+			if tt.riskScore != "" {
+				raw += `, "risk_score": ` + tt.riskScore
+			}
+			_, err := LoadSet(strings.NewReader(raw + "}"))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestFit_UnknownMethod(t *testing.T) {
+	_, err := Fit("magic", nil, nil)
+	assert.Error(t, err)
+}
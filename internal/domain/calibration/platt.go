@@ -0,0 +1,128 @@
+package calibration
+
+import (
+	"math"
+)
+
+// Platt maps a raw score to a probability with a fitted sigmoid: 1 / (1 + exp(-(A*score + B)))
+//
+// Platt scaling is the standard fix for classifiers (naive Bayes in particular)
+// whose raw outputs rank emails correctly but are over- or under-confident.
+// The identity calibration {A: 1, B: 0} is the plain logistic function.
+type Platt struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+// Identity returns the calibration that applies a plain sigmoid to the score
+func Identity() Platt {
+	return Platt{A: 1, B: 0}
+}
+
+// Apply converts a raw score into a calibrated probability
+func (p Platt) Apply(score float64) float64 {
+	return sigmoid(p.A*score + p.B)
+}
+
+// FitPlatt fits sigmoid parameters to (score, label) pairs
+//
+// Uses Platt's smoothed targets (N+ + 1)/(N+ + 2) and 1/(N- + 2) instead of hard
+// 0/1 labels, which keeps the fit finite when classes are perfectly separable,
+// then minimizes log loss with Newton's method (Lin, Lin & Weng 2007).
+// Returns the identity calibration if either class is missing.
+func FitPlatt(scores []float64, labels []bool) Platt {
+	positives, negatives := 0, 0
+	for _, label := range labels {
+		if label {
+			positives++
+		} else {
+			negatives++
+		}
+	}
+	if positives == 0 || negatives == 0 || len(scores) != len(labels) {
+		return Identity()
+	}
+
+	hiTarget := (float64(positives) + 1) / (float64(positives) + 2)
+	loTarget := 1 / (float64(negatives) + 2)
+	targets := make([]float64, len(labels))
+	for i, label := range labels {
+		if label {
+			targets[i] = hiTarget
+		} else {
+			targets[i] = loTarget
+		}
+	}
+
+	// Start from the class prior so the first iterations are already sensible
+	a, b := 0.0, math.Log((float64(positives)+1)/(float64(negatives)+1))
+	const (
+		maxIterations = 100
+		minStep       = 1e-10
+		sigma         = 1e-12 // Keeps the Hessian positive definite
+	)
+
+	// Negative log likelihood of the smoothed targets
+	objective := func(a, b float64) float64 {
+		nll := 0.0
+		for i, score := range scores {
+			f := a*score + b
+			// Numerically stable form of log(1 + exp(-f)) depending on the sign of f
+			if f >= 0 {
+				nll += (1-targets[i])*f + math.Log1p(math.Exp(-f))
+			} else {
+				nll += -targets[i]*f + math.Log1p(math.Exp(f))
+			}
+		}
+		return nll
+	}
+
+	current := objective(a, b)
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		// Gradient and Hessian of the negative log likelihood
+		var h11, h22, h21, g1, g2 float64 = sigma, sigma, 0, 0, 0
+		for i, score := range scores {
+			p := sigmoid(a*score + b)
+			d1 := p - targets[i]
+			d2 := p * (1 - p)
+			h11 += score * score * d2
+			h22 += d2
+			h21 += score * d2
+			g1 += score * d1
+			g2 += d1
+		}
+		if math.Abs(g1) < 1e-5 && math.Abs(g2) < 1e-5 {
+			break
+		}
+
+		det := h11*h22 - h21*h21
+		da := -(h22*g1 - h21*g2) / det
+		db := -(-h21*g1 + h11*g2) / det
+		gd := g1*da + g2*db
+
+		// Backtracking line search
+		step := 1.0
+		for step >= minStep {
+			newA, newB := a+step*da, b+step*db
+			candidate := objective(newA, newB)
+			if candidate < current+0.0001*step*gd {
+				a, b, current = newA, newB, candidate
+				break
+			}
+			step /= 2
+		}
+		if step < minStep {
+			break
+		}
+	}
+
+	return Platt{A: a, B: b}
+}
+
+func sigmoid(x float64) float64 {
+	if x >= 0 {
+		return 1 / (1 + math.Exp(-x))
+	}
+	e := math.Exp(x)
+	return e / (1 + e)
+}
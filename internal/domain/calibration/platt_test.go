@@ -0,0 +1,26 @@
+package calibration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitPlatt_OverconfidentScores(t *testing.T) {
+	// Raw scores are confidently large, but only 3 out of 4 high scores are true positives
+	scores := []float64{8, 8, 8, 8, -8, -8, -8, -8}
+	labels := []bool{true, true, true, false, false, false, false, false}
+
+	platt := FitPlatt(scores, labels)
+
+	assert.InDelta(t, 0.7, platt.Apply(8), 0.1, "high scores should map to the observed precision")
+	assert.InDelta(t, 0.1, platt.Apply(-8), 0.1, "low scores should map close to zero")
+	assert.Less(t, platt.Apply(-8), platt.Apply(8), "calibration must stay monotonic")
+}
+
+func TestFitPlatt_SingleClassReturnsIdentity(t *testing.T) {
+	platt := FitPlatt([]float64{1, 2, 3}, []bool{true, true, true})
+
+	assert.Equal(t, Identity(), platt)
+	assert.InDelta(t, 0.5, platt.Apply(0), 1e-9)
+}
@@ -0,0 +1,139 @@
+package calibration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Calibration methods
+const (
+	MethodPlatt    = "platt"
+	MethodIsotonic = "isotonic"
+)
+
+// Map is a fitted calibration of one score, serialized with its method
+type Map struct {
+	Method   string    `json:"method"`
+	Platt    *Platt    `json:"platt,omitempty"`
+	Isotonic *Isotonic `json:"isotonic,omitempty"`
+
+	// Samples is the number of labeled scores the map was fitted on
+	Samples int `json:"samples"`
+}
+
+// Fit fits a calibration map with the given method
+func Fit(method string, scores []float64, labels []bool) (Map, error) {
+	switch method {
+	case MethodPlatt:
+		platt := FitPlatt(scores, labels)
+		return Map{Method: method, Platt: &platt, Samples: len(scores)}, nil
+	case MethodIsotonic:
+		iso := FitIsotonic(scores, labels)
+		return Map{Method: method, Isotonic: &iso, Samples: len(scores)}, nil
+	default:
+		return Map{}, fmt.Errorf("unknown calibration method %q (expected %q or %q)", method, MethodPlatt, MethodIsotonic)
+	}
+}
+
+// Validate checks that a map read from a file can be applied: its method's parameters
+// are present and well formed
+func (m Map) Validate() error {
+	switch m.Method {
+	case MethodPlatt:
+		if m.Platt == nil {
+			return fmt.Errorf("platt map without parameters")
+		}
+		if math.IsNaN(m.Platt.A) || math.IsInf(m.Platt.A, 0) || math.IsNaN(m.Platt.B) || math.IsInf(m.Platt.B, 0) {
+			return fmt.Errorf("platt parameters must be finite")
+		}
+	case MethodIsotonic:
+		if m.Isotonic == nil {
+			return fmt.Errorf("isotonic map without blocks")
+		}
+		return m.Isotonic.validate()
+	default:
+		return fmt.Errorf("unknown calibration method %q", m.Method)
+	}
+	return nil
+}
+
+// Apply calibrates a score; unknown or empty maps return the score unchanged
+func (m Map) Apply(score float64) float64 {
+	switch {
+	case m.Method == MethodPlatt && m.Platt != nil:
+		return m.Platt.Apply(score)
+	case m.Method == MethodIsotonic && m.Isotonic != nil:
+		return m.Isotonic.Apply(score)
+	default:
+		return score
+	}
+}
+
+// Set groups the calibration maps loaded by the Detector
+//
+// Detections maps a detection type (e.g. "DOMAIN_TYPOSQUATTING") to the map applied
+// to its confidence. RiskScore, when present, is applied to the aggregated score so
+// that risk levels are thresholds on an actual probability of fraud.
+//
+// A set is fitted on the raw scores of one risk aggregator, named by Aggregator: the
+// Detector only applies it when scoring with that aggregator (see Fits).
+type Set struct {
+	Version    string         `json:"version"`
+	FittedAt   time.Time      `json:"fitted_at"`
+	Aggregator string         `json:"aggregator"`
+	Detections map[string]Map `json:"detections"`
+	RiskScore  *Map           `json:"risk_score,omitempty"`
+}
+
+// Fits reports whether the set was fitted on the scores of an aggregator
+func (s *Set) Fits(aggregator string) bool {
+	return s.Aggregator == aggregator
+}
+
+// Confidence calibrates the confidence of a detection type
+func (s *Set) Confidence(detectionType string, confidence float64) float64 {
+	if m, ok := s.Detections[detectionType]; ok {
+		return m.Apply(confidence)
+	}
+	return confidence
+}
+
+// Score calibrates an aggregated risk score
+func (s *Set) Score(score float64) float64 {
+	if s.RiskScore == nil {
+		return score
+	}
+	return s.RiskScore.Apply(score)
+}
+
+// Save writes the calibration set as JSON
+func (s *Set) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// LoadSet reads a calibration set written by Save
+func LoadSet(r io.Reader) (*Set, error) {
+	var set Set
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode calibration set: %w", err)
+	}
+	if set.Aggregator == "" {
+		return nil, fmt.Errorf("calibration set %s does not name the aggregator it was fitted on, refit it with cmd/evaluate", set.Version)
+	}
+	for detectionType, m := range set.Detections {
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("calibration set %s, detection %s: %w", set.Version, detectionType, err)
+		}
+	}
+	if set.RiskScore != nil {
+		if err := set.RiskScore.Validate(); err != nil {
+			return nil, fmt.Errorf("calibration set %s, risk score: %w", set.Version, err)
+		}
+	}
+	return &set, nil
+}
@@ -0,0 +1,50 @@
+package classifier
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Text returns the text of an email the classifier is trained and evaluated on
+//
+// Sender display name is included because impersonation ("CEO John Smith")
+// is a strong BEC signal that never appears in the body.
+func Text(email domain.Email) string {
+	return email.SenderName + "\n" + email.Subject + "\n" + email.BodyPreview
+}
+
+// Features extracts bag-of-n-grams features from text
+//
+// Tokens are lowercased runs of letters and digits; single-character tokens are
+// dropped. Every n-gram from 1 up to maxN is emitted, joined by a space
+// (e.g. "wire", "transfer", "wire transfer").
+func Features(text string, maxN int) []string {
+	tokens := tokenize(text)
+	if maxN < 1 {
+		maxN = 1
+	}
+
+	features := make([]string, 0, len(tokens)*maxN)
+	for n := 1; n <= maxN; n++ {
+		for i := 0; i+n <= len(tokens); i++ {
+			features = append(features, strings.Join(tokens[i:i+n], " "))
+		}
+	}
+	return features
+}
+
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := words[:0]
+	for _, word := range words {
+		if len([]rune(word)) > 1 {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
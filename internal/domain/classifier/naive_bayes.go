@@ -0,0 +1,316 @@
+package classifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain/calibration"
+)
+
+// FormatVersion is the model file format understood by Load
+// Bump when the serialized layout changes in an incompatible way.
+const FormatVersion = 1
+
+// Example is a labeled training document
+type Example struct {
+	Text  string
+	Fraud bool
+}
+
+// TrainOptions configures naive Bayes training
+type TrainOptions struct {
+	// MaxNGram is the largest n-gram size extracted from text (default 2)
+	MaxNGram int
+
+	// MinCount drops terms seen fewer times than this across the corpus (default 2)
+	// Pruning rare terms keeps the model file small and avoids overfitting on typos.
+	MinCount int
+
+	// Alpha is the Laplace smoothing constant (default 1.0)
+	Alpha float64
+
+	// HoldoutFraction of examples is kept out of training to fit the Platt calibration
+	// (default 0.2). NoHoldout trains on every example, without calibration.
+	HoldoutFraction float64
+
+	// Seed makes the train/holdout split reproducible
+	Seed int64
+
+	// Version is a free-form label stored in the model (e.g. "2025-06-01" or a git sha)
+	Version string
+}
+
+// NoHoldout is the HoldoutFraction that trains on the full corpus: zero selects the
+// default fraction, so any negative value disables the holdout
+const NoHoldout = -1.0
+
+func (o TrainOptions) withDefaults() TrainOptions {
+	if o.MaxNGram == 0 {
+		o.MaxNGram = 2
+	}
+	if o.MinCount == 0 {
+		o.MinCount = 2
+	}
+	if o.Alpha == 0 {
+		o.Alpha = 1.0
+	}
+	if o.HoldoutFraction == 0 {
+		o.HoldoutFraction = 0.2
+	}
+	if o.Version == "" {
+		o.Version = time.Now().UTC().Format("20060102T150405Z")
+	}
+	return o
+}
+
+// Model is a multinomial naive Bayes classifier over bag-of-n-grams features
+//
+// Term counts are serialized rather than log probabilities so a model file stays
+// human-inspectable ("how often did 'virement urgent' appear in fraud?").
+type Model struct {
+	FormatVersion int       `json:"format_version"`
+	Version       string    `json:"version"`
+	TrainedAt     time.Time `json:"trained_at"`
+	MaxNGram      int       `json:"max_ngram"`
+	Alpha         float64   `json:"alpha"`
+
+	// Documents and Tokens are indexed by class: [legit, fraud]
+	Documents [2]int `json:"documents"`
+	Tokens    [2]int `json:"tokens"`
+
+	// Terms maps each n-gram to its per-class counts [legit, fraud]
+	Terms map[string][2]int `json:"terms"`
+
+	// Calibration converts raw log-odds into a probability
+	Calibration calibration.Platt `json:"calibration"`
+
+	// Holdout metrics recorded at training time for traceability
+	HoldoutSize     int     `json:"holdout_size"`
+	HoldoutAccuracy float64 `json:"holdout_accuracy"`
+	HoldoutLogLoss  float64 `json:"holdout_log_loss"`
+
+	// Derived at load time
+	logRatio   map[string]float64
+	priorRatio float64
+}
+
+const (
+	legit = 0
+	fraud = 1
+)
+
+// TermContribution is the weight of evidence a single term adds to a prediction
+type TermContribution struct {
+	Term   string  `json:"term"`
+	Weight float64 `json:"weight"` // Log-odds added toward fraud (negative = toward legit)
+}
+
+// Prediction is the classifier output for a single document
+type Prediction struct {
+	Probability float64            `json:"probability"` // Calibrated P(fraud)
+	LogOdds     float64            `json:"log_odds"`    // Raw naive Bayes log-odds
+	TopTerms    []TermContribution `json:"top_terms"`   // Strongest fraud-leaning terms, descending
+}
+
+// Train fits a naive Bayes model from labeled examples
+func Train(examples []Example, opts TrainOptions) (*Model, error) {
+	opts = opts.withDefaults()
+
+	train, holdout := split(examples, opts.HoldoutFraction, opts.Seed)
+	if !hasBothClasses(train) {
+		return nil, errors.New("training set needs at least one fraud and one legit example")
+	}
+
+	counts := make(map[string][2]int)
+	model := &Model{
+		FormatVersion: FormatVersion,
+		Version:       opts.Version,
+		TrainedAt:     time.Now().UTC(),
+		MaxNGram:      opts.MaxNGram,
+		Alpha:         opts.Alpha,
+		Calibration:   calibration.Identity(),
+	}
+
+	for _, example := range train {
+		class := classOf(example.Fraud)
+		model.Documents[class]++
+		for _, term := range Features(example.Text, opts.MaxNGram) {
+			c := counts[term]
+			c[class]++
+			counts[term] = c
+		}
+	}
+
+	model.Terms = make(map[string][2]int, len(counts))
+	for term, c := range counts {
+		if c[legit]+c[fraud] < opts.MinCount {
+			continue
+		}
+		model.Terms[term] = c
+		model.Tokens[legit] += c[legit]
+		model.Tokens[fraud] += c[fraud]
+	}
+	model.prepare()
+
+	// Fit Platt scaling on held-out examples: naive Bayes log-odds are notoriously
+	// overconfident because n-grams are far from independent
+	if len(holdout) > 0 {
+		scores := make([]float64, len(holdout))
+		labels := make([]bool, len(holdout))
+		for i, example := range holdout {
+			scores[i] = model.logOdds(Features(example.Text, model.MaxNGram))
+			labels[i] = example.Fraud
+		}
+		if hasBothClasses(holdout) {
+			model.Calibration = calibration.FitPlatt(scores, labels)
+		}
+		model.HoldoutSize = len(holdout)
+		model.HoldoutAccuracy, model.HoldoutLogLoss = evaluate(model.Calibration, scores, labels)
+	}
+
+	return model, nil
+}
+
+// Predict returns the calibrated fraud probability for text and up to topN contributing terms
+func (m *Model) Predict(text string, topN int) Prediction {
+	features := Features(text, m.MaxNGram)
+	logOdds := m.logOdds(features)
+
+	// Aggregate per distinct term so repeated words show up once with their total weight
+	weights := make(map[string]float64)
+	for _, term := range features {
+		if ratio, ok := m.logRatio[term]; ok {
+			weights[term] += ratio
+		}
+	}
+
+	top := make([]TermContribution, 0, len(weights))
+	for term, weight := range weights {
+		if weight > 0 {
+			top = append(top, TermContribution{Term: term, Weight: weight})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Weight != top[j].Weight {
+			return top[i].Weight > top[j].Weight
+		}
+		return top[i].Term < top[j].Term
+	})
+	if len(top) > topN {
+		top = top[:topN]
+	}
+
+	return Prediction{
+		Probability: m.Calibration.Apply(logOdds),
+		LogOdds:     logOdds,
+		TopTerms:    top,
+	}
+}
+
+// Save writes the model as JSON
+func (m *Model) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// Load reads a model previously written by Save
+func Load(r io.Reader) (*Model, error) {
+	var model Model
+	if err := json.NewDecoder(r).Decode(&model); err != nil {
+		return nil, fmt.Errorf("failed to decode model: %w", err)
+	}
+	if model.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported model format version %d (expected %d)", model.FormatVersion, FormatVersion)
+	}
+	if model.Documents[legit] == 0 || model.Documents[fraud] == 0 {
+		return nil, errors.New("model has no documents for one of the classes")
+	}
+	model.prepare()
+	return &model, nil
+}
+
+// prepare precomputes per-term log-likelihood ratios and the class prior ratio
+func (m *Model) prepare() {
+	vocabulary := float64(len(m.Terms))
+	denominator := [2]float64{
+		float64(m.Tokens[legit]) + m.Alpha*vocabulary,
+		float64(m.Tokens[fraud]) + m.Alpha*vocabulary,
+	}
+
+	m.logRatio = make(map[string]float64, len(m.Terms))
+	for term, c := range m.Terms {
+		pFraud := (float64(c[fraud]) + m.Alpha) / denominator[fraud]
+		pLegit := (float64(c[legit]) + m.Alpha) / denominator[legit]
+		m.logRatio[term] = math.Log(pFraud) - math.Log(pLegit)
+	}
+
+	m.priorRatio = math.Log(float64(m.Documents[fraud])) - math.Log(float64(m.Documents[legit]))
+}
+
+// logOdds computes log P(fraud|doc) - log P(legit|doc), ignoring unknown terms
+func (m *Model) logOdds(features []string) float64 {
+	score := m.priorRatio
+	for _, term := range features {
+		score += m.logRatio[term]
+	}
+	return score
+}
+
+func evaluate(platt calibration.Platt, scores []float64, labels []bool) (accuracy, logLoss float64) {
+	const epsilon = 1e-12
+	correct := 0
+	for i, score := range scores {
+		p := math.Min(math.Max(platt.Apply(score), epsilon), 1-epsilon)
+		if (p >= 0.5) == labels[i] {
+			correct++
+		}
+		if labels[i] {
+			logLoss -= math.Log(p)
+		} else {
+			logLoss -= math.Log(1 - p)
+		}
+	}
+	n := float64(len(scores))
+	return float64(correct) / n, logLoss / n
+}
+
+// split shuffles examples and sets aside a holdout fraction
+// Small corpora (fewer than 10 examples) are not split.
+func split(examples []Example, fraction float64, seed int64) (train, holdout []Example) {
+	if fraction <= 0 || len(examples) < 10 {
+		return examples, nil
+	}
+
+	shuffled := append([]Example(nil), examples...)
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	n := int(float64(len(shuffled)) * fraction)
+	return shuffled[n:], shuffled[:n]
+}
+
+func hasBothClasses(examples []Example) bool {
+	var seenFraud, seenLegit bool
+	for _, example := range examples {
+		if example.Fraud {
+			seenFraud = true
+		} else {
+			seenLegit = true
+		}
+	}
+	return seenFraud && seenLegit
+}
+
+func classOf(isFraud bool) int {
+	if isFraud {
+		return fraud
+	}
+	return legit
+}
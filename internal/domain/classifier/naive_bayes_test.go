@@ -0,0 +1,95 @@
+package classifier
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func trainingExamples() []Example {
+	fraudTemplates := []string{
+		"Urgent wire transfer needed today to new bank account %d",
+		"Please process this payment immediately, keep it confidential %d",
+		"CEO request: buy gift cards now and send me the codes %d",
+		"Virement urgent et confidentiel sur le nouveau compte %d",
+		"Updated bank details for invoice %d, wire transfer asap",
+	}
+	legitTemplates := []string{
+		"Team lunch on Friday, please confirm attendance %d",
+		"Meeting notes from the project review %d",
+		"Quarterly report attached for your review %d",
+		"Reminder: office closed on Monday for maintenance %d",
+		"Welcome our new colleague joining the design team %d",
+	}
+
+	examples := make([]Example, 0)
+	for i := 0; i < 10; i++ {
+		for _, tpl := range fraudTemplates {
+			examples = append(examples, Example{Text: fmt.Sprintf(tpl, i), Fraud: true})
+		}
+		for _, tpl := range legitTemplates {
+			examples = append(examples, Example{Text: fmt.Sprintf(tpl, i), Fraud: false})
+		}
+	}
+	return examples
+}
+
+func TestTrain_SeparatesFraudFromLegit(t *testing.T) {
+	model, err := Train(trainingExamples(), TrainOptions{Seed: 42, Version: "test"})
+	require.NoError(t, err)
+
+	fraudPrediction := model.Predict("URGENT: wire transfer to the new bank account today", 3)
+	legitPrediction := model.Predict("Notes from the project review meeting", 3)
+
+	assert.Greater(t, fraudPrediction.Probability, 0.8)
+	assert.Less(t, legitPrediction.Probability, 0.2)
+	assert.Greater(t, model.HoldoutSize, 0, "calibration should be fitted on a holdout")
+
+	require.NotEmpty(t, fraudPrediction.TopTerms)
+	assert.LessOrEqual(t, len(fraudPrediction.TopTerms), 3)
+	for i := 1; i < len(fraudPrediction.TopTerms); i++ {
+		assert.GreaterOrEqual(t, fraudPrediction.TopTerms[i-1].Weight, fraudPrediction.TopTerms[i].Weight, "top terms sorted by weight")
+	}
+}
+
+func TestTrain_NoHoldout(t *testing.T) {
+	examples := trainingExamples()
+	model, err := Train(examples, TrainOptions{HoldoutFraction: NoHoldout, Seed: 42, Version: "test"})
+	require.NoError(t, err)
+
+	assert.Zero(t, model.HoldoutSize, "every example is used for training")
+	assert.Equal(t, len(examples), model.Documents[0]+model.Documents[1])
+}
+
+func TestTrain_RequiresBothClasses(t *testing.T) {
+	_, err := Train([]Example{{Text: "wire transfer", Fraud: true}}, TrainOptions{})
+	assert.Error(t, err)
+}
+
+func TestModel_SaveLoadRoundTrip(t *testing.T) {
+	model, err := Train(trainingExamples(), TrainOptions{Seed: 7, Version: "roundtrip"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, model.Save(&buf))
+
+	loaded, err := Load(&buf)
+	require.NoError(t, err)
+
+	text := "Please process this payment immediately"
+	assert.Equal(t, "roundtrip", loaded.Version)
+	assert.InDelta(t, model.Predict(text, 5).Probability, loaded.Predict(text, 5).Probability, 1e-9)
+}
+
+func TestLoad_RejectsUnknownFormat(t *testing.T) {
+	_, err := Load(bytes.NewBufferString(`{"format_version": 99}`))
+	assert.Error(t, err)
+}
+
+func TestFeatures(t *testing.T) {
+	features := Features("Wire transfer, NOW!", 2)
+	assert.Equal(t, []string{"wire", "transfer", "now", "wire transfer", "transfer now"}, features)
+}
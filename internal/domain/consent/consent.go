@@ -0,0 +1,108 @@
+// Package consent builds provider consent URLs and the state that ties a consent
+// callback back to the tenant that asked for it
+//
+// The state is "<tenant id>.<expiry unix>.<hex HMAC-SHA256(secret, tenant id.expiry)>":
+// the callback needs no stored session, and a state cannot be forged to attach
+// credentials to another tenant.
+package consent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Provider consent endpoints
+const (
+	// Microsoft admin consent for a multi-tenant application (application permissions)
+	MicrosoftAuthorizeURL = "https://login.microsoftonline.com/organizations/v2.0/adminconsent"
+	// Google authorization with offline access, returning a code exchanged for a refresh token
+	GoogleAuthorizeURL = "https://accounts.google.com/o/oauth2/v2/auth"
+)
+
+// defaultScopes are the permissions requested from each provider
+var defaultScopes = map[domain.Provider][]string{
+	domain.ProviderMicrosoft: {"https://graph.microsoft.com/.default"},
+	domain.ProviderGoogle: {
+		"https://www.googleapis.com/auth/gmail.modify",
+		"https://www.googleapis.com/auth/admin.directory.user.readonly",
+	},
+}
+
+// DefaultScopes returns the permissions requested from a provider
+func DefaultScopes(provider domain.Provider) []string {
+	return append([]string(nil), defaultScopes[provider]...)
+}
+
+// AuthorizationURL returns the URL an administrator opens to grant consent to app
+func AuthorizationURL(provider domain.Provider, app domain.OAuthApp, redirectURL, state string) (string, error) {
+	query := url.Values{}
+	query.Set("client_id", app.ClientID)
+	query.Set("redirect_uri", redirectURL)
+	query.Set("scope", strings.Join(DefaultScopes(provider), " "))
+	query.Set("state", state)
+
+	endpoint := app.AuthorizeURL
+	switch provider {
+	case domain.ProviderMicrosoft:
+		if endpoint == "" {
+			endpoint = MicrosoftAuthorizeURL
+		}
+	case domain.ProviderGoogle:
+		if endpoint == "" {
+			endpoint = GoogleAuthorizeURL
+		}
+		query.Set("response_type", "code")
+		query.Set("access_type", "offline") // Returns a refresh token
+		query.Set("prompt", "consent")      // Even if consent was granted before
+	default:
+		return "", fmt.Errorf("unsupported provider: %s", provider)
+	}
+	return endpoint + "?" + query.Encode(), nil
+}
+
+// SignState returns the state of a consent request for a tenant, valid until expires
+func SignState(secret []byte, tenantID uuid.UUID, expires time.Time) string {
+	payload := tenantID.String() + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + hex.EncodeToString(mac(secret, payload))
+}
+
+// VerifyState returns the tenant of a state signed with secret and not expired at now
+// Failures wrap domain.ErrInvalidConsentState.
+func VerifyState(secret []byte, state string, now time.Time) (uuid.UUID, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 {
+		return uuid.Nil, fmt.Errorf("%w: malformed", domain.ErrInvalidConsentState)
+	}
+	signature, err := hex.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac(secret, parts[0]+"."+parts[1])) {
+		return uuid.Nil, fmt.Errorf("%w: signature mismatch", domain.ErrInvalidConsentState)
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: malformed expiry", domain.ErrInvalidConsentState)
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return uuid.Nil, fmt.Errorf("%w: expired", domain.ErrInvalidConsentState)
+	}
+	tenantID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: malformed tenant", domain.ErrInvalidConsentState)
+	}
+	return tenantID, nil
+}
+
+func mac(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,94 @@
+package consent
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+func TestAuthorizationURL(t *testing.T) {
+	app := domain.OAuthApp{ClientID: "app-id"}
+	tests := []struct {
+		provider     domain.Provider
+		wantEndpoint string
+		wantParams   map[string]string
+	}{
+		{
+			provider:     domain.ProviderMicrosoft,
+			wantEndpoint: MicrosoftAuthorizeURL,
+			wantParams:   map[string]string{"scope": "https://graph.microsoft.com/.default"},
+		},
+		{
+			provider:     domain.ProviderGoogle,
+			wantEndpoint: GoogleAuthorizeURL,
+			wantParams:   map[string]string{"response_type": "code", "access_type": "offline", "prompt": "consent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.provider), func(t *testing.T) {
+			raw, err := AuthorizationURL(tt.provider, app, "https://app.example.com/consent/callback", "state-1")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(raw, tt.wantEndpoint+"?"))
+
+			parsed, err := url.Parse(raw)
+			require.NoError(t, err)
+			query := parsed.Query()
+			assert.Equal(t, "app-id", query.Get("client_id"))
+			assert.Equal(t, "https://app.example.com/consent/callback", query.Get("redirect_uri"))
+			assert.Equal(t, "state-1", query.Get("state"))
+			for key, want := range tt.wantParams {
+				assert.Equal(t, want, query.Get(key), key)
+			}
+		})
+	}
+
+	t.Run("endpoint override", func(t *testing.T) {
+		raw, err := AuthorizationURL(domain.ProviderGoogle, domain.OAuthApp{AuthorizeURL: "http://127.0.0.1:9000/authorize"}, "", "s")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(raw, "http://127.0.0.1:9000/authorize?"))
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, err := AuthorizationURL("yahoo", app, "", "s")
+		assert.Error(t, err)
+	})
+}
+
+func TestVerifyState(t *testing.T) {
+	secret := []byte("state secret")
+	now := time.Unix(1700000000, 0)
+	tenantID := uuid.New()
+	state := SignState(secret, tenantID, now.Add(time.Hour))
+
+	got, err := VerifyState(secret, state, now)
+	require.NoError(t, err)
+	assert.Equal(t, tenantID, got)
+
+	forged := strings.Replace(state, tenantID.String(), uuid.NewString(), 1)
+	tests := []struct {
+		name   string
+		secret []byte
+		state  string
+		now    time.Time
+	}{
+		{"expired", secret, state, now.Add(2 * time.Hour)},
+		{"other secret", []byte("other"), state, now},
+		{"forged tenant", secret, forged, now},
+		{"malformed", secret, "not-a-state", now},
+		{"empty", secret, "", now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyState(tt.secret, tt.state, tt.now)
+			assert.ErrorIs(t, err, domain.ErrInvalidConsentState)
+		})
+	}
+}
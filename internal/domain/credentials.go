@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuth grants used to obtain provider access tokens
+const (
+	GrantClientCredentials = "client_credentials" // Microsoft Graph application permissions
+	GrantJWTBearer         = "jwt_bearer"         // Google service account with domain-wide delegation
+	GrantRefreshToken      = "refresh_token"      // Delegated consent of an administrator
+)
+
+// TenantCredentials are the OAuth credentials a tenant granted us
+// They are stored JSON-encoded in Tenant.Credentials.
+type TenantCredentials struct {
+	Grant string `json:"grant"`
+
+	// Microsoft: application registration in the customer directory
+	DirectoryID  string `json:"directory_id,omitempty"` // Azure AD tenant ID
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+
+	// Google: service account impersonating a workspace administrator
+	ServiceAccount string `json:"service_account,omitempty"` // Service account email
+	PrivateKey     string `json:"private_key,omitempty"`     // PEM-encoded PKCS#8 RSA key
+	Subject        string `json:"subject,omitempty"`         // User impersonated through delegation
+
+	RefreshToken string   `json:"refresh_token,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// TokenURL overrides the provider token endpoint (local fake endpoints, sovereign clouds)
+	TokenURL string `json:"token_url,omitempty"`
+}
+
+// ParseCredentials decodes stored tenant credentials
+func ParseCredentials(encoded string) (TenantCredentials, error) {
+	var creds TenantCredentials
+	if err := json.Unmarshal([]byte(encoded), &creds); err != nil {
+		return creds, fmt.Errorf("invalid credentials: %w", err)
+	}
+	return creds, creds.Validate()
+}
+
+// Encode returns the stored form of the credentials
+func (c TenantCredentials) Encode() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode credentials: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// Validate checks the fields required by the grant
+func (c TenantCredentials) Validate() error {
+	switch c.Grant {
+	case GrantClientCredentials:
+		if c.DirectoryID == "" || c.ClientID == "" || c.ClientSecret == "" {
+			return errors.New("client credentials need a directory ID, client ID and client secret")
+		}
+	case GrantJWTBearer:
+		if c.ServiceAccount == "" || c.PrivateKey == "" || c.Subject == "" {
+			return errors.New("service account credentials need an account, private key and subject")
+		}
+	case GrantRefreshToken:
+		if c.ClientID == "" || c.RefreshToken == "" {
+			return errors.New("refresh token credentials need a client ID and refresh token")
+		}
+	default:
+		return fmt.Errorf("unknown grant %q", c.Grant)
+	}
+	return nil
+}
+
+// AccessToken is a provider access token obtained from a token exchange
+// RefreshToken is set when the provider rotated the refresh token.
+type AccessToken struct {
+	Value        string
+	ExpiresAt    time.Time
+	RefreshToken string
+}
+
+// ValidFor reports whether the token is still valid d after now
+func (t AccessToken) ValidFor(now time.Time, d time.Duration) bool {
+	return t.Value != "" && now.Add(d).Before(t.ExpiresAt)
+}
+
+// String describes the credentials without their secrets, so they are never logged
+func (c TenantCredentials) String() string {
+	principal := c.ClientID
+	if c.Grant == GrantJWTBearer {
+		principal = c.ServiceAccount
+	}
+	return fmt.Sprintf("%s credentials of %s", c.Grant, principal)
+}
+
+// GoString redacts the credentials in %#v output
+func (c TenantCredentials) GoString() string {
+	return c.String()
+}
+
+// DataKey is a tenant's data key, wrapped by a master key of the key manager
+// Tenant secrets (credentials) are encrypted with the unwrapped key.
+type DataKey struct {
+	TenantID    uuid.UUID
+	MasterKeyID string
+	WrappedKey  []byte
+	CreatedAt   time.Time
+	RotatedAt   *time.Time // Last re-wrap with a new master key
+}
@@ -0,0 +1,427 @@
+package detection
+
+import (
+	"math"
+	"sort"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Aggregator combines individual detection signals into a single risk score
+//
+// Different aggregators encode different assumptions about how signals relate:
+//   - WeightedMaxAggregator: one strong signal is enough, extra signals add nothing
+//   - NoisyORAggregator: signals are independent pieces of evidence of fraud
+//   - LogOddsAggregator: evidence adds up, but correlated signals are discounted
+//   - LogisticAggregator: weights learned from reviewed emails
+//
+// Aggregators are selected per tenant (see domain.Tenant.RiskAggregator).
+type Aggregator interface {
+	// Name identifies the aggregator in tenant configuration and in stored analyses
+	Name() string
+
+	// Aggregate returns the risk score (0.0-1.0) and each detection's contribution
+	// to it, in the same order as detections. Contributions sum to the score.
+	Aggregate(detections []domain.Detection) (score float64, contributions []float64)
+}
+
+// Aggregator names used in tenant configuration
+const (
+	AggregatorWeightedMax = "weighted_max"
+	AggregatorNoisyOR     = "noisy_or"
+	AggregatorLogOdds     = "log_odds"
+	AggregatorLogistic    = "logistic"
+)
+
+// DefaultWeights returns the per-type weights used by the weighted maximum aggregator
+// Weight by detection type (some types are more reliable than others)
+// Production : This should be a dedicated table to be editable quickly for finetunning
+func DefaultWeights() map[string]float64 {
+	return map[string]float64{
+		"DOMAIN_TYPOSQUATTING":                1.5,
+		"DISPLAY_NAME_MISMATCH":               1.3,
+		"AUTH_FAILURES":                       1.2,
+		"HIGH_RISK_ATTACHMENT":                1.5,
+		"SUSPICIOUS_ATTACHMENT_NAME":          1.3,
+		"URGENCY_FINANCIAL_LANGUAGE":          1.0,
+		"REPLY_TO_MISMATCH":                   1.1,
+		"MEDIUM_RISK_ATTACHMENT_WITH_URGENCY": 1.0,
+		"BEC_CSUITE_TARGETING":                1.6,
+		"BEC_FINANCE_TARGETING":               1.5,
+		"BEC_HR_PAYROLL_SCAM":                 1.4,
+		"BEC_HIGH_VALUE_TARGET":               1.2,
+		"MANAGER_IMPERSONATION":               1.5,
+		"MANAGER_BYPASS":                      1.2,
+		"VIP_IMPERSONATION":                   1.5,
+		"VIP_SIGNATURE_IMPERSONATION":         1.3,
+		"ML_TEXT_CLASSIFIER":                  1.0, // Already a calibrated probability
+	}
+}
+
+// typeAliases maps renamed detection types to their current name
+// Analyses stored and weights configured before the rename keep using the old name.
+var typeAliases = map[string]string{
+	"BEC_HR_W2_SCAM": "BEC_HR_PAYROLL_SCAM",
+}
+
+// canonicalType returns a detection type's current name
+func canonicalType(detectionType string) string {
+	if current, ok := typeAliases[detectionType]; ok {
+		return current
+	}
+	return detectionType
+}
+
+// lookup returns a detection type's value, whichever of its names values is keyed by
+func lookup[V any](values map[string]V, detectionType string) (V, bool) {
+	detectionType = canonicalType(detectionType)
+	if value, ok := values[detectionType]; ok {
+		return value, true
+	}
+	for old, current := range typeAliases {
+		if current == detectionType {
+			if value, ok := values[old]; ok {
+				return value, true
+			}
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// DefaultCorrelationGroups maps detection types to groups of signals that tend to
+// fire together because they look at the same evidence
+//
+// All language-based detections read the same subject + body keywords, so an
+// urgent wire transfer request to the CFO shouldn't count three times.
+func DefaultCorrelationGroups() map[string]string {
+	return map[string]string{
+		"URGENCY_FINANCIAL_LANGUAGE":          "content",
+		"BEC_CSUITE_TARGETING":                "content",
+		"BEC_FINANCE_TARGETING":               "content",
+		"BEC_HR_PAYROLL_SCAM":                 "content",
+		"BEC_HIGH_VALUE_TARGET":               "content",
+		"ML_TEXT_CLASSIFIER":                  "content",
+		"MEDIUM_RISK_ATTACHMENT_WITH_URGENCY": "attachment",
+		"HIGH_RISK_ATTACHMENT":                "attachment",
+		"SUSPICIOUS_ATTACHMENT_NAME":          "attachment",
+		"DOMAIN_TYPOSQUATTING":                "sender_identity",
+		"DISPLAY_NAME_MISMATCH":               "sender_identity",
+		"MANAGER_IMPERSONATION":               "sender_identity",
+		"MANAGER_BYPASS":                      "content",
+		"VIP_IMPERSONATION":                   "sender_identity",
+		"VIP_SIGNATURE_IMPERSONATION":         "sender_identity",
+	}
+}
+
+// StandardAggregators returns the built-in aggregators keyed by name
+// The learned logistic combiner is not included: it needs a fitted model.
+func StandardAggregators() map[string]Aggregator {
+	aggregators := []Aggregator{
+		NewWeightedMaxAggregator(DefaultWeights()),
+		NewNoisyORAggregator(nil),
+		NewLogOddsAggregator(DefaultBaseRate, DefaultCorrelationGroups(), DefaultCorrelationDiscount),
+	}
+
+	registry := make(map[string]Aggregator, len(aggregators))
+	for _, aggregator := range aggregators {
+		registry[aggregator.Name()] = aggregator
+	}
+	return registry
+}
+
+// WeightedMaxAggregator scores an email by its single strongest weighted signal
+//
+// Rationale: one strong signal should flag an email and averaging dilutes detection.
+// Limitation: additional independent signals don't raise the score, and weights
+// above 1.0 saturate medium confidences (0.7 * 1.5 caps at 1.0).
+type WeightedMaxAggregator struct {
+	weights map[string]float64
+}
+
+// NewWeightedMaxAggregator creates a weighted maximum aggregator
+func NewWeightedMaxAggregator(weights map[string]float64) *WeightedMaxAggregator {
+	return &WeightedMaxAggregator{weights: weights}
+}
+
+// Name returns the aggregator name
+func (a *WeightedMaxAggregator) Name() string {
+	return AggregatorWeightedMax
+}
+
+// Aggregate returns the highest confidence*weight, capped at 1.0
+// The strongest detection carries the whole score; the others contribute nothing.
+func (a *WeightedMaxAggregator) Aggregate(detections []domain.Detection) (float64, []float64) {
+	contributions := make([]float64, len(detections))
+	if len(detections) == 0 {
+		return 0.0, contributions
+	}
+
+	maxScore, maxIndex := 0.0, -1
+	for i, detection := range detections {
+		weight, _ := lookup(a.weights, detection.Type)
+		if weight == 0 {
+			weight = 1.0 // Default weight for unknown types
+		}
+
+		score := detection.Confidence * weight
+		if score > maxScore {
+			maxScore, maxIndex = score, i
+		}
+	}
+
+	// Cap at 1.0 to ensure RiskLevel categories work correctly
+	score := math.Min(maxScore, 1.0)
+	if maxIndex >= 0 {
+		contributions[maxIndex] = score
+	}
+	return score, contributions
+}
+
+// NoisyORAggregator treats each detection as an independent cause of fraud
+//
+// score = 1 - Π(1 - reliability_i * confidence_i)
+//
+// Two independent 0.7 signals give 0.91, and the score never exceeds 1.0 without capping.
+type NoisyORAggregator struct {
+	reliability map[string]float64
+}
+
+// NewNoisyORAggregator creates a noisy-OR aggregator
+// reliability optionally down-weights detection types (0.0-1.0, default 1.0).
+func NewNoisyORAggregator(reliability map[string]float64) *NoisyORAggregator {
+	return &NoisyORAggregator{reliability: reliability}
+}
+
+// Name returns the aggregator name
+func (a *NoisyORAggregator) Name() string {
+	return AggregatorNoisyOR
+}
+
+// Aggregate combines detections with noisy-OR
+//
+// Contributions split the score in proportion to each signal's -log(1 - p), the
+// quantity noisy-OR adds up, so they are independent of detection order.
+func (a *NoisyORAggregator) Aggregate(detections []domain.Detection) (float64, []float64) {
+	contributions := make([]float64, len(detections))
+	if len(detections) == 0 {
+		return 0.0, contributions
+	}
+
+	evidence := make([]float64, len(detections))
+	total := 0.0
+	for i, detection := range detections {
+		reliability, ok := lookup(a.reliability, detection.Type)
+		if !ok {
+			reliability = 1.0
+		}
+		p := clampProbability(detection.Confidence * reliability)
+		evidence[i] = -math.Log1p(-p)
+		total += evidence[i]
+	}
+
+	score := 1 - math.Exp(-total)
+	distribute(score, evidence, total, contributions)
+	return score, contributions
+}
+
+// Defaults for the log-odds aggregator
+const (
+	// DefaultBaseRate is the prior probability that an email is fraudulent
+	DefaultBaseRate = 0.05
+
+	// DefaultCorrelationDiscount is the factor applied to each additional signal
+	// from the same correlation group (1st: 1.0, 2nd: 0.5, 3rd: 0.25...)
+	DefaultCorrelationDiscount = 0.5
+)
+
+// LogOddsAggregator sums evidence in log-odds space with correlation discounting
+//
+// Each detection's confidence is read as a posterior P(fraud | signal), converted to
+// a likelihood ratio against the base rate, and added to the prior log-odds
+// (a naive Bayes combination). A single detection scores exactly its confidence.
+// Within a correlation group only the strongest signal counts fully; the next ones
+// are geometrically discounted since they largely restate the same evidence.
+type LogOddsAggregator struct {
+	baseRate float64
+	groups   map[string]string
+	discount float64
+}
+
+// NewLogOddsAggregator creates a log-odds aggregator
+func NewLogOddsAggregator(baseRate float64, groups map[string]string, discount float64) *LogOddsAggregator {
+	return &LogOddsAggregator{
+		baseRate: clampProbability(baseRate),
+		groups:   groups,
+		discount: discount,
+	}
+}
+
+// Name returns the aggregator name
+func (a *LogOddsAggregator) Name() string {
+	return AggregatorLogOdds
+}
+
+// Aggregate sums discounted log-likelihood ratios on top of the prior
+func (a *LogOddsAggregator) Aggregate(detections []domain.Detection) (float64, []float64) {
+	contributions := make([]float64, len(detections))
+	if len(detections) == 0 {
+		return 0.0, contributions
+	}
+
+	prior := logit(a.baseRate)
+	evidence := make([]float64, len(detections))
+	for i, detection := range detections {
+		evidence[i] = logit(clampProbability(detection.Confidence)) - prior
+	}
+
+	// Order each group's members by strength so the strongest one is kept intact
+	order := make([]int, len(detections))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(x, y int) bool { return evidence[order[x]] > evidence[order[y]] })
+
+	seen := make(map[string]int)
+	total := 0.0
+	for _, i := range order {
+		group, ok := lookup(a.groups, detections[i].Type)
+		if !ok {
+			group = detections[i].Type // Ungrouped types are their own group
+		}
+		evidence[i] *= math.Pow(a.discount, float64(seen[group]))
+		seen[group]++
+		total += evidence[i]
+	}
+
+	score := sigmoid(prior + total)
+	positive := 0.0
+	for _, e := range evidence {
+		if e > 0 {
+			positive += e
+		}
+	}
+	distribute(score, evidence, positive, contributions)
+	return score, contributions
+}
+
+// LogisticAggregator is a logistic regression over detection confidences
+//
+// score = sigmoid(Bias + Σ Weights[type] * confidence)
+//
+// Weights are learned from reviewed emails (see FitLogisticAggregator), which makes
+// this the only aggregator whose output is a probability by construction.
+type LogisticAggregator struct {
+	Version string             `json:"version"`
+	Bias    float64            `json:"bias"`
+	Weights map[string]float64 `json:"weights"`
+}
+
+// Name returns the aggregator name
+func (a *LogisticAggregator) Name() string {
+	return AggregatorLogistic
+}
+
+// Aggregate applies the learned logistic model
+func (a *LogisticAggregator) Aggregate(detections []domain.Detection) (float64, []float64) {
+	contributions := make([]float64, len(detections))
+	if len(detections) == 0 {
+		return 0.0, contributions
+	}
+
+	terms := make([]float64, len(detections))
+	total, positive := a.Bias, 0.0
+	for i, detection := range detections {
+		weight, _ := lookup(a.Weights, detection.Type)
+		terms[i] = weight * detection.Confidence
+		total += terms[i]
+		if terms[i] > 0 {
+			positive += terms[i]
+		}
+	}
+
+	score := sigmoid(total)
+	distribute(score, terms, positive, contributions)
+	return score, contributions
+}
+
+// LogisticExample is a reviewed email reduced to the detections it triggered
+type LogisticExample struct {
+	Detections []domain.Detection
+	Fraud      bool
+}
+
+// FitLogisticAggregator learns combiner weights by L2-regularized gradient descent
+//
+// Emails with no detections are valid (and important) negative examples: they
+// anchor the bias so that "nothing fired" maps to a low score.
+func FitLogisticAggregator(examples []LogisticExample, l2 float64, iterations int, version string) *LogisticAggregator {
+	model := &LogisticAggregator{Version: version, Weights: make(map[string]float64)}
+	if len(examples) == 0 {
+		return model
+	}
+
+	const learningRate = 0.5
+	n := float64(len(examples))
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		gradBias := 0.0
+		gradWeights := make(map[string]float64)
+
+		for _, example := range examples {
+			z := model.Bias
+			for _, detection := range example.Detections {
+				z += model.Weights[canonicalType(detection.Type)] * detection.Confidence
+			}
+
+			residual := sigmoid(z)
+			if example.Fraud {
+				residual--
+			}
+
+			gradBias += residual
+			for _, detection := range example.Detections {
+				gradWeights[canonicalType(detection.Type)] += residual * detection.Confidence
+			}
+		}
+
+		model.Bias -= learningRate * gradBias / n
+		for detectionType, gradient := range gradWeights {
+			weight := model.Weights[detectionType]
+			model.Weights[detectionType] = weight - learningRate*(gradient/n+l2*weight)
+		}
+	}
+
+	return model
+}
+
+// distribute splits score across detections in proportion to their positive evidence
+// Without any, the score comes from the prior or bias alone: it is split evenly so
+// contributions still sum to it.
+func distribute(score float64, evidence []float64, total float64, contributions []float64) {
+	if total <= 0 {
+		for i := range contributions {
+			contributions[i] = score / float64(len(contributions))
+		}
+		return
+	}
+	for i, e := range evidence {
+		if e > 0 {
+			contributions[i] = score * e / total
+		}
+	}
+}
+
+// clampProbability keeps probabilities away from 0 and 1 so log-odds stay finite
+func clampProbability(p float64) float64 {
+	const epsilon = 1e-6
+	return math.Min(math.Max(p, epsilon), 1-epsilon)
+}
+
+func logit(p float64) float64 {
+	return math.Log(p / (1 - p))
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
@@ -0,0 +1,165 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// Single strong signal vs the same signal corroborated by independent ones
+var (
+	typosquatOnly = []domain.Detection{
+		{Type: "DOMAIN_TYPOSQUATTING", Confidence: 0.70},
+	}
+	typosquatCorroborated = []domain.Detection{
+		{Type: "DOMAIN_TYPOSQUATTING", Confidence: 0.70},
+		{Type: "REPLY_TO_MISMATCH", Confidence: 0.75},
+		{Type: "AUTH_FAILURES", Confidence: 0.80},
+		{Type: "URGENCY_FINANCIAL_LANGUAGE", Confidence: 0.70},
+	}
+)
+
+func TestAggregators_CorroboratingSignalsRaiseScore(t *testing.T) {
+	for name, aggregator := range StandardAggregators() {
+		if name == AggregatorWeightedMax {
+			continue // Weighted max is the baseline that ignores corroboration
+		}
+		t.Run(name, func(t *testing.T) {
+			single, _ := aggregator.Aggregate(typosquatOnly)
+			multiple, _ := aggregator.Aggregate(typosquatCorroborated)
+
+			assert.Greater(t, multiple, single, "corroborating signals should raise the score")
+			assert.LessOrEqual(t, multiple, 1.0)
+			assert.InDelta(t, 0.70, single, 0.01, "a single detection should keep its confidence")
+		})
+	}
+}
+
+func TestAggregators_ContributionsSumToScore(t *testing.T) {
+	for name, aggregator := range StandardAggregators() {
+		t.Run(name, func(t *testing.T) {
+			score, contributions := aggregator.Aggregate(typosquatCorroborated)
+
+			assert.Len(t, contributions, len(typosquatCorroborated))
+			total := 0.0
+			for _, c := range contributions {
+				assert.GreaterOrEqual(t, c, 0.0)
+				total += c
+			}
+			assert.InDelta(t, score, total, 1e-9)
+		})
+	}
+}
+
+func TestAggregators_NoDetections(t *testing.T) {
+	for name, aggregator := range StandardAggregators() {
+		t.Run(name, func(t *testing.T) {
+			score, contributions := aggregator.Aggregate(nil)
+			assert.Equal(t, 0.0, score)
+			assert.Empty(t, contributions)
+		})
+	}
+}
+
+func TestWeightedMaxAggregator_SaturatesOnWeights(t *testing.T) {
+	// Documents the limitation that motivated alternative aggregators
+	score, contributions := NewWeightedMaxAggregator(DefaultWeights()).Aggregate(typosquatCorroborated)
+
+	assert.Equal(t, 1.0, score, "0.70 * 1.5 typosquatting weight saturates")
+	assert.Equal(t, []float64{1.0, 0, 0, 0}, contributions)
+}
+
+func TestNoisyORAggregator_Aggregate(t *testing.T) {
+	score, _ := NewNoisyORAggregator(nil).Aggregate([]domain.Detection{
+		{Type: "REPLY_TO_MISMATCH", Confidence: 0.7},
+		{Type: "AUTH_FAILURES", Confidence: 0.7},
+	})
+
+	assert.InDelta(t, 0.91, score, 1e-9) // 1 - 0.3 * 0.3
+}
+
+func TestLogOddsAggregator_DiscountsCorrelatedSignals(t *testing.T) {
+	aggregator := NewLogOddsAggregator(DefaultBaseRate, DefaultCorrelationGroups(), DefaultCorrelationDiscount)
+
+	correlated, correlatedContributions := aggregator.Aggregate([]domain.Detection{
+		{Type: "URGENCY_FINANCIAL_LANGUAGE", Confidence: 0.75},
+		{Type: "BEC_FINANCE_TARGETING", Confidence: 0.85},
+	})
+	independent, _ := aggregator.Aggregate([]domain.Detection{
+		{Type: "REPLY_TO_MISMATCH", Confidence: 0.75},
+		{Type: "BEC_FINANCE_TARGETING", Confidence: 0.85},
+	})
+
+	assert.Less(t, correlated, independent, "two content signals restate the same evidence")
+	assert.Greater(t, correlatedContributions[1], correlatedContributions[0], "strongest group member keeps full weight")
+}
+
+func TestLogisticAggregator_ContributionsWithoutPositiveTerms(t *testing.T) {
+	aggregator := &LogisticAggregator{Bias: -1, Weights: map[string]float64{"REPLY_TO_MISMATCH": -0.5}}
+	detections := []domain.Detection{
+		{Type: "REPLY_TO_MISMATCH", Confidence: 0.8},
+		{Type: "AUTH_FAILURES", Confidence: 0.6}, // No learned weight
+	}
+
+	score, contributions := aggregator.Aggregate(detections)
+
+	assert.InDelta(t, sigmoid(-1.4), score, 1e-9)
+	assert.InDelta(t, score, contributions[0]+contributions[1], 1e-9)
+	assert.Equal(t, contributions[0], contributions[1])
+}
+
+func TestAggregators_RenamedTypeKeepsItsWeight(t *testing.T) {
+	legacy := []domain.Detection{{Type: "BEC_HR_W2_SCAM", Confidence: 0.6}}
+	current := []domain.Detection{{Type: "BEC_HR_PAYROLL_SCAM", Confidence: 0.6}}
+
+	weighted := NewWeightedMaxAggregator(DefaultWeights())
+	legacyScore, _ := weighted.Aggregate(legacy)
+	assert.InDelta(t, 0.84, legacyScore, 1e-9, "stored detections use the current weight")
+
+	// Weights stored under the old name apply to new detections
+	combiner := &LogisticAggregator{Weights: map[string]float64{"BEC_HR_W2_SCAM": 2}}
+	legacyScore, _ = combiner.Aggregate(legacy)
+	currentScore, _ := combiner.Aggregate(current)
+	assert.InDelta(t, sigmoid(1.2), currentScore, 1e-9)
+	assert.Equal(t, legacyScore, currentScore)
+}
+
+func TestFitLogisticAggregator(t *testing.T) {
+	examples := make([]LogisticExample, 0)
+	for i := 0; i < 20; i++ {
+		examples = append(examples,
+			LogisticExample{Fraud: true, Detections: []domain.Detection{{Type: "DOMAIN_TYPOSQUATTING", Confidence: 0.9}}},
+			LogisticExample{Fraud: false, Detections: []domain.Detection{{Type: "MEDIUM_RISK_ATTACHMENT_WITH_URGENCY", Confidence: 0.7}}},
+			LogisticExample{Fraud: false},
+		)
+	}
+
+	aggregator := FitLogisticAggregator(examples, 0.001, 2000, "test")
+
+	fraudScore, _ := aggregator.Aggregate([]domain.Detection{{Type: "DOMAIN_TYPOSQUATTING", Confidence: 0.9}})
+	benignScore, _ := aggregator.Aggregate([]domain.Detection{{Type: "MEDIUM_RISK_ATTACHMENT_WITH_URGENCY", Confidence: 0.7}})
+
+	assert.Greater(t, fraudScore, 0.8)
+	assert.Less(t, benignScore, 0.2)
+	assert.Equal(t, AggregatorLogistic, aggregator.Name())
+}
+
+func TestDetector_Using_RecordsAggregatorAndContributions(t *testing.T) {
+	detector := NewDetector([]string{"company.com"}, []string{"microsoft.com"}).
+		Using(NewNoisyORAggregator(nil))
+
+	analysis := detector.AnalyzeEmail(domain.Email{
+		SenderEmail: "billing@micros0ft.com",
+		Headers:     map[string]string{"Reply-To": "billing@gmail.com"},
+	}, nil)
+
+	assert.Equal(t, AggregatorNoisyOR, analysis.Aggregator)
+	assert.Len(t, analysis.DetectedThreats, 2)
+	total := 0.0
+	for _, threat := range analysis.DetectedThreats {
+		assert.Greater(t, threat.Contribution, 0.0)
+		total += threat.Contribution
+	}
+	assert.InDelta(t, analysis.RiskScore, total, 1e-9)
+}
@@ -24,9 +24,16 @@ func (s *AttachmentStrategy) Name() string {
 
 // Detect checks for high-risk attachment types
 func (s *AttachmentStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the attachment names and the rule that matched
+func (s *AttachmentStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
 	if !email.HasAttachments {
+		trace.Skip("no attachments")
 		return nil
 	}
+	trace.Record("attachments", email.AttachmentNames)
 
 	// HIGH RISK: Executables and scripts
 	// These can run arbitrary code on the victim's machine
@@ -47,6 +54,7 @@ func (s *AttachmentStrategy) Detect(email domain.Email, recipient *domain.User,
 		// Check for high-risk extensions
 		for _, ext := range highRiskExtensions {
 			if strings.HasSuffix(filename, ext) {
+				trace.Record("high_risk_extension", ext)
 				return &domain.Detection{
 					Type:       "HIGH_RISK_ATTACHMENT",
 					Confidence: 0.90,
@@ -59,6 +67,7 @@ func (s *AttachmentStrategy) Detect(email domain.Email, recipient *domain.User,
 		// Legitimate files rarely have multiple extensions
 		dotCount := strings.Count(filename, ".")
 		if dotCount > 1 {
+			trace.Record("double_extension", name)
 			return &domain.Detection{
 				Type:       "SUSPICIOUS_ATTACHMENT_NAME",
 				Confidence: 0.85,
@@ -71,7 +80,10 @@ func (s *AttachmentStrategy) Detect(email domain.Email, recipient *domain.User,
 		for _, ext := range mediumRiskExtensions {
 			if strings.HasSuffix(filename, ext) {
 				// Check if email also has urgency language
-				if hasUrgencyLanguage(email) {
+				urgent := hasUrgencyLanguage(email)
+				trace.Record("medium_risk_extension", ext)
+				trace.Record("urgency_language", urgent)
+				if urgent {
 					return &domain.Detection{
 						Type:       "MEDIUM_RISK_ATTACHMENT_WITH_URGENCY",
 						Confidence: 0.70,
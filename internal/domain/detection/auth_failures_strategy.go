@@ -25,7 +25,14 @@ func (s *AuthFailuresStrategy) Name() string {
 
 // Detect checks email headers for SPF, DKIM, DMARC failures
 func (s *AuthFailuresStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the authentication headers and failures found
+func (s *AuthFailuresStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
 	failures := make([]string, 0)
+	trace.Record("received_spf", email.Headers["Received-SPF"])
+	trace.Record("authentication_results", email.Headers["Authentication-Results"])
 
 	// Check SPF (Sender Policy Framework)
 	// SPF verifies that sending server is authorized by domain owner
@@ -51,6 +58,8 @@ func (s *AuthFailuresStrategy) Detect(email domain.Email, recipient *domain.User
 
 	// Multiple failures = high confidence of spoofing
 	// Rationale: legitimate misconfigurations usually affect only one protocol
+	trace.Record("failures", failures)
+	trace.Threshold("failures", 2)
 	if len(failures) >= 2 {
 		return &domain.Detection{
 			Type:       "AUTH_FAILURES",
@@ -5,10 +5,13 @@ import (
 	"strings"
 
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/lexicon"
+	"github.com/stoik/email-security/internal/domain/roles"
 )
 
 // BECRoleStrategy detects Business Email Compromise attempts targeting high-value roles
-// Supports both English and French (France-compliant) detection patterns
+// Recipients are placed with the role taxonomy (package roles); keyword vocabularies
+// come from the per-language lexicons (EN, FR, DE, ES, IT, NL)
 type BECRoleStrategy struct{}
 
 // NewBECRoleStrategy creates a new BEC role targeting detection strategy
@@ -23,97 +26,62 @@ func (s *BECRoleStrategy) Name() string {
 
 // Detect identifies BEC attempts targeting high-value roles
 func (s *BECRoleStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the recipient role classification and content signals
+func (s *BECRoleStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
 	// Can only detect role-based targeting if we know the recipient
-	if recipient == nil || recipient.Role == "" {
+	profile := context.Profile(recipient)
+	if recipient == nil || (recipient.Role == "" && recipient.Department == "" && len(recipient.Groups) == 0 && !profile.Overridden) {
+		trace.Skip("recipient role unknown")
 		return nil
 	}
 
-	role := strings.ToLower(recipient.Role)
+	label := recipientLabel(recipient)
 	senderDomain := extractDomain(email.SenderEmail)
 	isExternal := !isInternalDomain(senderDomain, context.InternalDomains)
 
 	// Only flag if sender is external
 	// Internal emails to executives are normal business communication
 	if !isExternal {
+		trace.Skip("internal sender")
 		return nil
 	}
 
-	// Define high-value targets for BEC
-	cSuiteRoles := []string{
-		// English roles
-		"ceo", "cfo", "cto", "coo", "president", "chief", "vice president", "vp",
-		// French roles
-		"pdg", "président directeur général", "directeur général", "dg",
-		"daf", "directeur administratif et financier", "directeur financier",
-		"dsi", "directeur des systèmes d'information",
-		"directeur", "direction générale",
-	}
-	financeRoles := []string{
-		// English roles
-		"finance", "accounting", "treasurer", "controller", "payroll",
-		// French roles
-		"comptabilité", "comptable", "trésorier", "trésorerie",
-		"contrôleur de gestion", "contrôleur financier",
-		"responsable financier", "responsable comptable",
-		"service comptable", "paie",
-	}
-	hrRoles := []string{
-		// English roles
-		"hr", "human resources", "recruiting", "talent",
-		// French roles
-		"drh", "directeur des ressources humaines", "ressources humaines",
-		"rh", "responsable rh", "responsable ressources humaines",
-		"recrutement", "gestionnaire paie", "service rh",
-	}
-
-	isCsuite := containsAny(role, cSuiteRoles)
-	isFinance := containsAny(role, financeRoles)
-	isHR := containsAny(role, hrRoles)
+	// The job title, department and directory groups all place the recipient in
+	// the role taxonomy: an accountant titled "Analyst" in the Finance group is
+	// finance, an "Executive Assistant" is not an executive
+	isCsuite := profile.Has(roles.Executive)
+	isFinance := profile.Has(roles.Finance) || profile.Has(roles.Procurement)
+	isHR := profile.Has(roles.HR)
+	trace.Record("recipient_role", label)
+	trace.Record("recipient_profile", profile.String())
+	trace.Record("executive_role", isCsuite)
+	trace.Record("finance_role", isFinance)
+	trace.Record("hr_role", isHR)
 
 	// If not a high-value target, no detection
-	if !isCsuite && !isFinance && !isHR {
+	if !profile.HighValue() {
 		return nil
 	}
 
 	// Check for financial urgency keywords in subject + body
 	text := strings.ToLower(email.Subject + " " + email.BodyPreview)
 
-	// Urgency keywords
-	urgencyKeywords := []string{
-		// English
-		"urgent", "immediately", "asap", "today", "right away", "now",
-		// French
-		"urgent", "immédiatement", "rapidement", "aujourd'hui",
-		"tout de suite", "au plus vite", "dans l'immédiat",
-		"sans délai", "prioritaire", "en urgence",
-	}
+	// Content keywords follow the detected message language (merged with English)
+	// Urgency is the list UrgencyFinancialStrategy scores with. Bare "now" is
+	// deliberately not in it: matched as a substring, it flagged "know", "snow" or
+	// "acknowledge"; "need this now" is.
+	lex := lexicon.ForText(text)
 
-	// Wire transfer and payment keywords
-	wireTransferKeywords := []string{
-		// English
-		"wire transfer", "payment", "invoice", "bank account", "routing", "iban", "swift",
-		// French
-		"virement", "virement bancaire", "paiement", "facture",
-		"compte bancaire", "rib", "relevé d'identité bancaire",
-		"iban", "bic", "swift", "coordonnées bancaires",
-		"ordre de virement", "transfert de fonds",
-	}
-
-	// Payroll/tax document keywords
-	payrollDocKeywords := []string{
-		// English (US)
-		"tax form", "payroll",
-		// French
-		"bulletin de paie", "bulletin de salaire", "fiche de paie",
-		"numéro de sécurité sociale", "n° sécurité sociale",
-		"cotisations sociales", "déclaration de revenus",
-		"dsn", "déclaration sociale nominative",
-		"attestation fiscale", "salaires",
-	}
-
-	hasUrgency := containsAny(text, urgencyKeywords)
-	hasWireTransfer := containsAny(text, wireTransferKeywords)
-	hasPayrollDoc := containsAny(text, payrollDocKeywords)
+	hasUrgency := containsAny(text, lex.Urgency)
+	hasWireTransfer := containsAny(text, lex.Payment)
+	hasPayrollDoc := containsAny(text, lex.Payroll)
+	trace.Record("language", lex.Language)
+	trace.Record("urgency_language", hasUrgency)
+	trace.Record("payment_language", hasWireTransfer)
+	trace.Record("payroll_language", hasPayrollDoc)
 
 	// Calculate confidence based on role + content combination
 	// Higher confidence for more specific targeting patterns
@@ -125,19 +93,23 @@ func (s *BECRoleStrategy) Detect(email domain.Email, recipient *domain.User, con
 			Confidence: 0.90,
 			Evidence: fmt.Sprintf(
 				"Executive recipient (%s) + external sender + urgent wire transfer request (potential CEO fraud/Fraude au président)",
-				recipient.Role,
+				label,
 			),
 		}
 	}
 
-	// HIGH: Finance + wire transfer = invoice fraud attempt
+	// HIGH: Finance or procurement + wire transfer = invoice / vendor fraud attempt
 	if isFinance && hasWireTransfer {
+		function := "Finance"
+		if !profile.Has(roles.Finance) {
+			function = "Procurement"
+		}
 		return &domain.Detection{
 			Type:       "BEC_FINANCE_TARGETING",
 			Confidence: 0.85,
 			Evidence: fmt.Sprintf(
-				"Finance role (%s) + external sender + payment/wire transfer language (potential invoice fraud)",
-				recipient.Role,
+				"%s role (%s) + external sender + payment/wire transfer language (potential invoice fraud)",
+				function, label,
 			),
 		}
 	}
@@ -149,23 +121,39 @@ func (s *BECRoleStrategy) Detect(email domain.Email, recipient *domain.User, con
 			Confidence: 0.80,
 			Evidence: fmt.Sprintf(
 				"HR role (%s) + external sender + payroll/tax document request (W-2/bulletin de paie phishing)",
-				recipient.Role,
+				label,
 			),
 		}
 	}
 
-	// MEDIUM: Generic high-value target with urgency
+	// MEDIUM: Generic high-value target with urgency (also IT admins, legal,
+	// assistants, VPs and VIPs)
 	// Less specific but still suspicious
-	if (isCsuite || isFinance || isHR) && hasUrgency {
+	if hasUrgency {
 		return &domain.Detection{
 			Type:       "BEC_HIGH_VALUE_TARGET",
 			Confidence: 0.70,
 			Evidence: fmt.Sprintf(
-				"High-value role (%s) + external sender + urgent language",
-				recipient.Role,
+				"High-value role (%s: %s) + external sender + urgent language",
+				label, profile,
 			),
 		}
 	}
 
 	return nil
 }
+
+// recipientLabel names the recipient's position for evidence: its job title, else its
+// department, else its groups, else its address (a user tagged by a tenant override)
+func recipientLabel(recipient *domain.User) string {
+	switch {
+	case recipient.Role != "":
+		return recipient.Role
+	case recipient.Department != "":
+		return recipient.Department
+	case len(recipient.Groups) > 0:
+		return strings.Join(recipient.Groups, ", ")
+	default:
+		return recipient.Email
+	}
+}
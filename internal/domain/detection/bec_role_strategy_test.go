@@ -117,6 +117,66 @@ func TestBECRoleStrategy_Detect(t *testing.T) {
 			recipient:       nil,
 			expectDetection: false,
 		},
+		{
+			name: "Analyst in the Finance group receiving payment request - HIGH risk",
+			email: domain.Email{
+				SenderEmail: "scammer@phish.com",
+				Subject:     "Invoice Payment",
+				BodyPreview: "Please process payment for invoice via wire transfer",
+			},
+			recipient: &domain.User{
+				Role:   "Analyst",
+				Groups: []string{"Finance"},
+				Email:  "analyst@company.com",
+			},
+			expectDetection: true,
+			expectedType:    "BEC_FINANCE_TARGETING",
+			minConfidence:   0.85,
+		},
+		{
+			name: "Executives group member without title receiving urgent wire transfer - HIGH risk",
+			email: domain.Email{
+				SenderEmail: "fake@external.com",
+				Subject:     "Urgent Payment Needed",
+				BodyPreview: "Wire transfer must be completed asap",
+			},
+			recipient: &domain.User{
+				Groups: []string{"Executives"},
+				Email:  "board@company.com",
+			},
+			expectDetection: true,
+			expectedType:    "BEC_CSUITE_TARGETING",
+			minConfidence:   0.90,
+		},
+		{
+			// Bare "now" is not an urgency keyword: it is a substring of "know" and "snow"
+			name: "CEO asked to let a colleague know - NO detection",
+			email: domain.Email{
+				SenderEmail: "friend@external.com",
+				Subject:     "Ski trip",
+				BodyPreview: "Let me know if the snow holds, I acknowledge it is late notice",
+			},
+			recipient: &domain.User{
+				Role:  "CEO",
+				Email: "ceo@company.com",
+			},
+			expectDetection: false,
+		},
+		{
+			name: "CEO asked for a wire transfer they need now - HIGH risk",
+			email: domain.Email{
+				SenderEmail: "fake@external.com",
+				Subject:     "Wire transfer",
+				BodyPreview: "I need this now, send the wire transfer to the new bank account",
+			},
+			recipient: &domain.User{
+				Role:  "CEO",
+				Email: "ceo@company.com",
+			},
+			expectDetection: true,
+			expectedType:    "BEC_CSUITE_TARGETING",
+			minConfidence:   0.90,
+		},
 	}
 
 	for _, tt := range tests {
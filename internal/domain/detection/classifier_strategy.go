@@ -0,0 +1,69 @@
+package detection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/classifier"
+)
+
+// ClassifierStrategy flags emails whose text a trained naive Bayes model scores as fraud
+//
+// Unlike the rule-based strategies, the model is trained offline from reviewed
+// emails (see cmd/train-classifier) and picks up phrasing no keyword list anticipates.
+// The confidence is the model's calibrated probability, so it can be compared
+// directly with the rule-based confidences in risk scoring.
+type ClassifierStrategy struct {
+	model     *classifier.Model
+	threshold float64
+}
+
+// classifierEvidenceTerms is the number of top contributing terms reported as evidence
+const classifierEvidenceTerms = 5
+
+// NewClassifierStrategy creates a text classifier strategy
+// Emails are flagged when the calibrated fraud probability reaches threshold.
+func NewClassifierStrategy(model *classifier.Model, threshold float64) *ClassifierStrategy {
+	return &ClassifierStrategy{
+		model:     model,
+		threshold: threshold,
+	}
+}
+
+// Name returns the strategy name
+func (s *ClassifierStrategy) Name() string {
+	return "Text Classifier"
+}
+
+// Detect scores the email text and reports the strongest contributing terms
+func (s *ClassifierStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the model probability and top terms
+func (s *ClassifierStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
+	prediction := s.model.Predict(classifier.Text(email), classifierEvidenceTerms)
+	trace.Record("model_version", s.model.Version)
+	trace.Record("probability", prediction.Probability)
+	trace.Record("log_odds", prediction.LogOdds)
+	trace.Record("top_terms", prediction.TopTerms)
+	trace.Threshold("probability", s.threshold)
+	if prediction.Probability < s.threshold {
+		return nil
+	}
+
+	terms := make([]string, 0, len(prediction.TopTerms))
+	for _, term := range prediction.TopTerms {
+		terms = append(terms, fmt.Sprintf("%q (+%.2f)", term.Term, term.Weight))
+	}
+
+	return &domain.Detection{
+		Type:       "ML_TEXT_CLASSIFIER",
+		Confidence: prediction.Probability,
+		Evidence: fmt.Sprintf(
+			"Text classifier (model %s) fraud probability %.2f; top terms: %s",
+			s.model.Version, prediction.Probability, strings.Join(terms, ", "),
+		),
+	}
+}
@@ -0,0 +1,45 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/classifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifierStrategy_Detect(t *testing.T) {
+	examples := make([]classifier.Example, 0)
+	for i := 0; i < 5; i++ {
+		examples = append(examples,
+			classifier.Example{Text: "urgent wire transfer to new bank account", Fraud: true},
+			classifier.Example{Text: "buy gift cards and send the codes urgently", Fraud: true},
+			classifier.Example{Text: "agenda for the weekly team meeting", Fraud: false},
+			classifier.Example{Text: "photos from the company picnic", Fraud: false},
+		)
+	}
+	model, err := classifier.Train(examples, classifier.TrainOptions{HoldoutFraction: -1, Version: "v-test"})
+	require.NoError(t, err)
+
+	strategy := NewClassifierStrategy(model, 0.8)
+	context := NewDetectionContext([]string{"company.com"}, []string{})
+
+	t.Run("Fraudulent text - detection with top terms", func(t *testing.T) {
+		email := domain.Email{Subject: "Urgent", BodyPreview: "Wire transfer to the new bank account please"}
+
+		detection := strategy.Detect(email, nil, context)
+
+		require.NotNil(t, detection)
+		assert.Equal(t, "ML_TEXT_CLASSIFIER", detection.Type)
+		assert.GreaterOrEqual(t, detection.Confidence, 0.8)
+		assert.Contains(t, detection.Evidence, "v-test")
+		assert.Contains(t, detection.Evidence, "bank account")
+	})
+
+	t.Run("Benign text - no detection", func(t *testing.T) {
+		email := domain.Email{Subject: "Weekly team meeting", BodyPreview: "Agenda and photos from the picnic"}
+
+		assert.Nil(t, strategy.Detect(email, nil, context))
+	})
+}
@@ -1,9 +1,13 @@
 package detection
 
 import (
-	"math"
+	"fmt"
+	"time"
 
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/calibration"
+	"github.com/stoik/email-security/internal/domain/roles"
+	"github.com/stoik/email-security/internal/domain/senderlist"
 )
 
 // Detector performs fraud detection on emails using pluggable strategies
@@ -17,21 +21,82 @@ import (
 //   - Testability: Strategies can be tested in isolation
 //   - Configurability: Strategies can be enabled/disabled per organization
 //
+// Analyses are stamped with the detector's version and the version of the tenant
+// policy it was configured with, so a candidate version can run in shadow mode and be
+// compared with the live one.
+//
 // In production, this would support:
 //   - Dynamic strategy loading based on organization policies
 //   - Per-strategy confidence thresholds and weights
-//   - A/B testing of detection strategies
 //   - Machine learning strategies alongside rule-based ones
 type Detector struct {
-	strategies []DetectionStrategy
-	context    *DetectionContext
+	version       string
+	policyVersion string // Version of the tenant policy applied (see Governed)
+	strategies    []DetectionStrategy
+	context       *DetectionContext
+	aggregator    Aggregator
+
+	// calibration maps raw confidences and scores to probabilities (optional)
+	calibration *calibration.Set
+
+	// tracing records what every strategy saw in FraudAnalysis.Trace
+	tracing bool
+}
+
+// Version identifies the standard detector in the analyses it produces
+// Bump it when strategies, weights or vocabularies change, so analyses can be tied to
+// the detector that produced them and historical emails reprocessed with a new one.
+const Version = "1.0.0"
+
+// Option customizes a Detector at construction time
+type Option func(*Detector)
+
+// WithStrategies appends extra strategies (e.g. a trained ClassifierStrategy)
+// after the standard rule-based ones
+func WithStrategies(strategies ...DetectionStrategy) Option {
+	return func(d *Detector) {
+		d.strategies = append(d.strategies, strategies...)
+	}
+}
+
+// WithAggregator replaces the default weighted maximum risk aggregator
+func WithAggregator(aggregator Aggregator) Option {
+	return func(d *Detector) {
+		d.aggregator = aggregator
+	}
+}
+
+// WithCalibration applies fitted calibration maps (see cmd/evaluate) to detection
+// confidences and to the aggregated risk score
+// The maps are only applied while the detector scores with the aggregator they were
+// fitted on; a copy using another aggregator (see Using) is left uncalibrated.
+func WithCalibration(set *calibration.Set) Option {
+	return func(d *Detector) {
+		d.calibration = set
+	}
+}
+
+// WithVersion stamps analyses with another version than Version, e.g. for a
+// candidate detector with different options
+func WithVersion(version string) Option {
+	return func(d *Detector) {
+		d.version = version
+	}
+}
+
+// WithTracing enables trace mode: every analysis carries a per-strategy trace
+func WithTracing() Option {
+	return func(d *Detector) {
+		d.tracing = true
+	}
 }
 
 // NewDetector creates a new fraud detector with all standard detection strategies
 //
-// The detector is initialized with a fixed set of strategies. In production,
-// this could be made configurable to enable/disable strategies per tenant.
-func NewDetector(internalDomains, trustedDomains []string) *Detector {
+// The detector is initialized with the standard rule-based strategies; options
+// can add more. In production, this could be made configurable to enable/disable
+// strategies per tenant.
+func NewDetector(internalDomains, trustedDomains []string, opts ...Option) *Detector {
 	context := NewDetectionContext(internalDomains, trustedDomains)
 
 	// Initialize all detection strategies
@@ -44,73 +109,275 @@ func NewDetector(internalDomains, trustedDomains []string) *Detector {
 		NewReplyToStrategy(),
 		NewAttachmentStrategy(),
 		NewBECRoleStrategy(),
+		NewOrgChartStrategy(),
+		NewVIPImpersonationStrategy(),
 	}
 
-	return &Detector{
+	detector := &Detector{
+		version:    Version,
 		strategies: strategies,
 		context:    context,
+		aggregator: NewWeightedMaxAggregator(DefaultWeights()),
+	}
+	for _, opt := range opts {
+		opt(detector)
 	}
+
+	return detector
 }
 
+// senderListStrategy names the detections added by tenant block entries
+const senderListStrategy = "Sender List"
+
 // AnalyzeEmail runs all detection strategies on an email and returns fraud analysis
+//
+// The tenant's sender list is matched before the strategies and applied after them:
+// strategies still run on allowed and blocked senders, so the analysis records what
+// they found (Override.StrategyScore) when an entry turns out to be wrong. Address and
+// domain allow entries have no effect on unauthenticated senders (see
+// senderlist.Authenticated); the trace records the ignored entry.
 func (d *Detector) AnalyzeEmail(email domain.Email, recipient *domain.User) domain.FraudAnalysis {
 	detections := make([]domain.Detection, 0)
+	decision := d.context.Senders.Decide(email, time.Now())
+	listed := decision.Entry
+
+	var trace *domain.AnalysisTrace
+	if d.tracing {
+		trace = &domain.AnalysisTrace{Strategies: make([]domain.StrategyTrace, 0, len(d.strategies))}
+		if ignored := decision.Unauthenticated; ignored != nil {
+			trace.Strategies = append(trace.Strategies, domain.StrategyTrace{
+				Strategy:   senderListStrategy,
+				SkipReason: fmt.Sprintf("allow entry %s %s has no effect: sender authentication did not pass", ignored.MatchType, ignored.Value),
+				Values:     map[string]interface{}{"ignored_entry_id": ignored.ID.String()},
+			})
+		}
+	}
+	start := time.Now()
+	vip := recipient != nil && d.context.Profile(recipient).VIP
 
 	// Run all detection strategies
 	// Each strategy returns nil if no threat detected, or a Detection if suspicious
+	calibrated := d.calibrationSet()
 	for _, strategy := range d.strategies {
-		if det := strategy.Detect(email, recipient, d.context); det != nil {
+		if det := d.runStrategy(strategy, email, recipient, trace); det != nil {
+			det.Strategy = strategy.Name()
+			if calibrated != nil {
+				det.RawConfidence = det.Confidence
+				det.Confidence = calibrated.Confidence(det.Type, det.Confidence)
+			}
+			// VIPs are the likeliest targets: the same signal is more likely an attack
+			if vip {
+				det.Confidence = boostConfidence(det.Confidence)
+			}
 			detections = append(detections, *det)
 		}
 	}
 
-	// Calculate aggregate risk score
-	riskScore := d.calculateRiskScore(detections)
+	// Calculate aggregate risk score and record how much each signal weighed in it
+	riskScore, contributions := d.aggregator.Aggregate(detections)
+	if calibrated != nil && len(detections) > 0 {
+		score := calibrated.Score(riskScore)
+		for i := range contributions {
+			contributions[i] = rescale(contributions[i], riskScore, score)
+		}
+		riskScore = score
+	}
+	for i := range detections {
+		detections[i].Contribution = contributions[i]
+	}
+
+	var override *domain.AnalysisOverride
+	if listed != nil {
+		override = &domain.AnalysisOverride{
+			EntryID:       listed.ID,
+			Action:        listed.Action,
+			MatchType:     listed.MatchType,
+			Value:         listed.Value,
+			Reason:        listed.Reason,
+			StrategyScore: riskScore,
+		}
+		detections, riskScore = applySenderList(*listed, detections)
+	}
+
+	if trace != nil {
+		trace.Elapsed = time.Since(start)
+	}
+
+	thresholds := domain.DefaultRiskThresholds
+	if vip && d.context.VIPRiskThresholds != nil {
+		thresholds = *d.context.VIPRiskThresholds
+	}
 
 	return domain.FraudAnalysis{
 		EmailID:         email.ID,
 		RiskScore:       riskScore,
-		RiskLevel:       domain.RiskLevel(riskScore),
+		RiskLevel:       thresholds.Level(riskScore),
+		Aggregator:      d.aggregator.Name(),
+		DetectorVersion: d.version,
+		PolicyVersion:   d.policyVersion,
 		DetectedThreats: detections,
+		VIPRecipient:    vip,
+		Override:        override,
+		Trace:           trace,
 	}
 }
 
-// calculateRiskScore aggregates multiple detection signals into single score
-func (d *Detector) calculateRiskScore(detections []domain.Detection) float64 {
-	if len(detections) == 0 {
-		return 0.0
+// applySenderList overrides the strategies' verdict with a sender list entry
+// An allowed sender scores zero; a blocked one scores one, with a detection stating
+// why. The strategies' detections are kept, without contribution, for the record.
+func applySenderList(entry domain.SenderListEntry, detections []domain.Detection) ([]domain.Detection, float64) {
+	for i := range detections {
+		detections[i].Contribution = 0
 	}
+	if entry.Action == domain.SenderAllow {
+		return detections, 0
+	}
+	blocked := domain.Detection{
+		Type:         "SENDER_BLOCKLISTED",
+		Confidence:   1.0,
+		Evidence:     fmt.Sprintf("Sender matches the tenant's block entry %s %s (%s)", entry.MatchType, entry.Value, entry.Reason),
+		Strategy:     senderListStrategy,
+		Contribution: 1.0,
+	}
+	return append([]domain.Detection{blocked}, detections...), 1.0
+}
 
-	// Weight by detection type (some types are more reliable than others)
-	// Production : This should be a dedicated table to be editable quickly for finetunning
-	weights := map[string]float64{
-		"DOMAIN_TYPOSQUATTING":                1.5,
-		"DISPLAY_NAME_MISMATCH":               1.3,
-		"AUTH_FAILURES":                       1.2,
-		"HIGH_RISK_ATTACHMENT":                1.5,
-		"SUSPICIOUS_ATTACHMENT_NAME":          1.3,
-		"URGENCY_FINANCIAL_LANGUAGE":          1.0,
-		"REPLY_TO_MISMATCH":                   1.1,
-		"MEDIUM_RISK_ATTACHMENT_WITH_URGENCY": 1.0,
-		"BEC_CSUITE_TARGETING":                1.6,
-		"BEC_FINANCE_TARGETING":               1.5,
-		"BEC_HR_W2_SCAM":                      1.4,
-		"BEC_HIGH_VALUE_TARGET":               1.2,
+// runStrategy runs one strategy, appending its trace when tracing is enabled
+// Strategies that do not implement TracingStrategy only report whether they fired.
+func (d *Detector) runStrategy(strategy DetectionStrategy, email domain.Email, recipient *domain.User, trace *domain.AnalysisTrace) *domain.Detection {
+	if trace == nil {
+		return strategy.Detect(email, recipient, d.context)
 	}
 
-	maxScore := 0.0
-	for _, detection := range detections {
-		weight := weights[detection.Type]
-		if weight == 0 {
-			weight = 1.0 // Default weight for unknown types
-		}
+	st := domain.StrategyTrace{Strategy: strategy.Name(), Ran: true}
+	start := time.Now()
+	var det *domain.Detection
+	if tracing, ok := strategy.(TracingStrategy); ok {
+		det = tracing.DetectTraced(email, recipient, d.context, &st)
+	} else {
+		det = strategy.Detect(email, recipient, d.context)
+	}
+	st.Elapsed = time.Since(start)
+	if det != nil {
+		st.Fired = true
+		st.DetectionType = det.Type
+	}
 
-		score := detection.Confidence * weight
-		if score > maxScore {
-			maxScore = score
-		}
+	trace.Strategies = append(trace.Strategies, st)
+	return det
+}
+
+// Version returns the version stamped on the detector's analyses
+func (d *Detector) Version() string {
+	return d.version
+}
+
+// AggregatorName returns the name of the aggregator the detector scores with
+func (d *Detector) AggregatorName() string {
+	return d.aggregator.Name()
+}
+
+// calibrationSet is the calibration applied to analyses: nil when none is configured
+// or when it was fitted on another aggregator's scores
+func (d *Detector) calibrationSet() *calibration.Set {
+	if d.calibration == nil || !d.calibration.Fits(d.aggregator.Name()) {
+		return nil
+	}
+	return d.calibration
+}
+
+// StrategyNames returns the names of the configured strategies, in execution order
+func (d *Detector) StrategyNames() []string {
+	names := make([]string, len(d.strategies))
+	for i, strategy := range d.strategies {
+		names[i] = strategy.Name()
+	}
+	return names
+}
+
+// rescale keeps contributions summing to the score when the score is calibrated
+func rescale(contribution, from, to float64) float64 {
+	if from == 0 {
+		return 0
 	}
+	return contribution * to / from
+}
+
+// Using returns a copy of the detector that scores with a different aggregator
+// Strategies and context are shared; this is how per-tenant aggregators are applied.
+func (d *Detector) Using(aggregator Aggregator) *Detector {
+	clone := *d
+	clone.aggregator = aggregator
+	return &clone
+}
+
+// Extended returns a copy of the detector with additional strategies
+// Used for per-tenant strategies such as custom rules; the receiver is not modified.
+func (d *Detector) Extended(strategies ...DetectionStrategy) *Detector {
+	clone := *d
+	clone.strategies = append(append(make([]DetectionStrategy, 0, len(d.strategies)+len(strategies)), d.strategies...), strategies...)
+	return &clone
+}
+
+// Classifying returns a copy of the detector classifying recipients with a different
+// role classifier; this is how per-tenant role overrides are applied
+func (d *Detector) Classifying(classifier *roles.Classifier) *Detector {
+	clone := *d
+	context := *d.context
+	context.Roles = classifier
+	clone.context = &context
+	return &clone
+}
+
+// Listing returns a copy of the detector applying a tenant's sender allow and block
+// entries (see AnalyzeEmail)
+func (d *Detector) Listing(senders *senderlist.List) *Detector {
+	clone := *d
+	context := *d.context
+	context.Senders = senders
+	clone.context = &context
+	return &clone
+}
+
+// Protecting returns a copy of the detector protecting a tenant's VIPs, grading the
+// risk of mail to them with thresholds (nil uses the defaults)
+func (d *Detector) Protecting(vips *VIPList, thresholds *domain.RiskThresholds) *Detector {
+	clone := *d
+	context := *d.context
+	context.VIPs = vips
+	context.VIPRiskThresholds = thresholds
+	clone.context = &context
+	return &clone
+}
+
+// Versioned returns a copy of the detector stamping its analyses with another version,
+// e.g. a candidate built from the live detector with a different aggregator
+func (d *Detector) Versioned(version string) *Detector {
+	clone := *d
+	clone.version = version
+	return &clone
+}
+
+// Governed returns a copy of the detector stamping its analyses with the version of
+// the tenant policy it is configured with
+func (d *Detector) Governed(policyVersion string) *Detector {
+	clone := *d
+	clone.policyVersion = policyVersion
+	return &clone
+}
+
+// Tracing returns a copy of the detector with trace mode switched on or off
+// Used to explain a single email on demand without tracing the whole pipeline.
+func (d *Detector) Tracing(enabled bool) *Detector {
+	clone := *d
+	clone.tracing = enabled
+	return &clone
+}
 
-	// Cap at 1.0 to ensure RiskLevel categories work correctly
-	return math.Min(maxScore, 1.0)
+// Calibrated returns a copy of the detector that applies a different calibration set
+// (nil disables calibration); used by the evaluation harness to fit maps in stages
+func (d *Detector) Calibrated(set *calibration.Set) *Detector {
+	clone := *d
+	clone.calibration = set
+	return &clone
 }
@@ -6,11 +6,13 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/senderlist"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestDetector_CalculateRiskScore(t *testing.T) {
-	detector := NewDetector([]string{}, []string{})
+func TestWeightedMaxAggregator_Aggregate(t *testing.T) {
+	aggregator := NewWeightedMaxAggregator(DefaultWeights())
 
 	tests := []struct {
 		name          string
@@ -45,7 +47,7 @@ func TestDetector_CalculateRiskScore(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			score := detector.calculateRiskScore(tt.detections)
+			score, _ := aggregator.Aggregate(tt.detections)
 			assert.InDelta(t, tt.expectedScore, score, 0.01, "Risk score mismatch")
 
 			level := domain.RiskLevel(score)
@@ -91,3 +93,127 @@ func TestDetector_AnalyzeEmail_Integration(t *testing.T) {
 	assert.True(t, detectionTypes["URGENCY_FINANCIAL_LANGUAGE"], "Should detect urgent financial language")
 	assert.True(t, detectionTypes["REPLY_TO_MISMATCH"], "Should detect reply-to mismatch")
 }
+
+func TestDetector_AnalyzeEmail_Trace(t *testing.T) {
+	detector := NewDetector(
+		[]string{"company.com"},
+		[]string{"microsoft.com"},
+	)
+
+	// Near miss: "micro-soft-support.com" is below the 85% similarity threshold
+	email := domain.Email{
+		ID:          uuid.New(),
+		Subject:     "Invoice",
+		SenderName:  "Billing",
+		SenderEmail: "billing@micro-soft-support.com",
+		Headers:     map[string]string{"Received-SPF": "fail"},
+	}
+
+	untraced := detector.AnalyzeEmail(email, nil)
+	assert.Nil(t, untraced.Trace, "Trace is off by default")
+
+	analysis := detector.Tracing(true).AnalyzeEmail(email, nil)
+	require.NotNil(t, analysis.Trace)
+	require.Len(t, analysis.Trace.Strategies, len(detector.StrategyNames()))
+
+	traces := make(map[string]domain.StrategyTrace)
+	for _, st := range analysis.Trace.Strategies {
+		traces[st.Strategy] = st
+	}
+
+	typo := traces["Domain Typosquatting"]
+	assert.True(t, typo.Ran)
+	assert.False(t, typo.Fired)
+	assert.Equal(t, 85.0, typo.Thresholds["similarity_percent"])
+	similarities := typo.Values["similarity_percent"].(map[string]float64)
+	assert.Less(t, similarities["microsoft.com"], 85.0)
+
+	auth := traces["Authentication Failures"]
+	assert.Equal(t, []string{"SPF_FAIL"}, auth.Values["failures"], "One failure is below the threshold")
+	assert.Equal(t, 2.0, auth.Thresholds["failures"])
+
+	bec := traces["BEC Role Targeting"]
+	assert.False(t, bec.Ran)
+	assert.Equal(t, "recipient role unknown", bec.SkipReason)
+
+	assert.False(t, traces["Suspicious Attachments"].Ran)
+}
+
+func TestDetector_AnalyzeEmail_SenderList(t *testing.T) {
+	partner := domain.SenderListEntry{ID: uuid.New(), Action: domain.SenderAllow, MatchType: domain.SenderMatchRegistrableDomain, Value: "partner.com", Reason: "Billing replies go to a shared Gmail mailbox"}
+	blocked := domain.SenderListEntry{ID: uuid.New(), Action: domain.SenderBlock, MatchType: domain.SenderMatchDomain, Value: "known-bad.com", Reason: "Phishing campaign"}
+	detector := NewDetector([]string{"company.com"}, []string{}).Listing(senderlist.New([]domain.SenderListEntry{partner, blocked}))
+
+	// The partner's billing team takes replies on a free mailbox: a reply-to mismatch
+	allowed := detector.AnalyzeEmail(domain.Email{
+		SenderEmail: "billing@eu.partner.com",
+		Subject:     "Your invoice",
+		Headers: map[string]string{
+			"Reply-To":               "partner.billing@gmail.com",
+			"Authentication-Results": "mx.company.com; spf=pass smtp.mailfrom=eu.partner.com; dkim=pass header.d=partner.com; dmarc=pass",
+		},
+	}, nil)
+	require.NotNil(t, allowed.Override)
+	assert.Equal(t, domain.SenderAllow, allowed.Override.Action)
+	assert.Equal(t, partner.ID, allowed.Override.EntryID)
+	assert.Greater(t, allowed.Override.StrategyScore, 0.0)
+	assert.Equal(t, 0.0, allowed.RiskScore)
+	assert.Equal(t, "none", allowed.RiskLevel)
+	require.NotEmpty(t, allowed.DetectedThreats, "strategy detections are kept for the record")
+	assert.Equal(t, 0.0, allowed.DetectedThreats[0].Contribution)
+
+	flagged := detector.AnalyzeEmail(domain.Email{SenderEmail: "hello@known-bad.com", Subject: "Newsletter"}, nil)
+	require.NotNil(t, flagged.Override)
+	assert.Equal(t, domain.SenderBlock, flagged.Override.Action)
+	assert.Equal(t, 0.0, flagged.Override.StrategyScore)
+	assert.Equal(t, 1.0, flagged.RiskScore)
+	assert.Equal(t, "critical", flagged.RiskLevel)
+	require.Len(t, flagged.DetectedThreats, 1)
+	assert.Equal(t, "SENDER_BLOCKLISTED", flagged.DetectedThreats[0].Type)
+	assert.Contains(t, flagged.DetectedThreats[0].Evidence, "Phishing campaign")
+
+	assert.Nil(t, detector.AnalyzeEmail(domain.Email{SenderEmail: "someone@elsewhere.com"}, nil).Override)
+}
+
+func TestDetector_AnalyzeEmail_SpoofedAllowedSender(t *testing.T) {
+	partner := domain.SenderListEntry{ID: uuid.New(), Action: domain.SenderAllow, MatchType: domain.SenderMatchAddress, Value: "ap@partner.com", Reason: "Supplier invoices"}
+	detector := NewDetector([]string{"company.com"}, []string{}).Listing(senderlist.New([]domain.SenderListEntry{partner}))
+
+	// A spoofed From: the partner's address, failing SPF, DKIM and DMARC
+	analysis := detector.Tracing(true).AnalyzeEmail(domain.Email{
+		SenderEmail: "ap@partner.com",
+		Subject:     "Updated bank details",
+		Headers: map[string]string{
+			"Received-SPF":           "fail (mx.company.com: domain of ap@partner.com does not designate 198.51.100.66 as permitted sender) client-ip=198.51.100.66",
+			"Authentication-Results": "mx.company.com; spf=fail smtp.mailfrom=partner.com; dkim=fail header.d=partner.com; dmarc=fail header.from=partner.com",
+		},
+	}, nil)
+
+	assert.Nil(t, analysis.Override, "the allow entry has no effect")
+	assert.Greater(t, analysis.RiskScore, 0.0)
+	require.NotEmpty(t, analysis.DetectedThreats)
+	assert.Equal(t, "AUTH_FAILURES", analysis.DetectedThreats[0].Type)
+
+	require.NotNil(t, analysis.Trace)
+	ignored := analysis.Trace.Strategies[0]
+	assert.Equal(t, "Sender List", ignored.Strategy)
+	assert.Contains(t, ignored.SkipReason, "sender authentication did not pass")
+	assert.Equal(t, partner.ID.String(), ignored.Values["ignored_entry_id"])
+}
+
+func TestDetector_Version(t *testing.T) {
+	email := domain.Email{ID: uuid.New(), SenderEmail: "partner@external.com", Subject: "Hello"}
+
+	detector := NewDetector([]string{"company.com"}, nil)
+	assert.Equal(t, Version, detector.AnalyzeEmail(email, nil).DetectorVersion)
+
+	candidate := NewDetector([]string{"company.com"}, nil, WithVersion("2.0.0-rc1"))
+	assert.Equal(t, "2.0.0-rc1", candidate.Version())
+	assert.Equal(t, "2.0.0-rc1", candidate.Tracing(true).AnalyzeEmail(email, nil).DetectorVersion, "clones keep the version")
+
+	governed := detector.Versioned("1.0.0+noisy_or").Governed("abc123")
+	analysis := governed.AnalyzeEmail(email, nil)
+	assert.Equal(t, "1.0.0+noisy_or", analysis.DetectorVersion)
+	assert.Equal(t, "abc123", analysis.PolicyVersion)
+	assert.Empty(t, detector.AnalyzeEmail(email, nil).PolicyVersion, "the receiver is not modified")
+}
@@ -22,6 +22,11 @@ func (s *DisplayNameStrategy) Name() string {
 
 // Detect checks if sender display name implies authority but sender email is external
 func (s *DisplayNameStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the title match and sender domain
+func (s *DisplayNameStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
 	displayName := strings.ToLower(email.SenderName)
 	senderDomain := extractDomain(email.SenderEmail)
 
@@ -31,12 +36,16 @@ func (s *DisplayNameStrategy) Detect(email domain.Email, recipient *domain.User,
 	for _, title := range execTitles {
 		if strings.Contains(displayName, title) {
 			hasExecTitle = true
+			trace.Record("executive_title", title)
 			break
 		}
 	}
 
 	// Check if sender domain is external
 	isExternal := !isInternalDomain(senderDomain, context.InternalDomains)
+	trace.Record("display_name", email.SenderName)
+	trace.Record("sender_domain", senderDomain)
+	trace.Record("external_sender", isExternal)
 
 	if hasExecTitle && isExternal {
 		return &domain.Detection{
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/lexicon"
 )
 
 // isInternalDomain checks if a domain belongs to the organization
@@ -32,6 +33,16 @@ func ValidateEmail(email string) bool {
 	return emailRegex.MatchString(email)
 }
 
+// DomainSimilarity returns how similar two domains are, in percent of the longer
+// one's length left unedited (100 for identical domains)
+func DomainSimilarity(a, b string) float64 {
+	maxLen := max(len(a), len(b))
+	if maxLen == 0 {
+		return 100
+	}
+	return (1.0 - float64(levenshteinDistance(a, b))/float64(maxLen)) * 100
+}
+
 // levenshteinDistance calculates the edit distance between two strings
 func levenshteinDistance(s1, s2 string) int {
 	// Base cases: if either string is empty, distance is the other string's length
@@ -84,8 +95,7 @@ func levenshteinDistance(s1, s2 string) int {
 // This is a simplified check - the full UrgencyFinancialStrategy provides more detail
 func hasUrgencyLanguage(email domain.Email) bool {
 	text := strings.ToLower(email.Subject + " " + email.BodyPreview)
-	urgencyKeywords := []string{"urgent", "immediately", "asap", "right away", "today"}
-	return containsAny(text, urgencyKeywords)
+	return containsAny(text, lexicon.ForText(text).AttachmentUrgency)
 }
 
 // containsAny checks if text contains any of the keywords
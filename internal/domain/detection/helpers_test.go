@@ -3,6 +3,7 @@ package detection
 import (
 	"testing"
 
+	"github.com/stoik/email-security/internal/domain"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,3 +28,31 @@ func TestLevenshteinDistance(t *testing.T) {
 		})
 	}
 }
+
+func TestDomainSimilarity(t *testing.T) {
+	assert.Equal(t, 100.0, DomainSimilarity("", ""))
+	assert.Equal(t, 100.0, DomainSimilarity("paypal.com", "paypal.com"))
+	assert.InDelta(t, 90.0, DomainSimilarity("paypa1.com", "paypal.com"), 0.01)
+	assert.InDelta(t, 0.0, DomainSimilarity("abc", "xyz"), 0.01)
+}
+
+func TestHasUrgencyLanguage(t *testing.T) {
+	tests := []struct {
+		subject  string
+		body     string
+		expected bool
+	}{
+		{"Contract to sign", "Please sign it today", true},
+		{"URGENT: updated invoice", "See attached", true},
+		{"Virement urgent", "", true},
+		// Broader scoring terms do not flag an attachment on their own
+		{"QuickBooks export", "Here is the file, have a quick look", false},
+		{"Status report", "Sent before eod as usual", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			assert.Equal(t, tt.expected, hasUrgencyLanguage(domain.Email{Subject: tt.subject, BodyPreview: tt.body}))
+		})
+	}
+}
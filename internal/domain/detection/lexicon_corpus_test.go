@@ -0,0 +1,95 @@
+package detection
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/lexicon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corpusCase is one line of testdata/lexicon_corpus/<language>.jsonl
+//
+// The corpus captures real-world BEC and legitimate phrasing per language so that
+// lexicon edits can't silently regress detection for European customers.
+type corpusCase struct {
+	Name             string `json:"name"`
+	Subject          string `json:"subject"`
+	Body             string `json:"body"`
+	RecipientRole    string `json:"recipient_role"`
+	Language         string `json:"language"` // Lexicon the text is matched against, lexicon.MixedLanguage for all
+	UrgencyFinancial bool   `json:"urgency_financial"`
+	BECType          string `json:"bec_type"` // Expected BECRoleStrategy type, "" when no detection expected
+}
+
+func loadCorpus(t *testing.T) map[string][]corpusCase {
+	files, err := filepath.Glob(filepath.Join("testdata", "lexicon_corpus", "*.jsonl"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	corpus := make(map[string][]corpusCase)
+	for _, file := range files {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var c corpusCase
+			require.NoError(t, json.Unmarshal([]byte(line), &c), "%s: %s", file, line)
+			corpus[filepath.Base(file)] = append(corpus[filepath.Base(file)], c)
+		}
+		require.NoError(t, scanner.Err())
+		f.Close()
+	}
+
+	return corpus
+}
+
+func TestLexiconCorpus(t *testing.T) {
+	context := NewDetectionContext([]string{"company.com"}, []string{})
+
+	for file, cases := range loadCorpus(t) {
+		for _, tc := range cases {
+			t.Run(file+"/"+tc.Name, func(t *testing.T) {
+				email := domain.Email{
+					SenderEmail: "sender@external-partner.com",
+					Subject:     tc.Subject,
+					BodyPreview: tc.Body,
+				}
+
+				// Subjects too short to tell their language are matched against every lexicon
+				assert.Equal(t, tc.Language, lexicon.ForText(strings.ToLower(tc.Subject+" "+tc.Body)).Language, "language")
+
+				urgency := NewUrgencyFinancialStrategy().Detect(email, nil, context)
+				if tc.UrgencyFinancial {
+					assert.NotNil(t, urgency, "expected urgency/financial detection")
+				} else {
+					assert.Nil(t, urgency, "expected no urgency/financial detection")
+				}
+
+				if tc.RecipientRole == "" {
+					return
+				}
+				recipient := &domain.User{Role: tc.RecipientRole, Email: "user@company.com"}
+				bec := NewBECRoleStrategy().Detect(email, recipient, context)
+				if tc.BECType != "" {
+					if assert.NotNil(t, bec, "expected BEC detection") {
+						assert.Equal(t, tc.BECType, bec.Type)
+					}
+				} else {
+					assert.Nil(t, bec, "expected no BEC detection")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package detection
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/lexicon"
+)
+
+// OrgChartStrategy detects requests abusing the recipient's reporting line
+// It relies on the manager chain loaded with the recipient by directory sync:
+//   - a sender using the name of one of the recipient's managers from another address
+//     (the attacker knows who the recipient reports to)
+//   - a skip-level manager asking for an urgent payment, bypassing the recipient's
+//     direct manager, who would normally approve it
+type OrgChartStrategy struct{}
+
+// NewOrgChartStrategy creates a new org chart anomaly detection strategy
+func NewOrgChartStrategy() *OrgChartStrategy {
+	return &OrgChartStrategy{}
+}
+
+// Name returns the strategy name
+func (s *OrgChartStrategy) Name() string {
+	return "Org Chart Anomaly"
+}
+
+// Detect checks the sender against the recipient's managers
+func (s *OrgChartStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the matched manager and its level
+func (s *OrgChartStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
+	if recipient == nil || len(recipient.Managers) == 0 {
+		trace.Skip("recipient managers unknown")
+		return nil
+	}
+
+	senderDomain := extractDomain(email.SenderEmail)
+	isExternal := !isInternalDomain(senderDomain, context.InternalDomains)
+	trace.Record("managers", len(recipient.Managers))
+	trace.Record("external_sender", isExternal)
+
+	level, isManager := recipient.ManagedBy(email.SenderEmail)
+	if !isManager {
+		for i, manager := range recipient.Managers {
+			if !sameName(email.SenderName, manager.DisplayName) {
+				continue
+			}
+			trace.Record("impersonated_manager", manager.Email)
+			trace.Record("manager_level", i+1)
+
+			// An internal address may be a namesake: less likely an attack
+			confidence := 0.90
+			if !isExternal {
+				confidence = 0.60
+			}
+			return &domain.Detection{
+				Type:       "MANAGER_IMPERSONATION",
+				Confidence: confidence,
+				Evidence: fmt.Sprintf(
+					"Sender '%s <%s>' uses the name of the recipient's %s (%s) from another address",
+					email.SenderName, email.SenderEmail, managerLevel(i+1), manager.Email,
+				),
+			}
+		}
+		return nil
+	}
+	trace.Record("manager_level", level)
+
+	// The direct manager asking is the normal approval chain
+	if level < 2 {
+		return nil
+	}
+
+	text := strings.ToLower(email.Subject + " " + email.BodyPreview)
+	lex := lexicon.ForText(text)
+	hasUrgency := containsAny(text, lex.Urgency)
+	hasPayment := containsAny(text, lex.Payment)
+	trace.Record("urgency_language", hasUrgency)
+	trace.Record("payment_language", hasPayment)
+	if !hasUrgency || !hasPayment {
+		return nil
+	}
+
+	return &domain.Detection{
+		Type:       "MANAGER_BYPASS",
+		Confidence: 0.60,
+		Evidence: fmt.Sprintf(
+			"Urgent payment request from the recipient's %s (%s), bypassing the direct manager (%s)",
+			managerLevel(level), email.SenderEmail, recipient.Managers[0].Email,
+		),
+	}
+}
+
+// sameName reports whether a sender display name contains every word of a directory
+// name of at least two words, in any order ("CEO John Smith", "Smith, John")
+func sameName(senderName, directoryName string) bool {
+	want := nameWords(directoryName)
+	if len(want) < 2 {
+		return false
+	}
+	have := make(map[string]bool)
+	for _, word := range nameWords(senderName) {
+		have[word] = true
+	}
+	for _, word := range want {
+		if !have[word] {
+			return false
+		}
+	}
+	return true
+}
+
+// nameWords splits a display name into lowercase words
+func nameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '-' && r != '\''
+	})
+}
+
+// managerLevel describes a manager by its level above the recipient
+func managerLevel(level int) string {
+	switch level {
+	case 1:
+		return "direct manager"
+	case 2:
+		return "manager's manager"
+	default:
+		return fmt.Sprintf("level %d manager", level)
+	}
+}
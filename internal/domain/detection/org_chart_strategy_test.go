@@ -0,0 +1,124 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgChartStrategy_Detect(t *testing.T) {
+	context := NewDetectionContext([]string{"company.com"}, []string{})
+
+	// The accountant reports to the CFO, who reports to the CEO
+	accountant := &domain.User{
+		Email: "accountant@company.com",
+		Role:  "Accountant",
+		Managers: []domain.UserRef{
+			{ID: uuid.New(), Email: "cfo@company.com", DisplayName: "Marie Dupont"},
+			{ID: uuid.New(), Email: "ceo@company.com", DisplayName: "John Smith"},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		email          domain.Email
+		recipient      *domain.User
+		expectedType   string
+		expectedConf   float64
+		expectedInEvid string
+	}{
+		{
+			name: "CEO name from external address",
+			email: domain.Email{
+				SenderName:  "CEO John Smith",
+				SenderEmail: "john.smith@gmail.com",
+				Subject:     "Quick favor",
+			},
+			recipient:      accountant,
+			expectedType:   "MANAGER_IMPERSONATION",
+			expectedConf:   0.90,
+			expectedInEvid: "manager's manager",
+		},
+		{
+			name: "direct manager name reversed from internal address",
+			email: domain.Email{
+				SenderName:  "Dupont, Marie",
+				SenderEmail: "m.dupont@company.com",
+			},
+			recipient:      accountant,
+			expectedType:   "MANAGER_IMPERSONATION",
+			expectedConf:   0.60,
+			expectedInEvid: "direct manager",
+		},
+		{
+			name: "CEO bypassing the CFO for an urgent wire transfer",
+			email: domain.Email{
+				SenderName:  "John Smith",
+				SenderEmail: "CEO@company.com",
+				Subject:     "Urgent wire transfer",
+				BodyPreview: "Please process this payment today, don't loop in Marie",
+			},
+			recipient:      accountant,
+			expectedType:   "MANAGER_BYPASS",
+			expectedConf:   0.60,
+			expectedInEvid: "bypassing the direct manager (cfo@company.com)",
+		},
+		{
+			name: "direct manager asking for an urgent wire transfer",
+			email: domain.Email{
+				SenderName:  "Marie Dupont",
+				SenderEmail: "cfo@company.com",
+				Subject:     "Urgent wire transfer",
+				BodyPreview: "Please process this payment today",
+			},
+			recipient: accountant,
+		},
+		{
+			name: "CEO without a payment request",
+			email: domain.Email{
+				SenderName:  "John Smith",
+				SenderEmail: "ceo@company.com",
+				Subject:     "Team lunch",
+			},
+			recipient: accountant,
+		},
+		{
+			name: "first name only",
+			email: domain.Email{
+				SenderName:  "John",
+				SenderEmail: "john@external.com",
+			},
+			recipient: accountant,
+		},
+		{
+			name: "recipient without managers",
+			email: domain.Email{
+				SenderName:  "John Smith",
+				SenderEmail: "john.smith@gmail.com",
+			},
+			recipient: &domain.User{Email: "ceo@company.com", Role: "CEO"},
+		},
+		{
+			name:  "nil recipient",
+			email: domain.Email{SenderName: "John Smith", SenderEmail: "john.smith@gmail.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detection := NewOrgChartStrategy().Detect(tt.email, tt.recipient, context)
+
+			if tt.expectedType == "" {
+				assert.Nil(t, detection)
+				return
+			}
+			require.NotNil(t, detection)
+			assert.Equal(t, tt.expectedType, detection.Type)
+			assert.Equal(t, tt.expectedConf, detection.Confidence)
+			assert.Contains(t, detection.Evidence, tt.expectedInEvid)
+		})
+	}
+}
@@ -22,11 +22,17 @@ func (s *ReplyToStrategy) Name() string {
 
 // Detect checks if Reply-To header differs from sender and redirects to free email
 func (s *ReplyToStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the Reply-To domain and free email classification
+func (s *ReplyToStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
 	senderEmail := strings.ToLower(email.SenderEmail)
 	replyTo := strings.ToLower(email.Headers["Reply-To"])
 
 	// If Reply-To is empty or same as sender, no issue
 	if replyTo == "" || replyTo == senderEmail {
+		trace.Skip("no Reply-To header distinct from the sender")
 		return nil
 	}
 
@@ -43,6 +49,10 @@ func (s *ReplyToStrategy) Detect(email domain.Email, recipient *domain.User, con
 		}
 	}
 
+	trace.Record("sender_domain", senderDomain)
+	trace.Record("reply_to_domain", replyToDomain)
+	trace.Record("free_email_reply_to", isFreemail)
+
 	// Suspicious if reply-to is freemail and different from sender
 	// This is a strong indicator of phishing/BEC attack
 	if isFreemail && replyToDomain != senderDomain {
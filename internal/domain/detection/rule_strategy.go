@@ -0,0 +1,77 @@
+package detection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/rules"
+)
+
+// RuleStrategy evaluates a tenant's custom detection rules (see package rules)
+//
+// Rules are written by analysts and stored per tenant, so new signals ship without
+// a Go strategy or a redeploy. When several rules match, the one with the highest
+// confidence provides the detection type and the others are listed as evidence.
+type RuleStrategy struct {
+	rules []*rules.CompiledRule
+}
+
+// NewRuleStrategy creates a strategy over compiled rules
+func NewRuleStrategy(compiled []*rules.CompiledRule) *RuleStrategy {
+	return &RuleStrategy{rules: compiled}
+}
+
+// Name returns the strategy name
+func (s *RuleStrategy) Name() string {
+	return "Custom Rules"
+}
+
+// Detect evaluates every rule against the email
+func (s *RuleStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording which rules matched
+func (s *RuleStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
+	if len(s.rules) == 0 {
+		trace.Skip("no rules configured")
+		return nil
+	}
+
+	env := &rules.Env{
+		Email:           email,
+		Recipient:       recipient,
+		Profile:         context.Profile(recipient),
+		InternalDomains: context.InternalDomains,
+		TrustedDomains:  context.TrustedDomains,
+	}
+
+	var best *rules.CompiledRule
+	matched := make([]string, 0)
+	for _, rule := range s.rules {
+		if !rule.Program.Eval(env) {
+			continue
+		}
+		matched = append(matched, rule.Rule.Name)
+		if best == nil || rule.Rule.Confidence > best.Rule.Confidence {
+			best = rule
+		}
+	}
+	trace.Record("rules_evaluated", len(s.rules))
+	trace.Record("rules_matched", matched)
+
+	if best == nil {
+		return nil
+	}
+
+	evidence := fmt.Sprintf("Custom rule %q matched: %s", best.Rule.Name, best.Program.Source())
+	if len(matched) > 1 {
+		evidence += fmt.Sprintf(" (%d rules matched: %s)", len(matched), strings.Join(matched, ", "))
+	}
+	return &domain.Detection{
+		Type:       best.Rule.DetectionType,
+		Confidence: best.Rule.Confidence,
+		Evidence:   evidence,
+	}
+}
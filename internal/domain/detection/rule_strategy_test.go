@@ -0,0 +1,89 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compileRules(t *testing.T, defs ...domain.DetectionRule) []*rules.CompiledRule {
+	compiled := make([]*rules.CompiledRule, 0, len(defs))
+	for _, def := range defs {
+		rule, err := rules.CompileRule(def)
+		require.NoError(t, err)
+		compiled = append(compiled, rule)
+	}
+	return compiled
+}
+
+func TestRuleStrategy_Detect(t *testing.T) {
+	context := NewDetectionContext([]string{"company.com"}, []string{})
+	strategy := NewRuleStrategy(compileRules(t,
+		domain.DetectionRule{
+			Name:          "External invoice",
+			Expression:    `sender.domain not in internal_domains and subject matches "(?i)facture"`,
+			DetectionType: "CUSTOM_EXTERNAL_INVOICE",
+			Confidence:    0.6,
+		},
+		domain.DetectionRule{
+			Name:          "Invoice to finance",
+			Expression:    `subject matches "(?i)facture" and recipient.role contains "finance"`,
+			DetectionType: "CUSTOM_FINANCE_INVOICE",
+			Confidence:    0.8,
+		},
+	))
+	finance := &domain.User{Role: "Finance Director"}
+
+	tests := []struct {
+		name          string
+		senderEmail   string
+		recipient     *domain.User
+		expectedType  string
+		expectedMatch int
+	}{
+		{"no rule matches internal sender without role", "billing@company.com", nil, "", 0},
+		{"external sender only", "billing@vendor.fr", nil, "CUSTOM_EXTERNAL_INVOICE", 1},
+		{"highest confidence wins", "billing@vendor.fr", finance, "CUSTOM_FINANCE_INVOICE", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := domain.Email{Subject: "Facture 2024-118", SenderEmail: tt.senderEmail}
+			trace := &domain.StrategyTrace{Ran: true}
+
+			result := strategy.DetectTraced(email, tt.recipient, context, trace)
+
+			assert.Len(t, trace.Values["rules_matched"], tt.expectedMatch)
+			if tt.expectedType == "" {
+				assert.Nil(t, result)
+				return
+			}
+			require.NotNil(t, result)
+			assert.Equal(t, tt.expectedType, result.Type)
+		})
+	}
+}
+
+func TestDetector_Extended(t *testing.T) {
+	detector := NewDetector([]string{"company.com"}, []string{})
+	strategy := NewRuleStrategy(compileRules(t, domain.DetectionRule{
+		Name:          "Gift cards",
+		Expression:    `lower(subject) contains "gift card"`,
+		DetectionType: "CUSTOM_GIFT_CARD",
+		Confidence:    0.75,
+	}))
+
+	tenantDetector := detector.Extended(strategy)
+	email := domain.Email{Subject: "Gift card request", SenderEmail: "boss@company.com"}
+
+	assert.Len(t, tenantDetector.StrategyNames(), len(detector.StrategyNames())+1)
+	assert.Empty(t, detector.AnalyzeEmail(email, nil).DetectedThreats, "base detector is unchanged")
+
+	analysis := tenantDetector.AnalyzeEmail(email, nil)
+	require.Len(t, analysis.DetectedThreats, 1)
+	assert.Equal(t, "CUSTOM_GIFT_CARD", analysis.DetectedThreats[0].Type)
+	assert.Equal(t, "Custom Rules", analysis.DetectedThreats[0].Strategy)
+}
@@ -2,6 +2,8 @@ package detection
 
 import (
 	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/roles"
+	"github.com/stoik/email-security/internal/domain/senderlist"
 )
 
 // DetectionStrategy defines the interface that all fraud detection strategies must implement
@@ -18,6 +20,16 @@ type DetectionStrategy interface {
 	Name() string
 }
 
+// TracingStrategy is implemented by strategies that can explain their decision
+//
+// DetectTraced behaves exactly like Detect and additionally records intermediate
+// values into trace. Implementations call Detect through DetectTraced with a nil
+// trace: StrategyTrace methods are no-ops on nil, so there is a single code path.
+type TracingStrategy interface {
+	DetectionStrategy
+	DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection
+}
+
 // DetectionContext provides shared context needed by multiple detection strategies
 type DetectionContext struct {
 	// InternalDomains are the organization's own domains (e.g., "company.com")
@@ -27,12 +39,41 @@ type DetectionContext struct {
 	// TrustedDomains are legitimate external domains (e.g., "microsoft.com", "paypal.com")
 	// Used for typosquatting detection
 	TrustedDomains []string
+
+	// Roles classifies recipients into the role taxonomy, with the tenant's overrides
+	// Strategies reasoning about recipients use Profile rather than matching titles.
+	Roles *roles.Classifier
+
+	// VIPs are the tenant's VIPs (nil if none)
+	VIPs *VIPList
+
+	// VIPRiskThresholds grade the risk of mail to VIPs; nil uses the defaults
+	VIPRiskThresholds *domain.RiskThresholds
+
+	// Senders are the tenant's sender allow and block entries (nil if none)
+	Senders *senderlist.List
 }
 
 // NewDetectionContext creates a new detection context with the provided configuration
+// Recipients are classified with the default role taxonomy.
 func NewDetectionContext(internalDomains, trustedDomains []string) *DetectionContext {
 	return &DetectionContext{
 		InternalDomains: internalDomains,
 		TrustedDomains:  trustedDomains,
+		Roles:           roles.Default(),
+	}
+}
+
+// Profile classifies a recipient (the zero profile for nil)
+// Members of the tenant's VIP list are VIPs whatever their classification.
+func (c *DetectionContext) Profile(recipient *domain.User) roles.Profile {
+	classifier := c.Roles
+	if classifier == nil {
+		classifier = roles.Default()
+	}
+	profile := classifier.Classify(recipient)
+	if recipient != nil && c.VIPs.Contains(recipient.Email) {
+		profile.VIP = true
 	}
+	return profile
 }
@@ -21,7 +21,16 @@ func (s *TyposquattingStrategy) Name() string {
 
 // Detect checks if sender domain is similar to trusted domains
 func (s *TyposquattingStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the similarity to each trusted domain
+func (s *TyposquattingStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
 	senderDomain := extractDomain(email.SenderEmail)
+	similarities := make(map[string]float64, len(context.TrustedDomains))
+	trace.Record("sender_domain", senderDomain)
+	trace.Record("similarity_percent", similarities)
+	trace.Threshold("similarity_percent", 85)
 
 	for _, trustedDomain := range context.TrustedDomains {
 		// Skip if exact match (legitimate email)
@@ -30,9 +39,8 @@ func (s *TyposquattingStrategy) Detect(email domain.Email, recipient *domain.Use
 		}
 
 		// Calculate similarity using Levenshtein distance
-		distance := levenshteinDistance(senderDomain, trustedDomain)
-		maxLen := float64(max(len(senderDomain), len(trustedDomain)))
-		similarity := (1.0 - float64(distance)/maxLen) * 100
+		similarity := DomainSimilarity(senderDomain, trustedDomain)
+		similarities[trustedDomain] = similarity
 
 		// Flag if very similar but not identical (85% threshold)
 		// This threshold is tuned to catch typosquats without false positives
@@ -0,0 +1,158 @@
+package detection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// signatureWords is how many words at the end of the body are read as the signature
+const signatureWords = 40
+
+// vipConfidenceBoost is the share of the remaining distance to certainty added to
+// the confidence of detections on mail to a VIP (0.70 becomes 0.775)
+const vipConfidenceBoost = 0.25
+
+// VIPList is a tenant's VIPs, indexed for detection
+// It is immutable and safe for concurrent use; methods are no-ops on nil.
+type VIPList struct {
+	vips    []domain.VIP
+	byEmail map[string]domain.VIP // Keyed by lowercase address
+}
+
+// NewVIPList indexes a tenant's VIPs
+func NewVIPList(vips []domain.VIP) *VIPList {
+	list := &VIPList{vips: vips, byEmail: make(map[string]domain.VIP, len(vips))}
+	for _, vip := range vips {
+		list.byEmail[strings.ToLower(vip.Email)] = vip
+	}
+	return list
+}
+
+// Len returns the number of VIPs
+func (l *VIPList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.vips)
+}
+
+// Contains reports whether an address is a VIP's mailbox
+func (l *VIPList) Contains(email string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.byEmail[strings.ToLower(email)]
+	return ok
+}
+
+// MatchName returns the VIP whose name or alias a display name uses
+func (l *VIPList) MatchName(displayName string) (domain.VIP, bool) {
+	for _, vip := range l.list() {
+		for _, name := range vip.Names() {
+			if sameName(displayName, name) {
+				return vip, true
+			}
+		}
+	}
+	return domain.VIP{}, false
+}
+
+// MatchSignature returns the VIP whose name or alias signs a body: it appears, words in
+// any order, among the last signatureWords words
+func (l *VIPList) MatchSignature(body string) (domain.VIP, bool) {
+	words := nameWords(body)
+	if len(words) > signatureWords {
+		words = words[len(words)-signatureWords:]
+	}
+	for _, vip := range l.list() {
+		for _, name := range vip.Names() {
+			want := nameWords(name)
+			if len(want) < 2 {
+				continue
+			}
+			for start := 0; start+len(want) <= len(words); start++ {
+				if sameName(strings.Join(words[start:start+len(want)], " "), name) {
+					return vip, true
+				}
+			}
+		}
+	}
+	return domain.VIP{}, false
+}
+
+func (l *VIPList) list() []domain.VIP {
+	if l == nil {
+		return nil
+	}
+	return l.vips
+}
+
+// boostConfidence raises the confidence of a detection on mail to a VIP
+func boostConfidence(confidence float64) float64 {
+	return confidence + (1-confidence)*vipConfidenceBoost
+}
+
+// VIPImpersonationStrategy detects external mail using the name of one of the
+// tenant's VIPs, in the sender display name or in the signature
+// Unlike display name checks based on titles, it protects the people the tenant
+// listed, whatever their title ("these executives' names must never appear on
+// external mail").
+type VIPImpersonationStrategy struct{}
+
+// NewVIPImpersonationStrategy creates a new VIP impersonation detection strategy
+func NewVIPImpersonationStrategy() *VIPImpersonationStrategy {
+	return &VIPImpersonationStrategy{}
+}
+
+// Name returns the strategy name
+func (s *VIPImpersonationStrategy) Name() string {
+	return "VIP Impersonation"
+}
+
+// Detect checks external mail against the tenant's VIP names
+func (s *VIPImpersonationStrategy) Detect(email domain.Email, recipient *domain.User, context *DetectionContext) *domain.Detection {
+	return s.DetectTraced(email, recipient, context, nil)
+}
+
+// DetectTraced is Detect, recording the matched VIP
+func (s *VIPImpersonationStrategy) DetectTraced(email domain.Email, recipient *domain.User, context *DetectionContext, trace *domain.StrategyTrace) *domain.Detection {
+	if context.VIPs.Len() == 0 {
+		trace.Skip("no VIPs")
+		return nil
+	}
+
+	senderDomain := extractDomain(email.SenderEmail)
+	if isInternalDomain(senderDomain, context.InternalDomains) {
+		trace.Skip("internal sender")
+		return nil
+	}
+	trace.Record("vips", context.VIPs.Len())
+
+	if vip, ok := context.VIPs.MatchName(email.SenderName); ok {
+		trace.Record("impersonated_vip", vip.Email)
+		return &domain.Detection{
+			Type:       "VIP_IMPERSONATION",
+			Confidence: 0.90,
+			Evidence: fmt.Sprintf(
+				"External sender '%s <%s>' uses the name of VIP %s (%s)",
+				email.SenderName, email.SenderEmail, vip.Name, vip.Email,
+			),
+		}
+	}
+
+	if vip, ok := context.VIPs.MatchSignature(email.BodyPreview); ok {
+		trace.Record("signature_vip", vip.Email)
+		return &domain.Detection{
+			Type:       "VIP_SIGNATURE_IMPERSONATION",
+			Confidence: 0.70,
+			Evidence: fmt.Sprintf(
+				"External email from %s is signed with the name of VIP %s (%s)",
+				email.SenderEmail, vip.Name, vip.Email,
+			),
+		}
+	}
+
+	return nil
+}
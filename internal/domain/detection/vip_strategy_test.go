@@ -0,0 +1,118 @@
+package detection
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVIPs() *VIPList {
+	return NewVIPList([]domain.VIP{
+		{Email: "john.smith@company.com", Name: "John Smith", Aliases: []string{"Johnny Smith"}},
+		{Email: "marie.dupont@company.com", Name: "Marie-Claire Dupont"},
+	})
+}
+
+func TestVIPImpersonationStrategy_Detect(t *testing.T) {
+	context := NewDetectionContext([]string{"company.com"}, []string{})
+	context.VIPs = testVIPs()
+	strategy := NewVIPImpersonationStrategy()
+
+	tests := []struct {
+		name           string
+		email          domain.Email
+		expectedType   string
+		expectedInEvid string
+	}{
+		{
+			name:           "VIP display name from external address",
+			email:          domain.Email{SenderName: "Smith, John", SenderEmail: "j.smith.ceo@gmail.com"},
+			expectedType:   "VIP_IMPERSONATION",
+			expectedInEvid: "john.smith@company.com",
+		},
+		{
+			name:           "alias",
+			email:          domain.Email{SenderName: "Johnny Smith (mobile)", SenderEmail: "johnny@outlook.com"},
+			expectedType:   "VIP_IMPERSONATION",
+			expectedInEvid: "VIP John Smith",
+		},
+		{
+			name: "VIP signature",
+			email: domain.Email{
+				SenderName:  "Assistant",
+				SenderEmail: "office@exec-mail.com",
+				BodyPreview: "Please process the attached transfer before noon today. Best regards, Marie-Claire Dupont, CFO",
+			},
+			expectedType:   "VIP_SIGNATURE_IMPERSONATION",
+			expectedInEvid: "marie.dupont@company.com",
+		},
+		{
+			name:  "VIP from internal address",
+			email: domain.Email{SenderName: "John Smith", SenderEmail: "john.smith@company.com"},
+		},
+		{
+			name:  "one name only",
+			email: domain.Email{SenderName: "John", SenderEmail: "john@vendor.com"},
+		},
+		{
+			name: "VIP mentioned at the start of a long email",
+			email: domain.Email{
+				SenderEmail: "events@conference.com",
+				BodyPreview: "John Smith confirmed his keynote. " + repeatWords("agenda", signatureWords) + " Regards, Events team",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detection := strategy.Detect(tt.email, nil, context)
+			if tt.expectedType == "" {
+				assert.Nil(t, detection)
+				return
+			}
+			require.NotNil(t, detection)
+			assert.Equal(t, tt.expectedType, detection.Type)
+			assert.Contains(t, detection.Evidence, tt.expectedInEvid)
+		})
+	}
+
+	assert.Nil(t, strategy.Detect(tests[0].email, nil, NewDetectionContext([]string{"company.com"}, []string{})), "no VIPs")
+}
+
+func TestDetector_AnalyzeEmail_VIP(t *testing.T) {
+	detector := NewDetector([]string{"company.com"}, []string{})
+	recipient := &domain.User{Email: "john.smith@company.com", Role: "Product Manager"}
+	email := domain.Email{
+		SenderEmail: "partner@vendor.com",
+		Subject:     "Urgent payment",
+		BodyPreview: "Please process the payment today",
+	}
+
+	plain := detector.AnalyzeEmail(email, recipient)
+	require.NotEmpty(t, plain.DetectedThreats)
+	assert.False(t, plain.VIPRecipient)
+
+	thresholds := &domain.RiskThresholds{Critical: 0.70, High: 0.50, Medium: 0.30, Low: 0.10}
+	protected := detector.Protecting(testVIPs(), thresholds).AnalyzeEmail(email, recipient)
+	assert.True(t, protected.VIPRecipient)
+	assert.Greater(t, protected.DetectedThreats[0].Confidence, plain.DetectedThreats[0].Confidence)
+	assert.Equal(t, thresholds.Level(protected.RiskScore), protected.RiskLevel)
+	assert.True(t, domain.RiskLevelAtLeast(protected.RiskLevel, plain.RiskLevel))
+	assert.NotEqual(t, plain.RiskLevel, protected.RiskLevel)
+
+	// Other recipients keep the default thresholds
+	other := detector.Protecting(testVIPs(), thresholds).AnalyzeEmail(email, &domain.User{Email: "dev@company.com"})
+	assert.False(t, other.VIPRecipient)
+	assert.Equal(t, plain.RiskLevel, other.RiskLevel)
+}
+
+func repeatWords(word string, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = word
+	}
+	return strings.Join(words, " ")
+}
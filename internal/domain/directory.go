@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// UserRef identifies a user of the directory, such as a manager
+type UserRef struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+}
+
+// InGroup reports whether the user is a member of a directory group, ignoring case
+func (u *User) InGroup(name string) bool {
+	for _, group := range u.Groups {
+		if strings.EqualFold(group, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagedBy reports whether an address belongs to one of the user's managers, and
+// at which level (1 for the direct manager)
+func (u *User) ManagedBy(address string) (int, bool) {
+	for i, manager := range u.Managers {
+		if strings.EqualFold(manager.Email, address) {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// Departed reports whether the user left the directory
+func (u *User) Departed() bool {
+	return u.DeletedAt != nil
+}
@@ -0,0 +1,132 @@
+// Package directory reconciles the user directory synced from a tenant's provider
+//
+// Providers report each user's manager by reference (a provider user ID for Graph,
+// an address for Google Directory): Link resolves the references into manager IDs,
+// dropping links that would loop. Users missing from a complete sync have left the
+// organization and are soft-deleted, unless the sync would remove an implausible
+// share of the directory (a partial read must not deprovision everyone).
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// MaxDepth bounds the manager chains followed for a user
+const MaxDepth = 10
+
+// ErrTooManyDepartures is returned when a sync would soft-delete too many users
+var ErrTooManyDepartures = errors.New("too many departed users")
+
+// Normalize lowercases a synced user's address and trims, deduplicates and sorts its groups
+func Normalize(user *domain.User) {
+	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+	user.Department = strings.TrimSpace(user.Department)
+
+	seen := make(map[string]bool, len(user.Groups))
+	groups := make([]string, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		group = strings.TrimSpace(group)
+		key := strings.ToLower(group)
+		if group == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	user.Groups = groups
+}
+
+// Link sets each user's ManagerID from its ManagerRef, matched against the provider
+// user IDs and addresses of users, and returns the number of links dropped
+// References to unknown users, self-references and links closing a loop are
+// dropped: the first links, in order, are kept.
+func Link(users []domain.User) int {
+	byRef := make(map[string]uuid.UUID, 2*len(users))
+	for _, user := range users {
+		byRef[user.ProviderUserID] = user.ID
+		byRef[strings.ToLower(user.Email)] = user.ID
+	}
+
+	managers := make(map[uuid.UUID]uuid.UUID, len(users))
+	dropped := 0
+	for i := range users {
+		users[i].ManagerID = nil
+		ref := users[i].ManagerRef
+		if ref == "" {
+			continue
+		}
+		managerID, ok := byRef[ref]
+		if !ok {
+			managerID, ok = byRef[strings.ToLower(ref)]
+		}
+		if !ok || reaches(managers, managerID, users[i].ID) {
+			dropped++
+			continue
+		}
+		managers[users[i].ID] = managerID
+		users[i].ManagerID = &managerID
+	}
+	return dropped
+}
+
+// reaches reports whether following managers from id leads to target
+func reaches(managers map[uuid.UUID]uuid.UUID, id, target uuid.UUID) bool {
+	for {
+		if id == target {
+			return true
+		}
+		next, ok := managers[id]
+		if !ok {
+			return false
+		}
+		id = next
+	}
+}
+
+// Chain returns the managers of a user, nearest first, following ManagerID among
+// users for at most MaxDepth levels
+func Chain(users map[uuid.UUID]*domain.User, userID uuid.UUID) []domain.UserRef {
+	var chain []domain.UserRef
+	user := users[userID]
+	for user != nil && user.ManagerID != nil && len(chain) < MaxDepth {
+		manager, ok := users[*user.ManagerID]
+		if !ok {
+			break
+		}
+		chain = append(chain, domain.UserRef{ID: manager.ID, Email: manager.Email, DisplayName: manager.DisplayName})
+		user = manager
+	}
+	return chain
+}
+
+// Departed returns the users of existing that are not departed yet and missing from
+// synced, matched by provider user ID
+func Departed(existing, synced []domain.User) []domain.User {
+	present := make(map[string]bool, len(synced))
+	for _, user := range synced {
+		present[user.ProviderUserID] = true
+	}
+	departed := make([]domain.User, 0)
+	for _, user := range existing {
+		if !user.Departed() && !present[user.ProviderUserID] {
+			departed = append(departed, user)
+		}
+	}
+	return departed
+}
+
+// CheckDepartures returns ErrTooManyDepartures if departed users are more than
+// maxFraction of the active ones
+func CheckDepartures(active, departed int, maxFraction float64) error {
+	if departed > 0 && float64(departed) > maxFraction*float64(active) {
+		return fmt.Errorf("%w: %d of %d users", ErrTooManyDepartures, departed, active)
+	}
+	return nil
+}
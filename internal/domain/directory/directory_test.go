@@ -0,0 +1,138 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+func user(providerID, email, managerRef string) domain.User {
+	return domain.User{ID: uuid.New(), ProviderUserID: providerID, Email: email, DisplayName: providerID, ManagerRef: managerRef}
+}
+
+func TestNormalize(t *testing.T) {
+	u := domain.User{Email: " CFO@Company.com ", Department: " Finance ", Groups: []string{"Finance", " executives", "", "finance", "Executives "}}
+	Normalize(&u)
+	assert.Equal(t, "cfo@company.com", u.Email)
+	assert.Equal(t, "Finance", u.Department)
+	assert.Equal(t, []string{"Finance", "executives"}, u.Groups)
+}
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name        string
+		users       []domain.User
+		wantManager map[int]int // User index to manager index; absent means no manager
+		wantDropped int
+	}{
+		{
+			name: "by provider ID and by address",
+			users: []domain.User{
+				user("ceo", "ceo@company.com", ""),
+				user("cfo", "cfo@company.com", "ceo"),
+				user("accountant", "accountant@company.com", "CFO@company.com"),
+			},
+			wantManager: map[int]int{1: 0, 2: 1},
+		},
+		{
+			name: "unknown manager and self reference",
+			users: []domain.User{
+				user("a", "a@company.com", "departed"),
+				user("b", "b@company.com", "b"),
+			},
+			wantManager: map[int]int{},
+			wantDropped: 2,
+		},
+		{
+			name: "loop broken at the closing link",
+			users: []domain.User{
+				user("a", "a@company.com", "b"),
+				user("b", "b@company.com", "c"),
+				user("c", "c@company.com", "a"),
+			},
+			wantManager: map[int]int{0: 1, 1: 2},
+			wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := Link(tt.users)
+			assert.Equal(t, tt.wantDropped, dropped)
+			for i, u := range tt.users {
+				managerIndex, ok := tt.wantManager[i]
+				if !ok {
+					assert.Nil(t, u.ManagerID, u.ProviderUserID)
+					continue
+				}
+				require.NotNil(t, u.ManagerID, u.ProviderUserID)
+				assert.Equal(t, tt.users[managerIndex].ID, *u.ManagerID, u.ProviderUserID)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	users := []domain.User{
+		user("ceo", "ceo@company.com", ""),
+		user("cfo", "cfo@company.com", "ceo"),
+		user("accountant", "accountant@company.com", "cfo"),
+	}
+	Link(users)
+	byID := make(map[uuid.UUID]*domain.User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+
+	chain := Chain(byID, users[2].ID)
+	require.Len(t, chain, 2)
+	assert.Equal(t, "cfo@company.com", chain[0].Email)
+	assert.Equal(t, "ceo@company.com", chain[1].Email)
+	assert.Empty(t, Chain(byID, users[0].ID))
+	assert.Empty(t, Chain(byID, uuid.New()))
+
+	// Chains are bounded even if stored links loop
+	users[0].ManagerID = &users[2].ID
+	assert.Len(t, Chain(byID, users[2].ID), MaxDepth)
+}
+
+func TestDeparted(t *testing.T) {
+	left := time.Now()
+	existing := []domain.User{
+		user("stays", "stays@company.com", ""),
+		user("leaves", "leaves@company.com", ""),
+		user("left", "left@company.com", ""),
+	}
+	existing[2].DeletedAt = &left
+	synced := []domain.User{user("stays", "stays@company.com", ""), user("joins", "joins@company.com", "")}
+
+	departed := Departed(existing, synced)
+	require.Len(t, departed, 1)
+	assert.Equal(t, "leaves", departed[0].ProviderUserID)
+}
+
+func TestCheckDepartures(t *testing.T) {
+	tests := []struct {
+		active, departed int
+		wantErr          bool
+	}{
+		{active: 100, departed: 0},
+		{active: 100, departed: 50},
+		{active: 100, departed: 51, wantErr: true},
+		{active: 0, departed: 0},
+		{active: 2, departed: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := CheckDepartures(tt.active, tt.departed, 0.5)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrTooManyDepartures, "%d of %d", tt.departed, tt.active)
+		} else {
+			assert.NoError(t, err, "%d of %d", tt.departed, tt.active)
+		}
+	}
+}
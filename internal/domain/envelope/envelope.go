@@ -0,0 +1,104 @@
+// Package envelope implements AES-256-GCM envelope encryption
+//
+// Secrets are encrypted with a data key (one per tenant); data keys are themselves
+// encrypted ("wrapped") with a master key held by a key manager. Rotating a master
+// key only re-wraps data keys: secrets are not re-encrypted. Additional data binds a
+// ciphertext to its context: a secret to its tenant ID, so it cannot be moved to
+// another tenant's row, and a wrapped data key to the ID of its master key.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of data and master keys (AES-256)
+const KeySize = 32
+
+// sealedPrefix marks stored ciphertexts, with the format version
+const sealedPrefix = "enc:v1:"
+
+var (
+	// ErrNotSealed is returned when opening a value that was stored in the clear
+	ErrNotSealed = errors.New("value is not sealed")
+	// ErrDecrypt is returned for a ciphertext that was tampered with, or opened
+	// with the wrong key or context
+	ErrDecrypt = errors.New("failed to decrypt sealed value")
+)
+
+// NewKey returns a random AES-256 key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with key, authenticating aad; the nonce is prepended
+func Seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Open decrypts a Seal output, failing with ErrDecrypt if key or aad do not match
+func Open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Encode returns the stored text form of a Seal output
+func Encode(sealed []byte) string {
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Decode parses the stored text form, failing with ErrNotSealed for other text
+func Decode(text string) ([]byte, error) {
+	if !IsSealed(text) {
+		return nil, ErrNotSealed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(text, sealedPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return sealed, nil
+}
+
+// IsSealed reports whether stored text is an Encode output
+func IsSealed(text string) bool {
+	return strings.HasPrefix(text, sealedPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d, want %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,69 @@
+package envelope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	otherKey, err := NewKey()
+	require.NoError(t, err)
+	aad := []byte("tenant-1")
+
+	sealed, err := Seal(key, []byte("client secret"), aad)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "client secret")
+
+	plaintext, err := Open(key, sealed, aad)
+	require.NoError(t, err)
+	assert.Equal(t, "client secret", string(plaintext))
+
+	again, err := Seal(key, []byte("client secret"), aad)
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "random nonces")
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		key    []byte
+		sealed []byte
+		aad    []byte
+	}{
+		{"wrong key", otherKey, sealed, aad},
+		{"wrong context", key, sealed, []byte("tenant-2")},
+		{"tampered", key, tampered, aad},
+		{"truncated", key, sealed[:10], aad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(tt.key, tt.sealed, tt.aad)
+			assert.ErrorIs(t, err, ErrDecrypt)
+		})
+	}
+}
+
+func TestSeal_InvalidKey(t *testing.T) {
+	_, err := Seal([]byte("short"), []byte("secret"), nil)
+	assert.Error(t, err)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	sealed := []byte{0, 1, 2, 250, 255}
+	text := Encode(sealed)
+	assert.True(t, IsSealed(text))
+
+	decoded, err := Decode(text)
+	require.NoError(t, err)
+	assert.Equal(t, sealed, decoded)
+
+	_, err = Decode(`{"grant":"client_credentials"}`)
+	assert.ErrorIs(t, err, ErrNotSealed)
+	_, err = Decode("enc:v1:not base64!")
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
@@ -0,0 +1,204 @@
+package evaluation
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/calibration"
+	"github.com/stoik/email-security/internal/domain/detection"
+)
+
+// minCalibrationSamples is the fewest firings of a detection type worth calibrating
+// Below this, a fitted map is noise and the raw confidence is kept.
+const minCalibrationSamples = 10
+
+// Example is a reviewed email with the recipient it was addressed to
+type Example struct {
+	Email     domain.Email
+	Recipient *domain.User
+	Fraud     bool
+}
+
+// Result is the confusion matrix of one predictor over the corpus
+type Result struct {
+	Name      string
+	Confusion Confusion
+}
+
+// Report summarizes a Detector run over a labeled corpus
+//
+// Strategies treats "the strategy fired" as a fraud prediction, which measures each
+// signal on its own. Levels treats "risk level at or above L" as a fraud prediction,
+// which measures the alerting thresholds. Curve covers every risk score threshold.
+type Report struct {
+	Examples         int
+	Fraud            int
+	Strategies       []Result
+	Levels           []Result
+	Curve            []CurvePoint
+	ROCAUC           float64
+	AveragePrecision float64
+}
+
+// run is a detector pass over the corpus
+type run struct {
+	analyses []domain.FraudAnalysis
+	labels   []bool
+}
+
+func analyze(detector *detection.Detector, examples []Example) run {
+	r := run{
+		analyses: make([]domain.FraudAnalysis, len(examples)),
+		labels:   make([]bool, len(examples)),
+	}
+	for i, example := range examples {
+		r.analyses[i] = detector.AnalyzeEmail(example.Email, example.Recipient)
+		r.labels[i] = example.Fraud
+	}
+	return r
+}
+
+// Run evaluates the detector over a labeled corpus
+func Run(detector *detection.Detector, examples []Example) Report {
+	r := analyze(detector, examples)
+
+	strategies := detector.StrategyNames()
+	byStrategy := make(map[string]*Confusion, len(strategies))
+	for _, name := range strategies {
+		byStrategy[name] = &Confusion{}
+	}
+	byLevel := make(map[string]*Confusion, len(domain.RiskLevels))
+	for _, level := range domain.RiskLevels {
+		byLevel[level] = &Confusion{}
+	}
+
+	report := Report{Examples: len(examples)}
+	scores := make([]float64, len(examples))
+	for i, analysis := range r.analyses {
+		fraud := r.labels[i]
+		if fraud {
+			report.Fraud++
+		}
+		scores[i] = analysis.RiskScore
+
+		fired := make(map[string]bool, len(analysis.DetectedThreats))
+		for _, det := range analysis.DetectedThreats {
+			fired[det.Strategy] = true
+		}
+		for name, confusion := range byStrategy {
+			confusion.Add(fired[name], fraud)
+		}
+		for level, confusion := range byLevel {
+			confusion.Add(domain.RiskLevelAtLeast(analysis.RiskLevel, level), fraud)
+		}
+	}
+
+	for _, name := range strategies {
+		report.Strategies = append(report.Strategies, Result{Name: name, Confusion: *byStrategy[name]})
+	}
+	for _, level := range domain.RiskLevels {
+		report.Levels = append(report.Levels, Result{Name: level, Confusion: *byLevel[level]})
+	}
+	report.Curve = Curve(scores, r.labels)
+	report.ROCAUC = ROCAUC(report.Curve)
+	report.AveragePrecision = AveragePrecision(report.Curve)
+	return report
+}
+
+// Split shuffles the corpus and sets aside a holdout fraction, so that calibration
+// fitted on the train split is measured on emails it has not seen
+// Corpora too small to leave both splits non-empty are not split.
+func Split(examples []Example, fraction float64, seed int64) (train, holdout []Example) {
+	n := int(float64(len(examples)) * fraction)
+	if n <= 0 || n >= len(examples) {
+		return examples, nil
+	}
+
+	shuffled := append([]Example(nil), examples...)
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return shuffled[n:], shuffled[:n]
+}
+
+// FitCalibration fits calibration maps for the detector over a labeled corpus
+//
+// Fitting is done in two passes: detection confidences are calibrated first, then
+// the detector is rerun with those maps to calibrate the aggregated risk score it
+// actually produces. Detection types that fired fewer than minCalibrationSamples
+// times keep their raw confidence; likewise RiskScore is left nil when fewer emails
+// triggered any detection. The set is keyed by the detector's aggregator, the only
+// one whose scores it calibrates.
+func FitCalibration(detector *detection.Detector, examples []Example, method, version string) (*calibration.Set, error) {
+	set := &calibration.Set{
+		Version:    version,
+		FittedAt:   time.Now().UTC(),
+		Aggregator: detector.AggregatorName(),
+		Detections: make(map[string]calibration.Map),
+	}
+	if set.Version == "" {
+		set.Version = set.FittedAt.Format("20060102-150405")
+	}
+
+	// Pass 1: raw confidence of each detection type against the email verdict
+	uncalibrated := analyze(detector.Calibrated(nil), examples)
+	confidences := make(map[string][]float64)
+	labels := make(map[string][]bool)
+	for i, analysis := range uncalibrated.analyses {
+		for _, det := range analysis.DetectedThreats {
+			confidences[det.Type] = append(confidences[det.Type], det.Confidence)
+			labels[det.Type] = append(labels[det.Type], uncalibrated.labels[i])
+		}
+	}
+
+	types := make([]string, 0, len(confidences))
+	for detectionType := range confidences {
+		types = append(types, detectionType)
+	}
+	sort.Strings(types)
+	for _, detectionType := range types {
+		if len(confidences[detectionType]) < minCalibrationSamples {
+			continue
+		}
+		m, err := calibration.Fit(method, confidences[detectionType], labels[detectionType])
+		if err != nil {
+			return nil, err
+		}
+		set.Detections[detectionType] = m
+	}
+
+	// Pass 2: aggregated score with calibrated confidences. Emails where nothing
+	// fired are left out, as the Detector never calibrates their zero score.
+	calibrated := analyze(detector.Calibrated(set), examples)
+	scores := make([]float64, 0, len(examples))
+	scoreLabels := make([]bool, 0, len(examples))
+	for i, analysis := range calibrated.analyses {
+		if len(analysis.DetectedThreats) == 0 {
+			continue
+		}
+		scores = append(scores, analysis.RiskScore)
+		scoreLabels = append(scoreLabels, calibrated.labels[i])
+	}
+	if len(scores) < minCalibrationSamples {
+		return set, nil
+	}
+	scoreMap, err := calibration.Fit(method, scores, scoreLabels)
+	if err != nil {
+		return nil, err
+	}
+	set.RiskScore = &scoreMap
+
+	return set, nil
+}
+
+// LogisticExamples reduces the corpus to the uncalibrated detections each email
+// triggered, the training input of detection.FitLogisticAggregator
+func LogisticExamples(detector *detection.Detector, examples []Example) []detection.LogisticExample {
+	r := analyze(detector.Calibrated(nil), examples)
+	result := make([]detection.LogisticExample, len(examples))
+	for i, analysis := range r.analyses {
+		result[i] = detection.LogisticExample{Detections: analysis.DetectedThreats, Fraud: r.labels[i]}
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+package evaluation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/calibration"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfusion_Metrics(t *testing.T) {
+	c := Confusion{}
+	for _, p := range []struct{ predicted, fraud bool }{
+		{true, true}, {true, true}, {true, false}, {false, true}, {false, false}, {false, false},
+	} {
+		c.Add(p.predicted, p.fraud)
+	}
+
+	assert.Equal(t, Confusion{TP: 2, FP: 1, TN: 2, FN: 1}, c)
+	assert.InDelta(t, 2.0/3, c.Precision(), 1e-9)
+	assert.InDelta(t, 2.0/3, c.Recall(), 1e-9)
+	assert.InDelta(t, 2.0/3, c.F1(), 1e-9)
+	assert.InDelta(t, 4.0/6, c.Accuracy(), 1e-9)
+	assert.Equal(t, 0.0, Confusion{}.F1(), "empty matrix has no F1")
+}
+
+func TestCurve(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float64
+		labels []bool
+		auc    float64
+		ap     float64
+	}{
+		{
+			name:   "perfect ranking",
+			scores: []float64{0.9, 0.8, 0.2, 0.1},
+			labels: []bool{true, true, false, false},
+			auc:    1,
+			ap:     1,
+		},
+		{
+			name:   "inverted ranking",
+			scores: []float64{0.9, 0.8, 0.2, 0.1},
+			labels: []bool{false, false, true, true},
+			auc:    0,
+			ap:     5.0 / 12, // Recall 0.5 at precision 1/3, then 1.0 at 1/2
+		},
+		{
+			name:   "all tied",
+			scores: []float64{0.5, 0.5, 0.5, 0.5},
+			labels: []bool{true, false, true, false},
+			auc:    0.5,
+			ap:     0.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := Curve(tt.scores, tt.labels)
+
+			last := points[len(points)-1]
+			assert.Equal(t, 1.0, last.TPR, "lowest threshold flags every fraud")
+			assert.Equal(t, 1.0, last.FPR, "lowest threshold flags every legit email")
+			assert.InDelta(t, tt.auc, ROCAUC(points), 1e-9)
+			assert.InDelta(t, tt.ap, AveragePrecision(points), 1e-9)
+		})
+	}
+}
+
+func typosquat(i int) domain.Email {
+	return domain.Email{
+		ID:          uuid.New(),
+		SenderEmail: fmt.Sprintf("billing%d@micros0ft.com", i),
+		SenderName:  "Microsoft Billing",
+		Subject:     "Your subscription",
+		Headers:     map[string]string{},
+	}
+}
+
+func newsletter(i int) domain.Email {
+	return domain.Email{
+		ID:          uuid.New(),
+		SenderEmail: fmt.Sprintf("news%d@partner.org", i),
+		SenderName:  "Partner News",
+		Subject:     "Monthly newsletter",
+		BodyPreview: "Here is what happened this month.",
+		Headers:     map[string]string{},
+	}
+}
+
+func TestRun(t *testing.T) {
+	detector := detection.NewDetector([]string{"company.com"}, []string{"microsoft.com"})
+	examples := []Example{
+		{Email: typosquat(1), Fraud: true},
+		{Email: typosquat(2), Fraud: false}, // Mislabeled on purpose: one false positive
+		{Email: newsletter(1), Fraud: false},
+		{Email: newsletter(2), Fraud: true}, // Missed fraud
+	}
+
+	report := Run(detector, examples)
+
+	assert.Equal(t, 4, report.Examples)
+	assert.Equal(t, 2, report.Fraud)
+	require.Len(t, report.Strategies, len(detector.StrategyNames()))
+	byName := make(map[string]Confusion)
+	for _, result := range report.Strategies {
+		byName[result.Name] = result.Confusion
+	}
+	assert.Equal(t, Confusion{TP: 1, FP: 1, TN: 1, FN: 1}, byName["Domain Typosquatting"])
+	require.Len(t, report.Levels, len(domain.RiskLevels))
+	assert.Equal(t, "critical", report.Levels[0].Name)
+	assert.Equal(t, 1, report.Levels[len(report.Levels)-1].Confusion.TP)
+	assert.InDelta(t, 0.5, report.ROCAUC, 1e-9)
+}
+
+func TestFitCalibration(t *testing.T) {
+	detector := detection.NewDetector([]string{"company.com"}, []string{"microsoft.com"})
+
+	// Typosquatting reports 0.90 but only 3 in 4 of these emails are fraud
+	examples := make([]Example, 0, 40)
+	for i := 0; i < 20; i++ {
+		examples = append(examples, Example{Email: typosquat(i), Fraud: i%4 != 0})
+		examples = append(examples, Example{Email: newsletter(i), Fraud: false})
+	}
+
+	set, err := FitCalibration(detector, examples, calibration.MethodIsotonic, "test")
+	require.NoError(t, err)
+
+	assert.Equal(t, detection.AggregatorWeightedMax, set.Aggregator)
+	require.Contains(t, set.Detections, "DOMAIN_TYPOSQUATTING")
+	assert.InDelta(t, 0.75, set.Confidence("DOMAIN_TYPOSQUATTING", 0.90), 1e-9)
+	require.NotNil(t, set.RiskScore)
+
+	analysis := detector.Calibrated(set).AnalyzeEmail(typosquat(99), nil)
+	require.Len(t, analysis.DetectedThreats, 1)
+	assert.InDelta(t, 0.90, analysis.DetectedThreats[0].RawConfidence, 1e-9)
+	assert.InDelta(t, 0.75, analysis.DetectedThreats[0].Confidence, 1e-9)
+	assert.InDelta(t, 0.75, analysis.RiskScore, 1e-9)
+	assert.InDelta(t, analysis.RiskScore, analysis.DetectedThreats[0].Contribution, 1e-9)
+
+	// Another aggregator's scores are not what the maps were fitted on
+	noisyOR := detector.Calibrated(set).Using(detection.NewNoisyORAggregator(nil)).AnalyzeEmail(typosquat(99), nil)
+	require.Len(t, noisyOR.DetectedThreats, 1)
+	assert.InDelta(t, 0.90, noisyOR.DetectedThreats[0].Confidence, 1e-9)
+	assert.Zero(t, noisyOR.DetectedThreats[0].RawConfidence, "uncalibrated detections have no raw confidence")
+}
+
+func TestSplit(t *testing.T) {
+	examples := make([]Example, 20)
+	for i := range examples {
+		examples[i] = Example{Email: newsletter(i)}
+	}
+
+	train, holdout := Split(examples, 0.25, 1)
+	assert.Len(t, train, 15)
+	assert.Len(t, holdout, 5)
+	seen := make(map[uuid.UUID]bool)
+	for _, example := range append(train, holdout...) {
+		seen[example.Email.ID] = true
+	}
+	assert.Len(t, seen, 20, "every email is in exactly one split")
+
+	train, holdout = Split(examples, 0, 1)
+	assert.Len(t, train, 20)
+	assert.Empty(t, holdout)
+}
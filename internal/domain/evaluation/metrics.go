@@ -0,0 +1,129 @@
+package evaluation
+
+import (
+	"sort"
+)
+
+// Confusion is a binary confusion matrix, "positive" meaning flagged as fraud
+type Confusion struct {
+	TP int `json:"tp"`
+	FP int `json:"fp"`
+	TN int `json:"tn"`
+	FN int `json:"fn"`
+}
+
+// Add records one prediction against its label
+func (c *Confusion) Add(predicted, fraud bool) {
+	switch {
+	case predicted && fraud:
+		c.TP++
+	case predicted && !fraud:
+		c.FP++
+	case !predicted && fraud:
+		c.FN++
+	default:
+		c.TN++
+	}
+}
+
+// Precision is the share of flagged emails that are fraud
+func (c Confusion) Precision() float64 {
+	return ratio(c.TP, c.TP+c.FP)
+}
+
+// Recall is the share of fraud emails that are flagged
+func (c Confusion) Recall() float64 {
+	return ratio(c.TP, c.TP+c.FN)
+}
+
+// F1 is the harmonic mean of precision and recall
+func (c Confusion) F1() float64 {
+	p, r := c.Precision(), c.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+// Accuracy is the share of correct predictions
+func (c Confusion) Accuracy() float64 {
+	return ratio(c.TP+c.TN, c.TP+c.FP+c.TN+c.FN)
+}
+
+// CurvePoint is the operating point of a score threshold
+// Emails scoring at or above Threshold are flagged.
+type CurvePoint struct {
+	Threshold float64
+	TPR       float64 // Recall
+	FPR       float64
+	Precision float64
+}
+
+// Curve computes one operating point per distinct score, from the highest
+// threshold to the lowest, preceded by the "flag nothing" point
+func Curve(scores []float64, labels []bool) []CurvePoint {
+	if len(scores) == 0 || len(scores) != len(labels) {
+		return nil
+	}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	positives, negatives := 0, 0
+	for _, fraud := range labels {
+		if fraud {
+			positives++
+		} else {
+			negatives++
+		}
+	}
+
+	points := []CurvePoint{{Threshold: scores[order[0]] + 1e-9, Precision: 1}}
+	tp, fp := 0, 0
+	for i, idx := range order {
+		if labels[idx] {
+			tp++
+		} else {
+			fp++
+		}
+		// Tied scores share a threshold: emit once all of them are counted
+		if i+1 < len(order) && scores[order[i+1]] == scores[idx] {
+			continue
+		}
+		points = append(points, CurvePoint{
+			Threshold: scores[idx],
+			TPR:       ratio(tp, positives),
+			FPR:       ratio(fp, negatives),
+			Precision: ratio(tp, tp+fp),
+		})
+	}
+	return points
+}
+
+// ROCAUC is the area under the ROC curve (trapezoidal rule)
+func ROCAUC(points []CurvePoint) float64 {
+	area := 0.0
+	for i := 1; i < len(points); i++ {
+		area += (points[i].FPR - points[i-1].FPR) * (points[i].TPR + points[i-1].TPR) / 2
+	}
+	return area
+}
+
+// AveragePrecision summarizes the PR curve as the recall-weighted mean precision
+func AveragePrecision(points []CurvePoint) float64 {
+	ap := 0.0
+	for i := 1; i < len(points); i++ {
+		ap += (points[i].TPR - points[i-1].TPR) * points[i].Precision
+	}
+	return ap
+}
+
+func ratio(num, den int) float64 {
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
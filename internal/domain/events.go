@@ -0,0 +1,149 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published through the outbox
+const (
+	EventEmailIngested     = "email.ingested"
+	EventAnalysisCompleted = "analysis.completed"
+	EventThreatDetected    = "threat.detected"
+)
+
+// ThreatEventMinLevel is the lowest risk level that emits a threat.detected event
+const ThreatEventMinLevel = "high"
+
+// Event is a fact about an email, written to the outbox in the same transaction as
+// the change it describes and relayed to sinks (bus, broker, HTTP) at-least-once
+//
+// Consumers must be idempotent: the same event can be delivered more than once.
+// DedupKey identifies the fact (not the delivery) and is stable across retries, so
+// it is the key to deduplicate on.
+type Event struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	TenantID  uuid.UUID       `json:"tenant_id"`
+	EmailID   uuid.UUID       `json:"email_id"`
+	DedupKey  string          `json:"dedup_key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	// Attempts counts relay attempts so far, including the current one (delivery state, not published)
+	Attempts int `json:"-"`
+}
+
+// RiskLevel returns the risk level carried by the payload ("" for events without one)
+func (e Event) RiskLevel() string {
+	var payload struct {
+		RiskLevel string `json:"risk_level"`
+	}
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return ""
+	}
+	return payload.RiskLevel
+}
+
+// EmailIngestedPayload is the payload of email.ingested
+type EmailIngestedPayload struct {
+	UserID         uuid.UUID `json:"user_id"`
+	Subject        string    `json:"subject"`
+	SenderEmail    string    `json:"sender_email"`
+	RecipientEmail string    `json:"recipient_email"`
+	ReceivedAt     time.Time `json:"received_at"`
+}
+
+// AnalysisCompletedPayload is the payload of analysis.completed
+type AnalysisCompletedPayload struct {
+	AnalysisID  uuid.UUID `json:"analysis_id"`
+	RiskScore   float64   `json:"risk_score"`
+	RiskLevel   string    `json:"risk_level"`
+	Aggregator  string    `json:"aggregator"`
+	ThreatTypes []string  `json:"threat_types"`
+}
+
+// ThreatDetectedPayload is the payload of threat.detected
+// It carries what an alert needs (sender, recipient, subject, evidence) so
+// consumers such as webhooks and chat notifications don't read back the email.
+type ThreatDetectedPayload struct {
+	AnalysisID     uuid.UUID   `json:"analysis_id"`
+	RiskScore      float64     `json:"risk_score"`
+	RiskLevel      string      `json:"risk_level"`
+	Subject        string      `json:"subject"`
+	SenderEmail    string      `json:"sender_email"`
+	SenderName     string      `json:"sender_name"`
+	RecipientEmail string      `json:"recipient_email"`
+	Threats        []Detection `json:"threats"`
+}
+
+// NewEmailIngestedEvent describes a newly stored email
+func NewEmailIngestedEvent(email Email) (Event, error) {
+	return newEvent(EventEmailIngested, email.TenantID, email.ID, email.ID, EmailIngestedPayload{
+		UserID:         email.UserID,
+		Subject:        email.Subject,
+		SenderEmail:    email.SenderEmail,
+		RecipientEmail: email.RecipientEmail,
+		ReceivedAt:     email.ReceivedAt,
+	})
+}
+
+// NewAnalysisEvents describes a stored analysis: analysis.completed, plus
+// threat.detected when its risk level is ThreatEventMinLevel or above
+// The analysis must have its ID set; it is the dedup key of both events.
+func NewAnalysisEvents(email Email, analysis FraudAnalysis) ([]Event, error) {
+	threatTypes := make([]string, len(analysis.DetectedThreats))
+	for i, threat := range analysis.DetectedThreats {
+		threatTypes[i] = threat.Type
+	}
+
+	completed, err := newEvent(EventAnalysisCompleted, email.TenantID, email.ID, analysis.ID, AnalysisCompletedPayload{
+		AnalysisID:  analysis.ID,
+		RiskScore:   analysis.RiskScore,
+		RiskLevel:   analysis.RiskLevel,
+		Aggregator:  analysis.Aggregator,
+		ThreatTypes: threatTypes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{completed}
+
+	if RiskLevelAtLeast(analysis.RiskLevel, ThreatEventMinLevel) {
+		threat, err := newEvent(EventThreatDetected, email.TenantID, email.ID, analysis.ID, ThreatDetectedPayload{
+			AnalysisID:     analysis.ID,
+			RiskScore:      analysis.RiskScore,
+			RiskLevel:      analysis.RiskLevel,
+			Subject:        email.Subject,
+			SenderEmail:    email.SenderEmail,
+			SenderName:     email.SenderName,
+			RecipientEmail: email.RecipientEmail,
+			Threats:        analysis.DetectedThreats,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, threat)
+	}
+	return events, nil
+}
+
+// newEvent builds an event whose dedup key is its type and the ID of the entity it describes
+func newEvent(eventType string, tenantID, emailID, entityID uuid.UUID, payload interface{}) (Event, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal %s payload: %w", eventType, err)
+	}
+	return Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		TenantID:  tenantID,
+		EmailID:   emailID,
+		DedupKey:  eventType + ":" + entityID.String(),
+		Payload:   raw,
+		CreatedAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidHunt is returned for hunt queries without criteria or with malformed ones
+var ErrInvalidHunt = errors.New("invalid hunt query")
+
+// HuntQuery searches stored emails for a reported campaign (see package hunt)
+// Set criteria must all match; unset ones match any email. Text criteria ignore case.
+type HuntQuery struct {
+	TenantID uuid.UUID `json:"tenant_id,omitempty"` // uuid.Nil searches every tenant
+
+	Sender       string `json:"sender,omitempty"`        // Exact sender address
+	SenderDomain string `json:"sender_domain,omitempty"` // Sender domain or any of its subdomains
+	// LookalikeOf matches sender domains resembling a domain (typosquats, homoglyphs,
+	// other TLDs or the name embedded in a longer domain), but not the domain itself
+	LookalikeOf string `json:"lookalike_of,omitempty"`
+
+	Text           string            `json:"text,omitempty"`            // Substring of the subject or body preview (only the preview is stored)
+	AttachmentName string            `json:"attachment_name,omitempty"` // Substring of an attachment file name
+	AttachmentHash string            `json:"attachment_hash,omitempty"` // SHA-256 of an attachment's content, hex
+	Headers        map[string]string `json:"headers,omitempty"`         // Header name (as stored) to exact value
+
+	From time.Time `json:"from,omitempty"` // Received at or after From; zero is unbounded
+	To   time.Time `json:"to,omitempty"`   // Received before To; zero is unbounded
+
+	Limit int `json:"limit,omitempty"` // Maximum number of matches (default: hunt.DefaultLimit)
+}
+
+// HuntMatch is a stored email matching a hunt, with what the SOC needs to triage it
+type HuntMatch struct {
+	Email      Email   `json:"email"`
+	TenantName string  `json:"tenant_name"`
+	RiskLevel  string  `json:"risk_level,omitempty"` // Of the current analysis; empty if not analyzed yet
+	RiskScore  float64 `json:"risk_score,omitempty"`
+}
+
+// HuntReport summarizes a hunt's matches: how far a campaign spread
+type HuntReport struct {
+	Matches   int           `json:"matches"`
+	Tenants   int           `json:"tenants"`   // Distinct tenants that received a match
+	Mailboxes int           `json:"mailboxes"` // Distinct recipient mailboxes
+	Flagged   int           `json:"flagged"`   // Matches whose current analysis raised a threat alert
+	Truncated bool          `json:"truncated"` // More emails matched than the query's limit
+	Elapsed   time.Duration `json:"elapsed"`
+}
@@ -0,0 +1,232 @@
+// Package hunt searches stored emails for a reported campaign
+//
+// When a campaign is reported, the SOC hunts for every mailbox that already
+// received it: by sender, sender domain or lookalikes of a brand's domain, text,
+// attachment name or hash, header values and time window, in one tenant or all.
+// Storage prefilters candidates with its indexes; Matches is the authoritative
+// check every returned email passes.
+package hunt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/detection"
+	"github.com/stoik/email-security/internal/domain/senderlist"
+)
+
+const (
+	// DefaultLimit is the number of matches returned when a query sets no limit
+	DefaultLimit = 1000
+
+	// MaxLimit caps the matches of a single hunt; narrow the query to see more
+	MaxLimit = 50000
+
+	// MinTextLength is the shortest text or attachment name searched: shorter
+	// substrings can't use trigram indexes and match nearly every email
+	MinTextLength = 3
+
+	// LookalikeSimilarity is the percent similarity (see detection.DomainSimilarity)
+	// above which a domain is a lookalike. It is lower than the typosquatting
+	// strategy's: a hunt favors recall, and an analyst reviews the results.
+	LookalikeSimilarity = 80
+
+	// minEmbeddedLength is the shortest domain name flagged when embedded in a longer
+	// domain ("paypal-secure.com"); shorter names occur in unrelated domains
+	minEmbeddedLength = 4
+)
+
+// Normalize puts a query's criteria in the form they are matched in: lowercase
+// addresses, domains and hash, trimmed text, the default limit
+func Normalize(q *domain.HuntQuery) {
+	q.Sender = strings.ToLower(strings.TrimSpace(q.Sender))
+	q.SenderDomain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(q.SenderDomain)), "@")
+	q.LookalikeOf = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(q.LookalikeOf)), "@")
+	q.Text = strings.TrimSpace(q.Text)
+	q.AttachmentName = strings.TrimSpace(q.AttachmentName)
+	q.AttachmentHash = strings.ToLower(strings.TrimSpace(q.AttachmentHash))
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+}
+
+// Validate checks that a normalized query has at least one criterion on the emails
+// themselves, well-formed criteria and a valid time window and limit
+// Tenant and time window alone are not a hunt: they would export whole mailboxes.
+func Validate(q domain.HuntQuery) error {
+	if q.Sender == "" && q.SenderDomain == "" && q.LookalikeOf == "" && q.Text == "" &&
+		q.AttachmentName == "" && q.AttachmentHash == "" && len(q.Headers) == 0 {
+		return fmt.Errorf("%w: hunt needs a sender, domain, text, attachment or header criterion", domain.ErrInvalidHunt)
+	}
+	if q.Sender != "" && strings.LastIndex(q.Sender, "@") <= 0 {
+		return fmt.Errorf("%w: invalid sender address %q", domain.ErrInvalidHunt, q.Sender)
+	}
+	if q.SenderDomain != "" && !validDomain(q.SenderDomain) {
+		return fmt.Errorf("%w: invalid sender domain %q", domain.ErrInvalidHunt, q.SenderDomain)
+	}
+	if q.LookalikeOf != "" && !validDomain(q.LookalikeOf) {
+		return fmt.Errorf("%w: invalid lookalike domain %q", domain.ErrInvalidHunt, q.LookalikeOf)
+	}
+	if q.Text != "" && len([]rune(q.Text)) < MinTextLength {
+		return fmt.Errorf("%w: text must be at least %d characters", domain.ErrInvalidHunt, MinTextLength)
+	}
+	if q.AttachmentName != "" && len([]rune(q.AttachmentName)) < MinTextLength {
+		return fmt.Errorf("%w: attachment name must be at least %d characters", domain.ErrInvalidHunt, MinTextLength)
+	}
+	if q.AttachmentHash != "" {
+		if raw, err := hex.DecodeString(q.AttachmentHash); err != nil || len(raw) != 32 {
+			return fmt.Errorf("%w: attachment hash must be a hex SHA-256, got %q", domain.ErrInvalidHunt, q.AttachmentHash)
+		}
+	}
+	for name := range q.Headers {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%w: header criterion needs a name", domain.ErrInvalidHunt)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return fmt.Errorf("%w: invalid hunt window: %s is not before %s", domain.ErrInvalidHunt, q.From, q.To)
+	}
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrInvalidHunt, MaxLimit)
+	}
+	return nil
+}
+
+// Matches reports whether an email meets every criterion of a normalized query
+func Matches(q domain.HuntQuery, email domain.Email) bool {
+	sender := strings.ToLower(strings.TrimSpace(email.SenderEmail))
+	senderDomain := ""
+	if i := strings.LastIndex(sender, "@"); i >= 0 {
+		senderDomain = sender[i+1:]
+	}
+
+	switch {
+	case q.TenantID != uuid.Nil && email.TenantID != q.TenantID:
+		return false
+	case !q.From.IsZero() && email.ReceivedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && !email.ReceivedAt.Before(q.To):
+		return false
+	case q.Sender != "" && sender != q.Sender:
+		return false
+	case q.SenderDomain != "" && senderDomain != q.SenderDomain && !strings.HasSuffix(senderDomain, "."+q.SenderDomain):
+		return false
+	case q.LookalikeOf != "" && !IsLookalike(senderDomain, q.LookalikeOf):
+		return false
+	case q.Text != "" && !containsFold(email.Subject, q.Text) && !containsFold(email.BodyPreview, q.Text):
+		return false
+	case q.AttachmentHash != "" && !contains(email.AttachmentHashes, q.AttachmentHash):
+		return false
+	}
+
+	if q.AttachmentName != "" {
+		found := false
+		for _, name := range email.AttachmentNames {
+			if containsFold(name, q.AttachmentName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for name, value := range q.Headers {
+		if actual, ok := email.Headers[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// IsLookalike reports whether a sender domain imitates a target domain: the same
+// name under another TLD, the same name written with homoglyphs ("paypa1.com",
+// "rnicrosoft.com"), a typo of it, or the name embedded in a longer domain
+// ("paypal-secure.com", "paypal.com.verify.io")
+// The target's own registrable domain and its subdomains are not lookalikes.
+func IsLookalike(candidate, target string) bool {
+	candidate = strings.TrimSuffix(strings.ToLower(candidate), ".")
+	target = strings.TrimSuffix(strings.ToLower(target), ".")
+	if candidate == "" || target == "" {
+		return false
+	}
+	candidateRegistrable := senderlist.RegistrableDomain(candidate)
+	targetRegistrable := senderlist.RegistrableDomain(target)
+	if candidateRegistrable == targetRegistrable {
+		return false
+	}
+
+	candidateName := firstLabel(candidateRegistrable)
+	targetName := firstLabel(targetRegistrable)
+	if candidateName == targetName || Skeleton(candidateName) == Skeleton(targetName) {
+		return true
+	}
+	if detection.DomainSimilarity(Skeleton(candidateRegistrable), Skeleton(targetRegistrable)) > LookalikeSimilarity {
+		return true
+	}
+	return len(targetName) >= minEmbeddedLength && strings.Contains(Skeleton(candidate), Skeleton(targetName))
+}
+
+// Homoglyphs maps characters and sequences to the letter they imitate, applied in order
+// Storage computes skeletons in SQL from this table: sequences use none of the
+// characters mapped on their own, so replacing them first gives the same result.
+var Homoglyphs = [][2]string{
+	{"rn", "m"}, {"vv", "w"}, {"cl", "d"},
+	{"0", "o"}, {"1", "l"}, {"3", "e"}, {"4", "a"}, {"5", "s"}, {"7", "t"}, {"8", "b"}, {"i", "l"},
+	// Cyrillic and Greek letters rendered like Latin ones
+	{"а", "a"}, {"е", "e"}, {"о", "o"}, {"р", "p"}, {"с", "c"}, {"у", "y"}, {"х", "x"}, {"і", "l"}, {"ј", "j"}, {"ѕ", "s"},
+	{"α", "a"}, {"ο", "o"}, {"ρ", "p"}, {"ν", "v"}, {"τ", "t"},
+}
+
+var homoglyphs = newHomoglyphReplacer()
+
+func newHomoglyphReplacer() *strings.Replacer {
+	pairs := make([]string, 0, 2*len(Homoglyphs))
+	for _, h := range Homoglyphs {
+		pairs = append(pairs, h[0], h[1])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Skeleton returns the form a domain name takes once homoglyphs are replaced by the
+// letters they imitate: two names with the same skeleton look alike
+func Skeleton(name string) string {
+	return homoglyphs.Replace(strings.ToLower(name))
+}
+
+// firstLabel returns a domain's leftmost label: a registrable domain's name
+func firstLabel(name string) string {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// validDomain reports whether s looks like a domain name with a TLD
+func validDomain(s string) bool {
+	if strings.ContainsAny(s, "@ /") {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" {
+			return false
+		}
+	}
+	return strings.Contains(s, ".")
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
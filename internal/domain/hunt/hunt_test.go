@@ -0,0 +1,133 @@
+package hunt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+const invoiceHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func query(q domain.HuntQuery) domain.HuntQuery {
+	Normalize(&q)
+	return q
+}
+
+func TestIsLookalike(t *testing.T) {
+	tests := []struct {
+		candidate string
+		target    string
+		want      bool
+	}{
+		{"paypal.com", "paypal.com", false},
+		{"mail.paypal.com", "paypal.com", false},
+		{"paypal.net", "paypal.com", true},
+		{"paypal.co.uk", "paypal.com", true},
+		{"paypa1.com", "paypal.com", true},
+		{"rnicrosoft.com", "microsoft.com", true},
+		{"pаypal.com", "paypal.com", true}, // Cyrillic a
+		{"micros0ftt.com", "microsoft.com", true},
+		{"paypal-secure.com", "paypal.com", true},
+		{"paypal.com.verify.io", "paypal.com", true},
+		{"login.paypa1-billing.net", "paypal.com", true},
+		{"google.com", "paypal.com", false},
+		{"partner.com", "paypal.com", false},
+		{"hp.net", "hp.com", true},
+		{"shop.com", "hp.com", false},
+		{"", "paypal.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.candidate+" vs "+tt.target, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsLookalike(tt.candidate, tt.target))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   domain.HuntQuery
+		wantErr string
+	}{
+		{"sender", domain.HuntQuery{Sender: "Billing@Evil.com"}, ""},
+		{"headers", domain.HuntQuery{Headers: map[string]string{"X-Mailer": "MassMailer 2.1"}}, ""},
+		{"tenant and window only", domain.HuntQuery{TenantID: uuid.New(), From: time.Now().Add(-time.Hour)}, "needs a sender"},
+		{"invalid sender", domain.HuntQuery{Sender: "billing"}, "invalid sender address"},
+		{"invalid domain", domain.HuntQuery{SenderDomain: "evil"}, "invalid sender domain"},
+		{"invalid lookalike domain", domain.HuntQuery{LookalikeOf: "pay pal.com"}, "invalid lookalike domain"},
+		{"short text", domain.HuntQuery{Text: "ok"}, "at least 3 characters"},
+		{"short attachment name", domain.HuntQuery{AttachmentName: ".z"}, "at least 3 characters"},
+		{"hash", domain.HuntQuery{AttachmentHash: strings.ToUpper(invoiceHash)}, ""},
+		{"invalid hash", domain.HuntQuery{AttachmentHash: "abc123"}, "hex SHA-256"},
+		{"empty header name", domain.HuntQuery{Headers: map[string]string{" ": "x"}}, "needs a name"},
+		{"inverted window", domain.HuntQuery{Text: "invoice", From: time.Now(), To: time.Now().Add(-time.Hour)}, "invalid hunt window"},
+		{"limit too high", domain.HuntQuery{Text: "invoice", Limit: MaxLimit + 1}, "limit must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(query(tt.query))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	assert.Equal(t, DefaultLimit, query(domain.HuntQuery{}).Limit)
+}
+
+func TestMatches(t *testing.T) {
+	tenantID := uuid.New()
+	received := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	email := domain.Email{
+		TenantID:         tenantID,
+		Subject:          "Overdue INVOICE 4471",
+		SenderEmail:      "Billing@Mail.Paypa1-Billing.net",
+		BodyPreview:      "Please settle the attached invoice today.",
+		ReceivedAt:       received,
+		AttachmentNames:  []string{"Invoice_4471.pdf.exe"},
+		AttachmentHashes: []string{invoiceHash},
+		Headers:          map[string]string{"X-Mailer": "MassMailer 2.1"},
+	}
+
+	tests := []struct {
+		name  string
+		query domain.HuntQuery
+		want  bool
+	}{
+		{"sender ignores case", domain.HuntQuery{Sender: "billing@mail.paypa1-billing.net"}, true},
+		{"other sender", domain.HuntQuery{Sender: "ceo@mail.paypa1-billing.net"}, false},
+		{"parent domain", domain.HuntQuery{SenderDomain: "paypa1-billing.net"}, true},
+		{"domain suffix is not a subdomain", domain.HuntQuery{SenderDomain: "billing.net"}, false},
+		{"lookalike", domain.HuntQuery{LookalikeOf: "paypal.com"}, true},
+		{"not a lookalike", domain.HuntQuery{LookalikeOf: "microsoft.com"}, false},
+		{"subject text", domain.HuntQuery{Text: "invoice 4471"}, true},
+		{"body text", domain.HuntQuery{Text: "settle the attached"}, true},
+		{"missing text", domain.HuntQuery{Text: "wire transfer"}, false},
+		{"attachment name", domain.HuntQuery{AttachmentName: ".pdf.exe"}, true},
+		{"attachment hash", domain.HuntQuery{AttachmentHash: strings.ToUpper(invoiceHash)}, true},
+		{"other hash", domain.HuntQuery{AttachmentHash: strings.Repeat("0", 64)}, false},
+		{"header value", domain.HuntQuery{Headers: map[string]string{"X-Mailer": "MassMailer 2.1"}}, true},
+		{"header value is exact", domain.HuntQuery{Headers: map[string]string{"X-Mailer": "MassMailer"}}, false},
+		{"missing header", domain.HuntQuery{Headers: map[string]string{"X-Campaign": "MassMailer 2.1"}}, false},
+		{"tenant", domain.HuntQuery{TenantID: tenantID, Text: "invoice"}, true},
+		{"other tenant", domain.HuntQuery{TenantID: uuid.New(), Text: "invoice"}, false},
+		{"window", domain.HuntQuery{Text: "invoice", From: received, To: received.Add(time.Hour)}, true},
+		{"window end is exclusive", domain.HuntQuery{Text: "invoice", To: received}, false},
+		{"all criteria", domain.HuntQuery{LookalikeOf: "paypal.com", Text: "invoice", AttachmentHash: invoiceHash, From: received.Add(-time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Matches(query(tt.query), email))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package lexicon
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minStopwordHits is the minimum number of stopword occurrences required before
+// trusting a detected language. Short subjects like "Invoice #4821" carry almost
+// no signal: they are detected as English, but matched against every lexicon.
+const minStopwordHits = 2
+
+// DetectLanguage guesses the language of a text by counting stopword occurrences
+//
+// This is a deliberately simple frequency classifier: BEC emails are short, written
+// in plain business prose, and only need to be routed to the right keyword lexicon.
+// Ties and low-signal texts resolve to DefaultLanguage.
+func DetectLanguage(text string) string {
+	language, _ := detectLanguage(text)
+	return language
+}
+
+// detectLanguage is DetectLanguage, also reporting whether the text carried enough
+// stopwords to tell its language: short subjects like "Virement urgent" do not
+func detectLanguage(text string) (string, bool) {
+	words := tokenize(text)
+	if len(words) == 0 {
+		return DefaultLanguage, false
+	}
+
+	bestLanguage := DefaultLanguage
+	bestHits := 0
+	defaultHits := 0
+
+	for _, language := range languageCodes {
+		stopwords := registry[language].stopwordSet
+
+		hits := 0
+		for _, word := range words {
+			if _, ok := stopwords[word]; ok {
+				hits++
+			}
+		}
+
+		if language == DefaultLanguage {
+			defaultHits = hits
+		}
+		if hits > bestHits {
+			bestLanguage, bestHits = language, hits
+		}
+	}
+
+	if bestHits < minStopwordHits {
+		return DefaultLanguage, false
+	}
+	// Require a clear winner over English before switching lexicons
+	if bestHits <= defaultHits {
+		return DefaultLanguage, true
+	}
+
+	return bestLanguage, true
+}
+
+// tokenize lowercases text and splits it into words, keeping accented letters
+// and in-word apostrophes ("l'immédiat" stays one token)
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
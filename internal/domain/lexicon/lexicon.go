@@ -0,0 +1,219 @@
+package lexicon
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultLanguage is used when no language can be detected with confidence.
+// English is also merged into every other lexicon: BEC emails written in
+// German or Italian routinely borrow English business terms ("CEO", "urgent", "IBAN").
+const DefaultLanguage = "en"
+
+//go:embed data/*.json
+var dataFS embed.FS
+
+// Lexicon holds the keyword vocabularies used by language-based detection strategies
+//
+// Each language lives in its own versioned JSON file under data/. Bumping the
+// version whenever keywords change makes it possible to tie a change in detection
+// behavior back to a lexicon release.
+type Lexicon struct {
+	Language string `json:"language"` // ISO 639-1 code, e.g. "de"
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+
+	// Stopwords are frequent function words used only for language detection
+	Stopwords []string `json:"stopwords"`
+
+	// Urgency expresses time pressure ("urgent", "dringend", "subito")
+	Urgency []string `json:"urgency"`
+
+	// AttachmentUrgency is the narrower list flagging risky attachments sent under
+	// time pressure: broad terms like "quick" or "eod" match too much ordinary mail
+	// ("quickly", "QuickBooks"). Only English has one; other languages use it merged.
+	AttachmentUrgency []string `json:"attachment_urgency"`
+
+	// Financial is the broad money vocabulary used for language scoring
+	// (transfers, invoices, gift cards...)
+	Financial []string `json:"financial"`
+
+	// Payment is the narrower wire transfer / bank details vocabulary
+	// used for role-targeted BEC detection
+	Payment []string `json:"payment"`
+
+	// Authority covers executive titles and secrecy requests ("between us")
+	Authority []string `json:"authority"`
+
+	// Payroll covers payslips, tax forms and social security identifiers
+	Payroll []string `json:"payroll"`
+
+	stopwordSet map[string]struct{}
+}
+
+// registry holds every embedded lexicon keyed by language code
+// Loaded once at package init: a malformed lexicon file is a build defect, not a runtime condition
+var registry = mustLoad()
+
+// languageCodes caches the sorted language codes, for deterministic iteration
+var languageCodes = sortedLanguages()
+
+// withDefault caches each lexicon merged with the English one, keyed by language code
+var withDefault = mergeWithDefault()
+
+func mustLoad() map[string]*Lexicon {
+	lexicons, err := load()
+	if err != nil {
+		panic(fmt.Sprintf("lexicon: %v", err))
+	}
+	return lexicons
+}
+
+func load() (map[string]*Lexicon, error) {
+	entries, err := dataFS.ReadDir("data")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lexicon files: %w", err)
+	}
+
+	lexicons := make(map[string]*Lexicon, len(entries))
+	for _, entry := range entries {
+		raw, err := dataFS.ReadFile(path.Join("data", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+
+		var lex Lexicon
+		if err := json.Unmarshal(raw, &lex); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+		if lex.Language == "" || lex.Version == "" {
+			return nil, fmt.Errorf("%s: language and version are required", entry.Name())
+		}
+		if _, exists := lexicons[lex.Language]; exists {
+			return nil, fmt.Errorf("%s: duplicate lexicon for language %q", entry.Name(), lex.Language)
+		}
+
+		lex.stopwordSet = make(map[string]struct{}, len(lex.Stopwords))
+		for _, word := range lex.Stopwords {
+			lex.stopwordSet[word] = struct{}{}
+		}
+
+		lexicons[lex.Language] = &lex
+	}
+
+	if _, ok := lexicons[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("missing default lexicon %q", DefaultLanguage)
+	}
+
+	return lexicons, nil
+}
+
+// Get returns the lexicon for a language code, or nil if unsupported
+func Get(language string) *Lexicon {
+	return registry[language]
+}
+
+// Languages returns the supported language codes in sorted order
+func Languages() []string {
+	return append([]string(nil), languageCodes...)
+}
+
+func sortedLanguages() []string {
+	languages := make([]string, 0, len(registry))
+	for language := range registry {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// MixedLanguage is the language code of the union of every lexicon (ISO 639-2 "mul")
+const MixedLanguage = "mul"
+
+// all caches the union of every lexicon, English first
+var all = mergeAll()
+
+// ForText detects the language of text and returns the matching lexicon
+// merged with the English one
+// Texts too short to tell their language get the union of every lexicon: a two-word
+// subject like "Facture impayée" must still match French keywords.
+func ForText(text string) *Lexicon {
+	language, ok := detectLanguage(text)
+	if !ok {
+		return all
+	}
+	return withDefault[language]
+}
+
+func mergeAll() *Lexicon {
+	english := *registry[DefaultLanguage]
+	union := &english
+	versions := []string{DefaultLanguage + "@" + union.Version}
+	for _, language := range languageCodes {
+		if language == DefaultLanguage {
+			continue
+		}
+		union = merge(union, registry[language])
+		versions = append(versions, language+"@"+registry[language].Version)
+	}
+	union.Language = MixedLanguage
+	union.Name = "All languages"
+	union.Version = strings.Join(versions, ",")
+	return union
+}
+
+func mergeWithDefault() map[string]*Lexicon {
+	merged := make(map[string]*Lexicon, len(registry))
+	for language, lex := range registry {
+		if language == DefaultLanguage {
+			merged[language] = lex
+			continue
+		}
+		merged[language] = merge(lex, registry[DefaultLanguage])
+	}
+	return merged
+}
+
+// merge combines a primary lexicon with a fallback, primary keywords first
+// Keywords present in both are kept once so keyword counts aren't inflated
+func merge(primary, fallback *Lexicon) *Lexicon {
+	return &Lexicon{
+		Language:  primary.Language,
+		Name:      primary.Name,
+		Version:   primary.Version,
+		Stopwords: primary.Stopwords,
+
+		stopwordSet:       primary.stopwordSet,
+		Urgency:           appendUnique(clone(primary.Urgency), fallback.Urgency...),
+		AttachmentUrgency: appendUnique(clone(primary.AttachmentUrgency), fallback.AttachmentUrgency...),
+		Financial:         appendUnique(clone(primary.Financial), fallback.Financial...),
+		Payment:           appendUnique(clone(primary.Payment), fallback.Payment...),
+		Authority:         appendUnique(clone(primary.Authority), fallback.Authority...),
+		Payroll:           appendUnique(clone(primary.Payroll), fallback.Payroll...),
+	}
+}
+
+func clone(keywords []string) []string {
+	return append([]string(nil), keywords...)
+}
+
+func appendUnique(keywords []string, extra ...string) []string {
+	for _, keyword := range extra {
+		keyword = strings.ToLower(keyword)
+		found := false
+		for _, existing := range keywords {
+			if existing == keyword {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,83 @@
+package lexicon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_AllLexiconsValid(t *testing.T) {
+	lexicons, err := load()
+	require.NoError(t, err)
+
+	for _, language := range []string{"en", "fr", "de", "es", "it", "nl"} {
+		lex, ok := lexicons[language]
+		require.True(t, ok, "missing lexicon for %s", language)
+
+		assert.NotEmpty(t, lex.Version, "%s: version", language)
+		assert.NotEmpty(t, lex.Stopwords, "%s: stopwords", language)
+		assert.NotEmpty(t, lex.Urgency, "%s: urgency", language)
+		assert.NotEmpty(t, lex.Financial, "%s: financial", language)
+		assert.NotEmpty(t, lex.Payment, "%s: payment", language)
+		assert.NotEmpty(t, lex.Authority, "%s: authority", language)
+		assert.NotEmpty(t, lex.Payroll, "%s: payroll", language)
+	}
+	assert.NotEmpty(t, lexicons[DefaultLanguage].AttachmentUrgency)
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Please process the wire transfer for the invoice today", "en"},
+		{"Merci de traiter le virement pour la facture dans la journée", "fr"},
+		{"Bitte überweisen Sie den Betrag noch heute auf das neue Konto", "de"},
+		{"Por favor, realiza la transferencia del pago hoy mismo", "es"},
+		{"Per favore, esegui il bonifico che ti ho mandato, grazie", "it"},
+		{"Kunt u de betaling van de factuur vandaag nog voor mij doen", "nl"},
+		{"Invoice #4821", "en"},
+		{"Virement urgent", "en"},
+		{"", "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected+": "+tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DetectLanguage(tt.text))
+		})
+	}
+}
+
+func TestForText_MergesEnglishWithoutDuplicates(t *testing.T) {
+	lex := ForText("Bitte überweisen Sie den Betrag noch heute auf das neue Konto")
+
+	assert.Equal(t, "de", lex.Language)
+	assert.Contains(t, lex.Urgency, "dringend")
+	assert.Contains(t, lex.Urgency, "urgent", "English keywords should be merged in")
+
+	seen := make(map[string]bool)
+	for _, keyword := range lex.Payment {
+		assert.False(t, seen[keyword], "duplicate keyword %q", keyword)
+		seen[keyword] = true
+	}
+}
+
+func TestForText_ShortTextUsesEveryLexicon(t *testing.T) {
+	for _, text := range []string{"virement urgent", "facture impayée", "dringende überweisung", "invoice #4821"} {
+		lex := ForText(text)
+		assert.Equal(t, MixedLanguage, lex.Language, text)
+	}
+
+	lex := ForText("facture impayée")
+	assert.Contains(t, lex.Payment, "facture")
+	assert.Contains(t, lex.Urgency, "dringend")
+	assert.Contains(t, lex.Urgency, "urgent")
+	assert.Equal(t, "en", Get("en").Language, "the English lexicon is not modified")
+
+	seen := make(map[string]bool)
+	for _, keyword := range lex.Payment {
+		assert.False(t, seen[keyword], "duplicate keyword %q", keyword)
+		seen[keyword] = true
+	}
+}
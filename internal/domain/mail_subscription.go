@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Mail subscription statuses
+const (
+	SubscriptionActive = "active"
+	SubscriptionLapsed = "lapsed" // Creation or renewal failed: the mailbox is polled until recreated
+)
+
+// Lifecycle notifications sent by Microsoft Graph about a subscription itself
+const (
+	LifecycleRemoved     = "subscriptionRemoved"     // Graph dropped the subscription
+	LifecycleMissed      = "missed"                  // Some notifications were not delivered
+	LifecycleReauthorize = "reauthorizationRequired" // The subscription must be renewed now
+)
+
+var (
+	// ErrUnknownSubscription is returned for a notification about no stored subscription
+	ErrUnknownSubscription = errors.New("unknown mail subscription")
+	// ErrClientStateMismatch is returned for a notification without the subscription's secret
+	ErrClientStateMismatch = errors.New("notification client state mismatch")
+)
+
+// MailSubscription is a provider push subscription on one user's mailbox
+//
+// Graph subscriptions on messages last under 3 days and Gmail watches 7 days: both
+// are renewed before they expire. While a subscription is not live, its mailbox is
+// polled from SyncedAt instead.
+type MailSubscription struct {
+	ID                 uuid.UUID  `json:"id"`
+	TenantID           uuid.UUID  `json:"tenant_id"`
+	UserID             uuid.UUID  `json:"user_id"`
+	Provider           Provider   `json:"provider"`
+	Mailbox            string     `json:"mailbox"`          // User email address
+	ExternalID         string     `json:"external_id"`      // Graph subscription ID, Gmail mailbox address (empty until created)
+	ClientState        string     `json:"-"`                // Secret Graph echoes in every notification
+	Cursor             string     `json:"cursor,omitempty"` // Gmail history ID changes were fetched up to
+	Status             string     `json:"status"`
+	ExpiresAt          time.Time  `json:"expires_at"`
+	SyncedAt           time.Time  `json:"synced_at"` // Mail received before this time is ingested
+	LastNotificationAt *time.Time `json:"last_notification_at,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// Live reports whether the provider still pushes the mailbox's changes
+func (s MailSubscription) Live(now time.Time) bool {
+	return s.Status == SubscriptionActive && now.Before(s.ExpiresAt)
+}
+
+// NeedsClientState reports whether the subscription's notifications are authenticated
+// by the client state they echo (Graph); Gmail pushes are authenticated by the receiver
+func (s MailSubscription) NeedsClientState() bool {
+	return s.Provider == ProviderMicrosoft
+}
+
+// MailChange is one push notification, in provider-neutral form
+type MailChange struct {
+	Provider       Provider `json:"provider"`
+	SubscriptionID string   `json:"subscription_id"`      // MailSubscription.ExternalID
+	ClientState    string   `json:"-"`                    // Graph only
+	MessageID      string   `json:"message_id,omitempty"` // Graph: the new message
+	HistoryID      string   `json:"history_id,omitempty"` // Gmail: mailbox history ID after the change
+	Lifecycle      string   `json:"lifecycle,omitempty"`  // Graph lifecycle notification (Lifecycle*), no message
+}
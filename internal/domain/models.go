@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,17 +24,41 @@ type Tenant struct {
 	Status      string    `json:"status"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// RiskAggregator selects how detections are combined into a risk score
+	// ("weighted_max", "noisy_or", "log_odds", "logistic"). Empty uses the default.
+	RiskAggregator string `json:"risk_aggregator,omitempty"`
+
+	// VIPRiskThresholds grade the risk of mail to VIPs (see VIP); nil uses the defaults
+	VIPRiskThresholds *RiskThresholds `json:"vip_risk_thresholds,omitempty"`
 }
 
 // User represents an email user within a tenant's organization
+// Users are synced from the provider directory (see directory sync in
+// FraudDetectionService.SyncUsers): users who left are soft-deleted (DeletedAt).
 type User struct {
 	ID             uuid.UUID `json:"id"`
 	TenantID       uuid.UUID `json:"tenant_id"`
 	ProviderUserID string    `json:"provider_user_id"`
 	Email          string    `json:"email"`
 	DisplayName    string    `json:"display_name"`
-	Role           string    `json:"role,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
+	Role           string    `json:"role,omitempty"` // Directory job title
+	Department     string    `json:"department,omitempty"`
+	Groups         []string  `json:"groups,omitempty"` // Directory group names (e.g. "Finance", "Executives")
+	Disabled       bool      `json:"disabled,omitempty"`
+
+	// ManagerRef is the user's manager as the provider reports it: its provider user
+	// ID (Graph) or address (Google Directory). Directory sync resolves it into ManagerID.
+	ManagerRef string     `json:"-"`
+	ManagerID  *uuid.UUID `json:"manager_id,omitempty"`
+
+	// Managers are the user's managers up the org chart, nearest first. They are
+	// loaded with recipients for detection.
+	Managers []UserRef `json:"managers,omitempty"`
+
+	SyncedAt  time.Time  `json:"synced_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // Set when the user left the directory
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 // Email represents an email message retrieved from provider APIs
@@ -55,12 +80,20 @@ type Email struct {
 	ReceivedAt        time.Time         `json:"received_at"`
 	HasAttachments    bool              `json:"has_attachments"`
 	AttachmentNames   []string          `json:"attachment_names,omitempty"`
-	BodyPreview       string            `json:"body_preview"` // First 500 chars
+	AttachmentHashes  []string          `json:"attachment_hashes,omitempty"` // Lowercase hex SHA-256 of each attachment's content
+	BodyPreview       string            `json:"body_preview"`                // First 500 chars
 	Headers           map[string]string `json:"headers"`
 	IngestedAt        time.Time         `json:"ingested_at"`
 	ProcessedAt       *time.Time        `json:"processed_at,omitempty"`
 }
 
+// EmailCursor is a position in a tenant's emails ordered by reception, for keyset
+// pagination: a page holds the emails after it
+type EmailCursor struct {
+	ReceivedAt time.Time
+	ID         uuid.UUID
+}
+
 // FraudAnalysis represents the result of fraud detection on an email
 //
 // Simplification: we omit review workflow fields (reviewed_by, review_status,
@@ -72,8 +105,26 @@ type FraudAnalysis struct {
 	EmailID         uuid.UUID   `json:"email_id"`
 	RiskScore       float64     `json:"risk_score"` // 0.0 to 1.0
 	RiskLevel       string      `json:"risk_level"` // "low", "medium", "high", "critical"
+	Aggregator      string      `json:"aggregator"` // Aggregator that produced RiskScore, e.g. "noisy_or"
+	DetectorVersion string      `json:"detector_version,omitempty"`
+	PolicyVersion   string      `json:"policy_version,omitempty"` // Fingerprint of the tenant configuration applied
 	DetectedThreats []Detection `json:"detected_threats"`
 	AnalyzedAt      time.Time   `json:"analyzed_at"`
+
+	// VIPRecipient is set when the recipient is a VIP: confidences were raised and the
+	// risk level graded with the tenant's VIP thresholds
+	VIPRecipient bool `json:"vip_recipient,omitempty"`
+
+	// Override is set when a tenant allow or block entry decided the risk score
+	Override *AnalysisOverride `json:"override,omitempty"`
+
+	// Trace explains what each strategy saw; nil unless the detector runs in trace mode
+	Trace *AnalysisTrace `json:"trace,omitempty"`
+
+	// Recomputed is set on an analysis made on demand with the current detector
+	// configuration rather than read from storage: it may differ from the decision
+	// made at ingestion. Never stored.
+	Recomputed bool `json:"recomputed,omitempty"`
 }
 
 // Detection represents a single fraud detection signal
@@ -81,20 +132,98 @@ type Detection struct {
 	Type       string  `json:"type"`       // e.g., "DOMAIN_TYPOSQUATTING"
 	Confidence float64 `json:"confidence"` // 0.0 to 1.0
 	Evidence   string  `json:"evidence"`   // Human-readable explanation
+
+	// Strategy is the name of the detection strategy that produced this signal
+	Strategy string `json:"strategy,omitempty"`
+
+	// RawConfidence is the strategy's confidence before calibration
+	// Only set when the detector applies a calibration map (Confidence is then the calibrated value).
+	RawConfidence float64 `json:"raw_confidence,omitempty"`
+
+	// Contribution is the share of the analysis risk score attributed to this detection
+	// Contributions of all detections in an analysis sum to its RiskScore.
+	Contribution float64 `json:"contribution"`
+}
+
+// DetectionRule is a tenant-defined detection written in the rule language
+// (see package rules), managed at runtime without a redeploy
+type DetectionRule struct {
+	ID            uuid.UUID `json:"id"`
+	TenantID      uuid.UUID `json:"tenant_id"`
+	Name          string    `json:"name"`
+	Expression    string    `json:"expression"`     // e.g. sender.domain not in internal_domains and subject matches "(?i)facture"
+	DetectionType string    `json:"detection_type"` // e.g. "CUSTOM_INVOICE_FRAUD"
+	Confidence    float64   `json:"confidence"`     // 0.0 to 1.0, reported when the rule matches
+	Enabled       bool      `json:"enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TenantBacklog summarizes a tenant's emails awaiting fraud detection
+type TenantBacklog struct {
+	TenantID   uuid.UUID     `json:"tenant_id"`
+	TenantName string        `json:"tenant_name"`
+	Pending    int           `json:"pending"` // Unprocessed emails
+	Leased     int           `json:"leased"`  // Pending emails currently claimed by a worker
+	Lag        time.Duration `json:"lag"`     // Time the oldest pending email has waited since ingestion
+}
+
+// Claimable returns the number of pending emails a worker can claim now
+func (b TenantBacklog) Claimable() int {
+	return b.Pending - b.Leased
 }
 
 // RiskLevel converts a risk score to a categorical level
 func RiskLevel(score float64) string {
+	return DefaultRiskThresholds.Level(score)
+}
+
+// RiskThresholds are the minimum risk scores of each risk level
+type RiskThresholds struct {
+	Critical float64 `json:"critical"`
+	High     float64 `json:"high"`
+	Medium   float64 `json:"medium"`
+	Low      float64 `json:"low"`
+}
+
+// DefaultRiskThresholds are the thresholds used by RiskLevel
+var DefaultRiskThresholds = RiskThresholds{Critical: 0.85, High: 0.70, Medium: 0.50, Low: 0.30}
+
+// Level converts a risk score to a categorical level
+func (t RiskThresholds) Level(score float64) string {
 	switch {
-	case score >= 0.85:
+	case score >= t.Critical:
 		return "critical"
-	case score >= 0.70:
+	case score >= t.High:
 		return "high"
-	case score >= 0.50:
+	case score >= t.Medium:
 		return "medium"
-	case score >= 0.30:
+	case score >= t.Low:
 		return "low"
 	default:
 		return "none"
 	}
 }
+
+// Validate checks that the thresholds are in (0, 1] and increase with severity
+func (t RiskThresholds) Validate() error {
+	if t.Low <= 0 || t.Low >= t.Medium || t.Medium >= t.High || t.High >= t.Critical || t.Critical > 1 {
+		return fmt.Errorf("risk thresholds must satisfy 0 < low < medium < high < critical <= 1, got %+v", t)
+	}
+	return nil
+}
+
+// StricterThan reports whether every level is reached at a score no higher than in other
+func (t RiskThresholds) StricterThan(other RiskThresholds) bool {
+	return t.Critical <= other.Critical && t.High <= other.High && t.Medium <= other.Medium && t.Low <= other.Low
+}
+
+// RiskLevels lists the alerting risk levels, from most to least severe
+var RiskLevels = []string{"critical", "high", "medium", "low"}
+
+var riskLevelRank = map[string]int{"none": 0, "low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// RiskLevelAtLeast reports whether level is as severe as threshold or more
+func RiskLevelAtLeast(level, threshold string) bool {
+	return riskLevelRank[level] >= riskLevelRank[threshold]
+}
@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification channels
+const (
+	ChannelSlack = "slack" // Target: Slack incoming webhook URL
+	ChannelTeams = "teams" // Target: Microsoft Teams connector URL
+	ChannelEmail = "email" // Target: recipient address, sent over SMTP
+)
+
+// NotificationRoute sends a tenant's analyses matching its filters to a channel
+//
+// Alerts at or above DigestBelow are sent right away; lower ones are batched into
+// a periodic digest, so low-severity noise reaches people without paging them.
+type NotificationRoute struct {
+	ID       uuid.UUID `json:"id"`
+	TenantID uuid.UUID `json:"tenant_id"`
+	Name     string    `json:"name"` // e.g. "SOC Slack"
+	Channel  string    `json:"channel"`
+	Target   string    `json:"target"`
+
+	MinRiskLevel   string   `json:"min_risk_level"`            // Analyses below it are ignored
+	DetectionTypes []string `json:"detection_types,omitempty"` // Only analyses with one of these detections; empty matches all
+	DigestBelow    string   `json:"digest_below,omitempty"`    // Alerts below it go to the digest; empty sends all right away
+
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the route's channel, target and filters
+func (r NotificationRoute) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("notification route needs a name")
+	}
+	switch r.Channel {
+	case ChannelSlack, ChannelTeams:
+		target, err := url.Parse(r.Target)
+		if err != nil || target.Scheme != "https" || target.Host == "" {
+			return fmt.Errorf("%s route target must be an https URL", r.Channel)
+		}
+	case ChannelEmail:
+		if _, err := mail.ParseAddress(r.Target); err != nil {
+			return fmt.Errorf("invalid email address %q", r.Target)
+		}
+	default:
+		return fmt.Errorf("unknown notification channel %q", r.Channel)
+	}
+	if _, ok := riskLevelRank[r.MinRiskLevel]; !ok || r.MinRiskLevel == "none" {
+		return fmt.Errorf("unknown risk level %q", r.MinRiskLevel)
+	}
+	if _, ok := riskLevelRank[r.DigestBelow]; r.DigestBelow != "" && !ok {
+		return fmt.Errorf("unknown risk level %q", r.DigestBelow)
+	}
+	return nil
+}
+
+// Matches reports whether an analysis with riskLevel and detectionTypes is routed here
+func (r NotificationRoute) Matches(riskLevel string, detectionTypes []string) bool {
+	if !r.Enabled || !RiskLevelAtLeast(riskLevel, r.MinRiskLevel) {
+		return false
+	}
+	if len(r.DetectionTypes) == 0 {
+		return true
+	}
+	for _, wanted := range r.DetectionTypes {
+		for _, detected := range detectionTypes {
+			if wanted == detected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Digested reports whether alerts of riskLevel are batched into the digest
+func (r NotificationRoute) Digested(riskLevel string) bool {
+	return r.DigestBelow != "" && !RiskLevelAtLeast(riskLevel, r.DigestBelow)
+}
+
+// Notification is a rendered message, ready for any channel
+type Notification struct {
+	Title    string `json:"title"`
+	Text     string `json:"text"`     // Plain text with line breaks; chat channels render it as markdown
+	Severity string `json:"severity"` // Highest risk level of the alerts it reports, for colors and priorities
+}
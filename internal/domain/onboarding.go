@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tenant statuses
+// A tenant is created pending consent and becomes active once an administrator
+// grants it. Active tenants can be suspended (and resumed) or lose their consent
+// (auth_error, active again after a new consent). Any tenant can be offboarded: its
+// tokens are revoked and its data purged.
+const (
+	TenantPendingConsent = "pending_consent" // Created, waiting for an administrator to grant consent
+	TenantActive         = "active"
+	TenantSuspended      = "suspended"   // Paused by us (billing, abuse): no provider calls
+	TenantAuthError      = "auth_error"  // Consent revoked or credentials invalid: provider calls stop until reauthorized
+	TenantOffboarding    = "offboarding" // Leaving: tokens are revoked and data purged
+)
+
+// tenantTransitions lists the statuses each status can move to
+var tenantTransitions = map[string][]string{
+	TenantPendingConsent: {TenantActive, TenantOffboarding},
+	TenantActive:         {TenantSuspended, TenantAuthError, TenantOffboarding},
+	TenantSuspended:      {TenantActive, TenantOffboarding},
+	TenantAuthError:      {TenantActive, TenantSuspended, TenantOffboarding},
+	TenantOffboarding:    {},
+}
+
+var (
+	// ErrTenantNotFound is returned for operations on an unknown tenant
+	ErrTenantNotFound = errors.New("tenant not found")
+	// ErrInvalidTransition is returned when a tenant cannot move to a status from its current one
+	ErrInvalidTransition = errors.New("invalid tenant status transition")
+	// ErrTenantInactive is returned for provider operations on a tenant that is not active
+	ErrTenantInactive = errors.New("tenant is not active")
+	// ErrInvalidConsentState is returned for a consent callback whose state was not issued by us or expired
+	ErrInvalidConsentState = errors.New("invalid consent state")
+	// ErrConsentDenied is returned when the administrator declined consent
+	ErrConsentDenied = errors.New("consent denied")
+	// ErrDirectoryMismatch is returned when a consent names another directory than the one the tenant was onboarded with
+	ErrDirectoryMismatch = errors.New("consent directory does not match the tenant")
+)
+
+// ValidateTenantTransition returns ErrInvalidTransition unless a tenant can move from
+// one status to another
+func ValidateTenantTransition(from, to string) error {
+	for _, allowed := range tenantTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+}
+
+// Active reports whether provider calls may be made for the tenant
+func (t *Tenant) Active() bool {
+	return t.Status == TenantActive
+}
+
+// OAuthApp is our application registered with a provider, which tenants consent to
+// AuthorizeURL and TokenURL override the provider endpoints (local fake endpoints).
+type OAuthApp struct {
+	ClientID     string
+	ClientSecret string
+	AuthorizeURL string
+	TokenURL     string
+}
+
+// ConsentCallback holds the parameters a provider redirects the administrator with
+// Microsoft admin consent returns the directory (tenant) ID and whether the grant
+// was made; Google an authorization code. Both echo the state and report refusals
+// in Error.
+type ConsentCallback struct {
+	State            string
+	Code             string
+	DirectoryID      string
+	AdminConsent     bool
+	Error            string
+	ErrorDescription string
+}
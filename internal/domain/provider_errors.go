@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Provider API errors
+// Provider adapters wrap them so callers can act on the cause with errors.Is.
+var (
+	ErrThrottled   = errors.New("provider throttled the request")          // 429, Gmail rate limit 403: retry later
+	ErrUnavailable = errors.New("provider unavailable")                    // 5xx, network errors: retry
+	ErrAuthExpired = errors.New("provider credentials expired or revoked") // 401: refresh the token or ask for consent again
+	ErrNotFound    = errors.New("provider resource not found")             // 404: mailbox or message gone
+	ErrCircuitOpen = errors.New("provider circuit open")                   // Recent calls kept failing: not attempted
+)
+
+// ThrottledError is ErrThrottled with the delay the provider asked for (Retry-After)
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", ErrThrottled, e.RetryAfter)
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrThrottled
+}
+
+// RetryAfter returns the delay a throttled error asks for, 0 if none
+func RetryAfter(err error) time.Duration {
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) {
+		return throttled.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,176 @@
+// Package push decodes and encodes provider push notifications
+//
+// Microsoft Graph posts change notifications as a JSON batch:
+//
+//	{"value": [{"subscriptionId": "...", "clientState": "...", "changeType": "created",
+//	            "resource": "Users/{id}/Messages/{id}", "resourceData": {"id": "..."}}]}
+//
+// Lifecycle notifications (subscription removed, missed notifications,
+// reauthorization required) use the same batch with a lifecycleEvent instead of a
+// change. Gmail publishes mailbox changes to a Pub/Sub topic, which pushes them as
+// one message whose base64 data is {"emailAddress": "...", "historyId": 1234}: the
+// notification says the mailbox changed, not which message arrived.
+//
+// Encoders produce the same payloads, for fake notification senders.
+package push
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// ErrNoChange is returned for a Gmail message without a mailbox or history ID
+var ErrNoChange = errors.New("notification has no mailbox change")
+
+type graphBatch struct {
+	Value []graphNotification `json:"value"`
+}
+
+type graphNotification struct {
+	SubscriptionID string             `json:"subscriptionId"`
+	ClientState    string             `json:"clientState,omitempty"`
+	ChangeType     string             `json:"changeType,omitempty"`
+	Resource       string             `json:"resource,omitempty"`
+	ResourceData   *graphResourceData `json:"resourceData,omitempty"`
+	LifecycleEvent string             `json:"lifecycleEvent,omitempty"`
+}
+
+type graphResourceData struct {
+	ID string `json:"id"`
+}
+
+// ParseGraph decodes a Graph notification batch
+// Changes other than created messages are skipped: subscriptions only ask for those.
+func ParseGraph(body []byte) ([]domain.MailChange, error) {
+	var batch graphBatch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("invalid graph notification: %w", err)
+	}
+
+	changes := make([]domain.MailChange, 0, len(batch.Value))
+	for _, n := range batch.Value {
+		if n.SubscriptionID == "" {
+			return nil, fmt.Errorf("invalid graph notification: no subscriptionId")
+		}
+		change := domain.MailChange{
+			Provider:       domain.ProviderMicrosoft,
+			SubscriptionID: n.SubscriptionID,
+			ClientState:    n.ClientState,
+		}
+		switch {
+		case n.LifecycleEvent != "":
+			change.Lifecycle = n.LifecycleEvent
+		case n.ChangeType == "created":
+			change.MessageID = graphMessageID(n)
+			if change.MessageID == "" {
+				return nil, fmt.Errorf("invalid graph notification: no message in %q", n.Resource)
+			}
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// graphMessageID returns the message a change is about: resourceData.id, or the last
+// segment of the resource path
+func graphMessageID(n graphNotification) string {
+	if n.ResourceData != nil && n.ResourceData.ID != "" {
+		return n.ResourceData.ID
+	}
+	if i := strings.LastIndex(n.Resource, "/"); i >= 0 {
+		return n.Resource[i+1:]
+	}
+	return ""
+}
+
+// EncodeGraph encodes changes as a Graph notification batch
+func EncodeGraph(changes ...domain.MailChange) ([]byte, error) {
+	batch := graphBatch{Value: make([]graphNotification, 0, len(changes))}
+	for _, change := range changes {
+		n := graphNotification{SubscriptionID: change.SubscriptionID, ClientState: change.ClientState}
+		if change.Lifecycle != "" {
+			n.LifecycleEvent = change.Lifecycle
+		} else {
+			n.ChangeType = "created"
+			n.Resource = "Users/me/Messages/" + change.MessageID
+			n.ResourceData = &graphResourceData{ID: change.MessageID}
+		}
+		batch.Value = append(batch.Value, n)
+	}
+	return json.Marshal(batch)
+}
+
+type pubsubPush struct {
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId,omitempty"`
+	} `json:"message"`
+	Subscription string `json:"subscription,omitempty"`
+}
+
+type gmailChange struct {
+	EmailAddress string      `json:"emailAddress"`
+	HistoryID    json.Number `json:"historyId"`
+}
+
+// ParseGmail decodes a Pub/Sub push of a Gmail mailbox change
+func ParseGmail(body []byte) (domain.MailChange, error) {
+	var msg pubsubPush
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return domain.MailChange{}, fmt.Errorf("invalid pub/sub push: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Message.Data)
+	if err != nil {
+		return domain.MailChange{}, fmt.Errorf("invalid pub/sub message data: %w", err)
+	}
+	var change gmailChange
+	if err := json.Unmarshal(data, &change); err != nil {
+		return domain.MailChange{}, fmt.Errorf("invalid gmail notification: %w", err)
+	}
+	if change.EmailAddress == "" || change.HistoryID == "" {
+		return domain.MailChange{}, ErrNoChange
+	}
+	if _, err := strconv.ParseUint(change.HistoryID.String(), 10, 64); err != nil {
+		return domain.MailChange{}, fmt.Errorf("invalid gmail history ID %q", change.HistoryID)
+	}
+	return domain.MailChange{
+		Provider:       domain.ProviderGoogle,
+		SubscriptionID: change.EmailAddress,
+		HistoryID:      change.HistoryID.String(),
+	}, nil
+}
+
+// EncodeGmail encodes a Gmail mailbox change as a Pub/Sub push
+func EncodeGmail(change domain.MailChange) ([]byte, error) {
+	data, err := json.Marshal(gmailChange{EmailAddress: change.SubscriptionID, HistoryID: json.Number(change.HistoryID)})
+	if err != nil {
+		return nil, err
+	}
+	var msg pubsubPush
+	msg.Message.Data = base64.StdEncoding.EncodeToString(data)
+	msg.Message.MessageID = change.HistoryID
+	msg.Subscription = "projects/email-security/subscriptions/gmail-push"
+	return json.Marshal(msg)
+}
+
+// HistoryAfter reports whether Gmail history ID a is after b
+// An empty b (no cursor yet) is before every history ID.
+func HistoryAfter(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA != nil || errB != nil {
+		return a != b
+	}
+	return x > y
+}
@@ -0,0 +1,114 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []domain.MailChange
+		wantErr string
+	}{
+		{
+			name: "created message",
+			body: `{"value":[{"subscriptionId":"sub-1","clientState":"secret","changeType":"created",
+				"resource":"Users/u-1/Messages/AAMkAD1","resourceData":{"@odata.type":"#Microsoft.Graph.Message","id":"AAMkAD1"}}]}`,
+			want: []domain.MailChange{{Provider: domain.ProviderMicrosoft, SubscriptionID: "sub-1", ClientState: "secret", MessageID: "AAMkAD1"}},
+		},
+		{
+			name: "message from resource path",
+			body: `{"value":[{"subscriptionId":"sub-1","changeType":"created","resource":"Users/u-1/Messages/AAMkAD2"}]}`,
+			want: []domain.MailChange{{Provider: domain.ProviderMicrosoft, SubscriptionID: "sub-1", MessageID: "AAMkAD2"}},
+		},
+		{
+			name: "lifecycle and skipped update",
+			body: `{"value":[{"subscriptionId":"sub-1","clientState":"secret","lifecycleEvent":"missed"},
+				{"subscriptionId":"sub-1","changeType":"updated","resource":"Users/u-1/Messages/AAMkAD1"}]}`,
+			want: []domain.MailChange{{Provider: domain.ProviderMicrosoft, SubscriptionID: "sub-1", ClientState: "secret", Lifecycle: domain.LifecycleMissed}},
+		},
+		{name: "empty batch", body: `{"value":[]}`, want: []domain.MailChange{}},
+		{name: "no subscription", body: `{"value":[{"changeType":"created","resource":"Users/u-1/Messages/AAMkAD1"}]}`, wantErr: "no subscriptionId"},
+		{name: "no message", body: `{"value":[{"subscriptionId":"sub-1","changeType":"created","resource":"Messages"}]}`, wantErr: "no message"},
+		{name: "not json", body: `validationToken`, wantErr: "invalid graph notification"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := ParseGraph([]byte(tt.body))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, changes)
+		})
+	}
+}
+
+func TestEncodeGraph_RoundTrip(t *testing.T) {
+	changes := []domain.MailChange{
+		{Provider: domain.ProviderMicrosoft, SubscriptionID: "sub-1", ClientState: "secret", MessageID: "AAMkAD1"},
+		{Provider: domain.ProviderMicrosoft, SubscriptionID: "sub-2", ClientState: "secret", Lifecycle: domain.LifecycleReauthorize},
+	}
+	body, err := EncodeGraph(changes...)
+	require.NoError(t, err)
+	parsed, err := ParseGraph(body)
+	require.NoError(t, err)
+	assert.Equal(t, changes, parsed)
+}
+
+func TestParseGmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    domain.MailChange
+		wantErr string
+	}{
+		{
+			// data is {"emailAddress": "cfo@company.com", "historyId": 9876}
+			name: "mailbox change",
+			body: `{"message":{"data":"eyJlbWFpbEFkZHJlc3MiOiAiY2ZvQGNvbXBhbnkuY29tIiwgImhpc3RvcnlJZCI6IDk4NzZ9","messageId":"1"},"subscription":"projects/p/subscriptions/s"}`,
+			want: domain.MailChange{Provider: domain.ProviderGoogle, SubscriptionID: "cfo@company.com", HistoryID: "9876"},
+		},
+		{name: "not base64", body: `{"message":{"data":"%%%"}}`, wantErr: "invalid pub/sub message data"},
+		// data is {"emailAddress": "cfo@company.com"}
+		{name: "no history", body: `{"message":{"data":"eyJlbWFpbEFkZHJlc3MiOiAiY2ZvQGNvbXBhbnkuY29tIn0="}}`, wantErr: ErrNoChange.Error()},
+		// data is {"emailAddress": "cfo@company.com", "historyId": -1}
+		{name: "bad history", body: `{"message":{"data":"eyJlbWFpbEFkZHJlc3MiOiAiY2ZvQGNvbXBhbnkuY29tIiwgImhpc3RvcnlJZCI6IC0xfQ=="}}`, wantErr: "invalid gmail history ID"},
+		{name: "not json", body: `[]`, wantErr: "invalid pub/sub push"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change, err := ParseGmail([]byte(tt.body))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, change)
+		})
+	}
+}
+
+func TestEncodeGmail_RoundTrip(t *testing.T) {
+	change := domain.MailChange{Provider: domain.ProviderGoogle, SubscriptionID: "cfo@company.com", HistoryID: "12345"}
+	body, err := EncodeGmail(change)
+	require.NoError(t, err)
+	parsed, err := ParseGmail(body)
+	require.NoError(t, err)
+	assert.Equal(t, change, parsed)
+}
+
+func TestHistoryAfter(t *testing.T) {
+	assert.True(t, HistoryAfter("100", ""))
+	assert.True(t, HistoryAfter("100", "99"))
+	assert.False(t, HistoryAfter("99", "100"), "numeric, not lexical")
+	assert.False(t, HistoryAfter("100", "100"))
+}
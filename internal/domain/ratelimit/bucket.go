@@ -0,0 +1,66 @@
+// Package ratelimit implements token buckets
+//
+// A bucket holds up to burst tokens and refills at a steady rate; each action
+// takes one. Short bursts go through, sustained floods are throttled to the rate.
+// Buckets take the current time as an argument so callers and tests control the clock.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token bucket safe for concurrent use
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket refilling rate tokens per second, holding at most burst
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// PerMinute returns the refill rate of n tokens per minute
+func PerMinute(n int) float64 {
+	return float64(n) / 60
+}
+
+// Allow takes a token if one is available at now
+func (b *TokenBucket) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Reserve takes a token at now and returns how long to wait before using it
+// Unlike Allow it never refuses: waiting callers queue up by borrowing from future
+// refills, so a caller that waits the returned duration stays within the rate.
+func (b *TokenBucket) Reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refill adds the tokens earned since the last call; callers hold b.mu
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	bucket := NewTokenBucket(PerMinute(60), 3)
+
+	// The burst goes through, then the bucket is empty
+	for i := 0; i < 3; i++ {
+		assert.True(t, bucket.Allow(start), "burst %d", i)
+	}
+	assert.False(t, bucket.Allow(start))
+
+	// One token per second
+	assert.False(t, bucket.Allow(start.Add(500*time.Millisecond)))
+	assert.True(t, bucket.Allow(start.Add(time.Second)))
+	assert.False(t, bucket.Allow(start.Add(time.Second)))
+
+	// Refills up to the burst, not beyond
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, bucket.Allow(later), "refilled %d", i)
+	}
+	assert.False(t, bucket.Allow(later))
+}
+
+func TestTokenBucket_ClockGoingBack(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	bucket := NewTokenBucket(1, 1)
+
+	assert.True(t, bucket.Allow(start))
+	assert.False(t, bucket.Allow(start.Add(-time.Hour)), "an earlier time earns no tokens")
+	assert.True(t, bucket.Allow(start.Add(time.Second)))
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	bucket := NewTokenBucket(2, 2)
+
+	// The burst is immediate, then callers queue half a second apart
+	assert.Zero(t, bucket.Reserve(start))
+	assert.Zero(t, bucket.Reserve(start))
+	assert.Equal(t, 500*time.Millisecond, bucket.Reserve(start))
+	assert.Equal(t, time.Second, bucket.Reserve(start))
+	assert.False(t, bucket.Allow(start), "reservations use future tokens")
+
+	// Once the debt is repaid, the bucket refills as usual
+	assert.Zero(t, bucket.Reserve(start.Add(2*time.Second)))
+}
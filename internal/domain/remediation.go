@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Remediation actions applied to a message in the recipient's mailbox
+// Every action is reversible: delete moves the message to the deleted items / trash.
+const (
+	RemediationQuarantine = "quarantine" // Move to a quarantine folder/label hidden from the inbox
+	RemediationJunk       = "junk"       // Move to the junk / spam folder
+	RemediationLabel      = "label"      // Apply a category (Graph) or label (Gmail)
+	RemediationBanner     = "banner"     // Prepend a warning banner to the body
+	RemediationDelete     = "delete"     // Move to deleted items / trash
+)
+
+// Remediation action statuses
+const (
+	RemediationApplied  = "applied"
+	RemediationFailed   = "failed"
+	RemediationRestored = "restored"
+)
+
+// RemediationActorPolicy is the actor recorded for actions taken automatically by a policy
+const RemediationActorPolicy = "policy"
+
+// remediationMoves are the actions that move the message; a policy can use one at most
+var remediationMoves = map[string]bool{RemediationQuarantine: true, RemediationJunk: true, RemediationDelete: true}
+
+var remediationActions = map[string]bool{
+	RemediationQuarantine: true, RemediationJunk: true, RemediationLabel: true,
+	RemediationBanner: true, RemediationDelete: true,
+}
+
+// RemediationPolicy lists the actions applied automatically to a tenant's emails of one risk level
+type RemediationPolicy struct {
+	TenantID  uuid.UUID `json:"tenant_id"`
+	RiskLevel string    `json:"risk_level"` // "low", "medium", "high", "critical"
+	Actions   []string  `json:"actions"`    // Applied in order, e.g. ["banner", "label", "quarantine"]
+	Label     string    `json:"label,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the policy's risk level and actions
+func (p RemediationPolicy) Validate() error {
+	if _, ok := riskLevelRank[p.RiskLevel]; !ok || p.RiskLevel == "none" {
+		return fmt.Errorf("unknown risk level %q", p.RiskLevel)
+	}
+	return ValidateRemediationActions(p.Actions, p.Label)
+}
+
+// ValidateRemediationActions checks a list of actions applied together
+func ValidateRemediationActions(actions []string, label string) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("no remediation action")
+	}
+	moves := 0
+	for _, action := range actions {
+		if !remediationActions[action] {
+			return fmt.Errorf("unknown remediation action %q", action)
+		}
+		if remediationMoves[action] {
+			moves++
+		}
+		if action == RemediationLabel && label == "" {
+			return fmt.Errorf("label action needs a label")
+		}
+	}
+	if moves > 1 {
+		return fmt.Errorf("at most one of quarantine, junk and delete can be applied")
+	}
+	return nil
+}
+
+// MessageRef locates a message in a provider mailbox
+type MessageRef struct {
+	Mailbox   string `json:"mailbox"`    // Mailbox address (Graph user principal name, Gmail user ID)
+	MessageID string `json:"message_id"` // Provider message ID
+}
+
+// RemediationAction is the audit record of one action on one message
+//
+// RestoreState is what the provider needs to undo the action (e.g. the folder the
+// message was moved from); it is opaque to everything but the remediator.
+type RemediationAction struct {
+	ID           uuid.UUID  `json:"id"`
+	TenantID     uuid.UUID  `json:"tenant_id"`
+	EmailID      uuid.UUID  `json:"email_id"`
+	AnalysisID   uuid.UUID  `json:"analysis_id,omitempty"` // Analysis that triggered a policy action
+	Action       string     `json:"action"`
+	Label        string     `json:"label,omitempty"`
+	MessageID    string     `json:"message_id,omitempty"`  // Provider message acted on
+	ReplacedBy   string     `json:"replaced_by,omitempty"` // Bannered copy that replaced it: later actions target it
+	Status       string     `json:"status"`
+	Actor        string     `json:"actor"` // RemediationActorPolicy, or the analyst who acted from the API
+	Error        string     `json:"error,omitempty"`
+	RestoreState string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RestoredAt   *time.Time `json:"restored_at,omitempty"`
+	RestoredBy   string     `json:"restored_by,omitempty"`
+}
@@ -0,0 +1,26 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, Backoff(time.Second, 10*time.Second, 0))
+	assert.Equal(t, time.Second, Backoff(time.Second, 10*time.Second, 1))
+	assert.Equal(t, 8*time.Second, Backoff(time.Second, 10*time.Second, 4))
+	assert.Equal(t, 10*time.Second, Backoff(time.Second, 10*time.Second, 1000))
+}
+
+func TestSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, Sleep(ctx, 0))
+	assert.NoError(t, Sleep(ctx, time.Millisecond))
+
+	cancel()
+	assert.ErrorIs(t, Sleep(ctx, time.Hour), context.Canceled)
+	assert.ErrorIs(t, Sleep(ctx, 0), context.Canceled)
+}
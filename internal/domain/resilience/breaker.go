@@ -0,0 +1,121 @@
+// Package resilience protects calls to provider APIs
+//
+// An Executor runs each call within a per-key token bucket (provider quotas are per
+// tenant or mailbox), retries transient failures with jittered exponential backoff
+// honoring Retry-After, and stops calling a key whose calls keep failing (circuit
+// breaker) until a cooldown has passed. Failures are classified with the domain
+// provider errors (domain.ErrThrottled, domain.ErrUnavailable, ...).
+package resilience
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Breaker states
+const (
+	StateClosed   = "closed"    // Calls go through
+	StateOpen     = "open"      // Calls are refused until the cooldown has passed
+	StateHalfOpen = "half_open" // One probe call is let through to test recovery
+)
+
+// Breaker is a circuit breaker safe for concurrent use
+// Only transient failures (throttling, unavailability) count: a missing mailbox
+// says nothing about the provider's health.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int       // Consecutive transient failures while closed
+	openUntil time.Time // End of the cooldown while open
+	probing   bool      // A half-open probe is in flight
+}
+
+// NewBreaker creates a closed breaker opening after threshold consecutive
+// transient failures, for cooldown
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, state: StateClosed}
+}
+
+// Allow returns domain.ErrCircuitOpen if a call must not be attempted at now
+// After the cooldown, a single probe is allowed; Record of its outcome closes or
+// reopens the breaker.
+func (b *Breaker) Allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		if now.Before(b.openUntil) {
+			return domain.ErrCircuitOpen
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			return domain.ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record updates the breaker with the outcome of an allowed call
+func (b *Breaker) Record(now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	transient := Transient(err)
+	if b.state == StateHalfOpen {
+		b.probing = false
+		switch {
+		case transient:
+			b.open(now)
+		case err == nil:
+			b.state = StateClosed
+			b.failures = 0
+		}
+		return
+	}
+
+	switch {
+	case err == nil:
+		b.failures = 0
+	case transient:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open(now)
+		}
+	}
+}
+
+// Release gives back an allowed call that was not made, e.g. cancelled while waiting
+// for the rate limit
+// It frees a half-open probe without closing the breaker, and leaves the failure
+// count of a closed one as it was.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State returns the breaker's state at now
+func (b *Breaker) State(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && !now.Before(b.openUntil) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// open starts a cooldown; callers hold b.mu
+func (b *Breaker) open(now time.Time) {
+	b.state = StateOpen
+	b.openUntil = now.Add(b.cooldown)
+	b.failures = 0
+}
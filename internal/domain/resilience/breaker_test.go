@@ -0,0 +1,84 @@
+package resilience
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+func TestBreaker_OpensAndRecovers(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	breaker := NewBreaker(3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, breaker.Allow(start))
+		breaker.Record(start, domain.ErrUnavailable)
+	}
+	assert.Equal(t, StateOpen, breaker.State(start))
+	assert.ErrorIs(t, breaker.Allow(start.Add(59*time.Second)), domain.ErrCircuitOpen)
+
+	// After the cooldown a single probe goes through
+	probe := start.Add(time.Minute)
+	assert.NoError(t, breaker.Allow(probe))
+	assert.ErrorIs(t, breaker.Allow(probe), domain.ErrCircuitOpen, "one probe at a time")
+
+	// A failed probe reopens for a full cooldown
+	breaker.Record(probe, &domain.ThrottledError{})
+	assert.ErrorIs(t, breaker.Allow(probe.Add(30*time.Second)), domain.ErrCircuitOpen)
+
+	// A successful probe closes
+	probe = probe.Add(time.Minute)
+	assert.NoError(t, breaker.Allow(probe))
+	breaker.Record(probe, nil)
+	assert.Equal(t, StateClosed, breaker.State(probe))
+	assert.NoError(t, breaker.Allow(probe))
+}
+
+func TestBreaker_Release(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	breaker := NewBreaker(2, time.Minute)
+
+	// A released call does not reset the failure count
+	breaker.Record(start, domain.ErrUnavailable)
+	assert.NoError(t, breaker.Allow(start))
+	breaker.Release()
+	breaker.Record(start, domain.ErrUnavailable)
+	assert.Equal(t, StateOpen, breaker.State(start))
+
+	// A cancelled probe frees the slot for another one, without closing
+	probe := start.Add(time.Minute)
+	assert.NoError(t, breaker.Allow(probe))
+	breaker.Release()
+	assert.Equal(t, StateHalfOpen, breaker.State(probe))
+	assert.NoError(t, breaker.Allow(probe))
+	assert.ErrorIs(t, breaker.Allow(probe), domain.ErrCircuitOpen, "one probe at a time")
+}
+
+func TestBreaker_Record(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		outcomes []error
+		want     string
+	}{
+		{"transient failures open", []error{domain.ErrUnavailable, domain.ErrThrottled}, StateOpen},
+		{"success resets the count", []error{domain.ErrUnavailable, nil, domain.ErrUnavailable}, StateClosed},
+		{"not found is not a failure", []error{domain.ErrNotFound, domain.ErrNotFound}, StateClosed},
+		{"auth expired is not a failure", []error{domain.ErrAuthExpired, domain.ErrAuthExpired}, StateClosed},
+		{"other errors are not failures", []error{errors.New("bad request"), errors.New("bad request")}, StateClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreaker(2, time.Minute)
+			for _, err := range tt.outcomes {
+				breaker.Record(start, err)
+			}
+			assert.Equal(t, tt.want, breaker.State(start))
+		})
+	}
+}
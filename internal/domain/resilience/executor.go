@@ -0,0 +1,158 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/ratelimit"
+)
+
+// Config configures an Executor
+type Config struct {
+	// Rate and Burst size each key's token bucket, in calls per second
+	// (default: 10 per second, bursts of 20)
+	Rate  float64
+	Burst int
+
+	// MaxAttempts bounds the calls made for one Do, retries included (default: 4)
+	MaxAttempts int
+
+	// BaseDelay doubles on every retry up to MaxDelay, with jitter (default: 500ms to 30s).
+	// A Retry-After longer than MaxDelay is not waited for: the throttled error is
+	// returned so the caller can defer the work.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// FailureThreshold consecutive transient failures open a key's breaker for
+	// Cooldown (default: 5 failures, 1 minute)
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// withDefaults fills unset fields
+func (c Config) withDefaults() Config {
+	if c.Rate <= 0 {
+		c.Rate = 10
+	}
+	if c.Burst <= 0 {
+		c.Burst = 20
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 4
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = 500 * time.Millisecond
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 30 * time.Second
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = time.Minute
+	}
+	return c
+}
+
+// Transient reports whether a failure may succeed when retried
+func Transient(err error) bool {
+	return errors.Is(err, domain.ErrThrottled) || errors.Is(err, domain.ErrUnavailable)
+}
+
+// Executor runs calls with per-key rate limiting, retries and circuit breaking
+type Executor struct {
+	cfg Config
+
+	// Clock, sleep and jitter are replaced in tests
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(d time.Duration) time.Duration
+
+	mu       sync.Mutex
+	limiters map[string]*ratelimit.TokenBucket
+	breakers map[string]*Breaker
+}
+
+// NewExecutor creates an executor
+func NewExecutor(cfg Config) *Executor {
+	return &Executor{
+		cfg:      cfg.withDefaults(),
+		now:      time.Now,
+		sleep:    Sleep,
+		jitter:   equalJitter,
+		limiters: make(map[string]*ratelimit.TokenBucket),
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Do calls fn for key within the key's rate limit and circuit breaker, retrying
+// transient failures
+// It returns fn's last error, domain.ErrCircuitOpen when the breaker refuses the
+// call, or the context's error when cancelled while waiting.
+func (e *Executor) Do(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	limiter, breaker := e.limits(key)
+	for attempt := 1; ; attempt++ {
+		if err := breaker.Allow(e.now()); err != nil {
+			return err
+		}
+		if wait := limiter.Reserve(e.now()); wait > 0 {
+			if err := e.sleep(ctx, wait); err != nil {
+				breaker.Release() // Not called: free a half-open probe
+				return err
+			}
+		}
+
+		err := fn(ctx)
+		breaker.Record(e.now(), err)
+		if err == nil || !Transient(err) || attempt >= e.cfg.MaxAttempts {
+			return err
+		}
+
+		delay := e.backoff(attempt)
+		if retryAfter := domain.RetryAfter(err); retryAfter > 0 {
+			if retryAfter > e.cfg.MaxDelay {
+				return err
+			}
+			delay = retryAfter
+		}
+		if err := e.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// State returns the breaker state of a key
+func (e *Executor) State(key string) string {
+	_, breaker := e.limits(key)
+	return breaker.State(e.now())
+}
+
+// backoff returns the jittered delay before retry number attempt
+func (e *Executor) backoff(attempt int) time.Duration {
+	return e.jitter(Backoff(e.cfg.BaseDelay, e.cfg.MaxDelay, attempt))
+}
+
+// limits returns the token bucket and breaker of a key
+func (e *Executor) limits(key string) (*ratelimit.TokenBucket, *Breaker) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	limiter, ok := e.limiters[key]
+	if !ok {
+		limiter = ratelimit.NewTokenBucket(e.cfg.Rate, e.cfg.Burst)
+		e.limiters[key] = limiter
+		e.breakers[key] = NewBreaker(e.cfg.FailureThreshold, e.cfg.Cooldown)
+	}
+	return limiter, e.breakers[key]
+}
+
+// equalJitter returns a random delay between d/2 and d, so clients throttled
+// together do not retry together
+func equalJitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
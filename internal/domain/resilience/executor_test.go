@@ -0,0 +1,159 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// newTestExecutor returns an executor on a fake clock that sleeps by advancing it,
+// without jitter, and the slept durations
+func newTestExecutor(cfg Config) (*Executor, *[]time.Duration) {
+	executor := NewExecutor(cfg)
+	now := time.Unix(1700000000, 0)
+	var slept []time.Duration
+	executor.now = func() time.Time { return now }
+	executor.sleep = func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	executor.jitter = func(d time.Duration) time.Duration { return d }
+	return executor, &slept
+}
+
+// failing returns a call failing with errs in turn, then succeeding, and its call count
+func failing(errs ...error) (func(ctx context.Context) error, *int) {
+	calls := 0
+	return func(ctx context.Context) error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+func TestExecutor_Do(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+		wantSlept []time.Duration
+	}{
+		{
+			name:      "success",
+			wantCalls: 1,
+		},
+		{
+			name:      "transient failures are retried with backoff",
+			errs:      []error{domain.ErrUnavailable, domain.ErrUnavailable},
+			wantCalls: 3,
+			wantSlept: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "retry after is honored",
+			errs:      []error{&domain.ThrottledError{RetryAfter: 7 * time.Second}},
+			wantCalls: 2,
+			wantSlept: []time.Duration{7 * time.Second},
+		},
+		{
+			name:      "retry after beyond the max delay is not waited for",
+			errs:      []error{&domain.ThrottledError{RetryAfter: time.Hour}},
+			wantErr:   domain.ErrThrottled,
+			wantCalls: 1,
+		},
+		{
+			name:      "attempts are bounded",
+			errs:      []error{domain.ErrUnavailable, domain.ErrUnavailable, domain.ErrUnavailable, domain.ErrUnavailable},
+			wantErr:   domain.ErrUnavailable,
+			wantCalls: 3,
+			wantSlept: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "not found is not retried",
+			errs:      []error{domain.ErrNotFound},
+			wantErr:   domain.ErrNotFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "auth expired is not retried",
+			errs:      []error{domain.ErrAuthExpired},
+			wantErr:   domain.ErrAuthExpired,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor, slept := newTestExecutor(Config{
+				MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, FailureThreshold: 10,
+			})
+			fn, calls := failing(tt.errs...)
+
+			err := executor.Do(context.Background(), "tenant", fn)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, *calls)
+			assert.Equal(t, tt.wantSlept, []time.Duration(*slept))
+		})
+	}
+}
+
+func TestExecutor_Do_RateLimit(t *testing.T) {
+	executor, slept := newTestExecutor(Config{Rate: 2, Burst: 2})
+	fn, calls := failing()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, executor.Do(context.Background(), "tenant", fn))
+	}
+	assert.Equal(t, 4, *calls)
+	// The burst goes through, then calls are spaced at the rate
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, []time.Duration(*slept))
+
+	// Keys have their own bucket
+	require.NoError(t, executor.Do(context.Background(), "other", fn))
+	assert.Len(t, *slept, 2)
+}
+
+func TestExecutor_Do_CircuitBreaker(t *testing.T) {
+	executor, _ := newTestExecutor(Config{MaxAttempts: 1, FailureThreshold: 2, Cooldown: time.Minute})
+	unavailable, _ := failing(domain.ErrUnavailable, domain.ErrUnavailable)
+
+	assert.ErrorIs(t, executor.Do(context.Background(), "tenant", unavailable), domain.ErrUnavailable)
+	assert.ErrorIs(t, executor.Do(context.Background(), "tenant", unavailable), domain.ErrUnavailable)
+	assert.Equal(t, StateOpen, executor.State("tenant"))
+
+	fn, calls := failing()
+	assert.ErrorIs(t, executor.Do(context.Background(), "tenant", fn), domain.ErrCircuitOpen)
+	assert.Equal(t, 0, *calls, "an open circuit does not call")
+
+	// Other keys are unaffected
+	assert.NoError(t, executor.Do(context.Background(), "other", fn))
+	assert.Equal(t, 1, *calls)
+}
+
+func TestExecutor_Do_Cancelled(t *testing.T) {
+	executor, _ := newTestExecutor(Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fn, calls := failing(domain.ErrUnavailable)
+
+	err := executor.Do(ctx, "tenant", fn)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, *calls)
+}
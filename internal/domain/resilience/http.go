@@ -0,0 +1,57 @@
+package resilience
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Default per-tenant quotas of the provider APIs, in calls per second
+// Graph allows 10,000 requests per 10 minutes per mailbox and application; Gmail
+// 250 quota units per second per user, about 50 messages.get calls.
+const (
+	GraphRate = 10000.0 / 600
+	GmailRate = 50.0
+)
+
+// FromHTTP classifies a provider API error response as a domain provider error
+// retryAfter is the Retry-After header, reason the error reason of the body
+// (Gmail reports rate limits as 403 rateLimitExceeded/userRateLimitExceeded).
+// It returns nil for successful statuses.
+func FromHTTP(status int, retryAfter, reason string, now time.Time) error {
+	switch {
+	case status < 400:
+		return nil
+	case status == http.StatusTooManyRequests,
+		status == http.StatusForbidden && (reason == "rateLimitExceeded" || reason == "userRateLimitExceeded"):
+		return &domain.ThrottledError{RetryAfter: ParseRetryAfter(retryAfter, now)}
+	case status == http.StatusUnauthorized:
+		return domain.ErrAuthExpired
+	case status == http.StatusNotFound:
+		return domain.ErrNotFound
+	case status >= 500:
+		return fmt.Errorf("%w: status %d", domain.ErrUnavailable, status)
+	default:
+		return fmt.Errorf("provider request rejected: status %d %s", status, reason)
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+// It returns 0 if the header is missing, invalid or in the past.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
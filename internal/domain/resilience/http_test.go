@@ -0,0 +1,57 @@
+package resilience
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+func TestFromHTTP(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name           string
+		status         int
+		retryAfter     string
+		reason         string
+		wantErr        error
+		wantRetryAfter time.Duration
+	}{
+		{name: "success", status: http.StatusOK},
+		{name: "too many requests", status: http.StatusTooManyRequests, retryAfter: "12", wantErr: domain.ErrThrottled, wantRetryAfter: 12 * time.Second},
+		{name: "gmail rate limit", status: http.StatusForbidden, reason: "userRateLimitExceeded", wantErr: domain.ErrThrottled},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantErr: domain.ErrAuthExpired},
+		{name: "not found", status: http.StatusNotFound, wantErr: domain.ErrNotFound},
+		{name: "server error", status: http.StatusServiceUnavailable, wantErr: domain.ErrUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := FromHTTP(tt.status, tt.retryAfter, tt.reason, now)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantRetryAfter, domain.RetryAfter(err))
+		})
+	}
+
+	t.Run("forbidden", func(t *testing.T) {
+		err := FromHTTP(http.StatusForbidden, "", "insufficientPermissions", now)
+		assert.Error(t, err)
+		assert.False(t, Transient(err))
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, ParseRetryAfter("30", now))
+	assert.Equal(t, 90*time.Second, ParseRetryAfter("Mon, 01 Jan 2024 12:01:30 GMT", now))
+	assert.Zero(t, ParseRetryAfter("Mon, 01 Jan 2024 11:00:00 GMT", now))
+	assert.Zero(t, ParseRetryAfter("", now))
+	assert.Zero(t, ParseRetryAfter("soon", now))
+}
@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoleOverride corrects the role classification of a tenant's users (see package roles)
+// It targets either every user with a job title ("Head of Treasury Ops" is finance)
+// or one user by address, e.g. to tag a VIP whose title says nothing.
+type RoleOverride struct {
+	ID        uuid.UUID `json:"id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Title     string    `json:"title,omitempty"` // Matched ignoring case, accents and punctuation
+	Email     string    `json:"email,omitempty"`
+	Functions []string  `json:"functions,omitempty"` // e.g. ["finance"]; replaces the classified functions
+	Seniority string    `json:"seniority,omitempty"` // e.g. "c_level"; empty keeps the classified seniority
+	VIP       bool      `json:"vip,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
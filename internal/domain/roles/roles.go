@@ -0,0 +1,333 @@
+// Package roles classifies directory users into a role taxonomy
+//
+// Job titles, departments and groups come from the directory as free text in any
+// language. The classifier normalizes them into business functions (executive,
+// finance, HR, IT admin, legal, procurement, assistant) and a seniority level, so
+// detection strategies reason about "a finance manager" rather than matching
+// substrings of "Finance Manager". Tenants can override the classification of a
+// title or of a user, and tag users as VIPs.
+package roles
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Function is a business function of the taxonomy
+type Function string
+
+const (
+	Executive   Function = "executive"
+	Finance     Function = "finance"
+	HR          Function = "hr"
+	ITAdmin     Function = "it_admin"
+	Legal       Function = "legal"
+	Procurement Function = "procurement"
+	Assistant   Function = "assistant"
+)
+
+// Functions lists the taxonomy functions, in the order profiles report them
+var Functions = []Function{Executive, Finance, HR, ITAdmin, Legal, Procurement, Assistant}
+
+// Valid reports whether f is a taxonomy function
+func (f Function) Valid() bool {
+	for _, function := range Functions {
+		if f == function {
+			return true
+		}
+	}
+	return false
+}
+
+// Seniority ranks a position, from individual contributors to the C-suite
+type Seniority int
+
+const (
+	Staff Seniority = iota
+	Manager
+	Director
+	VP
+	CLevel
+)
+
+var seniorityNames = []string{"staff", "manager", "director", "vp", "c_level"}
+
+func (s Seniority) String() string {
+	if s < Staff || s > CLevel {
+		return "invalid"
+	}
+	return seniorityNames[s]
+}
+
+// ParseSeniority parses a seniority name ("staff", "manager", "director", "vp", "c_level")
+func ParseSeniority(name string) (Seniority, error) {
+	for i, n := range seniorityNames {
+		if n == name {
+			return Seniority(i), nil
+		}
+	}
+	return Staff, fmt.Errorf("unknown seniority %q", name)
+}
+
+// MarshalText encodes a seniority by name
+func (s Seniority) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a seniority name
+func (s *Seniority) UnmarshalText(text []byte) error {
+	parsed, err := ParseSeniority(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// Profile is the classification of a user
+type Profile struct {
+	Functions  []Function `json:"functions,omitempty"`
+	Seniority  Seniority  `json:"seniority"`
+	VIP        bool       `json:"vip,omitempty"`
+	Overridden bool       `json:"overridden,omitempty"` // A tenant override applied
+}
+
+// Has reports whether the profile includes a function
+func (p Profile) Has(function Function) bool {
+	for _, f := range p.Functions {
+		if f == function {
+			return true
+		}
+	}
+	return false
+}
+
+// HighValue reports whether the user is a likely BEC target: a VIP, a member of a
+// taxonomy function, or a vice president and above
+func (p Profile) HighValue() bool {
+	return p.VIP || len(p.Functions) > 0 || p.Seniority >= VP
+}
+
+// String describes the profile for evidence, e.g. "finance, director, VIP"
+func (p Profile) String() string {
+	parts := make([]string, 0, len(p.Functions)+2)
+	for _, f := range p.Functions {
+		parts = append(parts, string(f))
+	}
+	if p.Seniority > Staff {
+		parts = append(parts, p.Seniority.String())
+	}
+	if p.VIP {
+		parts = append(parts, "VIP")
+	}
+	if len(parts) == 0 {
+		return "staff"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Classifier classifies users with a taxonomy and a tenant's overrides
+// It is immutable and safe for concurrent use.
+type Classifier struct {
+	taxonomy *Taxonomy
+	titles   map[string]domain.RoleOverride // Keyed by normalized title
+	users    map[string]domain.RoleOverride // Keyed by lowercase address
+}
+
+// defaultClassifier classifies with the embedded taxonomy and no overrides
+var defaultClassifier = New(defaultTaxonomy)
+
+// Default returns the classifier of the embedded taxonomy, without overrides
+func Default() *Classifier {
+	return defaultClassifier
+}
+
+// New creates a classifier over a taxonomy
+func New(taxonomy *Taxonomy) *Classifier {
+	return &Classifier{taxonomy: taxonomy}
+}
+
+// Version returns the version of the classifier's taxonomy
+func (c *Classifier) Version() string {
+	return c.taxonomy.Version
+}
+
+// WithOverrides returns a copy of the classifier applying a tenant's overrides
+// Invalid overrides are ignored: they are validated when stored.
+func (c *Classifier) WithOverrides(overrides []domain.RoleOverride) *Classifier {
+	clone := &Classifier{
+		taxonomy: c.taxonomy,
+		titles:   make(map[string]domain.RoleOverride),
+		users:    make(map[string]domain.RoleOverride),
+	}
+	for _, override := range overrides {
+		if ValidateOverride(override) != nil {
+			continue
+		}
+		if override.Email != "" {
+			clone.users[strings.ToLower(override.Email)] = override
+		} else {
+			clone.titles[Normalize(override.Title)] = override
+		}
+	}
+	return clone
+}
+
+// Classify returns the profile of a user (the zero profile for nil)
+//
+// The job title gives functions and seniority; the department and group names add
+// functions. A title override replaces the title's classification; a user override
+// replaces the functions and seniority it sets, and tags VIPs.
+func (c *Classifier) Classify(user *domain.User) Profile {
+	if user == nil {
+		return Profile{}
+	}
+
+	var profile Profile
+	functions := make(map[Function]bool)
+	if override, ok := c.titles[Normalize(user.Role)]; ok && user.Role != "" {
+		applyOverride(&profile, functions, override)
+	} else {
+		profile.Seniority = c.classifyTitle(user.Role, functions)
+	}
+	for _, text := range append([]string{user.Department}, user.Groups...) {
+		for _, m := range c.taxonomy.find(Words(text)) {
+			if m.function != "" {
+				functions[m.function] = true
+			}
+		}
+	}
+	if override, ok := c.users[strings.ToLower(user.Email)]; ok {
+		if len(override.Functions) > 0 {
+			functions = make(map[Function]bool)
+		}
+		applyOverride(&profile, functions, override)
+	}
+
+	for _, f := range Functions {
+		if functions[f] {
+			profile.Functions = append(profile.Functions, f)
+		}
+	}
+	return profile
+}
+
+// ClassifyTitle returns the profile of a job title alone, without overrides
+func (c *Classifier) ClassifyTitle(title string) Profile {
+	return c.Classify(&domain.User{Role: title})
+}
+
+// classifyTitle adds the functions named by a title and returns its seniority
+//
+//   - When phrases overlap, the longest wins: "vice president" is a VP, not a
+//     president; "chief of staff" is not a chief officer
+//   - An assistant ("Executive Assistant", "Assistant to the CEO") is staff and
+//     not an executive, whoever they assist; functions named before the assistant
+//     phrase are kept ("Finance Assistant")
+//   - "Assistant" directly followed by a rank or function is a deputy: "Assistant
+//     Director" is a manager, "Assistant General Counsel" is legal
+func (c *Classifier) classifyTitle(title string, functions map[Function]bool) Seniority {
+	matches := c.taxonomy.find(Words(title))
+
+	// deputies are the matches preceded by a deputy "assistant"
+	deputies := make(map[int]bool)
+	assistantAt := -1
+	for _, m := range matches {
+		if m.function != Assistant || covered(m, matches) {
+			continue
+		}
+		deputy := false
+		for j, n := range matches {
+			if n.start == m.end && n.function != Assistant {
+				deputies[j] = true
+				deputy = true
+			}
+		}
+		if !deputy && (assistantAt < 0 || m.start < assistantAt) {
+			assistantAt = m.start
+		}
+	}
+
+	if assistantAt >= 0 {
+		functions[Assistant] = true
+		for _, m := range matches {
+			if m.function != "" && m.function != Assistant && m.function != Executive && m.end <= assistantAt {
+				functions[m.function] = true
+			}
+		}
+		return Staff
+	}
+
+	seniority := Staff
+	for i, m := range matches {
+		if m.function != "" {
+			if m.function != Assistant {
+				functions[m.function] = true
+			}
+			continue
+		}
+		if covered(m, matches) {
+			continue
+		}
+		level := m.seniority
+		if deputies[i] && level > Staff {
+			level--
+		}
+		seniority = max(seniority, level)
+	}
+	if seniority == CLevel {
+		functions[Executive] = true
+	}
+	return seniority
+}
+
+// covered reports whether a longer match of the same kind (seniority, or the same
+// function) contains m
+func covered(m match, matches []match) bool {
+	for _, n := range matches {
+		sameKind := (m.function == "") == (n.function == "") && (m.function == "" || m.function == n.function)
+		if sameKind && n.start <= m.start && n.end >= m.end && n.end-n.start > m.end-m.start {
+			return true
+		}
+	}
+	return false
+}
+
+// applyOverride applies the fields an override sets
+func applyOverride(profile *Profile, functions map[Function]bool, override domain.RoleOverride) {
+	for _, f := range override.Functions {
+		functions[Function(f)] = true
+	}
+	if override.Seniority != "" {
+		profile.Seniority, _ = ParseSeniority(override.Seniority)
+	}
+	profile.VIP = profile.VIP || override.VIP
+	profile.Overridden = true
+}
+
+// ValidateOverride checks that an override targets exactly one title or user and
+// sets known functions and seniority
+func ValidateOverride(override domain.RoleOverride) error {
+	if (override.Title == "") == (override.Email == "") {
+		return fmt.Errorf("role override must target exactly one of a title or a user email")
+	}
+	if override.Title != "" && Normalize(override.Title) == "" {
+		return fmt.Errorf("role override title %q has no words", override.Title)
+	}
+	if len(override.Functions) == 0 && override.Seniority == "" && !override.VIP {
+		return fmt.Errorf("role override must set functions, seniority or VIP")
+	}
+	for _, f := range override.Functions {
+		if !Function(f).Valid() {
+			return fmt.Errorf("unknown function %q", f)
+		}
+	}
+	if override.Seniority != "" {
+		if _, err := ParseSeniority(override.Seniority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
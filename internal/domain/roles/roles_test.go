@@ -0,0 +1,146 @@
+package roles
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+func TestClassifier_ClassifyTitle(t *testing.T) {
+	tests := []struct {
+		title     string
+		functions []Function
+		seniority Seniority
+	}{
+		{"CEO", []Function{Executive}, CLevel},
+		{"CFO", []Function{Executive, Finance}, CLevel},
+		{"Chief Revenue Officer", []Function{Executive}, CLevel},
+		{"Vice President, Sales", nil, VP},
+		{"Director of Facilities", nil, Director},
+		{"Chief of Staff", nil, Director},
+		{"Chief of Staff Assistant", []Function{Assistant}, Staff},
+		{"Executive Assistant to the CEO", []Function{Assistant}, Staff},
+		{"Finance Assistant", []Function{Finance, Assistant}, Staff},
+		{"Assistant Director of Finance", []Function{Finance}, Manager},
+		{"Assistant General Counsel", []Function{Legal}, Staff},
+		{"Finance Manager", []Function{Finance}, Manager},
+		{"HR Director", []Function{HR}, Director},
+		{"IT Administrator", []Function{ITAdmin}, Staff},
+		{"Procurement Lead", []Function{Procurement}, Manager},
+		{"Software Engineer", nil, Staff},
+		{"Product Owner", nil, Staff},
+		{"General Manager", []Function{Executive}, CLevel},
+
+		// Whole words only
+		{"Chargé de mission Rhône", nil, Staff},
+		{"Budget Analyst", nil, Staff},
+		{"Responsable RH", []Function{HR}, Manager},
+		{"DG", []Function{Executive}, CLevel},
+
+		// Other languages, accents optional
+		{"Directeur Général", []Function{Executive}, CLevel},
+		{"Directeur General", []Function{Executive}, CLevel},
+		{"Directeur commercial", nil, Director},
+		{"Geschäftsführer", []Function{Executive}, CLevel},
+		{"Personalleiterin", []Function{HR}, Director},
+		{"Contabilità", []Function{Finance}, Staff},
+		{"Algemeen Directeur", []Function{Executive}, CLevel},
+		{"Directora de Recursos Humanos", []Function{HR}, Director},
+		{"Acheteuse", []Function{Procurement}, Staff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			profile := Default().ClassifyTitle(tt.title)
+			assert.Equal(t, tt.functions, profile.Functions)
+			assert.Equal(t, tt.seniority, profile.Seniority, profile.Seniority.String())
+		})
+	}
+}
+
+func TestClassifier_Classify(t *testing.T) {
+	analyst := &domain.User{Email: "analyst@company.com", Role: "Analyst", Department: "Accounts Payable", Groups: []string{"Executives"}}
+	profile := Default().Classify(analyst)
+	assert.Equal(t, []Function{Executive, Finance}, profile.Functions)
+	assert.Equal(t, Staff, profile.Seniority, "groups and departments don't give seniority")
+	assert.True(t, profile.HighValue())
+
+	assert.Equal(t, Profile{}, Default().Classify(nil))
+	assert.False(t, Default().Classify(&domain.User{Role: "Software Engineer"}).HighValue())
+}
+
+func TestClassifier_WithOverrides(t *testing.T) {
+	classifier := Default().WithOverrides([]domain.RoleOverride{
+		{Title: "Head of Treasury Ops", Functions: []string{"finance"}, Seniority: "director"},
+		{Email: "Board.Advisor@company.com", VIP: true},
+		{Email: "facilities@company.com", Functions: []string{"procurement"}},
+		{Title: "Invalid", Functions: []string{"sales"}},
+	})
+
+	profile := classifier.Classify(&domain.User{Email: "ops@company.com", Role: "head of treasury-ops"})
+	assert.Equal(t, []Function{Finance}, profile.Functions)
+	assert.Equal(t, Director, profile.Seniority)
+	assert.True(t, profile.Overridden)
+
+	advisor := classifier.Classify(&domain.User{Email: "board.advisor@company.com", Role: "Advisor"})
+	assert.True(t, advisor.VIP)
+	assert.True(t, advisor.HighValue())
+	assert.Equal(t, "VIP", advisor.String())
+
+	facilities := classifier.Classify(&domain.User{Email: "facilities@company.com", Role: "Director of Facilities", Groups: []string{"Finance"}})
+	assert.Equal(t, []Function{Procurement}, facilities.Functions, "user override replaces functions")
+	assert.Equal(t, Director, facilities.Seniority, "and keeps the seniority it doesn't set")
+
+	// The default classifier is not modified
+	assert.False(t, Default().Classify(&domain.User{Email: "board.advisor@company.com"}).VIP)
+}
+
+func TestValidateOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		override domain.RoleOverride
+		wantErr  string
+	}{
+		{"title", domain.RoleOverride{Title: "Head of Treasury", Functions: []string{"finance"}}, ""},
+		{"VIP user", domain.RoleOverride{Email: "ceo@company.com", VIP: true}, ""},
+		{"no target", domain.RoleOverride{VIP: true}, "exactly one"},
+		{"two targets", domain.RoleOverride{Title: "CEO", Email: "ceo@company.com", VIP: true}, "exactly one"},
+		{"nothing set", domain.RoleOverride{Title: "CEO"}, "must set"},
+		{"unknown function", domain.RoleOverride{Title: "Sales", Functions: []string{"sales"}}, `unknown function "sales"`},
+		{"unknown seniority", domain.RoleOverride{Title: "Sales", Seniority: "boss"}, `unknown seniority "boss"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOverride(tt.override)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfile_JSON(t *testing.T) {
+	raw, err := json.Marshal(Profile{Functions: []Function{Finance}, Seniority: VP})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"functions": ["finance"], "seniority": "vp"}`, string(raw))
+
+	var decoded Profile
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.Equal(t, VP, decoded.Seniority)
+}
+
+func TestParseTaxonomy_Errors(t *testing.T) {
+	_, err := ParseTaxonomy([]byte(`{"version": "1", "functions": {"sales": ["sales"]}}`))
+	assert.ErrorContains(t, err, `unknown function "sales"`)
+	_, err = ParseTaxonomy([]byte(`{"version": "1", "seniority": {"boss": ["boss"]}}`))
+	assert.ErrorContains(t, err, `unknown seniority "boss"`)
+	_, err = ParseTaxonomy([]byte(`{"functions": {}}`))
+	assert.ErrorContains(t, err, "version")
+}
@@ -0,0 +1,149 @@
+package roles
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+//go:embed data/taxonomy.json
+var taxonomyJSON []byte
+
+// Taxonomy is the vocabulary mapping job title phrases to seniority levels and
+// business functions
+//
+// Phrases are matched as whole words, ignoring case and accents: "rh" matches
+// "Responsable RH" but not "Rhône", "dg" matches "DG" but not "budget". The file is
+// versioned like the lexicons, so a change in classification can be tied to a release.
+type Taxonomy struct {
+	Version   string                `json:"version"`
+	Seniority map[string][]string   `json:"seniority"` // Seniority name to phrases
+	Functions map[Function][]string `json:"functions"` // Function to phrases
+
+	// phrases indexes every phrase by its first word
+	phrases map[string][]phrase
+}
+
+// phrase is a taxonomy entry: a sequence of words naming a seniority or a function
+type phrase struct {
+	words     []string
+	seniority Seniority // Set when function is empty
+	function  Function
+}
+
+// match is a phrase found in a title, covering words [start, end)
+type match struct {
+	phrase
+	start, end int
+}
+
+// defaultTaxonomy is the embedded taxonomy
+// Loaded once at package init: a malformed taxonomy file is a build defect, not a runtime condition
+var defaultTaxonomy = mustParse(taxonomyJSON)
+
+func mustParse(raw []byte) *Taxonomy {
+	taxonomy, err := ParseTaxonomy(raw)
+	if err != nil {
+		panic(fmt.Sprintf("roles: %v", err))
+	}
+	return taxonomy
+}
+
+// ParseTaxonomy reads a taxonomy from its JSON form
+func ParseTaxonomy(raw []byte) (*Taxonomy, error) {
+	var taxonomy Taxonomy
+	if err := json.Unmarshal(raw, &taxonomy); err != nil {
+		return nil, fmt.Errorf("failed to parse taxonomy: %w", err)
+	}
+	if taxonomy.Version == "" {
+		return nil, fmt.Errorf("taxonomy version is required")
+	}
+
+	taxonomy.phrases = make(map[string][]phrase)
+	add := func(text string, p phrase) error {
+		p.words = Words(text)
+		if len(p.words) == 0 {
+			return fmt.Errorf("empty phrase %q", text)
+		}
+		taxonomy.phrases[p.words[0]] = append(taxonomy.phrases[p.words[0]], p)
+		return nil
+	}
+	for name, texts := range taxonomy.Seniority {
+		seniority, err := ParseSeniority(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, text := range texts {
+			if err := add(text, phrase{seniority: seniority}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for function, texts := range taxonomy.Functions {
+		if !function.Valid() {
+			return nil, fmt.Errorf("unknown function %q", function)
+		}
+		for _, text := range texts {
+			if err := add(text, phrase{function: function}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &taxonomy, nil
+}
+
+// find returns every phrase occurring in words
+func (t *Taxonomy) find(words []string) []match {
+	matches := make([]match, 0)
+	for start, word := range words {
+		for _, p := range t.phrases[word] {
+			end := start + len(p.words)
+			if end <= len(words) && equalWords(words[start:end], p.words) {
+				matches = append(matches, match{phrase: p, start: start, end: end})
+			}
+		}
+	}
+	return matches
+}
+
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Words splits a title into lowercase words without accents
+// Hyphens and apostrophes separate words ("co-founder", "d'information"); "&" is
+// kept inside words ("p&o", "fp&a").
+func Words(title string) []string {
+	return strings.FieldsFunc(fold(strings.ToLower(title)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '&'
+	})
+}
+
+// Normalize returns a title's words joined by spaces, the form override titles are
+// matched in
+func Normalize(title string) string {
+	return strings.Join(Words(title), " ")
+}
+
+// accents folds the accented letters of the supported languages
+var accents = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ä", "a", "ã", "a",
+	"ç", "c",
+	"è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u",
+	"ß", "ss",
+)
+
+func fold(s string) string {
+	return accents.Replace(s)
+}
@@ -0,0 +1,288 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+)
+
+// evalFunc evaluates a type-checked expression: bool, float64, string, []string
+// or map[string]string according to its static type
+type evalFunc func(env *Env) interface{}
+
+// checked is a type-checked expression ready to evaluate
+type checked struct {
+	typ  Type
+	eval evalFunc
+}
+
+// check type-checks a parsed expression and compiles it into a closure
+// Regular expressions are compiled here, so evaluation cannot fail.
+func check(n node) (checked, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		value := n.value
+		eval := func(*Env) interface{} { return value }
+		switch value.(type) {
+		case bool:
+			return checked{TypeBool, eval}, nil
+		case float64:
+			return checked{TypeNumber, eval}, nil
+		default:
+			return checked{TypeString, eval}, nil
+		}
+
+	case *listNode:
+		elements := make([]evalFunc, len(n.elements))
+		for i, element := range n.elements {
+			c, err := check(element)
+			if err != nil {
+				return checked{}, err
+			}
+			if c.typ != TypeString {
+				return checked{}, errorf(element.position(), "list elements must be strings, got %s", c.typ)
+			}
+			elements[i] = c.eval
+		}
+		return checked{TypeList, func(env *Env) interface{} {
+			values := make([]string, len(elements))
+			for i, element := range elements {
+				values[i] = element(env).(string)
+			}
+			return values
+		}}, nil
+
+	case *fieldNode:
+		f, ok := fields[n.name]
+		if !ok {
+			if _, isFunction := functions[n.name]; isFunction {
+				return checked{}, errorf(n.pos, "%q is a function: call it as %s(...)", n.name, n.name)
+			}
+			if s := suggest(n.name); s != "" {
+				return checked{}, errorf(n.pos, "unknown field %q (did you mean %q?)", n.name, s)
+			}
+			return checked{}, errorf(n.pos, "unknown field %q", n.name)
+		}
+		return checked{f.typ, f.get}, nil
+
+	case *indexNode:
+		target, err := check(n.target)
+		if err != nil {
+			return checked{}, err
+		}
+		if target.typ != TypeMap {
+			return checked{}, errorf(n.pos, "cannot index %s, only headers", target.typ)
+		}
+		key, err := check(n.key)
+		if err != nil {
+			return checked{}, err
+		}
+		if key.typ != TypeString {
+			return checked{}, errorf(n.key.position(), "header name must be a string, got %s", key.typ)
+		}
+		return checked{TypeString, func(env *Env) interface{} {
+			return target.eval(env).(map[string]string)[key.eval(env).(string)]
+		}}, nil
+
+	case *callNode:
+		return checkCall(n)
+
+	case *notNode:
+		operand, err := check(n.operand)
+		if err != nil {
+			return checked{}, err
+		}
+		if operand.typ != TypeBool {
+			return checked{}, errorf(n.pos, "\"not\" expects a bool, got %s", operand.typ)
+		}
+		return checked{TypeBool, func(env *Env) interface{} { return !operand.eval(env).(bool) }}, nil
+
+	case *binaryNode:
+		return checkBinary(n)
+	}
+	return checked{}, errorf(n.position(), "unsupported expression")
+}
+
+func checkCall(n *callNode) (checked, error) {
+	fn, ok := functions[n.name]
+	if !ok {
+		if _, isField := fields[n.name]; isField {
+			return checked{}, errorf(n.pos, "%q is a field, not a function", n.name)
+		}
+		if s := suggest(n.name); s != "" {
+			return checked{}, errorf(n.pos, "unknown function %q (did you mean %q?)", n.name, s)
+		}
+		return checked{}, errorf(n.pos, "unknown function %q", n.name)
+	}
+
+	args := make([]evalFunc, len(n.args))
+	types := make([]Type, len(n.args))
+	for i, arg := range n.args {
+		c, err := check(arg)
+		if err != nil {
+			return checked{}, err
+		}
+		args[i], types[i] = c.eval, c.typ
+	}
+
+	if n.name == "len" {
+		if len(types) != 1 || (types[0] != TypeString && types[0] != TypeList) {
+			return checked{}, errorf(n.pos, "len expects one string or list argument")
+		}
+	} else {
+		if len(types) != len(fn.params) {
+			return checked{}, errorf(n.pos, "%s expects %d argument(s), got %d", n.name, len(fn.params), len(types))
+		}
+		for i, param := range fn.params {
+			if types[i] != param {
+				return checked{}, errorf(n.args[i].position(), "argument %d of %s must be a %s, got %s", i+1, n.name, param, types[i])
+			}
+		}
+	}
+
+	return checked{fn.result, func(env *Env) interface{} {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = arg(env)
+		}
+		return fn.call(values)
+	}}, nil
+}
+
+func checkBinary(n *binaryNode) (checked, error) {
+	left, err := check(n.left)
+	if err != nil {
+		return checked{}, err
+	}
+
+	// The right operand of "matches" must be a literal so the pattern is validated now
+	if n.op == "matches" {
+		return checkMatches(n, left)
+	}
+
+	right, err := check(n.right)
+	if err != nil {
+		return checked{}, err
+	}
+	l, r := left.eval, right.eval
+
+	switch n.op {
+	case "and", "or":
+		if left.typ != TypeBool || right.typ != TypeBool {
+			return checked{}, errorf(n.pos, "%q expects bool operands, got %s and %s", n.op, left.typ, right.typ)
+		}
+		if n.op == "and" {
+			return checked{TypeBool, func(env *Env) interface{} { return l(env).(bool) && r(env).(bool) }}, nil
+		}
+		return checked{TypeBool, func(env *Env) interface{} { return l(env).(bool) || r(env).(bool) }}, nil
+
+	case "==", "!=":
+		if left.typ != right.typ {
+			return checked{}, errorf(n.pos, "cannot compare %s with %s", left.typ, right.typ)
+		}
+		if left.typ == TypeList || left.typ == TypeMap {
+			return checked{}, errorf(n.pos, "cannot compare %s values with %q (use \"in\" or \"contains\")", left.typ, n.op)
+		}
+		equal := n.op == "=="
+		return checked{TypeBool, func(env *Env) interface{} { return (l(env) == r(env)) == equal }}, nil
+
+	case "<", "<=", ">", ">=":
+		if left.typ != TypeNumber || right.typ != TypeNumber {
+			return checked{}, errorf(n.pos, "%q expects numbers, got %s and %s", n.op, left.typ, right.typ)
+		}
+		op := n.op
+		return checked{TypeBool, func(env *Env) interface{} {
+			a, b := l(env).(float64), r(env).(float64)
+			switch op {
+			case "<":
+				return a < b
+			case "<=":
+				return a <= b
+			case ">":
+				return a > b
+			default:
+				return a >= b
+			}
+		}}, nil
+
+	case "in", "not in":
+		if right.typ == TypeString && left.typ == TypeString {
+			return checked{}, errorf(n.pos, "%q expects a list on the right, got string (use \"contains\" for substrings)", n.op)
+		}
+		if left.typ != TypeString || right.typ != TypeList {
+			return checked{}, errorf(n.pos, "%q expects a string on the left and a list on the right, got %s and %s", n.op, left.typ, right.typ)
+		}
+		want := n.op == "in"
+		return checked{TypeBool, func(env *Env) interface{} {
+			return contains(r(env).([]string), l(env).(string)) == want
+		}}, nil
+
+	case "contains":
+		if right.typ != TypeString {
+			return checked{}, errorf(n.pos, "\"contains\" expects a string on the right, got %s", right.typ)
+		}
+		switch left.typ {
+		case TypeString:
+			return checked{TypeBool, func(env *Env) interface{} { return strings.Contains(l(env).(string), r(env).(string)) }}, nil
+		case TypeList:
+			return checked{TypeBool, func(env *Env) interface{} { return contains(l(env).([]string), r(env).(string)) }}, nil
+		}
+		return checked{}, errorf(n.pos, "\"contains\" expects a string or list on the left, got %s", left.typ)
+
+	case "startswith", "endswith":
+		if right.typ != TypeString {
+			return checked{}, errorf(n.pos, "%q expects a string on the right, got %s", n.op, right.typ)
+		}
+		test := strings.HasPrefix
+		if n.op == "endswith" {
+			test = strings.HasSuffix
+		}
+		return anyString(n, left, func(env *Env, s string) bool { return test(s, r(env).(string)) })
+	}
+
+	return checked{}, errorf(n.pos, "unknown operator %q", n.op)
+}
+
+func checkMatches(n *binaryNode, left checked) (checked, error) {
+	literal, ok := n.right.(*literalNode)
+	pattern, isString := "", false
+	if ok {
+		pattern, isString = literal.value.(string)
+	}
+	if !isString {
+		return checked{}, errorf(n.right.position(), "\"matches\" expects a regular expression string literal on the right")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return checked{}, errorf(n.right.position(), "invalid regular expression: %v", err)
+	}
+	return anyString(n, left, func(env *Env, s string) bool { return re.MatchString(s) })
+}
+
+// anyString applies a string test to a string operand, or to any element of a list operand
+// (e.g. attachments endswith ".exe")
+func anyString(n *binaryNode, operand checked, test func(env *Env, s string) bool) (checked, error) {
+	eval := operand.eval
+	switch operand.typ {
+	case TypeString:
+		return checked{TypeBool, func(env *Env) interface{} { return test(env, eval(env).(string)) }}, nil
+	case TypeList:
+		return checked{TypeBool, func(env *Env) interface{} {
+			for _, s := range eval(env).([]string) {
+				if test(env, s) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+	return checked{}, errorf(n.pos, "%q expects a string or list on the left, got %s", n.op, operand.typ)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
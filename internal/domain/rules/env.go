@@ -0,0 +1,253 @@
+package rules
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/lexicon"
+	"github.com/stoik/email-security/internal/domain/roles"
+)
+
+// Env is what a rule is evaluated against: the email, its recipient and the
+// detection context (internal and trusted domains)
+type Env struct {
+	Email           domain.Email
+	Recipient       *domain.User  // nil when the recipient is not in the directory
+	Profile         roles.Profile // Role classification of the recipient
+	InternalDomains []string
+	TrustedDomains  []string
+}
+
+// Type is the static type of an expression
+type Type int
+
+const (
+	TypeBool Type = iota + 1
+	TypeNumber
+	TypeString
+	TypeList // List of strings
+	TypeMap  // String to string map (headers)
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeMap:
+		return "map"
+	default:
+		return "invalid"
+	}
+}
+
+// field is a named value read from the Env
+type field struct {
+	typ Type
+	doc string
+	get func(env *Env) interface{}
+}
+
+// fields are the values rules can refer to
+//
+// Strings are compared as-is: use lower() or "(?i)" regular expressions for
+// case-insensitive matching. Domains are always lowercase.
+var fields = map[string]field{
+	"subject": {TypeString, "email subject", func(env *Env) interface{} { return env.Email.Subject }},
+	"body":    {TypeString, "body preview (first 500 characters)", func(env *Env) interface{} { return env.Email.BodyPreview }},
+	"language": {TypeString, "detected language code of subject and body (en, fr, de...)", func(env *Env) interface{} {
+		return lexicon.DetectLanguage(strings.ToLower(env.Email.Subject + " " + env.Email.BodyPreview))
+	}},
+
+	"sender.email":  {TypeString, "sender address", func(env *Env) interface{} { return env.Email.SenderEmail }},
+	"sender.name":   {TypeString, "sender display name", func(env *Env) interface{} { return env.Email.SenderName }},
+	"sender.domain": {TypeString, "sender domain", func(env *Env) interface{} { return domainOf(env.Email.SenderEmail) }},
+
+	"recipient.email": {TypeString, "recipient address", func(env *Env) interface{} { return env.Email.RecipientEmail }},
+	"recipient.name": {TypeString, "recipient display name (empty if unknown)", func(env *Env) interface{} {
+		if env.Recipient == nil {
+			return ""
+		}
+		return env.Recipient.DisplayName
+	}},
+	"recipient.role": {TypeString, "recipient job title, lowercase (empty if unknown)", func(env *Env) interface{} {
+		if env.Recipient == nil {
+			return ""
+		}
+		return strings.ToLower(env.Recipient.Role)
+	}},
+	"recipient.department": {TypeString, "recipient department, lowercase (empty if unknown)", func(env *Env) interface{} {
+		if env.Recipient == nil {
+			return ""
+		}
+		return strings.ToLower(env.Recipient.Department)
+	}},
+	"recipient.groups": {TypeList, "recipient directory groups, lowercase", func(env *Env) interface{} {
+		groups := []string{}
+		if env.Recipient != nil {
+			for _, group := range env.Recipient.Groups {
+				groups = append(groups, strings.ToLower(group))
+			}
+		}
+		return groups
+	}},
+	"recipient.manager": {TypeString, "recipient's direct manager address (empty if unknown)", func(env *Env) interface{} {
+		if env.Recipient == nil || len(env.Recipient.Managers) == 0 {
+			return ""
+		}
+		return env.Recipient.Managers[0].Email
+	}},
+	"recipient.managers": {TypeList, "recipient's manager addresses up the org chart, nearest first", func(env *Env) interface{} {
+		managers := []string{}
+		if env.Recipient != nil {
+			for _, manager := range env.Recipient.Managers {
+				managers = append(managers, manager.Email)
+			}
+		}
+		return managers
+	}},
+	"recipient.disabled": {TypeBool, "recipient account disabled in the directory", func(env *Env) interface{} {
+		return env.Recipient != nil && env.Recipient.Disabled
+	}},
+	"recipient.functions": {TypeList, "recipient role taxonomy functions (executive, finance, hr, it_admin, legal, procurement, assistant)", func(env *Env) interface{} {
+		functions := make([]string, len(env.Profile.Functions))
+		for i, f := range env.Profile.Functions {
+			functions[i] = string(f)
+		}
+		return functions
+	}},
+	"recipient.seniority": {TypeString, "recipient seniority (staff, manager, director, vp, c_level)", func(env *Env) interface{} {
+		return env.Profile.Seniority.String()
+	}},
+	"recipient.vip":   {TypeBool, "recipient tagged VIP by the tenant", func(env *Env) interface{} { return env.Profile.VIP }},
+	"recipient.known": {TypeBool, "recipient found in the tenant directory", func(env *Env) interface{} { return env.Recipient != nil }},
+
+	"reply_to":        {TypeString, "Reply-To address (empty if absent)", func(env *Env) interface{} { return env.Email.Headers["Reply-To"] }},
+	"reply_to.domain": {TypeString, "Reply-To domain (empty if absent)", func(env *Env) interface{} { return domainOf(env.Email.Headers["Reply-To"]) }},
+	"headers": {TypeMap, "raw headers, e.g. headers[\"Received-SPF\"] (empty string if absent)", func(env *Env) interface{} {
+		if env.Email.Headers == nil {
+			return map[string]string{}
+		}
+		return env.Email.Headers
+	}},
+
+	"attachments": {TypeList, "attachment file names", func(env *Env) interface{} {
+		if env.Email.AttachmentNames == nil {
+			return []string{}
+		}
+		return env.Email.AttachmentNames
+	}},
+	"has_attachments": {TypeBool, "email has attachments", func(env *Env) interface{} { return env.Email.HasAttachments }},
+
+	"internal_domains": {TypeList, "the organization's domains", func(env *Env) interface{} { return env.InternalDomains }},
+	"trusted_domains":  {TypeList, "trusted external domains", func(env *Env) interface{} { return env.TrustedDomains }},
+}
+
+// function is a builtin callable from rules
+type function struct {
+	params []Type
+	result Type
+	doc    string
+	call   func(args []interface{}) interface{}
+}
+
+var functions = map[string]function{
+	"lower": {[]Type{TypeString}, TypeString, "lowercase a string", func(args []interface{}) interface{} {
+		return strings.ToLower(args[0].(string))
+	}},
+	"domain": {[]Type{TypeString}, TypeString, "domain of an address", func(args []interface{}) interface{} {
+		return domainOf(args[0].(string))
+	}},
+	"similarity": {[]Type{TypeString, TypeString}, TypeNumber, "edit-distance similarity from 0 to 1", func(args []interface{}) interface{} {
+		return similarity(args[0].(string), args[1].(string))
+	}},
+	"max_similarity": {[]Type{TypeString, TypeList}, TypeNumber, "highest similarity to any list element", func(args []interface{}) interface{} {
+		best := 0.0
+		for _, candidate := range args[1].([]string) {
+			best = max(best, similarity(args[0].(string), candidate))
+		}
+		return best
+	}},
+	// len accepts a string or a list; its parameters are checked separately
+	"len": {nil, TypeNumber, "length of a string or list", func(args []interface{}) interface{} {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v)))
+		case []string:
+			return float64(len(v))
+		}
+		return 0.0
+	}},
+}
+
+// Fields describes the fields rules can use, as "type: description" by name
+func Fields() map[string]string {
+	docs := make(map[string]string, len(fields))
+	for name, f := range fields {
+		docs[name] = f.typ.String() + ": " + f.doc
+	}
+	return docs
+}
+
+// suggest returns the known name closest to an unknown one, if close enough
+func suggest(name string) string {
+	candidates := make([]string, 0, len(fields)+len(functions))
+	for f := range fields {
+		candidates = append(candidates, f)
+	}
+	for f := range functions {
+		candidates = append(candidates, f)
+	}
+	sort.Strings(candidates)
+
+	best, bestDistance := "", len(name)/2+1
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func domainOf(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(address[at+1:], "> "))
+}
+
+func similarity(a, b string) float64 {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(a, b))/float64(longest)
+}
+
+// editDistance is the Levenshtein distance between two strings (bytes)
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
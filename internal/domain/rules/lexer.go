@@ -0,0 +1,185 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Position locates a token in the rule source (1-based)
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Error is a compile error with the position of the offending token
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos Position, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenKeyword  // and, or, not, in, matches, contains, startswith, endswith, true, false
+	tokenOperator // == != < <= > >=
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+	tokenDot
+)
+
+type token struct {
+	kind tokenKind
+	text string // Source text; unquoted value for strings
+	num  float64
+	pos  Position
+}
+
+// describe renders a token for error messages
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of rule"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true,
+	"matches": true, "contains": true, "startswith": true, "endswith": true,
+	"true": true, "false": true,
+}
+
+// lex splits a rule into tokens
+func lex(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(src)
+	line, col := 1, 1
+
+	advance := func(n int) {
+		for k := 0; k < n; k++ {
+			if runes[0] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+			runes = runes[1:]
+		}
+	}
+
+	for len(runes) > 0 {
+		r := runes[0]
+		pos := Position{Line: line, Column: col}
+
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+			continue
+
+		case r == '"':
+			value, n, err := lexString(runes, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value, pos: pos})
+			advance(n)
+
+		case unicode.IsDigit(r):
+			n := 0
+			for n < len(runes) && (unicode.IsDigit(runes[n]) || runes[n] == '.') {
+				n++
+			}
+			text := string(runes[:n])
+			num, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, errorf(pos, "invalid number %q", text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, num: num, pos: pos})
+			advance(n)
+
+		case unicode.IsLetter(r) || r == '_':
+			n := 0
+			for n < len(runes) && (unicode.IsLetter(runes[n]) || unicode.IsDigit(runes[n]) || runes[n] == '_') {
+				n++
+			}
+			text := string(runes[:n])
+			kind := tokenIdent
+			if keywords[text] {
+				kind = tokenKeyword
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: pos})
+			advance(n)
+
+		case strings.ContainsRune("=!<>", r):
+			text := string(r)
+			if len(runes) > 1 && runes[1] == '=' {
+				text += "="
+			}
+			if text == "=" || text == "!" {
+				return nil, errorf(pos, "unexpected %q (did you mean %q?)", text, text+"=")
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: text, pos: pos})
+			advance(len(text))
+
+		default:
+			kinds := map[rune]tokenKind{
+				'(': tokenLParen, ')': tokenRParen, '[': tokenLBracket, ']': tokenRBracket,
+				',': tokenComma, '.': tokenDot,
+			}
+			kind, ok := kinds[r]
+			if !ok {
+				return nil, errorf(pos, "unexpected character %q", r)
+			}
+			tokens = append(tokens, token{kind: kind, text: string(r), pos: pos})
+			advance(1)
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: Position{Line: line, Column: col}})
+	return tokens, nil
+}
+
+// lexString reads a double-quoted string, returning the value and rune length
+// Only \" is an escape: other backslashes are kept so regular expressions such as
+// "\d+\.exe$" need no double escaping.
+func lexString(runes []rune, pos Position) (string, int, error) {
+	var value strings.Builder
+	for n := 1; n < len(runes); n++ {
+		switch {
+		case runes[n] == '\\' && n+1 < len(runes) && runes[n+1] == '"':
+			value.WriteRune('"')
+			n++
+		case runes[n] == '\n':
+			return "", 0, errorf(pos, "unterminated string")
+		case runes[n] == '"':
+			return value.String(), n + 1, nil
+		default:
+			value.WriteRune(runes[n])
+		}
+	}
+	return "", 0, errorf(pos, "unterminated string")
+}
@@ -0,0 +1,290 @@
+package rules
+
+import (
+	"strings"
+)
+
+// node is a parsed expression
+type node interface {
+	position() Position
+}
+
+type literalNode struct {
+	pos   Position
+	value interface{} // bool, float64 or string
+}
+
+type listNode struct {
+	pos      Position
+	elements []node
+}
+
+// fieldNode is a (possibly dotted) field name such as "sender.domain"
+type fieldNode struct {
+	pos  Position
+	name string
+}
+
+type indexNode struct {
+	pos    Position
+	target node
+	key    node
+}
+
+type callNode struct {
+	pos  Position
+	name string
+	args []node
+}
+
+type notNode struct {
+	pos     Position
+	operand node
+}
+
+type binaryNode struct {
+	pos         Position // Position of the operator
+	op          string   // "and", "or", "==", "in", "not in", "matches", ...
+	left, right node
+}
+
+func (n *literalNode) position() Position { return n.pos }
+func (n *listNode) position() Position    { return n.pos }
+func (n *fieldNode) position() Position   { return n.pos }
+func (n *indexNode) position() Position   { return n.pos }
+func (n *callNode) position() Position    { return n.pos }
+func (n *notNode) position() Position     { return n.pos }
+func (n *binaryNode) position() Position  { return n.pos }
+
+// comparisonOperators are the non-associative binary operators
+var comparisonOperators = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"in": true, "matches": true, "contains": true, "startswith": true, "endswith": true,
+}
+
+// parser is a recursive descent parser over the grammar:
+//
+//	expr       = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | comparison
+//	comparison = primary [ operator primary ]
+//	operator   = "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" | "not" "in"
+//	           | "matches" | "contains" | "startswith" | "endswith"
+//	primary    = literal | list | field [ "[" expr "]" ] | call | "(" expr ")"
+//	field      = ident { "." ident }
+//	call       = ident "(" [ expr { "," expr } ] ")"
+//	list       = "[" [ expr { "," expr } ] "]"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) (node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, errorf(tok.pos, "unexpected %s after end of expression (missing \"and\"/\"or\"?)", tok.describe())
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokenKeyword && tok.text == word
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, errorf(tok.pos, "expected %s, got %s", what, tok.describe())
+	}
+	return tok, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: op.pos, op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: op.pos, op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		op := p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{pos: op.pos, operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	op := ""
+	switch {
+	case tok.kind == tokenOperator || (tok.kind == tokenKeyword && comparisonOperators[tok.text]):
+		p.next()
+		op = tok.text
+	case tok.kind == tokenKeyword && tok.text == "not":
+		p.next()
+		if !p.isKeyword("in") {
+			return nil, errorf(tok.pos, "expected \"in\" after \"not\" (use \"not (a %s b)\" to negate other operators)", p.peek().text)
+		}
+		p.next()
+		op = "not in"
+	default:
+		return left, nil
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind == tokenOperator || (next.kind == tokenKeyword && comparisonOperators[next.text]) {
+		return nil, errorf(next.pos, "comparisons cannot be chained; combine them with \"and\"")
+	}
+	return &binaryNode{pos: tok.pos, op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return &literalNode{pos: tok.pos, value: tok.text}, nil
+	case tokenNumber:
+		return &literalNode{pos: tok.pos, value: tok.num}, nil
+	case tokenKeyword:
+		if tok.text == "true" || tok.text == "false" {
+			return &literalNode{pos: tok.pos, value: tok.text == "true"}, nil
+		}
+		return nil, errorf(tok.pos, "expected a value, got keyword %q", tok.text)
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "\")\""); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokenLBracket:
+		elements, err := p.parseList(tokenRBracket, "\"]\"")
+		if err != nil {
+			return nil, err
+		}
+		return &listNode{pos: tok.pos, elements: elements}, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLParen {
+			p.next()
+			args, err := p.parseList(tokenRParen, "\")\"")
+			if err != nil {
+				return nil, err
+			}
+			return &callNode{pos: tok.pos, name: tok.text, args: args}, nil
+		}
+		return p.parseField(tok)
+	default:
+		return nil, errorf(tok.pos, "expected a value, got %s", tok.describe())
+	}
+}
+
+func (p *parser) parseField(first token) (node, error) {
+	parts := []string{first.text}
+	for p.peek().kind == tokenDot {
+		p.next()
+		part, err := p.expect(tokenIdent, "a field name after \".\"")
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part.text)
+	}
+	var field node = &fieldNode{pos: first.pos, name: strings.Join(parts, ".")}
+
+	if p.peek().kind == tokenLBracket {
+		open := p.next()
+		key, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRBracket, "\"]\""); err != nil {
+			return nil, err
+		}
+		field = &indexNode{pos: open.pos, target: field, key: key}
+	}
+	return field, nil
+}
+
+// parseList parses comma-separated expressions up to the closing token
+func (p *parser) parseList(closing tokenKind, closingText string) ([]node, error) {
+	elements := make([]node, 0)
+	if p.peek().kind == closing {
+		p.next()
+		return elements, nil
+	}
+	for {
+		element, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+
+		tok := p.next()
+		if tok.kind == closing {
+			return elements, nil
+		}
+		if tok.kind != tokenComma {
+			return nil, errorf(tok.pos, "expected \",\" or %s, got %s", closingText, tok.describe())
+		}
+	}
+}
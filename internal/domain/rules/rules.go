@@ -0,0 +1,80 @@
+// Package rules implements the detection rule language
+//
+// Analysts write boolean expressions over the email, its recipient and the
+// detection context, for example:
+//
+//	sender.domain not in internal_domains
+//	  and subject matches "(?i)facture"
+//	  and recipient.role contains "finance"
+//
+// Rules are parsed and statically type-checked by Compile, so a rule that compiles
+// cannot fail at evaluation time. See Fields for the available fields; builtins are
+// lower, domain, len, similarity and max_similarity.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// Program is a compiled rule expression
+type Program struct {
+	source string
+	eval   evalFunc
+}
+
+// Compile parses and type-checks a rule expression
+// Errors are *Error values carrying the line and column of the problem.
+func Compile(source string) (*Program, error) {
+	tree, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	c, err := check(tree)
+	if err != nil {
+		return nil, err
+	}
+	if c.typ != TypeBool {
+		return nil, errorf(tree.position(), "rule must evaluate to a bool, got %s", c.typ)
+	}
+	return &Program{source: source, eval: c.eval}, nil
+}
+
+// Source returns the rule expression the program was compiled from
+func (p *Program) Source() string {
+	return p.source
+}
+
+// Eval reports whether the rule matches
+func (p *Program) Eval(env *Env) bool {
+	return p.eval(env).(bool)
+}
+
+// detectionTypePattern keeps custom types in the same style as built-in ones
+var detectionTypePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// CompiledRule is a stored rule with its compiled expression
+type CompiledRule struct {
+	Rule    domain.DetectionRule
+	Program *Program
+}
+
+// CompileRule validates a stored rule and compiles its expression
+func CompileRule(rule domain.DetectionRule) (*CompiledRule, error) {
+	if rule.Name == "" {
+		return nil, fmt.Errorf("rule name is required")
+	}
+	if !detectionTypePattern.MatchString(rule.DetectionType) {
+		return nil, fmt.Errorf("rule %q: detection type %q must be UPPER_SNAKE_CASE", rule.Name, rule.DetectionType)
+	}
+	if rule.Confidence <= 0 || rule.Confidence > 1 {
+		return nil, fmt.Errorf("rule %q: confidence must be in (0, 1], got %.2f", rule.Name, rule.Confidence)
+	}
+	program, err := Compile(rule.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+	}
+	return &CompiledRule{Rule: rule, Program: program}, nil
+}
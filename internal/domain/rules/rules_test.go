@@ -0,0 +1,166 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stoik/email-security/internal/domain"
+	"github.com/stoik/email-security/internal/domain/roles"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEnv() *Env {
+	return &Env{
+		Email: domain.Email{
+			Subject:         "Facture en retard - paiement urgent",
+			SenderEmail:     "compta@fournisseur-paiement.fr",
+			SenderName:      "Service Comptabilité",
+			RecipientEmail:  "alice@company.com",
+			BodyPreview:     "Merci de régler la facture ci-jointe aujourd'hui.",
+			HasAttachments:  true,
+			AttachmentNames: []string{"facture_2024.pdf", "details.xlsm"},
+			Headers: map[string]string{
+				"Reply-To":     "compta.urgent@gmail.com",
+				"Received-SPF": "softfail",
+			},
+		},
+		Recipient: &domain.User{
+			Email: "alice@company.com", DisplayName: "Alice", Role: "Finance Manager",
+			Department: "Finance", Groups: []string{"Finance", "Payments"},
+			Managers: []domain.UserRef{{Email: "cfo@company.com"}, {Email: "ceo@company.com"}},
+		},
+		Profile:         roles.Profile{Functions: []roles.Function{roles.Finance}, Seniority: roles.Manager},
+		InternalDomains: []string{"company.com"},
+		TrustedDomains:  []string{"microsoft.com", "paypal.com"},
+	}
+}
+
+func TestCompile_Eval(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want bool
+	}{
+		{"request example", `sender.domain not in internal_domains and subject matches "(?i)facture" and recipient.role contains "finance"`, true},
+		{"in list literal", `recipient.role in ["finance manager", "cfo"]`, true},
+		{"not in", `sender.domain not in ["fournisseur-paiement.fr"]`, false},
+		{"header index", `headers["Received-SPF"] == "softfail"`, true},
+		{"missing header is empty", `headers["X-Missing"] == ""`, true},
+		{"reply-to domain", `reply_to.domain in ["gmail.com", "yahoo.com"] and reply_to.domain != sender.domain`, true},
+		{"list endswith any", `attachments endswith ".xlsm"`, true},
+		{"list contains element", `attachments contains "details.xlsm"`, true},
+		{"string contains", `lower(body) contains "facture"`, true},
+		{"regex escape kept", `sender.email matches "^compta@.*\.fr$"`, true},
+		{"number comparison", `len(attachments) >= 2 and len(subject) < 100`, true},
+		{"similarity", `max_similarity(sender.domain, trusted_domains) > 0.85`, false},
+		{"function on field", `domain(reply_to) == "gmail.com"`, true},
+		{"precedence: and binds tighter than or", `false and false or true`, true},
+		{"parentheses", `false and (false or true)`, false},
+		{"not", `not recipient.known`, false},
+		{"language", `language == "fr"`, true},
+		{"group membership", `recipient.groups contains "finance" and recipient.department == "finance"`, true},
+		{"sender is a manager", `sender.email in recipient.managers or sender.email == recipient.manager`, false},
+		{"role taxonomy", `recipient.functions contains "finance" and recipient.seniority in ["manager", "director"] and not recipient.vip`, true},
+		{"direct manager", `recipient.manager == "cfo@company.com" and not recipient.disabled`, true},
+		{"multiline", "has_attachments\n  and sender.domain not in internal_domains", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.rule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, program.Eval(testEnv()))
+		})
+	}
+}
+
+func TestCompile_UnknownRecipient(t *testing.T) {
+	program, err := Compile(`recipient.known or recipient.role == ""`)
+	require.NoError(t, err)
+
+	env := testEnv()
+	env.Recipient = nil
+	assert.True(t, program.Eval(env))
+}
+
+func TestCompile_UnknownRecipientOrgChart(t *testing.T) {
+	program, err := Compile(`len(recipient.managers) == 0 and recipient.manager == "" and not recipient.disabled and recipient.department == ""`)
+	require.NoError(t, err)
+
+	env := testEnv()
+	env.Recipient = nil
+	assert.True(t, program.Eval(env))
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		column int
+		msg    string
+	}{
+		{"typo suggestion", `sender.domian == "x"`, 1, `unknown field "sender.domian" (did you mean "sender.domain"?)`},
+		{"not a bool", `subject`, 1, "rule must evaluate to a bool, got string"},
+		{"type mismatch", `len(subject) == "5"`, 14, "cannot compare number with string"},
+		{"in needs a list", `subject in "facture"`, 9, `"in" expects a list on the right, got string (use "contains" for substrings)`},
+		{"invalid regex", `subject matches "(unclosed"`, 17, "invalid regular expression"},
+		{"regex must be literal", `subject matches sender.name`, 17, "regular expression string literal"},
+		{"and needs bools", `subject and true`, 9, `"and" expects bool operands, got string and bool`},
+		{"unterminated string", `subject == "abc`, 12, "unterminated string"},
+		{"single equals", `subject = "abc"`, 9, `did you mean "=="?`},
+		{"chained comparison", `1 < 2 < 3`, 7, "cannot be chained"},
+		{"missing operator", `has_attachments has_attachments`, 17, "missing \"and\"/\"or\"?"},
+		{"wrong arity", `similarity(subject)`, 1, "similarity expects 2 argument(s), got 1"},
+		{"field called", `subject()`, 1, `"subject" is a field, not a function`},
+		{"function without call", `lower == "x"`, 1, `"lower" is a function`},
+		{"index non-map", `subject["x"] == ""`, 8, "cannot index string, only headers"},
+		{"list of numbers", `subject in [1, 2]`, 13, "list elements must be strings, got number"},
+		{"empty rule", ``, 1, "expected a value, got end of rule"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.rule)
+			require.Error(t, err)
+
+			var compileErr *Error
+			require.True(t, errors.As(err, &compileErr), "error should carry a position")
+			assert.Equal(t, tt.column, compileErr.Pos.Column)
+			assert.Contains(t, compileErr.Msg, tt.msg)
+		})
+	}
+}
+
+func TestCompileRule(t *testing.T) {
+	valid := domain.DetectionRule{
+		Name:          "Invoice from external sender",
+		Expression:    `subject matches "(?i)facture"`,
+		DetectionType: "CUSTOM_INVOICE",
+		Confidence:    0.8,
+	}
+
+	compiled, err := CompileRule(valid)
+	require.NoError(t, err)
+	assert.True(t, compiled.Program.Eval(testEnv()))
+
+	tests := []struct {
+		name   string
+		mutate func(r *domain.DetectionRule)
+		msg    string
+	}{
+		{"missing name", func(r *domain.DetectionRule) { r.Name = "" }, "name is required"},
+		{"bad type", func(r *domain.DetectionRule) { r.DetectionType = "custom invoice" }, "UPPER_SNAKE_CASE"},
+		{"bad confidence", func(r *domain.DetectionRule) { r.Confidence = 1.5 }, "confidence must be in (0, 1]"},
+		{"bad expression", func(r *domain.DetectionRule) { r.Expression = `subject ==` }, `rule "Invoice from external sender": 1:11`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.mutate(&rule)
+			_, err := CompileRule(rule)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.msg)
+		})
+	}
+}
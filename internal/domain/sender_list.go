@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sender list actions
+const (
+	SenderAllow = "allow" // Trust the sender: strategies still run, but do not score
+	SenderBlock = "block" // Flag the sender whatever the strategies find
+)
+
+// Sender list match types
+const (
+	SenderMatchAddress           = "address"            // Exact sender address
+	SenderMatchDomain            = "domain"             // Exact sender domain
+	SenderMatchRegistrableDomain = "registrable_domain" // A domain and all its subdomains ("vendor.co.uk")
+	SenderMatchIPCIDR            = "ip_cidr"            // Sending IP within a network ("203.0.113.0/24")
+)
+
+// SenderListEntry is a tenant's allow or block entry for senders (see package senderlist)
+// Matching entries override the strategies and are recorded in the analysis.
+type SenderListEntry struct {
+	ID        uuid.UUID  `json:"id"`
+	TenantID  uuid.UUID  `json:"tenant_id"`
+	Action    string     `json:"action"`     // SenderAllow or SenderBlock
+	MatchType string     `json:"match_type"` // e.g. SenderMatchDomain
+	Value     string     `json:"value"`      // Normalized: lowercase address or domain, canonical CIDR
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil never expires
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active reports whether the entry applies at a time
+func (e SenderListEntry) Active(at time.Time) bool {
+	return e.ExpiresAt == nil || at.Before(*e.ExpiresAt)
+}
+
+// Sender list audit operations
+const (
+	SenderListCreated = "created"
+	SenderListDeleted = "deleted"
+)
+
+// SenderListChange is an audit record of a sender list change
+// Records keep a copy of the entry, so they survive its deletion.
+type SenderListChange struct {
+	ID        uuid.UUID       `json:"id"`
+	TenantID  uuid.UUID       `json:"tenant_id"`
+	EntryID   uuid.UUID       `json:"entry_id"`
+	Operation string          `json:"operation"` // SenderListCreated or SenderListDeleted
+	Entry     SenderListEntry `json:"entry"`
+	Actor     string          `json:"actor"`
+	At        time.Time       `json:"at"`
+}
+
+// AnalysisOverride records the sender list entry that decided an analysis
+type AnalysisOverride struct {
+	EntryID   uuid.UUID `json:"entry_id"`
+	Action    string    `json:"action"`
+	MatchType string    `json:"match_type"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+
+	// StrategyScore is the risk score the strategies gave, before the override
+	StrategyScore float64 `json:"strategy_score"`
+}
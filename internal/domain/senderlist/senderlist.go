@@ -0,0 +1,351 @@
+// Package senderlist matches emails against a tenant's allow and block entries
+//
+// Entries target an exact address, an exact domain, a registrable domain (the
+// domain and all its subdomains) or the sending IP's network. A block entry wins
+// over an allow entry: a partner's domain can be allowed while one of its
+// compromised mailboxes is blocked. Address and domain allow entries only apply
+// when the receiving side authenticated the sender, so spoofing an allowed
+// partner does not turn detection off. Expired entries are ignored.
+package senderlist
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// specificity orders match types from the most to the least specific
+var specificity = []string{
+	domain.SenderMatchAddress,
+	domain.SenderMatchDomain,
+	domain.SenderMatchRegistrableDomain,
+	domain.SenderMatchIPCIDR,
+}
+
+// List is a tenant's sender list, indexed for matching
+// It is immutable and safe for concurrent use; Match is a no-op on nil.
+type List struct {
+	entries []domain.SenderListEntry
+	nets    map[int]*net.IPNet // Parsed networks of ip_cidr entries, by index
+}
+
+// New indexes a tenant's entries
+// Invalid entries are ignored: they are validated when stored.
+func New(entries []domain.SenderListEntry) *List {
+	list := &List{nets: make(map[int]*net.IPNet)}
+	for _, entry := range entries {
+		if Validate(entry) != nil {
+			continue
+		}
+		if entry.MatchType == domain.SenderMatchIPCIDR {
+			_, network, _ := net.ParseCIDR(entry.Value)
+			list.nets[len(list.entries)] = network
+		}
+		list.entries = append(list.entries, entry)
+	}
+	return list
+}
+
+// Len returns the number of entries
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.entries)
+}
+
+// Decision is how a sender list decides an email
+type Decision struct {
+	// Entry decides the email (nil if none)
+	Entry *domain.SenderListEntry
+	// Unauthenticated is an allow entry the sender's address or domain matched, ignored
+	// because the receiving side did not authenticate the sender (see Authenticated)
+	Unauthenticated *domain.SenderListEntry
+}
+
+// Match returns the entry deciding an email at a time (nil if none)
+func (l *List) Match(email domain.Email, at time.Time) *domain.SenderListEntry {
+	return l.Decide(email, at).Entry
+}
+
+// Decide returns the entry deciding an email at a time
+// Block entries win over allow entries; among entries with the same action, the
+// most specific match type wins. Address and domain allow entries only apply to
+// authenticated senders: the From header is the sender's to write, and a spoofed
+// partner address must not turn off detection.
+func (l *List) Decide(email domain.Email, at time.Time) Decision {
+	var decision Decision
+	if l == nil {
+		return decision
+	}
+	address := strings.ToLower(strings.TrimSpace(email.SenderEmail))
+	senderDomain := ""
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		senderDomain = address[i+1:]
+	}
+	ip := SenderIP(email)
+	authenticated := Authenticated(email)
+
+	for _, matchType := range specificity {
+		for i := range l.entries {
+			entry := &l.entries[i]
+			if entry.MatchType != matchType || !entry.Active(at) {
+				continue
+			}
+			var matched bool
+			switch matchType {
+			case domain.SenderMatchAddress:
+				matched = address == entry.Value
+			case domain.SenderMatchDomain:
+				matched = senderDomain != "" && senderDomain == entry.Value
+			case domain.SenderMatchRegistrableDomain:
+				matched = senderDomain != "" && RegistrableDomain(senderDomain) == entry.Value
+			case domain.SenderMatchIPCIDR:
+				matched = ip != nil && l.nets[i].Contains(ip)
+			}
+			if !matched {
+				continue
+			}
+			if entry.Action == domain.SenderBlock {
+				decision.Entry = entry
+				return decision
+			}
+			if matchType != domain.SenderMatchIPCIDR && !authenticated {
+				if decision.Unauthenticated == nil {
+					decision.Unauthenticated = entry
+				}
+				continue
+			}
+			if decision.Entry == nil {
+				decision.Entry = entry
+			}
+		}
+	}
+	return decision
+}
+
+// Authenticated reports whether the receiving side verified the sender's domain:
+// DMARC passed, or SPF or DKIM passed for the sender's registrable domain
+// Any DMARC failure, and mail without authentication results, is not authenticated.
+func Authenticated(email domain.Email) bool {
+	address := strings.ToLower(strings.TrimSpace(email.SenderEmail))
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return false
+	}
+	senderDomain := RegistrableDomain(address[i+1:])
+	aligned := func(value string) bool {
+		if at := strings.LastIndex(value, "@"); at >= 0 {
+			value = value[at+1:]
+		}
+		return value != "" && RegistrableDomain(value) == senderDomain
+	}
+
+	results := authResults(email.Headers["Authentication-Results"])
+	if results["dmarc"].result == "fail" {
+		return false
+	}
+	if results["dmarc"].result == "pass" {
+		return true
+	}
+	if dkim := results["dkim"]; dkim.result == "pass" && (aligned(dkim.props["header.d"]) || aligned(dkim.props["header.i"])) {
+		return true
+	}
+	if spf := results["spf"]; spf.result == "pass" && aligned(spf.props["smtp.mailfrom"]) {
+		return true
+	}
+
+	// Received-SPF: "pass (...) client-ip=...; envelope-from=billing@partner.com"
+	spf := strings.ToLower(strings.TrimSpace(email.Headers["Received-SPF"]))
+	if strings.HasPrefix(spf, "pass") {
+		if j := strings.Index(spf, "envelope-from="); j >= 0 {
+			value := spf[j+len("envelope-from="):]
+			if end := strings.IndexAny(value, "; "); end >= 0 {
+				value = value[:end]
+			}
+			return aligned(strings.Trim(value, `"<>`))
+		}
+	}
+	return false
+}
+
+// authResult is one method's result in an Authentication-Results header
+type authResult struct {
+	result string
+	props  map[string]string // e.g. header.d, smtp.mailfrom
+}
+
+// authResults parses an Authentication-Results header (RFC 8601) by method; the first
+// result of a method wins
+func authResults(header string) map[string]authResult {
+	results := make(map[string]authResult)
+	for _, segment := range strings.Split(strings.ToLower(header), ";") {
+		var method string
+		var result authResult
+		for _, field := range strings.Fields(segment) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if method == "" {
+				method, result = key, authResult{result: value, props: make(map[string]string)}
+				continue
+			}
+			result.props[key] = strings.Trim(value, `"<>()`)
+		}
+		if _, seen := results[method]; method != "" && !seen {
+			results[method] = result
+		}
+	}
+	return results
+}
+
+// Normalize puts an entry's value in the form it is matched in: lowercase address
+// or domain, registrable domain, canonical network (a bare IP is a /32 or /128)
+func Normalize(entry *domain.SenderListEntry) {
+	value := strings.ToLower(strings.TrimSpace(entry.Value))
+	switch entry.MatchType {
+	case domain.SenderMatchDomain:
+		value = strings.TrimPrefix(value, "@")
+	case domain.SenderMatchRegistrableDomain:
+		value = RegistrableDomain(strings.TrimPrefix(value, "@"))
+	case domain.SenderMatchIPCIDR:
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			value = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			value = network.String()
+		}
+	}
+	entry.Value = value
+}
+
+// Validate checks that an entry has a known action and match type, a value of the
+// match type's form and a reason
+func Validate(entry domain.SenderListEntry) error {
+	if entry.Action != domain.SenderAllow && entry.Action != domain.SenderBlock {
+		return fmt.Errorf("unknown sender list action %q", entry.Action)
+	}
+	if strings.TrimSpace(entry.Reason) == "" {
+		return fmt.Errorf("sender list entry needs a reason")
+	}
+
+	switch entry.MatchType {
+	case domain.SenderMatchAddress:
+		at := strings.LastIndex(entry.Value, "@")
+		if at <= 0 || !validDomain(entry.Value[at+1:]) {
+			return fmt.Errorf("invalid sender address %q", entry.Value)
+		}
+	case domain.SenderMatchDomain:
+		if !validDomain(entry.Value) {
+			return fmt.Errorf("invalid sender domain %q", entry.Value)
+		}
+	case domain.SenderMatchRegistrableDomain:
+		if !validDomain(entry.Value) || RegistrableDomain(entry.Value) != entry.Value || multiLabelSuffixes[entry.Value] {
+			return fmt.Errorf("%q is not a registrable domain", entry.Value)
+		}
+	case domain.SenderMatchIPCIDR:
+		if _, _, err := net.ParseCIDR(entry.Value); err != nil {
+			return fmt.Errorf("invalid IP network %q", entry.Value)
+		}
+	default:
+		return fmt.Errorf("unknown sender list match type %q", entry.MatchType)
+	}
+	return nil
+}
+
+// validDomain reports whether s looks like a lowercase domain name with a TLD
+func validDomain(s string) bool {
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// multiLabelSuffixes are the public suffixes of more than one label that registrable
+// domains are computed against; other domains register under their last label
+// In production, this would be the full Public Suffix List (publicsuffix.org).
+var multiLabelSuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true, "ltd.uk": true, "plc.uk": true, "me.uk": true,
+	"com.au": true, "net.au": true, "org.au": true,
+	"co.nz": true, "co.za": true, "co.jp": true, "co.in": true, "co.kr": true,
+	"com.br": true, "com.mx": true, "com.ar": true, "com.cn": true, "com.tr": true, "com.sg": true, "com.hk": true,
+	"gouv.fr": true, "asso.fr": true, "co.it": true, "com.es": true, "nom.es": true, "org.es": true,
+}
+
+// RegistrableDomain returns the domain a name was registered as: its public suffix
+// plus one label ("mail.vendor.co.uk" is "vendor.co.uk")
+// A public suffix itself is returned unchanged.
+func RegistrableDomain(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return name
+	}
+	suffixLabels := 1
+	if multiLabelSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+		suffixLabels = 2
+	}
+	if len(labels) <= suffixLabels {
+		return name
+	}
+	return strings.Join(labels[len(labels)-suffixLabels-1:], ".")
+}
+
+// SenderIP returns the sending server's IP as the receiving side saw it (nil if
+// unknown): the client-ip of Received-SPF, else the connecting IP of the topmost
+// Received header, added by the tenant's own MX
+// Headers the sender writes, like X-Originating-IP, are never read: a forged value
+// could match an allowed network, or frame a blocked one.
+func SenderIP(email domain.Email) net.IP {
+	spf := email.Headers["Received-SPF"]
+	if i := strings.Index(strings.ToLower(spf), "client-ip="); i >= 0 {
+		value := spf[i+len("client-ip="):]
+		if end := strings.IndexAny(value, "; "); end >= 0 {
+			value = value[:end]
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			return ip
+		}
+	}
+	return receivedIP(email.Headers["Received"])
+}
+
+// receivedIP returns the connecting IP of a Received header's from clause:
+// "from mail.partner.com (mail.partner.com [203.0.113.9]) by mx.company.com ..."
+func receivedIP(received string) net.IP {
+	from := strings.ToLower(received)
+	if i := strings.Index(from, " by "); i >= 0 {
+		from = from[:i]
+	}
+	if !strings.HasPrefix(strings.TrimSpace(from), "from ") {
+		return nil
+	}
+	open := strings.Index(from, "[")
+	if open < 0 {
+		return nil
+	}
+	end := strings.Index(from[open:], "]")
+	if end < 0 {
+		return nil
+	}
+	return net.ParseIP(strings.TrimPrefix(from[open+1:open+end], "ipv6:"))
+}
@@ -0,0 +1,172 @@
+package senderlist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+func entry(action, matchType, value string) domain.SenderListEntry {
+	e := domain.SenderListEntry{ID: uuid.New(), Action: action, MatchType: matchType, Value: value, Reason: "test"}
+	Normalize(&e)
+	return e
+}
+
+func TestList_Match(t *testing.T) {
+	now := time.Now()
+	expired := entry(domain.SenderBlock, domain.SenderMatchDomain, "old-partner.com")
+	yesterday := now.Add(-24 * time.Hour)
+	expired.ExpiresAt = &yesterday
+
+	list := New([]domain.SenderListEntry{
+		entry(domain.SenderAllow, domain.SenderMatchRegistrableDomain, "Partner.co.uk"),
+		entry(domain.SenderBlock, domain.SenderMatchAddress, "Compromised@Mail.Partner.co.uk"),
+		entry(domain.SenderBlock, domain.SenderMatchDomain, "@evil.com"),
+		entry(domain.SenderAllow, domain.SenderMatchIPCIDR, "203.0.113.0/24"),
+		entry(domain.SenderBlock, domain.SenderMatchIPCIDR, "198.51.100.7"),
+		expired,
+		{Action: "maybe", MatchType: domain.SenderMatchDomain, Value: "ignored.com", Reason: "invalid"},
+	})
+	assert.Equal(t, 6, list.Len())
+
+	tests := []struct {
+		name       string
+		email      domain.Email
+		wantAction string
+		wantValue  string
+	}{
+		{
+			"registrable domain covers subdomains",
+			domain.Email{SenderEmail: "billing@mail.partner.co.uk", Headers: map[string]string{"Authentication-Results": "mx.company.com; dmarc=pass header.from=mail.partner.co.uk"}},
+			domain.SenderAllow, "partner.co.uk",
+		},
+		{
+			"spoofed allowed domain",
+			domain.Email{SenderEmail: "billing@mail.partner.co.uk", Headers: map[string]string{"Authentication-Results": "mx.company.com; spf=fail smtp.mailfrom=evil.com; dkim=none; dmarc=fail header.from=mail.partner.co.uk"}},
+			"", "",
+		},
+		{"block wins over allow", domain.Email{SenderEmail: "compromised@mail.partner.co.uk"}, domain.SenderBlock, "compromised@mail.partner.co.uk"},
+		{"exact domain", domain.Email{SenderEmail: "ceo@EVIL.com"}, domain.SenderBlock, "evil.com"},
+		{"exact domain excludes subdomains", domain.Email{SenderEmail: "ceo@mail.evil.com"}, "", ""},
+		{"other registrable domain", domain.Email{SenderEmail: "a@otherpartner.co.uk"}, "", ""},
+		{
+			"forged originating IP",
+			domain.Email{SenderEmail: "x@unknown.com", Headers: map[string]string{"X-Originating-IP": "[203.0.113.42]"}},
+			"", "",
+		},
+		{
+			"received by the tenant's MX",
+			domain.Email{SenderEmail: "x@unknown.com", Headers: map[string]string{
+				"Received":         "from mail.unknown.com (mail.unknown.com [203.0.113.42]) by mx.company.com with ESMTPS id 4f2a; Tue, 6 Oct 2026 09:12:44 +0000",
+				"X-Originating-IP": "[198.51.100.7]",
+			}},
+			domain.SenderAllow, "203.0.113.0/24",
+		},
+		{
+			"SPF client IP",
+			domain.Email{SenderEmail: "x@unknown.com", Headers: map[string]string{"Received-SPF": "pass (domain of unknown.com designates 198.51.100.7 as permitted sender) client-ip=198.51.100.7; envelope-from=x@unknown.com"}},
+			domain.SenderBlock, "198.51.100.7/32",
+		},
+		{"expired entry", domain.Email{SenderEmail: "x@old-partner.com"}, "", ""},
+		{"invalid entry", domain.Email{SenderEmail: "x@ignored.com"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched := list.Match(tt.email, now)
+			if tt.wantAction == "" {
+				assert.Nil(t, matched)
+				return
+			}
+			require.NotNil(t, matched)
+			assert.Equal(t, tt.wantAction, matched.Action)
+			assert.Equal(t, tt.wantValue, matched.Value)
+		})
+	}
+
+	assert.NotNil(t, list.Match(domain.Email{SenderEmail: "x@old-partner.com"}, yesterday.Add(-time.Hour)), "active before expiry")
+	var none *List
+	assert.Nil(t, none.Match(domain.Email{SenderEmail: "ceo@evil.com"}, now))
+
+	// The spoofed partner is blocked whatever its authentication, and reported as ignored when allowed
+	decision := list.Decide(domain.Email{SenderEmail: "billing@partner.co.uk"}, now)
+	assert.Nil(t, decision.Entry)
+	require.NotNil(t, decision.Unauthenticated)
+	assert.Equal(t, "partner.co.uk", decision.Unauthenticated.Value)
+}
+
+func TestAuthenticated(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no results", nil, false},
+		{"DMARC pass", map[string]string{"Authentication-Results": "mx.company.com; spf=pass smtp.mailfrom=bounce.esp.com; dmarc=pass header.from=partner.com"}, true},
+		{"DMARC fail wins", map[string]string{"Authentication-Results": "mx.company.com; dkim=pass header.d=partner.com; dmarc=fail"}, false},
+		{"aligned DKIM", map[string]string{"Authentication-Results": "mx.company.com; dkim=pass (2048-bit key) header.d=mail.partner.com header.s=s1"}, true},
+		{"DKIM of another domain", map[string]string{"Authentication-Results": "mx.company.com; dkim=pass header.d=esp.com"}, false},
+		{"aligned SPF", map[string]string{"Authentication-Results": "mx.company.com; spf=pass smtp.mailfrom=bounces@partner.com"}, true},
+		{"SPF of another domain", map[string]string{"Authentication-Results": "mx.company.com; spf=pass smtp.mailfrom=evil.com"}, false},
+		{"Received-SPF pass", map[string]string{"Received-SPF": "pass (mx.company.com: domain of ap@partner.com designates 203.0.113.9 as permitted sender) client-ip=203.0.113.9; envelope-from=ap@partner.com"}, true},
+		{"Received-SPF softfail", map[string]string{"Received-SPF": "softfail client-ip=203.0.113.9; envelope-from=ap@partner.com"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Authenticated(domain.Email{SenderEmail: "ap@partner.com", Headers: tt.headers}))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   domain.SenderListEntry
+		wantErr string
+	}{
+		{"address", entry(domain.SenderAllow, domain.SenderMatchAddress, "ap@vendor.com"), ""},
+		{"IPv6 network", entry(domain.SenderBlock, domain.SenderMatchIPCIDR, "2001:db8::/32"), ""},
+		{"unknown action", entry("trust", domain.SenderMatchDomain, "vendor.com"), "unknown sender list action"},
+		{"unknown match type", entry(domain.SenderAllow, "regex", ".*"), "unknown sender list match type"},
+		{"no reason", domain.SenderListEntry{Action: domain.SenderAllow, MatchType: domain.SenderMatchDomain, Value: "vendor.com"}, "reason"},
+		{"address without domain", entry(domain.SenderAllow, domain.SenderMatchAddress, "ap@"), "invalid sender address"},
+		{"domain without TLD", entry(domain.SenderBlock, domain.SenderMatchDomain, "localhost"), "invalid sender domain"},
+		{"public suffix", domain.SenderListEntry{Action: domain.SenderBlock, MatchType: domain.SenderMatchRegistrableDomain, Value: "co.uk", Reason: "test"}, "not a registrable domain"},
+		{"subdomain", domain.SenderListEntry{Action: domain.SenderBlock, MatchType: domain.SenderMatchRegistrableDomain, Value: "mail.vendor.com", Reason: "test"}, "not a registrable domain"},
+		{"bad network", entry(domain.SenderBlock, domain.SenderMatchIPCIDR, "300.1.1.0/24"), "invalid IP network"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.entry)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := map[string]string{
+		"vendor.com":            "vendor.com",
+		"mail.eu.vendor.com":    "vendor.com",
+		"mail.vendor.co.uk":     "vendor.co.uk",
+		"Vendor.COM.":           "vendor.com",
+		"co.uk":                 "co.uk",
+		"localhost":             "localhost",
+		"factures.exemple.fr":   "exemple.fr",
+		"impots.gouv.fr":        "impots.gouv.fr",
+		"secure.impots.gouv.fr": "impots.gouv.fr",
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, RegistrableDomain(name), name)
+	}
+}
@@ -0,0 +1,59 @@
+package domain
+
+import "time"
+
+// AnalysisTrace records what every detection strategy saw while analyzing an email
+//
+// Only produced when tracing is enabled on the Detector. It answers "why wasn't this
+// flagged?": unlike DetectedThreats it also covers strategies that did not fire.
+type AnalysisTrace struct {
+	Strategies []StrategyTrace `json:"strategies"`
+	Elapsed    time.Duration   `json:"elapsed_ns"`
+}
+
+// StrategyTrace records a single strategy run
+//
+// Values holds the intermediate results the strategy computed (similarity scores,
+// keyword hits, role classification, auth results...) and Thresholds the values
+// they were compared against, keyed by the same names.
+type StrategyTrace struct {
+	Strategy      string                 `json:"strategy"`
+	Ran           bool                   `json:"ran"`
+	SkipReason    string                 `json:"skip_reason,omitempty"`
+	Fired         bool                   `json:"fired"`
+	DetectionType string                 `json:"detection_type,omitempty"`
+	Values        map[string]interface{} `json:"values,omitempty"`
+	Thresholds    map[string]float64     `json:"thresholds,omitempty"`
+	Elapsed       time.Duration          `json:"elapsed_ns"`
+}
+
+// Record stores an intermediate value; safe to call on a nil trace (tracing disabled)
+func (t *StrategyTrace) Record(key string, value interface{}) {
+	if t == nil {
+		return
+	}
+	if t.Values == nil {
+		t.Values = make(map[string]interface{})
+	}
+	t.Values[key] = value
+}
+
+// Threshold stores the threshold a value is compared against; safe on a nil trace
+func (t *StrategyTrace) Threshold(key string, value float64) {
+	if t == nil {
+		return
+	}
+	if t.Thresholds == nil {
+		t.Thresholds = make(map[string]float64)
+	}
+	t.Thresholds[key] = value
+}
+
+// Skip records that the strategy did not apply to the email; safe on a nil trace
+func (t *StrategyTrace) Skip(reason string) {
+	if t == nil {
+		return
+	}
+	t.Ran = false
+	t.SkipReason = reason
+}
@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VIP is a tenant's high-value user, protected whatever their job title
+// Mail to a VIP is scored with raised confidences and the tenant's VIP risk thresholds,
+// and external mail using a VIP's name (display name or signature) is flagged.
+type VIP struct {
+	ID        uuid.UUID `json:"id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Email     string    `json:"email"`             // The VIP's mailbox, stored lowercase
+	Name      string    `json:"name"`              // e.g. "John Smith"; external mail must not use it
+	Aliases   []string  `json:"aliases,omitempty"` // Other names the VIP goes by, e.g. "Johnny Smith"
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Names returns the VIP's protected names
+func (v VIP) Names() []string {
+	return append([]string{v.Name}, v.Aliases...)
+}
@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = "pending"   // Waiting for its first or next attempt
+	WebhookDelivered = "delivered" // Receiver answered 2xx
+	WebhookDead      = "dead"      // Failed every attempt; kept for investigation and manual replay
+)
+
+// WebhookSubscription sends a tenant's events to an endpoint of theirs
+type WebhookSubscription struct {
+	ID       uuid.UUID `json:"id"`
+	TenantID uuid.UUID `json:"tenant_id"`
+	URL      string    `json:"url"`
+
+	// Secret signs payloads (HMAC-SHA256); shown once at creation, never serialized afterwards
+	Secret string `json:"-"`
+
+	EventTypes   []string  `json:"event_types"`              // e.g. ["threat.detected"]
+	MinRiskLevel string    `json:"min_risk_level,omitempty"` // Events below it are not sent; empty sends all
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// webhookEventTypes are the event types a subscription can receive
+var webhookEventTypes = map[string]bool{
+	EventEmailIngested:     true,
+	EventAnalysisCompleted: true,
+	EventThreatDetected:    true,
+}
+
+// Validate checks the subscription's endpoint and filters
+// Endpoints must use HTTPS, except on localhost for development.
+func (s WebhookSubscription) Validate() error {
+	endpoint, err := url.Parse(s.URL)
+	if err != nil || endpoint.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", s.URL)
+	}
+	local := endpoint.Hostname() == "localhost" || endpoint.Hostname() == "127.0.0.1"
+	if endpoint.Scheme != "https" && !(endpoint.Scheme == "http" && local) {
+		return fmt.Errorf("webhook URL %q must use https", s.URL)
+	}
+	if len(s.EventTypes) == 0 {
+		return fmt.Errorf("webhook subscription needs at least one event type")
+	}
+	for _, eventType := range s.EventTypes {
+		if !webhookEventTypes[eventType] {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	if _, ok := riskLevelRank[s.MinRiskLevel]; s.MinRiskLevel != "" && !ok {
+		return fmt.Errorf("unknown risk level %q", s.MinRiskLevel)
+	}
+	return nil
+}
+
+// Matches reports whether an event should be delivered to the subscription
+// Events without a risk level (email.ingested) only match subscriptions without a minimum.
+func (s WebhookSubscription) Matches(event Event) bool {
+	if !s.Enabled {
+		return false
+	}
+	subscribed := false
+	for _, eventType := range s.EventTypes {
+		subscribed = subscribed || eventType == event.Type
+	}
+	if !subscribed {
+		return false
+	}
+	if s.MinRiskLevel == "" {
+		return true
+	}
+	level := event.RiskLevel()
+	return level != "" && RiskLevelAtLeast(level, s.MinRiskLevel)
+}
+
+// WebhookDelivery is one event to deliver to one subscription, with its delivery state
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	TenantID       uuid.UUID       `json:"tenant_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	DedupKey       string          `json:"dedup_key"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Body returns the JSON document posted to the subscriber
+// id identifies the delivery (stable across retries) and data is the event payload.
+func (d WebhookDelivery) Body() ([]byte, error) {
+	return json.Marshal(struct {
+		ID        uuid.UUID       `json:"id"`
+		EventID   uuid.UUID       `json:"event_id"`
+		Type      string          `json:"type"`
+		TenantID  uuid.UUID       `json:"tenant_id"`
+		CreatedAt time.Time       `json:"created_at"`
+		Data      json.RawMessage `json:"data"`
+	}{d.ID, d.EventID, d.EventType, d.TenantID, d.CreatedAt, d.Payload})
+}
+
+// WebhookAttempt is one HTTP request of a delivery, for the delivery log
+type WebhookAttempt struct {
+	DeliveryID  uuid.UUID     `json:"delivery_id"`
+	Attempt     int           `json:"attempt"`
+	StatusCode  int           `json:"status_code,omitempty"` // 0 when no response was received
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"duration"`
+	AttemptedAt time.Time     `json:"attempted_at"`
+}
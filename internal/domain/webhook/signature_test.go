@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign_Verify(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"type":"threat.detected"}`)
+	signedAt := time.Unix(1700000000, 0)
+	header := Sign(secret, signedAt, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   string
+		now    time.Time
+		want   error
+	}{
+		{"valid", secret, header, string(body), signedAt.Add(time.Minute), nil},
+		{"tampered body", secret, header, `{"type":"analysis.completed"}`, signedAt, ErrSignatureMismatch},
+		{"wrong secret", "whsec_other", header, string(body), signedAt, ErrSignatureMismatch},
+		{"replayed later", secret, header, string(body), signedAt.Add(DefaultTolerance + time.Second), ErrStaleTimestamp},
+		{"clock ahead", secret, header, string(body), signedAt.Add(-DefaultTolerance - time.Second), ErrStaleTimestamp},
+		{"rotated secret", secret, Sign("whsec_old", signedAt, body) + "," + header[len("t=1700000000,"):], string(body), signedAt, nil},
+		{"unknown scheme ignored", secret, header + ",v0=abc", string(body), signedAt, nil},
+		{"missing timestamp", secret, "v1=abcd", string(body), signedAt, ErrMalformedHeader},
+		{"missing signature", secret, "t=1700000000", string(body), signedAt, ErrMalformedHeader},
+		{"not hex", secret, "t=1700000000,v1=xyz", string(body), signedAt, ErrMalformedHeader},
+		{"garbage", secret, "signature", string(body), signedAt, ErrMalformedHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, []byte(tt.body), tt.now, DefaultTolerance)
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+func TestSign_Format(t *testing.T) {
+	header := Sign("secret", time.Unix(1700000000, 0), []byte("body"))
+	assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, header)
+}
@@ -15,5 +15,81 @@ type EmailProvider interface {
 
 	// GetEmails fetches emails for a specific user within a date range
 	// receivedAfter is used to implement incremental sync (only fetch new emails since last run)
-	GetEmails(ctx context.Context, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error)
+	// Failures wrap the domain provider errors (domain.ErrThrottled, domain.ErrNotFound, ...).
+	GetEmails(ctx context.Context, tenantID, userID uuid.UUID, receivedAfter time.Time) ([]domain.Email, error)
+}
+
+// TokenExchanger defines the contract for provider OAuth token endpoints
+type TokenExchanger interface {
+	// Exchange obtains an access token with a tenant's credentials
+	// Revoked consent or invalid credentials wrap domain.ErrAuthExpired.
+	Exchange(ctx context.Context, provider domain.Provider, creds domain.TenantCredentials) (domain.AccessToken, error)
+	// ExchangeCode redeems a consent authorization code, returning a token with a refresh token
+	ExchangeCode(ctx context.Context, provider domain.Provider, app domain.OAuthApp, code, redirectURL string) (domain.AccessToken, error)
+	// Revoke withdraws the grant of a tenant's credentials where the provider allows it
+	Revoke(ctx context.Context, provider domain.Provider, creds domain.TenantCredentials) error
+}
+
+// TokenSource defines the contract for obtaining a tenant's provider access token
+type TokenSource interface {
+	// Token returns a valid access token; fails with domain.ErrAuthExpired while the
+	// tenant must reauthorize
+	Token(ctx context.Context, tenantID uuid.UUID) (string, error)
+}
+
+// KeyManager defines the contract for a key management service holding master keys
+type KeyManager interface {
+	// ActiveKeyID returns the ID of the master key WrapKey uses
+	ActiveKeyID(ctx context.Context) (string, error)
+	// WrapKey encrypts a data key with the active master key, returning that key's ID
+	WrapKey(ctx context.Context, dataKey []byte) (masterKeyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped with a (possibly retired) master key
+	UnwrapKey(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error)
+}
+
+// SecretStore defines the contract for encrypting tenant secrets at rest
+type SecretStore interface {
+	// Seal returns the stored form of a tenant secret
+	Seal(ctx context.Context, tenantID uuid.UUID, plaintext string) (string, error)
+	// Open returns a tenant secret from its stored form
+	Open(ctx context.Context, tenantID uuid.UUID, sealed string) (string, error)
+}
+
+// MailboxRemediator defines the contract for acting on messages in a provider mailbox
+// Every action can be undone: Apply returns the state Restore needs, which callers
+// store with the audit record.
+type MailboxRemediator interface {
+	// Apply performs a remediation action (domain.Remediation*) on a message
+	// label is the category or label name for the label action. current is the message
+	// later actions must target: the bannered copy after a banner, message otherwise.
+	Apply(ctx context.Context, message domain.MessageRef, action, label string) (restoreState string, current domain.MessageRef, err error)
+
+	// Restore undoes an action applied earlier
+	Restore(ctx context.Context, message domain.MessageRef, action, label, restoreState string) error
+}
+
+// MailSubscriber defines the contract for push notifications on provider mailboxes
+// (Microsoft Graph subscriptions, Gmail watch through Pub/Sub)
+type MailSubscriber interface {
+	// Subscribe starts notifications for new mail in a mailbox
+	// The returned subscription has ExternalID, ExpiresAt and, for Gmail, Cursor set;
+	// clientState is echoed back by Graph in every notification.
+	Subscribe(ctx context.Context, mailbox, clientState string) (domain.MailSubscription, error)
+
+	// Renew extends a subscription and returns its new expiry
+	Renew(ctx context.Context, sub domain.MailSubscription) (time.Time, error)
+
+	// Unsubscribe stops a subscription's notifications
+	Unsubscribe(ctx context.Context, sub domain.MailSubscription) error
+
+	// FetchChanges fetches the new messages a notification is about, and the
+	// subscription's cursor after them
+	FetchChanges(ctx context.Context, sub domain.MailSubscription, change domain.MailChange) (emails []domain.Email, cursor string, err error)
+}
+
+// MailChangeHandler defines the contract for handling received push notifications
+// HandleMailChange returns domain.ErrUnknownSubscription or domain.ErrClientStateMismatch
+// for notifications to discard; other errors ask the provider to send it again.
+type MailChangeHandler interface {
+	HandleMailChange(ctx context.Context, change domain.MailChange) error
 }
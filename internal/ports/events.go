@@ -0,0 +1,41 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// EventSink defines the contract for delivering outbox events to a consumer
+// Delivery is at-least-once: Publish may be called again with an event it already
+// accepted, so sinks (or their consumers) deduplicate on Event.DedupKey.
+type EventSink interface {
+	// Name identifies the sink in logs and delivery errors
+	Name() string
+
+	// Publish delivers one event; an error schedules a retry
+	Publish(ctx context.Context, event domain.Event) error
+}
+
+// MessagePublisher is the subset of a message broker client the broker sink needs
+// NATS JetStream, Kafka and most brokers can implement it in a few lines: subject is
+// the topic or subject, key the partition key, and headers carry metadata such as
+// the dedup key (e.g. Nats-Msg-Id for JetStream's duplicate window).
+type MessagePublisher interface {
+	Publish(ctx context.Context, subject string, key []byte, payload []byte, headers map[string]string) error
+}
+
+// WebhookSender defines the contract for posting a signed webhook request
+// statusCode is 0 when no response was received (err then says why).
+type WebhookSender interface {
+	Send(ctx context.Context, url string, body []byte, headers map[string]string) (statusCode int, err error)
+}
+
+// Notifier defines the contract for sending a notification over one channel
+// (Slack, Teams, email); target is the channel's address taken from the route
+type Notifier interface {
+	// Channel returns the channel name matched against NotificationRoute.Channel
+	Channel() string
+
+	Send(ctx context.Context, target string, notification domain.Notification) error
+}
@@ -0,0 +1,15 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// EmailHunter defines the contract for searching stored emails for a reported campaign
+// Invalid queries fail with domain.ErrInvalidHunt before any match is streamed.
+type EmailHunter interface {
+	// Hunt calls fn with each email matching the query, newest first, and reports how
+	// far the campaign spread; an error from fn stops the hunt and is returned
+	Hunt(ctx context.Context, query domain.HuntQuery, fn func(domain.HuntMatch) error) (domain.HuntReport, error)
+}
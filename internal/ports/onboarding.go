@@ -0,0 +1,24 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/stoik/email-security/internal/domain"
+)
+
+// TenantOnboarding defines the contract for managing the tenant lifecycle
+// Operations not allowed in the tenant's status fail with domain.ErrInvalidTransition,
+// operations on unknown tenants with domain.ErrTenantNotFound.
+type TenantOnboarding interface {
+	// StartOnboarding creates a tenant pending consent and returns its consent URL
+	StartOnboarding(ctx context.Context, tenant *domain.Tenant) (string, error)
+	// ConsentURL returns a new consent URL for a tenant pending consent or reauthorization
+	ConsentURL(ctx context.Context, tenantID uuid.UUID) (string, error)
+	// CompleteConsent stores the credentials granted by a consent callback and activates the tenant
+	CompleteConsent(ctx context.Context, callback domain.ConsentCallback) (*domain.Tenant, error)
+	Suspend(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error)
+	Resume(ctx context.Context, tenantID uuid.UUID) (*domain.Tenant, error)
+	// Offboard revokes the tenant's grant and deletes it with all of its data
+	Offboard(ctx context.Context, tenantID uuid.UUID) error
+}
@@ -2,6 +2,7 @@ package ports
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stoik/email-security/internal/domain"
@@ -12,20 +13,171 @@ type Storage interface {
 	// Tenant operations
 	CreateTenant(ctx context.Context, tenant *domain.Tenant) error
 	GetTenant(ctx context.Context, id uuid.UUID) (*domain.Tenant, error)
+	// UpdateTenantCredentials replaces a tenant's stored credentials and moves it from
+	// status from to status to; it fails with domain.ErrInvalidTransition if the tenant
+	// is no longer in status from
+	UpdateTenantCredentials(ctx context.Context, tenantID uuid.UUID, credentials, from, to string) error
+	// UpdateTenantStatus moves a tenant from status from to status to; it fails with
+	// domain.ErrInvalidTransition if the tenant is no longer in status from
+	UpdateTenantStatus(ctx context.Context, tenantID uuid.UUID, from, to string) error
+	// UpdateTenantVIPRiskThresholds sets the risk thresholds of mail to a tenant's VIPs (nil for the defaults)
+	UpdateTenantVIPRiskThresholds(ctx context.Context, tenantID uuid.UUID, thresholds *domain.RiskThresholds) error
+	// DeleteTenant deletes a tenant and, by cascade, all of its data
+	DeleteTenant(ctx context.Context, tenantID uuid.UUID) error
 
 	// User operations
+	// CreateUser upserts a user by provider user ID and sets user.ID to the stored ID;
+	// a departed user is restored
 	CreateUser(ctx context.Context, user *domain.User) error
+	// UpdateUserManagers sets the managers of a tenant's users, keyed by user ID (nil clears)
+	UpdateUserManagers(ctx context.Context, tenantID uuid.UUID, managers map[uuid.UUID]*uuid.UUID) error
+	// MarkUsersDeparted soft-deletes the tenant's users not synced since syncedBefore
+	MarkUsersDeparted(ctx context.Context, tenantID uuid.UUID, syncedBefore time.Time) (int, error)
+	// GetUserByEmail and GetUsersByEmails return departed users too, with their managers
 	GetUserByEmail(ctx context.Context, tenantID uuid.UUID, email string) (*domain.User, error)
+	// GetUsersByEmails looks up many recipients in one round trip, keyed by address
+	GetUsersByEmails(ctx context.Context, tenantID uuid.UUID, emails []string) (map[string]*domain.User, error)
+	// ListUsers returns the users still in the tenant's directory
+	ListUsers(ctx context.Context, tenantID uuid.UUID) ([]domain.User, error)
 
 	// Email operations
 	CreateEmail(ctx context.Context, email *domain.Email) error
 	GetEmail(ctx context.Context, id uuid.UUID) (*domain.Email, error)
-	GetUnprocessedEmails(ctx context.Context, limit int) ([]domain.Email, error)
+	GetUnprocessedEmails(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.Email, error)
 	MarkEmailProcessed(ctx context.Context, emailID uuid.UUID) error
 
+	// Email processing leases: safe for concurrent detection workers
+	// ClaimUnprocessedEmails leases up to limit of a tenant's unprocessed emails to owner
+	// (skipping other workers' live leases; expired leases are claimable again)
+	ClaimUnprocessedEmails(ctx context.Context, tenantID uuid.UUID, owner string, limit int, lease time.Duration) ([]domain.Email, error)
+	// ReleaseEmails gives up owner's leases without processing the emails
+	ReleaseEmails(ctx context.Context, owner string, emailIDs []uuid.UUID) error
+	// CompleteEmails stores analyses as current and marks their emails processed, atomically,
+	// for the emails still leased to owner; returns the number stored
+	CompleteEmails(ctx context.Context, owner string, analyses []domain.FraudAnalysis) (int, error)
+	// GetBacklog reports pending emails and detection lag for every tenant that has some
+	GetBacklog(ctx context.Context) ([]domain.TenantBacklog, error)
+
 	// Fraud analysis operations
+	// CreateFraudAnalysis stores an analysis as the email's current one (each email has at most one)
+	// and sets its ID
 	CreateFraudAnalysis(ctx context.Context, analysis *domain.FraudAnalysis) error
 	GetHighRiskEmails(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.FraudAnalysis, error)
+	// GetFraudAnalysisByEmail returns the current analysis of an email with its trace (nil if never analyzed)
+	GetFraudAnalysisByEmail(ctx context.Context, emailID uuid.UUID) (*domain.FraudAnalysis, error)
+
+	// Reprocessing operations (re-running a detector over stored emails)
+	// ListProcessedEmails returns up to limit of a tenant's analyzed emails received after
+	// a cursor and before to, oldest first
+	ListProcessedEmails(ctx context.Context, tenantID uuid.UUID, after domain.EmailCursor, to time.Time, limit int) ([]domain.Email, error)
+	// GetCurrentAnalyses returns the current analyses of emails by email ID; emails never analyzed are absent
+	GetCurrentAnalyses(ctx context.Context, emailIDs []uuid.UUID) (map[uuid.UUID]domain.FraudAnalysis, error)
+	// StoreVersionedAnalyses stores analyses as history, or as current if promote,
+	// without writing events, and sets their IDs (reprocessing and shadow detection)
+	StoreVersionedAnalyses(ctx context.Context, analyses []domain.FraudAnalysis, promote bool) error
+	// ListAnalysesByVersion returns the latest analysis by a detector version of each of a
+	// tenant's emails received in [from, to), by email ID
+	ListAnalysesByVersion(ctx context.Context, tenantID uuid.UUID, detectorVersion string, from, to time.Time) (map[uuid.UUID]domain.FraudAnalysis, error)
+
+	// Retro-hunt operations (searching stored emails for a reported campaign)
+	// HuntEmails returns up to limit emails the query may match, with their tenant name and
+	// current risk, received before a cursor (a zero cursor starts from the newest), newest
+	// first. Candidates come from indexes; callers confirm them with hunt.Matches.
+	HuntEmails(ctx context.Context, query domain.HuntQuery, before domain.EmailCursor, limit int) ([]domain.HuntMatch, error)
+
+	// Outbox operations (events are written by CreateEmail, CreateFraudAnalysis and
+	// CompleteEmails in the same transaction as the change they describe)
+	// ClaimOutboxEvents leases up to limit due, undelivered events to owner; claiming counts as an attempt
+	ClaimOutboxEvents(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.Event, error)
+	MarkOutboxEventsPublished(ctx context.Context, owner string, eventIDs []uuid.UUID) error
+	// RetryOutboxEvent releases a failed event, to be claimed again after delay
+	RetryOutboxEvent(ctx context.Context, owner string, eventID uuid.UUID, delay time.Duration, lastErr string) error
+	// DeadLetterOutboxEvent stops retrying an event, keeping it for investigation
+	DeadLetterOutboxEvent(ctx context.Context, owner string, eventID uuid.UUID, lastErr string) error
+
+	// Custom detection rule operations (per tenant)
+	CreateDetectionRule(ctx context.Context, rule *domain.DetectionRule) error
+	UpdateDetectionRule(ctx context.Context, rule *domain.DetectionRule) error
+	DeleteDetectionRule(ctx context.Context, tenantID, ruleID uuid.UUID) error
+	ListDetectionRules(ctx context.Context, tenantID uuid.UUID) ([]domain.DetectionRule, error)
+
+	// Role override operations (per tenant, see package roles)
+	// UpsertRoleOverride creates the override of a title or user, or replaces the existing one
+	UpsertRoleOverride(ctx context.Context, override *domain.RoleOverride) error
+	DeleteRoleOverride(ctx context.Context, tenantID, overrideID uuid.UUID) error
+	ListRoleOverrides(ctx context.Context, tenantID uuid.UUID) ([]domain.RoleOverride, error)
+
+	// VIP list operations (per tenant)
+	// UpsertVIP adds a VIP, or replaces the tenant's VIP with the same address
+	UpsertVIP(ctx context.Context, vip *domain.VIP) error
+	DeleteVIP(ctx context.Context, tenantID, vipID uuid.UUID) error
+	ListVIPs(ctx context.Context, tenantID uuid.UUID) ([]domain.VIP, error)
+
+	// Sender list operations (per tenant allow/block entries, with an audit trail)
+	// CreateSenderListEntry stores an entry and records its creation by entry.CreatedBy
+	CreateSenderListEntry(ctx context.Context, entry *domain.SenderListEntry) error
+	// DeleteSenderListEntry removes an entry and records its deletion by actor
+	DeleteSenderListEntry(ctx context.Context, tenantID, entryID uuid.UUID, actor string) error
+	// ListSenderListEntries returns a tenant's entries, expired ones included
+	ListSenderListEntries(ctx context.Context, tenantID uuid.UUID) ([]domain.SenderListEntry, error)
+	// ListSenderListChanges returns a tenant's latest audit records, newest first
+	ListSenderListChanges(ctx context.Context, tenantID uuid.UUID, limit int) ([]domain.SenderListChange, error)
+
+	// Webhook operations (subscriptions and their delivery log, per tenant)
+	CreateWebhookSubscription(ctx context.Context, sub *domain.WebhookSubscription) error
+	// ListWebhookSubscriptions returns a tenant's subscriptions with their sealed secrets
+	ListWebhookSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.WebhookSubscription, error)
+	// UpdateWebhookSubscriptionSecret replaces a subscription's sealed secret
+	UpdateWebhookSubscriptionSecret(ctx context.Context, tenantID, subscriptionID uuid.UUID, secret string) error
+	DeleteWebhookSubscription(ctx context.Context, tenantID, subscriptionID uuid.UUID) error
+	// EnqueueWebhookDeliveries stores pending deliveries, skipping events already enqueued for a subscription
+	EnqueueWebhookDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error
+	// ClaimWebhookDeliveries leases up to limit due, pending deliveries to owner; claiming counts as an attempt
+	ClaimWebhookDeliveries(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.WebhookDelivery, error)
+	// RecordWebhookAttempt logs an attempt and moves its delivery (still leased to owner) to status,
+	// due again after retryIn when pending
+	RecordWebhookAttempt(ctx context.Context, owner string, attempt domain.WebhookAttempt, status string, retryIn time.Duration) error
+	// ListWebhookDeliveries returns the latest deliveries of a subscription, newest first
+	ListWebhookDeliveries(ctx context.Context, tenantID, subscriptionID uuid.UUID, limit int) ([]domain.WebhookDelivery, error)
+	ListWebhookAttempts(ctx context.Context, tenantID, deliveryID uuid.UUID) ([]domain.WebhookAttempt, error)
+
+	// Notification route operations (per tenant)
+	CreateNotificationRoute(ctx context.Context, route *domain.NotificationRoute) error
+	// ListNotificationRoutes returns a tenant's routes with their sealed targets
+	ListNotificationRoutes(ctx context.Context, tenantID uuid.UUID) ([]domain.NotificationRoute, error)
+	// UpdateNotificationRouteTarget replaces a route's sealed target
+	UpdateNotificationRouteTarget(ctx context.Context, tenantID, routeID uuid.UUID, target string) error
+	DeleteNotificationRoute(ctx context.Context, tenantID, routeID uuid.UUID) error
+
+	// Remediation operations (policies per tenant and risk level, audit log of actions)
+	UpsertRemediationPolicy(ctx context.Context, policy *domain.RemediationPolicy) error
+	ListRemediationPolicies(ctx context.Context, tenantID uuid.UUID) ([]domain.RemediationPolicy, error)
+	CreateRemediationAction(ctx context.Context, action *domain.RemediationAction) error
+	// GetRemediationAction returns an audit record with its restore state (nil if not found)
+	GetRemediationAction(ctx context.Context, tenantID, actionID uuid.UUID) (*domain.RemediationAction, error)
+	// ListRemediationActions returns the audit records of an email, oldest first
+	ListRemediationActions(ctx context.Context, tenantID, emailID uuid.UUID) ([]domain.RemediationAction, error)
+	// MarkRemediationRestored moves an applied action to restored; fails if it is not applied
+	MarkRemediationRestored(ctx context.Context, tenantID, actionID uuid.UUID, by string, at time.Time) error
+
+	// Mail subscription operations (provider push notifications, one subscription per user)
+	CreateMailSubscription(ctx context.Context, sub *domain.MailSubscription) error
+	UpdateMailSubscription(ctx context.Context, sub *domain.MailSubscription) error
+	// GetMailSubscription finds a subscription by its provider ID (nil if not found)
+	GetMailSubscription(ctx context.Context, provider domain.Provider, externalID string) (*domain.MailSubscription, error)
+	ListMailSubscriptions(ctx context.Context, tenantID uuid.UUID) ([]domain.MailSubscription, error)
+	// ListMailSubscriptionsDue returns, for all tenants, lapsed subscriptions and active ones expiring before a time
+	ListMailSubscriptionsDue(ctx context.Context, expiringBefore time.Time, limit int) ([]domain.MailSubscription, error)
+
+	// Tenant data key operations (envelope encryption of tenant secrets)
+	// GetTenantDataKey returns a tenant's wrapped data key (nil if none yet)
+	GetTenantDataKey(ctx context.Context, tenantID uuid.UUID) (*domain.DataKey, error)
+	// CreateTenantDataKey stores a tenant's data key unless it already has one
+	CreateTenantDataKey(ctx context.Context, key *domain.DataKey) error
+	// ListDataKeysToRewrap returns up to limit data keys not wrapped with a master key
+	ListDataKeysToRewrap(ctx context.Context, masterKeyID string, limit int) ([]domain.DataKey, error)
+	// RewrapTenantDataKey replaces a data key's wrapping if it is still wrapped with fromMasterKeyID
+	RewrapTenantDataKey(ctx context.Context, tenantID uuid.UUID, fromMasterKeyID string, key *domain.DataKey) error
 
 	// Lifecycle
 	Close() error